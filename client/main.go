@@ -9,15 +9,14 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	pb "grpc-sample-minimal/proto"
+	"grpc-sample-minimal/tlsconfig"
 )
 
 const (
 	address     = "server:50051"
 	defaultName = "world"
-	authToken = "my-secret-token"
 )
 
 // loggingClientInterceptor is a unary interceptor that logs RPC calls.
@@ -37,9 +36,22 @@ func loggingClientStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc,
 }
 
 func main() {
+	// AUTH_TOKEN is a JWT, e.g. from `server token -sub <name>`; automation
+	// clients may use an API key (API_KEY) from CreateAPIKey instead
+	authToken := os.Getenv("AUTH_TOKEN")
+	apiKey := os.Getenv("API_KEY")
+	if authToken == "" && apiKey == "" {
+		log.Fatalf("AUTH_TOKEN or API_KEY is not set (create a token with the server's token subcommand)")
+	}
+
+	creds, err := tlsconfig.DialOption("client")
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+
 	// Set up a connection to the server.
 	conn, err := grpc.Dial(address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		creds,
 		grpc.WithChainUnaryInterceptor(loggingClientInterceptor),
 		grpc.WithChainStreamInterceptor(loggingClientStreamInterceptor),
 	)
@@ -52,7 +64,11 @@ func main() {
 	// Add auth token to context
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authToken)
+	if authToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+authToken)
+	} else {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", apiKey)
+	}
 
 	// Contact the server and print out its response.
 	name := defaultName
@@ -3,9 +3,12 @@ package application
 import (
     "bytes"
     "context"
+    "errors"
     "fmt"
     "io"
     "log"
+    "path"
+    "strconv"
     "strings"
     "time"
 
@@ -20,6 +23,12 @@ type ApplicationService struct {
 	fileRepo       domain.FileMetadataRepository
 	ocrClient      domain.OCRClient // OCR??????????????????????
 	ocrResultRepo  domain.OCRResultRepository // OCR?????
+	apiKeyStore    domain.APIKeyStore
+	auditLog       domain.AuditLog
+	quotaPolicy    *domain.QuotaPolicy // nil when no quota is configured
+	contentPolicy  *domain.ContentPolicy // nil when content validation is off
+	contentScanner domain.ContentScanner // nil when uploads are not scanned
+	signedURLs     *domain.SignedURLPolicy // nil when signed URLs are off
 }
 
 func NewApplicationService(
@@ -28,6 +37,12 @@ func NewApplicationService(
 	fileRepo domain.FileMetadataRepository,
 	ocrClient domain.OCRClient,
 	ocrResultRepo domain.OCRResultRepository,
+	apiKeyStore domain.APIKeyStore,
+	auditLog domain.AuditLog,
+	quotaPolicy *domain.QuotaPolicy,
+	contentPolicy *domain.ContentPolicy,
+	contentScanner domain.ContentScanner,
+	signedURLs *domain.SignedURLPolicy,
 ) *ApplicationService {
 	return &ApplicationService{
 		greeterService: greeterService,
@@ -35,6 +50,12 @@ func NewApplicationService(
 		fileRepo:       fileRepo,
 		ocrClient:      ocrClient,
 		ocrResultRepo:  ocrResultRepo,
+		apiKeyStore:    apiKeyStore,
+		auditLog:       auditLog,
+		quotaPolicy:    quotaPolicy,
+		contentPolicy:  contentPolicy,
+		contentScanner: contentScanner,
+		signedURLs:     signedURLs,
 	}
 }
 
@@ -58,8 +79,18 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
 	var filename string
 	var fileContent bytes.Buffer
 	var bytesWritten int64
+	var quota *domain.UploadQuota
+	var stored bool
+	defer func() { quota.Release(stored) }()
 	chunkCount := 0
 
+	provider := "s3"
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if vals := md.Get("storage-provider"); len(vals) > 0 {
+			provider = vals[0]
+		}
+	}
+
 	for {
 		chunk, err := stream.Recv()
 		if err == io.EOF {
@@ -75,9 +106,18 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
 		if chunk.GetFilename() != "" && filename == "" {
 			filename = chunk.GetFilename()
 			log.Printf("Received filename in chunk %d: %s", chunkCount, filename)
+
+			// Check the tenant's quota before accepting any content
+			quota, err = s.quotaPolicy.StartUpload(stream.Context(), s.fileRepo, domain.ScopeFilename(stream.Context(), filename), provider)
+			if err != nil {
+				return err
+			}
 		}
 		
 		bytesWritten += int64(len(chunk.GetContent()))
+		if err := quota.Check(bytesWritten); err != nil {
+			return err
+		}
 		fileContent.Write(chunk.GetContent())
 	}
 
@@ -111,27 +151,89 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
     	return fmt.Errorf("file content is empty")
     }
 
-    status, err := storage.UploadFile(stream.Context(), filename, &fileContent)
+	// Files of tenant callers are stored under their tenant's key
+	open := func() (io.Reader, error) { return bytes.NewReader(fileContent.Bytes()), nil }
+	status, err := s.storeUpload(stream.Context(), storage, provider, domain.ScopeFilename(stream.Context(), filename), filename, open, bytesWritten)
 	if err != nil {
 		return err
 	}
-	status.BytesWritten = bytesWritten
-	
-	// Ensure status has the correct filename
-	if status.Filename == "" {
-		status.Filename = filename
-	}
+	stored = true
+	return stream.SendAndClose(status)
+}
 
-	// Save file metadata to database
+// storeUpload validates, scans and stores the content of an upload, records
+// its metadata and queues it for OCR. filename is the file key, displayName
+// the name the caller knows the file by. open returns the content from its
+// start; the checks, the scan and the upload each read it once, so it is
+// streamed rather than held in memory.
+func (s *ApplicationService) storeUpload(ctx context.Context, storage domain.StorageService, provider string, filename string, displayName string, open func() (io.Reader, error), bytesWritten int64) (*proto.FileUploadStatus, error) {
+	// Check the content against its extension and the allow/deny lists
+	content, err := open()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, domain.ContentSniffLength)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	verdict, err := s.contentPolicy.Check(displayName, head[:n])
+	if err != nil {
+		log.Printf("Upload of %s rejected: %v", filename, err)
+		return nil, err
+	}
+	namespace := domain.GetFileNamespace(displayName)
 	storagePath := domain.BuildStoragePath(filename)
-	namespace := domain.GetFileNamespace(filename)
-	provider := "s3"
-	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
-		if vals := md.Get("storage-provider"); len(vals) > 0 {
-			provider = vals[0]
+	if verdict.Namespace != namespace {
+		namespace = verdict.Namespace
+		storagePath = domain.TenantStoragePath(filename, namespace)
+		log.Printf("Upload of %s holds %s content; storing it under %s", filename, verdict.ContentType, namespace)
+	}
+
+	// Flagged files are kept in quarantine, away from downloads and OCR
+	var signature string
+	if s.contentScanner != nil {
+		content, err := open()
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.contentScanner.Scan(ctx, filename, content)
+		if err != nil {
+			log.Printf("Upload of %s rejected: %v", filename, err)
+			return nil, err
+		}
+		if result.Infected {
+			signature = result.Signature
+			namespace = domain.QuarantineNamespace
+			storagePath = domain.QuarantinePath(filename)
+			log.Printf("Upload of %s quarantined: %s", filename, signature)
 		}
 	}
-	
+
+	var status *proto.FileUploadStatus
+	content, err = open()
+	if err != nil {
+		return nil, err
+	}
+	if storagePath == domain.BuildStoragePath(filename) {
+		status, err = storage.UploadFile(ctx, filename, content)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := storage.UploadFileByPath(ctx, storagePath, content); err != nil {
+			return nil, err
+		}
+		status = &proto.FileUploadStatus{Success: true, Message: fmt.Sprintf("File %s uploaded to %s", displayName, storagePath)}
+		if signature != "" {
+			status.Message = fmt.Sprintf("File %s was quarantined: %s", displayName, signature)
+		}
+	}
+	status.BytesWritten = bytesWritten
+	status.Filename = displayName
+	status.Namespace = strings.TrimSuffix(namespace, "/")
+
+	// Save file metadata to database
 	fileMetadata := &domain.FileMetadata{
 		Filename:        filename,
 		Namespace:       strings.TrimSuffix(namespace, "/"),
@@ -139,12 +241,16 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
 		StorageProvider: provider,
 		StoragePath:     storagePath,
 		UploadedAt:      time.Now(),
+		Tenant:          domain.TenantOfFilename(filename),
+	}
+	if principal := domain.PrincipalFromContext(ctx); principal != nil {
+		fileMetadata.Owner = principal.Subject
 	}
 	
 	log.Printf("Saving file metadata: filename=%s, namespace=%s, size=%d, provider=%s", 
 		fileMetadata.Filename, fileMetadata.Namespace, fileMetadata.Size, fileMetadata.StorageProvider)
 	
-	if err := s.fileRepo.Create(stream.Context(), fileMetadata); err != nil {
+	if err := s.fileRepo.Create(ctx, fileMetadata); err != nil {
 		log.Printf("Warning: Failed to save file metadata to database: %v", err)
 		// Continue even if DB save fails
 	} else {
@@ -154,7 +260,10 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
 	// documents/???images/?????????OCR?????????
 	// namespace?"documents/"???"images/"????"/"????
 	log.Printf("Debug: Checking OCR queue condition - namespace=%s, ocrClient=%v", namespace, s.ocrClient != nil)
-	if (namespace == "documents/" || namespace == "images/") && s.ocrClient != nil {
+	// Files not matching their extension and quarantined files skip OCR
+	if verdict.Mismatch || signature != "" {
+		log.Printf("Debug: OCR task not enqueued - %s is stored under %s", filename, namespace)
+	} else if (namespace == "documents/" || namespace == "images/") && s.ocrClient != nil {
 		// ????????OCR??????????????????????????
 		queueManager := domain.GetQueueManager()
 		go func() {
@@ -174,7 +283,7 @@ func (s *ApplicationService) UploadFile(stream proto.Greeter_UploadFileServer) e
 		}
 	}
 
-	return stream.SendAndClose(status)
+	return status, nil
 }
 
 func (s *ApplicationService) DownloadFile(req *proto.FileDownloadRequest, stream proto.Greeter_DownloadFileServer) error {
@@ -191,7 +300,36 @@ func (s *ApplicationService) DownloadFile(req *proto.FileDownloadRequest, stream
         }
     }
 
-    reader, err := storage.DownloadFile(stream.Context(), req.GetFilename())
+    filename := domain.ScopeFilename(stream.Context(), req.GetFilename())
+    var reader io.Reader
+    var err error
+    switch req.GetVariant() {
+    case "":
+        // Re-namespaced files live at the storage path of their metadata
+        if provider == "" {
+            provider = "s3"
+        }
+        file, findErr := s.fileRepo.FindByFilename(stream.Context(), filename, provider)
+        switch {
+        case findErr == nil && file != nil && file.Namespace+"/" == domain.QuarantineNamespace:
+            return fmt.Errorf("%w: %s", domain.ErrFileQuarantined, req.GetFilename())
+        case findErr == nil && file != nil && file.StoragePath != "" && file.StoragePath != domain.BuildStoragePath(filename):
+            reader, err = storage.DownloadFileByPath(stream.Context(), file.StoragePath)
+        default:
+            reader, err = storage.DownloadFile(stream.Context(), filename)
+        }
+    case domain.DownloadVariantSearchable:
+        // Derived files live at exact paths outside the upload namespaces
+        engineName := req.GetEngineName()
+        if engineName == "" {
+            engineName = "tesseract"
+        }
+        reader, err = storage.DownloadFileByPath(stream.Context(), domain.SearchablePDFPath(filename, engineName))
+    case domain.DownloadVariantRedacted:
+        reader, err = storage.DownloadFileByPath(stream.Context(), domain.RedactedPath(filename))
+    default:
+        return fmt.Errorf("unsupported download variant: %s", req.GetVariant())
+    }
 	if err != nil {
 		return err
 	}
@@ -221,8 +359,13 @@ func (s *ApplicationService) ListFiles(ctx context.Context, req *proto.FileListR
 
 	// Get files from database instead of directly from storage
 	// This is more reliable and faster
-	files, err := s.fileRepo.ListByProvider(ctx, provider)
+	tenant := domain.CallerTenant(ctx)
+	files, err := s.fileRepo.ListByProvider(ctx, provider, tenant)
 	if err != nil {
+		// A storage listing cannot be scoped to a tenant
+		if tenant != "" {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
 		log.Printf("Error listing files from database: %v, falling back to storage", err)
 		
 		// Fallback to storage listing if DB fails
@@ -244,6 +387,9 @@ func (s *ApplicationService) ListFiles(ctx context.Context, req *proto.FileListR
 		}
 		files = storageFiles
 	}
+	for _, f := range files {
+		f.Filename = domain.DisplayFilename(ctx, f.Filename)
+	}
 	
 	return &proto.FileListResponse{
 		Files: files,
@@ -262,7 +408,29 @@ func (s *ApplicationService) ProcessOCR(ctx context.Context, req *proto.OCRReque
 	
 	// OCR??????????????????????
 	// ?????????????OCR??????????????
-	_, err := s.ocrClient.ProcessDocument(ctx, req.Filename, nil, req.StorageProvider)
+	// Explicit languages override per-document detection in the OCR service
+	languages, err := domain.ParseOCRLanguages(req.Languages)
+	if err != nil {
+		return &proto.OCRResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+	ctx = domain.WithOCRLanguages(ctx, languages)
+	
+	// Quarantined files never reach the OCR engines
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	if file, err := s.fileRepo.FindByFilename(ctx, domain.ScopeFilename(ctx, req.Filename), provider); err == nil && file != nil && file.Namespace+"/" == domain.QuarantineNamespace {
+		return &proto.OCRResponse{
+			Success: false,
+			Message: fmt.Sprintf("%v: %s", domain.ErrFileQuarantined, req.Filename),
+		}, nil
+	}
+	
+	_, err = s.ocrClient.ProcessDocument(ctx, domain.ScopeFilename(ctx, req.Filename), nil, req.StorageProvider)
 	if err != nil {
 		return &proto.OCRResponse{
 			TaskId:  "",
@@ -292,7 +460,7 @@ func (s *ApplicationService) GetOCRResult(ctx context.Context, req *proto.OCRRes
 		engineName = "tesseract" // ?????
 	}
 	
-	result, err := s.ocrResultRepo.GetOCRResult(ctx, req.Filename, req.StorageProvider, engineName)
+	result, err := s.ocrResultRepo.GetOCRResult(ctx, domain.ScopeFilename(ctx, req.Filename), req.StorageProvider, engineName)
 	// ?????????????OCR??????????????????
 	if err != nil {
 		return nil, fmt.Errorf("failed to get OCR result from repository: %w", err)
@@ -313,6 +481,10 @@ func (s *ApplicationService) GetOCRResult(ctx context.Context, req *proto.OCRRes
 			PageNumber: int32(page.PageNumber),
 			Text:       page.Text,
 			Confidence: page.Confidence,
+			PreprocessSteps: page.PreprocessSteps,
+			EngineAgreement: domain.EngineAgreementToProto(page.EngineAgreement),
+			EngineName: page.EngineName,
+			Classification: page.Classification,
 		}
 	}
 	
@@ -322,7 +494,7 @@ func (s *ApplicationService) GetOCRResult(ctx context.Context, req *proto.OCRRes
 	}
 	
 	return &proto.OCRResultResponse{
-		Filename:     result.Filename,
+		Filename:     domain.DisplayFilename(ctx, result.Filename),
 		EngineName:   result.EngineName,
 		ExtractedText: result.ExtractedText,
 		Pages:        pages,
@@ -330,6 +502,12 @@ func (s *ApplicationService) GetOCRResult(ctx context.Context, req *proto.OCRRes
 		ErrorMessage: errorMsg,
 		Confidence:   result.Confidence,
 		ProcessedAt:  result.ProcessedAt.Unix(),
+		PreprocessProfile: result.PreprocessProfile,
+		DetectedLanguage: result.DetectedLanguage,
+		Languages: result.Languages,
+		EngineAgreement: domain.EngineAgreementToProto(result.EngineAgreement),
+		EngineVersion: result.EngineVersion,
+		Barcodes: domain.BarcodesToProto(result.Barcodes),
 	}, nil
 }
 
@@ -344,14 +522,17 @@ func (s *ApplicationService) ListOCRResults(ctx context.Context, req *proto.OCRL
 		return nil, fmt.Errorf("failed to list OCR results: %w", err)
 	}
 	
-	summaries := make([]*proto.OCRResultSummary, len(results))
-	for i, result := range results {
-		summaries[i] = &proto.OCRResultSummary{
-			Filename:    result.Filename,
+	summaries := make([]*proto.OCRResultSummary, 0, len(results))
+	for _, result := range results {
+		if !domain.VisibleToCaller(ctx, result.Filename) {
+			continue
+		}
+		summaries = append(summaries, &proto.OCRResultSummary{
+			Filename:    domain.DisplayFilename(ctx, result.Filename),
 			EngineName:  result.EngineName,
 			Status:      result.Status,
 			ProcessedAt: result.ProcessedAt.Unix(),
-		}
+		})
 	}
 	
 	return &proto.OCRListResponse{
@@ -365,7 +546,7 @@ func (s *ApplicationService) CompareOCRResults(ctx context.Context, req *proto.O
 		return nil, fmt.Errorf("OCR result repository is not available")
 	}
 	
-	results, err := s.ocrResultRepo.GetOCRComparison(ctx, req.Filename, req.StorageProvider)
+	results, err := s.ocrResultRepo.GetOCRComparison(ctx, domain.ScopeFilename(ctx, req.Filename), req.StorageProvider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get OCR comparison: %w", err)
 	}
@@ -378,6 +559,10 @@ func (s *ApplicationService) CompareOCRResults(ctx context.Context, req *proto.O
 				PageNumber: int32(page.PageNumber),
 				Text:       page.Text,
 				Confidence: page.Confidence,
+				PreprocessSteps: page.PreprocessSteps,
+				EngineAgreement: domain.EngineAgreementToProto(page.EngineAgreement),
+				EngineName: page.EngineName,
+				Classification: page.Classification,
 			}
 		}
 		
@@ -387,7 +572,7 @@ func (s *ApplicationService) CompareOCRResults(ctx context.Context, req *proto.O
 		}
 		
 		pbResults[i] = &proto.OCRResultResponse{
-			Filename:     result.Filename,
+			Filename:     domain.DisplayFilename(ctx, result.Filename),
 			EngineName:   result.EngineName,
 			ExtractedText: result.ExtractedText,
 			Pages:        pages,
@@ -395,6 +580,12 @@ func (s *ApplicationService) CompareOCRResults(ctx context.Context, req *proto.O
 			ErrorMessage: errorMsg,
 			Confidence:   result.Confidence,
 			ProcessedAt:  result.ProcessedAt.Unix(),
+			PreprocessProfile: result.PreprocessProfile,
+			DetectedLanguage: result.DetectedLanguage,
+			Languages: result.Languages,
+			EngineAgreement: domain.EngineAgreementToProto(result.EngineAgreement),
+			EngineVersion: result.EngineVersion,
+			Barcodes: domain.BarcodesToProto(result.Barcodes),
 		}
 	}
 	
@@ -405,8 +596,439 @@ func (s *ApplicationService) CompareOCRResults(ctx context.Context, req *proto.O
 	}, nil
 }
 
+// GetOCRLayout returns the word-level layout of an OCR result in the requested format.
+func (s *ApplicationService) GetOCRLayout(ctx context.Context, req *proto.OCRLayoutRequest) (*proto.OCRLayoutResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract" // ?????
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = domain.LayoutFormatJSON
+	}
+	
+	layout, err := s.ocrResultRepo.GetOCRLayout(ctx, domain.ScopeFilename(ctx, req.Filename), req.StorageProvider, engineName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OCR layout from repository: %w", err)
+	}
+	if layout == nil {
+		return &proto.OCRLayoutResponse{
+			Filename:   req.Filename,
+			EngineName: engineName,
+			Format:     format,
+			Status:     "not_found",
+		}, nil
+	}
+	
+	content, contentType, err := domain.ExportOCRLayout(layout, format)
+	if err != nil {
+		return nil, err
+	}
+	
+	return &proto.OCRLayoutResponse{
+		Filename:    domain.DisplayFilename(ctx, layout.Filename),
+		EngineName:  layout.EngineName,
+		Format:      format,
+		ContentType: contentType,
+		Content:     content,
+		PageCount:   int32(len(layout.Pages)),
+		Status:      "completed",
+	}, nil
+}
+
+// GetExtractedTables returns the tables found in an OCR result's pages.
+func (s *ApplicationService) GetExtractedTables(ctx context.Context, req *proto.ExtractedTablesRequest) (*proto.ExtractedTablesResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract"
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = domain.TableFormatJSON
+	}
+	
+	tables, err := s.ocrResultRepo.GetExtractedTables(ctx, domain.ScopeFilename(ctx, req.Filename), req.StorageProvider, engineName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extracted tables from repository: %w", err)
+	}
+	if tables == nil {
+		return &proto.ExtractedTablesResponse{
+			Filename:   req.Filename,
+			EngineName: engineName,
+			Format:     format,
+			Status:     "not_found",
+		}, nil
+	}
+	
+	tables = domain.FilterTablesByPage(tables, int(req.PageNumber))
+	content, contentType, err := domain.ExportExtractedTables(tables, format)
+	if err != nil {
+		return nil, err
+	}
+	
+	return &proto.ExtractedTablesResponse{
+		Filename:    req.Filename,
+		EngineName:  engineName,
+		Tables:      domain.ExtractedTablesToProto(tables),
+		Format:      format,
+		ContentType: contentType,
+		Content:     content,
+		Status:      "completed",
+	}, nil
+}
+
+// ExportSearchablePDF asks the OCR service to build (or reuse) the searchable PDF
+// of a file; the PDF itself is downloaded through DownloadFile with the
+// "searchable" variant.
+func (s *ApplicationService) ExportSearchablePDF(ctx context.Context, req *proto.SearchablePDFRequest) (*proto.SearchablePDFResponse, error) {
+	if s.ocrClient == nil {
+		return nil, fmt.Errorf("OCR client is not available")
+	}
+	
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract"
+	}
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	
+	file, cached, err := s.ocrClient.ExportSearchablePDF(ctx, domain.ScopeFilename(ctx, req.Filename), provider, engineName, req.Force)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return &proto.SearchablePDFResponse{
+			Filename:        req.Filename,
+			StorageProvider: provider,
+			EngineName:      engineName,
+			Status:          "not_found",
+		}, nil
+	}
+	
+	filename := domain.DisplayFilename(ctx, file.Filename)
+	return &proto.SearchablePDFResponse{
+		Filename:         filename,
+		StorageProvider:  file.StorageProvider,
+		EngineName:       file.EngineName,
+		StoragePath:      file.StoragePath,
+		DownloadFilename: domain.SearchablePDFFilename(filename),
+		PageCount:        int32(file.PageCount),
+		Size:             file.Size,
+		Cached:           cached,
+		Status:           "completed",
+		GeneratedAt:      file.CreatedAt.Unix(),
+	}, nil
+}
+
+// SetGroundTruth registers the reference text of a file for EvaluateOCR.
+func (s *ApplicationService) SetGroundTruth(ctx context.Context, req *proto.GroundTruthRequest) (*proto.GroundTruthResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	if req.Filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	if req.StorageProvider == "" {
+		req.StorageProvider = "s3"
+	}
+	req.Filename = domain.ScopeFilename(ctx, req.Filename)
+	
+	gt := domain.GroundTruthFromProto(req)
+	if err := s.ocrResultRepo.SaveGroundTruth(ctx, gt); err != nil {
+		return nil, err
+	}
+	message := "ground truth saved for the whole document"
+	if len(gt.Pages) > 0 {
+		message = fmt.Sprintf("ground truth saved for %d pages", len(gt.Pages))
+	}
+	return &proto.GroundTruthResponse{Success: true, Message: message}, nil
+}
+
+// EvaluateOCR computes CER/WER of the stored OCR results against ground truth.
+func (s *ApplicationService) EvaluateOCR(ctx context.Context, req *proto.EvaluateOCRRequest) (*proto.EvaluateOCRResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	return domain.EvaluateOCRFromProto(ctx, s.ocrResultRepo, req)
+}
+
+// GetExtractedFields returns the key-value fields read from a file by its
+// document type's template.
+func (s *ApplicationService) GetExtractedFields(ctx context.Context, req *proto.ExtractedFieldsRequest) (*proto.ExtractedFieldsResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	
+	fields, err := s.ocrResultRepo.GetExtractedFields(ctx, domain.ScopeFilename(ctx, req.Filename), provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extracted fields from repository: %w", err)
+	}
+	if fields == nil {
+		return &proto.ExtractedFieldsResponse{Status: "not_found"}, nil
+	}
+	out := domain.ExtractedFieldsToProto(fields)
+	out.Filename = domain.DisplayFilename(ctx, out.Filename)
+	return &proto.ExtractedFieldsResponse{
+		Fields: out,
+		Status: "completed",
+	}, nil
+}
+
+// SearchDocuments returns the files whose extracted fields match the filters,
+// with value counts of the requested facet fields.
+func (s *ApplicationService) SearchDocuments(ctx context.Context, req *proto.SearchDocumentsRequest) (*proto.SearchDocumentsResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	query := domain.DocumentSearchQueryFromProto(req)
+	query.Tenant = domain.CallerTenant(ctx)
+	// Totals and facets only count files in the caller's namespaces
+	if namespaces, scoped := domain.NamespaceScope(ctx); scoped {
+		if len(namespaces) == 0 {
+			return &proto.SearchDocumentsResponse{}, nil
+		}
+		query.Namespaces = namespaces
+	}
+	result, err := s.ocrResultRepo.SearchExtractedFields(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	resp := domain.DocumentSearchResultToProto(result)
+	for _, doc := range resp.Documents {
+		doc.Filename = domain.DisplayFilename(ctx, doc.Filename)
+	}
+	return resp, nil
+}
+
+// GetPIIFindings returns the personal information found in a file's OCR text
+// and where its redacted copy is.
+func (s *ApplicationService) GetPIIFindings(ctx context.Context, req *proto.PIIFindingsRequest) (*proto.PIIFindingsResponse, error) {
+	if s.ocrResultRepo == nil {
+		return nil, fmt.Errorf("OCR result repository is not available")
+	}
+	if req.Filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	
+	filename := domain.ScopeFilename(ctx, req.Filename)
+	report, err := s.ocrResultRepo.GetPIIReport(ctx, filename, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PII findings from repository: %w", err)
+	}
+	if report == nil {
+		return &proto.PIIFindingsResponse{Filename: req.Filename, StorageProvider: provider, Status: "not_found"}, nil
+	}
+	redacted, err := s.ocrResultRepo.GetDerivedFile(ctx, filename, provider, domain.RedactedEngineName, domain.DerivedKindRedacted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get redacted copy from repository: %w", err)
+	}
+	resp := domain.PIIReportToProto(report, redacted)
+	resp.Filename = req.Filename
+	return resp, nil
+}
+
+// CreateAPIKey issues an API key for the caller. The key acts as the caller,
+// with the caller's tenant and roles, limited to the requested scopes, for at
+// most API_KEY_MAX_TTL. Keys cannot create keys with scopes they do not have
+// themselves.
+func (s *ApplicationService) CreateAPIKey(ctx context.Context, req *proto.CreateAPIKeyRequest) (*proto.CreateAPIKeyResponse, error) {
+	if s.apiKeyStore == nil {
+		return nil, fmt.Errorf("API key store is not available")
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, fmt.Errorf("%w: no caller", domain.ErrPermissionDenied)
+	}
+	scopes, err := domain.NormalizeAPIKeyScopes(req.GetScopes())
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !principal.HasScope(scope) {
+			return nil, fmt.Errorf("%w: API key %s may not grant scope %s", domain.ErrPermissionDenied, principal.APIKeyID, scope)
+		}
+	}
+	ttl, err := domain.APIKeyTTL(req.GetExpiresInSeconds())
+	if err != nil {
+		return nil, err
+	}
+	// A key cannot outlive the key that created it
+	now := time.Now()
+	if principal.APIKeyID != "" && now.Add(ttl).After(principal.ExpiresAt) {
+		ttl = principal.ExpiresAt.Sub(now)
+	}
+
+	key, secret, err := domain.NewAPIKey(principal, strings.TrimSpace(req.GetName()), scopes, ttl, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if err := s.apiKeyStore.CreateAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+	log.Printf("API key %s created: owner=%s tenant=%s scopes=%v expires=%s", key.ID, key.Owner, key.Tenant, key.Scopes, key.ExpiresAt.Format(time.RFC3339))
+	return &proto.CreateAPIKeyResponse{ApiKey: secret, Key: domain.APIKeyToProto(key)}, nil
+}
+
+// ListAPIKeys returns the caller's API keys; admins see every key of their
+// tenant.
+func (s *ApplicationService) ListAPIKeys(ctx context.Context, req *proto.ListAPIKeysRequest) (*proto.ListAPIKeysResponse, error) {
+	if s.apiKeyStore == nil {
+		return nil, fmt.Errorf("API key store is not available")
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, fmt.Errorf("%w: no caller", domain.ErrPermissionDenied)
+	}
+	filter := domain.APIKeyFilter{Tenant: principal.Tenant, Owner: principal.Subject, IncludeRevoked: req.GetIncludeRevoked()}
+	if principal.HasRole("admin") && principal.HasScope(domain.APIKeyScopeAdmin) {
+		filter.Owner = ""
+		filter.AllTenants = principal.Tenant == ""
+	}
+	keys, err := s.apiKeyStore.ListAPIKeys(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.ListAPIKeysResponse{}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, domain.APIKeyToProto(key))
+	}
+	return resp, nil
+}
+
+// RevokeAPIKey revokes one of the caller's API keys (any key of the tenant
+// for admins). Revoked keys are rejected from the next call on.
+func (s *ApplicationService) RevokeAPIKey(ctx context.Context, req *proto.RevokeAPIKeyRequest) (*proto.RevokeAPIKeyResponse, error) {
+	if s.apiKeyStore == nil {
+		return nil, fmt.Errorf("API key store is not available")
+	}
+	if req.GetId() == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	key, err := s.apiKeyStore.GetAPIKey(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	if key == nil || !domain.CanManageAPIKey(principal, key) {
+		return nil, fmt.Errorf("%w: %s", domain.ErrAPIKeyNotFound, req.GetId())
+	}
+	if !key.RevokedAt.IsZero() {
+		return &proto.RevokeAPIKeyResponse{Success: true, Message: "API key was already revoked"}, nil
+	}
+	if err := s.apiKeyStore.RevokeAPIKey(ctx, key.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	log.Printf("API key %s revoked by %s", key.ID, principal.Subject)
+	return &proto.RevokeAPIKeyResponse{Success: true, Message: "API key revoked"}, nil
+}
+
+// QueryAuditLog returns audit events, newest first, a page at a time. Tenant
+// callers only see their tenant; callers without the admin or auditor role
+// only see their own events.
+func (s *ApplicationService) QueryAuditLog(ctx context.Context, req *proto.AuditLogQuery) (*proto.AuditLogResponse, error) {
+	if s.auditLog == nil {
+		return nil, fmt.Errorf("audit log is not available")
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, fmt.Errorf("%w: no caller", domain.ErrPermissionDenied)
+	}
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	if pageSize > 1000 {
+		pageSize = 1000
+	}
+	query := domain.AuditQuery{
+		Subject:  req.GetSubject(),
+		Tenant:   req.GetTenant(),
+		RPC:      req.GetRpc(),
+		Filename: req.GetFilename(),
+		Provider: req.GetStorageProvider(),
+		Outcome:  req.GetOutcome(),
+		Limit:    pageSize + 1,
+	}
+	if req.GetSince() > 0 {
+		query.Since = time.Unix(req.GetSince(), 0)
+	}
+	if req.GetUntil() > 0 {
+		query.Until = time.Unix(req.GetUntil(), 0)
+	}
+	if req.GetPageToken() != "" {
+		beforeID, err := strconv.ParseInt(req.GetPageToken(), 10, 64)
+		if err != nil || beforeID <= 0 {
+			return nil, fmt.Errorf("invalid page_token %q", req.GetPageToken())
+		}
+		query.BeforeID = beforeID
+	}
+	if principal.Tenant != "" {
+		query.Tenant = principal.Tenant
+	}
+	if !principal.HasRole("admin") && !principal.HasRole("auditor") {
+		query.Subject = principal.Subject
+	}
+
+	events, err := s.auditLog.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.AuditLogResponse{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		resp.NextPageToken = strconv.FormatInt(events[pageSize-1].ID, 10)
+	}
+	for _, e := range events {
+		resp.Events = append(resp.Events, domain.AuditEventToProto(e))
+	}
+	return resp, nil
+}
+
+// GetQuota returns the storage quota and usage of the caller's tenant. Only
+// unscoped callers may ask for another tenant.
+func (s *ApplicationService) GetQuota(ctx context.Context, req *proto.GetQuotaRequest) (*proto.GetQuotaResponse, error) {
+	tenant := domain.CallerTenant(ctx)
+	if req.GetTenant() != "" && req.GetTenant() != tenant {
+		if !domain.CallerUnscoped(ctx) {
+			return nil, fmt.Errorf("%w: quota of tenant %q", domain.ErrPermissionDenied, req.GetTenant())
+		}
+		tenant = req.GetTenant()
+	}
+	usage, err := s.fileRepo.TenantUsage(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	quota := s.quotaPolicy.For(tenant)
+	return &proto.GetQuotaResponse{
+		Tenant:      tenant,
+		MaxBytes:    quota.MaxBytes,
+		MaxFiles:    quota.MaxFiles,
+		MaxFileSize: quota.MaxFileSize,
+		UsedBytes:   usage.Bytes,
+		FileCount:   usage.Files,
+	}, nil
+}
+
 func (s *ApplicationService) DeleteFile(ctx context.Context, req *proto.DeleteFileRequest) (*proto.DeleteFileResponse, error) {
-	filename := req.GetFilename()
+	filename := domain.ScopeFilename(ctx, req.GetFilename())
 	provider := req.GetStorageProvider()
 	if provider == "" {
 		provider = "s3"
@@ -425,8 +1047,13 @@ func (s *ApplicationService) DeleteFile(ctx context.Context, req *proto.DeleteFi
 		}
 	}
 
-	// Delete from storage
-	if err := storage.DeleteFile(ctx, filename); err != nil {
+	// Delete from storage, at the path of the metadata for re-namespaced and
+	// quarantined files
+	storagePath := domain.BuildStoragePath(filename)
+	if file, err := s.fileRepo.FindByFilename(ctx, filename, provider); err == nil && file != nil && file.StoragePath != "" {
+		storagePath = file.StoragePath
+	}
+	if err := storage.DeleteFileByPath(ctx, storagePath); err != nil {
 		log.Printf("Error deleting file from storage: %v", err)
 		return &proto.DeleteFileResponse{
 			Success: false,
@@ -442,6 +1069,247 @@ func (s *ApplicationService) DeleteFile(ctx context.Context, req *proto.DeleteFi
 
 	return &proto.DeleteFileResponse{
 		Success: true,
-		Message: fmt.Sprintf("File %s deleted successfully from %s", filename, provider),
+		Message: fmt.Sprintf("File %s deleted successfully from %s", req.GetFilename(), provider),
+	}, nil
+}
+
+// storageFor returns the storage of provider; the default storage for "s3"
+// and when the provider is unavailable.
+func (s *ApplicationService) storageFor(ctx context.Context, provider string) domain.StorageService {
+	switch provider {
+	case "gcs":
+		if gcs, err := domain.NewGCSStorageService(ctx); err == nil {
+			return gcs
+		}
+	case "azure":
+		if azure, err := domain.NewAzureStorageService(ctx); err == nil {
+			return azure
+		}
+	}
+	return s.storageService
+}
+
+// CreateUploadURL returns a signed URL the client uploads a file to directly.
+// The object is staged until FinalizeUpload validates it and moves it into
+// place; the extension and quota are checked up front.
+func (s *ApplicationService) CreateUploadURL(ctx context.Context, req *proto.CreateUploadURLRequest) (*proto.SignedURLResponse, error) {
+	if s.signedURLs == nil {
+		return nil, fmt.Errorf("%w: SIGNED_URLS is off", domain.ErrSignedURLUnavailable)
+	}
+	if req.GetFilename() == "" {
+		return nil, fmt.Errorf("filename is required but was not provided")
+	}
+	provider := req.GetStorageProvider()
+	if provider == "" {
+		provider = "s3"
+	}
+	// The size bounds what the URL accepts, so it must be declared
+	if req.GetSize() <= 0 {
+		return nil, fmt.Errorf("%w: direct uploads must declare their size", domain.ErrContentRejected)
+	}
+	if err := s.contentPolicy.CheckExtension(req.GetFilename()); err != nil {
+		return nil, err
+	}
+	filename := domain.ScopeFilename(ctx, req.GetFilename())
+	// The quota is only checked here; FinalizeUpload reserves it
+	quota, err := s.quotaPolicy.StartUpload(ctx, s.fileRepo, filename, provider)
+	if err != nil {
+		return nil, err
+	}
+	err = quota.Check(req.GetSize())
+	quota.Release(false)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := s.signedURLs.Signer(s.storageFor(ctx, provider), provider)
+	if err != nil {
+		return nil, err
+	}
+	ttl := s.signedURLs.TTL(req.GetExpiresInSeconds())
+	var owner string
+	if principal := domain.PrincipalFromContext(ctx); principal != nil {
+		owner = principal.Subject
+	}
+	grant, err := domain.NewUploadGrant(filename, provider, owner, req.GetSize(), time.Now().Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signer.SignedUploadURL(ctx, grant.StagingPath, req.GetContentType(), req.GetSize(), ttl)
+	if err != nil {
+		return nil, err
+	}
+	// Objects that are never finalized are deleted by SweepStagedUploads
+	staged := &domain.StagedUpload{ID: grant.ID, Provider: provider, StoragePath: grant.StagingPath, ExpiresAt: time.Unix(grant.ExpiresAt, 0)}
+	if err := s.fileRepo.SaveStagedUpload(ctx, staged); err != nil {
+		return nil, err
+	}
+	log.Printf("Upload URL for %s (%s, upload %s, direct=%v) expires at %s", filename, provider, grant.ID, signed.Direct, signed.ExpiresAt.Format(time.RFC3339))
+	return &proto.SignedURLResponse{
+		Url:       signed.URL,
+		Method:    signed.Method,
+		Headers:   signed.Headers,
+		ExpiresAt: signed.ExpiresAt.Unix(),
+		UploadId:  s.signedURLs.SignUpload(grant),
+		Direct:    signed.Direct,
+	}, nil
+}
+
+// FinalizeUpload completes a direct upload: the staged object goes through
+// the checks of UploadFile, is stored at its path, recorded in file_metadata
+// and queued for OCR. Rejected objects are deleted; after a failed scan the
+// object stays staged, so the call can be retried.
+func (s *ApplicationService) FinalizeUpload(ctx context.Context, req *proto.FinalizeUploadRequest) (*proto.FileUploadStatus, error) {
+	if s.signedURLs == nil {
+		return nil, fmt.Errorf("%w: SIGNED_URLS is off", domain.ErrSignedURLUnavailable)
+	}
+	grant, err := s.signedURLs.VerifyUpload(req.GetUploadId())
+	if err != nil {
+		return nil, err
+	}
+	provider := req.GetStorageProvider()
+	if provider == "" {
+		provider = "s3"
+	}
+	// The upload ID names its file, which must be the caller's
+	if grant.Filename != domain.ScopeFilename(ctx, req.GetFilename()) || grant.Provider != provider {
+		return nil, fmt.Errorf("%w: upload %s is not for %s on %s", domain.ErrPermissionDenied, grant.ID, req.GetFilename(), provider)
+	}
+	if principal := domain.PrincipalFromContext(ctx); grant.Owner != "" && (principal == nil || principal.Subject != grant.Owner) {
+		return nil, fmt.Errorf("%w: upload %s belongs to another user", domain.ErrPermissionDenied, grant.ID)
+	}
+
+	storage := s.storageFor(ctx, provider)
+	quota, err := s.quotaPolicy.StartUpload(ctx, s.fileRepo, grant.Filename, provider)
+	if err != nil {
+		return nil, err
+	}
+	var stored bool
+	defer func() { quota.Release(stored) }()
+	if err := quota.Check(grant.Size); err != nil {
+		s.discardStagedUpload(ctx, storage, grant)
+		return nil, err
+	}
+
+	// The staged object is streamed for every check; reading it fails
+	// unless it holds exactly the declared size
+	open := func() (io.Reader, error) {
+		reader, err := storage.DownloadFileByPath(ctx, grant.StagingPath)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s has no uploaded object: %w", grant.ID, err)
+		}
+		return domain.NewDeclaredSizeReader(reader, grant.Size), nil
+	}
+	content, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		if errors.Is(err, domain.ErrContentRejected) {
+			s.discardStagedUpload(ctx, storage, grant)
+		}
+		return nil, err
+	}
+
+	status, err := s.storeUpload(ctx, storage, provider, grant.Filename, domain.DisplayFilename(ctx, grant.Filename), open, grant.Size)
+	if err != nil {
+		if errors.Is(err, domain.ErrContentRejected) {
+			s.discardStagedUpload(ctx, storage, grant)
+		}
+		return nil, err
+	}
+	stored = true
+	s.discardStagedUpload(ctx, storage, grant)
+	status.StorageProvider = provider
+	return status, nil
+}
+
+// discardStagedUpload deletes the staged object of a direct upload.
+func (s *ApplicationService) discardStagedUpload(ctx context.Context, storage domain.StorageService, grant *domain.UploadGrant) {
+	if err := storage.DeleteFileByPath(ctx, grant.StagingPath); err != nil {
+		log.Printf("Warning: Failed to delete staged upload %s: %v", grant.StagingPath, err)
+		return
+	}
+	if err := s.fileRepo.DeleteStagedUpload(ctx, grant.ID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// SweepStagedUploads deletes the staged objects of direct uploads whose
+// grants expired before now without being finalized, and returns how many
+// it deleted. Objects that cannot be deleted are retried on the next sweep.
+func (s *ApplicationService) SweepStagedUploads(ctx context.Context, now time.Time) (int, error) {
+	expired, err := s.fileRepo.ListExpiredStagedUploads(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, upload := range expired {
+		if err := s.storageFor(ctx, upload.Provider).DeleteFileByPath(ctx, upload.StoragePath); err != nil {
+			log.Printf("Warning: Failed to delete expired staged upload %s (%s): %v", upload.StoragePath, upload.Provider, err)
+			continue
+		}
+		if err := s.fileRepo.DeleteStagedUpload(ctx, upload.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// CreateDownloadURL returns a signed URL the client downloads a file or one
+// of its derived files from directly.
+func (s *ApplicationService) CreateDownloadURL(ctx context.Context, req *proto.CreateDownloadURLRequest) (*proto.SignedURLResponse, error) {
+	if s.signedURLs == nil {
+		return nil, fmt.Errorf("%w: SIGNED_URLS is off", domain.ErrSignedURLUnavailable)
+	}
+	if req.GetFilename() == "" {
+		return nil, fmt.Errorf("filename is required but was not provided")
+	}
+	provider := req.GetStorageProvider()
+	if provider == "" {
+		provider = "s3"
+	}
+	filename := domain.ScopeFilename(ctx, req.GetFilename())
+	downloadName := path.Base(req.GetFilename())
+	var storagePath string
+	switch req.GetVariant() {
+	case "":
+		storagePath = domain.BuildStoragePath(filename)
+		if file, err := s.fileRepo.FindByFilename(ctx, filename, provider); err == nil && file != nil {
+			if file.Namespace+"/" == domain.QuarantineNamespace {
+				return nil, fmt.Errorf("%w: %s", domain.ErrFileQuarantined, req.GetFilename())
+			}
+			if file.StoragePath != "" {
+				storagePath = file.StoragePath
+			}
+		}
+	case domain.DownloadVariantSearchable:
+		engineName := req.GetEngineName()
+		if engineName == "" {
+			engineName = "tesseract"
+		}
+		storagePath = domain.SearchablePDFPath(filename, engineName)
+		downloadName = domain.SearchablePDFFilename(downloadName)
+	case domain.DownloadVariantRedacted:
+		storagePath = domain.RedactedPath(filename)
+	default:
+		return nil, fmt.Errorf("unsupported download variant: %s", req.GetVariant())
+	}
+
+	signer, err := s.signedURLs.Signer(s.storageFor(ctx, provider), provider)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signer.SignedDownloadURL(ctx, storagePath, downloadName, s.signedURLs.TTL(req.GetExpiresInSeconds()))
+	if err != nil {
+		return nil, err
+	}
+	return &proto.SignedURLResponse{
+		Url:       signed.URL,
+		Method:    signed.Method,
+		Headers:   signed.Headers,
+		ExpiresAt: signed.ExpiresAt.Unix(),
+		Direct:    signed.Direct,
 	}, nil
 }
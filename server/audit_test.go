@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	pb "grpc-sample-minimal/proto"
+	"grpc-sample-minimal/server/domain"
+)
+
+// recordingAuditLog keeps appended events in memory.
+type recordingAuditLog struct {
+	events []*domain.AuditEvent
+}
+
+func (l *recordingAuditLog) Append(ctx context.Context, e *domain.AuditEvent) error {
+	l.events = append(l.events, e)
+	return nil
+}
+
+func (l *recordingAuditLog) Query(ctx context.Context, q domain.AuditQuery) ([]*domain.AuditEvent, error) {
+	return l.events, nil
+}
+
+func (l *recordingAuditLog) VerifyChain(ctx context.Context) (int, error) {
+	return len(l.events), nil
+}
+
+// useAuditLog installs log as the audit log for the test.
+func useAuditLog(t *testing.T, log domain.AuditLog) {
+	t.Helper()
+	previous := auditLog
+	auditLog = log
+	t.Cleanup(func() { auditLog = previous })
+}
+
+func incomingContext(forwardedFor string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 40000}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forwardedFor))
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	recorded := &recordingAuditLog{}
+	useAuditLog(t, recorded)
+	info := &grpc.UnaryServerInfo{FullMethod: "/greeter.Greeter/DeleteFile"}
+	req := &pb.DeleteFileRequest{Filename: "invoice.pdf", StorageProvider: "azure"}
+
+	for _, tt := range []struct {
+		name    string
+		err     error
+		outcome string
+	}{
+		{"success", nil, domain.AuditOutcomeSuccess},
+		{"denied", status.Error(codes.PermissionDenied, "no"), domain.AuditOutcomeDenied},
+		{"unauthenticated", status.Error(codes.Unauthenticated, "no token"), domain.AuditOutcomeUnauthenticated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded.events = nil
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				if tt.err == nil {
+					domain.AuditEventFromContext(ctx).SetPrincipal(&domain.Principal{Subject: "alice", Tenant: "acme"})
+					return &pb.DeleteFileResponse{Success: true}, nil
+				}
+				return nil, tt.err
+			}
+			if _, err := auditInterceptor(incomingContext("203.0.113.9"), req, info, handler); err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if len(recorded.events) != 1 {
+				t.Fatalf("recorded %d events, want 1", len(recorded.events))
+			}
+			e := recorded.events[0]
+			if e.RPC != "DeleteFile" || e.Filename != "invoice.pdf" || e.Provider != "azure" || e.Outcome != tt.outcome ||
+				e.ClientIP != "192.0.2.7" || e.ForwardedFor != "203.0.113.9" || e.BytesIn == 0 {
+				t.Fatalf("event = %+v", e)
+			}
+			if (tt.err == nil) != (e.Subject == "alice" && e.BytesOut > 0) {
+				t.Fatalf("subject %q and %d bytes out for outcome %s", e.Subject, e.BytesOut, e.Outcome)
+			}
+		})
+	}
+
+	t.Run("without an audit log", func(t *testing.T) {
+		useAuditLog(t, nil)
+		called := false
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = domain.AuditEventFromContext(ctx) == nil
+			return nil, nil
+		}
+		auditInterceptor(context.Background(), req, info, handler)
+		if !called {
+			t.Fatal("handler did not run without an audit event")
+		}
+	})
+}
+
+// fakeServerStream feeds messages to a stream handler and counts what it sends.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv []*pb.FileChunk
+	sent int
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if len(s.recv) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(*pb.FileChunk), s.recv[0])
+	s.recv = s.recv[1:]
+	return nil
+}
+
+func (s *fakeServerStream) SendMsg(m interface{}) error {
+	s.sent++
+	return nil
+}
+
+func TestAuditStreamInterceptor(t *testing.T) {
+	recorded := &recordingAuditLog{}
+	useAuditLog(t, recorded)
+	chunks := []*pb.FileChunk{
+		{Filename: "scan.png", Content: make([]byte, 1000)},
+		{Filename: "other.png", Content: make([]byte, 500)},
+	}
+	ss := &fakeServerStream{ctx: incomingContext(""), recv: chunks}
+	info := &grpc.StreamServerInfo{FullMethod: "/greeter.Greeter/UploadFile", IsClientStream: true}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		if domain.AuditEventFromContext(stream.Context()) == nil {
+			t.Error("stream context carries no audit event")
+		}
+		for {
+			var chunk pb.FileChunk
+			if err := stream.RecvMsg(&chunk); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		return stream.SendMsg(&pb.FileUploadStatus{Filename: "scan.png", Success: true})
+	}
+	if err := auditStreamInterceptor(nil, ss, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.events) != 1 {
+		t.Fatalf("recorded %d events, want 1", len(recorded.events))
+	}
+	e := recorded.events[0]
+	// The first chunk names the file; uploads without a provider go to S3
+	if e.RPC != "UploadFile" || e.Filename != "scan.png" || e.Provider != "s3" || e.Outcome != domain.AuditOutcomeSuccess {
+		t.Fatalf("event = %+v", e)
+	}
+	if e.BytesIn < 1500 || e.BytesOut == 0 || ss.sent != 1 {
+		t.Fatalf("bytes in %d, out %d; want the chunk sizes and the status", e.BytesIn, e.BytesOut)
+	}
+}
+
+func TestRunAuditVerify(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "files.db")
+	t.Setenv("DB_PATH", dbPath)
+	t.Setenv("AUDIT_HASH_CHAIN", "")
+	store, err := domain.NewAuditLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rpc := range []string{"SayHello", "ListFiles", "DeleteFile"} {
+		e := &domain.AuditEvent{Time: time.Now(), RPC: rpc}
+		e.SetResult(nil)
+		if err := store.Append(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.(io.Closer).Close()
+
+	if code := runAuditVerify(nil); code != 0 {
+		t.Fatalf("runAuditVerify = %d on an intact chain, want 0", code)
+	}
+	if code := runAuditVerify([]string{"extra"}); code != 2 {
+		t.Fatalf("runAuditVerify with arguments = %d, want 2", code)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{`DROP TRIGGER audit_log_no_update`, `UPDATE audit_log SET rpc = 'SayHello' WHERE id = 3`} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if code := runAuditVerify(nil); code != 1 {
+		t.Fatalf("runAuditVerify = %d on a tampered chain, want 1", code)
+	}
+}
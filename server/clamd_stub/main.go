@@ -0,0 +1,173 @@
+// Command clamd_stub is a stand-in for clamd, the ClamAV daemon. It speaks the
+// clamd protocol (PING, VERSION and INSTREAM, with z or n command prefixes)
+// and flags the EICAR test file, so upload scanning can be exercised without
+// ClamAV and its signature database:
+//
+//	CONTENT_SCANNER=clamd CLAMD_ADDRESS=localhost:3310 ./server
+//
+// Configuration:
+//
+//	CLAMD_STUB_ADDRESS     listen address (default ":3310"; "unix:/path" for a socket)
+//	CLAMD_STUB_SIGNATURES  extra signatures, "Name=substring,..."
+//	CLAMD_STUB_MAX_SIZE    StreamMaxLength in bytes (default 26214400)
+//	CLAMD_STUB_DELAY       sleep per scan, e.g. "5s", to trigger client timeouts
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eicar is the EICAR anti-virus test file.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+type signature struct {
+	name    string
+	pattern []byte
+}
+
+type stub struct {
+	signatures []signature
+	maxSize    int64
+	delay      time.Duration
+}
+
+func main() {
+	s := &stub{
+		signatures: []signature{{name: "Eicar-Test-Signature", pattern: []byte(eicar)}},
+		maxSize:    25 << 20,
+	}
+	for _, entry := range strings.Split(os.Getenv("CLAMD_STUB_SIGNATURES"), ",") {
+		name, pattern, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && name != "" && pattern != "" {
+			s.signatures = append(s.signatures, signature{name: name, pattern: []byte(pattern)})
+		}
+	}
+	if v := os.Getenv("CLAMD_STUB_MAX_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid CLAMD_STUB_MAX_SIZE %q", v)
+		}
+		s.maxSize = n
+	}
+	if v := os.Getenv("CLAMD_STUB_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid CLAMD_STUB_DELAY %q", v)
+		}
+		s.delay = d
+	}
+
+	address := os.Getenv("CLAMD_STUB_ADDRESS")
+	if address == "" {
+		address = ":3310"
+	}
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+		os.Remove(path)
+	}
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	log.Printf("clamd stub listening at %v (%d signatures)", lis.Addr(), len(s.signatures))
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Printf("accept failed: %v", err)
+			continue
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve handles one command per connection, as clamd does outside sessions.
+func (s *stub) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	r := bufio.NewReader(conn)
+
+	// "zCOMMAND\0" or "nCOMMAND\n"; replies use the same terminator
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	delim := byte('\n')
+	switch prefix {
+	case 'z':
+		delim = 0
+	case 'n':
+	default:
+		r.UnreadByte()
+	}
+	command, err := r.ReadString(delim)
+	if err != nil {
+		return
+	}
+	reply := func(msg string) {
+		conn.Write(append([]byte(msg), delim))
+	}
+
+	switch strings.TrimRight(command, "\x00\n") {
+	case "PING":
+		reply("PONG")
+	case "VERSION":
+		reply("ClamAV 1.0.0/clamd-stub")
+	case "INSTREAM":
+		content, err := s.readStream(r)
+		if err != nil {
+			reply(err.Error())
+			return
+		}
+		time.Sleep(s.delay)
+		if name := s.match(content); name != "" {
+			log.Printf("INSTREAM: %d bytes, %s FOUND", len(content), name)
+			reply("stream: " + name + " FOUND")
+			return
+		}
+		log.Printf("INSTREAM: %d bytes, OK", len(content))
+		reply("stream: OK")
+	default:
+		reply("UNKNOWN COMMAND")
+	}
+}
+
+// readStream reads INSTREAM chunks up to the terminating zero length.
+func (s *stub) readStream(r io.Reader) ([]byte, error) {
+	var content bytes.Buffer
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, fmt.Errorf("INSTREAM: %v ERROR", err)
+		}
+		n := int64(binary.BigEndian.Uint32(size[:]))
+		if n == 0 {
+			return content.Bytes(), nil
+		}
+		if int64(content.Len())+n > s.maxSize {
+			return nil, fmt.Errorf("INSTREAM size limit exceeded. ERROR")
+		}
+		if _, err := io.CopyN(&content, r, n); err != nil {
+			return nil, fmt.Errorf("INSTREAM: %v ERROR", err)
+		}
+	}
+}
+
+func (s *stub) match(content []byte) string {
+	for _, sig := range s.signatures {
+		if bytes.Contains(content, sig.pattern) {
+			return sig.name
+		}
+	}
+	return ""
+}
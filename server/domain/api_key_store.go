@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// apiKeyTouchInterval limits how often last_used_at is written for a key.
+const apiKeyTouchInterval = time.Minute
+
+// sqliteAPIKeyStore stores API keys in the api_keys table of the SQLite
+// database.
+type sqliteAPIKeyStore struct {
+	db *sql.DB
+}
+
+// NewAPIKeyStore opens the API key table in DB_PATH.
+func NewAPIKeyStore(ctx context.Context) (APIKeyStore, error) {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/data/files.db"
+	}
+
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_foreign_keys=1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			secret_hash TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			owner TEXT NOT NULL,
+			tenant TEXT NOT NULL DEFAULT '',
+			roles TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_used_at INTEGER,
+			revoked_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_keys_tenant_owner ON api_keys(tenant, owner);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+
+	log.Printf("APIKeyStore initialized successfully (db: %s)", dbPath)
+	return &sqliteAPIKeyStore{db: db}, nil
+}
+
+func (s *sqliteAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, name, secret_hash, scopes, owner, tenant, roles, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.Name, key.SecretHash, strings.Join(key.Scopes, ","), key.Owner, key.Tenant,
+		strings.Join(key.Roles, ","), key.CreatedAt.Unix(), key.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save API key: %w", err)
+	}
+	return nil
+}
+
+const apiKeyColumns = `id, name, secret_hash, scopes, owner, tenant, roles, created_at, expires_at, last_used_at, revoked_at`
+
+func (s *sqliteAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id)
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *sqliteAPIKeyStore) ListAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE (? OR tenant = ?)
+		  AND (? = '' OR owner = ?)
+		  AND (? OR revoked_at IS NULL)
+		ORDER BY created_at DESC
+	`, filter.AllTenants, filter.Tenant, filter.Owner, filter.Owner, filter.IncludeRevoked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteAPIKeyStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	// Keys of busy scripts are used on every call; write at most once a minute
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE api_keys SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at <= ?)
+	`, at.Unix(), id, at.Add(-apiKeyTouchInterval).Unix())
+	return err
+}
+
+func (s *sqliteAPIKeyStore) Close() error {
+	return s.db.Close()
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes, roles string
+	var createdAt, expiresAt int64
+	var lastUsedAt, revokedAt sql.NullInt64
+	if err := row.Scan(&key.ID, &key.Name, &key.SecretHash, &scopes, &key.Owner, &key.Tenant, &roles,
+		&createdAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = splitList(scopes)
+	key.Roles = splitList(roles)
+	key.CreatedAt = time.Unix(createdAt, 0)
+	key.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = time.Unix(lastUsedAt.Int64, 0)
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = time.Unix(revokedAt.Int64, 0)
+	}
+	return &key, nil
+}
+
+// splitList splits a comma-separated column, returning nil for "".
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,322 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// API key scopes. A key may only call the RPCs of its scopes, on top of what
+// the roles of its owner allow.
+const (
+	APIKeyScopeUpload = "upload"
+	APIKeyScopeRead   = "read"
+	APIKeyScopeOCR    = "ocr"
+	APIKeyScopeAdmin  = "admin" // every RPC, including key management
+)
+
+// apiKeyScopeRPCs lists the RPCs each scope grants.
+var apiKeyScopeRPCs = map[string][]string{
+	APIKeyScopeUpload: {"UploadFile", "CreateUploadURL", "FinalizeUpload"},
+	APIKeyScopeRead: {
+		"SayHello", "StreamCounter", "Chat",
+		"DownloadFile", "ListFiles",
+		"GetOCRResult", "ListOCRResults", "CompareOCRResults", "GetOCRLayout",
+		"GetExtractedTables", "GetExtractedFields", "SearchDocuments", "GetPIIFindings",
+		"GetQuota", "CreateDownloadURL",
+	},
+	APIKeyScopeOCR:   {"ProcessOCR", "ExportSearchablePDF", "SetGroundTruth", "EvaluateOCR"},
+	APIKeyScopeAdmin: {"*"},
+}
+
+// APIKeyPrefix starts every API key, so keys are recognizable in an
+// authorization header and in secret scanners.
+const APIKeyPrefix = "gsk_"
+
+// apiKeyIDLength is the length of the hex key ID following the prefix.
+const apiKeyIDLength = 16
+
+var (
+	// ErrAPIKeyNotFound is returned for unknown keys and keys of other callers.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKeyScope is returned for unknown or missing scopes.
+	ErrInvalidAPIKeyScope = errors.New("invalid API key scope")
+)
+
+// APIKey is a long-lived credential of an automation client. Only the SHA-256
+// hash of its secret is stored; the key itself is shown once on creation.
+type APIKey struct {
+	ID         string
+	Name       string
+	SecretHash string // hex SHA-256 of the secret part of the key
+	Scopes     []string
+	Owner      string   // subject that created the key
+	Tenant     string   // tenant of the owner
+	Roles      []string // roles of the owner when the key was created
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time // zero when never used
+	RevokedAt  time.Time // zero while active
+}
+
+// APIKeyFilter selects keys to list.
+type APIKeyFilter struct {
+	Tenant         string
+	AllTenants     bool   // ignore Tenant
+	Owner          string // "" for every owner
+	IncludeRevoked bool
+}
+
+// APIKeyStore persists API keys.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// GetAPIKey returns nil when there is no key with the ID.
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	// TouchAPIKey records a use of the key.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// NormalizeAPIKeyScopes validates, lower-cases, sorts and deduplicates scopes.
+func NormalizeAPIKeyScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if _, ok := apiKeyScopeRPCs[scope]; !ok {
+			return nil, fmt.Errorf("%w %q (want upload, read, ocr or admin)", ErrInvalidAPIKeyScope, scope)
+		}
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyScope)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// APIKeyDefaultTTL is the lifetime of keys created without one:
+// API_KEY_DEFAULT_TTL, 90 days by default.
+func APIKeyDefaultTTL() time.Duration {
+	if v := os.Getenv("API_KEY_DEFAULT_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid API_KEY_DEFAULT_TTL %q, using 90 days", v)
+	}
+	return 90 * 24 * time.Hour
+}
+
+// APIKeyMaxTTL is the longest lifetime a key may be created with:
+// API_KEY_MAX_TTL, 365 days by default.
+func APIKeyMaxTTL() time.Duration {
+	if v := os.Getenv("API_KEY_MAX_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid API_KEY_MAX_TTL %q, using 365 days", v)
+	}
+	return 365 * 24 * time.Hour
+}
+
+// APIKeyTTL returns the lifetime of a key requested to expire after seconds:
+// the default for 0, and at most APIKeyMaxTTL. The seconds are capped before
+// they are converted, so huge values cannot overflow into a short or negative
+// lifetime.
+func APIKeyTTL(seconds int64) (time.Duration, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("expires_in_seconds must not be negative")
+	}
+	limit := APIKeyMaxTTL()
+	ttl := APIKeyDefaultTTL()
+	if seconds > 0 {
+		if seconds >= int64(limit/time.Second) {
+			return limit, nil
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	return min(ttl, limit), nil
+}
+
+// NewAPIKey creates a key for principal and returns it with the key string
+// to hand to the client. The key keeps a copy of the principal's roles: roles
+// come from the claims of the token the key was created with, which are not
+// available when the key is used later. Role changes therefore only reach a
+// key when it is replaced, which is why its lifetime is bounded by
+// APIKeyMaxTTL.
+func NewAPIKey(principal *Principal, name string, scopes []string, ttl time.Duration, now time.Time) (*APIKey, string, error) {
+	id := make([]byte, apiKeyIDLength/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key := &APIKey{
+		ID:         hex.EncodeToString(id),
+		Name:       name,
+		SecretHash: hashAPIKeySecret(encodedSecret),
+		Scopes:     scopes,
+		Owner:      principal.Subject,
+		Tenant:     principal.Tenant,
+		Roles:      append([]string(nil), principal.Roles...),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	return key, APIKeyPrefix + key.ID + "_" + encodedSecret, nil
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a JWT.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// parseAPIKey splits a key into its ID and secret.
+func parseAPIKey(raw string) (string, string, bool) {
+	if !IsAPIKey(raw) {
+		return "", "", false
+	}
+	rest := raw[len(APIKeyPrefix):]
+	if len(rest) < apiKeyIDLength+2 || rest[apiKeyIDLength] != '_' {
+		return "", "", false
+	}
+	id := rest[:apiKeyIDLength]
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", "", false
+	}
+	return id, rest[apiKeyIDLength+1:], true
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetAPIKeys lets callers authenticate with API keys from store, sent as
+// x-api-key metadata or as the bearer token.
+func (a *Authenticator) SetAPIKeys(store APIKeyStore) {
+	a.apiKeys = store
+}
+
+// VerifyAPIKey checks an API key and returns its principal: the owner of the
+// key, with the owner's tenant, the roles the owner had when the key was
+// created, and the key's scopes.
+func (a *Authenticator) VerifyAPIKey(ctx context.Context, raw string) (*Principal, error) {
+	if a.apiKeys == nil {
+		return nil, fmt.Errorf("%w: API keys are not accepted", ErrUnauthenticated)
+	}
+	id, secret, ok := parseAPIKey(strings.TrimSpace(raw))
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed API key", ErrUnauthenticated)
+	}
+	key, err := a.apiKeys.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to look up API key: %v", ErrUnauthenticated, err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	now := a.now()
+	if !key.RevokedAt.IsZero() {
+		return nil, fmt.Errorf("%w: API key %s is revoked", ErrUnauthenticated, key.ID)
+	}
+	if !now.Before(key.ExpiresAt) {
+		return nil, fmt.Errorf("%w: API key %s expired", ErrUnauthenticated, key.ID)
+	}
+	if err := a.apiKeys.TouchAPIKey(ctx, key.ID, now); err != nil {
+		log.Printf("Warning: failed to record use of API key %s: %v", key.ID, err)
+	}
+	return &Principal{
+		Subject:   key.Owner,
+		Tenant:    key.Tenant,
+		Roles:     append([]string(nil), key.Roles...),
+		Issuer:    "api-key",
+		ExpiresAt: key.ExpiresAt,
+		APIKeyID:  key.ID,
+		Scopes:    key.Scopes,
+	}, nil
+}
+
+// HasScope reports whether the principal has an API key scope. Callers
+// authenticated otherwise have every scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p.APIKeyID == "" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == APIKeyScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAPIKeyScope returns ErrPermissionDenied when the principal is an API
+// key whose scopes do not include the RPC of method.
+func CheckAPIKeyScope(principal *Principal, method string) error {
+	if principal == nil || principal.APIKeyID == "" {
+		return nil
+	}
+	rpc := method[strings.LastIndex(method, "/")+1:]
+	for _, scope := range principal.Scopes {
+		if matchesPolicyList(apiKeyScopeRPCs[scope], rpc) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: API key %s with scopes %v may not call %s", ErrPermissionDenied, principal.APIKeyID, principal.Scopes, rpc)
+}
+
+// CanManageAPIKey reports whether the principal may see and revoke a key:
+// its own keys, and every key of its tenant for admins (of every tenant for
+// admins without one).
+func CanManageAPIKey(principal *Principal, key *APIKey) bool {
+	if principal == nil {
+		return false
+	}
+	if principal.Tenant != "" && key.Tenant != principal.Tenant {
+		return false
+	}
+	if key.Owner == principal.Subject && key.Tenant == principal.Tenant {
+		return true
+	}
+	return principal.HasRole("admin") && principal.HasScope(APIKeyScopeAdmin)
+}
+
+// APIKeyToProto converts a key for ListAPIKeys; the secret hash is left out.
+func APIKeyToProto(key *APIKey) *pb.APIKeyInfo {
+	info := &pb.APIKeyInfo{
+		Id:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		Owner:     key.Owner,
+		Tenant:    key.Tenant,
+		CreatedAt: key.CreatedAt.Unix(),
+		ExpiresAt: key.ExpiresAt.Unix(),
+	}
+	if !key.LastUsedAt.IsZero() {
+		info.LastUsedAt = key.LastUsedAt.Unix()
+	}
+	if !key.RevokedAt.IsZero() {
+		info.RevokedAt = key.RevokedAt.Unix()
+	}
+	return info
+}
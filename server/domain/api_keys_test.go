@@ -0,0 +1,243 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryAPIKeyStore keeps keys in a map.
+type memoryAPIKeyStore struct {
+	keys    map[string]*APIKey
+	touched map[string]time.Time
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{keys: make(map[string]*APIKey), touched: make(map[string]time.Time)}
+}
+
+func (s *memoryAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *memoryAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return s.keys[id], nil
+}
+
+func (s *memoryAPIKeyStore) ListAPIKeys(ctx context.Context, filter APIKeyFilter) ([]*APIKey, error) {
+	var keys []*APIKey
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.keys[id].RevokedAt = at
+	return nil
+}
+
+func (s *memoryAPIKeyStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	s.touched[id] = at
+	return nil
+}
+
+func TestParseAPIKey(t *testing.T) {
+	for _, tt := range []struct {
+		raw, id, secret string
+		ok              bool
+	}{
+		{"gsk_0123456789abcdef_secret", "0123456789abcdef", "secret", true},
+		{"gsk_0123456789abcdef_a_b", "0123456789abcdef", "a_b", true},
+		{"gsk_0123456789abcdef_", "", "", false},
+		{"gsk_0123456789abcdef", "", "", false},
+		{"gsk_0123456789abcdeg_secret", "", "", false},
+		{"gsk_0123456789abcde_secret", "", "", false},
+		{"0123456789abcdef_secret", "", "", false},
+		{"eyJhbGciOiJIUzI1NiJ9.e30.sig", "", "", false},
+		{"", "", "", false},
+	} {
+		id, secret, ok := parseAPIKey(tt.raw)
+		if id != tt.id || secret != tt.secret || ok != tt.ok {
+			t.Errorf("parseAPIKey(%q) = %q, %q, %v; want %q, %q, %v", tt.raw, id, secret, ok, tt.id, tt.secret, tt.ok)
+		}
+	}
+}
+
+func TestNewAPIKeyRoundTrip(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	owner := &Principal{Subject: "alice", Tenant: "acme", Roles: []string{"editor"}}
+	key, raw, err := NewAPIKey(owner, "batch", []string{APIKeyScopeRead}, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, secret, ok := parseAPIKey(raw)
+	if !ok || id != key.ID || hashAPIKeySecret(secret) != key.SecretHash {
+		t.Fatalf("key %q does not parse back to key %s", raw, key.ID)
+	}
+	if strings.Contains(key.SecretHash, secret) {
+		t.Fatal("the secret is stored in the clear")
+	}
+	// The roles are a snapshot, not shared with the principal
+	owner.Roles[0] = "admin"
+	if key.Roles[0] != "editor" || !key.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("key = %+v", key)
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	store := newMemoryAPIKeyStore()
+	auth := NewAuthenticator(nil, "", "")
+	auth.now = func() time.Time { return now }
+	auth.SetAPIKeys(store)
+	owner := &Principal{Subject: "alice", Tenant: "acme", Roles: []string{"editor"}}
+
+	issue := func(ttl time.Duration, revoked bool) (*APIKey, string) {
+		key, raw, err := NewAPIKey(owner, "batch", []string{APIKeyScopeRead, APIKeyScopeUpload}, ttl, now.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked {
+			key.RevokedAt = now.Add(-time.Second)
+		}
+		store.CreateAPIKey(context.Background(), key)
+		return key, raw
+	}
+	valid, validRaw := issue(time.Hour, false)
+	_, revokedRaw := issue(time.Hour, true)
+	_, expiredRaw := issue(time.Minute, false)
+	wrongSecret := validRaw[:len(validRaw)-1] + "x"
+	if strings.HasSuffix(validRaw, "x") {
+		wrongSecret = validRaw[:len(validRaw)-1] + "y"
+	}
+
+	for _, tt := range []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{"valid", validRaw, ""},
+		{"surrounding whitespace", " " + validRaw + "\n", ""},
+		{"wrong secret", wrongSecret, "unknown API key"},
+		{"unknown key", "gsk_0123456789abcdef_secret", "unknown API key"},
+		{"revoked", revokedRaw, "revoked"},
+		{"expired", expiredRaw, "expired"},
+		{"malformed", "gsk_nothex", "malformed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := auth.VerifyAPIKey(context.Background(), tt.raw)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want unauthenticated: %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyAPIKey: %v", err)
+			}
+			if principal.Subject != "alice" || principal.Tenant != "acme" || principal.APIKeyID != valid.ID ||
+				principal.Issuer != "api-key" || strings.Join(principal.Roles, ",") != "editor" ||
+				strings.Join(principal.Scopes, ",") != "read,upload" || !principal.ExpiresAt.Equal(valid.ExpiresAt) {
+				t.Fatalf("principal = %+v", principal)
+			}
+			if !store.touched[valid.ID].Equal(now) {
+				t.Fatalf("use recorded at %v, want %v", store.touched[valid.ID], now)
+			}
+		})
+	}
+
+	t.Run("without a store", func(t *testing.T) {
+		if _, err := NewAuthenticator(nil, "", "").VerifyAPIKey(context.Background(), validRaw); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("err = %v, want unauthenticated", err)
+		}
+	})
+}
+
+func TestCheckAPIKeyScope(t *testing.T) {
+	key := func(scopes ...string) *Principal {
+		return &Principal{Subject: "alice", APIKeyID: "0123456789abcdef", Scopes: scopes}
+	}
+	for _, tt := range []struct {
+		name      string
+		principal *Principal
+		method    string
+		allowed   bool
+	}{
+		{"no caller", nil, "/greeter.Greeter/DeleteFile", true},
+		{"token caller", &Principal{Subject: "alice"}, "/greeter.Greeter/DeleteFile", true},
+		{"read key lists files", key(APIKeyScopeRead), "/greeter.Greeter/ListFiles", true},
+		{"read key uploads", key(APIKeyScopeRead), "/greeter.Greeter/UploadFile", false},
+		{"upload key uploads", key(APIKeyScopeUpload), "/greeter.Greeter/UploadFile", true},
+		{"upload and ocr key processes", key(APIKeyScopeOCR, APIKeyScopeUpload), "/greeter.Greeter/ProcessOCR", true},
+		{"ocr key deletes", key(APIKeyScopeOCR), "/greeter.Greeter/DeleteFile", false},
+		{"ocr key creates keys", key(APIKeyScopeOCR, APIKeyScopeRead, APIKeyScopeUpload), "/greeter.Greeter/CreateAPIKey", false},
+		{"admin key creates keys", key(APIKeyScopeAdmin), "/greeter.Greeter/CreateAPIKey", true},
+		{"key without scopes", key(), "/greeter.Greeter/SayHello", false},
+		{"bare method name", key(APIKeyScopeRead), "GetOCRResult", true},
+	} {
+		err := CheckAPIKeyScope(tt.principal, tt.method)
+		if (err == nil) != tt.allowed || (err != nil && !errors.Is(err, ErrPermissionDenied)) {
+			t.Errorf("%s: CheckAPIKeyScope = %v, want allowed %v", tt.name, err, tt.allowed)
+		}
+	}
+}
+
+func TestCanManageAPIKey(t *testing.T) {
+	key := &APIKey{ID: "0123456789abcdef", Owner: "alice", Tenant: "acme"}
+	for _, tt := range []struct {
+		name      string
+		principal *Principal
+		want      bool
+	}{
+		{"no caller", nil, false},
+		{"owner", &Principal{Subject: "alice", Tenant: "acme"}, true},
+		{"owner through a read key", &Principal{Subject: "alice", Tenant: "acme", APIKeyID: "1", Scopes: []string{APIKeyScopeRead}}, true},
+		{"same subject of another tenant", &Principal{Subject: "alice", Tenant: "globex"}, false},
+		{"other user of the tenant", &Principal{Subject: "bob", Tenant: "acme"}, false},
+		{"admin of the tenant", &Principal{Subject: "bob", Tenant: "acme", Roles: []string{"admin"}}, true},
+		{"admin of another tenant", &Principal{Subject: "bob", Tenant: "globex", Roles: []string{"admin"}}, false},
+		{"admin without a tenant", &Principal{Subject: "root", Roles: []string{"admin"}}, true},
+		{"admin through a read key", &Principal{Subject: "bob", Tenant: "acme", Roles: []string{"admin"}, APIKeyID: "1", Scopes: []string{APIKeyScopeRead}}, false},
+		{"admin through an admin key", &Principal{Subject: "bob", Tenant: "acme", Roles: []string{"admin"}, APIKeyID: "1", Scopes: []string{APIKeyScopeAdmin}}, true},
+	} {
+		if got := CanManageAPIKey(tt.principal, key); got != tt.want {
+			t.Errorf("%s: CanManageAPIKey = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyTTL(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tt := range []struct {
+		name       string
+		defaultTTL string
+		maxTTL     string
+		seconds    int64
+		want       time.Duration
+		wantErr    bool
+	}{
+		{"default", "", "", 0, 90 * day, false},
+		{"requested", "", "", 3600, time.Hour, false},
+		{"capped", "", "", 400 * 86400, 365 * day, false},
+		{"overflowing", "", "", math.MaxInt64, 365 * day, false},
+		{"overflowing into negative", "", "", math.MaxInt64/int64(time.Second) + 1, 365 * day, false},
+		{"negative", "", "", -1, 0, true},
+		{"configured maximum", "", "720h", 60 * 86400, 30 * day, false},
+		{"default above the maximum", "2160h", "24h", 0, day, false},
+		{"invalid maximum", "", "forever", 400 * 86400, 365 * day, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEY_DEFAULT_TTL", tt.defaultTTL)
+			t.Setenv("API_KEY_MAX_TTL", tt.maxTTL)
+			got, err := APIKeyTTL(tt.seconds)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("APIKeyTTL(%d) = %v, %v; want %v, error %v", tt.seconds, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "grpc-sample-minimal/proto"
+)
+
+// Audit outcomes.
+const (
+	AuditOutcomeSuccess         = "success"
+	AuditOutcomeDenied          = "denied"
+	AuditOutcomeUnauthenticated = "unauthenticated"
+	AuditOutcomeLimited         = "limited" // rate limit or quota
+	AuditOutcomeError           = "error"
+)
+
+// auditErrorLimit truncates error messages stored in the audit log.
+const auditErrorLimit = 500
+
+// AuditEvent records one RPC: who called it, on which file, and how it ended.
+// Request and response contents are never recorded, only their sizes.
+type AuditEvent struct {
+	ID           int64
+	Time         time.Time
+	Subject      string // "" when authentication failed
+	Tenant       string
+	APIKeyID     string
+	RPC          string // method name, e.g. "DeleteFile"
+	Filename     string // as sent by the caller
+	Provider     string
+	Outcome      string
+	Code         string // gRPC status code
+	Error        string
+	BytesIn      int64
+	BytesOut     int64
+	ClientIP     string
+	ForwardedFor string
+	Duration     time.Duration
+	// Hash chains the event to its predecessor: SHA-256 over PrevHash and
+	// the event. Editing or removing an event breaks every later hash.
+	Hash     string
+	PrevHash string
+}
+
+// SetPrincipal records the authenticated caller. It is a no-op on nil, so
+// interceptors can call it whether or not the RPC is audited.
+func (e *AuditEvent) SetPrincipal(principal *Principal) {
+	if e == nil || principal == nil {
+		return
+	}
+	e.Subject, e.Tenant, e.APIKeyID = principal.Subject, principal.Tenant, principal.APIKeyID
+}
+
+// SetResult records the outcome of the RPC from its error.
+func (e *AuditEvent) SetResult(err error) {
+	code := status.Code(err)
+	e.Code = code.String()
+	switch code {
+	case codes.OK:
+		e.Outcome = AuditOutcomeSuccess
+	case codes.PermissionDenied:
+		e.Outcome = AuditOutcomeDenied
+	case codes.Unauthenticated:
+		e.Outcome = AuditOutcomeUnauthenticated
+	case codes.ResourceExhausted:
+		e.Outcome = AuditOutcomeLimited
+	default:
+		e.Outcome = AuditOutcomeError
+	}
+	if err != nil {
+		e.Error = err.Error()
+		if len(e.Error) > auditErrorLimit {
+			e.Error = e.Error[:auditErrorLimit]
+		}
+	}
+}
+
+type auditEventKey struct{}
+
+// WithAuditEvent returns a context carrying the event of the current RPC, so
+// later interceptors can fill in the caller.
+func WithAuditEvent(ctx context.Context, event *AuditEvent) context.Context {
+	return context.WithValue(ctx, auditEventKey{}, event)
+}
+
+// AuditEventFromContext returns the event of the current RPC, or nil.
+func AuditEventFromContext(ctx context.Context) *AuditEvent {
+	event, _ := ctx.Value(auditEventKey{}).(*AuditEvent)
+	return event
+}
+
+// auditHashInput is the hashed form of an event; field order is fixed by the
+// struct, so the JSON encoding is stable.
+type auditHashInput struct {
+	Time         int64  `json:"time"`
+	Subject      string `json:"subject"`
+	Tenant       string `json:"tenant"`
+	APIKeyID     string `json:"api_key_id"`
+	RPC          string `json:"rpc"`
+	Filename     string `json:"filename"`
+	Provider     string `json:"provider"`
+	Outcome      string `json:"outcome"`
+	Code         string `json:"code"`
+	Error        string `json:"error"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	ClientIP     string `json:"client_ip"`
+	ForwardedFor string `json:"forwarded_for"`
+	Duration     int64  `json:"duration_ns"`
+}
+
+// auditHash chains an event to the hash of its predecessor.
+func auditHash(prevHash string, e *AuditEvent) string {
+	data, _ := json.Marshal(auditHashInput{
+		Time: e.Time.UnixNano(), Subject: e.Subject, Tenant: e.Tenant, APIKeyID: e.APIKeyID,
+		RPC: e.RPC, Filename: e.Filename, Provider: e.Provider,
+		Outcome: e.Outcome, Code: e.Code, Error: e.Error,
+		BytesIn: e.BytesIn, BytesOut: e.BytesOut,
+		ClientIP: e.ClientIP, ForwardedFor: e.ForwardedFor, Duration: int64(e.Duration),
+	})
+	sum := sha256.Sum256(append([]byte(prevHash+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditQuery filters the audit log; empty fields match everything.
+type AuditQuery struct {
+	Subject  string
+	Tenant   string
+	RPC      string
+	Filename string
+	Provider string
+	Outcome  string
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+	BeforeID int64     // page after this event; 0 for the first page
+	Limit    int
+}
+
+// AuditLog is the append-only store of audit events.
+type AuditLog interface {
+	// Append stores an event, setting its ID and hash.
+	Append(ctx context.Context, event *AuditEvent) error
+	// Query returns matching events, newest first.
+	Query(ctx context.Context, query AuditQuery) ([]*AuditEvent, error)
+	// VerifyChain recomputes the hash chain and returns the number of
+	// chained events, or an error naming the first event that does not match.
+	VerifyChain(ctx context.Context) (int, error)
+}
+
+// AuditEventToProto converts an event for QueryAuditLog.
+func AuditEventToProto(e *AuditEvent) *pb.AuditEvent {
+	return &pb.AuditEvent{
+		Id:              e.ID,
+		Time:            e.Time.Unix(),
+		Subject:         e.Subject,
+		Tenant:          e.Tenant,
+		ApiKeyId:        e.APIKeyID,
+		Rpc:             e.RPC,
+		Filename:        e.Filename,
+		StorageProvider: e.Provider,
+		Outcome:         e.Outcome,
+		Code:            e.Code,
+		Error:           e.Error,
+		BytesIn:         e.BytesIn,
+		BytesOut:        e.BytesOut,
+		ClientIp:        e.ClientIP,
+		ForwardedFor:    e.ForwardedFor,
+		DurationMs:      e.Duration.Milliseconds(),
+		Hash:            e.Hash,
+		PrevHash:        e.PrevHash,
+	}
+}
@@ -0,0 +1,208 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteAuditLog stores audit events in the audit_log table of the SQLite
+// database. Triggers reject UPDATE and DELETE, so the table is append-only
+// for everything going through SQLite; the hash chain makes edits of the
+// file itself detectable.
+type sqliteAuditLog struct {
+	db    *sql.DB
+	chain bool
+
+	mu sync.Mutex // serializes appends, which read the previous hash
+}
+
+// NewAuditLog opens the audit log in DB_PATH. Hash chaining is controlled by
+// AUDIT_HASH_CHAIN (default on; false/0/off/no disables).
+func NewAuditLog(ctx context.Context) (AuditLog, error) {
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "/app/data/files.db"
+	}
+
+	// Immediate transactions keep concurrent appenders from forking the chain
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_foreign_keys=1&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time_ns INTEGER NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			tenant TEXT NOT NULL DEFAULT '',
+			api_key_id TEXT NOT NULL DEFAULT '',
+			rpc TEXT NOT NULL,
+			filename TEXT NOT NULL DEFAULT '',
+			storage_provider TEXT NOT NULL DEFAULT '',
+			outcome TEXT NOT NULL,
+			code TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
+			client_ip TEXT NOT NULL DEFAULT '',
+			forwarded_for TEXT NOT NULL DEFAULT '',
+			duration_ns INTEGER NOT NULL DEFAULT 0,
+			hash TEXT NOT NULL DEFAULT '',
+			prev_hash TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log(time_ns);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_tenant_subject ON audit_log(tenant, subject);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_filename ON audit_log(filename);
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+
+	chain := true
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("AUDIT_HASH_CHAIN"))) {
+	case "false", "0", "off", "no":
+		chain = false
+	}
+	log.Printf("AuditLog initialized successfully (db: %s, hash chain: %v)", dbPath, chain)
+	return &sqliteAuditLog{db: db, chain: chain}, nil
+}
+
+func (l *sqliteAuditLog) Append(ctx context.Context, e *AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	e.Hash, e.PrevHash = "", ""
+	if l.chain {
+		err := tx.QueryRowContext(ctx, `SELECT hash FROM audit_log WHERE hash != '' ORDER BY id DESC LIMIT 1`).Scan(&e.PrevHash)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read the previous audit hash: %w", err)
+		}
+		e.Hash = auditHash(e.PrevHash, e)
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_log (time_ns, subject, tenant, api_key_id, rpc, filename, storage_provider,
+			outcome, code, error, bytes_in, bytes_out, client_ip, forwarded_for, duration_ns, hash, prev_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.Time.UnixNano(), e.Subject, e.Tenant, e.APIKeyID, e.RPC, e.Filename, e.Provider,
+		e.Outcome, e.Code, e.Error, e.BytesIn, e.BytesOut, e.ClientIP, e.ForwardedFor, int64(e.Duration), e.Hash, e.PrevHash)
+	if err != nil {
+		return fmt.Errorf("failed to append audit event: %w", err)
+	}
+	if e.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const auditColumns = `id, time_ns, subject, tenant, api_key_id, rpc, filename, storage_provider,
+	outcome, code, error, bytes_in, bytes_out, client_ip, forwarded_for, duration_ns, hash, prev_hash`
+
+func (l *sqliteAuditLog) Query(ctx context.Context, q AuditQuery) ([]*AuditEvent, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	for _, f := range []struct{ column, value string }{
+		{"subject", q.Subject}, {"tenant", q.Tenant}, {"rpc", q.RPC},
+		{"filename", q.Filename}, {"storage_provider", q.Provider}, {"outcome", q.Outcome},
+	} {
+		if f.value != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "time_ns >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "time_ns < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < ?")
+		args = append(args, q.BeforeID)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := l.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log WHERE `+strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (l *sqliteAuditLog) VerifyChain(ctx context.Context) (int, error) {
+	rows, err := l.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log WHERE hash != '' ORDER BY id ASC`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	checked := 0
+	prev := ""
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return checked, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if e.PrevHash != prev {
+			return checked, fmt.Errorf("audit event %d does not follow its predecessor (an event was removed or reordered)", e.ID)
+		}
+		if auditHash(e.PrevHash, e) != e.Hash {
+			return checked, fmt.Errorf("audit event %d was modified", e.ID)
+		}
+		prev = e.Hash
+		checked++
+	}
+	return checked, rows.Err()
+}
+
+func (l *sqliteAuditLog) Close() error {
+	return l.db.Close()
+}
+
+func scanAuditEvent(row interface{ Scan(...interface{}) error }) (*AuditEvent, error) {
+	var e AuditEvent
+	var timeNs, durationNs int64
+	if err := row.Scan(&e.ID, &timeNs, &e.Subject, &e.Tenant, &e.APIKeyID, &e.RPC, &e.Filename, &e.Provider,
+		&e.Outcome, &e.Code, &e.Error, &e.BytesIn, &e.BytesOut, &e.ClientIP, &e.ForwardedFor, &durationNs,
+		&e.Hash, &e.PrevHash); err != nil {
+		return nil, err
+	}
+	e.Time = time.Unix(0, timeNs)
+	e.Duration = time.Duration(durationNs)
+	return &e, nil
+}
@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestAuditLog opens an audit log in a temporary database and appends
+// count events to it.
+func newTestAuditLog(t *testing.T, count int) *sqliteAuditLog {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "audit.db"))
+	t.Setenv("AUDIT_HASH_CHAIN", "")
+	store, err := NewAuditLog(context.Background())
+	if err != nil {
+		t.Fatalf("NewAuditLog: %v", err)
+	}
+	l := store.(*sqliteAuditLog)
+	t.Cleanup(func() { l.Close() })
+	start := time.Unix(1_800_000_000, 0)
+	for i := range count {
+		e := &AuditEvent{
+			Time:     start.Add(time.Duration(i) * time.Second),
+			Subject:  "alice",
+			Tenant:   "acme",
+			RPC:      "DownloadFile",
+			Filename: fmt.Sprintf("file-%d.pdf", i),
+			Provider: "s3",
+			BytesOut: int64(100 * i),
+			Duration: time.Millisecond,
+		}
+		e.SetResult(nil)
+		if err := l.Append(context.Background(), e); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return l
+}
+
+func TestAuditLogChain(t *testing.T) {
+	ctx := context.Background()
+	l := newTestAuditLog(t, 5)
+	if n, err := l.VerifyChain(ctx); n != 5 || err != nil {
+		t.Fatalf("VerifyChain = %d, %v; want 5 events intact", n, err)
+	}
+	events, err := l.Query(ctx, AuditQuery{Limit: 10})
+	if err != nil || len(events) != 5 {
+		t.Fatalf("Query = %d events, %v", len(events), err)
+	}
+	// Newest first, each chained to the one before it
+	for i, e := range events {
+		if e.Filename != fmt.Sprintf("file-%d.pdf", 4-i) || e.Hash == "" {
+			t.Fatalf("event %d = %+v", i, e)
+		}
+		if i+1 < len(events) && e.PrevHash != events[i+1].Hash {
+			t.Fatalf("event %d is not chained to its predecessor", e.ID)
+		}
+	}
+	if events[4].PrevHash != "" {
+		t.Fatalf("first event has predecessor %q", events[4].PrevHash)
+	}
+
+	t.Run("filters and pages", func(t *testing.T) {
+		got, err := l.Query(ctx, AuditQuery{Filename: "file-2.pdf", Limit: 10})
+		if err != nil || len(got) != 1 || got[0].BytesOut != 200 {
+			t.Fatalf("Query by filename = %+v, %v", got, err)
+		}
+		got, err = l.Query(ctx, AuditQuery{BeforeID: events[1].ID, Since: events[4].Time.Add(time.Second), Limit: 10})
+		if err != nil || len(got) != 2 || got[0].ID != events[2].ID {
+			t.Fatalf("Query page = %d events, %v; want events 2 and 3", len(got), err)
+		}
+	})
+
+	t.Run("triggers reject UPDATE and DELETE", func(t *testing.T) {
+		for _, stmt := range []string{
+			`UPDATE audit_log SET subject = 'mallory' WHERE id = 2`,
+			`DELETE FROM audit_log WHERE id = 2`,
+			`DELETE FROM audit_log`,
+		} {
+			_, err := l.db.ExecContext(ctx, stmt)
+			if err == nil || !strings.Contains(err.Error(), "append-only") {
+				t.Errorf("%s: err = %v, want append-only", stmt, err)
+			}
+		}
+		if n, err := l.VerifyChain(ctx); n != 5 || err != nil {
+			t.Fatalf("VerifyChain = %d, %v after rejected writes", n, err)
+		}
+	})
+}
+
+func TestAuditLogChainDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name    string
+		trigger string
+		stmt    string
+		checked int
+		wantErr string
+	}{
+		{"modified event", "audit_log_no_update", `UPDATE audit_log SET subject = 'mallory' WHERE id = 3`, 2, "audit event 3 was modified"},
+		{"modified hash", "audit_log_no_update", `UPDATE audit_log SET bytes_out = 0, hash = 'x' WHERE id = 4`, 3, "audit event 4 was modified"},
+		{"removed event", "audit_log_no_delete", `DELETE FROM audit_log WHERE id = 2`, 1, "audit event 3 does not follow its predecessor"},
+		{"removed last event", "audit_log_no_delete", `DELETE FROM audit_log WHERE id = 5`, 4, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestAuditLog(t, 5)
+			if _, err := l.db.ExecContext(ctx, `DROP TRIGGER `+tt.trigger); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.db.ExecContext(ctx, tt.stmt); err != nil {
+				t.Fatalf("%s: %v", tt.stmt, err)
+			}
+			n, err := l.VerifyChain(ctx)
+			if n != tt.checked {
+				t.Errorf("VerifyChain checked %d events, want %d", n, tt.checked)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyChain: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("VerifyChain err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditLogWithoutChain(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "audit.db"))
+	t.Setenv("AUDIT_HASH_CHAIN", "off")
+	store, err := NewAuditLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.(*sqliteAuditLog).Close()
+	e := &AuditEvent{Time: time.Now(), RPC: "SayHello", Outcome: AuditOutcomeSuccess, Code: "OK"}
+	if err := store.Append(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Hash != "" || e.ID == 0 {
+		t.Fatalf("event = %+v, want an unchained event", e)
+	}
+	if n, err := store.VerifyChain(context.Background()); n != 0 || err != nil {
+		t.Fatalf("VerifyChain = %d, %v; want no chained events", n, err)
+	}
+}
+
+func TestAuditEventSetResult(t *testing.T) {
+	for _, tt := range []struct {
+		err     error
+		outcome string
+		code    string
+	}{
+		{nil, AuditOutcomeSuccess, "OK"},
+		{status.Error(codes.PermissionDenied, "no"), AuditOutcomeDenied, "PermissionDenied"},
+		{status.Error(codes.Unauthenticated, "no"), AuditOutcomeUnauthenticated, "Unauthenticated"},
+		{status.Error(codes.ResourceExhausted, "slow down"), AuditOutcomeLimited, "ResourceExhausted"},
+		{status.Error(codes.NotFound, "gone"), AuditOutcomeError, "NotFound"},
+		{errors.New(strings.Repeat("x", 2*auditErrorLimit)), AuditOutcomeError, "Unknown"},
+	} {
+		var e AuditEvent
+		e.SetResult(tt.err)
+		if e.Outcome != tt.outcome || e.Code != tt.code || len(e.Error) > auditErrorLimit || (tt.err != nil) != (e.Error != "") {
+			t.Errorf("SetResult(%v) = %q %q %q, want %q %q", tt.err, e.Outcome, e.Code, e.Error, tt.outcome, tt.code)
+		}
+	}
+
+	var missing *AuditEvent
+	missing.SetPrincipal(&Principal{Subject: "alice"}) // no-op on nil
+	e := &AuditEvent{}
+	e.SetPrincipal(&Principal{Subject: "alice", Tenant: "acme", APIKeyID: "0123456789abcdef"})
+	if e.Subject != "alice" || e.Tenant != "acme" || e.APIKeyID != "0123456789abcdef" {
+		t.Fatalf("SetPrincipal = %+v", e)
+	}
+}
@@ -0,0 +1,309 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Principal is the authenticated caller of an RPC.
+type Principal struct {
+	Subject   string   // "sub": the user or service
+	Tenant    string   // tenant claim; "" when the token has none
+	Roles     []string // roles claim
+	Issuer    string
+	ExpiresAt time.Time
+	APIKeyID  string   // set when authenticated with an API key
+	Scopes    []string // scopes of the API key
+
+	token string // the verified token, forwarded to downstream services
+}
+
+// HasRole reports whether the principal has the role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Token returns the bearer token the principal was authenticated with.
+func (p *Principal) Token() string {
+	return p.token
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Authenticator turns the "authorization" metadata of an RPC into a Principal.
+type Authenticator struct {
+	verifier    *JWTVerifier
+	tenantClaim string
+	rolesClaim  string
+	now         func() time.Time
+
+	// certRoles maps client-certificate SANs to roles; see SetCertificateRoles.
+	certRoles map[string][]string
+	// apiKeys verifies API keys; see SetAPIKeys.
+	apiKeys APIKeyStore
+}
+
+// ErrUnauthenticated is returned when an RPC carries no usable credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// NewAuthenticator creates an authenticator reading the tenant and roles from
+// the given claims ("tenant" and "roles" when empty).
+func NewAuthenticator(verifier *JWTVerifier, tenantClaim string, rolesClaim string) *Authenticator {
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return &Authenticator{verifier: verifier, tenantClaim: tenantClaim, rolesClaim: rolesClaim, now: time.Now}
+}
+
+// AuthenticatorFromEnv configures JWT authentication:
+//
+//   - AUTH_JWT_HMAC_SECRET (or AUTH_JWT_HMAC_SECRET_FILE): HS256/384/512 key
+//   - AUTH_JWT_JWKS_FILE: local JWKS with RSA, EC or oct keys, selected by kid
+//   - AUTH_JWT_ISSUER, AUTH_JWT_AUDIENCE: required iss and aud, when set
+//   - AUTH_JWT_CLOCK_SKEW: tolerance for exp/nbf/iat (default 60s)
+//   - AUTH_JWT_TENANT_CLAIM, AUTH_JWT_ROLES_CLAIM: claim names (default "tenant", "roles")
+//   - AUTH_CERT_SANS: roles of mTLS clients without a token, by certificate
+//     SAN, e.g. "webapp=service,spiffe://dev/ops=admin|service"
+//
+// It fails when neither a key nor AUTH_CERT_SANS is configured, so a server
+// never accepts every caller.
+func AuthenticatorFromEnv() (*Authenticator, error) {
+	secret := []byte(os.Getenv("AUTH_JWT_HMAC_SECRET"))
+	if path := os.Getenv("AUTH_JWT_HMAC_SECRET_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AUTH_JWT_HMAC_SECRET_FILE: %w", err)
+		}
+		secret = []byte(strings.TrimSpace(string(data)))
+	}
+	jwksPath := os.Getenv("AUTH_JWT_JWKS_FILE")
+	certRoles, err := ParseCertificateRoles(os.Getenv("AUTH_CERT_SANS"))
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 && jwksPath == "" {
+		if len(certRoles) == 0 {
+			return nil, fmt.Errorf("set AUTH_JWT_HMAC_SECRET, AUTH_JWT_JWKS_FILE or AUTH_CERT_SANS")
+		}
+		// Certificate authentication only
+		a := NewAuthenticator(nil, "", "")
+		a.SetCertificateRoles(certRoles)
+		return a, nil
+	}
+	verifier, err := NewJWTVerifier(secret, jwksPath)
+	if err != nil {
+		return nil, err
+	}
+	verifier.Issuer = os.Getenv("AUTH_JWT_ISSUER")
+	verifier.Audience = os.Getenv("AUTH_JWT_AUDIENCE")
+	if skew := os.Getenv("AUTH_JWT_CLOCK_SKEW"); skew != "" {
+		d, err := time.ParseDuration(skew)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid AUTH_JWT_CLOCK_SKEW %q", skew)
+		}
+		verifier.ClockSkew = d
+	}
+	tenantClaim, rolesClaim := ClaimNamesFromEnv()
+	a := NewAuthenticator(verifier, tenantClaim, rolesClaim)
+	a.SetCertificateRoles(certRoles)
+	return a, nil
+}
+
+// ClaimNamesFromEnv returns the names of the tenant and roles claims
+// (AUTH_JWT_TENANT_CLAIM and AUTH_JWT_ROLES_CLAIM, default "tenant" and
+// "roles").
+func ClaimNamesFromEnv() (tenantClaim string, rolesClaim string) {
+	tenantClaim, rolesClaim = os.Getenv("AUTH_JWT_TENANT_CLAIM"), os.Getenv("AUTH_JWT_ROLES_CLAIM")
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return tenantClaim, rolesClaim
+}
+
+// ParseCertificateRoles parses AUTH_CERT_SANS: comma-separated san=roles
+// entries with roles separated by "|".
+func ParseCertificateRoles(value string) (map[string][]string, error) {
+	certRoles := make(map[string][]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid AUTH_CERT_SANS entry %q (want san=role|role)", entry)
+		}
+		san := strings.TrimSpace(entry[:i])
+		var roles []string
+		for _, role := range strings.Split(entry[i+1:], "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		certRoles[san] = roles
+	}
+	return certRoles, nil
+}
+
+// SetCertificateRoles lets clients with a verified TLS client certificate
+// authenticate without a token. A certificate whose DNS or URI SAN is a key
+// of certRoles authenticates as that SAN with its roles and no tenant; a
+// bearer token, when sent, takes precedence.
+func (a *Authenticator) SetCertificateRoles(certRoles map[string][]string) {
+	a.certRoles = certRoles
+}
+
+// Authenticate verifies the bearer token of an incoming RPC and returns the
+// context with its principal. The token may be sent with or without the
+// "Bearer " prefix. An API key is accepted as the token or as x-api-key
+// metadata.
+func (a *Authenticator) Authenticate(ctx context.Context) (context.Context, *Principal, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	var token, apiKey string
+	if values := md["authorization"]; len(values) > 0 {
+		token = trimBearer(values[0])
+	}
+	if keys := md["x-api-key"]; len(keys) > 0 {
+		apiKey = strings.TrimSpace(keys[0])
+	}
+	if IsAPIKey(token) {
+		token, apiKey = "", token
+	}
+	// A JWT takes precedence over an x-api-key sent along with it
+	if token == "" && apiKey != "" {
+		principal, err := a.VerifyAPIKey(ctx, apiKey)
+		if err != nil {
+			return ctx, nil, err
+		}
+		return WithPrincipal(ctx, principal), principal, nil
+	}
+	if token == "" {
+		if principal := a.certificatePrincipal(ctx); principal != nil {
+			return WithPrincipal(ctx, principal), principal, nil
+		}
+		if !ok {
+			return ctx, nil, fmt.Errorf("%w: metadata is not provided", ErrUnauthenticated)
+		}
+		return ctx, nil, fmt.Errorf("%w: authorization token is required", ErrUnauthenticated)
+	}
+	principal, err := a.Verify(token)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return WithPrincipal(ctx, principal), principal, nil
+}
+
+// Verify checks a bearer token and returns its principal.
+func (a *Authenticator) Verify(token string) (*Principal, error) {
+	token = trimBearer(token)
+	if a.verifier == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", ErrUnauthenticated)
+	}
+	claims, err := a.verifier.Verify(token, a.now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: %v: sub is required", ErrUnauthenticated, ErrTokenClaims)
+	}
+	principal := &Principal{
+		Subject: subject,
+		Roles:   stringListClaim(claims, a.rolesClaim),
+		token:   token,
+	}
+	principal.Tenant, _ = claims[a.tenantClaim].(string)
+	// The tenant becomes part of file keys and storage paths
+	if principal.Tenant != "" && !ValidTenant(principal.Tenant) {
+		return nil, fmt.Errorf("%w: %v: invalid tenant %q", ErrUnauthenticated, ErrTokenClaims, principal.Tenant)
+	}
+	principal.Issuer, _ = claims["iss"].(string)
+	principal.ExpiresAt, _ = numericDateClaim(claims, "exp")
+	return principal, nil
+}
+
+// trimBearer strips an optional "Bearer " prefix from a credential.
+func trimBearer(token string) string {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
+
+// certificatePrincipal returns the principal of the verified client
+// certificate of the connection, or nil. URI SANs are matched before DNS SANs.
+func (a *Authenticator) certificatePrincipal(ctx context.Context) *Principal {
+	if len(a.certRoles) == 0 {
+		return nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := info.State.VerifiedChains[0][0]
+	var sans []string
+	for _, u := range leaf.URIs {
+		sans = append(sans, u.String())
+	}
+	sans = append(sans, leaf.DNSNames...)
+	for _, san := range sans {
+		if roles, ok := a.certRoles[san]; ok {
+			return &Principal{
+				Subject:   san,
+				Roles:     append([]string(nil), roles...),
+				Issuer:    leaf.Issuer.CommonName,
+				ExpiresAt: leaf.NotAfter,
+			}
+		}
+	}
+	return nil
+}
+
+// BearerToken returns the token to send to downstream services: the caller's
+// own token when the context has a principal, otherwise the service token
+// (e.g. for work started by a queue worker).
+func BearerToken(ctx context.Context, serviceToken string) string {
+	token := serviceToken
+	if principal := PrincipalFromContext(ctx); principal != nil && principal.token != "" {
+		token = principal.token
+	}
+	if token == "" || strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		return token
+	}
+	return "Bearer " + token
+}
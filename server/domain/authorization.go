@@ -0,0 +1,400 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// ErrPermissionDenied is returned when no role of the caller grants a request.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// RolePolicy is what one role may do. Empty Namespaces or Providers allow
+// all of them; "*" in a list matches everything.
+type RolePolicy struct {
+	RPCs       []string `json:"rpcs"`                 // method names, e.g. "DeleteFile"
+	Namespaces []string `json:"namespaces,omitempty"` // storage namespaces, e.g. "images/"
+	Providers  []string `json:"providers,omitempty"`  // "s3", "gcs", "azure"
+}
+
+// AccessPolicy maps roles to the RPCs, namespaces and storage providers they
+// may use. A request is allowed when one of the caller's roles grants the
+// RPC, the namespace of its file and its provider together.
+type AccessPolicy struct {
+	Roles map[string]RolePolicy `json:"roles"`
+	// DefaultRoles apply to callers whose token has no roles.
+	DefaultRoles []string `json:"default_roles,omitempty"`
+}
+
+// AccessRequest is the part of an RPC a policy decides on.
+type AccessRequest struct {
+	Method    string // full gRPC method, e.g. "/proto.Greeter/DeleteFile"
+	Namespace string // "" when the request names no file
+	Provider  string // "" when the request names no provider
+}
+
+// AccessDecision is the outcome of a policy check.
+type AccessDecision struct {
+	Allowed bool
+	Role    string // the granting role
+	Reason  string // why it was denied
+}
+
+// ParseAccessPolicy reads a JSON policy.
+func ParseAccessPolicy(data []byte) (*AccessPolicy, error) {
+	var policy AccessPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid access policy: %w", err)
+	}
+	if len(policy.Roles) == 0 {
+		return nil, fmt.Errorf("invalid access policy: no roles")
+	}
+	for name, role := range policy.Roles {
+		if len(role.RPCs) == 0 {
+			return nil, fmt.Errorf("invalid access policy: role %q allows no rpcs", name)
+		}
+		for i, ns := range role.Namespaces {
+			if ns != "*" && !strings.HasSuffix(ns, "/") {
+				role.Namespaces[i] = ns + "/"
+			}
+		}
+	}
+	for _, name := range policy.DefaultRoles {
+		if _, ok := policy.Roles[name]; !ok {
+			return nil, fmt.Errorf("invalid access policy: unknown default role %q", name)
+		}
+	}
+	return &policy, nil
+}
+
+// Decide checks a request against the roles of a principal.
+func (p *AccessPolicy) Decide(principal *Principal, req AccessRequest) AccessDecision {
+	if principal == nil {
+		return AccessDecision{Reason: "no principal"}
+	}
+	roles := principal.Roles
+	if len(roles) == 0 {
+		roles = p.DefaultRoles
+	}
+	roles = append([]string(nil), roles...)
+	sort.Strings(roles)
+
+	rpc := req.Method[strings.LastIndex(req.Method, "/")+1:]
+	reason := "no role grants " + rpc
+	for _, name := range roles {
+		role, ok := p.Roles[name]
+		if !ok || !matchesPolicyList(role.RPCs, rpc) {
+			continue
+		}
+		if req.Namespace != "" && len(role.Namespaces) > 0 && !matchesPolicyList(role.Namespaces, req.Namespace) {
+			reason = fmt.Sprintf("role %s may not access namespace %s", name, req.Namespace)
+			continue
+		}
+		if req.Provider != "" && len(role.Providers) > 0 && !matchesPolicyList(role.Providers, req.Provider) {
+			reason = fmt.Sprintf("role %s may not access provider %s", name, req.Provider)
+			continue
+		}
+		return AccessDecision{Allowed: true, Role: name}
+	}
+	return AccessDecision{Reason: reason}
+}
+
+// VisibleNamespaces returns the namespaces the principal may access with the
+// method and provider of req. all is true when some granting role is not
+// limited to namespaces.
+func (p *AccessPolicy) VisibleNamespaces(principal *Principal, req AccessRequest) (namespaces []string, all bool) {
+	if principal == nil {
+		return nil, false
+	}
+	roles := principal.Roles
+	if len(roles) == 0 {
+		roles = p.DefaultRoles
+	}
+	rpc := req.Method[strings.LastIndex(req.Method, "/")+1:]
+	seen := map[string]bool{}
+	for _, name := range roles {
+		role, ok := p.Roles[name]
+		if !ok || !matchesPolicyList(role.RPCs, rpc) {
+			continue
+		}
+		if req.Provider != "" && len(role.Providers) > 0 && !matchesPolicyList(role.Providers, req.Provider) {
+			continue
+		}
+		if len(role.Namespaces) == 0 || matchesPolicyList(role.Namespaces, "*") {
+			return nil, true
+		}
+		for _, ns := range role.Namespaces {
+			if !seen[ns] {
+				seen[ns] = true
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, false
+}
+
+func matchesPolicyList(list []string, value string) bool {
+	for _, v := range list {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// policyCheckInterval limits how often the policy file is checked for changes.
+const policyCheckInterval = 2 * time.Second
+
+// Authorizer enforces an access policy file, reloading it when the file
+// changes. Every decision is logged.
+type Authorizer struct {
+	path  string
+	files FileMetadataRepository // see SetFileMetadata
+
+	mu      sync.Mutex
+	policy  *AccessPolicy
+	modTime time.Time
+	checked time.Time
+}
+
+// NewAuthorizer loads the policy file at path.
+func NewAuthorizer(path string) (*Authorizer, error) {
+	a := &Authorizer{path: path}
+	if _, err := a.reload(time.Now(), true); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// AuthorizerFromEnv loads AUTH_POLICY_FILE. It returns nil when the variable
+// is unset, in which case every authenticated caller may use every RPC.
+func AuthorizerFromEnv() (*Authorizer, error) {
+	path := os.Getenv("AUTH_POLICY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	return NewAuthorizer(path)
+}
+
+// SetFileMetadata makes the authorizer decide on the namespace file_metadata
+// records for a file, so rules on quarantine/ and on re-namespaced files
+// apply. Without it, the namespace follows the extension.
+func (a *Authorizer) SetFileMetadata(files FileMetadataRepository) {
+	a.files = files
+}
+
+// reload re-reads the policy when the file changed. A broken file keeps the
+// previous policy in force.
+func (a *Authorizer) reload(now time.Time, force bool) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !force && now.Sub(a.checked) < policyCheckInterval {
+		return false, nil
+	}
+	a.checked = now
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read access policy: %w", err)
+	}
+	if !force && info.ModTime().Equal(a.modTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read access policy: %w", err)
+	}
+	policy, err := ParseAccessPolicy(data)
+	if err != nil {
+		// Do not retry the same broken file until it changes again
+		a.modTime = info.ModTime()
+		return false, err
+	}
+	a.policy, a.modTime = policy, info.ModTime()
+	return true, nil
+}
+
+// Policy returns the current policy, reloading it if the file changed.
+func (a *Authorizer) Policy() *AccessPolicy {
+	reloaded, err := a.reload(time.Now(), false)
+	if err != nil {
+		log.Printf("Authz: keeping the previous access policy: %v", err)
+	} else if reloaded {
+		log.Printf("Authz: reloaded access policy from %s", a.path)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.policy
+}
+
+// Authorize decides and logs a request, returning ErrPermissionDenied when
+// it is not allowed.
+func (a *Authorizer) Authorize(principal *Principal, req AccessRequest) error {
+	decision := a.Policy().Decide(principal, req)
+	logAccessDecision(principal, req, decision)
+	if !decision.Allowed {
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, decision.Reason)
+	}
+	return nil
+}
+
+func logAccessDecision(principal *Principal, req AccessRequest, decision AccessDecision) {
+	var subject, tenant string
+	var roles []string
+	if principal != nil {
+		subject, tenant, roles = principal.Subject, principal.Tenant, principal.Roles
+	}
+	if decision.Allowed {
+		log.Printf("Authz allow: method=%s sub=%s tenant=%s roles=%v namespace=%s provider=%s role=%s",
+			req.Method, subject, tenant, roles, req.Namespace, req.Provider, decision.Role)
+		return
+	}
+	log.Printf("Authz deny: method=%s sub=%s tenant=%s roles=%v namespace=%s provider=%s reason=%s",
+		req.Method, subject, tenant, roles, req.Namespace, req.Provider, decision.Reason)
+}
+
+// RequestFile returns the filename and storage provider of an RPC message.
+// The provider is "s3" for an empty provider field, as the services default
+// to it, and fallbackProvider for messages without one, e.g. the
+// storage-provider metadata of UploadFile.
+func RequestFile(msg interface{}, fallbackProvider string) (filename string, provider string) {
+	provider = fallbackProvider
+	if m, ok := msg.(interface{ GetFilename() string }); ok {
+		filename = m.GetFilename()
+	}
+	if m, ok := msg.(interface{ GetStorageProvider() string }); ok {
+		provider = m.GetStorageProvider()
+		if provider == "" {
+			provider = "s3"
+		}
+	}
+	return filename, provider
+}
+
+// FileNamespace returns the namespace a file of the caller is stored under:
+// the one file_metadata records, which differs from the extension's for
+// quarantined and re-namespaced files, or that of the extension for files
+// it does not know (e.g. new uploads).
+func (a *Authorizer) FileNamespace(ctx context.Context, filename string, provider string) string {
+	if a.files != nil {
+		if provider == "" {
+			provider = "s3"
+		}
+		file, err := a.files.FindByFilename(ctx, ScopeFilename(ctx, filename), provider)
+		if err != nil {
+			log.Printf("Authz: namespace of %s falls back to its extension: %v", filename, err)
+		} else if file != nil && file.Namespace != "" {
+			return strings.TrimSuffix(file.Namespace, "/") + "/"
+		}
+	}
+	return GetFileNamespace(filename)
+}
+
+// AccessRequestFor builds the access request of an RPC message: the
+// namespace its file is stored under (see FileNamespace) and its storage
+// provider (see RequestFile).
+func (a *Authorizer) AccessRequestFor(ctx context.Context, method string, msg interface{}, fallbackProvider string) AccessRequest {
+	filename, provider := RequestFile(msg, fallbackProvider)
+	req := AccessRequest{Method: method, Provider: provider}
+	if filename != "" {
+		req.Namespace = a.FileNamespace(ctx, filename, provider)
+	}
+	return req
+}
+
+// AuthorizeFilenames checks every file a request lists, e.g. the filenames
+// of EvaluateOCR.
+func (a *Authorizer) AuthorizeFilenames(ctx context.Context, principal *Principal, req AccessRequest, filenames []string) error {
+	for _, filename := range filenames {
+		fileReq := req
+		fileReq.Namespace = a.FileNamespace(ctx, filename, req.Provider)
+		if err := a.Authorize(principal, fileReq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScopeNamespaces returns a context restricting the searches of a request to
+// the namespaces the principal may access (see NamespaceScope), so that
+// totals and facet counts only cover visible files.
+func (a *Authorizer) ScopeNamespaces(ctx context.Context, principal *Principal, req AccessRequest) context.Context {
+	namespaces, all := a.Policy().VisibleNamespaces(principal, req)
+	if all {
+		return ctx
+	}
+	return context.WithValue(ctx, namespaceScopeKey{}, namespaces)
+}
+
+type namespaceScopeKey struct{}
+
+// NamespaceScope returns the namespaces the caller's searches are restricted
+// to, and whether they are restricted at all.
+func NamespaceScope(ctx context.Context) ([]string, bool) {
+	namespaces, ok := ctx.Value(namespaceScopeKey{}).([]string)
+	return namespaces, ok
+}
+
+// FilterResponse removes the files of a listing the principal may not see:
+// files of ListFiles, results of ListOCRResults and documents of
+// SearchDocuments, whose facets are counted over the scope of
+// ScopeNamespaces. It returns how many entries were removed.
+func (a *Authorizer) FilterResponse(ctx context.Context, principal *Principal, req AccessRequest, resp interface{}) int {
+	policy := a.Policy()
+	visibleIn := func(namespace string) bool {
+		fileReq := req
+		fileReq.Namespace = namespace
+		return policy.Decide(principal, fileReq).Allowed
+	}
+	visible := func(filename string, provider string) bool {
+		if provider == "" {
+			provider = req.Provider
+		}
+		return visibleIn(a.FileNamespace(ctx, filename, provider))
+	}
+	removed := 0
+	switch r := resp.(type) {
+	case *pb.FileListResponse:
+		files := r.Files[:0]
+		for _, f := range r.Files {
+			namespace := f.GetNamespace()
+			if namespace == "" {
+				namespace = a.FileNamespace(ctx, f.GetFilename(), req.Provider)
+			}
+			if visibleIn(strings.TrimSuffix(namespace, "/") + "/") {
+				files = append(files, f)
+			}
+		}
+		removed, r.Files = len(r.Files)-len(files), files
+	case *pb.OCRListResponse:
+		results := r.Results[:0]
+		for _, s := range r.Results {
+			if visible(s.GetFilename(), "") {
+				results = append(results, s)
+			}
+		}
+		removed, r.Results = len(r.Results)-len(results), results
+	case *pb.SearchDocumentsResponse:
+		docs := r.Documents[:0]
+		for _, d := range r.Documents {
+			if visible(d.GetFilename(), d.GetStorageProvider()) {
+				docs = append(docs, d)
+			}
+		}
+		removed, r.Documents = len(r.Documents)-len(docs), docs
+		r.Total -= int32(removed)
+	}
+	if removed > 0 {
+		log.Printf("Authz filter: method=%s sub=%s removed %d entries outside the caller's namespaces", req.Method, principal.Subject, removed)
+	}
+	return removed
+}
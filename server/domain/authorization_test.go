@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func loadExamplePolicy(t *testing.T) *AccessPolicy {
+	t.Helper()
+	data, err := os.ReadFile("../policies/access_policy.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ParseAccessPolicy(data)
+	if err != nil {
+		t.Fatalf("ParseAccessPolicy: %v", err)
+	}
+	return policy
+}
+
+func TestAccessPolicyDecide(t *testing.T) {
+	policy := loadExamplePolicy(t)
+	// A role limited to the namespace the contractor may not use
+	policy.Roles["uploader"] = RolePolicy{RPCs: []string{"UploadFile"}, Namespaces: []string{"documents/"}}
+
+	user := func(roles ...string) *Principal { return &Principal{Subject: "alice", Tenant: "acme", Roles: roles} }
+	method := func(rpc string) string { return "/greeter.Greeter/" + rpc }
+	for _, tt := range []struct {
+		name      string
+		principal *Principal
+		req       AccessRequest
+		allowed   bool
+		role      string
+	}{
+		{"admin wildcard", user("admin"), AccessRequest{Method: method("DeleteFile"), Namespace: "images/", Provider: "gcs"}, true, "admin"},
+		{"editor rpc", user("editor"), AccessRequest{Method: method("UploadFile"), Namespace: "documents/", Provider: "s3"}, true, "editor"},
+		{"editor may not delete", user("editor"), AccessRequest{Method: method("DeleteFile")}, false, ""},
+		{"default roles without roles", user(), AccessRequest{Method: method("ListFiles")}, true, "viewer"},
+		{"default roles do not upload", user(), AccessRequest{Method: method("UploadFile")}, false, ""},
+		{"unknown role", user("superuser"), AccessRequest{Method: method("ListFiles")}, false, ""},
+		{"contractor in its namespace and provider", user("contractor"), AccessRequest{Method: method("UploadFile"), Namespace: "images/", Provider: "s3"}, true, "contractor"},
+		{"contractor outside its namespace", user("contractor"), AccessRequest{Method: method("UploadFile"), Namespace: "documents/", Provider: "s3"}, false, ""},
+		{"contractor on another provider", user("contractor"), AccessRequest{Method: method("DownloadFile"), Namespace: "images/", Provider: "azure"}, false, ""},
+		{"contractor request without a file", user("contractor"), AccessRequest{Method: method("ListFiles")}, true, "contractor"},
+		{"second role grants what the first does not", user("contractor", "uploader"), AccessRequest{Method: method("UploadFile"), Namespace: "documents/", Provider: "s3"}, true, "uploader"},
+		{"roles are tried in sorted order", user("viewer", "editor"), AccessRequest{Method: method("ListFiles")}, true, "editor"},
+		{"no principal", nil, AccessRequest{Method: method("SayHello")}, false, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Decide(tt.principal, tt.req)
+			if decision.Allowed != tt.allowed || decision.Role != tt.role {
+				t.Fatalf("Decide = %+v, want allowed %v by %q", decision, tt.allowed, tt.role)
+			}
+			if !decision.Allowed && decision.Reason == "" {
+				t.Fatalf("Decide denied without a reason")
+			}
+		})
+	}
+}
+
+func TestAccessPolicyVisibleNamespaces(t *testing.T) {
+	policy := loadExamplePolicy(t)
+	policy.Roles["filer"] = RolePolicy{RPCs: []string{"ListFiles"}, Namespaces: []string{"documents/", "images/"}}
+	for _, tt := range []struct {
+		name       string
+		roles      []string
+		provider   string
+		namespaces []string
+		all        bool
+	}{
+		{"unlimited role", []string{"viewer"}, "", nil, true},
+		{"limited role", []string{"contractor"}, "s3", []string{"images/"}, false},
+		{"limited role on another provider", []string{"contractor"}, "gcs", nil, false},
+		{"union of limited roles", []string{"contractor", "filer"}, "", []string{"documents/", "images/"}, false},
+		{"any unlimited role wins", []string{"contractor", "editor"}, "s3", nil, true},
+		{"no granting role", []string{"superuser"}, "", nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			namespaces, all := policy.VisibleNamespaces(&Principal{Roles: tt.roles}, AccessRequest{Method: "/greeter.Greeter/ListFiles", Provider: tt.provider})
+			if all != tt.all || !reflect.DeepEqual(namespaces, tt.namespaces) {
+				t.Fatalf("VisibleNamespaces = %q, all %v; want %q, all %v", namespaces, all, tt.namespaces, tt.all)
+			}
+		})
+	}
+}
+
+func TestParseAccessPolicy(t *testing.T) {
+	policy, err := ParseAccessPolicy([]byte(`{"roles": {"r": {"rpcs": ["ListFiles"], "namespaces": ["images", "*"]}}}`))
+	if err != nil {
+		t.Fatalf("ParseAccessPolicy: %v", err)
+	}
+	if got := policy.Roles["r"].Namespaces; !reflect.DeepEqual(got, []string{"images/", "*"}) {
+		t.Fatalf("namespaces = %q, want a trailing slash added", got)
+	}
+	for _, bad := range []string{
+		`not json`,
+		`{"roles": {}}`,
+		`{"roles": {"r": {"rpcs": []}}}`,
+		`{"roles": {"r": {"rpcs": ["*"]}}, "default_roles": ["viewer"]}`,
+	} {
+		if _, err := ParseAccessPolicy([]byte(bad)); err == nil {
+			t.Errorf("ParseAccessPolicy(%s) succeeded, want an error", bad)
+		}
+	}
+}
@@ -174,10 +174,7 @@ func (q *azureQueueService) EnqueueOCRTask(ctx context.Context, filename string,
 	log.Printf("DEBUG: Azure Queue client obtained successfully for file=%s", filename)
 
 	// OCRTask?JSON???????
-	task := &OCRTask{
-		Filename:        filename,
-		StorageProvider: storageProvider,
-	}
+	task := NewOCRTask(filename, storageProvider)
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
 		return fmt.Errorf("failed to marshal OCR task: %w", err)
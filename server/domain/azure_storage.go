@@ -10,9 +10,12 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 
 	pb "grpc-sample-minimal/proto"
 )
@@ -90,19 +93,13 @@ func NewAzureStorageService(ctx context.Context) (StorageService, error) {
 		}
 	}
 
-	return &azureStorageService{
+	return withEncryption(&azureStorageService{
 		blobClient:     blobClient,
 		containerName: containerName,
-	}, nil
+	})
 }
 
 func (s *azureStorageService) UploadFile(ctx context.Context, filename string, content io.Reader) (*pb.FileUploadStatus, error) {
-	// Read content into bytes
-	contentBytes, err := io.ReadAll(content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read content for Azure upload: %w", err)
-	}
-
 	// Build storage path with namespace prefix (documents/, media/, or others/)
 	storagePath := BuildStoragePath(filename)
 
@@ -111,8 +108,8 @@ func (s *azureStorageService) UploadFile(ctx context.Context, filename string, c
 	containerClient := serviceClient.NewContainerClient(s.containerName)
 	blockBlobClient := containerClient.NewBlockBlobClient(storagePath)
 
-	// Upload the buffer
-	_, err = blockBlobClient.UploadBuffer(ctx, contentBytes, nil)
+	// Stream the content in blocks
+	_, err := blockBlobClient.UploadStream(ctx, content, azureUploadStreamOptions())
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload file to Azure Blob Storage: %w", err)
 	}
@@ -177,21 +174,76 @@ func (s *azureStorageService) DownloadFileByPath(ctx context.Context, storagePat
 	return bytes.NewReader(data), nil
 }
 
+func (s *azureStorageService) UploadFileByPath(ctx context.Context, storagePath string, content io.Reader) error {
+	serviceClient := s.blobClient.ServiceClient()
+	containerClient := serviceClient.NewContainerClient(s.containerName)
+	blockBlobClient := containerClient.NewBlockBlobClient(storagePath)
+
+	if _, err := blockBlobClient.UploadStream(ctx, content, azureUploadStreamOptions()); err != nil {
+		return fmt.Errorf("failed to upload file to Azure Blob Storage: %w", err)
+	}
+	return nil
+}
+
+// azureUploadBlockSize is the block size of streamed uploads. A blob has at
+// most 50,000 blocks, which bounds uploads to about 195 GiB.
+const azureUploadBlockSize = 4 << 20
+
+// azureUploadStreamOptions streams uploads one block at a time, so at most
+// one block is held in memory. The blocks are committed once the content
+// ends, so a failed upload leaves the previous blob in place.
+func azureUploadStreamOptions() *azblob.UploadStreamOptions {
+	return &azblob.UploadStreamOptions{BlockSize: azureUploadBlockSize, Concurrency: 1}
+}
+
 func (s *azureStorageService) DeleteFile(ctx context.Context, filename string) error {
 	// Build storage path with namespace prefix
-	storagePath := BuildStoragePath(filename)
+	return s.DeleteFileByPath(ctx, BuildStoragePath(filename))
+}
 
+func (s *azureStorageService) DeleteFileByPath(ctx context.Context, storagePath string) error {
 	// Get block blob client
 	serviceClient := s.blobClient.ServiceClient()
 	containerClient := serviceClient.NewContainerClient(s.containerName)
 	blockBlobClient := containerClient.NewBlockBlobClient(storagePath)
 
-	if _, err := blockBlobClient.Delete(ctx, nil); err != nil {
+	// Like S3, deleting a missing blob succeeds
+	if _, err := blockBlobClient.Delete(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
 		return fmt.Errorf("failed to delete file from Azure Blob Storage: %w", err)
 	}
 	return nil
 }
 
+// SignedUploadURL returns a blob SAS URL with create and write permission.
+// The SAS is signed with the account key, so it needs a client created from
+// a connection string with one.
+func (s *azureStorageService) SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error) {
+	expiresAt := time.Now().Add(expires)
+	blobClient := s.blobClient.ServiceClient().NewContainerClient(s.containerName).NewBlobClient(storagePath)
+	signed, err := blobClient.GetSASURL(sas.BlobPermissions{Create: true, Write: true}, expiresAt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure upload SAS: %w", err)
+	}
+	headers := map[string]string{"x-ms-blob-type": "BlockBlob"}
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	return &SignedURL{URL: signed, Method: http.MethodPut, Headers: headers, ExpiresAt: expiresAt, Direct: true}, nil
+}
+
+// SignedDownloadURL returns a blob SAS URL with read permission. Downloads
+// are named after the blob, as a SAS from GetSASURL cannot override the
+// Content-Disposition.
+func (s *azureStorageService) SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error) {
+	expiresAt := time.Now().Add(expires)
+	blobClient := s.blobClient.ServiceClient().NewContainerClient(s.containerName).NewBlobClient(storagePath)
+	signed, err := blobClient.GetSASURL(sas.BlobPermissions{Read: true}, expiresAt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure download SAS: %w", err)
+	}
+	return &SignedURL{URL: signed, Method: http.MethodGet, ExpiresAt: expiresAt, Direct: true}, nil
+}
+
 func (s *azureStorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, error) {
 	var files []*pb.FileInfo
 	
@@ -210,7 +262,7 @@ func (s *azureStorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, er
 			name := *blob.Name
 			
 			// Skip directory prefixes (names ending with "/")
-			if strings.HasSuffix(name, "/") {
+			if strings.HasSuffix(name, "/") || IsDerivedStoragePath(name) {
 				continue
 			}
 			
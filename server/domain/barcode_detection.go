@@ -0,0 +1,164 @@
+package domain
+
+import (
+	"context"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// Barcode symbologies reported by the built-in detector.
+const (
+	SymbologyQRCode  = "qr_code"
+	SymbologyCode128 = "code_128"
+	SymbologyCode39  = "code_39"
+	SymbologyEAN13   = "ean_13"
+	SymbologyEAN8    = "ean_8"
+	SymbologyUPCA    = "upc_a"
+)
+
+// DetectedBarcode is a barcode or QR code decoded from a page image.
+type DetectedBarcode struct {
+	PageNumber int         `json:"page_number"`
+	Symbology  string      `json:"symbology"`
+	Payload    string      `json:"payload"` // text; "" when the content is not text (see Raw)
+	Raw        []byte      `json:"raw,omitempty"`
+	BBox       BoundingBox `json:"bbox"` // in page image pixels
+}
+
+// BarcodeDetector finds barcodes in page images. It runs next to the OCR
+// engines, which do not read them.
+type BarcodeDetector interface {
+	Name() string
+	Detect(ctx context.Context, img image.Image) ([]DetectedBarcode, error)
+}
+
+// BarcodeDetectionEnabled reports whether pages are scanned for barcodes
+// (OCR_BARCODE_DETECTION, on by default).
+func BarcodeDetectionEnabled() bool {
+	switch strings.ToLower(os.Getenv("OCR_BARCODE_DETECTION")) {
+	case "false", "0", "off", "no":
+		return false
+	}
+	return true
+}
+
+// builtinBarcodeDetector decodes QR codes and the common linear symbologies
+// (Code 128, Code 39, EAN-13/UPC-A, EAN-8) in pure Go.
+type builtinBarcodeDetector struct{}
+
+// NewBarcodeDetector returns the built-in pure Go detector.
+func NewBarcodeDetector() BarcodeDetector {
+	return builtinBarcodeDetector{}
+}
+
+func (builtinBarcodeDetector) Name() string { return "builtin" }
+
+func (builtinBarcodeDetector) Detect(ctx context.Context, img image.Image) ([]DetectedBarcode, error) {
+	bits := binarizeForBarcodes(img)
+	found := detectQRCodes(bits)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	found = append(found, detectLinearBarcodes(bits)...)
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].BBox.Y0 != found[j].BBox.Y0 {
+			return found[i].BBox.Y0 < found[j].BBox.Y0
+		}
+		return found[i].BBox.X0 < found[j].BBox.X0
+	})
+	return found, nil
+}
+
+// detectDocumentBarcodes scans each page image once and attaches the
+// barcodes to every successful result. Documents without page images
+// (Office files) are skipped.
+func detectDocumentBarcodes(ctx context.Context, detector BarcodeDetector, pages *documentPages, results map[string]*OCRResult) {
+	if err := pages.render(ctx); err != nil || len(pages.images) == 0 {
+		return
+	}
+	var barcodes []DetectedBarcode
+	for i := range pages.images {
+		found, err := detector.Detect(ctx, pages.images[i])
+		if err != nil {
+			log.Printf("Barcode detection failed on page %d of %s: %v", i+1, pages.filename, err)
+			continue
+		}
+		for _, b := range found {
+			b.PageNumber = i + 1
+			barcodes = append(barcodes, b)
+		}
+	}
+	if len(barcodes) == 0 {
+		return
+	}
+	log.Printf("Detected %d barcode(s) in %s", len(barcodes), pages.filename)
+	for _, result := range results {
+		if result == nil || result.Status == "failed" || result.Error != nil {
+			continue
+		}
+		result.Barcodes = barcodes
+	}
+}
+
+// BarcodesToProto converts detected barcodes to their messages.
+func BarcodesToProto(barcodes []DetectedBarcode) []*pb.Barcode {
+	out := make([]*pb.Barcode, 0, len(barcodes))
+	for _, b := range barcodes {
+		out = append(out, &pb.Barcode{
+			PageNumber: int32(b.PageNumber),
+			Symbology:  b.Symbology,
+			Payload:    b.Payload,
+			Raw:        b.Raw,
+			Bbox:       boundingBoxToProto(b.BBox),
+		})
+	}
+	return out
+}
+
+// bitImage is a binarized image; true is black.
+type bitImage struct {
+	width, height int
+	black         []bool
+}
+
+func (b *bitImage) get(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return false
+	}
+	return b.black[y*b.width+x]
+}
+
+// binarizeForBarcodes thresholds an image with Otsu's method.
+func binarizeForBarcodes(img image.Image) *bitImage {
+	g := toGray(img)
+	threshold := otsuThreshold(g)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	bits := &bitImage{width: w, height: h, black: make([]bool, w*h)}
+	for y := 0; y < h; y++ {
+		row := g.Pix[y*g.Stride : y*g.Stride+w]
+		for x, v := range row {
+			bits.black[y*w+x] = v <= threshold
+		}
+	}
+	return bits
+}
+
+// lineRuns returns the run lengths of a scan line, starting with a white run
+// (possibly empty), alternating white and black.
+func lineRuns(n int, black func(i int) bool) []int {
+	runs := []int{0}
+	color := false
+	for i := 0; i < n; i++ {
+		if black(i) != color {
+			runs = append(runs, 0)
+			color = !color
+		}
+		runs[len(runs)-1]++
+	}
+	return runs
+}
@@ -0,0 +1,541 @@
+package domain
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// linearMatch is a barcode read on one scan line; first and last are the run
+// indexes of its first and last bar.
+type linearMatch struct {
+	symbology string
+	payload   string
+	first     int
+	last      int
+}
+
+// linearRead is a linearMatch placed on the page.
+type linearRead struct {
+	symbology, payload string
+	vertical           bool
+	line               int // row, or column for vertical reads
+	start, end         int // along the line
+}
+
+// linearScanStep is the distance between scan lines in pixels.
+const linearScanStep = 2
+
+// detectLinearBarcodes reads every other row and column and keeps the
+// barcodes read identically on enough neighboring lines.
+func detectLinearBarcodes(bits *bitImage) []DetectedBarcode {
+	var reads []linearRead
+	for y := 0; y < bits.height; y += linearScanStep {
+		for _, m := range decodeLinearLine(bits.width, func(x int) bool { return bits.black[y*bits.width+x] }) {
+			m.line = y
+			reads = append(reads, m)
+		}
+	}
+	for x := 0; x < bits.width; x += linearScanStep {
+		for _, m := range decodeLinearLine(bits.height, func(y int) bool { return bits.black[y*bits.width+x] }) {
+			m.vertical, m.line = true, x
+			reads = append(reads, m)
+		}
+	}
+	return groupLinearReads(reads)
+}
+
+// decodeLinearLine reads a scan line in both directions.
+func decodeLinearLine(n int, black func(i int) bool) []linearRead {
+	runs := lineRuns(n, black)
+	if len(runs) < 20 {
+		return nil
+	}
+	offsets := make([]int, len(runs)+1)
+	for i, r := range runs {
+		offsets[i+1] = offsets[i] + r
+	}
+	var reads []linearRead
+	for _, m := range decodeLinearRuns(runs) {
+		reads = append(reads, linearRead{symbology: m.symbology, payload: m.payload, start: offsets[m.first], end: offsets[m.last+1]})
+	}
+
+	// Reversed, so that upside-down barcodes read too. The reversed line
+	// must also start with a white run.
+	reversed := make([]int, 0, len(runs)+1)
+	if len(runs)%2 == 0 {
+		reversed = append(reversed, 0)
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		reversed = append(reversed, runs[i])
+	}
+	shift := len(reversed) - len(runs) // 1 when a white run was added
+	for _, m := range decodeLinearRuns(reversed) {
+		// Run k of reversed is run len(runs)-1-(k-shift) of runs.
+		first := len(runs) - 1 - (m.last - shift)
+		last := len(runs) - 1 - (m.first - shift)
+		reads = append(reads, linearRead{symbology: m.symbology, payload: m.payload, start: offsets[first], end: offsets[last+1]})
+	}
+	return reads
+}
+
+// decodeLinearRuns tries each symbology on a line's runs (white first).
+func decodeLinearRuns(runs []int) []linearMatch {
+	var matches []linearMatch
+	matches = append(matches, decodeCode128(runs)...)
+	matches = append(matches, decodeEAN(runs)...)
+	matches = append(matches, decodeCode39(runs)...)
+	return matches
+}
+
+// minLinearReads is the number of scan lines a barcode must be read on.
+// Code 39 has no mandatory check character and needs more agreement.
+func minLinearReads(symbology string) int {
+	if symbology == SymbologyCode39 {
+		return 3
+	}
+	return 2
+}
+
+// groupLinearReads clusters identical reads on nearby, overlapping lines.
+func groupLinearReads(reads []linearRead) []DetectedBarcode {
+	type group struct {
+		read             linearRead
+		count            int
+		minLine, maxLine int
+		minStart, maxEnd int
+	}
+	var groups []*group
+	for _, r := range reads {
+		var g *group
+		for _, c := range groups {
+			if c.read.symbology == r.symbology && c.read.payload == r.payload && c.read.vertical == r.vertical &&
+				r.line-c.maxLine <= linearScanStep*4 && r.start < c.maxEnd && c.minStart < r.end {
+				g = c
+				break
+			}
+		}
+		if g == nil {
+			groups = append(groups, &group{read: r, count: 1, minLine: r.line, maxLine: r.line, minStart: r.start, maxEnd: r.end})
+			continue
+		}
+		if r.line != g.maxLine {
+			g.count++
+		}
+		g.maxLine = max(g.maxLine, r.line)
+		g.minStart = min(g.minStart, r.start)
+		g.maxEnd = max(g.maxEnd, r.end)
+	}
+
+	var out []DetectedBarcode
+	seen := map[string]bool{}
+	for _, g := range groups {
+		if g.count < minLinearReads(g.read.symbology) {
+			continue
+		}
+		box := BoundingBox{X0: g.minStart, Y0: g.minLine, X1: g.maxEnd, Y1: g.maxLine + 1}
+		if g.read.vertical {
+			box = BoundingBox{X0: g.minLine, Y0: g.minStart, X1: g.maxLine + 1, Y1: g.maxEnd}
+		}
+		// A barcode read both ways, or in both orientations, is reported once.
+		key := g.read.symbology + "\x00" + g.read.payload
+		if seen[key] && overlapsDetected(out, key, box) {
+			continue
+		}
+		seen[key] = true
+		out = append(out, DetectedBarcode{Symbology: g.read.symbology, Payload: g.read.payload, Raw: []byte(g.read.payload), BBox: box})
+	}
+	return out
+}
+
+// overlapsDetected reports whether a barcode with the same content overlaps box.
+func overlapsDetected(found []DetectedBarcode, key string, box BoundingBox) bool {
+	for _, f := range found {
+		if f.Symbology+"\x00"+f.Payload == key && box.X0 < f.BBox.X1 && f.BBox.X0 < box.X1 && box.Y0 < f.BBox.Y1 && f.BBox.Y0 < box.Y1 {
+			return true
+		}
+	}
+	return false
+}
+
+// patternVariance compares run widths to a pattern in modules. It returns the
+// average deviation per module, or +Inf when a single run is off by more than
+// maxIndividual modules.
+func patternVariance(runs []int, pattern []int, maxIndividual float64) float64 {
+	total, modules := 0, 0
+	for i, r := range runs {
+		total += r
+		modules += pattern[i]
+	}
+	if total < modules {
+		return math.Inf(1) // narrower than one pixel per module
+	}
+	unit := float64(total) / float64(modules)
+	var variance float64
+	for i, r := range runs {
+		d := math.Abs(float64(r)/unit - float64(pattern[i]))
+		if d > maxIndividual {
+			return math.Inf(1)
+		}
+		variance += d
+	}
+	return variance / float64(modules)
+}
+
+// bestPattern returns the index of the closest pattern, or -1 when none is
+// within maxAverage.
+func bestPattern(runs []int, patterns [][]int, maxAverage, maxIndividual float64) int {
+	best, bestVariance := -1, maxAverage
+	for i, p := range patterns {
+		if v := patternVariance(runs, p, maxIndividual); v < bestVariance {
+			best, bestVariance = i, v
+		}
+	}
+	return best
+}
+
+// quietBefore reports whether the white run before a barcode is at least
+// modules wide; the start of the line counts as quiet.
+func quietBefore(runs []int, i int, unit float64, modules float64) bool {
+	return i == 1 && runs[0] == 0 || float64(runs[i-1]) >= unit*modules
+}
+
+// quietAfter is quietBefore for the white run after run i.
+func quietAfter(runs []int, i int, unit float64, modules float64) bool {
+	return i+1 >= len(runs) || float64(runs[i+1]) >= unit*modules
+}
+
+func sumRuns(runs []int) int {
+	total := 0
+	for _, r := range runs {
+		total += r
+	}
+	return total
+}
+
+// code128Patterns are the bar/space widths of Code 128 values 0-105; 106 is
+// the stop pattern without its final bar.
+var code128Patterns = func() [][]int {
+	specs := strings.Fields(`
+		212222 222122 222221 121223 121322 131222 122213 122312 132212 221213
+		221312 231212 112232 122132 122231 113222 123122 123221 223211 221132
+		221231 213212 223112 312131 311222 321122 321221 312212 322112 322211
+		212123 212321 232121 111323 131123 131321 112313 132113 132311 211313
+		231113 231311 112133 112331 132131 113123 113321 133121 313121 211331
+		231131 213113 213311 213131 311123 311321 331121 312113 312311 332111
+		314111 221411 431111 111224 111422 121124 121421 141122 141221 112214
+		112412 122114 122411 142112 142211 241211 221114 413111 241112 134111
+		111242 121142 121241 114212 124112 124211 411212 421112 421211 212141
+		214121 412121 111143 111341 131141 114113 114311 411113 411311 113141
+		114131 311141 411131 211412 211214 211232 233111`)
+	patterns := make([][]int, len(specs))
+	for i, spec := range specs {
+		for _, c := range spec {
+			patterns[i] = append(patterns[i], int(c-'0'))
+		}
+	}
+	return patterns
+}()
+
+const (
+	code128StartA = 103
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// decodeCode128 finds Code 128 symbols: a start code, data, a modulo 103 check
+// value and the stop pattern.
+func decodeCode128(runs []int) []linearMatch {
+	var matches []linearMatch
+	for i := 1; i+6 < len(runs); i += 2 {
+		start := bestPattern(runs[i:i+6], code128Patterns[code128StartA:code128StartC+1], 0.25, 0.7)
+		if start < 0 {
+			continue
+		}
+		unit := float64(sumRuns(runs[i:i+6])) / 11
+		if !quietBefore(runs, i, unit, 5) {
+			continue
+		}
+		values := []int{code128StartA + start}
+		pos := i + 6
+		stopped := false
+		for pos+6 < len(runs)+1 && pos+6 <= len(runs) {
+			v := bestPattern(runs[pos:pos+6], code128Patterns, 0.25, 0.7)
+			if v < 0 {
+				break
+			}
+			if v == code128Stop {
+				// The stop pattern ends with a 2-module bar.
+				if pos+6 < len(runs) && math.Abs(float64(runs[pos+6])/unit-2) < 1 && quietAfter(runs, pos+6, unit, 5) {
+					stopped = true
+				}
+				break
+			}
+			if v >= code128StartA {
+				break
+			}
+			values = append(values, v)
+			pos += 6
+		}
+		if !stopped || len(values) < 3 {
+			continue
+		}
+		checksum := values[0]
+		for k := 1; k < len(values)-1; k++ {
+			checksum += k * values[k]
+		}
+		if checksum%103 != values[len(values)-1] {
+			continue
+		}
+		payload, ok := code128Text(values[0], values[1:len(values)-1])
+		if !ok {
+			continue
+		}
+		matches = append(matches, linearMatch{symbology: SymbologyCode128, payload: payload, first: i, last: pos + 6})
+		i = pos + 6
+	}
+	return matches
+}
+
+// code128Text decodes the data values of a Code 128 symbol. FNC1 in first
+// position (GS1-128) is dropped; later FNC1s become the GS separator.
+func code128Text(start int, values []int) (string, bool) {
+	const setA, setB, setC = 0, 1, 2
+	set := start - code128StartA
+	var b strings.Builder
+	shift := false
+	for k, v := range values {
+		current := set
+		if shift {
+			current = setA + setB - set // A <-> B for one character
+			shift = false
+		}
+		switch {
+		case current == setC && v < 100:
+			b.WriteByte(byte('0' + v/10))
+			b.WriteByte(byte('0' + v%10))
+		case current == setA && v < 64:
+			b.WriteByte(byte(32 + v))
+		case current == setA && v < 96:
+			b.WriteByte(byte(v - 64))
+		case current == setB && v < 96:
+			b.WriteByte(byte(32 + v))
+		case v == 102: // FNC1
+			if k > 0 {
+				b.WriteByte(0x1d)
+			}
+		case v == 98 && current != setC:
+			shift = true
+		case v == 99 && current != setC:
+			set = setC
+		case v == 100 && current == setA, v == 100 && current == setC:
+			set = setB
+		case v == 101 && current != setA:
+			set = setA
+		case v == 96, v == 97, v == 100, v == 101:
+			// FNC2-FNC4 carry no text
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// eanDigitPatterns are the space/bar widths of the L code digits; R codes
+// have the same widths starting with a bar, G codes are L reversed.
+var eanDigitPatterns = [][]int{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+// eanLGPatterns are the L codes (0-9) followed by the G codes (10-19).
+var eanLGPatterns = func() [][]int {
+	patterns := append([][]int(nil), eanDigitPatterns...)
+	for _, p := range eanDigitPatterns {
+		patterns = append(patterns, []int{p[3], p[2], p[1], p[0]})
+	}
+	return patterns
+}()
+
+// eanFirstDigitParity is the L/G parity of the left half for each implied
+// first digit of an EAN-13 (bit set = G, first digit in the high bit).
+var eanFirstDigitParity = []int{0x00, 0x0B, 0x0D, 0x0E, 0x13, 0x19, 0x1C, 0x15, 0x16, 0x1A}
+
+// decodeEAN finds EAN-13 (reported as UPC-A when the first digit is 0) and
+// EAN-8 symbols.
+func decodeEAN(runs []int) []linearMatch {
+	var matches []linearMatch
+	for i := 1; i+42 < len(runs); i += 2 {
+		guard := runs[i : i+3]
+		unit := float64(sumRuns(guard)) / 3
+		if patternVariance(guard, []int{1, 1, 1}, 0.7) > 0.48 || !quietBefore(runs, i, unit, 5) {
+			continue
+		}
+		if m, ok := decodeEANDigits(runs, i, 6); ok {
+			matches = append(matches, m)
+			i = m.last
+			continue
+		}
+		if m, ok := decodeEANDigits(runs, i, 4); ok {
+			matches = append(matches, m)
+			i = m.last
+		}
+	}
+	return matches
+}
+
+// decodeEANDigits reads an EAN symbol with half digits per half starting at
+// the start guard in run i.
+func decodeEANDigits(runs []int, i int, half int) (linearMatch, bool) {
+	middle := i + 3 + half*4
+	end := middle + 5 + half*4
+	if end+3 > len(runs) {
+		return linearMatch{}, false
+	}
+	unit := float64(sumRuns(runs[i:end+3])) / float64(3+half*7+5+half*7+3)
+	if patternVariance(runs[middle:middle+5], []int{1, 1, 1, 1, 1}, 0.7) > 0.48 ||
+		patternVariance(runs[end:end+3], []int{1, 1, 1}, 0.7) > 0.48 ||
+		!quietAfter(runs, end+2, unit, 5) {
+		return linearMatch{}, false
+	}
+	digits := make([]byte, 0, half*2+1)
+	parity := 0
+	for k := 0; k < half; k++ {
+		p := bestPattern(runs[i+3+k*4:i+7+k*4], eanLGPatterns, 0.48, 0.7)
+		if p < 0 || (half == 4 && p >= 10) {
+			return linearMatch{}, false
+		}
+		parity <<= 1
+		if p >= 10 {
+			parity |= 1
+			p -= 10
+		}
+		digits = append(digits, byte('0'+p))
+	}
+	for k := 0; k < half; k++ {
+		p := bestPattern(runs[middle+5+k*4:middle+9+k*4], eanDigitPatterns, 0.48, 0.7)
+		if p < 0 {
+			return linearMatch{}, false
+		}
+		digits = append(digits, byte('0'+p))
+	}
+
+	symbology := SymbologyEAN8
+	if half == 6 {
+		first := -1
+		for d, pattern := range eanFirstDigitParity {
+			if pattern == parity {
+				first = d
+			}
+		}
+		if first < 0 {
+			return linearMatch{}, false
+		}
+		digits = append([]byte{byte('0' + first)}, digits...)
+		symbology = SymbologyEAN13
+	}
+	if !eanChecksumValid(digits) {
+		return linearMatch{}, false
+	}
+	payload := string(digits)
+	if symbology == SymbologyEAN13 && payload[0] == '0' {
+		symbology, payload = SymbologyUPCA, payload[1:]
+	}
+	return linearMatch{symbology: symbology, payload: payload, first: i, last: end + 2}, true
+}
+
+// eanChecksumValid checks the last digit: weights 3 and 1 alternate from the
+// digit before it.
+func eanChecksumValid(digits []byte) bool {
+	sum := 0
+	for k := len(digits) - 2; k >= 0; k-- {
+		d := int(digits[k] - '0')
+		if (len(digits)-2-k)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(digits[len(digits)-1]-'0')
+}
+
+// code39Alphabet and code39Encodings map Code 39 characters to their
+// patterns: 9 elements (bar first), a set bit for each wide element.
+const code39Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%"
+
+var code39Encodings = []int{
+	0x034, 0x121, 0x061, 0x160, 0x031, 0x130, 0x070, 0x025, 0x124, 0x064,
+	0x109, 0x049, 0x148, 0x019, 0x118, 0x058, 0x00D, 0x10C, 0x04C, 0x01C,
+	0x103, 0x043, 0x142, 0x013, 0x112, 0x052, 0x007, 0x106, 0x046, 0x016,
+	0x181, 0x0C1, 0x1C0, 0x091, 0x190, 0x0D0, 0x085, 0x184, 0x0C4, 0x0A8,
+	0x0A2, 0x08A, 0x02A,
+}
+
+const code39Asterisk = 0x094
+
+// decodeCode39 finds Code 39 symbols delimited by the * start/stop character.
+func decodeCode39(runs []int) []linearMatch {
+	var matches []linearMatch
+	for i := 1; i+9 < len(runs); i += 2 {
+		if code39Pattern(runs[i:i+9]) != code39Asterisk {
+			continue
+		}
+		unit := float64(sumRuns(runs[i:i+9])) / 12 // 6 narrow + 3 wide at 2:1
+		if !quietBefore(runs, i, unit, 5) {
+			continue
+		}
+		var b strings.Builder
+		pos := i + 10 // skip the gap between characters
+		stopped := false
+		for pos+9 <= len(runs) {
+			if gap := float64(runs[pos-1]); gap > unit*4 {
+				break
+			}
+			pattern := code39Pattern(runs[pos : pos+9])
+			if pattern == code39Asterisk {
+				stopped = quietAfter(runs, pos+8, unit, 5)
+				break
+			}
+			k := indexOf(code39Encodings, pattern)
+			if k < 0 {
+				break
+			}
+			b.WriteByte(code39Alphabet[k])
+			pos += 10
+		}
+		if !stopped || b.Len() == 0 {
+			continue
+		}
+		matches = append(matches, linearMatch{symbology: SymbologyCode39, payload: b.String(), first: i, last: pos + 8})
+		i = pos + 8
+	}
+	return matches
+}
+
+// code39Pattern classifies 9 elements as narrow or wide; it returns -1 unless
+// exactly three are clearly wide.
+func code39Pattern(runs []int) int {
+	sorted := append([]int(nil), runs...)
+	sort.Ints(sorted)
+	narrow, wide := float64(sorted[5]), float64(sorted[6])
+	if wide < narrow*1.5 || float64(sorted[8]) > float64(sorted[0])*4.5 || narrow > float64(sorted[0])*2 {
+		return -1
+	}
+	pattern := 0
+	for _, r := range runs {
+		pattern <<= 1
+		if r >= sorted[6] {
+			pattern |= 1
+		}
+	}
+	return pattern
+}
+
+func indexOf(values []int, v int) int {
+	for i, x := range values {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
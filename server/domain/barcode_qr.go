@@ -0,0 +1,806 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// qrFinder is a finder pattern candidate: its center and module size.
+type qrFinder struct {
+	x, y, module float64
+	count        int
+}
+
+// detectQRCodes locates finder pattern triples and decodes the symbol each
+// one frames.
+func detectQRCodes(bits *bitImage) []DetectedBarcode {
+	finders := findQRFinders(bits)
+	used := make([]bool, len(finders))
+	var found []DetectedBarcode
+	for _, t := range qrFinderTriples(finders) {
+		if used[t[0]] || used[t[1]] || used[t[2]] {
+			continue
+		}
+		tl, tr, bl := orderQRFinders(finders[t[0]], finders[t[1]], finders[t[2]])
+		code, ok := decodeQRAt(bits, tl, tr, bl)
+		if !ok {
+			continue
+		}
+		used[t[0]], used[t[1]], used[t[2]] = true, true, true
+		found = append(found, code)
+	}
+	return found
+}
+
+// finderRatio reports whether five runs look like the 1:1:3:1:1 finder
+// pattern.
+func finderRatio(s [5]int) bool {
+	total := 0
+	for _, v := range s {
+		if v == 0 {
+			return false
+		}
+		total += v
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	tolerance := m / 2
+	return math.Abs(m-float64(s[0])) < tolerance &&
+		math.Abs(m-float64(s[1])) < tolerance &&
+		math.Abs(3*m-float64(s[2])) < 3*tolerance &&
+		math.Abs(m-float64(s[3])) < tolerance &&
+		math.Abs(m-float64(s[4])) < tolerance
+}
+
+// findQRFinders scans rows for finder patterns, confirms them across the
+// column and the row through their center, and merges repeated hits.
+func findQRFinders(bits *bitImage) []qrFinder {
+	var finders []qrFinder
+	for y := 0; y < bits.height; y++ {
+		runs := lineRuns(bits.width, func(x int) bool { return bits.black[y*bits.width+x] })
+		end := runs[0]
+		for i := 1; i+4 < len(runs); i += 2 {
+			end += runs[i]
+			var s [5]int
+			copy(s[:], runs[i:i+5])
+			if !finderRatio(s) {
+				end += runs[i+1]
+				continue
+			}
+			total := sumRuns(s[:])
+			stop := end + s[1] + s[2] + s[3] + s[4]
+			cx := float64(stop) - float64(s[4]) - float64(s[3]) - float64(s[2])/2
+			cy, ok := crossCheckFinder(bits, int(cx), y, 0, 1, s[2], total)
+			if ok {
+				cx, ok = crossCheckFinder(bits, int(cx), int(cy), 1, 0, s[2], total)
+			}
+			if ok {
+				finders = mergeQRFinder(finders, qrFinder{x: cx, y: cy, module: float64(total) / 7, count: 1})
+			}
+			end += runs[i+1]
+		}
+	}
+	var confirmed []qrFinder
+	for _, f := range finders {
+		if f.count >= 2 {
+			confirmed = append(confirmed, f)
+		}
+	}
+	sort.Slice(confirmed, func(i, j int) bool { return confirmed[i].count > confirmed[j].count })
+	if len(confirmed) > 30 {
+		confirmed = confirmed[:30]
+	}
+	return confirmed
+}
+
+// crossCheckFinder measures the finder pattern through (x, y) along the
+// direction (dx, dy) and returns the coordinate of its center on that axis.
+func crossCheckFinder(bits *bitImage, x, y, dx, dy, maxCount, originalTotal int) (float64, bool) {
+	inside := func(k int) bool {
+		px, py := x+k*dx, y+k*dy
+		return px >= 0 && py >= 0 && px < bits.width && py < bits.height
+	}
+	at := func(k int) bool { return bits.get(x+k*dx, y+k*dy) }
+	var s [5]int
+	k := 0
+	for inside(k) && at(k) {
+		s[2]++
+		k--
+	}
+	if !inside(k) {
+		return 0, false
+	}
+	for inside(k) && !at(k) && s[1] <= maxCount {
+		s[1]++
+		k--
+	}
+	if !inside(k) || s[1] > maxCount {
+		return 0, false
+	}
+	for inside(k) && at(k) && s[0] <= maxCount {
+		s[0]++
+		k--
+	}
+	if s[0] > maxCount {
+		return 0, false
+	}
+	k = 1
+	for inside(k) && at(k) {
+		s[2]++
+		k++
+	}
+	if !inside(k) {
+		return 0, false
+	}
+	for inside(k) && !at(k) && s[3] < maxCount {
+		s[3]++
+		k++
+	}
+	if !inside(k) || s[3] >= maxCount {
+		return 0, false
+	}
+	for inside(k) && at(k) && s[4] < maxCount {
+		s[4]++
+		k++
+	}
+	if s[4] >= maxCount {
+		return 0, false
+	}
+	total := sumRuns(s[:])
+	if 5*abs(total-originalTotal) >= 2*originalTotal || !finderRatio(s) {
+		return 0, false
+	}
+	origin := x
+	if dy != 0 {
+		origin = y
+	}
+	return float64(origin+k) - float64(s[4]) - float64(s[3]) - float64(s[2])/2, true
+}
+
+// mergeQRFinder folds a hit into a nearby candidate of similar size, or adds
+// it as a new candidate.
+func mergeQRFinder(finders []qrFinder, f qrFinder) []qrFinder {
+	for i := range finders {
+		c := &finders[i]
+		if math.Abs(c.x-f.x) > c.module || math.Abs(c.y-f.y) > c.module {
+			continue
+		}
+		if ratio := c.module / f.module; ratio > 1.4 || ratio < 1/1.4 {
+			continue
+		}
+		n := float64(c.count)
+		c.x = (c.x*n + f.x) / (n + 1)
+		c.y = (c.y*n + f.y) / (n + 1)
+		c.module = (c.module*n + f.module) / (n + 1)
+		c.count++
+		return finders
+	}
+	return append(finders, f)
+}
+
+// qrFinderTriples returns the finder triples that form a right isosceles
+// triangle of similar module sizes, best first.
+func qrFinderTriples(finders []qrFinder) [][3]int {
+	type scored struct {
+		triple [3]int
+		score  float64
+	}
+	var candidates []scored
+	for a := 0; a < len(finders); a++ {
+		for b := a + 1; b < len(finders); b++ {
+			for c := b + 1; c < len(finders); c++ {
+				fa, fb, fc := finders[a], finders[b], finders[c]
+				minModule := math.Min(fa.module, math.Min(fb.module, fc.module))
+				maxModule := math.Max(fa.module, math.Max(fb.module, fc.module))
+				if maxModule > 1.5*minModule {
+					continue
+				}
+				tl, tr, bl := orderQRFinders(fa, fb, fc)
+				l1, l2 := finderDistance(tl, tr), finderDistance(tl, bl)
+				hyp := finderDistance(tr, bl)
+				longer := math.Max(l1, l2)
+				legs := math.Abs(l1-l2) / longer
+				right := math.Abs(hyp-math.Hypot(l1, l2)) / hyp
+				if legs > 0.15 || right > 0.1 {
+					continue
+				}
+				module := (fa.module + fb.module + fc.module) / 3
+				if dim := (l1+l2)/2/module + 7; dim < 18 || dim > 180 {
+					continue
+				}
+				candidates = append(candidates, scored{[3]int{a, b, c}, legs + right + (maxModule-minModule)/maxModule})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+	triples := make([][3]int, len(candidates))
+	for i, c := range candidates {
+		triples[i] = c.triple
+	}
+	return triples
+}
+
+func finderDistance(a, b qrFinder) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+// orderQRFinders returns the top-left finder (opposite the longest side)
+// followed by the top-right and bottom-left ones.
+func orderQRFinders(a, b, c qrFinder) (tl, tr, bl qrFinder) {
+	ab, ac, bc := finderDistance(a, b), finderDistance(a, c), finderDistance(b, c)
+	switch {
+	case bc >= ab && bc >= ac:
+		tl, tr, bl = a, b, c
+	case ac >= ab && ac >= bc:
+		tl, tr, bl = b, a, c
+	default:
+		tl, tr, bl = c, a, b
+	}
+	// Image y points down: top-right x bottom-left is positive.
+	if (tr.x-tl.x)*(bl.y-tl.y)-(tr.y-tl.y)*(bl.x-tl.x) < 0 {
+		tr, bl = bl, tr
+	}
+	return tl, tr, bl
+}
+
+// qrGrid is a sampled QR symbol; true is a dark module.
+type qrGrid struct {
+	dim     int
+	modules []bool
+}
+
+func (g *qrGrid) get(x, y int) bool { return g.modules[y*g.dim+x] }
+
+// qrTransform maps module coordinates to image pixels from the three finder
+// centers, which sit at module (3.5, 3.5) of their corners.
+type qrTransform struct {
+	ox, oy, ux, uy, vx, vy float64
+}
+
+func newQRTransform(tl, tr, bl qrFinder, dim int) qrTransform {
+	span := float64(dim - 7)
+	return qrTransform{
+		ox: tl.x, oy: tl.y,
+		ux: (tr.x - tl.x) / span, uy: (tr.y - tl.y) / span,
+		vx: (bl.x - tl.x) / span, vy: (bl.y - tl.y) / span,
+	}
+}
+
+func (t qrTransform) point(mx, my float64) (float64, float64) {
+	mx, my = mx-3.5, my-3.5
+	return t.ox + mx*t.ux + my*t.vx, t.oy + mx*t.uy + my*t.vy
+}
+
+func sampleQRGrid(bits *bitImage, t qrTransform, dim int) *qrGrid {
+	g := &qrGrid{dim: dim, modules: make([]bool, dim*dim)}
+	for y := 0; y < dim; y++ {
+		for x := 0; x < dim; x++ {
+			px, py := t.point(float64(x)+0.5, float64(y)+0.5)
+			g.modules[y*dim+x] = bits.get(int(math.Floor(px)), int(math.Floor(py)))
+		}
+	}
+	return g
+}
+
+// decodeQRAt estimates the symbol size from the finder spacing, samples the
+// grid and decodes it, trying neighboring sizes when the estimate is off.
+func decodeQRAt(bits *bitImage, tl, tr, bl qrFinder) (DetectedBarcode, bool) {
+	module := (tl.module + tr.module + bl.module) / 3
+	estimate := int(math.Round((finderDistance(tl, tr)+finderDistance(tl, bl))/2/module)) + 7
+	var dims []int
+	switch estimate % 4 {
+	case 0:
+		dims = []int{estimate + 1}
+	case 1:
+		dims = []int{estimate}
+	case 2:
+		dims = []int{estimate - 1}
+	case 3:
+		dims = []int{estimate - 2, estimate + 2}
+	}
+	dims = append(dims, dims[0]-4, dims[len(dims)-1]+4)
+
+	tried := map[int]bool{}
+	for len(dims) > 0 {
+		dim := dims[0]
+		dims = dims[1:]
+		if dim < 21 || dim > 177 || tried[dim] {
+			continue
+		}
+		tried[dim] = true
+		t := newQRTransform(tl, tr, bl, dim)
+		grid := sampleQRGrid(bits, t, dim)
+		version := (dim - 17) / 4
+		if version >= 7 {
+			if v, ok := readQRVersion(grid); ok && v != version {
+				// The version block is more reliable than the estimate.
+				dims = append([]int{17 + 4*v}, dims...)
+				continue
+			}
+		}
+		raw, payload, err := decodeQRGrid(grid, version)
+		if err != nil {
+			continue
+		}
+		x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, c := range [][2]float64{{0, 0}, {float64(dim), 0}, {0, float64(dim)}, {float64(dim), float64(dim)}} {
+			px, py := t.point(c[0], c[1])
+			x0, y0 = math.Min(x0, px), math.Min(y0, py)
+			x1, y1 = math.Max(x1, px), math.Max(y1, py)
+		}
+		box := BoundingBox{
+			X0: int(math.Max(0, math.Floor(x0))),
+			Y0: int(math.Max(0, math.Floor(y0))),
+			X1: int(math.Min(float64(bits.width), math.Ceil(x1))),
+			Y1: int(math.Min(float64(bits.height), math.Ceil(y1))),
+		}
+		return DetectedBarcode{Symbology: SymbologyQRCode, Payload: payload, Raw: raw, BBox: box}, true
+	}
+	return DetectedBarcode{}, false
+}
+
+// bchCode appends the BCH remainder of value to it.
+func bchCode(value, generator, bits int) int {
+	rem := value
+	for i := 0; i < bits; i++ {
+		rem = rem<<1 ^ (rem>>(bits-1))*generator
+	}
+	return value<<bits | rem
+}
+
+func hammingDistance(a, b int) int {
+	n := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+// readQRVersion decodes either version information block (versions 7+).
+func readQRVersion(g *qrGrid) (int, bool) {
+	topRight, bottomLeft := 0, 0
+	for j := 5; j >= 0; j-- {
+		for i := g.dim - 9; i >= g.dim-11; i-- {
+			topRight = topRight<<1 | boolBit(g.get(i, j))
+		}
+	}
+	for i := 5; i >= 0; i-- {
+		for j := g.dim - 9; j >= g.dim-11; j-- {
+			bottomLeft = bottomLeft<<1 | boolBit(g.get(i, j))
+		}
+	}
+	best, bestDistance := 0, 4
+	for v := 7; v <= 40; v++ {
+		code := bchCode(v, 0x1F25, 12)
+		for _, read := range []int{topRight, bottomLeft} {
+			if d := hammingDistance(code, read); d < bestDistance {
+				best, bestDistance = v, d
+			}
+		}
+	}
+	return best, best != 0
+}
+
+// readQRFormat decodes either format information copy into the error
+// correction level index (L, M, Q, H) and the mask pattern.
+func readQRFormat(g *qrGrid) (level, mask int, ok bool) {
+	first := 0
+	for i := 0; i < 6; i++ {
+		first = first<<1 | boolBit(g.get(i, 8))
+	}
+	first = first<<1 | boolBit(g.get(7, 8))
+	first = first<<1 | boolBit(g.get(8, 8))
+	first = first<<1 | boolBit(g.get(8, 7))
+	for j := 5; j >= 0; j-- {
+		first = first<<1 | boolBit(g.get(8, j))
+	}
+	second := 0
+	for j := g.dim - 1; j >= g.dim-7; j-- {
+		second = second<<1 | boolBit(g.get(8, j))
+	}
+	for i := g.dim - 8; i < g.dim; i++ {
+		second = second<<1 | boolBit(g.get(i, 8))
+	}
+	best, bestDistance := -1, 4
+	for data := 0; data < 32; data++ {
+		code := bchCode(data, 0x537, 10) ^ 0x5412
+		for _, read := range []int{first, second} {
+			if d := hammingDistance(code, read); d < bestDistance {
+				best, bestDistance = data, d
+			}
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	// Format bits 01, 00, 11, 10 are levels L, M, Q, H.
+	return [4]int{1, 0, 3, 2}[best>>3], best & 7, true
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// qrECCodewordsPerBlock and qrECBlocks are indexed by level (L, M, Q, H) and
+// version.
+var qrECCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrECBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrRawCodewords is the number of codewords a version holds.
+func qrRawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		modules -= (25*align-10)*align - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+// qrAlignmentPositions returns the alignment pattern centers on each axis.
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, 4*version+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// qrFunctionModules marks the modules that do not carry data.
+func qrFunctionModules(version int) []bool {
+	dim := 17 + 4*version
+	function := make([]bool, dim*dim)
+	mark := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				function[y*dim+x] = true
+			}
+		}
+	}
+	mark(0, 0, 9, 9)
+	mark(dim-8, 0, 8, 9)
+	mark(0, dim-8, 9, 8)
+	mark(6, 0, 1, dim)
+	mark(0, 6, dim, 1)
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			mark(cx-2, cy-2, 5, 5)
+		}
+	}
+	if version >= 7 {
+		mark(dim-11, 0, 3, 6)
+		mark(0, dim-11, 6, 3)
+	}
+	return function
+}
+
+// qrMaskBit reports whether a mask pattern inverts the module at row i,
+// column j.
+func qrMaskBit(mask, i, j int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
+
+// decodeQRGrid unmasks and reads the codewords of a sampled symbol, corrects
+// each block and decodes the data segments.
+func decodeQRGrid(g *qrGrid, version int) ([]byte, string, error) {
+	level, mask, ok := readQRFormat(g)
+	if !ok {
+		return nil, "", fmt.Errorf("unreadable format information")
+	}
+	function := qrFunctionModules(version)
+	total := qrRawCodewords(version)
+	codewords := make([]byte, 0, total)
+	var current byte
+	bitCount := 0
+	upward := true
+	for right := g.dim - 1; right > 0 && len(codewords) < total; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for vert := 0; vert < g.dim; vert++ {
+			y := vert
+			if upward {
+				y = g.dim - 1 - vert
+			}
+			for col := 0; col < 2; col++ {
+				x := right - col
+				if function[y*g.dim+x] {
+					continue
+				}
+				bit := g.get(x, y) != qrMaskBit(mask, y, x)
+				current = current<<1 | byte(boolBit(bit))
+				bitCount++
+				if bitCount == 8 {
+					if len(codewords) < total {
+						codewords = append(codewords, current)
+					}
+					current, bitCount = 0, 0
+				}
+			}
+		}
+		upward = !upward
+	}
+	if len(codewords) < total {
+		return nil, "", fmt.Errorf("symbol holds %d of %d codewords", len(codewords), total)
+	}
+
+	// De-interleave: the short blocks come first and are one data codeword
+	// shorter than the long ones.
+	blocks := qrECBlocks[level][version]
+	ecLen := qrECCodewordsPerBlock[level][version]
+	shortBlocks := blocks - total%blocks
+	shortLen := total / blocks
+	shortData := shortLen - ecLen
+	if shortData <= 0 {
+		return nil, "", fmt.Errorf("invalid block layout")
+	}
+	data := make([][]byte, blocks)
+	for b := range data {
+		n := shortLen
+		if b >= shortBlocks {
+			n++
+		}
+		data[b] = make([]byte, 0, n)
+	}
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for b := range data {
+			if i == shortData && b < shortBlocks {
+				continue
+			}
+			data[b] = append(data[b], codewords[k])
+			k++
+		}
+	}
+	for i := 0; i < ecLen; i++ {
+		for b := range data {
+			data[b] = append(data[b], codewords[k])
+			k++
+		}
+	}
+	var stream []byte
+	for _, block := range data {
+		if _, err := rsCorrect(block, ecLen); err != nil {
+			return nil, "", err
+		}
+		stream = append(stream, block[:len(block)-ecLen]...)
+	}
+	return decodeQRSegments(stream, version)
+}
+
+// qrBitReader reads big-endian bit fields from the data codewords.
+type qrBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *qrBitReader) available() int { return len(r.data)*8 - r.pos }
+
+func (r *qrBitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := r.data[r.pos>>3] >> (7 - r.pos&7) & 1
+		v = v<<1 | int(bit)
+		r.pos++
+	}
+	return v
+}
+
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrCountBits is the character count width of a mode in a version.
+func qrCountBits(mode, version int) int {
+	widths := map[int][3]int{
+		0x1: {10, 12, 14}, // numeric
+		0x2: {9, 11, 13},  // alphanumeric
+		0x4: {8, 16, 16},  // byte
+		0x8: {8, 10, 12},  // kanji
+	}[mode]
+	switch {
+	case version <= 9:
+		return widths[0]
+	case version <= 26:
+		return widths[1]
+	}
+	return widths[2]
+}
+
+// decodeQRSegments decodes the data bit stream into its raw bytes and text.
+// Kanji segments are kept as Shift JIS bytes; byte segments are UTF-8 when
+// valid and ISO 8859-1 otherwise.
+func decodeQRSegments(stream []byte, version int) ([]byte, string, error) {
+	r := &qrBitReader{data: stream}
+	var raw []byte
+	kanji := false
+	for r.available() >= 4 {
+		mode := r.read(4)
+		switch mode {
+		case 0x0:
+			r.pos = len(stream) * 8
+		case 0x3: // structured append: sequence and parity
+			if r.available() < 16 {
+				return nil, "", fmt.Errorf("truncated structured append header")
+			}
+			r.read(16)
+		case 0x5: // FNC1 in first position
+		case 0x9: // FNC1 in second position: application indicator
+			if r.available() < 8 {
+				return nil, "", fmt.Errorf("truncated FNC1 indicator")
+			}
+			r.read(8)
+		case 0x7: // ECI designator; UTF-8 and Latin-1 are told apart below
+			if r.available() < 8 {
+				return nil, "", fmt.Errorf("truncated ECI")
+			}
+			first := r.read(8)
+			extra := 0
+			switch {
+			case first&0x80 == 0:
+			case first&0xC0 == 0x80:
+				extra = 8
+			case first&0xE0 == 0xC0:
+				extra = 16
+			default:
+				return nil, "", fmt.Errorf("invalid ECI designator")
+			}
+			if r.available() < extra {
+				return nil, "", fmt.Errorf("truncated ECI")
+			}
+			r.read(extra)
+		case 0x1, 0x2, 0x4, 0x8:
+			width := qrCountBits(mode, version)
+			if r.available() < width {
+				return nil, "", fmt.Errorf("truncated segment header")
+			}
+			count := r.read(width)
+			var err error
+			switch mode {
+			case 0x1:
+				raw, err = readQRNumeric(r, count, raw)
+			case 0x2:
+				raw, err = readQRAlphanumeric(r, count, raw)
+			case 0x4:
+				if r.available() < 8*count {
+					return nil, "", fmt.Errorf("truncated byte segment")
+				}
+				for i := 0; i < count; i++ {
+					raw = append(raw, byte(r.read(8)))
+				}
+			case 0x8:
+				kanji = true
+				raw, err = readQRKanji(r, count, raw)
+			}
+			if err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", fmt.Errorf("unknown segment mode %d", mode)
+		}
+	}
+	if kanji {
+		return raw, "", nil
+	}
+	if utf8.Valid(raw) {
+		return raw, string(raw), nil
+	}
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return raw, string(runes), nil
+}
+
+func readQRNumeric(r *qrBitReader, count int, out []byte) ([]byte, error) {
+	for count > 0 {
+		digits, width := 3, 10
+		switch count {
+		case 1:
+			digits, width = 1, 4
+		case 2:
+			digits, width = 2, 7
+		}
+		if r.available() < width {
+			return nil, fmt.Errorf("truncated numeric segment")
+		}
+		v := r.read(width)
+		if v >= []int{0, 10, 100, 1000}[digits] {
+			return nil, fmt.Errorf("invalid numeric group")
+		}
+		out = append(out, fmt.Sprintf("%0*d", digits, v)...)
+		count -= digits
+	}
+	return out, nil
+}
+
+func readQRAlphanumeric(r *qrBitReader, count int, out []byte) ([]byte, error) {
+	for count > 0 {
+		if count == 1 {
+			if r.available() < 6 {
+				return nil, fmt.Errorf("truncated alphanumeric segment")
+			}
+			v := r.read(6)
+			if v >= len(qrAlphanumeric) {
+				return nil, fmt.Errorf("invalid alphanumeric character")
+			}
+			return append(out, qrAlphanumeric[v]), nil
+		}
+		if r.available() < 11 {
+			return nil, fmt.Errorf("truncated alphanumeric segment")
+		}
+		v := r.read(11)
+		if v >= 45*45 {
+			return nil, fmt.Errorf("invalid alphanumeric pair")
+		}
+		out = append(out, qrAlphanumeric[v/45], qrAlphanumeric[v%45])
+		count -= 2
+	}
+	return out, nil
+}
+
+func readQRKanji(r *qrBitReader, count int, out []byte) ([]byte, error) {
+	if r.available() < 13*count {
+		return nil, fmt.Errorf("truncated kanji segment")
+	}
+	for i := 0; i < count; i++ {
+		v := r.read(13)
+		sjis := (v/0xC0)<<8 | v%0xC0
+		if sjis < 0x1F00 {
+			sjis += 0x8140
+		} else {
+			sjis += 0xC140
+		}
+		out = append(out, byte(sjis>>8), byte(sjis))
+	}
+	return out, nil
+}
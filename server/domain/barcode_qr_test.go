@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// rsEncode appends ecLen Reed-Solomon check bytes to data, with the
+// generator rsCorrect expects.
+func rsEncode(data []byte, ecLen int) []byte {
+	gen := []byte{1}
+	for i := 0; i < ecLen; i++ {
+		next := make([]byte, len(gen)+1)
+		for j, g := range gen {
+			next[j] ^= g
+			next[j+1] ^= gfMul(g, gfPow(i))
+		}
+		gen = next
+	}
+	rem := append(append([]byte(nil), data...), make([]byte, ecLen)...)
+	for i := range data {
+		if coef := rem[i]; coef != 0 {
+			for j := 1; j < len(gen); j++ {
+				rem[i+j] ^= gfMul(gen[j], coef)
+			}
+		}
+	}
+	return append(append([]byte(nil), data...), rem[len(data):]...)
+}
+
+// The version 1-M codeword of "01234567" from ISO/IEC 18004.
+var (
+	qrSampleData = []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	qrSampleEC   = []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+)
+
+func TestRSEncodeMatchesTheStandard(t *testing.T) {
+	if got := rsEncode(qrSampleData, len(qrSampleEC)); !bytes.Equal(got[len(qrSampleData):], qrSampleEC) {
+		t.Fatalf("check bytes = % X, want % X", got[len(qrSampleData):], qrSampleEC)
+	}
+}
+
+func TestRSCorrect(t *testing.T) {
+	codeword := append(append([]byte(nil), qrSampleData...), qrSampleEC...)
+	long := rsEncode(bytes.Repeat([]byte("reed-solomon "), 15), 30)
+	for _, tt := range []struct {
+		name      string
+		codeword  []byte
+		ecLen     int
+		positions []int
+		wantErr   bool
+	}{
+		{"clean", codeword, 10, nil, false},
+		{"one data error", codeword, 10, []int{3}, false},
+		{"check byte errors", codeword, 10, []int{16, 25}, false},
+		{"first and last byte", codeword, 10, []int{0, 25}, false},
+		{"as many errors as correctable", codeword, 10, []int{0, 5, 9, 14, 20}, false},
+		{"too many errors", codeword, 10, []int{0, 4, 8, 12, 16, 20}, true},
+		{"long codeword", long, 30, []int{1, 40, 41, 42, 100, 150, 180, 190, 200, 224}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			received := append([]byte(nil), tt.codeword...)
+			for i, p := range tt.positions {
+				received[p] ^= byte(0x5A + i)
+			}
+			corrected, err := rsCorrect(received, tt.ecLen)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("rsCorrect corrected %d bytes, want an error", corrected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rsCorrect: %v", err)
+			}
+			if corrected != len(tt.positions) || !bytes.Equal(received, tt.codeword) {
+				t.Fatalf("rsCorrect corrected %d bytes to % X, want %d to % X", corrected, received, len(tt.positions), tt.codeword)
+			}
+		})
+	}
+}
+
+// qrBits writes big-endian bit fields.
+type qrBits struct {
+	data []byte
+	n    int
+}
+
+func (b *qrBits) write(v, width int) *qrBits {
+	for i := width - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if v>>i&1 != 0 {
+			b.data[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+	return b
+}
+
+// bytes returns the stream with a terminator and a pad codeword.
+func (b *qrBits) bytes() []byte {
+	return append(b.write(0, 4).data, 0xEC)
+}
+
+func TestDecodeQRSegments(t *testing.T) {
+	bits := func() *qrBits { return &qrBits{} }
+	for _, tt := range []struct {
+		name     string
+		stream   []byte
+		version  int
+		wantRaw  string
+		wantText string
+		wantErr  bool
+	}{
+		{"standard numeric sample", qrSampleData, 1, "01234567", "01234567", false},
+		{"numeric groups of one and two digits", bits().write(0x1, 4).write(5, 10).write(123, 10).write(45, 7).bytes(), 1, "12345", "12345", false},
+		{"numeric in version 10", bits().write(0x1, 4).write(4, 12).write(987, 10).write(6, 4).bytes(), 10, "9876", "9876", false},
+		{"alphanumeric", bits().write(0x2, 4).write(5, 9).write(10*45+12, 11).write(41*45+4, 11).write(2, 6).bytes(), 1, "AC-42", "AC-42", false},
+		{"utf-8 bytes", bits().write(0x4, 4).write(3, 8).write(0x68, 8).write(0xC3, 8).write(0xA9, 8).bytes(), 1, "h\xc3\xa9", "hé", false},
+		{"latin-1 bytes", bits().write(0x4, 4).write(2, 8).write(0x63, 8).write(0xE9, 8).bytes(), 1, "c\xe9", "cé", false},
+		{"eci and mixed segments", bits().write(0x7, 4).write(26, 8).write(0x1, 4).write(2, 10).write(42, 7).write(0x4, 4).write(1, 8).write('x', 8).bytes(), 1, "42x", "42x", false},
+		{"two-byte eci designator", bits().write(0x7, 4).write(0x80|1, 8).write(0, 8).write(0x4, 4).write(1, 8).write('y', 8).bytes(), 1, "y", "y", false},
+		{"structured append and fnc1", bits().write(0x3, 4).write(0x1234, 16).write(0x5, 4).write(0x4, 4).write(1, 8).write('z', 8).bytes(), 1, "z", "z", false},
+		{"kanji kept as shift jis", bits().write(0x8, 4).write(1, 8).write(0x12*0xC0+0x1F, 13).bytes(), 1, "\x93\x5f", "", false},
+		{"no trailing terminator", bits().write(0x4, 4).write(1, 8).write('a', 8).data, 1, "a", "a", false},
+		{"empty stream", nil, 1, "", "", false},
+		{"unknown mode", bits().write(0x6, 4).write(0, 8).bytes(), 1, "", "", true},
+		{"invalid numeric group", bits().write(0x1, 4).write(3, 10).write(1000, 10).bytes(), 1, "", "", true},
+		{"invalid alphanumeric character", bits().write(0x2, 4).write(1, 9).write(45, 6).bytes(), 1, "", "", true},
+		{"truncated byte segment", bits().write(0x4, 4).write(9, 8).write('a', 8).data, 1, "", "", true},
+		{"truncated kanji segment", bits().write(0x8, 4).write(3, 8).write(0, 13).data, 1, "", "", true},
+		{"invalid eci designator", bits().write(0x7, 4).write(0xE0, 8).bytes(), 1, "", "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, text, err := decodeQRSegments(tt.stream, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (string(raw) != tt.wantRaw || text != tt.wantText) {
+				t.Fatalf("decodeQRSegments = %q, %q; want %q, %q", raw, text, tt.wantRaw, tt.wantText)
+			}
+		})
+	}
+}
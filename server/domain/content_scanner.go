@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrScanFailed is returned when an upload could not be scanned; such
+// uploads are refused rather than stored unscanned.
+var ErrScanFailed = errors.New("content scan failed")
+
+// QuarantineNamespace is the storage namespace of uploads a ContentScanner
+// flagged. Objects are stored as quarantine/<filename> (tenant files under
+// tenants/<tenant>/), recorded with the "quarantine" namespace, and are not
+// sent to OCR or offered for download.
+const QuarantineNamespace = "quarantine/"
+
+// QuarantinePath returns the storage path of a quarantined upload.
+func QuarantinePath(filename string) string {
+	return TenantStoragePath(filename, QuarantineNamespace)
+}
+
+// ErrFileQuarantined is returned for downloads of quarantined files.
+var ErrFileQuarantined = errors.New("file is quarantined")
+
+// ScanResult is the verdict of a ContentScanner.
+type ScanResult struct {
+	Infected  bool
+	Signature string // name of the detected threat
+}
+
+// ContentScanner scans uploads for malware before they are stored.
+type ContentScanner interface {
+	// Scan reads content to the end and returns the verdict. An error means
+	// the content could not be scanned.
+	Scan(ctx context.Context, filename string, content io.Reader) (*ScanResult, error)
+}
+
+// clamdChunkSize is the size of INSTREAM chunks.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans content with the INSTREAM command of a clamd server
+// (ClamAV daemon) over TCP or a Unix socket.
+type ClamdScanner struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for address, "host:port" or
+// "unix:/path/to/clamd.sock".
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return &ClamdScanner{Network: "unix", Address: path, Timeout: timeout}
+	}
+	return &ClamdScanner{Network: "tcp", Address: address, Timeout: timeout}
+}
+
+// ContentScannerFromEnv configures malware scanning: CONTENT_SCANNER=clamd
+// scans uploads with the clamd at CLAMD_ADDRESS (default localhost:3310)
+// within CLAMD_TIMEOUT (default 30s). It returns nil when CONTENT_SCANNER is
+// unset or "none".
+func ContentScannerFromEnv() (ContentScanner, error) {
+	switch scanner := strings.ToLower(strings.TrimSpace(os.Getenv("CONTENT_SCANNER"))); scanner {
+	case "", "none":
+		return nil, nil
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "localhost:3310"
+		}
+		timeout := 30 * time.Second
+		if v := os.Getenv("CLAMD_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid CLAMD_TIMEOUT %q", v)
+			}
+			timeout = d
+		}
+		log.Printf("Content scanner: clamd at %s", address)
+		return NewClamdScanner(address, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown CONTENT_SCANNER %q (want clamd or none)", scanner)
+	}
+}
+
+// Scan sends content to clamd in INSTREAM chunks: each chunk is prefixed
+// with its length as a 4-byte big-endian integer, and a zero length ends the
+// stream. clamd answers "stream: OK", "stream: <signature> FOUND" or
+// "<reason> ERROR".
+func (s *ClamdScanner) Scan(ctx context.Context, filename string, content io.Reader) (*ScanResult, error) {
+	dialer := net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to clamd: %v", ErrScanFailed, err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if err := s.send(conn, content); err != nil {
+		// clamd closes the connection when the stream is over its size
+		// limit; its reply says so
+		if reply, readErr := readClamdReply(conn); readErr == nil && reply != "" {
+			return parseClamdReply(reply)
+		}
+		return nil, fmt.Errorf("%w: failed to send %s to clamd: %v", ErrScanFailed, filename, err)
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read the clamd reply: %v", ErrScanFailed, err)
+	}
+	return parseClamdReply(reply)
+}
+
+func (s *ClamdScanner) send(conn net.Conn, content io.Reader) error {
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	return w.Flush()
+}
+
+// readClamdReply reads a NUL-terminated reply.
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+func parseClamdReply(reply string) (*ScanResult, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return &ScanResult{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &ScanResult{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return nil, fmt.Errorf("%w: clamd: %s", ErrScanFailed, reply)
+}
@@ -0,0 +1,306 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrContentRejected is returned for uploads refused by the content policy.
+var ErrContentRejected = errors.New("upload rejected")
+
+// Content types recognized by SniffContentType.
+const (
+	ContentTypePDF     = "pdf"
+	ContentTypePNG     = "png"
+	ContentTypeJPEG    = "jpeg"
+	ContentTypeGIF     = "gif"
+	ContentTypeWebP    = "webp"
+	ContentTypeBMP     = "bmp"
+	ContentTypeTIFF    = "tiff"
+	ContentTypeICO     = "ico"
+	ContentTypeZIP     = "zip" // also docx/xlsx/pptx and OpenDocument
+	ContentTypeOLE     = "ole" // legacy Office: doc/xls/ppt
+	ContentTypeRTF     = "rtf"
+	ContentTypeMP4     = "mp4" // ISO base media: mp4/mov/m4a
+	ContentTypeAVI     = "avi"
+	ContentTypeWAV     = "wav"
+	ContentTypeEBML    = "ebml" // mkv/webm
+	ContentTypeFLV     = "flv"
+	ContentTypeASF     = "asf" // wmv/wma
+	ContentTypeMP3     = "mp3"
+	ContentTypeAAC     = "aac"
+	ContentTypeOGG     = "ogg"
+	ContentTypeFLAC    = "flac"
+	ContentTypeExe     = "exe" // Windows PE
+	ContentTypeELF     = "elf"
+	ContentTypeMachO   = "macho"
+	ContentTypeScript  = "script" // starts with #!
+	ContentTypeText    = "text"
+	ContentTypeUnknown = "unknown"
+)
+
+// ContentSniffLength is how many leading bytes SniffContentType looks at.
+const ContentSniffLength = 512
+
+// contentSignature is a magic number at an offset of the content.
+type contentSignature struct {
+	offset int
+	magic  string
+	kind   string
+}
+
+// contentSignatures are checked in order; the first match wins.
+var contentSignatures = []contentSignature{
+	{0, "%PDF-", ContentTypePDF},
+	{0, "\x89PNG\r\n\x1a\n", ContentTypePNG},
+	{0, "\xff\xd8\xff", ContentTypeJPEG},
+	{0, "GIF87a", ContentTypeGIF},
+	{0, "GIF89a", ContentTypeGIF},
+	{8, "WEBP", ContentTypeWebP},
+	{8, "WAVE", ContentTypeWAV},
+	{8, "AVI ", ContentTypeAVI},
+	{0, "II*\x00", ContentTypeTIFF},
+	{0, "MM\x00*", ContentTypeTIFF},
+	{0, "\x00\x00\x01\x00", ContentTypeICO},
+	{0, "PK\x03\x04", ContentTypeZIP},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", ContentTypeOLE},
+	{0, "{\\rtf", ContentTypeRTF},
+	{4, "ftyp", ContentTypeMP4},
+	{0, "\x1a\x45\xdf\xa3", ContentTypeEBML},
+	{0, "FLV\x01", ContentTypeFLV},
+	{0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11", ContentTypeASF},
+	{0, "ID3", ContentTypeMP3},
+	{0, "OggS", ContentTypeOGG},
+	{0, "fLaC", ContentTypeFLAC},
+	{0, "\x7fELF", ContentTypeELF},
+	{0, "\xfe\xed\xfa\xce", ContentTypeMachO},
+	{0, "\xfe\xed\xfa\xcf", ContentTypeMachO},
+	{0, "\xce\xfa\xed\xfe", ContentTypeMachO},
+	{0, "\xcf\xfa\xed\xfe", ContentTypeMachO},
+	{0, "MZ", ContentTypeExe},
+	{0, "#!", ContentTypeScript},
+	{0, "BM", ContentTypeBMP},
+}
+
+// binarySignatureCheck rejects short signatures that plain text can start
+// with ("MZ", "BM") unless the rest of the header agrees.
+func binarySignatureCheck(kind string, head []byte) bool {
+	switch kind {
+	case ContentTypeExe:
+		return bytes.IndexByte(head, 0) >= 0
+	case ContentTypeBMP:
+		// The four reserved bytes after the file size are zero
+		return len(head) >= 10 && string(head[6:10]) == "\x00\x00\x00\x00"
+	case ContentTypeWebP, ContentTypeWAV, ContentTypeAVI:
+		return bytes.HasPrefix(head, []byte("RIFF"))
+	}
+	return true
+}
+
+// SniffContentType identifies content from its leading bytes. Content without
+// a known signature and without NUL bytes is text (in any encoding).
+func SniffContentType(head []byte) string {
+	if len(head) > ContentSniffLength {
+		head = head[:ContentSniffLength]
+	}
+	for _, sig := range contentSignatures {
+		if len(head) >= sig.offset+len(sig.magic) && string(head[sig.offset:sig.offset+len(sig.magic)]) == sig.magic {
+			if !binarySignatureCheck(sig.kind, head) {
+				continue
+			}
+			return sig.kind
+		}
+	}
+	// MPEG audio frames: 11 sync bits; ADTS (AAC) sets layer 00
+	if len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0 {
+		if head[1]&0x06 == 0 {
+			return ContentTypeAAC
+		}
+		return ContentTypeMP3
+	}
+	if len(head) > 0 && bytes.IndexByte(head, 0) < 0 {
+		return ContentTypeText
+	}
+	return ContentTypeUnknown
+}
+
+// extensionContentTypes lists the content types each extension may hold.
+// Extensions not listed are not checked (they go to others/). Office has
+// long saved RTF, HTML and XML documents as .doc, and HTML, CSV and XML
+// sheets as .xls, so those extensions accept text as well.
+var extensionContentTypes = map[string][]string{
+	"pdf":  {ContentTypePDF},
+	"doc":  {ContentTypeOLE, ContentTypeRTF, ContentTypeText},
+	"xls":  {ContentTypeOLE, ContentTypeText},
+	"ppt":  {ContentTypeOLE},
+	"docx": {ContentTypeZIP},
+	"xlsx": {ContentTypeZIP},
+	"pptx": {ContentTypeZIP},
+	"odt":  {ContentTypeZIP},
+	"ods":  {ContentTypeZIP},
+	"odp":  {ContentTypeZIP},
+	"txt":  {ContentTypeText},
+	"md":   {ContentTypeText},
+	"csv":  {ContentTypeText},
+	"rtf":  {ContentTypeRTF},
+	"jpg":  {ContentTypeJPEG},
+	"jpeg": {ContentTypeJPEG},
+	"png":  {ContentTypePNG},
+	"gif":  {ContentTypeGIF},
+	"webp": {ContentTypeWebP},
+	"bmp":  {ContentTypeBMP},
+	"svg":  {ContentTypeText},
+	"ico":  {ContentTypeICO, ContentTypePNG},
+	"mp4":  {ContentTypeMP4},
+	"mov":  {ContentTypeMP4},
+	"m4v":  {ContentTypeMP4},
+	"m4a":  {ContentTypeMP4},
+	"avi":  {ContentTypeAVI},
+	"mkv":  {ContentTypeEBML},
+	"webm": {ContentTypeEBML},
+	"flv":  {ContentTypeFLV},
+	"wmv":  {ContentTypeASF},
+	"wma":  {ContentTypeASF},
+	"mp3":  {ContentTypeMP3},
+	"wav":  {ContentTypeWAV},
+	"flac": {ContentTypeFLAC},
+	"aac":  {ContentTypeAAC, ContentTypeMP4},
+	"ogg":  {ContentTypeOGG},
+}
+
+// contentTypeNamespace is the namespace of content stored by its type.
+func contentTypeNamespace(kind string) string {
+	switch kind {
+	case ContentTypePDF, ContentTypeZIP, ContentTypeOLE, ContentTypeRTF, ContentTypeText:
+		return "documents/"
+	case ContentTypePNG, ContentTypeJPEG, ContentTypeGIF, ContentTypeWebP, ContentTypeBMP, ContentTypeTIFF, ContentTypeICO:
+		return "images/"
+	case ContentTypeMP4, ContentTypeAVI, ContentTypeWAV, ContentTypeEBML, ContentTypeFLV, ContentTypeASF,
+		ContentTypeMP3, ContentTypeAAC, ContentTypeOGG, ContentTypeFLAC:
+		return "media/"
+	}
+	return "others/"
+}
+
+// What to do with content that does not match its extension.
+const (
+	ContentMismatchReject      = "reject"
+	ContentMismatchRenamespace = "renamespace" // store under the namespace of the content, without OCR
+	ContentMismatchAllow       = "allow"
+)
+
+// ContentPolicy decides which uploads are accepted, by extension and by
+// sniffed content type.
+type ContentPolicy struct {
+	Mismatch          string
+	AllowExtensions   map[string]bool // empty allows every extension
+	DenyExtensions    map[string]bool
+	DenyContentTypes  map[string]bool
+	AllowContentTypes map[string]bool // empty allows every content type
+}
+
+// ContentVerdict is the result of checking an upload.
+type ContentVerdict struct {
+	ContentType string
+	// Namespace is where the file is stored; it differs from the namespace of
+	// its extension only when a mismatching file is re-namespaced.
+	Namespace string
+	Mismatch  bool
+}
+
+// ContentPolicyFromEnv configures content validation:
+//
+//   - CONTENT_VALIDATION: default on; false/0/off/no disables it (returns nil)
+//   - CONTENT_MISMATCH: reject (default), renamespace or allow
+//   - CONTENT_ALLOW_EXTENSIONS, CONTENT_DENY_EXTENSIONS: comma-separated
+//     extensions (deny default: exe,dll,scr,com,bat,cmd,msi,ps1,vbs,jar)
+//   - CONTENT_ALLOW_TYPES, CONTENT_DENY_TYPES: comma-separated content types
+//     (deny default: exe,elf,macho)
+func ContentPolicyFromEnv() (*ContentPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CONTENT_VALIDATION"))) {
+	case "false", "0", "off", "no":
+		return nil, nil
+	}
+	policy := &ContentPolicy{Mismatch: strings.ToLower(strings.TrimSpace(os.Getenv("CONTENT_MISMATCH")))}
+	switch policy.Mismatch {
+	case "":
+		policy.Mismatch = ContentMismatchReject
+	case ContentMismatchReject, ContentMismatchRenamespace, ContentMismatchAllow:
+	default:
+		return nil, fmt.Errorf("invalid CONTENT_MISMATCH %q (want reject, renamespace or allow)", policy.Mismatch)
+	}
+	policy.AllowExtensions = envSet("CONTENT_ALLOW_EXTENSIONS", "")
+	policy.DenyExtensions = envSet("CONTENT_DENY_EXTENSIONS", "exe,dll,scr,com,bat,cmd,msi,ps1,vbs,jar")
+	policy.AllowContentTypes = envSet("CONTENT_ALLOW_TYPES", "")
+	policy.DenyContentTypes = envSet("CONTENT_DENY_TYPES", "exe,elf,macho")
+	return policy, nil
+}
+
+// envSet reads a comma-separated, case-insensitive set; def applies when the
+// variable is unset, so it can be cleared with an empty value.
+func envSet(name string, def string) map[string]bool {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		value = def
+	}
+	set := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(item), ".")); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// CheckExtension checks only the extension lists, for uploads whose content
+// is not available yet.
+func (p *ContentPolicy) CheckExtension(filename string) error {
+	if p == nil {
+		return nil
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if p.DenyExtensions[ext] || (len(p.AllowExtensions) > 0 && !p.AllowExtensions[ext]) {
+		return fmt.Errorf("%w: extension %q is not allowed", ErrContentRejected, ext)
+	}
+	return nil
+}
+
+// Check validates an upload by its filename and leading bytes. It returns
+// ErrContentRejected for denied extensions and content types, and for
+// content that does not match its extension unless the policy re-namespaces
+// or allows it. A nil policy accepts everything.
+func (p *ContentPolicy) Check(filename string, head []byte) (ContentVerdict, error) {
+	verdict := ContentVerdict{ContentType: SniffContentType(head), Namespace: GetFileNamespace(filename)}
+	if p == nil {
+		return verdict, nil
+	}
+	if err := p.CheckExtension(filename); err != nil {
+		return verdict, err
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if p.DenyContentTypes[verdict.ContentType] || (len(p.AllowContentTypes) > 0 && !p.AllowContentTypes[verdict.ContentType]) {
+		return verdict, fmt.Errorf("%w: %s content is not allowed", ErrContentRejected, verdict.ContentType)
+	}
+	expected, checked := extensionContentTypes[ext]
+	if !checked {
+		return verdict, nil
+	}
+	for _, kind := range expected {
+		if kind == verdict.ContentType {
+			return verdict, nil
+		}
+	}
+	verdict.Mismatch = true
+	switch p.Mismatch {
+	case ContentMismatchRenamespace:
+		verdict.Namespace = contentTypeNamespace(verdict.ContentType)
+		return verdict, nil
+	case ContentMismatchAllow:
+		return verdict, nil
+	}
+	return verdict, fmt.Errorf("%w: %s content does not match the .%s extension", ErrContentRejected, verdict.ContentType, ext)
+}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		head string
+		want string
+	}{
+		{"pdf", "%PDF-1.7\n", ContentTypePDF},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00", ContentTypePNG},
+		{"zip", "PK\x03\x04\x14\x00", ContentTypeZIP},
+		{"ole", "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00", ContentTypeOLE},
+		{"rtf", "{\\rtf1\\ansi", ContentTypeRTF},
+		{"windows executable", "MZ\x90\x00\x03\x00", ContentTypeExe},
+		{"text starting with MZ", "MZ is a prefix of this line", ContentTypeText},
+		{"bmp", "BM\x36\x00\x0c\x00\x00\x00\x00\x00", ContentTypeBMP},
+		{"text starting with BM", "BMW sales figures", ContentTypeText},
+		{"webp", "RIFF\x00\x00\x00\x00WEBPVP8 ", ContentTypeWebP},
+		{"elf", "\x7fELF\x02\x01", ContentTypeELF},
+		{"script", "#!/bin/sh\n", ContentTypeScript},
+		{"html", "<!DOCTYPE html><html>", ContentTypeText},
+		{"binary", "\x01\x02\x00\x03", ContentTypeUnknown},
+		{"empty", "", ContentTypeUnknown},
+	} {
+		if got := SniffContentType([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: SniffContentType = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContentPolicyCheck(t *testing.T) {
+	policy := func(mismatch string) *ContentPolicy {
+		return &ContentPolicy{
+			Mismatch:         mismatch,
+			DenyExtensions:   map[string]bool{"exe": true},
+			DenyContentTypes: map[string]bool{ContentTypeExe: true, ContentTypeELF: true},
+		}
+	}
+	exe := "MZ\x90\x00\x03\x00"
+	for _, tt := range []struct {
+		name          string
+		policy        *ContentPolicy
+		filename      string
+		head          string
+		wantErr       error
+		wantNamespace string
+		wantMismatch  bool
+	}{
+		{"matching pdf", policy(ContentMismatchReject), "report.pdf", "%PDF-1.7", nil, "documents/", false},
+		{"denied extension", policy(ContentMismatchReject), "setup.exe", "text", ErrContentRejected, "others/", false},
+		{"denied content type", policy(ContentMismatchAllow), "notes.bin", "\x7fELF\x02", ErrContentRejected, "others/", false},
+		{"mismatch rejected", policy(ContentMismatchReject), "report.pdf", "plain text", ErrContentRejected, "documents/", true},
+		{"mismatch re-namespaced", policy(ContentMismatchRenamespace), "photo.png", "plain text", nil, "documents/", true},
+		{"mismatch allowed", policy(ContentMismatchAllow), "photo.png", "plain text", nil, "images/", true},
+		{"unchecked extension", policy(ContentMismatchReject), "data.bin", "\x01\x00", nil, "others/", false},
+		{"legacy doc", policy(ContentMismatchReject), "letter.doc", "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", nil, "documents/", false},
+		{"rtf saved as doc", policy(ContentMismatchReject), "letter.doc", "{\\rtf1\\ansi", nil, "documents/", false},
+		{"html saved as doc", policy(ContentMismatchReject), "letter.doc", "<html><body>", nil, "documents/", false},
+		{"csv saved as xls", policy(ContentMismatchReject), "sheet.xls", "a,b,c\n1,2,3\n", nil, "documents/", false},
+		{"zip named xls", policy(ContentMismatchReject), "sheet.xls", "PK\x03\x04", ErrContentRejected, "documents/", true},
+		{"executable named doc", policy(ContentMismatchReject), "letter.doc", exe, ErrContentRejected, "documents/", false},
+		{"nil policy", nil, "setup.exe", exe, nil, "others/", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := tt.policy.Check(tt.filename, []byte(tt.head))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if verdict.Namespace != tt.wantNamespace || verdict.Mismatch != tt.wantMismatch {
+				t.Fatalf("verdict = %+v, want namespace %q and mismatch %v", verdict, tt.wantNamespace, tt.wantMismatch)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -26,13 +27,51 @@ type FileMetadata struct {
 	StorageProvider string
 	StoragePath string
 	UploadedAt  time.Time
+	Tenant      string // tenant of the uploader; "" for files of unscoped callers
+	Owner       string // subject of the uploader
 }
 
+// DerivedFile is a file generated from an OCR result and cached in storage.
+// SourceProcessedAt is the processed_at of the OCR result it was built from, so
+// a re-run of OCR invalidates it.
+type DerivedFile struct {
+	Filename          string
+	StorageProvider   string
+	EngineName        string
+	Kind              string // e.g. DerivedKindSearchablePDF
+	StoragePath       string
+	PageCount         int
+	Size              int64
+	SourceProcessedAt time.Time
+	CreatedAt         time.Time
+}
+
+// DerivedKindSearchablePDF is the DerivedFile kind of searchable PDFs.
+const DerivedKindSearchablePDF = "searchable_pdf"
+
+// DerivedKindRedacted is the DerivedFile kind of redacted copies; they are
+// recorded under RedactedEngineName.
+const DerivedKindRedacted = "redacted"
+
 type FileMetadataRepository interface {
 	Create(ctx context.Context, metadata *FileMetadata) error
-	ListByProvider(ctx context.Context, provider string) ([]*pb.FileInfo, error)
+	// ListByProvider returns the files of a provider, only those of one tenant
+	// when tenant is not "". Filenames are file keys (see TenantFileKey).
+	ListByProvider(ctx context.Context, provider string, tenant string) ([]*pb.FileInfo, error)
 	FindByFilename(ctx context.Context, filename string, provider string) (*FileMetadata, error)
 	Delete(ctx context.Context, filename string, provider string) error
+	// TenantUsage returns the number and total size of a tenant's files over
+	// all providers.
+	TenantUsage(ctx context.Context, tenant string) (TenantUsage, error)
+	// ListStoragePaths returns the storage paths of all uploads and derived
+	// files of a provider.
+	ListStoragePaths(ctx context.Context, provider string) ([]string, error)
+	// SaveStagedUpload records the staged object of a direct upload.
+	SaveStagedUpload(ctx context.Context, upload *StagedUpload) error
+	// DeleteStagedUpload forgets a staged upload once it is finalized or deleted.
+	DeleteStagedUpload(ctx context.Context, id string) error
+	// ListExpiredStagedUploads returns the staged uploads that expired before t.
+	ListExpiredStagedUploads(ctx context.Context, t time.Time) ([]*StagedUpload, error)
 }
 
 // OCRResultRepository ?OCR?????????????????????
@@ -41,9 +80,37 @@ type OCRResultRepository interface {
 	GetOCRResult(ctx context.Context, filename string, provider string, engineName string) (*OCRResult, error)
 	ListOCRResults(ctx context.Context, provider string) ([]*OCRResult, error)
 	GetOCRComparison(ctx context.Context, filename string, provider string) ([]*OCRResult, error)
+	// GetOCRLayout returns the stored word-level layout, or nil when there is no result.
+	GetOCRLayout(ctx context.Context, filename string, provider string, engineName string) (*OCRLayout, error)
+	// GetExtractedTables returns the tables found in a result's pages, or nil when there is no result.
+	GetExtractedTables(ctx context.Context, filename string, provider string, engineName string) ([]ExtractedTable, error)
 	DeleteOCRResult(ctx context.Context, filename string, provider string, engineName string) error
+	// SaveDerivedFile records (or replaces) a generated file.
+	SaveDerivedFile(ctx context.Context, file *DerivedFile) error
+	// GetDerivedFile returns the generated file of the given kind, or nil.
+	GetDerivedFile(ctx context.Context, filename string, provider string, engineName string, kind string) (*DerivedFile, error)
 	// LogError ??????????????????????????????
 	LogError(ctx context.Context, filename string, provider string, engineName string, errorType string, errorMsg string) error
+	// SaveGroundTruth records (or replaces) the reference text of a file.
+	SaveGroundTruth(ctx context.Context, gt *GroundTruth) error
+	// GetGroundTruth returns the reference text of a file, or nil.
+	GetGroundTruth(ctx context.Context, filename string, provider string) (*GroundTruth, error)
+	// ListGroundTruth returns the files of a provider that have ground truth.
+	ListGroundTruth(ctx context.Context, provider string) ([]string, error)
+	// SaveOCREvaluation records (or replaces) the aggregate of an engine version on a dataset.
+	SaveOCREvaluation(ctx context.Context, ev *OCREvaluation) error
+	// ListOCREvaluations returns the stored aggregates of a dataset, all datasets when empty.
+	ListOCREvaluations(ctx context.Context, dataset string) ([]*OCREvaluation, error)
+	// SaveExtractedFields records (or replaces) the fields read from a file.
+	SaveExtractedFields(ctx context.Context, fields *ExtractedFields) error
+	// GetExtractedFields returns the fields read from a file, or nil.
+	GetExtractedFields(ctx context.Context, filename string, provider string) (*ExtractedFields, error)
+	// SearchExtractedFields returns the files whose fields match a query, with facet counts.
+	SearchExtractedFields(ctx context.Context, query DocumentSearchQuery) (*DocumentSearchResult, error)
+	// SavePIIReport records (or replaces) the personal information found in a file.
+	SavePIIReport(ctx context.Context, report *PIIReport) error
+	// GetPIIReport returns the personal information found in a file, or nil.
+	GetPIIReport(ctx context.Context, filename string, provider string) (*PIIReport, error)
 }
 
 type sqliteFileMetadataRepository struct {
@@ -87,6 +154,8 @@ func (r *sqliteFileMetadataRepository) initSchema(ctx context.Context) error {
 		storage_provider TEXT NOT NULL,
 		storage_path TEXT NOT NULL,
 		uploaded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		tenant TEXT NOT NULL DEFAULT '',
+		owner TEXT NOT NULL DEFAULT '',
 		UNIQUE(filename, storage_provider)
 	);
 
@@ -104,6 +173,12 @@ func (r *sqliteFileMetadataRepository) initSchema(ctx context.Context) error {
 		error_message TEXT,
 		average_confidence REAL,  -- ?????
 		processed_at DATETIME,
+		preprocess_profile TEXT,
+		detected_language TEXT,
+		languages TEXT,  -- comma-separated language codes used for recognition
+		engine_agreement TEXT,  -- ensemble results: JSON array of per-engine agreement
+		engine_version TEXT,
+		tenant TEXT NOT NULL DEFAULT '',  -- tenant of the file key
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE(filename, storage_provider, engine_name)  -- ???????????????????
 	);
@@ -135,11 +210,174 @@ func (r *sqliteFileMetadataRepository) initSchema(ctx context.Context) error {
 		page_number INTEGER NOT NULL,
 		text TEXT,
 		confidence REAL,
+		preprocess_steps TEXT,  -- JSON array of applied preprocessing steps
+		engine_agreement TEXT,  -- ensemble results: JSON array of per-engine agreement
+		engine_name TEXT,  -- engine that produced the page (differs from the result's in cascades)
+		classification TEXT,  -- page class found before OCR: printed, handwritten, mixed, blank, photo
 		FOREIGN KEY (ocr_result_id) REFERENCES ocr_results(id) ON DELETE CASCADE
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_ocr_pages_result_id ON ocr_pages(ocr_result_id);
 	
+	-- Page layouts: page size plus word boxes grouped into blocks and lines
+	CREATE TABLE IF NOT EXISTS ocr_page_layouts (
+		ocr_result_id INTEGER NOT NULL,
+		page_number INTEGER NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		PRIMARY KEY (ocr_result_id, page_number),
+		FOREIGN KEY (ocr_result_id) REFERENCES ocr_results(id) ON DELETE CASCADE
+	);
+	
+	CREATE TABLE IF NOT EXISTS ocr_layout_words (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ocr_result_id INTEGER NOT NULL,
+		page_number INTEGER NOT NULL,
+		block_index INTEGER NOT NULL,
+		line_index INTEGER NOT NULL,
+		word_index INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		confidence REAL,
+		x0 INTEGER NOT NULL,
+		y0 INTEGER NOT NULL,
+		x1 INTEGER NOT NULL,
+		y1 INTEGER NOT NULL,
+		FOREIGN KEY (ocr_result_id) REFERENCES ocr_results(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_ocr_layout_words_result_page ON ocr_layout_words(ocr_result_id, page_number);
+	
+	-- Tables found in the page layouts of an OCR result
+	CREATE TABLE IF NOT EXISTS ocr_tables (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ocr_result_id INTEGER NOT NULL,
+		page_number INTEGER NOT NULL,
+		table_index INTEGER NOT NULL,
+		rows INTEGER NOT NULL,
+		columns INTEGER NOT NULL,
+		ruled BOOLEAN NOT NULL,
+		confidence REAL,
+		x0 INTEGER NOT NULL,
+		y0 INTEGER NOT NULL,
+		x1 INTEGER NOT NULL,
+		y1 INTEGER NOT NULL,
+		cells TEXT NOT NULL,  -- JSON array of cells
+		FOREIGN KEY (ocr_result_id) REFERENCES ocr_results(id) ON DELETE CASCADE,
+		UNIQUE(ocr_result_id, page_number, table_index)
+	);
+	
+	-- Barcodes and QR codes found on the page images of an OCR result
+	CREATE TABLE IF NOT EXISTS ocr_barcodes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ocr_result_id INTEGER NOT NULL,
+		page_number INTEGER NOT NULL,
+		symbology TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		raw BLOB,
+		x0 INTEGER NOT NULL,
+		y0 INTEGER NOT NULL,
+		x1 INTEGER NOT NULL,
+		y1 INTEGER NOT NULL,
+		FOREIGN KEY (ocr_result_id) REFERENCES ocr_results(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_ocr_barcodes_result ON ocr_barcodes(ocr_result_id);
+	
+	-- Files generated from OCR results (e.g. searchable PDFs), cached in storage
+	CREATE TABLE IF NOT EXISTS derived_files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		storage_provider TEXT NOT NULL,
+		engine_name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		storage_path TEXT NOT NULL,
+		page_count INTEGER NOT NULL DEFAULT 0,
+		size INTEGER NOT NULL DEFAULT 0,
+		source_processed_at DATETIME,
+		created_at DATETIME NOT NULL,
+		UNIQUE(filename, storage_provider, engine_name, kind)
+	);
+	
+	-- Staged objects of direct uploads, deleted once they expire unfinalized
+	CREATE TABLE IF NOT EXISTS staged_uploads (
+		id TEXT PRIMARY KEY,
+		storage_provider TEXT NOT NULL,
+		storage_path TEXT NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	
+	CREATE INDEX IF NOT EXISTS idx_staged_uploads_expires ON staged_uploads(expires_at);
+	
+	-- Reference text for accuracy evaluation; page_number 0 is the whole document
+	CREATE TABLE IF NOT EXISTS ocr_ground_truth (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		storage_provider TEXT NOT NULL,
+		page_number INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE(filename, storage_provider, page_number)
+	);
+	
+	-- Accuracy of an engine version on a dataset (latest run)
+	CREATE TABLE IF NOT EXISTS ocr_evaluations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		dataset TEXT NOT NULL,
+		engine_name TEXT NOT NULL,
+		engine_version TEXT NOT NULL,
+		preprocess_profile TEXT NOT NULL,
+		files INTEGER NOT NULL,
+		pages INTEGER NOT NULL,
+		ref_chars INTEGER NOT NULL,
+		char_errors INTEGER NOT NULL,
+		ref_words INTEGER NOT NULL,
+		word_errors INTEGER NOT NULL,
+		cer REAL NOT NULL,
+		wer REAL NOT NULL,
+		details TEXT,  -- JSON: per file and page results
+		evaluated_at DATETIME NOT NULL,
+		UNIQUE(dataset, engine_name, engine_version, preprocess_profile)
+	);
+	
+	-- Fields read from a file by its document type's template (latest run)
+	CREATE TABLE IF NOT EXISTS extracted_fields (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		storage_provider TEXT NOT NULL,
+		engine_name TEXT NOT NULL,
+		document_type TEXT NOT NULL,
+		fields TEXT NOT NULL,  -- JSON array of fields
+		validation_errors TEXT,  -- JSON array of messages
+		valid BOOLEAN NOT NULL,
+		extracted_at DATETIME NOT NULL,
+		UNIQUE(filename, storage_provider)
+	);
+	
+	-- One row per extracted field, for searching and facet counts
+	CREATE TABLE IF NOT EXISTS extracted_field_values (
+		extracted_fields_id INTEGER NOT NULL,
+		field_name TEXT NOT NULL,
+		value TEXT NOT NULL,  -- normalized value
+		number REAL,  -- numeric value of number and amount fields
+		FOREIGN KEY (extracted_fields_id) REFERENCES extracted_fields(id) ON DELETE CASCADE
+	);
+	
+	CREATE INDEX IF NOT EXISTS idx_extracted_fields_type ON extracted_fields(storage_provider, document_type);
+	CREATE INDEX IF NOT EXISTS idx_extracted_field_values_name ON extracted_field_values(field_name, value);
+	
+	-- Personal information found in a file's OCR result (latest scan); values are stored masked
+	CREATE TABLE IF NOT EXISTS pii_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		filename TEXT NOT NULL,
+		storage_provider TEXT NOT NULL,
+		engine_name TEXT NOT NULL,  -- OCR result that was scanned
+		findings TEXT NOT NULL,  -- JSON array of findings
+		finding_count INTEGER NOT NULL,
+		source_processed_at DATETIME,
+		scanned_at DATETIME NOT NULL,
+		UNIQUE(filename, storage_provider)
+	);
+	
 	-- ????????????????
 	CREATE TABLE IF NOT EXISTS queue_tasks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -149,7 +387,8 @@ func (r *sqliteFileMetadataRepository) initSchema(ctx context.Context) error {
 		enqueued_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		dequeued_at DATETIME,
 		processed_at DATETIME,
-		error_message TEXT
+		error_message TEXT,
+		tenant TEXT NOT NULL DEFAULT ''
 	);
 	
 	CREATE INDEX IF NOT EXISTS idx_queue_filename_provider ON queue_tasks(filename, storage_provider);
@@ -169,14 +408,86 @@ func (r *sqliteFileMetadataRepository) initSchema(ctx context.Context) error {
 	GROUP BY filename, storage_provider;
 	`
 
-	_, err := r.db.ExecContext(ctx, createTableSQL)
-	return err
+	if _, err := r.db.ExecContext(ctx, createTableSQL); err != nil {
+		return err
+	}
+	
+	// Columns added after the first release; CREATE TABLE IF NOT EXISTS leaves
+	// existing databases untouched.
+	if err := ensureColumn(ctx, r.db, "ocr_results", "preprocess_profile", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_results", "detected_language", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_results", "languages", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_results", "engine_agreement", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_results", "engine_version", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_pages", "engine_agreement", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_pages", "engine_name", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_pages", "classification", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "file_metadata", "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "file_metadata", "owner", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "ocr_results", "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(ctx, r.db, "queue_tasks", "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS idx_file_metadata_tenant ON file_metadata(tenant, storage_provider)"); err != nil {
+		return err
+	}
+	return ensureColumn(ctx, r.db, "ocr_pages", "preprocess_steps", "TEXT")
+}
+
+// ensureColumn adds a column to an existing table unless it is already present.
+func ensureColumn(ctx context.Context, db *sql.DB, table string, column string, decl string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
 }
 
 func (r *sqliteFileMetadataRepository) Create(ctx context.Context, metadata *FileMetadata) error {
 	query := `
-		INSERT OR REPLACE INTO file_metadata (filename, namespace, size, storage_provider, storage_path, uploaded_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT OR REPLACE INTO file_metadata (filename, namespace, size, storage_provider, storage_path, uploaded_at, tenant, owner)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
 	uploadedAt := metadata.UploadedAt
@@ -191,19 +502,21 @@ func (r *sqliteFileMetadataRepository) Create(ctx context.Context, metadata *Fil
 		metadata.StorageProvider,
 		metadata.StoragePath,
 		uploadedAt,
+		metadata.Tenant,
+		metadata.Owner,
 	)
 	return err
 }
 
-func (r *sqliteFileMetadataRepository) ListByProvider(ctx context.Context, provider string) ([]*pb.FileInfo, error) {
+func (r *sqliteFileMetadataRepository) ListByProvider(ctx context.Context, provider string, tenant string) ([]*pb.FileInfo, error) {
 	query := `
 		SELECT filename, namespace, size, uploaded_at
 		FROM file_metadata
-		WHERE storage_provider = ?
+		WHERE storage_provider = ? AND (? = '' OR tenant = ?)
 		ORDER BY uploaded_at DESC
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, provider)
+	rows, err := r.db.QueryContext(ctx, query, provider, tenant, tenant)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query files: %w", err)
 	}
@@ -250,7 +563,7 @@ func (r *sqliteFileMetadataRepository) ListByProvider(ctx context.Context, provi
 
 func (r *sqliteFileMetadataRepository) FindByFilename(ctx context.Context, filename string, provider string) (*FileMetadata, error) {
 	query := `
-		SELECT id, filename, namespace, size, storage_provider, storage_path, uploaded_at
+		SELECT id, filename, namespace, size, storage_provider, storage_path, uploaded_at, tenant, owner
 		FROM file_metadata
 		WHERE filename = ? AND storage_provider = ?
 	`
@@ -264,6 +577,8 @@ func (r *sqliteFileMetadataRepository) FindByFilename(ctx context.Context, filen
 		&metadata.StorageProvider,
 		&metadata.StoragePath,
 		&metadata.UploadedAt,
+		&metadata.Tenant,
+		&metadata.Owner,
 	)
 
 	if err == sql.ErrNoRows {
@@ -282,6 +597,72 @@ func (r *sqliteFileMetadataRepository) Delete(ctx context.Context, filename stri
 	return err
 }
 
+func (r *sqliteFileMetadataRepository) TenantUsage(ctx context.Context, tenant string) (TenantUsage, error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM file_metadata WHERE tenant = ?`
+	var usage TenantUsage
+	if err := r.db.QueryRowContext(ctx, query, tenant).Scan(&usage.Files, &usage.Bytes); err != nil {
+		return TenantUsage{}, fmt.Errorf("failed to read tenant usage: %w", err)
+	}
+	return usage, nil
+}
+
+func (r *sqliteFileMetadataRepository) ListStoragePaths(ctx context.Context, provider string) ([]string, error) {
+	query := `
+		SELECT storage_path FROM file_metadata WHERE storage_provider = ? AND storage_path != ''
+		UNION
+		SELECT storage_path FROM derived_files WHERE storage_provider = ?
+	`
+	rows, err := r.db.QueryContext(ctx, query, provider, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan storage path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func (r *sqliteFileMetadataRepository) SaveStagedUpload(ctx context.Context, upload *StagedUpload) error {
+	query := `INSERT OR REPLACE INTO staged_uploads (id, storage_provider, storage_path, expires_at) VALUES (?, ?, ?, ?)`
+	if _, err := r.db.ExecContext(ctx, query, upload.ID, upload.Provider, upload.StoragePath, upload.ExpiresAt.UTC().Truncate(time.Second)); err != nil {
+		return fmt.Errorf("failed to save staged upload: %w", err)
+	}
+	return nil
+}
+
+func (r *sqliteFileMetadataRepository) DeleteStagedUpload(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM staged_uploads WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete staged upload: %w", err)
+	}
+	return nil
+}
+
+func (r *sqliteFileMetadataRepository) ListExpiredStagedUploads(ctx context.Context, t time.Time) ([]*StagedUpload, error) {
+	query := `SELECT id, storage_provider, storage_path, expires_at FROM staged_uploads WHERE expires_at < ? ORDER BY expires_at`
+	rows, err := r.db.QueryContext(ctx, query, t.UTC().Truncate(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []*StagedUpload
+	for rows.Next() {
+		upload := &StagedUpload{}
+		if err := rows.Scan(&upload.ID, &upload.Provider, &upload.StoragePath, &upload.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan staged upload: %w", err)
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
 func (r *sqliteFileMetadataRepository) Close() error {
 	return r.db.Close()
 }
@@ -330,8 +711,8 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 	// OCR?????
 	query := `
 		INSERT OR REPLACE INTO ocr_results 
-		(filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		(filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at, preprocess_profile, detected_language, languages, engine_agreement, engine_version, tenant)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
 	processedAt := result.ProcessedAt
@@ -372,6 +753,12 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 		errorMsg,
 		avgConfidence,
 		processedAt,
+		result.PreprocessProfile,
+		result.DetectedLanguage,
+		strings.Join(result.Languages, ","),
+		encodeEngineAgreement(result.EngineAgreement),
+		result.EngineVersion,
+		TenantOfFilename(result.Filename),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save OCR result: %w", err)
@@ -391,8 +778,8 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 	// OCR??????
 	if len(result.Pages) > 0 {
 		pageQuery := `
-			INSERT INTO ocr_pages (ocr_result_id, page_number, text, confidence)
-			VALUES (?, ?, ?, ?)
+			INSERT INTO ocr_pages (ocr_result_id, page_number, text, confidence, preprocess_steps, engine_agreement, engine_name, classification)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		`
 		for _, page := range result.Pages {
 			_, err = tx.ExecContext(ctx, pageQuery,
@@ -400,6 +787,10 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 				page.PageNumber,
 				page.Text,
 				page.Confidence,
+				encodePreprocessSteps(page.PreprocessSteps),
+				encodeEngineAgreement(page.EngineAgreement),
+				page.EngineName,
+				page.Classification,
 			)
 			if err != nil {
 				return fmt.Errorf("failed to save OCR page: %w", err)
@@ -407,6 +798,16 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 		}
 	}
 	
+	if err := saveOCRLayouts(ctx, tx, ocrResultID, result.Pages); err != nil {
+		return err
+	}
+	if err := saveExtractedTables(ctx, tx, ocrResultID, result.Pages); err != nil {
+		return err
+	}
+	if err := saveBarcodes(ctx, tx, ocrResultID, result.Barcodes); err != nil {
+		return err
+	}
+	
 	// ????????????
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -418,7 +819,7 @@ func (r *sqliteOCRResultRepository) SaveOCRResult(ctx context.Context, result *O
 // GetOCRResult ?OCR???????
 func (r *sqliteOCRResultRepository) GetOCRResult(ctx context.Context, filename string, provider string, engineName string) (*OCRResult, error) {
 	query := `
-		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at
+		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at, preprocess_profile, detected_language, languages, engine_agreement, engine_version
 		FROM ocr_results
 		WHERE filename = ? AND storage_provider = ? AND engine_name = ?
 	`
@@ -427,6 +828,7 @@ func (r *sqliteOCRResultRepository) GetOCRResult(ctx context.Context, filename s
 	var resultID int64
 	var errorMsg sql.NullString
 	var processedAt sql.NullTime
+	var preprocessProfile, detectedLanguage, languages, agreement, engineVersion sql.NullString
 	
 	err := r.db.QueryRowContext(ctx, query, filename, provider, engineName).Scan(
 		&resultID,
@@ -438,6 +840,11 @@ func (r *sqliteOCRResultRepository) GetOCRResult(ctx context.Context, filename s
 		&errorMsg,
 		&result.Confidence,
 		&processedAt,
+		&preprocessProfile,
+		&detectedLanguage,
+		&languages,
+		&agreement,
+		&engineVersion,
 	)
 	
 	if err == sql.ErrNoRows {
@@ -453,10 +860,16 @@ func (r *sqliteOCRResultRepository) GetOCRResult(ctx context.Context, filename s
 	if processedAt.Valid {
 		result.ProcessedAt = processedAt.Time
 	}
+	result.PreprocessProfile = preprocessProfile.String
+	result.DetectedLanguage = detectedLanguage.String
+	result.Languages = splitLanguages(languages.String)
+	result.EngineAgreement = decodeEngineAgreement(agreement.String)
+	result.EngineVersion = engineVersion.String
+	result.Tenant = TenantOfFilename(result.Filename)
 	
 	// ????????
 	pagesQuery := `
-		SELECT page_number, text, confidence
+		SELECT page_number, text, confidence, preprocess_steps, engine_agreement, engine_name, classification
 		FROM ocr_pages
 		WHERE ocr_result_id = ?
 		ORDER BY page_number
@@ -470,20 +883,329 @@ func (r *sqliteOCRResultRepository) GetOCRResult(ctx context.Context, filename s
 	
 	for rows.Next() {
 		var page OCRPage
-		if err := rows.Scan(&page.PageNumber, &page.Text, &page.Confidence); err != nil {
+		var steps, pageAgreement, pageEngine, classification sql.NullString
+		if err := rows.Scan(&page.PageNumber, &page.Text, &page.Confidence, &steps, &pageAgreement, &pageEngine, &classification); err != nil {
 			log.Printf("Error scanning OCR page row: %v", err)
 			continue
 		}
+		page.PreprocessSteps = decodePreprocessSteps(steps.String)
+		page.EngineAgreement = decodeEngineAgreement(pageAgreement.String)
+		page.EngineName = pageEngine.String
+		page.Classification = classification.String
 		result.Pages = append(result.Pages, page)
 	}
 	
+	result.Barcodes, err = r.loadBarcodes(ctx, resultID)
+	if err != nil {
+		return nil, err
+	}
+	
 	return &result, nil
 }
 
+// splitLanguages parses the comma-separated languages column.
+func splitLanguages(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// encodePreprocessSteps stores page preprocessing steps as a JSON array.
+func encodePreprocessSteps(steps []string) string {
+	if len(steps) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(steps)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodePreprocessSteps is the inverse of encodePreprocessSteps.
+func decodePreprocessSteps(data string) []string {
+	if data == "" {
+		return nil
+	}
+	var steps []string
+	if err := json.Unmarshal([]byte(data), &steps); err != nil {
+		log.Printf("Ignoring malformed preprocess steps %q: %v", data, err)
+		return nil
+	}
+	return steps
+}
+
+// encodeEngineAgreement stores ensemble agreement scores as a JSON array.
+func encodeEngineAgreement(agreement []EngineAgreement) string {
+	if len(agreement) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(agreement)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeEngineAgreement is the inverse of encodeEngineAgreement.
+func decodeEngineAgreement(data string) []EngineAgreement {
+	if data == "" {
+		return nil
+	}
+	var agreement []EngineAgreement
+	if err := json.Unmarshal([]byte(data), &agreement); err != nil {
+		log.Printf("Ignoring malformed engine agreement %q: %v", data, err)
+		return nil
+	}
+	return agreement
+}
+
+// saveExtractedTables replaces the tables stored for an OCR result.
+func saveExtractedTables(ctx context.Context, tx *sql.Tx, ocrResultID int64, pages []OCRPage) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ocr_tables WHERE ocr_result_id = ?", ocrResultID); err != nil {
+		return fmt.Errorf("failed to delete existing tables: %w", err)
+	}
+	for _, page := range pages {
+		for _, table := range page.Tables {
+			cells, err := json.Marshal(table.Cells)
+			if err != nil {
+				return fmt.Errorf("failed to encode table cells: %w", err)
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO ocr_tables
+				(ocr_result_id, page_number, table_index, rows, columns, ruled, confidence, x0, y0, x1, y1, cells)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, ocrResultID, page.PageNumber, table.Index, table.Rows, table.Columns, table.Ruled, table.Confidence,
+				table.BBox.X0, table.BBox.Y0, table.BBox.X1, table.BBox.Y1, string(cells))
+			if err != nil {
+				return fmt.Errorf("failed to save table %d of page %d: %w", table.Index, page.PageNumber, err)
+			}
+		}
+	}
+	return nil
+}
+
+// saveBarcodes replaces the barcodes stored for an OCR result.
+func saveBarcodes(ctx context.Context, tx *sql.Tx, ocrResultID int64, barcodes []DetectedBarcode) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ocr_barcodes WHERE ocr_result_id = ?", ocrResultID); err != nil {
+		return fmt.Errorf("failed to delete existing barcodes: %w", err)
+	}
+	for _, b := range barcodes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO ocr_barcodes
+			(ocr_result_id, page_number, symbology, payload, raw, x0, y0, x1, y1)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, ocrResultID, b.PageNumber, b.Symbology, b.Payload, b.Raw, b.BBox.X0, b.BBox.Y0, b.BBox.X1, b.BBox.Y1)
+		if err != nil {
+			return fmt.Errorf("failed to save %s barcode of page %d: %w", b.Symbology, b.PageNumber, err)
+		}
+	}
+	return nil
+}
+
+// loadBarcodes returns the barcodes of an OCR result in page order.
+func (r *sqliteOCRResultRepository) loadBarcodes(ctx context.Context, ocrResultID int64) ([]DetectedBarcode, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT page_number, symbology, payload, raw, x0, y0, x1, y1
+		FROM ocr_barcodes
+		WHERE ocr_result_id = ?
+		ORDER BY page_number, id
+	`, ocrResultID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query barcodes: %w", err)
+	}
+	defer rows.Close()
+
+	var barcodes []DetectedBarcode
+	for rows.Next() {
+		var b DetectedBarcode
+		if err := rows.Scan(&b.PageNumber, &b.Symbology, &b.Payload, &b.Raw, &b.BBox.X0, &b.BBox.Y0, &b.BBox.X1, &b.BBox.Y1); err != nil {
+			return nil, fmt.Errorf("failed to scan barcode: %w", err)
+		}
+		barcodes = append(barcodes, b)
+	}
+	return barcodes, rows.Err()
+}
+
+// GetExtractedTables returns the tables of an OCR result in page order; nil
+// when there is no result.
+func (r *sqliteOCRResultRepository) GetExtractedTables(ctx context.Context, filename string, provider string, engineName string) ([]ExtractedTable, error) {
+	var resultID int64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id FROM ocr_results
+		WHERE filename = ? AND storage_provider = ? AND engine_name = ?
+	`, filename, provider, engineName).Scan(&resultID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OCR result: %w", err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT page_number, table_index, rows, columns, ruled, confidence, x0, y0, x1, y1, cells
+		FROM ocr_tables
+		WHERE ocr_result_id = ?
+		ORDER BY page_number, table_index
+	`, resultID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tables: %w", err)
+	}
+	defer rows.Close()
+
+	tables := []ExtractedTable{}
+	for rows.Next() {
+		var t ExtractedTable
+		var confidence sql.NullFloat64
+		var cells string
+		if err := rows.Scan(&t.PageNumber, &t.Index, &t.Rows, &t.Columns, &t.Ruled, &confidence,
+			&t.BBox.X0, &t.BBox.Y0, &t.BBox.X1, &t.BBox.Y1, &cells); err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		t.Confidence = confidence.Float64
+		if err := json.Unmarshal([]byte(cells), &t.Cells); err != nil {
+			return nil, fmt.Errorf("failed to decode cells of table %d on page %d: %w", t.Index, t.PageNumber, err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// saveOCRLayouts replaces the layout rows of an OCR result.
+func saveOCRLayouts(ctx context.Context, tx *sql.Tx, ocrResultID int64, pages []OCRPage) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ocr_page_layouts WHERE ocr_result_id = ?", ocrResultID); err != nil {
+		return fmt.Errorf("failed to delete existing page layouts: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ocr_layout_words WHERE ocr_result_id = ?", ocrResultID); err != nil {
+		return fmt.Errorf("failed to delete existing layout words: %w", err)
+	}
+	
+	wordStmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO ocr_layout_words
+		(ocr_result_id, page_number, block_index, line_index, word_index, text, confidence, x0, y0, x1, y1)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare layout word insert: %w", err)
+	}
+	defer wordStmt.Close()
+	
+	for _, page := range pages {
+		if page.Layout == nil {
+			continue
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO ocr_page_layouts (ocr_result_id, page_number, width, height) VALUES (?, ?, ?, ?)",
+			ocrResultID, page.PageNumber, page.Layout.Width, page.Layout.Height)
+		if err != nil {
+			return fmt.Errorf("failed to save page layout: %w", err)
+		}
+		for bi, block := range page.Layout.Blocks {
+			for li, line := range block.Lines {
+				for wi, w := range line.Words {
+					_, err := wordStmt.ExecContext(ctx,
+						ocrResultID, page.PageNumber, bi, li, wi, w.Text, w.Confidence,
+						w.BBox.X0, w.BBox.Y0, w.BBox.X1, w.BBox.Y1)
+					if err != nil {
+						return fmt.Errorf("failed to save layout word: %w", err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetOCRLayout returns the word-level layout of an OCR result. Pages without a
+// stored layout are omitted.
+func (r *sqliteOCRResultRepository) GetOCRLayout(ctx context.Context, filename string, provider string, engineName string) (*OCRLayout, error) {
+	var resultID int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id FROM ocr_results WHERE filename = ? AND storage_provider = ? AND engine_name = ?",
+		filename, provider, engineName).Scan(&resultID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OCR result: %w", err)
+	}
+	
+	layout := &OCRLayout{
+		Filename:        filename,
+		StorageProvider: provider,
+		EngineName:      engineName,
+	}
+	
+	pageRows, err := r.db.QueryContext(ctx,
+		"SELECT page_number, width, height FROM ocr_page_layouts WHERE ocr_result_id = ? ORDER BY page_number",
+		resultID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query page layouts: %w", err)
+	}
+	pageIndex := map[int]int{}
+	for pageRows.Next() {
+		var page OCRLayoutPage
+		if err := pageRows.Scan(&page.PageNumber, &page.Width, &page.Height); err != nil {
+			pageRows.Close()
+			return nil, fmt.Errorf("failed to scan page layout: %w", err)
+		}
+		pageIndex[page.PageNumber] = len(layout.Pages)
+		layout.Pages = append(layout.Pages, page)
+	}
+	pageRows.Close()
+	if err := pageRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read page layouts: %w", err)
+	}
+	
+	wordRows, err := r.db.QueryContext(ctx, `
+		SELECT page_number, block_index, line_index, text, confidence, x0, y0, x1, y1
+		FROM ocr_layout_words
+		WHERE ocr_result_id = ?
+		ORDER BY page_number, block_index, line_index, word_index
+	`, resultID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query layout words: %w", err)
+	}
+	defer wordRows.Close()
+	
+	for wordRows.Next() {
+		var pageNumber, blockIndex, lineIndex int
+		var confidence sql.NullFloat64
+		var w OCRWord
+		if err := wordRows.Scan(&pageNumber, &blockIndex, &lineIndex, &w.Text, &confidence,
+			&w.BBox.X0, &w.BBox.Y0, &w.BBox.X1, &w.BBox.Y1); err != nil {
+			return nil, fmt.Errorf("failed to scan layout word: %w", err)
+		}
+		w.Confidence = confidence.Float64
+		pi, ok := pageIndex[pageNumber]
+		if !ok {
+			continue
+		}
+		page := &layout.Pages[pi]
+		for len(page.Blocks) <= blockIndex {
+			page.Blocks = append(page.Blocks, OCRBlock{})
+		}
+		block := &page.Blocks[blockIndex]
+		for len(block.Lines) <= lineIndex {
+			block.Lines = append(block.Lines, OCRLine{})
+		}
+		block.Lines[lineIndex].Words = append(block.Lines[lineIndex].Words, w)
+	}
+	if err := wordRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read layout words: %w", err)
+	}
+	
+	for i := range layout.Pages {
+		layout.Pages[i].recomputeBoxes()
+	}
+	return layout, nil
+}
+
 // ListOCRResults ??????????OCR?????????
 func (r *sqliteOCRResultRepository) ListOCRResults(ctx context.Context, provider string) ([]*OCRResult, error) {
 	query := `
-		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at
+		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at, preprocess_profile, detected_language, languages, engine_agreement, engine_version
 		FROM ocr_results
 		WHERE storage_provider = ?
 		ORDER BY processed_at DESC
@@ -501,6 +1223,7 @@ func (r *sqliteOCRResultRepository) ListOCRResults(ctx context.Context, provider
 		var resultID int64
 		var errorMsg sql.NullString
 		var processedAt sql.NullTime
+		var preprocessProfile, detectedLanguage, languages, agreement, engineVersion sql.NullString
 		
 		if err := rows.Scan(
 			&resultID,
@@ -512,6 +1235,11 @@ func (r *sqliteOCRResultRepository) ListOCRResults(ctx context.Context, provider
 			&errorMsg,
 			&result.Confidence,
 			&processedAt,
+			&preprocessProfile,
+			&detectedLanguage,
+			&languages,
+			&agreement,
+			&engineVersion,
 		); err != nil {
 			log.Printf("Error scanning OCR result row: %v", err)
 			continue
@@ -523,6 +1251,12 @@ func (r *sqliteOCRResultRepository) ListOCRResults(ctx context.Context, provider
 		if processedAt.Valid {
 			result.ProcessedAt = processedAt.Time
 		}
+		result.PreprocessProfile = preprocessProfile.String
+		result.DetectedLanguage = detectedLanguage.String
+		result.Languages = splitLanguages(languages.String)
+		result.EngineAgreement = decodeEngineAgreement(agreement.String)
+		result.EngineVersion = engineVersion.String
+		result.Tenant = TenantOfFilename(result.Filename)
 		
 		results = append(results, &result)
 	}
@@ -533,7 +1267,7 @@ func (r *sqliteOCRResultRepository) ListOCRResults(ctx context.Context, provider
 // GetOCRComparison ???OCR????????????
 func (r *sqliteOCRResultRepository) GetOCRComparison(ctx context.Context, filename string, provider string) ([]*OCRResult, error) {
 	query := `
-		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at
+		SELECT id, filename, storage_provider, engine_name, status, extracted_text, error_message, average_confidence, processed_at, preprocess_profile, detected_language, languages, engine_agreement, engine_version
 		FROM ocr_results
 		WHERE filename = ? AND storage_provider = ?
 		ORDER BY engine_name
@@ -551,6 +1285,7 @@ func (r *sqliteOCRResultRepository) GetOCRComparison(ctx context.Context, filena
 		var resultID int64
 		var errorMsg sql.NullString
 		var processedAt sql.NullTime
+		var preprocessProfile, detectedLanguage, languages, agreement, engineVersion sql.NullString
 		
 		if err := rows.Scan(
 			&resultID,
@@ -562,6 +1297,11 @@ func (r *sqliteOCRResultRepository) GetOCRComparison(ctx context.Context, filena
 			&errorMsg,
 			&result.Confidence,
 			&processedAt,
+			&preprocessProfile,
+			&detectedLanguage,
+			&languages,
+			&agreement,
+			&engineVersion,
 		); err != nil {
 			log.Printf("Error scanning OCR result row: %v", err)
 			continue
@@ -573,10 +1313,16 @@ func (r *sqliteOCRResultRepository) GetOCRComparison(ctx context.Context, filena
 		if processedAt.Valid {
 			result.ProcessedAt = processedAt.Time
 		}
+		result.PreprocessProfile = preprocessProfile.String
+		result.DetectedLanguage = detectedLanguage.String
+		result.Languages = splitLanguages(languages.String)
+		result.EngineAgreement = decodeEngineAgreement(agreement.String)
+		result.EngineVersion = engineVersion.String
+		result.Tenant = TenantOfFilename(result.Filename)
 		
 		// ?????????
 		pagesQuery := `
-			SELECT page_number, text, confidence
+			SELECT page_number, text, confidence, preprocess_steps, engine_agreement, engine_name, classification
 			FROM ocr_pages
 			WHERE ocr_result_id = ?
 			ORDER BY page_number
@@ -587,11 +1333,19 @@ func (r *sqliteOCRResultRepository) GetOCRComparison(ctx context.Context, filena
 			defer pageRows.Close()
 			for pageRows.Next() {
 				var page OCRPage
-				if err := pageRows.Scan(&page.PageNumber, &page.Text, &page.Confidence); err == nil {
+				var steps, pageAgreement, pageEngine, classification sql.NullString
+				if err := pageRows.Scan(&page.PageNumber, &page.Text, &page.Confidence, &steps, &pageAgreement, &pageEngine, &classification); err == nil {
+					page.PreprocessSteps = decodePreprocessSteps(steps.String)
+					page.EngineAgreement = decodeEngineAgreement(pageAgreement.String)
+					page.EngineName = pageEngine.String
+					page.Classification = classification.String
 					result.Pages = append(result.Pages, page)
 				}
 			}
 		}
+		if barcodes, err := r.loadBarcodes(ctx, resultID); err == nil {
+			result.Barcodes = barcodes
+		}
 		
 		results = append(results, &result)
 	}
@@ -606,6 +1360,467 @@ func (r *sqliteOCRResultRepository) DeleteOCRResult(ctx context.Context, filenam
 	return err
 }
 
+// SaveDerivedFile records a generated file, replacing any previous one of the same kind.
+func (r *sqliteOCRResultRepository) SaveDerivedFile(ctx context.Context, file *DerivedFile) error {
+	createdAt := file.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	query := `
+		INSERT OR REPLACE INTO derived_files
+		(filename, storage_provider, engine_name, kind, storage_path, page_count, size, source_processed_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		file.Filename, file.StorageProvider, file.EngineName, file.Kind, file.StoragePath,
+		file.PageCount, file.Size, file.SourceProcessedAt, createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to save derived file: %w", err)
+	}
+	return nil
+}
+
+// GetDerivedFile returns a generated file record, or nil when there is none.
+func (r *sqliteOCRResultRepository) GetDerivedFile(ctx context.Context, filename string, provider string, engineName string, kind string) (*DerivedFile, error) {
+	query := `
+		SELECT filename, storage_provider, engine_name, kind, storage_path, page_count, size, source_processed_at, created_at
+		FROM derived_files
+		WHERE filename = ? AND storage_provider = ? AND engine_name = ? AND kind = ?
+	`
+	var file DerivedFile
+	var sourceProcessedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, filename, provider, engineName, kind).Scan(
+		&file.Filename,
+		&file.StorageProvider,
+		&file.EngineName,
+		&file.Kind,
+		&file.StoragePath,
+		&file.PageCount,
+		&file.Size,
+		&sourceProcessedAt,
+		&file.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get derived file: %w", err)
+	}
+	if sourceProcessedAt.Valid {
+		file.SourceProcessedAt = sourceProcessedAt.Time
+	}
+	return &file, nil
+}
+
+// SaveGroundTruth replaces the reference text of a file. A ground truth with
+// pages is stored per page; otherwise as page 0 for the whole document.
+func (r *sqliteOCRResultRepository) SaveGroundTruth(ctx context.Context, gt *GroundTruth) error {
+	updatedAt := gt.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ocr_ground_truth WHERE filename = ? AND storage_provider = ?`,
+		gt.Filename, gt.StorageProvider); err != nil {
+		return fmt.Errorf("failed to replace ground truth: %w", err)
+	}
+	pages := gt.Pages
+	if len(pages) == 0 {
+		pages = []GroundTruthPage{{PageNumber: 0, Text: gt.Text}}
+	}
+	for _, page := range pages {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO ocr_ground_truth (filename, storage_provider, page_number, text, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, gt.Filename, gt.StorageProvider, page.PageNumber, page.Text, updatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save ground truth page %d: %w", page.PageNumber, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetGroundTruth returns the reference text of a file, or nil when there is none.
+func (r *sqliteOCRResultRepository) GetGroundTruth(ctx context.Context, filename string, provider string) (*GroundTruth, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT page_number, text, updated_at
+		FROM ocr_ground_truth
+		WHERE filename = ? AND storage_provider = ?
+		ORDER BY page_number
+	`, filename, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ground truth: %w", err)
+	}
+	defer rows.Close()
+
+	var gt *GroundTruth
+	var texts []string
+	for rows.Next() {
+		var page GroundTruthPage
+		var updatedAt time.Time
+		if err := rows.Scan(&page.PageNumber, &page.Text, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ground truth: %w", err)
+		}
+		if gt == nil {
+			gt = &GroundTruth{Filename: filename, StorageProvider: provider, UpdatedAt: updatedAt}
+		}
+		texts = append(texts, page.Text)
+		if page.PageNumber > 0 {
+			gt.Pages = append(gt.Pages, page)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if gt != nil {
+		gt.Text = strings.Join(texts, "\f")
+	}
+	return gt, nil
+}
+
+// ListGroundTruth returns the files of a provider that have ground truth.
+func (r *sqliteOCRResultRepository) ListGroundTruth(ctx context.Context, provider string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT DISTINCT filename FROM ocr_ground_truth
+		WHERE storage_provider = ?
+		ORDER BY filename
+	`, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ground truth: %w", err)
+	}
+	defer rows.Close()
+
+	var filenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, fmt.Errorf("failed to scan ground truth: %w", err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, rows.Err()
+}
+
+// SaveOCREvaluation records the aggregate of an engine version on a dataset,
+// replacing the previous run of the same version.
+func (r *sqliteOCRResultRepository) SaveOCREvaluation(ctx context.Context, ev *OCREvaluation) error {
+	details, err := json.Marshal(ev.FileResults)
+	if err != nil {
+		return fmt.Errorf("failed to encode evaluation details: %w", err)
+	}
+	evaluatedAt := ev.EvaluatedAt
+	if evaluatedAt.IsZero() {
+		evaluatedAt = time.Now()
+	}
+	query := `
+		INSERT OR REPLACE INTO ocr_evaluations
+		(dataset, engine_name, engine_version, preprocess_profile, files, pages,
+		 ref_chars, char_errors, ref_words, word_errors, cer, wer, details, evaluated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = r.db.ExecContext(ctx, query,
+		ev.Dataset, ev.EngineName, ev.EngineVersion, ev.PreprocessProfile, ev.Files, ev.Pages,
+		ev.RefChars, ev.CharErrors, ev.RefWords, ev.WordErrors, ev.CER(), ev.WER(), string(details), evaluatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save OCR evaluation: %w", err)
+	}
+	return nil
+}
+
+// ListOCREvaluations returns the stored aggregates without file details,
+// newest first.
+func (r *sqliteOCRResultRepository) ListOCREvaluations(ctx context.Context, dataset string) ([]*OCREvaluation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT dataset, engine_name, engine_version, preprocess_profile, files, pages,
+		       ref_chars, char_errors, ref_words, word_errors, evaluated_at
+		FROM ocr_evaluations
+		WHERE ? = '' OR dataset = ?
+		ORDER BY evaluated_at DESC, dataset, engine_name, engine_version
+	`, dataset, dataset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OCR evaluations: %w", err)
+	}
+	defer rows.Close()
+
+	var evaluations []*OCREvaluation
+	for rows.Next() {
+		var ev OCREvaluation
+		if err := rows.Scan(&ev.Dataset, &ev.EngineName, &ev.EngineVersion, &ev.PreprocessProfile, &ev.Files, &ev.Pages,
+			&ev.RefChars, &ev.CharErrors, &ev.RefWords, &ev.WordErrors, &ev.EvaluatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan OCR evaluation: %w", err)
+		}
+		evaluations = append(evaluations, &ev)
+	}
+	return evaluations, rows.Err()
+}
+
+// SaveExtractedFields replaces the fields read from a file and their search rows.
+func (r *sqliteOCRResultRepository) SaveExtractedFields(ctx context.Context, fields *ExtractedFields) error {
+	fieldsJSON, err := json.Marshal(fields.Fields)
+	if err != nil {
+		return fmt.Errorf("failed to encode extracted fields: %w", err)
+	}
+	errorsJSON, err := json.Marshal(fields.ValidationErrors)
+	if err != nil {
+		return fmt.Errorf("failed to encode validation errors: %w", err)
+	}
+	extractedAt := fields.ExtractedAt
+	if extractedAt.IsZero() {
+		extractedAt = time.Now()
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The old search rows go with the old record (ON DELETE CASCADE).
+	if _, err := tx.ExecContext(ctx, `DELETE FROM extracted_fields WHERE filename = ? AND storage_provider = ?`,
+		fields.Filename, fields.StorageProvider); err != nil {
+		return fmt.Errorf("failed to replace extracted fields: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO extracted_fields
+		(filename, storage_provider, engine_name, document_type, fields, validation_errors, valid, extracted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, fields.Filename, fields.StorageProvider, fields.EngineName, fields.DocumentType,
+		string(fieldsJSON), string(errorsJSON), fields.Valid(), extractedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save extracted fields: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get extracted fields ID: %w", err)
+	}
+	for _, field := range fields.Fields {
+		var number sql.NullFloat64
+		if field.Type == FieldTypeNumber || field.Type == FieldTypeAmount {
+			number = sql.NullFloat64{Float64: field.Number, Valid: field.Normalized != ""}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO extracted_field_values (extracted_fields_id, field_name, value, number)
+			VALUES (?, ?, ?, ?)
+		`, id, field.Name, field.Normalized, number); err != nil {
+			return fmt.Errorf("failed to save field %s: %w", field.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetExtractedFields returns the fields read from a file, or nil when there are none.
+func (r *sqliteOCRResultRepository) GetExtractedFields(ctx context.Context, filename string, provider string) (*ExtractedFields, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT filename, storage_provider, engine_name, document_type, fields, validation_errors, extracted_at
+		FROM extracted_fields
+		WHERE filename = ? AND storage_provider = ?
+	`, filename, provider)
+	fields, err := scanExtractedFields(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extracted fields: %w", err)
+	}
+	return fields, nil
+}
+
+// SearchExtractedFields returns the newest matching files, up to query.Limit,
+// and counts the values of the facet fields over all matches.
+func (r *sqliteOCRResultRepository) SearchExtractedFields(ctx context.Context, query DocumentSearchQuery) (*DocumentSearchResult, error) {
+	where := []string{"storage_provider = ?"}
+	args := []interface{}{query.StorageProvider}
+	if query.DocumentType != "" {
+		where = append(where, "document_type = ?")
+		args = append(args, query.DocumentType)
+	}
+	if query.ValidOnly {
+		where = append(where, "valid = 1")
+	}
+	if query.Tenant != "" {
+		prefix := TenantFileKey(query.Tenant, "")
+		where = append(where, "substr(filename, 1, ?) = ?")
+		args = append(args, len(prefix), prefix)
+	}
+	if len(query.Namespaces) > 0 {
+		marks := make([]string, len(query.Namespaces))
+		for i, ns := range query.Namespaces {
+			marks[i] = "?"
+			args = append(args, strings.TrimSuffix(ns, "/"))
+		}
+		where = append(where, `(SELECT m.namespace FROM file_metadata m
+			WHERE m.filename = extracted_fields.filename AND m.storage_provider = extracted_fields.storage_provider) IN (`+strings.Join(marks, ", ")+")")
+	}
+	for _, filter := range query.Filters {
+		cond := []string{"v.extracted_fields_id = extracted_fields.id", "v.field_name = ?"}
+		args = append(args, filter.Name)
+		if filter.Value != "" {
+			cond = append(cond, "v.value = ? COLLATE NOCASE")
+			args = append(args, filter.Value)
+		}
+		if filter.Min != nil {
+			cond = append(cond, "v.number >= ?")
+			args = append(args, *filter.Min)
+		}
+		if filter.Max != nil {
+			cond = append(cond, "v.number <= ?")
+			args = append(args, *filter.Max)
+		}
+		where = append(where, "EXISTS (SELECT 1 FROM extracted_field_values v WHERE "+strings.Join(cond, " AND ")+")")
+	}
+	matches := "SELECT id FROM extracted_fields WHERE " + strings.Join(where, " AND ")
+
+	result := &DocumentSearchResult{}
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+matches+")", args...).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("failed to search extracted fields: %w", err)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT filename, storage_provider, engine_name, document_type, fields, validation_errors, extracted_at
+		FROM extracted_fields
+		WHERE id IN (`+matches+`)
+		ORDER BY extracted_at DESC, filename
+		LIMIT ?
+	`, append(append([]interface{}(nil), args...), limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search extracted fields: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		fields, err := scanExtractedFields(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan extracted fields: %w", err)
+		}
+		result.Documents = append(result.Documents, fields)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	type facetQuery struct {
+		name  string
+		query string
+		args  []interface{}
+	}
+	facets := []facetQuery{{DocumentTypeFacet, `
+		SELECT document_type, COUNT(*) FROM extracted_fields
+		WHERE id IN (` + matches + `)
+		GROUP BY document_type ORDER BY COUNT(*) DESC, document_type
+	`, args}}
+	for _, name := range query.FacetFields {
+		if name == DocumentTypeFacet {
+			continue
+		}
+		facets = append(facets, facetQuery{name, `
+			SELECT value, COUNT(DISTINCT extracted_fields_id) FROM extracted_field_values
+			WHERE field_name = ? AND extracted_fields_id IN (` + matches + `)
+			GROUP BY value ORDER BY COUNT(DISTINCT extracted_fields_id) DESC, value
+		`, append([]interface{}{name}, args...)})
+	}
+	for _, f := range facets {
+		facet, err := r.countFacet(ctx, f.name, f.query, f.args)
+		if err != nil {
+			return nil, err
+		}
+		result.Facets = append(result.Facets, facet)
+	}
+	return result, nil
+}
+
+// countFacet runs a value, count query.
+func (r *sqliteOCRResultRepository) countFacet(ctx context.Context, name string, query string, args []interface{}) (FieldFacet, error) {
+	facet := FieldFacet{FieldName: name}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return facet, fmt.Errorf("failed to count facet %s: %w", name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value FacetValue
+		if err := rows.Scan(&value.Value, &value.Count); err != nil {
+			return facet, fmt.Errorf("failed to scan facet %s: %w", name, err)
+		}
+		facet.Values = append(facet.Values, value)
+	}
+	return facet, rows.Err()
+}
+
+// scanExtractedFields reads an extracted_fields row.
+func scanExtractedFields(row interface{ Scan(...interface{}) error }) (*ExtractedFields, error) {
+	var fields ExtractedFields
+	var fieldsJSON string
+	var errorsJSON sql.NullString
+	if err := row.Scan(&fields.Filename, &fields.StorageProvider, &fields.EngineName, &fields.DocumentType,
+		&fieldsJSON, &errorsJSON, &fields.ExtractedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(fieldsJSON), &fields.Fields); err != nil {
+		return nil, fmt.Errorf("failed to decode extracted fields: %w", err)
+	}
+	if errorsJSON.Valid && errorsJSON.String != "" {
+		if err := json.Unmarshal([]byte(errorsJSON.String), &fields.ValidationErrors); err != nil {
+			return nil, fmt.Errorf("failed to decode validation errors: %w", err)
+		}
+	}
+	return &fields, nil
+}
+
+// SavePIIReport replaces the findings of a file.
+func (r *sqliteOCRResultRepository) SavePIIReport(ctx context.Context, report *PIIReport) error {
+	findingsJSON, err := json.Marshal(report.Findings)
+	if err != nil {
+		return fmt.Errorf("failed to encode PII findings: %w", err)
+	}
+	scannedAt := report.ScannedAt
+	if scannedAt.IsZero() {
+		scannedAt = time.Now()
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO pii_reports
+		(filename, storage_provider, engine_name, findings, finding_count, source_processed_at, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, report.Filename, report.StorageProvider, report.EngineName, string(findingsJSON), len(report.Findings),
+		report.SourceProcessedAt, scannedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save PII findings: %w", err)
+	}
+	return nil
+}
+
+// GetPIIReport returns the findings of a file, or nil when it was not scanned.
+func (r *sqliteOCRResultRepository) GetPIIReport(ctx context.Context, filename string, provider string) (*PIIReport, error) {
+	var report PIIReport
+	var findingsJSON string
+	var sourceProcessedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+		SELECT filename, storage_provider, engine_name, findings, source_processed_at, scanned_at
+		FROM pii_reports
+		WHERE filename = ? AND storage_provider = ?
+	`, filename, provider).Scan(&report.Filename, &report.StorageProvider, &report.EngineName,
+		&findingsJSON, &sourceProcessedAt, &report.ScannedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PII findings: %w", err)
+	}
+	if err := json.Unmarshal([]byte(findingsJSON), &report.Findings); err != nil {
+		return nil, fmt.Errorf("failed to decode PII findings: %w", err)
+	}
+	if sourceProcessedAt.Valid {
+		report.SourceProcessedAt = sourceProcessedAt.Time
+	}
+	return &report, nil
+}
+
 // LogError ???????????????????
 func (r *sqliteOCRResultRepository) LogError(ctx context.Context, filename string, provider string, engineName string, errorType string, errorMsg string) error {
 	query := `
@@ -2,22 +2,24 @@ package domain
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 // easyOCREngine EasyOCR???????
 type easyOCREngine struct {
 	languages []string // OCR??: ["ja", "en"]??
+
+	poolOnce sync.Once
+	pool     *easyOCRPool // started on first use so registering the engine stays cheap
 }
 
 // NewEasyOCREngine EasyOCR????????????
@@ -30,6 +32,36 @@ func NewEasyOCREngine(languages []string) OCREngine {
 	}
 }
 
+// DefaultLanguages returns the languages configured at startup.
+func (e *easyOCREngine) DefaultLanguages() []string {
+	codes, err := ParseOCRLanguages(e.languages)
+	if err != nil {
+		return nil
+	}
+	return codes
+}
+
+// SupportsLanguage reports whether EasyOCR has a model for the language; models
+// are downloaded on first use.
+func (e *easyOCREngine) SupportsLanguage(code string) bool {
+	_, ok := lookupOCRLanguage(code)
+	return ok
+}
+
+// LanguageProbes returns one set per CJK script: EasyOCR only combines
+// Japanese, Korean and Chinese models with English, never with each other.
+func (e *easyOCREngine) LanguageProbes() [][]string {
+	return [][]string{{"ja", "en"}, {"ko", "en"}, {"zh", "en"}}
+}
+
+// languageList returns the EasyOCR languages for this call.
+func (e *easyOCREngine) languageList(ctx context.Context) []string {
+	if codes := OCRLanguagesFromContext(ctx); len(codes) > 0 {
+		return easyOCRLanguageList(codes)
+	}
+	return e.languages
+}
+
 // Name ????????
 func (e *easyOCREngine) Name() string {
 	return "easyocr"
@@ -38,10 +70,11 @@ func (e *easyOCREngine) Name() string {
 // ProcessDocument ?????????OCR??
 func (e *easyOCREngine) ProcessDocument(ctx context.Context, filename string, content io.Reader) (*OCRResult, error) {
 	result := &OCRResult{
-		Filename:    filename,
-		EngineName:  e.Name(),
-		Status:      "processing",
-		ProcessedAt: time.Now(),
+		Filename:          filename,
+		EngineName:        e.Name(),
+		Status:            "processing",
+		ProcessedAt:       time.Now(),
+		PreprocessProfile: PreprocessorForEngine(e.Name()).Spec(),
 	}
 
 	// ?????
@@ -58,6 +91,11 @@ func (e *easyOCREngine) ProcessDocument(ctx context.Context, filename string, co
 		return e.processImageFile(ctx, filename, content, result)
 	}
 
+	// Office documents: native text extraction, embedded images go through OCR
+	if isOfficeFile(ext) || isLegacyOfficeFile(ext) {
+		return processOfficeDocument(ctx, e, filename, content, result)
+	}
+
 	// ?????????????????
 	result.Status = "failed"
 	result.Error = fmt.Errorf("unsupported file type: %s", ext)
@@ -66,23 +104,87 @@ func (e *easyOCREngine) ProcessDocument(ctx context.Context, filename string, co
 
 // ProcessImage ???OCR??
 func (e *easyOCREngine) ProcessImage(ctx context.Context, img image.Image) (string, float64, error) {
-	// ?????????????EasyOCR??
-	tempFile, err := saveImageToTempFileForEasyOCR(img)
+	text, confidence, _, err := e.ProcessImageWithLayout(ctx, img)
+	return text, confidence, err
+}
+
+// ProcessImageWithLayout runs OCR and builds the page layout from EasyOCR's
+// detection boxes.
+func (e *easyOCREngine) ProcessImageWithLayout(ctx context.Context, img image.Image) (string, float64, *OCRPageLayout, error) {
+	outputs, err := e.ProcessImagesWithLayout(ctx, []image.Image{img})
 	if err != nil {
-		return "", 0.0, fmt.Errorf("failed to save image to temp file: %w", err)
+		return "", 0.0, nil, err
 	}
+	out := outputs[0]
+	return out.Text, out.Confidence, out.Layout, out.Err
+}
+
+// BatchSize returns the number of pages sent to a worker per request.
+func (e *easyOCREngine) BatchSize() int {
+	return e.workerPool().cfg.BatchSize
+}
+
+// ProcessImagesWithLayout OCRs several images through the worker pool.
+func (e *easyOCREngine) ProcessImagesWithLayout(ctx context.Context, imgs []image.Image) ([]ImageOCROutput, error) {
+	// ?????????????EasyOCR??
+	paths := make([]string, 0, len(imgs))
 	defer func() {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
+		for _, p := range paths {
+			os.Remove(p)
+		}
 	}()
+	for _, img := range imgs {
+		tempFile, err := saveImageToTempFileForEasyOCR(img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save image to temp file: %w", err)
+		}
+		tempFile.Close()
+		paths = append(paths, tempFile.Name())
+	}
 
-	// EasyOCR?Python????????????
-	text, confidence, err := e.callEasyOCR(tempFile.Name())
+	results, err := e.workerPool().Recognize(ctx, e.languageList(ctx), paths)
 	if err != nil {
-		return "", 0.0, fmt.Errorf("EasyOCR processing failed: %w", err)
+		return nil, fmt.Errorf("EasyOCR processing failed: %w", err)
+	}
+
+	outputs := make([]ImageOCROutput, len(imgs))
+	for i, r := range results {
+		if r.Error != "" {
+			outputs[i].Err = fmt.Errorf("EasyOCR error: %s", r.Error)
+			continue
+		}
+		bounds := imgs[i].Bounds()
+		outputs[i] = ImageOCROutput{
+			Text:       r.Text,
+			Confidence: r.Confidence,
+			Layout:     buildLayoutFromDetections(bounds.Dx(), bounds.Dy(), r.detections()),
+		}
+	}
+	return outputs, nil
+}
+
+// workerPool starts the worker pool on first use.
+func (e *easyOCREngine) workerPool() *easyOCRPool {
+	e.poolOnce.Do(func() {
+		e.pool = newEasyOCRPool(EasyOCRPoolConfigFromEnv())
+	})
+	return e.pool
+}
+
+// Version returns the EasyOCR version of the workers; "" until the pool has started.
+func (e *easyOCREngine) Version() string {
+	if e.pool == nil {
+		return ""
 	}
+	return e.pool.Version()
+}
 
-	return text, confidence, nil
+// Close stops the worker processes.
+func (e *easyOCREngine) Close() error {
+	if e.pool == nil {
+		return nil
+	}
+	return e.pool.Close()
 }
 
 // saveImageToTempFileForEasyOCR ?????????????EasyOCR??
@@ -112,47 +214,6 @@ func saveImageToTempFileForEasyOCR(img image.Image) (*os.File, error) {
 	return readFile, nil
 }
 
-// callEasyOCR Python????????EasyOCR?????
-func (e *easyOCREngine) callEasyOCR(imagePath string) (string, float64, error) {
-	// Python???????????????????????: ?????????
-	scriptPath := os.Getenv("EASYOCR_SCRIPT_PATH")
-	if scriptPath == "" {
-		scriptPath = "/app/easyocr_script.py"
-	}
-	
-	// ???????????????
-	langsStr := strings.Join(e.languages, ",")
-	
-	// Python????????
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-	
-	cmd := exec.CommandContext(ctx, "python3", scriptPath, imagePath, langsStr)
-	
-	// ?????????????
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", 0.0, fmt.Errorf("EasyOCR script execution failed: %w (output: %s)", err, string(output))
-	}
-	
-	// JSON??????
-	var result struct {
-		Text       string  `json:"text"`
-		Confidence float64 `json:"confidence"`
-		Error      string  `json:"error,omitempty"`
-	}
-	
-	if err := json.Unmarshal(output, &result); err != nil {
-		return "", 0.0, fmt.Errorf("failed to parse EasyOCR output: %w (output: %s)", err, string(output))
-	}
-	
-	if result.Error != "" {
-		return "", 0.0, fmt.Errorf("EasyOCR error: %s", result.Error)
-	}
-	
-	return result.Text, result.Confidence, nil
-}
-
 // processPDF PDF??
 func (e *easyOCREngine) processPDF(ctx context.Context, filename string, content io.Reader, result *OCRResult) (*OCRResult, error) {
 	// PDF??
@@ -165,16 +226,17 @@ func (e *easyOCREngine) processPDF(ctx context.Context, filename string, content
 	}
 
 	log.Printf("PDF converted to %d images", len(images))
+	ctx = resolveDocumentLanguages(ctx, e, result, "", images)
 
 	// ????OCR??
 	var allText strings.Builder
 	var totalConfidence float64
 	pages := make([]OCRPage, 0, len(images))
 
-	for pageNum, img := range images {
-		// OCR??
-		text, confidence, err := e.ProcessImage(ctx, img)
-		if err != nil {
+	// Pages go to the worker pool in batches
+	recs, errs := recognizeImages(ctx, e, images, pdfRenderDPI)
+	for pageNum, rec := range recs {
+		if err := errs[pageNum]; err != nil {
 			log.Printf("Failed to process OCR for page %d: %v", pageNum+1, err)
 			// ?????????
 			pages = append(pages, OCRPage{
@@ -187,15 +249,17 @@ func (e *easyOCREngine) processPDF(ctx context.Context, filename string, content
 
 		// ?????
 		allText.WriteString(fmt.Sprintf("\n--- Page %d ---\n", pageNum+1))
-		allText.WriteString(text)
-		totalConfidence += confidence
+		allText.WriteString(rec.Text)
+		totalConfidence += rec.Confidence
 		pages = append(pages, OCRPage{
-			PageNumber: pageNum + 1,
-			Text:       text,
-			Confidence: confidence,
+			PageNumber:      pageNum + 1,
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
 		})
 
-		log.Printf("Processed page %d/%d: confidence=%.2f", pageNum+1, len(images), confidence)
+		log.Printf("Processed page %d/%d: confidence=%.2f", pageNum+1, len(images), rec.Confidence)
 	}
 
 	// ?????
@@ -227,9 +291,10 @@ func (e *easyOCREngine) processImageFile(ctx context.Context, filename string, c
 	}
 
 	log.Printf("Decoded image format: %s", format)
+	ctx = resolveDocumentLanguages(ctx, e, result, "", []image.Image{img})
 
 	// OCR??
-	text, confidence, err := e.ProcessImage(ctx, img)
+	rec, err := recognizeImage(ctx, e, img, 0)
 	if err != nil {
 		result.Status = "failed"
 		result.Error = fmt.Errorf("OCR processing failed: %w", err)
@@ -237,14 +302,16 @@ func (e *easyOCREngine) processImageFile(ctx context.Context, filename string, c
 	}
 
 	// ?????
-	result.ExtractedText = text
-	result.Confidence = confidence
+	result.ExtractedText = rec.Text
+	result.Confidence = rec.Confidence
 	result.Status = "completed"
 	result.Pages = []OCRPage{
 		{
-			PageNumber: 1,
-			Text:       text,
-			Confidence: confidence,
+			PageNumber:      1,
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
 		},
 	}
 
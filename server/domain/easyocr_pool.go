@@ -0,0 +1,467 @@
+package domain
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The EasyOCR pool keeps long-lived worker processes (easyocr_worker.py) so the
+// models are loaded once per language set instead of once per page. Requests and
+// responses are JSON frames over the worker's stdin/stdout, each prefixed with a
+// 4-byte big-endian length. The worker sends {"ready": true, "version": "..."}
+// once started.
+
+// maxEasyOCRFrameSize guards against reading garbage as a frame length.
+const maxEasyOCRFrameSize = 64 << 20
+
+// EasyOCRPoolConfig configures the worker pool.
+type EasyOCRPoolConfig struct {
+	Command        []string      // worker command line
+	Size           int           // number of worker processes
+	BatchSize      int           // images per request
+	RequestTimeout time.Duration // per image in a request
+	StartTimeout   time.Duration // until the worker reports ready
+	HealthInterval time.Duration // between pings of idle workers
+	HealthTimeout  time.Duration
+}
+
+// EasyOCRPoolConfigFromEnv reads EASYOCR_WORKER_COMMAND (default
+// "python3 $EASYOCR_WORKER_SCRIPT"), EASYOCR_WORKERS, EASYOCR_BATCH_SIZE,
+// EASYOCR_REQUEST_TIMEOUT, EASYOCR_START_TIMEOUT and EASYOCR_HEALTH_INTERVAL.
+func EasyOCRPoolConfigFromEnv() EasyOCRPoolConfig {
+	script := os.Getenv("EASYOCR_WORKER_SCRIPT")
+	if script == "" {
+		script = "/app/easyocr_worker.py"
+	}
+	command := strings.Fields(os.Getenv("EASYOCR_WORKER_COMMAND"))
+	if len(command) == 0 {
+		command = []string{"python3", script}
+	}
+	return EasyOCRPoolConfig{
+		Command:        command,
+		Size:           envInt("EASYOCR_WORKERS", 1),
+		BatchSize:      envInt("EASYOCR_BATCH_SIZE", 4),
+		RequestTimeout: envDuration("EASYOCR_REQUEST_TIMEOUT", 60*time.Second),
+		StartTimeout:   envDuration("EASYOCR_START_TIMEOUT", 2*time.Minute),
+		HealthInterval: envDuration("EASYOCR_HEALTH_INTERVAL", 30*time.Second),
+		HealthTimeout:  5 * time.Second,
+	}
+}
+
+// envInt reads a positive integer environment variable.
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// envDuration reads a duration environment variable such as "90s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+// easyOCRRequest is a request frame. Op is "ocr" or "ping".
+type easyOCRRequest struct {
+	ID        uint64   `json:"id"`
+	Op        string   `json:"op"`
+	Languages []string `json:"languages,omitempty"`
+	Images    []string `json:"images,omitempty"`
+}
+
+// easyOCRResponse is a response frame.
+type easyOCRResponse struct {
+	ID      uint64               `json:"id"`
+	Ready   bool                 `json:"ready,omitempty"`
+	Version string               `json:"version,omitempty"` // EasyOCR version, ready frame only
+	Error   string               `json:"error,omitempty"`
+	Results []easyOCRImageResult `json:"results,omitempty"`
+}
+
+// easyOCRImageResult is the recognition of one image.
+type easyOCRImageResult struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+	Error      string  `json:"error,omitempty"`
+	Boxes      []struct {
+		Text       string  `json:"text"`
+		Confidence float64 `json:"confidence"`
+		Box        [4]int  `json:"box"`
+	} `json:"boxes"`
+}
+
+// detections converts the result boxes.
+func (r easyOCRImageResult) detections() []textDetection {
+	detections := make([]textDetection, 0, len(r.Boxes))
+	for _, b := range r.Boxes {
+		detections = append(detections, textDetection{
+			Text:       b.Text,
+			BBox:       BoundingBox{X0: b.Box[0], Y0: b.Box[1], X1: b.Box[2], Y1: b.Box[3]},
+			Confidence: b.Confidence,
+		})
+	}
+	return detections
+}
+
+// writeEasyOCRFrame writes one length-prefixed JSON frame.
+func writeEasyOCRFrame(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := w.Write(append(header[:], data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readEasyOCRFrame reads one length-prefixed JSON frame.
+func readEasyOCRFrame(r io.Reader, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxEasyOCRFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// errEasyOCRWorkerDied means the process exited or broke the protocol; the
+// request may succeed on a fresh worker.
+var errEasyOCRWorkerDied = errors.New("EasyOCR worker died")
+
+// easyOCRWorker is one worker process. It serves one request at a time.
+type easyOCRWorker struct {
+	slot    int
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	exited  chan struct{}
+	seq     uint64
+	version string // reported in the ready frame
+}
+
+// startEasyOCRWorker launches a worker and waits for its ready frame.
+func startEasyOCRWorker(cfg EasyOCRPoolConfig, slot int) (*easyOCRWorker, error) {
+	cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// A plain pipe instead of StdoutPipe: reads end with EOF when the process
+	// exits, without racing cmd.Wait closing the pipe.
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
+	if err := cmd.Start(); err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return nil, fmt.Errorf("failed to start EasyOCR worker: %w", err)
+	}
+	stdoutW.Close()
+
+	w := &easyOCRWorker{
+		slot:   slot,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdoutR),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		stdoutR.Close()
+		close(w.exited)
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		var resp easyOCRResponse
+		if err := readEasyOCRFrame(w.stdout, &resp); err != nil {
+			ready <- err
+			return
+		}
+		if !resp.Ready {
+			ready <- fmt.Errorf("unexpected first frame: %+v", resp)
+			return
+		}
+		w.version = resp.Version
+		ready <- nil
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			w.kill()
+			return nil, fmt.Errorf("EasyOCR worker failed to start: %w", err)
+		}
+	case <-time.After(cfg.StartTimeout):
+		w.kill()
+		return nil, fmt.Errorf("EasyOCR worker not ready after %v", cfg.StartTimeout)
+	}
+	log.Printf("EasyOCR worker %d started (pid %d)", slot, cmd.Process.Pid)
+	return w, nil
+}
+
+// call sends a request and waits for the matching response. Any error leaves
+// the worker unusable; the caller must not return it to the pool.
+func (w *easyOCRWorker) call(ctx context.Context, req easyOCRRequest, timeout time.Duration) (*easyOCRResponse, error) {
+	w.seq++
+	req.ID = w.seq
+	type outcome struct {
+		resp *easyOCRResponse
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		if err := writeEasyOCRFrame(w.stdin, req); err != nil {
+			done <- outcome{err: fmt.Errorf("%w: write: %v", errEasyOCRWorkerDied, err)}
+			return
+		}
+		var resp easyOCRResponse
+		if err := readEasyOCRFrame(w.stdout, &resp); err != nil {
+			done <- outcome{err: fmt.Errorf("%w: read: %v", errEasyOCRWorkerDied, err)}
+			return
+		}
+		if resp.ID != req.ID {
+			done <- outcome{err: fmt.Errorf("%w: response id %d for request %d", errEasyOCRWorkerDied, resp.ID, req.ID)}
+			return
+		}
+		done <- outcome{resp: &resp}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.resp, o.err
+	case <-timer.C:
+		w.kill()
+		<-done
+		return nil, fmt.Errorf("EasyOCR request timed out after %v", timeout)
+	case <-ctx.Done():
+		w.kill()
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+// kill terminates the process and waits for it to be reaped.
+func (w *easyOCRWorker) kill() {
+	w.stdin.Close()
+	if w.cmd.Process != nil {
+		w.cmd.Process.Kill()
+	}
+	<-w.exited
+}
+
+// easyOCRPool hands out idle workers and replaces broken ones.
+type easyOCRPool struct {
+	cfg       EasyOCRPoolConfig
+	idle      chan *easyOCRWorker
+	closed    chan struct{}
+	closeOnce sync.Once
+	version   atomic.Value // string, from the most recently started worker
+}
+
+// newEasyOCRPool starts the workers in the background and returns immediately;
+// requests wait until a worker is ready.
+func newEasyOCRPool(cfg EasyOCRPoolConfig) *easyOCRPool {
+	if cfg.Size <= 0 {
+		cfg.Size = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	p := &easyOCRPool{
+		cfg:    cfg,
+		idle:   make(chan *easyOCRWorker, cfg.Size),
+		closed: make(chan struct{}),
+	}
+	for slot := 0; slot < cfg.Size; slot++ {
+		go p.spawn(slot)
+	}
+	if cfg.HealthInterval > 0 {
+		go p.healthLoop()
+	}
+	return p
+}
+
+// spawn starts a worker for slot, retrying with backoff until it succeeds or
+// the pool is closed.
+func (p *easyOCRPool) spawn(slot int) {
+	backoff := time.Second
+	for {
+		select {
+		case <-p.closed:
+			return
+		default:
+		}
+		w, err := startEasyOCRWorker(p.cfg, slot)
+		if err == nil {
+			p.version.Store(w.version)
+			select {
+			case <-p.closed:
+				w.kill()
+			default:
+				p.idle <- w
+			}
+			return
+		}
+		log.Printf("EasyOCR worker %d: %v (retrying in %v)", slot, err, backoff)
+		select {
+		case <-p.closed:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// Version returns the EasyOCR version reported by the workers, "" before one has started.
+func (p *easyOCRPool) Version() string {
+	v, _ := p.version.Load().(string)
+	return v
+}
+
+// acquire waits for an idle worker.
+func (p *easyOCRPool) acquire(ctx context.Context) (*easyOCRWorker, error) {
+	select {
+	case w := <-p.idle:
+		return w, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.closed:
+		return nil, errors.New("EasyOCR pool is closed")
+	}
+}
+
+// release returns a healthy worker to the pool, or kills it and starts a replacement.
+func (p *easyOCRPool) release(w *easyOCRWorker, healthy bool) {
+	select {
+	case <-p.closed:
+		w.kill()
+		return
+	default:
+	}
+	if healthy {
+		p.idle <- w
+		return
+	}
+	w.kill()
+	log.Printf("EasyOCR worker %d restarting", w.slot)
+	go p.spawn(w.slot)
+}
+
+// healthLoop periodically pings idle workers; busy workers are covered by
+// their request timeout.
+func (p *easyOCRPool) healthLoop() {
+	ticker := time.NewTicker(p.cfg.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+		var idle []*easyOCRWorker
+	drain:
+		for len(idle) < p.cfg.Size {
+			select {
+			case w := <-p.idle:
+				idle = append(idle, w)
+			default:
+				break drain
+			}
+		}
+		for _, w := range idle {
+			_, err := w.call(context.Background(), easyOCRRequest{Op: "ping"}, p.cfg.HealthTimeout)
+			if err != nil {
+				log.Printf("EasyOCR worker %d failed health check: %v", w.slot, err)
+			}
+			p.release(w, err == nil)
+		}
+	}
+}
+
+// Recognize OCRs image files in batches of cfg.BatchSize. A request that fails
+// because its worker died is retried once on a fresh worker.
+func (p *easyOCRPool) Recognize(ctx context.Context, languages []string, paths []string) ([]easyOCRImageResult, error) {
+	results := make([]easyOCRImageResult, 0, len(paths))
+	for start := 0; start < len(paths); start += p.cfg.BatchSize {
+		batch := paths[start:min(start+p.cfg.BatchSize, len(paths))]
+		req := easyOCRRequest{Op: "ocr", Languages: languages, Images: batch}
+		var resp *easyOCRResponse
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			resp, err = p.do(ctx, req, p.cfg.RequestTimeout*time.Duration(len(batch)))
+			if err == nil || !errors.Is(err, errEasyOCRWorkerDied) {
+				break
+			}
+			log.Printf("EasyOCR request failed (attempt %d): %v", attempt+1, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("EasyOCR error: %s", resp.Error)
+		}
+		if len(resp.Results) != len(batch) {
+			return nil, fmt.Errorf("EasyOCR returned %d results for %d images", len(resp.Results), len(batch))
+		}
+		results = append(results, resp.Results...)
+	}
+	return results, nil
+}
+
+// do runs one request on a worker.
+func (p *easyOCRPool) do(ctx context.Context, req easyOCRRequest, timeout time.Duration) (*easyOCRResponse, error) {
+	w, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.call(ctx, req, timeout)
+	p.release(w, err == nil)
+	return resp, err
+}
+
+// Close stops all idle workers; busy workers stop when their request ends.
+func (p *easyOCRPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		for {
+			select {
+			case w := <-p.idle:
+				w.kill()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,232 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEasyOCRFrames(t *testing.T) {
+	var buf bytes.Buffer
+	req := easyOCRRequest{ID: 7, Op: "ocr", Languages: []string{"ja", "en"}, Images: []string{"/tmp/a.png"}}
+	if err := writeEasyOCRFrame(&buf, req); err != nil {
+		t.Fatalf("writeEasyOCRFrame: %v", err)
+	}
+	if n := binary.BigEndian.Uint32(buf.Bytes()[:4]); int(n) != buf.Len()-4 {
+		t.Fatalf("frame header = %d, want %d", n, buf.Len()-4)
+	}
+	var got easyOCRRequest
+	if err := readEasyOCRFrame(&buf, &got); err != nil {
+		t.Fatalf("readEasyOCRFrame: %v", err)
+	}
+	if got.ID != 7 || got.Op != "ocr" || strings.Join(got.Languages, ",") != "ja,en" || len(got.Images) != 1 {
+		t.Fatalf("frame = %+v, want %+v", got, req)
+	}
+
+	oversized := binary.BigEndian.AppendUint32(nil, maxEasyOCRFrameSize+1)
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, io.EOF},
+		{"short header", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"short body", append(binary.BigEndian.AppendUint32(nil, 10), `{"id"`...), io.ErrUnexpectedEOF},
+		{"oversized", oversized, nil},
+		{"not json", append(binary.BigEndian.AppendUint32(nil, 3), "abc"...), nil},
+	} {
+		var resp easyOCRResponse
+		err := readEasyOCRFrame(bytes.NewReader(tt.data), &resp)
+		if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// buildEasyOCRStub builds the Python-free worker of server/ocr/easyocr_stub.
+func buildEasyOCRStub(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not found")
+	}
+	bin := filepath.Join(t.TempDir(), "easyocr-stub")
+	out, err := exec.Command("go", "build", "-o", bin, "../ocr/easyocr_stub").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building the EasyOCR stub: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// writeTestPNG writes a blank PNG of the given size.
+func writeTestPNG(t *testing.T, dir string, name string, width, height int) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// workerPid takes an idle worker, notes its process and returns it.
+func workerPid(t *testing.T, p *easyOCRPool) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w, err := p.acquire(ctx)
+	if err != nil {
+		t.Fatalf("no idle worker: %v", err)
+	}
+	pid := w.cmd.Process.Pid
+	p.release(w, true)
+	return pid
+}
+
+func TestEasyOCRPool(t *testing.T) {
+	stub := buildEasyOCRStub(t)
+	dir := t.TempDir()
+	images := []string{
+		writeTestPNG(t, dir, "a.png", 10, 20),
+		writeTestPNG(t, dir, "b.png", 30, 40),
+		writeTestPNG(t, dir, "c.png", 50, 60),
+	}
+	config := func(size, batch int) EasyOCRPoolConfig {
+		return EasyOCRPoolConfig{
+			Command:        []string{stub},
+			Size:           size,
+			BatchSize:      batch,
+			RequestTimeout: 5 * time.Second,
+			StartTimeout:   5 * time.Second,
+			HealthTimeout:  time.Second,
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("batches", func(t *testing.T) {
+		t.Setenv("EASYOCR_STUB_TEXT", "hello")
+		pool := newEasyOCRPool(config(1, 2))
+		defer pool.Close()
+		results, err := pool.Recognize(ctx, []string{"en"}, images)
+		if err != nil {
+			t.Fatalf("Recognize: %v", err)
+		}
+		if len(results) != 3 {
+			t.Fatalf("got %d results, want 3", len(results))
+		}
+		for i, want := range [][2]int{{10, 20}, {30, 40}, {50, 60}} {
+			d := results[i].detections()
+			if results[i].Text != "hello" || len(d) != 1 || d[0].BBox.X1 != want[0] || d[0].BBox.Y1 != want[1] {
+				t.Errorf("result %d = %+v, want hello over %dx%d", i, results[i], want[0], want[1])
+			}
+		}
+		// Three images in batches of two are two requests
+		w, _ := pool.acquire(ctx)
+		if w.seq != 2 {
+			t.Errorf("worker served %d requests, want 2", w.seq)
+		}
+		pool.release(w, true)
+		if pool.Version() != "stub" {
+			t.Errorf("Version = %q, want stub", pool.Version())
+		}
+	})
+
+	t.Run("worker crashing mid-request is replaced", func(t *testing.T) {
+		t.Setenv("EASYOCR_STUB_CRASH_AFTER", "2")
+		pool := newEasyOCRPool(config(1, 1))
+		defer pool.Close()
+		first := workerPid(t, pool)
+		// The second image crashes the worker; it is retried on a new one
+		results, err := pool.Recognize(ctx, []string{"en"}, images[:2])
+		if err != nil || len(results) != 2 {
+			t.Fatalf("Recognize = %d results, %v; want 2 results", len(results), err)
+		}
+		if pid := workerPid(t, pool); pid == first {
+			t.Fatalf("worker %d was not replaced", pid)
+		}
+	})
+
+	t.Run("timed out request", func(t *testing.T) {
+		t.Setenv("EASYOCR_STUB_DELAY", "5s")
+		cfg := config(1, 1)
+		cfg.RequestTimeout = 200 * time.Millisecond
+		pool := newEasyOCRPool(cfg)
+		defer pool.Close()
+		first := workerPid(t, pool)
+		start := time.Now()
+		_, err := pool.Recognize(ctx, []string{"en"}, images[:1])
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("err = %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("Recognize returned after %v, want about the request timeout", elapsed)
+		}
+		if pid := workerPid(t, pool); pid == first {
+			t.Fatalf("timed out worker %d was not replaced", pid)
+		}
+	})
+
+	t.Run("health check replaces dead idle workers", func(t *testing.T) {
+		cfg := config(1, 1)
+		cfg.HealthInterval = 50 * time.Millisecond
+		pool := newEasyOCRPool(cfg)
+		defer pool.Close()
+		w, err := pool.acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := w.cmd.Process.Pid
+		w.cmd.Process.Kill()
+		<-w.exited
+		pool.release(w, true)
+		deadline := time.Now().Add(10 * time.Second)
+		for workerPid(t, pool) == first {
+			if time.Now().After(deadline) {
+				t.Fatalf("dead worker %d was not replaced", first)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	t.Run("close reaps all workers", func(t *testing.T) {
+		pool := newEasyOCRPool(config(3, 1))
+		var workers []*easyOCRWorker
+		for range 3 {
+			w, err := pool.acquire(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			workers = append(workers, w)
+		}
+		for _, w := range workers {
+			pool.release(w, true)
+		}
+		pool.Close()
+		for _, w := range workers {
+			select {
+			case <-w.exited:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("worker %d still running after Close", w.cmd.Process.Pid)
+			}
+			if w.cmd.ProcessState == nil {
+				t.Fatalf("worker %d was not reaped", w.cmd.Process.Pid)
+			}
+		}
+		if _, err := pool.Recognize(ctx, nil, images[:1]); err == nil {
+			t.Fatal("Recognize on a closed pool succeeded")
+		}
+	})
+}
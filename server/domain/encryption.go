@@ -0,0 +1,354 @@
+package domain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// ErrDecryptionFailed is returned when a stored file cannot be decrypted: its
+// master key is unknown, or the object was modified or truncated.
+var ErrDecryptionFailed = errors.New("failed to decrypt stored file")
+
+// Encrypted objects start with a header, followed by AES-256-GCM chunks:
+//
+//	magic[8] version[1] chunkSize[4] noncePrefix[7]
+//	keyIDLen[1] keyID wrappedKeyLen[2] wrappedKey
+//	chunk*  (each chunkSize bytes of plaintext + a 16-byte tag; the last may be shorter)
+//
+// Every file has its own random data key, wrapped (encrypted) with a master
+// key of the KeyProvider. The nonce of chunk i is noncePrefix || i || last,
+// so chunks cannot be reordered, dropped or truncated without failing
+// authentication, and every chunk authenticates the header as additional
+// data. Rotating the master key re-encrypts the object under a new header.
+const (
+	encryptionMagic     = "\x89GSENC\r\n"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 * 1024
+	encryptionPrefixLen = 7
+	dataKeySize         = 32
+)
+
+// KeyProvider holds the master keys that wrap the data keys of stored files.
+type KeyProvider interface {
+	// ActiveKeyID returns the ID of the key new data keys are wrapped with.
+	ActiveKeyID() string
+	// WrapKey encrypts a data key with the active master key.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped with the master key keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// encryptionHeader is the parsed header of an encrypted object.
+type encryptionHeader struct {
+	chunkSize   int
+	noncePrefix []byte
+	keyID       string
+	wrappedKey  []byte
+}
+
+func (h *encryptionHeader) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(encryptionMagic)
+	buf.WriteByte(encryptionVersion)
+	binary.Write(&buf, binary.BigEndian, uint32(h.chunkSize))
+	buf.Write(h.noncePrefix)
+	buf.WriteByte(byte(len(h.keyID)))
+	buf.WriteString(h.keyID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(h.wrappedKey)))
+	buf.Write(h.wrappedKey)
+	return buf.Bytes()
+}
+
+// readEncryptionHeader parses the header after the magic.
+func readEncryptionHeader(r io.Reader) (*encryptionHeader, error) {
+	var fixed [1 + 4 + encryptionPrefixLen + 1]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[0] != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", fixed[0])
+	}
+	h := &encryptionHeader{
+		chunkSize:   int(binary.BigEndian.Uint32(fixed[1:5])),
+		noncePrefix: append([]byte(nil), fixed[5:5+encryptionPrefixLen]...),
+	}
+	if h.chunkSize <= 0 || h.chunkSize > 16<<20 {
+		return nil, fmt.Errorf("invalid chunk size %d", h.chunkSize)
+	}
+	keyID := make([]byte, fixed[len(fixed)-1])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return nil, err
+	}
+	h.keyID = string(keyID)
+	var wrappedLen uint16
+	if err := binary.Read(r, binary.BigEndian, &wrappedLen); err != nil {
+		return nil, err
+	}
+	h.wrappedKey = make([]byte, wrappedLen)
+	if _, err := io.ReadFull(r, h.wrappedKey); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// chunkNonce is the nonce of chunk i.
+func chunkNonce(prefix []byte, i uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, i)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkStream reads a source a chunk at a time and turns each chunk into
+// output; it looks one byte ahead to know which chunk is the last.
+type chunkStream struct {
+	src     *bufio.Reader
+	in      []byte
+	out     []byte
+	index   uint32
+	done    bool
+	process func(chunk []byte, index uint32, last bool) ([]byte, error)
+}
+
+func (s *chunkStream) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.src, s.in)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := n < len(s.in)
+		if !last {
+			if _, err := s.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		if s.out, err = s.process(s.in[:n], s.index, last); err != nil {
+			return 0, err
+		}
+		s.index++
+		s.done = last
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// NewEncryptingReader returns the encrypted form of content, with a fresh
+// data key wrapped by keys. Content is encrypted as it is read.
+func NewEncryptingReader(ctx context.Context, keys KeyProvider, content io.Reader) (io.Reader, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	h := &encryptionHeader{chunkSize: encryptionChunkSize, noncePrefix: make([]byte, encryptionPrefixLen), keyID: keyID, wrappedKey: wrapped}
+	if _, err := rand.Read(h.noncePrefix); err != nil {
+		return nil, err
+	}
+	header := h.marshal()
+	chunks := &chunkStream{
+		src: bufio.NewReaderSize(content, encryptionChunkSize),
+		in:  make([]byte, encryptionChunkSize),
+		process: func(chunk []byte, index uint32, last bool) ([]byte, error) {
+			return gcm.Seal(nil, chunkNonce(h.noncePrefix, index, last), chunk, header), nil
+		},
+	}
+	return io.MultiReader(bytes.NewReader(header), chunks), nil
+}
+
+// NewDecryptingReader returns the plaintext of a stored object. Objects
+// without the encryption header fail with ErrDecryptionFailed, unless
+// allowPlaintext is set while files stored before encryption was enabled
+// are migrated; those are then returned as they are.
+func NewDecryptingReader(ctx context.Context, keys KeyProvider, stored io.Reader, allowPlaintext bool) (io.Reader, error) {
+	src := bufio.NewReaderSize(stored, encryptionChunkSize+aes.BlockSize)
+	if magic, _ := src.Peek(len(encryptionMagic)); string(magic) != encryptionMagic {
+		if allowPlaintext {
+			return src, nil
+		}
+		return nil, fmt.Errorf("%w: the object is not encrypted", ErrDecryptionFailed)
+	}
+	src.Discard(len(encryptionMagic))
+	h, err := readEncryptionHeader(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %v", ErrDecryptionFailed, err)
+	}
+	return openChunks(ctx, keys, h, src)
+}
+
+// openChunks decrypts the chunks that follow header h in src.
+func openChunks(ctx context.Context, keys KeyProvider, h *encryptionHeader, src *bufio.Reader) (io.Reader, error) {
+	dataKey, err := keys.UnwrapKey(ctx, h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := h.marshal()
+	return &chunkStream{
+		src: src,
+		in:  make([]byte, h.chunkSize+gcm.Overhead()),
+		process: func(chunk []byte, index uint32, last bool) ([]byte, error) {
+			plain, err := gcm.Open(nil, chunkNonce(h.noncePrefix, index, last), chunk, header)
+			if err != nil {
+				return nil, fmt.Errorf("%w: chunk %d does not authenticate", ErrDecryptionFailed, index)
+			}
+			return plain, nil
+		},
+	}, nil
+}
+
+// encryptedStorage encrypts objects on the way into a StorageService and
+// decrypts them on the way out. Listing and deleting pass through.
+type encryptedStorage struct {
+	StorageService
+	keys           KeyProvider
+	allowPlaintext bool // read objects without a header as they are
+}
+
+// KeyRewrapper is implemented by storage that encrypts objects; RewrapFile
+// re-encrypts an object under the active master key, reporting whether the
+// object was rewritten.
+type KeyRewrapper interface {
+	RewrapFile(ctx context.Context, storagePath string) (bool, error)
+}
+
+func (s *encryptedStorage) UploadFile(ctx context.Context, filename string, content io.Reader) (*pb.FileUploadStatus, error) {
+	encrypted, err := NewEncryptingReader(ctx, s.keys, content)
+	if err != nil {
+		return nil, err
+	}
+	return s.StorageService.UploadFile(ctx, filename, encrypted)
+}
+
+func (s *encryptedStorage) UploadFileByPath(ctx context.Context, storagePath string, content io.Reader) error {
+	encrypted, err := NewEncryptingReader(ctx, s.keys, content)
+	if err != nil {
+		return err
+	}
+	return s.StorageService.UploadFileByPath(ctx, storagePath, encrypted)
+}
+
+func (s *encryptedStorage) DownloadFile(ctx context.Context, filename string) (io.Reader, error) {
+	stored, err := s.StorageService.DownloadFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecryptingReader(ctx, s.keys, stored, s.allowPlaintext)
+}
+
+func (s *encryptedStorage) DownloadFileByPath(ctx context.Context, storagePath string) (io.Reader, error) {
+	stored, err := s.StorageService.DownloadFileByPath(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecryptingReader(ctx, s.keys, stored, s.allowPlaintext)
+}
+
+// RewrapFile re-encrypts an object wrapped with an older master key with a
+// new data key wrapped by the active one; the chunks authenticate the
+// header, so it cannot be rewritten on its own. Plaintext objects are
+// encrypted.
+func (s *encryptedStorage) RewrapFile(ctx context.Context, storagePath string) (bool, error) {
+	stored, err := s.StorageService.DownloadFileByPath(ctx, storagePath)
+	if err != nil {
+		return false, err
+	}
+	src := bufio.NewReader(stored)
+	if magic, _ := src.Peek(len(encryptionMagic)); string(magic) != encryptionMagic {
+		return true, s.UploadFileByPath(ctx, storagePath, src)
+	}
+	src.Discard(len(encryptionMagic))
+	h, err := readEncryptionHeader(src)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid header: %v", ErrDecryptionFailed, err)
+	}
+	if h.keyID == s.keys.ActiveKeyID() {
+		return false, nil
+	}
+	plain, err := openChunks(ctx, s.keys, h, src)
+	if err != nil {
+		return false, err
+	}
+	return true, s.UploadFileByPath(ctx, storagePath, plain)
+}
+
+var (
+	storageKeysOnce sync.Once
+	storageKeys     KeyProvider
+	storageKeysErr  error
+)
+
+// StorageKeyProvider returns the key provider of storage encryption (see
+// KeyProviderFromEnv), loaded once per process; nil when encryption is off.
+func StorageKeyProvider() (KeyProvider, error) {
+	storageKeysOnce.Do(func() {
+		storageKeys, storageKeysErr = KeyProviderFromEnv()
+		if storageKeysErr == nil && storageKeys != nil {
+			log.Printf("Storage encryption enabled (active key: %s)", storageKeys.ActiveKeyID())
+		}
+	})
+	return storageKeys, storageKeysErr
+}
+
+// PlaintextReadsAllowed reports whether STORAGE_ENCRYPTION_ALLOW_PLAINTEXT is
+// set, so objects stored before encryption was enabled can still be read
+// until rotate-storage-key -rewrap has encrypted them (default off).
+func PlaintextReadsAllowed() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_ENCRYPTION_ALLOW_PLAINTEXT"))) {
+	case "true", "1", "on", "yes":
+		return true
+	}
+	return false
+}
+
+// withEncryption wraps a storage service when storage encryption is on.
+func withEncryption(storage StorageService) (StorageService, error) {
+	keys, err := StorageKeyProvider()
+	if err != nil {
+		return nil, fmt.Errorf("storage encryption is misconfigured: %w", err)
+	}
+	if keys == nil {
+		return storage, nil
+	}
+	return &encryptedStorage{StorageService: storage, keys: keys, allowPlaintext: PlaintextReadsAllowed()}, nil
+}
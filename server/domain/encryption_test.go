@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyProvider(t *testing.T) KeyProvider {
+	t.Helper()
+	keys, err := NewLocalKeyProvider(filepath.Join(t.TempDir(), "storage-keys.json"), true)
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider: %v", err)
+	}
+	return keys
+}
+
+func encryptForTest(t *testing.T, keys KeyProvider, plain []byte) []byte {
+	t.Helper()
+	r, err := NewEncryptingReader(context.Background(), keys, bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("NewEncryptingReader: %v", err)
+	}
+	stored, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	return stored
+}
+
+func decryptForTest(keys KeyProvider, stored []byte, allowPlaintext bool) ([]byte, error) {
+	r, err := NewDecryptingReader(context.Background(), keys, bytes.NewReader(stored), allowPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keys := testKeyProvider(t)
+	for _, tt := range []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"below a chunk", encryptionChunkSize - 1},
+		{"one chunk", encryptionChunkSize},
+		{"above a chunk", encryptionChunkSize + 1},
+		{"three chunks", 3 * encryptionChunkSize},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			stored := encryptForTest(t, keys, plain)
+			if tt.size > 16 && bytes.Contains(stored, plain) {
+				t.Fatal("stored object contains the plaintext")
+			}
+			got, err := decryptForTest(keys, stored, false)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("decrypted %d bytes, want %d", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestDecryptionDetectsTampering(t *testing.T) {
+	keys := testKeyProvider(t)
+	plain := bytes.Repeat([]byte{'x'}, 3*encryptionChunkSize)
+	stored := encryptForTest(t, keys, plain)
+	headerLen := len(stored) - 3*(encryptionChunkSize+16)
+	chunk := func(i int) []byte {
+		start := headerLen + i*(encryptionChunkSize+16)
+		return stored[start : start+encryptionChunkSize+16]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		stored []byte
+	}{
+		{"truncated at a chunk boundary", stored[:headerLen+2*(encryptionChunkSize+16)]},
+		{"truncated inside a chunk", stored[:len(stored)-10]},
+		{"chunks reordered", join(stored[:headerLen], chunk(1), chunk(0), chunk(2))},
+		{"chunk dropped", join(stored[:headerLen], chunk(0), chunk(2))},
+		{"chunk modified", func() []byte {
+			b := bytes.Clone(stored)
+			b[headerLen+100] ^= 1
+			return b
+		}()},
+		{"nonce prefix modified", func() []byte {
+			b := bytes.Clone(stored)
+			b[len(encryptionMagic)+1+4] ^= 1
+			return b
+		}()},
+		{"header swapped", join(encryptForTest(t, keys, plain)[:headerLen], stored[headerLen:])},
+		{"header only", stored[:headerLen]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptForTest(keys, tt.stored, false)
+			if !errors.Is(err, ErrDecryptionFailed) {
+				t.Fatalf("err = %v, want ErrDecryptionFailed", err)
+			}
+		})
+	}
+}
+
+func TestDecryptionOfPlaintext(t *testing.T) {
+	keys := testKeyProvider(t)
+	plain := []byte("stored before encryption was enabled")
+	for _, tt := range []struct {
+		name           string
+		allowPlaintext bool
+		wantErr        error
+	}{
+		{"rejected by default", false, ErrDecryptionFailed},
+		{"passed through during a migration", true, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptForTest(keys, plain, tt.allowPlaintext)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, plain) {
+				t.Fatalf("got %q, want %q", got, plain)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// External engine types.
+const (
+	ExternalEngineCommand = "command"
+	ExternalEngineHTTP    = "http"
+)
+
+const (
+	defaultExternalEngineTimeout = 60 * time.Second
+	// maxExternalResponseBytes bounds what is read from an adapter's reply.
+	maxExternalResponseBytes = 32 << 20
+)
+
+// externalEngineNamePattern keeps engine names usable in storage paths and
+// environment variable names.
+var externalEngineNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ExternalEngineConfig declares an OCR engine implemented outside this process.
+// See doc/EXTERNAL_OCR_ENGINES.md for the request/response contract.
+type ExternalEngineConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "command" or "http"
+
+	// Command is the argv of a program that reads one request from stdin and
+	// writes one response to stdout (type "command").
+	Command []string `json:"command,omitempty"`
+	// URL receives the request as a JSON POST (type "http"). Header values
+	// may reference environment variables, e.g. "Bearer ${PADDLE_TOKEN}".
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Timeout per image, as a Go duration ("90s"). Defaults to 60s.
+	Timeout string `json:"timeout,omitempty"`
+
+	// Languages are the defaults sent when no language is requested or detected.
+	Languages []string `json:"languages,omitempty"`
+	// SupportedLanguages may be chosen by language detection; defaults to Languages.
+	SupportedLanguages []string `json:"supported_languages,omitempty"`
+	// LanguageProbes are the language sets tried on a sample page during
+	// detection; defaults to one set with all supported languages.
+	LanguageProbes [][]string `json:"language_probes,omitempty"`
+
+	// Preprocess is the default preprocessing profile (see OCR_PREPROCESS_<ENGINE>).
+	Preprocess string `json:"preprocess,omitempty"`
+
+	// Version identifies the model behind the adapter; it is stored with
+	// results so evaluations can tell model upgrades apart.
+	Version string `json:"version,omitempty"`
+}
+
+// externalEnginesFile is the layout of the OCR_EXTERNAL_ENGINES_CONFIG file.
+type externalEnginesFile struct {
+	Engines []ExternalEngineConfig `json:"engines"`
+}
+
+// LoadExternalEngineConfigs reads and validates an engine config file.
+func LoadExternalEngineConfigs(path string) ([]ExternalEngineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read external engine config: %w", err)
+	}
+	var file externalEnginesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse external engine config %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, cfg := range file.Engines {
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("external engine #%d in %s: %w", i+1, path, err)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("external engine %q is declared twice in %s", cfg.Name, path)
+		}
+		seen[cfg.Name] = true
+	}
+	return file.Engines, nil
+}
+
+// validate checks the fields required by the engine type.
+func (c ExternalEngineConfig) validate() error {
+	if !externalEngineNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("invalid engine name %q: use lowercase letters, digits, '-' and '_'", c.Name)
+	}
+	switch c.Type {
+	case ExternalEngineCommand:
+		if len(c.Command) == 0 {
+			return fmt.Errorf("engine %s: command is required", c.Name)
+		}
+	case ExternalEngineHTTP:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return fmt.Errorf("engine %s: url must be http:// or https://", c.Name)
+		}
+	default:
+		return fmt.Errorf("engine %s: unknown type %q (want %q or %q)", c.Name, c.Type, ExternalEngineCommand, ExternalEngineHTTP)
+	}
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("engine %s: invalid timeout %q", c.Name, c.Timeout)
+		}
+	}
+	if _, err := ParseOCRLanguages(c.Languages); err != nil {
+		return fmt.Errorf("engine %s: %w", c.Name, err)
+	}
+	if _, err := ParseOCRLanguages(c.SupportedLanguages); err != nil {
+		return fmt.Errorf("engine %s: %w", c.Name, err)
+	}
+	for _, probe := range c.LanguageProbes {
+		if _, err := ParseOCRLanguages(probe); err != nil {
+			return fmt.Errorf("engine %s: language_probes: %w", c.Name, err)
+		}
+	}
+	if _, err := ParsePreprocessProfile(c.Preprocess); err != nil {
+		return fmt.Errorf("engine %s: preprocess: %w", c.Name, err)
+	}
+	return nil
+}
+
+// externalOCRRequest is sent once per image.
+type externalOCRRequest struct {
+	Image     string   `json:"image"` // base64-encoded PNG
+	Format    string   `json:"format"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	Languages []string `json:"languages,omitempty"` // language codes, e.g. ["ja", "en"]
+}
+
+// The response uses the same shape as the EasyOCR worker results:
+// {"text", "confidence", "boxes": [{"text", "confidence", "box": [x0, y0, x1, y1]}], "error"}.
+type externalOCRResponse = easyOCRImageResult
+
+// externalOCREngine is an OCREngine backed by a command or HTTP endpoint.
+type externalOCREngine struct {
+	cfg       ExternalEngineConfig
+	timeout   time.Duration
+	defaults  []string
+	supported []string
+	probes    [][]string
+	client    *http.Client
+}
+
+// NewExternalOCREngine creates an engine from its configuration.
+func NewExternalOCREngine(cfg ExternalEngineConfig) (OCREngine, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	e := &externalOCREngine{cfg: cfg, timeout: defaultExternalEngineTimeout}
+	if cfg.Timeout != "" {
+		e.timeout, _ = time.ParseDuration(cfg.Timeout)
+	}
+	e.defaults, _ = ParseOCRLanguages(cfg.Languages)
+	e.supported, _ = ParseOCRLanguages(cfg.SupportedLanguages)
+	if len(e.supported) == 0 {
+		e.supported = e.defaults
+	}
+	for _, probe := range cfg.LanguageProbes {
+		codes, _ := ParseOCRLanguages(probe)
+		e.probes = append(e.probes, codes)
+	}
+	if len(e.probes) == 0 && len(e.supported) > 1 {
+		e.probes = [][]string{e.supported}
+	}
+	if cfg.Type == ExternalEngineHTTP {
+		e.client = &http.Client{Timeout: e.timeout}
+	}
+	if cfg.Preprocess != "" {
+		if err := SetDefaultPreprocessProfile(cfg.Name, cfg.Preprocess); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// ExternalEnginesFromEnv creates the engines declared in the file named by
+// OCR_EXTERNAL_ENGINES_CONFIG. It returns nil when the variable is unset.
+func ExternalEnginesFromEnv() ([]OCREngine, error) {
+	path := os.Getenv("OCR_EXTERNAL_ENGINES_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+	configs, err := LoadExternalEngineConfigs(path)
+	if err != nil {
+		return nil, err
+	}
+	engines := make([]OCREngine, 0, len(configs))
+	for _, cfg := range configs {
+		engine, err := NewExternalOCREngine(cfg)
+		if err != nil {
+			return nil, err
+		}
+		engines = append(engines, engine)
+	}
+	return engines, nil
+}
+
+// Name returns the configured engine name.
+func (e *externalOCREngine) Name() string {
+	return e.cfg.Name
+}
+
+// Version returns the configured version.
+func (e *externalOCREngine) Version() string {
+	return e.cfg.Version
+}
+
+// DefaultLanguages returns the configured default languages.
+func (e *externalOCREngine) DefaultLanguages() []string {
+	return e.defaults
+}
+
+// SupportsLanguage reports whether the language is in supported_languages.
+func (e *externalOCREngine) SupportsLanguage(code string) bool {
+	return containsLanguage(e.supported, code)
+}
+
+// LanguageProbes returns the configured probe sets.
+func (e *externalOCREngine) LanguageProbes() [][]string {
+	return e.probes
+}
+
+// ProcessDocument runs OCR on a PDF, image or Office document.
+func (e *externalOCREngine) ProcessDocument(ctx context.Context, filename string, content io.Reader) (*OCRResult, error) {
+	result := &OCRResult{
+		Filename:          filename,
+		EngineName:        e.Name(),
+		Status:            "processing",
+		ProcessedAt:       time.Now(),
+		PreprocessProfile: PreprocessorForEngine(e.Name()).Spec(),
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if ext == "pdf" {
+		return e.processPDF(ctx, content, result)
+	}
+	if isImageFile(ext) {
+		return e.processImageFile(ctx, content, result)
+	}
+	if isOfficeFile(ext) || isLegacyOfficeFile(ext) {
+		return processOfficeDocument(ctx, e, filename, content, result)
+	}
+
+	result.Status = "failed"
+	result.Error = fmt.Errorf("unsupported file type: %s", ext)
+	return result, result.Error
+}
+
+// ProcessImage runs OCR on one image.
+func (e *externalOCREngine) ProcessImage(ctx context.Context, img image.Image) (string, float64, error) {
+	text, confidence, _, err := e.ProcessImageWithLayout(ctx, img)
+	return text, confidence, err
+}
+
+// ProcessImageWithLayout sends the image to the adapter and builds the layout
+// from the returned boxes. Missing text is rebuilt from the boxes and missing
+// confidence is their mean; confidences above 1 are taken as percentages.
+func (e *externalOCREngine) ProcessImageWithLayout(ctx context.Context, img image.Image) (string, float64, *OCRPageLayout, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", 0.0, nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	bounds := img.Bounds()
+	languages := OCRLanguagesFromContext(ctx)
+	if len(languages) == 0 {
+		languages = e.defaults
+	}
+	req := externalOCRRequest{
+		Image:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Format:    "png",
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		Languages: languages,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", 0.0, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	var data []byte
+	if e.cfg.Type == ExternalEngineHTTP {
+		data, err = e.callHTTP(ctx, body)
+	} else {
+		data, err = e.callCommand(ctx, body)
+	}
+	if err != nil {
+		return "", 0.0, nil, fmt.Errorf("%s: %w", e.Name(), err)
+	}
+
+	var resp externalOCRResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", 0.0, nil, fmt.Errorf("%s: invalid response: %w", e.Name(), err)
+	}
+	if resp.Error != "" {
+		return "", 0.0, nil, fmt.Errorf("%s error: %s", e.Name(), resp.Error)
+	}
+	for i := range resp.Boxes {
+		resp.Boxes[i].Confidence = normalizeConfidence(resp.Boxes[i].Confidence)
+	}
+	layout := buildLayoutFromDetections(bounds.Dx(), bounds.Dy(), resp.detections())
+	text := resp.Text
+	if text == "" {
+		text = layout.Text()
+	}
+	confidence := normalizeConfidence(resp.Confidence)
+	if confidence == 0 {
+		confidence = layout.MeanConfidence()
+	}
+	return text, confidence, layout, nil
+}
+
+// normalizeConfidence converts 0-100 confidences to 0.0-1.0.
+func normalizeConfidence(c float64) float64 {
+	if c > 1 {
+		return min(c/100.0, 1.0)
+	}
+	return max(c, 0)
+}
+
+// callCommand runs the adapter with the request on stdin and returns stdout.
+func (e *externalOCREngine) callCommand(ctx context.Context, body []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, e.cfg.Command[0], e.cfg.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("command timed out after %v", e.timeout)
+		}
+		return nil, fmt.Errorf("command failed: %w, stderr: %s", err, tailString(stderr.String(), 500))
+	}
+	if stdout.Len() > maxExternalResponseBytes {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxExternalResponseBytes)
+	}
+	return stdout.Bytes(), nil
+}
+
+// callHTTP posts the request and returns the response body.
+func (e *externalOCREngine) callHTTP(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxExternalResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(data) > maxExternalResponseBytes {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxExternalResponseBytes)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, tailString(string(data), 500))
+	}
+	return data, nil
+}
+
+// tailString returns at most the last n bytes of s.
+func tailString(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
+
+// processPDF OCRs each rendered page.
+func (e *externalOCREngine) processPDF(ctx context.Context, content io.Reader, result *OCRResult) (*OCRResult, error) {
+	images, err := NewPDFConverter().ConvertPDFToImages(ctx, content)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("failed to convert PDF to images: %w", err)
+		return result, result.Error
+	}
+
+	log.Printf("PDF converted to %d images", len(images))
+	ctx = resolveDocumentLanguages(ctx, e, result, "", images)
+
+	var allText strings.Builder
+	var totalConfidence float64
+	pages := make([]OCRPage, 0, len(images))
+	recs, errs := recognizeImages(ctx, e, images, pdfRenderDPI)
+	for pageNum, rec := range recs {
+		if err := errs[pageNum]; err != nil {
+			log.Printf("Failed to process OCR for page %d with %s: %v", pageNum+1, e.Name(), err)
+			pages = append(pages, OCRPage{PageNumber: pageNum + 1})
+			continue
+		}
+		allText.WriteString(fmt.Sprintf("\n--- Page %d ---\n", pageNum+1))
+		allText.WriteString(rec.Text)
+		totalConfidence += rec.Confidence
+		pages = append(pages, OCRPage{
+			PageNumber:      pageNum + 1,
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
+		})
+		log.Printf("Processed page %d/%d with %s: confidence=%.2f", pageNum+1, len(images), e.Name(), rec.Confidence)
+	}
+
+	if len(pages) == 0 {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("no pages were successfully processed")
+		return result, result.Error
+	}
+
+	result.ExtractedText = strings.TrimSpace(allText.String())
+	result.Confidence = totalConfidence / float64(len(pages))
+	result.Status = "completed"
+	result.Pages = pages
+	return result, nil
+}
+
+// processImageFile OCRs a single image file.
+func (e *externalOCREngine) processImageFile(ctx context.Context, content io.Reader, result *OCRResult) (*OCRResult, error) {
+	img, _, err := image.Decode(content)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("failed to decode image: %w", err)
+		return result, result.Error
+	}
+	ctx = resolveDocumentLanguages(ctx, e, result, "", []image.Image{img})
+
+	rec, err := recognizeImage(ctx, e, img, 0)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("OCR processing failed: %w", err)
+		return result, result.Error
+	}
+
+	result.ExtractedText = rec.Text
+	result.Confidence = rec.Confidence
+	result.Status = "completed"
+	result.Pages = []OCRPage{{
+		PageNumber:      1,
+		Text:            rec.Text,
+		Confidence:      rec.Confidence,
+		Layout:          rec.Layout,
+		PreprocessSteps: rec.PreprocessSteps,
+	}}
+	return result, nil
+}
@@ -0,0 +1,343 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExternalAdapterProcess is not a test: run with EXTERNAL_ADAPTER_MODE
+// set, the test binary acts as a command adapter for the tests below.
+func TestExternalAdapterProcess(t *testing.T) {
+	mode := os.Getenv("EXTERNAL_ADAPTER_MODE")
+	if mode == "" {
+		return
+	}
+	os.Exit(runTestAdapter(mode))
+}
+
+// runTestAdapter answers one request from stdin the way mode says.
+func runTestAdapter(mode string) int {
+	var req externalOCRRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "bad request: %v\n", err)
+		return 2
+	}
+	resp, ok := testAdapterResponse(mode, req)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "adapter crashed")
+		return 3
+	}
+	os.Stdout.WriteString(resp)
+	return 0
+}
+
+// testAdapterResponse is the reply of a fake adapter in the given mode; false
+// when the adapter should fail.
+func testAdapterResponse(mode string, req externalOCRRequest) (string, bool) {
+	switch mode {
+	case "echo":
+		// Report what was received, so the test can check the request
+		data, err := base64.StdEncoding.DecodeString(req.Image)
+		if err != nil {
+			return "", false
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil || req.Format != "png" {
+			return "", false
+		}
+		text := fmt.Sprintf("%dx%d %dx%d %s", req.Width, req.Height, img.Bounds().Dx(), img.Bounds().Dy(), strings.Join(req.Languages, "+"))
+		return fmt.Sprintf(`{"text": %q, "confidence": 87, "boxes": [{"text": "hello", "confidence": 90, "box": [1, 2, 30, 12]}]}`, text), true
+	case "boxes":
+		return `{"boxes": [{"text": "hello", "confidence": 0.8, "box": [1, 2, 30, 12]}, {"text": "world", "confidence": 0.6, "box": [34, 2, 60, 12]}]}`, true
+	case "error":
+		return `{"error": "model not loaded"}`, true
+	case "garbage":
+		return "Traceback (most recent call last):", true
+	case "empty":
+		return "", true
+	case "slow":
+		time.Sleep(10 * time.Second)
+		return `{"text": "late"}`, true
+	}
+	return "", false
+}
+
+func testImage(width, height int) image.Image {
+	return image.NewGray(image.Rect(0, 0, width, height))
+}
+
+func newTestExternalEngine(t *testing.T, cfg ExternalEngineConfig) *externalOCREngine {
+	t.Helper()
+	engine, err := NewExternalOCREngine(cfg)
+	if err != nil {
+		t.Fatalf("NewExternalOCREngine: %v", err)
+	}
+	return engine.(*externalOCREngine)
+}
+
+func TestLoadExternalEngineConfigs(t *testing.T) {
+	configs, err := LoadExternalEngineConfigs("../ocr/external/ocr_engines.example.json")
+	if err != nil {
+		t.Fatalf("example config: %v", err)
+	}
+	if len(configs) != 2 || configs[0].Name != "paddleocr" || configs[0].Type != ExternalEngineCommand ||
+		configs[1].Name != "cloud-ocr" || configs[1].Headers["Authorization"] != "Bearer ${CLOUD_OCR_TOKEN}" {
+		t.Fatalf("example config = %+v", configs)
+	}
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"minimal", `{"engines": [{"name": "ocr_2", "type": "command", "command": ["ocr"]}]}`, ""},
+		{"no engines", `{}`, ""},
+		{"malformed", `{"engines": [`, "failed to parse"},
+		{"invalid name", `{"engines": [{"name": "Paddle OCR", "type": "command", "command": ["ocr"]}]}`, "invalid engine name"},
+		{"no command", `{"engines": [{"name": "ocr", "type": "command"}]}`, "command is required"},
+		{"no url", `{"engines": [{"name": "ocr", "type": "http"}]}`, "url must be"},
+		{"ftp url", `{"engines": [{"name": "ocr", "type": "http", "url": "ftp://ocr.example.com"}]}`, "url must be"},
+		{"unknown type", `{"engines": [{"name": "ocr", "type": "grpc"}]}`, "unknown type"},
+		{"invalid timeout", `{"engines": [{"name": "ocr", "type": "command", "command": ["ocr"], "timeout": "soon"}]}`, "invalid timeout"},
+		{"negative timeout", `{"engines": [{"name": "ocr", "type": "command", "command": ["ocr"], "timeout": "-1s"}]}`, "invalid timeout"},
+		{"unknown language", `{"engines": [{"name": "ocr", "type": "command", "command": ["ocr"], "languages": ["klingon"]}]}`, "unsupported OCR language"},
+		{"unknown probe language", `{"engines": [{"name": "ocr", "type": "command", "command": ["ocr"], "language_probes": [["en"], ["klingon"]]}]}`, "language_probes"},
+		{"unknown preprocess step", `{"engines": [{"name": "ocr", "type": "command", "command": ["ocr"], "preprocess": "sharpen"}]}`, "preprocess"},
+		{"duplicate name", `{"engines": [{"name": "ocr", "type": "command", "command": ["a"]}, {"name": "ocr", "type": "http", "url": "http://b"}]}`, "declared twice"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadExternalEngineConfigs(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadExternalEngineConfigs: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadExternalEngineConfigs(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadExternalEngineConfigs of a missing file succeeded")
+	}
+}
+
+func TestNewExternalOCREngineLanguages(t *testing.T) {
+	e := newTestExternalEngine(t, ExternalEngineConfig{
+		Name: "ocr", Type: ExternalEngineCommand, Command: []string{"ocr"},
+		Languages: []string{"ja", "en"}, Version: "2.7", Timeout: "90s",
+	})
+	if e.timeout != 90*time.Second || e.Version() != "2.7" {
+		t.Errorf("timeout %v, version %q", e.timeout, e.Version())
+	}
+	// Without supported_languages the defaults are supported and probed together
+	if !e.SupportsLanguage("ja") || e.SupportsLanguage("ko") || fmt.Sprint(e.LanguageProbes()) != "[[ja en]]" {
+		t.Errorf("supported %v, probes %v", e.supported, e.LanguageProbes())
+	}
+	single := newTestExternalEngine(t, ExternalEngineConfig{Name: "ocr", Type: ExternalEngineCommand, Command: []string{"ocr"}, Languages: []string{"en"}})
+	if single.timeout != defaultExternalEngineTimeout || len(single.LanguageProbes()) != 0 {
+		t.Errorf("timeout %v, probes %v", single.timeout, single.LanguageProbes())
+	}
+}
+
+func TestExternalCommandAdapter(t *testing.T) {
+	adapter := []string{os.Args[0], "-test.run=^TestExternalAdapterProcess$"}
+	e := newTestExternalEngine(t, ExternalEngineConfig{
+		Name: "adapter", Type: ExternalEngineCommand, Command: adapter, Timeout: "5s", Languages: []string{"ja", "en"},
+	})
+	ctx := context.Background()
+
+	t.Run("request and response", func(t *testing.T) {
+		t.Setenv("EXTERNAL_ADAPTER_MODE", "echo")
+		text, confidence, layout, err := e.ProcessImageWithLayout(ctx, testImage(64, 32))
+		if err != nil {
+			t.Fatalf("ProcessImageWithLayout: %v", err)
+		}
+		// The image, its size and the default languages reach the adapter
+		if text != "64x32 64x32 ja+en" || confidence != 0.87 {
+			t.Fatalf("got %q at %v, want the echoed request at 0.87", text, confidence)
+		}
+		words := layout.Words()
+		if len(words) != 1 || words[0].Text != "hello" || words[0].Confidence != 0.9 || words[0].BBox.X1 != 30 {
+			t.Fatalf("words = %+v", words)
+		}
+		// Requested languages replace the defaults
+		text, _, _, err = e.ProcessImageWithLayout(WithOCRLanguages(ctx, []string{"ko"}), testImage(8, 8))
+		if err != nil || !strings.HasSuffix(text, " ko") {
+			t.Fatalf("got %q, %v; want the requested language", text, err)
+		}
+	})
+
+	t.Run("text and confidence from the boxes", func(t *testing.T) {
+		t.Setenv("EXTERNAL_ADAPTER_MODE", "boxes")
+		text, confidence, _, err := e.ProcessImageWithLayout(ctx, testImage(64, 32))
+		if err != nil || text != "hello world" || confidence < 0.699 || confidence > 0.701 {
+			t.Fatalf("got %q at %v, %v; want hello world at 0.7", text, confidence, err)
+		}
+	})
+
+	t.Run("document", func(t *testing.T) {
+		t.Setenv("EXTERNAL_ADAPTER_MODE", "boxes")
+		var buf bytes.Buffer
+		png.Encode(&buf, testImage(64, 32))
+		result, err := e.ProcessDocument(ctx, "scan.png", &buf)
+		if err != nil || result.Status != "completed" || result.ExtractedText != "hello world" || len(result.Pages) != 1 || result.Pages[0].Layout == nil {
+			t.Fatalf("ProcessDocument = %+v, %v", result, err)
+		}
+		if _, err := e.ProcessDocument(ctx, "notes.txt", strings.NewReader("text")); err == nil {
+			t.Fatal("ProcessDocument of a text file succeeded")
+		}
+	})
+
+	for _, tt := range []struct {
+		mode    string
+		wantErr string
+	}{
+		{"error", "adapter error: model not loaded"},
+		{"garbage", "invalid response"},
+		{"empty", "invalid response"},
+		{"crash", "adapter crashed"},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Setenv("EXTERNAL_ADAPTER_MODE", tt.mode)
+			_, _, _, err := e.ProcessImageWithLayout(ctx, testImage(8, 8))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("non-zero exit", func(t *testing.T) {
+		t.Setenv("EXTERNAL_ADAPTER_MODE", "crash")
+		_, _, _, err := e.ProcessImageWithLayout(ctx, testImage(8, 8))
+		if err == nil || !strings.Contains(err.Error(), "command failed") || !strings.Contains(err.Error(), "exit status 3") {
+			t.Fatalf("err = %v, want the exit status", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Setenv("EXTERNAL_ADAPTER_MODE", "slow")
+		slow := newTestExternalEngine(t, ExternalEngineConfig{Name: "adapter", Type: ExternalEngineCommand, Command: adapter, Timeout: "300ms"})
+		start := time.Now()
+		_, _, _, err := slow.ProcessImageWithLayout(ctx, testImage(8, 8))
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("err = %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("returned after %v, want about the timeout", elapsed)
+		}
+	})
+
+	t.Run("missing program", func(t *testing.T) {
+		missing := newTestExternalEngine(t, ExternalEngineConfig{Name: "adapter", Type: ExternalEngineCommand, Command: []string{filepath.Join(t.TempDir(), "missing")}})
+		if _, _, _, err := missing.ProcessImageWithLayout(ctx, testImage(8, 8)); err == nil || !strings.Contains(err.Error(), "command failed") {
+			t.Fatalf("err = %v, want command failed", err)
+		}
+	})
+}
+
+func TestExternalHTTPAdapter(t *testing.T) {
+	t.Setenv("TEST_OCR_TOKEN", "s3cret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "want a JSON POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req externalOCRRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mode := strings.TrimPrefix(r.URL.Path, "/")
+		switch mode {
+		case "unavailable":
+			http.Error(w, "model is loading", http.StatusServiceUnavailable)
+			return
+		case "slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		resp, ok := testAdapterResponse(mode, req)
+		if !ok {
+			http.Error(w, "unknown mode", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, resp)
+	}))
+	defer server.Close()
+
+	engine := func(path string, headers map[string]string) *externalOCREngine {
+		return newTestExternalEngine(t, ExternalEngineConfig{
+			Name: "cloud", Type: ExternalEngineHTTP, URL: server.URL + path, Headers: headers,
+			Timeout: "300ms", Languages: []string{"en"},
+		})
+	}
+	auth := map[string]string{"Authorization": "Bearer ${TEST_OCR_TOKEN}"}
+	ctx := context.Background()
+
+	text, confidence, layout, err := engine("/echo", auth).ProcessImageWithLayout(ctx, testImage(64, 32))
+	if err != nil {
+		t.Fatalf("ProcessImageWithLayout: %v", err)
+	}
+	if text != "64x32 64x32 en" || confidence != 0.87 || len(layout.Words()) != 1 {
+		t.Fatalf("got %q at %v with %d words", text, confidence, len(layout.Words()))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		headers map[string]string
+		wantErr string
+	}{
+		{"header without the variable", "/echo", nil, "HTTP 401: unauthorized"},
+		{"error response", "/error", auth, "cloud error: model not loaded"},
+		{"malformed response", "/garbage", auth, "invalid response"},
+		{"empty response", "/empty", auth, "invalid response"},
+		{"error status", "/unavailable", auth, "HTTP 503: model is loading"},
+		{"timeout", "/slow", auth, "request failed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := engine(tt.path, tt.headers).ProcessImageWithLayout(ctx, testImage(8, 8))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeConfidence(t *testing.T) {
+	for _, tt := range []struct{ in, want float64 }{
+		{0.87, 0.87}, {1, 1}, {87, 0.87}, {100, 1}, {250, 1}, {-0.5, 0}, {0, 0},
+	} {
+		if got := normalizeConfidence(tt.in); got != tt.want {
+			t.Errorf("normalizeConfidence(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,732 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// Field value types of extraction templates.
+const (
+	FieldTypeString = "string"
+	FieldTypeNumber = "number"
+	FieldTypeAmount = "amount"
+	FieldTypeDate   = "date"
+)
+
+// FieldTemplate describes how to read the fields of one document type, e.g.
+// invoice number, date, total and vendor of invoices.
+type FieldTemplate struct {
+	DocumentType string      `json:"document_type"`
+	Keywords     []string    `json:"keywords"`     // case-insensitive; the template with most hits applies
+	MinKeywords  int         `json:"min_keywords"` // default 1
+	Fields       []FieldRule `json:"fields"`
+	Required     []string    `json:"required"`
+}
+
+// FieldRule locates one field. Exactly one of Regex, Anchor and Region is set.
+//
+//   - Regex is matched against the document text; the first capture group (or
+//     the whole match) is the value.
+//   - Anchor is a regex matched against the words of each line; the value is
+//     the text to its right on the same line or, failing that, the text below
+//     it. Direction restricts this to "right" or "below".
+//   - Region is a box in page fractions (0-1); the value is the text inside.
+//
+// Pattern, when set, must match the located text and its first capture group
+// (or the whole match) becomes the value. Validate must match the normalized
+// value.
+type FieldRule struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"` // string (default), number, amount, date
+	Regex       string    `json:"regex,omitempty"`
+	Anchor      string    `json:"anchor,omitempty"`
+	Direction   string    `json:"direction,omitempty"`    // "right", "below", or "" for both
+	MaxDistance float64   `json:"max_distance,omitempty"` // anchors: in line heights; 0 = rest of the line, next line
+	Region      *FieldBox `json:"region,omitempty"`
+	Page        int       `json:"page,omitempty"` // 0 = any page (regions: first page)
+	Pattern     string    `json:"pattern,omitempty"`
+	Validate    string    `json:"validate,omitempty"`
+	DateFormats []string  `json:"date_formats,omitempty"` // Go layouts tried before the defaults
+
+	regex, anchor, pattern, validate *regexp.Regexp
+}
+
+// FieldBox is a region in fractions of the page size.
+type FieldBox struct {
+	X0 float64 `json:"x0"`
+	Y0 float64 `json:"y0"`
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+}
+
+// FieldTemplateSet is the set of templates loaded at startup.
+type FieldTemplateSet struct {
+	Templates []*FieldTemplate `json:"templates"`
+}
+
+// LoadFieldTemplates reads and validates a template file.
+func LoadFieldTemplates(path string) (*FieldTemplateSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field templates: %w", err)
+	}
+	var set FieldTemplateSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse field templates %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, t := range set.Templates {
+		if t.DocumentType == "" {
+			return nil, fmt.Errorf("template %d: document_type is required", i)
+		}
+		if seen[t.DocumentType] {
+			return nil, fmt.Errorf("template %s: duplicate document_type", t.DocumentType)
+		}
+		seen[t.DocumentType] = true
+		if err := t.compile(); err != nil {
+			return nil, fmt.Errorf("template %s: %w", t.DocumentType, err)
+		}
+	}
+	return &set, nil
+}
+
+// FieldTemplatesFromEnv loads the templates named by OCR_FIELD_TEMPLATES; nil when unset.
+func FieldTemplatesFromEnv() (*FieldTemplateSet, error) {
+	path := os.Getenv("OCR_FIELD_TEMPLATES")
+	if path == "" {
+		return nil, nil
+	}
+	return LoadFieldTemplates(path)
+}
+
+// compile validates the template and compiles its expressions.
+func (t *FieldTemplate) compile() error {
+	if t.MinKeywords <= 0 {
+		t.MinKeywords = 1
+	}
+	names := map[string]bool{}
+	for i := range t.Fields {
+		f := &t.Fields[i]
+		if f.Name == "" {
+			return fmt.Errorf("field %d: name is required", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("field %s: duplicate name", f.Name)
+		}
+		names[f.Name] = true
+		switch f.Type {
+		case "":
+			f.Type = FieldTypeString
+		case FieldTypeString, FieldTypeNumber, FieldTypeAmount, FieldTypeDate:
+		default:
+			return fmt.Errorf("field %s: unknown type %q", f.Name, f.Type)
+		}
+		locators := 0
+		for _, set := range []bool{f.Regex != "", f.Anchor != "", f.Region != nil} {
+			if set {
+				locators++
+			}
+		}
+		if locators != 1 {
+			return fmt.Errorf("field %s: exactly one of regex, anchor and region is required", f.Name)
+		}
+		if f.Direction != "" && f.Direction != "right" && f.Direction != "below" {
+			return fmt.Errorf("field %s: direction must be right or below", f.Name)
+		}
+		if f.MaxDistance < 0 {
+			return fmt.Errorf("field %s: max_distance must not be negative", f.Name)
+		}
+		var err error
+		for _, c := range []struct {
+			expr string
+			dst  **regexp.Regexp
+		}{{f.Regex, &f.regex}, {f.Anchor, &f.anchor}, {f.Pattern, &f.pattern}, {f.Validate, &f.validate}} {
+			if c.expr == "" {
+				continue
+			}
+			if *c.dst, err = regexp.Compile(c.expr); err != nil {
+				return fmt.Errorf("field %s: %w", f.Name, err)
+			}
+		}
+	}
+	for _, name := range t.Required {
+		if !names[name] {
+			return fmt.Errorf("required field %s is not defined", name)
+		}
+	}
+	return nil
+}
+
+// ExtractedField is a field value read from a document.
+type ExtractedField struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`      // text as read
+	Normalized string      `json:"normalized"` // trimmed text, number, or date as YYYY-MM-DD
+	Number     float64     `json:"number,omitempty"`
+	Confidence float64     `json:"confidence"`
+	PageNumber int         `json:"page_number,omitempty"`
+	BBox       BoundingBox `json:"bbox"`
+	Method     string      `json:"method"` // regex, anchor, region
+}
+
+// ExtractedFields is the outcome of running a template on a document.
+type ExtractedFields struct {
+	Filename         string
+	StorageProvider  string
+	EngineName       string
+	DocumentType     string
+	Fields           []ExtractedField
+	ValidationErrors []string
+	ExtractedAt      time.Time
+}
+
+// Valid reports whether all required fields were found and all values parsed.
+func (e *ExtractedFields) Valid() bool {
+	return len(e.ValidationErrors) == 0
+}
+
+// Field returns the named field, or nil.
+func (e *ExtractedFields) Field(name string) *ExtractedField {
+	for i := range e.Fields {
+		if e.Fields[i].Name == name {
+			return &e.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Match returns the template for a document text: the one with the most
+// keyword hits at or above its minimum, the first on ties; nil for none.
+func (s *FieldTemplateSet) Match(text string) *FieldTemplate {
+	if s == nil {
+		return nil
+	}
+	lower := strings.ToLower(text)
+	var best *FieldTemplate
+	bestHits := 0
+	for _, t := range s.Templates {
+		hits := 0
+		for _, k := range t.Keywords {
+			if strings.Contains(lower, strings.ToLower(k)) {
+				hits++
+			}
+		}
+		if hits >= t.MinKeywords && hits > bestHits {
+			best, bestHits = t, hits
+		}
+	}
+	return best
+}
+
+// Extract runs the template on an OCR result. Page layouts, when present,
+// are used by anchor and region rules; without them anchors fall back to
+// the page text.
+func (t *FieldTemplate) Extract(result *OCRResult) *ExtractedFields {
+	out := &ExtractedFields{
+		Filename:        result.Filename,
+		StorageProvider: result.StorageProvider,
+		EngineName:      result.EngineName,
+		DocumentType:    t.DocumentType,
+		ExtractedAt:     time.Now(),
+	}
+	for i := range t.Fields {
+		rule := &t.Fields[i]
+		field, ok := rule.locate(result)
+		if !ok {
+			continue
+		}
+		if err := rule.normalize(&field); err != nil {
+			out.ValidationErrors = append(out.ValidationErrors, err.Error())
+		}
+		out.Fields = append(out.Fields, field)
+	}
+	for _, name := range t.Required {
+		if out.Field(name) == nil {
+			out.ValidationErrors = append(out.ValidationErrors, fmt.Sprintf("%s: required field not found", name))
+		}
+	}
+	return out
+}
+
+// locate finds the raw value of a field.
+func (f *FieldRule) locate(result *OCRResult) (ExtractedField, bool) {
+	field := ExtractedField{Name: f.Name, Type: f.Type}
+	pages := result.Pages
+	if len(pages) == 0 {
+		pages = []OCRPage{{PageNumber: 1, Text: documentText(result), Confidence: result.Confidence}}
+	}
+	for _, page := range pages {
+		if f.Page > 0 && page.PageNumber != f.Page {
+			continue
+		}
+		var value string
+		var box BoundingBox
+		var confidence float64
+		var ok bool
+		switch {
+		case f.regex != nil:
+			field.Method = "regex"
+			value, ok = firstGroup(f.regex, page.Text)
+			confidence = page.Confidence
+		case f.anchor != nil:
+			field.Method = "anchor"
+			value, box, confidence, ok = f.anchorValue(page)
+		case f.Region != nil:
+			field.Method = "region"
+			value, box, confidence, ok = f.regionValue(page)
+		}
+		if !ok {
+			if f.Region != nil && f.Page == 0 {
+				break
+			}
+			continue
+		}
+		field.Value = strings.TrimSpace(value)
+		field.PageNumber = page.PageNumber
+		field.BBox = box
+		field.Confidence = confidence
+		return field, true
+	}
+	return field, false
+}
+
+// firstGroup returns the first capture group of the first match, or the
+// whole match when the expression has no groups.
+func firstGroup(re *regexp.Regexp, text string) (string, bool) {
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	if len(m) > 1 {
+		return m[1], strings.TrimSpace(m[1]) != ""
+	}
+	return m[0], strings.TrimSpace(m[0]) != ""
+}
+
+// applyPattern narrows located text with the rule's pattern.
+func (f *FieldRule) applyPattern(text string) (string, bool) {
+	text = strings.TrimLeft(strings.TrimSpace(text), ":：#- ")
+	if f.pattern == nil {
+		return text, text != ""
+	}
+	return firstGroup(f.pattern, text)
+}
+
+// anchorValue finds the text right of or below each anchor occurrence on the
+// page and returns the first that passes the pattern.
+func (f *FieldRule) anchorValue(page OCRPage) (string, BoundingBox, float64, bool) {
+	words := page.Layout.Words()
+	if len(words) == 0 {
+		return f.anchorValueInText(page)
+	}
+	lineHeight := medianWordHeight(words)
+	rows := visualRows(words, lineHeight)
+	// Without max_distance the value may be anywhere right on the line, or
+	// on the next line when that follows within a line spacing.
+	maxRight, maxBelow := math.MaxInt, lineHeight*5/2
+	if f.MaxDistance > 0 {
+		maxRight = int(f.MaxDistance * float64(lineHeight))
+		maxBelow = maxRight
+	}
+	for r, row := range rows {
+		rowWords := rowWordList(row)
+		for _, anchor := range anchorSpans(f.anchor, rowWords) {
+			anchorBox := segmentBox(rowWords[anchor[0]:anchor[1]])
+			if f.Direction != "below" {
+				var right []OCRWord
+				for _, w := range rowWords[anchor[1]:] {
+					if w.BBox.X0-anchorBox.X1 > maxRight {
+						break
+					}
+					if len(right) > 0 && w.BBox.X0-right[len(right)-1].BBox.X1 > lineHeight*3 {
+						break
+					}
+					right = append(right, w)
+				}
+				if value, ok := f.applyPattern(joinWords(right)); ok {
+					return value, segmentBox(right), meanWordConfidence(right), true
+				}
+			}
+			if f.Direction != "right" && r+1 < len(rows) {
+				next := rows[r+1]
+				if next.box.Y0-anchorBox.Y1 > maxBelow {
+					continue
+				}
+				for _, seg := range next.segments {
+					box := segmentBox(seg)
+					if box.X0 < anchorBox.X1+lineHeight*2 && anchorBox.X0-lineHeight*2 < box.X1 {
+						if value, ok := f.applyPattern(joinWords(seg)); ok {
+							return value, box, meanWordConfidence(seg), true
+						}
+					}
+				}
+			}
+		}
+	}
+	return "", BoundingBox{}, 0, false
+}
+
+// anchorValueInText is anchorValue for pages without a layout: the rest of
+// the anchor's line, or the next non-empty line.
+func (f *FieldRule) anchorValueInText(page OCRPage) (string, BoundingBox, float64, bool) {
+	lines := strings.Split(page.Text, "\n")
+	for i, line := range lines {
+		loc := f.anchor.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		if f.Direction != "below" {
+			if value, ok := f.applyPattern(line[loc[1]:]); ok {
+				return value, BoundingBox{}, page.Confidence, true
+			}
+		}
+		if f.Direction != "right" {
+			for _, next := range lines[i+1:] {
+				if strings.TrimSpace(next) == "" {
+					continue
+				}
+				if value, ok := f.applyPattern(next); ok {
+					return value, BoundingBox{}, page.Confidence, true
+				}
+				break
+			}
+		}
+	}
+	return "", BoundingBox{}, 0, false
+}
+
+// regionValue reads the words whose centers fall in the rule's region.
+func (f *FieldRule) regionValue(page OCRPage) (string, BoundingBox, float64, bool) {
+	if page.Layout == nil || page.Layout.Width == 0 || page.Layout.Height == 0 {
+		return "", BoundingBox{}, 0, false
+	}
+	w, h := float64(page.Layout.Width), float64(page.Layout.Height)
+	region := BoundingBox{
+		X0: int(f.Region.X0 * w), Y0: int(f.Region.Y0 * h),
+		X1: int(math.Ceil(f.Region.X1 * w)), Y1: int(math.Ceil(f.Region.Y1 * h)),
+	}
+	var inside []OCRWord
+	for _, word := range page.Layout.Words() {
+		cx, cy := (word.BBox.X0+word.BBox.X1)/2, (word.BBox.Y0+word.BBox.Y1)/2
+		if cx >= region.X0 && cx < region.X1 && cy >= region.Y0 && cy < region.Y1 {
+			inside = append(inside, word)
+		}
+	}
+	if len(inside) == 0 {
+		return "", BoundingBox{}, 0, false
+	}
+	text, confidence := cellText(inside)
+	value, ok := f.applyPattern(text)
+	return value, segmentBox(inside), confidence, ok
+}
+
+// rowWordList flattens the segments of a row.
+func rowWordList(row tableRow) []OCRWord {
+	var words []OCRWord
+	for _, seg := range row.segments {
+		words = append(words, seg...)
+	}
+	return words
+}
+
+// anchorSpans returns the [first, end) word ranges covered by matches of
+// anchor in the space-joined words.
+func anchorSpans(anchor *regexp.Regexp, words []OCRWord) [][2]int {
+	starts := make([]int, len(words))
+	var b strings.Builder
+	for i, w := range words {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		starts[i] = b.Len()
+		b.WriteString(w.Text)
+	}
+	var spans [][2]int
+	for _, loc := range anchor.FindAllStringIndex(b.String(), -1) {
+		first, end := -1, 0
+		for i, start := range starts {
+			if start < loc[1] && start+len(words[i].Text) > loc[0] {
+				if first < 0 {
+					first = i
+				}
+				end = i + 1
+			}
+		}
+		if first >= 0 {
+			spans = append(spans, [2]int{first, end})
+		}
+	}
+	return spans
+}
+
+func joinWords(words []OCRWord) string {
+	parts := make([]string, len(words))
+	for i, w := range words {
+		parts[i] = w.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+// normalize parses the value by type and applies the rule's validation.
+func (f *FieldRule) normalize(field *ExtractedField) error {
+	switch f.Type {
+	case FieldTypeNumber, FieldTypeAmount:
+		n, err := parseAmount(field.Value)
+		if err != nil {
+			return fmt.Errorf("%s: cannot read %q as %s", f.Name, field.Value, f.Type)
+		}
+		field.Number = n
+		field.Normalized = strconv.FormatFloat(n, 'f', -1, 64)
+	case FieldTypeDate:
+		d, err := parseFieldDate(field.Value, f.DateFormats)
+		if err != nil {
+			return fmt.Errorf("%s: cannot read %q as a date", f.Name, field.Value)
+		}
+		field.Normalized = d.Format("2006-01-02")
+	default:
+		field.Normalized = strings.Join(strings.Fields(field.Value), " ")
+	}
+	if f.validate != nil && !f.validate.MatchString(field.Normalized) {
+		return fmt.Errorf("%s: %q does not match %s", f.Name, field.Normalized, f.Validate)
+	}
+	return nil
+}
+
+// parseAmount reads numbers as printed on documents: currency signs and
+// codes are ignored, "1,234.56" and "1.234,56" are both 1234.56, and a
+// leading minus or parentheses make it negative.
+func parseAmount(text string) (float64, error) {
+	text = strings.TrimSpace(foldWidth(text))
+	negative := strings.HasPrefix(text, "-") || (strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")"))
+	var digits strings.Builder
+	for _, r := range text {
+		if unicode.IsDigit(r) || r == '.' || r == ',' {
+			digits.WriteRune(r)
+		}
+	}
+	s := strings.Trim(digits.String(), ".,")
+	if s == "" {
+		return 0, fmt.Errorf("no digits")
+	}
+	lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	decimal := byte(0)
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = s[max(lastDot, lastComma)]
+	case lastComma >= 0 && len(s)-lastComma-1 != 3:
+		decimal = ','
+	case lastDot >= 0 && (len(s)-lastDot-1 != 3 || strings.Count(s, ".") == 1):
+		decimal = '.'
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == decimal && i == strings.LastIndexByte(s, decimal):
+			b.WriteByte('.')
+		case s[i] == '.' || s[i] == ',':
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	n, err := strconv.ParseFloat(b.String(), 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// defaultDateFormats are tried after a rule's own formats. Day-first and
+// month-first numeric dates are ambiguous; month-first is tried first.
+var defaultDateFormats = []string{
+	"2006-01-02", "2006/1/2", "2006.1.2", "2006年1月2日",
+	"1/2/2006", "2/1/2006", "1-2-2006", "2.1.2006",
+	"Jan 2, 2006", "January 2, 2006", "2 Jan 2006", "2 January 2006", "Jan 2 2006", "2-Jan-2006",
+}
+
+// parseFieldDate reads a date in the first matching format.
+func parseFieldDate(text string, formats []string) (time.Time, error) {
+	text = strings.Join(strings.Fields(strings.Trim(foldWidth(text), " .,")), " ")
+	for _, layout := range append(append([]string(nil), formats...), defaultDateFormats...) {
+		if d, err := time.Parse(layout, text); err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", text)
+}
+
+// preferredFieldSource picks the result to extract fields from: combined
+// results first, then the most confident engine.
+func preferredFieldSource(results []*OCRResult) *OCRResult {
+	var best *OCRResult
+	rank := func(r *OCRResult) int {
+		switch r.EngineName {
+		case EnsembleEngineName:
+			return 2
+		case CascadeEngineName:
+			return 1
+		}
+		return 0
+	}
+	for _, r := range results {
+		if r.Status != "completed" || r.EngineName == RedactedEngineName {
+			continue
+		}
+		if best == nil || rank(r) > rank(best) || (rank(r) == rank(best) && r.Confidence > best.Confidence) {
+			best = r
+		}
+	}
+	return best
+}
+
+// ExtractDocumentFields runs the matching template on the best stored OCR
+// result of a file (ensemble, then cascade, then the most confident engine)
+// and stores the record. It returns nil when no template matches.
+func ExtractDocumentFields(ctx context.Context, repo OCRResultRepository, templates *FieldTemplateSet, filename string, provider string) (*ExtractedFields, error) {
+	results, err := repo.GetOCRComparison(ctx, filename, provider)
+	if err != nil {
+		return nil, err
+	}
+	source := preferredFieldSource(results)
+	if source == nil {
+		return nil, nil
+	}
+	template := templates.Match(documentText(source))
+	if template == nil {
+		return nil, nil
+	}
+	if err := attachStoredLayout(ctx, repo, source); err != nil {
+		return nil, err
+	}
+	fields := template.Extract(source)
+	fields.StorageProvider = provider
+	if err := repo.SaveExtractedFields(ctx, fields); err != nil {
+		return nil, err
+	}
+	if !fields.Valid() {
+		log.Printf("Field extraction for %s (%s): %s", filename, template.DocumentType, strings.Join(fields.ValidationErrors, "; "))
+	}
+	return fields, nil
+}
+
+// FieldFilter restricts a search to documents whose field equals Value
+// (case-insensitive), or whose numeric value lies within Min and Max.
+type FieldFilter struct {
+	Name  string
+	Value string
+	Min   *float64
+	Max   *float64
+}
+
+// DocumentSearchQuery searches the extracted fields of a provider's files.
+type DocumentSearchQuery struct {
+	StorageProvider string
+	DocumentType    string
+	Filters         []FieldFilter
+	FacetFields     []string // fields to count values of; document_type is always counted
+	ValidOnly       bool
+	Limit           int
+	Tenant          string // only files of this tenant; "" searches every file
+	// Namespaces limits the search to files stored in these namespaces
+	// (e.g. "documents/"; see file_metadata); nil searches every namespace.
+	Namespaces []string
+}
+
+// FacetValue is a field value and the number of matching documents with it.
+type FacetValue struct {
+	Value string
+	Count int
+}
+
+// FieldFacet counts the values of a field over the matching documents.
+type FieldFacet struct {
+	FieldName string
+	Values    []FacetValue
+}
+
+// DocumentSearchResult is the page of matching documents and the facets of
+// all matches.
+type DocumentSearchResult struct {
+	Total     int
+	Documents []*ExtractedFields
+	Facets    []FieldFacet
+}
+
+// DocumentTypeFacet is the facet name of the template that matched.
+const DocumentTypeFacet = "document_type"
+
+// ExtractedFieldsToProto converts an extraction record to its message.
+func ExtractedFieldsToProto(fields *ExtractedFields) *pb.ExtractedFields {
+	out := &pb.ExtractedFields{
+		Filename:         fields.Filename,
+		StorageProvider:  fields.StorageProvider,
+		EngineName:       fields.EngineName,
+		DocumentType:     fields.DocumentType,
+		ValidationErrors: fields.ValidationErrors,
+		Valid:            fields.Valid(),
+		ExtractedAt:      fields.ExtractedAt.Unix(),
+	}
+	for _, f := range fields.Fields {
+		out.Fields = append(out.Fields, &pb.ExtractedField{
+			Name:       f.Name,
+			Type:       f.Type,
+			Value:      f.Value,
+			Normalized: f.Normalized,
+			Number:     f.Number,
+			Confidence: f.Confidence,
+			PageNumber: int32(f.PageNumber),
+			Bbox:       boundingBoxToProto(f.BBox),
+			Method:     f.Method,
+		})
+	}
+	return out
+}
+
+// DocumentSearchQueryFromProto converts a search request; the provider
+// defaults to s3.
+func DocumentSearchQueryFromProto(req *pb.SearchDocumentsRequest) DocumentSearchQuery {
+	query := DocumentSearchQuery{
+		StorageProvider: req.StorageProvider,
+		DocumentType:    req.DocumentType,
+		FacetFields:     req.FacetFields,
+		ValidOnly:       req.ValidOnly,
+		Limit:           int(req.Limit),
+	}
+	if query.StorageProvider == "" {
+		query.StorageProvider = "s3"
+	}
+	for _, f := range req.Filters {
+		query.Filters = append(query.Filters, FieldFilter{Name: f.Name, Value: f.Value, Min: f.Min, Max: f.Max})
+	}
+	return query
+}
+
+// DocumentSearchResultToProto converts a search result to its response.
+func DocumentSearchResultToProto(result *DocumentSearchResult) *pb.SearchDocumentsResponse {
+	resp := &pb.SearchDocumentsResponse{Total: int32(result.Total)}
+	for _, doc := range result.Documents {
+		resp.Documents = append(resp.Documents, ExtractedFieldsToProto(doc))
+	}
+	for _, facet := range result.Facets {
+		pf := &pb.FieldFacet{FieldName: facet.FieldName}
+		for _, v := range facet.Values {
+			pf.Values = append(pf.Values, &pb.FacetValue{Value: v.Value, Count: int32(v.Count)})
+		}
+		resp.Facets = append(resp.Facets, pf)
+	}
+	return resp
+}
@@ -0,0 +1,435 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exampleFieldTemplates loads the templates shipped with the OCR service.
+func exampleFieldTemplates(t *testing.T) *FieldTemplateSet {
+	t.Helper()
+	set, err := LoadFieldTemplates("../ocr/templates/field_templates.example.json")
+	if err != nil {
+		t.Fatalf("LoadFieldTemplates: %v", err)
+	}
+	return set
+}
+
+// sampleInvoiceText is an invoice as read by an engine without a layout.
+const sampleInvoiceText = `ACME Trading Co.
+INVOICE
+Invoice No: INV-2024-001
+Invoice Date: 15/03/2024
+Bill To: Example Corp
+Total Due: $1,234.50`
+
+func TestFieldTemplateMatch(t *testing.T) {
+	set := exampleFieldTemplates(t)
+	for _, tt := range []struct {
+		text string
+		want string // document type; "" for no template
+	}{
+		{sampleInvoiceText, "invoice"},
+		{"RECEIPT\nCoffee 450\nTotal 450\nChange 50", "receipt"},
+		{"領収書\n合計 ¥1,200", "receipt"},
+		{"請求書\n合計金額 ¥12,000", "invoice"},
+		// Two receipt keywords beat one invoice keyword
+		{"Receipt for invoice 42\nChange due", "receipt"},
+		// A tie goes to the first template
+		{"Receipt for invoice 42", "invoice"},
+		{"Meeting notes\nAgenda", ""},
+		{"", ""},
+	} {
+		got := ""
+		if template := set.Match(tt.text); template != nil {
+			got = template.DocumentType
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	var none *FieldTemplateSet
+	if none.Match(sampleInvoiceText) != nil {
+		t.Error("a nil template set matched")
+	}
+	strict := &FieldTemplateSet{Templates: []*FieldTemplate{{DocumentType: "contract", Keywords: []string{"agreement", "parties"}, MinKeywords: 2}}}
+	if strict.Match("Service Agreement") != nil || strict.Match("Agreement between the parties") == nil {
+		t.Error("min_keywords is not applied")
+	}
+}
+
+func TestLoadFieldTemplatesRejectsInvalidTemplates(t *testing.T) {
+	for _, tt := range []struct {
+		name, json, wantErr string
+	}{
+		{"no document type", `{"templates":[{"fields":[]}]}`, "document_type is required"},
+		{"duplicate type", `{"templates":[{"document_type":"a"},{"document_type":"a"}]}`, "duplicate document_type"},
+		{"no locator", `{"templates":[{"document_type":"a","fields":[{"name":"x"}]}]}`, "exactly one of"},
+		{"two locators", `{"templates":[{"document_type":"a","fields":[{"name":"x","regex":"x","anchor":"y"}]}]}`, "exactly one of"},
+		{"unknown type", `{"templates":[{"document_type":"a","fields":[{"name":"x","type":"money","regex":"x"}]}]}`, "unknown type"},
+		{"bad regex", `{"templates":[{"document_type":"a","fields":[{"name":"x","regex":"("}]}]}`, "field x"},
+		{"bad direction", `{"templates":[{"document_type":"a","fields":[{"name":"x","anchor":"x","direction":"left"}]}]}`, "direction"},
+		{"undefined required field", `{"templates":[{"document_type":"a","fields":[{"name":"x","regex":"x"}],"required":["y"]}]}`, "required field y"},
+	} {
+		path := filepath.Join(t.TempDir(), "templates.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadFieldTemplates(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// layoutOfLines builds a page layout with one line per word list.
+func layoutOfLines(lines ...[]OCRWord) *OCRPageLayout {
+	block := OCRBlock{}
+	for _, words := range lines {
+		block.Lines = append(block.Lines, OCRLine{BBox: segmentBox(words), Words: words})
+	}
+	return &OCRPageLayout{Width: 600, Height: 400, Blocks: []OCRBlock{block}}
+}
+
+func TestFieldTemplateExtract(t *testing.T) {
+	invoice := exampleFieldTemplates(t).Match(sampleInvoiceText)
+	if invoice == nil {
+		t.Fatal("no template for the sample invoice")
+	}
+	type want struct {
+		normalized string
+		number     float64
+		method     string
+	}
+	for _, tt := range []struct {
+		name   string
+		page   OCRPage
+		fields map[string]want // fields not listed must be missing
+		errors []string
+	}{
+		{
+			name: "text without a layout",
+			page: OCRPage{PageNumber: 1, Text: sampleInvoiceText, Confidence: 0.8},
+			fields: map[string]want{
+				"invoice_number": {"INV-2024-001", 0, "anchor"},
+				"invoice_date":   {"2024-03-15", 0, "anchor"},
+				"total":          {"1234.5", 1234.5, "anchor"},
+			},
+		},
+		{
+			name: "layout with values right of and below the anchors",
+			page: OCRPage{PageNumber: 1, Confidence: 0.9, Layout: layoutOfLines(
+				[]OCRWord{testWord("ACME", 20, 10, 70, 24), testWord("Trading", 76, 10, 140, 24), testWord("Co.", 146, 10, 170, 24)},
+				[]OCRWord{testWord("Invoice", 20, 60, 80, 74), testWord("No", 86, 60, 106, 74), testWord("INV-7", 112, 60, 160, 74)},
+				[]OCRWord{testWord("Date", 20, 90, 60, 104), testWord("2024-03-15", 66, 90, 150, 104)},
+				[]OCRWord{testWord("Total", 20, 130, 70, 144)},
+				[]OCRWord{testWord("(¥1,200)", 20, 150, 90, 164)},
+			)},
+			fields: map[string]want{
+				"vendor":         {"ACME Trading Co.", 0, "region"},
+				"invoice_number": {"INV-7", 0, "anchor"},
+				"invoice_date":   {"2024-03-15", 0, "anchor"},
+				"total":          {"-1200", -1200, "anchor"},
+			},
+		},
+		{
+			name: "missing and unreadable fields",
+			page: OCRPage{PageNumber: 1, Text: "Invoice No: inv\nInvoice Date: someday\nTotal: $120", Confidence: 0.5},
+			fields: map[string]want{
+				"invoice_date": {"", 0, "anchor"},
+				"total":        {"120", 120, "anchor"},
+			},
+			errors: []string{`invoice_date: cannot read "someday" as a date`, "invoice_number: required field not found"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &OCRResult{Filename: "invoice.pdf", EngineName: "tesseract", Pages: []OCRPage{tt.page}}
+			fields := invoice.Extract(result)
+			if fields.DocumentType != "invoice" || fields.Filename != "invoice.pdf" || fields.EngineName != "tesseract" {
+				t.Fatalf("extracted %+v", fields)
+			}
+			if strings.Join(fields.ValidationErrors, "; ") != strings.Join(tt.errors, "; ") || fields.Valid() != (len(tt.errors) == 0) {
+				t.Errorf("validation errors = %q, want %q", fields.ValidationErrors, tt.errors)
+			}
+			for _, name := range []string{"invoice_number", "invoice_date", "total", "vendor"} {
+				got, w := fields.Field(name), tt.fields[name]
+				if _, ok := tt.fields[name]; !ok {
+					if got != nil {
+						t.Errorf("%s = %+v, want it missing", name, got)
+					}
+					continue
+				}
+				if got == nil {
+					t.Errorf("%s not found", name)
+					continue
+				}
+				if got.Normalized != w.normalized || got.Number != w.number || got.Method != w.method || got.PageNumber != 1 {
+					t.Errorf("%s = %q (%v) by %s, want %q (%v) by %s", name, got.Normalized, got.Number, got.Method, w.normalized, w.number, w.method)
+				}
+				if tt.page.Layout != nil && got.BBox == (BoundingBox{}) {
+					t.Errorf("%s has no box", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldNormalization(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want float64
+	}{
+		{"$1,234.50", 1234.5},
+		{"1.234,56 €", 1234.56},
+		{"EUR 3.000,00", 3000},
+		{"¥1,200", 1200},
+		{"1,234", 1234},
+		{"12,5", 12.5},
+		{"1.5", 1.5},
+		{"1.234.567", 1234567},
+		{"-12", -12},
+		{"(45.00)", -45},
+		{"１２，３４５円", 12345},
+	} {
+		if got, err := parseAmount(tt.text); err != nil || got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, %v; want %v", tt.text, got, err, tt.want)
+		}
+	}
+	for _, text := range []string{"", "n/a", "$"} {
+		if got, err := parseAmount(text); err == nil {
+			t.Errorf("parseAmount(%q) = %v, want an error", text, got)
+		}
+	}
+
+	for _, tt := range []struct {
+		text    string
+		formats []string
+		want    string // "" for an error
+	}{
+		{"2024-03-15", nil, "2024-03-15"},
+		{"2024/3/5", nil, "2024-03-05"},
+		{"2024年3月15日", nil, "2024-03-15"},
+		{"２０２４年３月１５日", nil, "2024-03-15"},
+		// Numeric dates are month-first unless that cannot be a date
+		{"03/04/2024", nil, "2024-03-04"},
+		{"15/03/2024", nil, "2024-03-15"},
+		{"03/04/2024", []string{"02/01/2006"}, "2024-04-03"},
+		{"Mar 15, 2024.", nil, "2024-03-15"},
+		{"15  March 2024", nil, "2024-03-15"},
+		{"someday", nil, ""},
+	} {
+		got, err := parseFieldDate(tt.text, tt.formats)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseFieldDate(%q) = %v, want an error", tt.text, got)
+			}
+		} else if err != nil || got.Format("2006-01-02") != tt.want {
+			t.Errorf("parseFieldDate(%q, %v) = %v, %v; want %s", tt.text, tt.formats, got, err, tt.want)
+		}
+	}
+
+	rules := []FieldRule{
+		{Name: "vendor", Regex: "x"},
+		{Name: "code", Regex: "x", Validate: "^[A-Z]{3}$"},
+		{Name: "count", Type: FieldTypeNumber, Regex: "x"},
+	}
+	template := &FieldTemplate{DocumentType: "t", Fields: rules}
+	if err := template.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		rule, value, want, wantErr string
+	}{
+		{"vendor", "  ACME \n Trading  ", "ACME Trading", ""},
+		{"code", "ABC", "ABC", ""},
+		{"code", "AB1", "AB1", "does not match"},
+		{"count", "1,000 pcs", "1000", ""},
+		{"count", "none", "", "cannot read"},
+	} {
+		var rule *FieldRule
+		for i := range template.Fields {
+			if template.Fields[i].Name == tt.rule {
+				rule = &template.Fields[i]
+			}
+		}
+		field := ExtractedField{Name: tt.rule, Type: rule.Type, Value: tt.value}
+		err := rule.normalize(&field)
+		if field.Normalized != tt.want || (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s %q normalized to %q, %v; want %q, %q", tt.rule, tt.value, field.Normalized, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// newTestOCRResultRepository opens the OCR result tables in a temporary database.
+func newTestOCRResultRepository(t *testing.T) *sqliteOCRResultRepository {
+	t.Helper()
+	previous := dbPath
+	dbPath = filepath.Join(t.TempDir(), "files.db")
+	t.Cleanup(func() { dbPath = previous })
+	metadata, err := NewFileMetadataRepository(context.Background())
+	if err != nil {
+		t.Fatalf("NewFileMetadataRepository: %v", err)
+	}
+	metadata.(*sqliteFileMetadataRepository).Close()
+	repo, err := NewOCRResultRepository(context.Background())
+	if err != nil {
+		t.Fatalf("NewOCRResultRepository: %v", err)
+	}
+	r := repo.(*sqliteOCRResultRepository)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestExtractDocumentFields(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestOCRResultRepository(t)
+	templates := exampleFieldTemplates(t)
+	for _, result := range []*OCRResult{
+		{Filename: "invoice.pdf", StorageProvider: "s3", EngineName: "tesseract", Status: "completed", Confidence: 0.6,
+			Pages: []OCRPage{{PageNumber: 1, Text: strings.Replace(sampleInvoiceText, "1,234.50", "1,284.50", 1), Confidence: 0.6}}},
+		{Filename: "invoice.pdf", StorageProvider: "s3", EngineName: "easyocr", Status: "completed", Confidence: 0.9,
+			Pages: []OCRPage{{PageNumber: 1, Text: sampleInvoiceText, Confidence: 0.9}}},
+		{Filename: "notes.pdf", StorageProvider: "s3", EngineName: "tesseract", Status: "completed", Confidence: 0.9,
+			Pages: []OCRPage{{PageNumber: 1, Text: "Meeting notes", Confidence: 0.9}}},
+	} {
+		if err := repo.SaveOCRResult(ctx, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields, err := ExtractDocumentFields(ctx, repo, templates, "invoice.pdf", "s3")
+	if err != nil || fields == nil {
+		t.Fatalf("ExtractDocumentFields = %v, %v", fields, err)
+	}
+	// The more confident engine is read
+	if fields.EngineName != "easyocr" || fields.Field("total").Number != 1234.5 || !fields.Valid() {
+		t.Fatalf("extracted %s, total %+v, errors %v", fields.EngineName, fields.Field("total"), fields.ValidationErrors)
+	}
+	stored, err := repo.GetExtractedFields(ctx, "invoice.pdf", "s3")
+	if err != nil || stored == nil || stored.DocumentType != "invoice" || len(stored.Fields) != len(fields.Fields) {
+		t.Fatalf("stored %+v, %v", stored, err)
+	}
+
+	if fields, err := ExtractDocumentFields(ctx, repo, templates, "notes.pdf", "s3"); fields != nil || err != nil {
+		t.Fatalf("notes.pdf extracted %+v, %v; want no template", fields, err)
+	}
+	if stored, _ := repo.GetExtractedFields(ctx, "notes.pdf", "s3"); stored != nil {
+		t.Fatal("fields stored for a document without a template")
+	}
+}
+
+func TestSearchExtractedFields(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestOCRResultRepository(t)
+	start := time.Unix(1_800_000_000, 0)
+	for i, doc := range []struct {
+		filename, provider, docType, vendor string
+		total                               float64
+		valid                               bool
+	}{
+		{"a.pdf", "s3", "invoice", "ACME", 100, true},
+		{"b.pdf", "s3", "invoice", "ACME", 250, true},
+		{"c.pdf", "s3", "invoice", "Globex", 900, false},
+		{"d.pdf", "s3", "receipt", "", 250, true},
+		{"e.pdf", "gcs", "invoice", "ACME", 100, true},
+	} {
+		fields := &ExtractedFields{
+			Filename: doc.filename, StorageProvider: doc.provider, EngineName: "tesseract", DocumentType: doc.docType,
+			ExtractedAt: start.Add(time.Duration(i) * time.Minute),
+			Fields:      []ExtractedField{{Name: "total", Type: FieldTypeAmount, Value: fmt.Sprint(doc.total), Normalized: fmt.Sprint(doc.total), Number: doc.total}},
+		}
+		if doc.vendor != "" {
+			fields.Fields = append(fields.Fields, ExtractedField{Name: "vendor", Type: FieldTypeString, Value: doc.vendor, Normalized: doc.vendor})
+		}
+		if !doc.valid {
+			fields.ValidationErrors = []string{"invoice_date: required field not found"}
+		}
+		if err := repo.SaveExtractedFields(ctx, fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving again replaces the record and its search rows
+	again := &ExtractedFields{Filename: "a.pdf", StorageProvider: "s3", EngineName: "easyocr", DocumentType: "invoice", ExtractedAt: start,
+		Fields: []ExtractedField{{Name: "total", Type: FieldTypeAmount, Value: "100", Normalized: "100", Number: 100}, {Name: "vendor", Value: "ACME", Normalized: "ACME"}}}
+	if err := repo.SaveExtractedFields(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+
+	amount := func(n float64) *float64 { return &n }
+	for _, tt := range []struct {
+		name   string
+		query  DocumentSearchQuery
+		total  int
+		files  string // newest first
+		facets string
+	}{
+		{
+			name:   "all documents of a provider",
+			query:  DocumentSearchQuery{StorageProvider: "s3", FacetFields: []string{"vendor", DocumentTypeFacet}},
+			total:  4,
+			files:  "d.pdf c.pdf b.pdf a.pdf",
+			facets: "document_type[invoice=3 receipt=1] vendor[ACME=2 Globex=1]",
+		},
+		{
+			name:   "document type and amount range",
+			query:  DocumentSearchQuery{StorageProvider: "s3", DocumentType: "invoice", Filters: []FieldFilter{{Name: "total", Min: amount(200)}}, FacetFields: []string{"vendor"}},
+			total:  2,
+			files:  "c.pdf b.pdf",
+			facets: "document_type[invoice=2] vendor[ACME=1 Globex=1]",
+		},
+		{
+			name:   "value filters ignore case",
+			query:  DocumentSearchQuery{StorageProvider: "s3", Filters: []FieldFilter{{Name: "vendor", Value: "acme"}}, FacetFields: []string{"total"}},
+			total:  2,
+			files:  "b.pdf a.pdf",
+			facets: "document_type[invoice=2] total[100=1 250=1]",
+		},
+		{
+			name:   "valid documents up to an amount",
+			query:  DocumentSearchQuery{StorageProvider: "s3", ValidOnly: true, Filters: []FieldFilter{{Name: "total", Max: amount(250)}}, FacetFields: []string{"total"}},
+			total:  3,
+			files:  "d.pdf b.pdf a.pdf",
+			facets: "document_type[invoice=2 receipt=1] total[250=2 100=1]",
+		},
+		{
+			name:   "facets count every match beyond the limit",
+			query:  DocumentSearchQuery{StorageProvider: "s3", Limit: 1, FacetFields: []string{"vendor"}},
+			total:  4,
+			files:  "d.pdf",
+			facets: "document_type[invoice=3 receipt=1] vendor[ACME=2 Globex=1]",
+		},
+		{
+			name:   "no match",
+			query:  DocumentSearchQuery{StorageProvider: "s3", Filters: []FieldFilter{{Name: "vendor", Value: "Initech"}}, FacetFields: []string{"vendor"}},
+			total:  0,
+			files:  "",
+			facets: "document_type[] vendor[]",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := repo.SearchExtractedFields(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files, facets []string
+			for _, doc := range result.Documents {
+				files = append(files, doc.Filename)
+			}
+			for _, facet := range result.Facets {
+				var values []string
+				for _, v := range facet.Values {
+					values = append(values, fmt.Sprintf("%s=%d", v.Value, v.Count))
+				}
+				facets = append(facets, facet.FieldName+"["+strings.Join(values, " ")+"]")
+			}
+			if result.Total != tt.total || strings.Join(files, " ") != tt.files || strings.Join(facets, " ") != tt.facets {
+				t.Fatalf("got %d [%s] %s\nwant %d [%s] %s", result.Total, strings.Join(files, " "), strings.Join(facets, " "), tt.total, tt.files, tt.facets)
+			}
+		})
+	}
+}
@@ -4,13 +4,17 @@ import (
     "cloud.google.com/go/storage"
     "bytes"
     "context"
+    "errors"
     "fmt"
     pb "grpc-sample-minimal/proto"
     "io"
     "io/ioutil"
     "log"
+    "net/http"
+    "net/url"
     "os"
     "strings"
+    "time"
 )
 
 var (
@@ -48,7 +52,7 @@ func NewGCSStorageService(ctx context.Context) (StorageService, error) {
         log.Printf("NewGCSStorageService: Bucket %s exists (location: %s)", gcsBucketName, attrs.Location)
     }
 
-    return &gcsStorageService{client: client}, nil
+    return withEncryption(&gcsStorageService{client: client})
 }
 
 func (s *gcsStorageService) UploadFile(ctx context.Context, filename string, content io.Reader) (*pb.FileUploadStatus, error) {
@@ -108,17 +112,70 @@ func (s *gcsStorageService) DownloadFileByPath(ctx context.Context, storagePath
     return bytes.NewReader(data), nil
 }
 
+func (s *gcsStorageService) UploadFileByPath(ctx context.Context, storagePath string, content io.Reader) error {
+    wc := s.client.Bucket(gcsBucketName).Object(storagePath).NewWriter(ctx)
+    if _, err := io.Copy(wc, content); err != nil {
+        _ = wc.Close()
+        return fmt.Errorf("failed to write object to GCS: %w", err)
+    }
+    if err := wc.Close(); err != nil {
+        return fmt.Errorf("failed to close GCS writer: %w", err)
+    }
+    return nil
+}
+
 func (s *gcsStorageService) DeleteFile(ctx context.Context, filename string) error {
 	// Build storage path with namespace prefix
-	storagePath := BuildStoragePath(filename)
+	return s.DeleteFileByPath(ctx, BuildStoragePath(filename))
+}
 
+func (s *gcsStorageService) DeleteFileByPath(ctx context.Context, storagePath string) error {
 	obj := s.client.Bucket(gcsBucketName).Object(storagePath)
-	if err := obj.Delete(ctx); err != nil {
+	// Like S3, deleting a missing object succeeds
+	if err := obj.Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
 		return fmt.Errorf("failed to delete file from GCS: %w", err)
 	}
 	return nil
 }
 
+// SignedUploadURL returns a V4 signed URL for a PUT. Signing needs service
+// account credentials; the emulator has none.
+func (s *gcsStorageService) SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error) {
+	expiresAt := time.Now().Add(expires)
+	// The declared size is signed as a length range, so GCS refuses larger bodies
+	lengthRange := fmt.Sprintf("0,%d", size)
+	signed, err := s.client.Bucket(gcsBucketName).SignedURL(storagePath, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      http.MethodPut,
+		Expires:     expiresAt,
+		ContentType: contentType,
+		Headers:     []string{"x-goog-content-length-range:" + lengthRange},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GCS upload: %w", err)
+	}
+	headers := map[string]string{"x-goog-content-length-range": lengthRange}
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	return &SignedURL{URL: signed, Method: http.MethodPut, Headers: headers, ExpiresAt: expiresAt, Direct: true}, nil
+}
+
+// SignedDownloadURL returns a V4 signed URL for a GET.
+func (s *gcsStorageService) SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error) {
+	expiresAt := time.Now().Add(expires)
+	signed, err := s.client.Bucket(gcsBucketName).SignedURL(storagePath, &storage.SignedURLOptions{
+		Scheme:          storage.SigningSchemeV4,
+		Method:          http.MethodGet,
+		Expires:         expiresAt,
+		QueryParameters: url.Values{"response-content-disposition": {attachmentDisposition(downloadName)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GCS download: %w", err)
+	}
+	return &SignedURL{URL: signed, Method: http.MethodGet, ExpiresAt: expiresAt, Direct: true}, nil
+}
+
 func (s *gcsStorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, error) {
     var files []*pb.FileInfo
     
@@ -136,7 +193,7 @@ func (s *gcsStorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, erro
         
         // Skip directory prefixes (names ending with "/")
         name := attrs.Name
-        if strings.HasSuffix(name, "/") {
+        if strings.HasSuffix(name, "/") || IsDerivedStoragePath(name) {
             continue
         }
         
@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"unicode/utf16"
+)
+
+// The searchable PDF text layer is drawn invisibly (render mode 3), so the font
+// only has to exist: every character maps to one empty glyph that is half an em
+// wide, with ascent = 1 em and descent = 0 so that text selection covers exactly
+// the word box. Embedding it avoids viewers substituting a system font.
+const (
+	glyphlessUnitsPerEm = 1000
+	glyphlessAdvance    = 500
+	glyphlessFontName   = "GlyphLessFont"
+)
+
+var (
+	glyphlessFontOnce sync.Once
+	glyphlessFontData []byte
+)
+
+// glyphlessFont returns the TrueType program of the glyphless font.
+func glyphlessFont() []byte {
+	glyphlessFontOnce.Do(func() {
+		glyphlessFontData = buildGlyphlessFont()
+	})
+	return glyphlessFontData
+}
+
+// buildGlyphlessFont assembles a minimal TrueType font with two empty glyphs
+// (.notdef and the glyph every CID maps to).
+func buildGlyphlessFont() []byte {
+	be := func(values ...any) []byte {
+		var b bytes.Buffer
+		for _, v := range values {
+			binary.Write(&b, binary.BigEndian, v)
+		}
+		return b.Bytes()
+	}
+
+	tables := map[string][]byte{}
+	tables["head"] = be(
+		uint32(0x00010000), // version
+		uint32(0x00010000), // fontRevision
+		uint32(0),          // checkSumAdjustment, patched below
+		uint32(0x5F0F3CF5), // magicNumber
+		uint16(0x000B),     // flags
+		uint16(glyphlessUnitsPerEm),
+		uint64(0), uint64(0), // created, modified
+		int16(0), int16(0), int16(glyphlessAdvance), int16(glyphlessUnitsPerEm), // bbox
+		uint16(0), // macStyle
+		uint16(3), // lowestRecPPEM
+		int16(2),  // fontDirectionHint
+		int16(0),  // indexToLocFormat: short offsets
+		int16(0),  // glyphDataFormat
+	)
+	tables["hhea"] = be(
+		uint32(0x00010000),
+		int16(glyphlessUnitsPerEm), int16(0), int16(0), // ascender, descender, lineGap
+		uint16(glyphlessAdvance),
+		int16(0), int16(0), int16(0), // minLSB, minRSB, xMaxExtent
+		int16(1), int16(0), int16(0), // caret slope rise/run, offset
+		int16(0), int16(0), int16(0), int16(0), // reserved
+		int16(0),  // metricDataFormat
+		uint16(2), // numberOfHMetrics
+	)
+	tables["maxp"] = be(
+		uint32(0x00010000),
+		uint16(2),                                  // numGlyphs
+		uint16(0), uint16(0), uint16(0), uint16(0), // points/contours
+		uint16(2), // maxZones
+		uint16(0), uint16(0), uint16(0), uint16(0), uint16(0), uint16(0), uint16(0), uint16(0),
+	)
+	tables["hmtx"] = be(uint16(glyphlessAdvance), int16(0), uint16(glyphlessAdvance), int16(0))
+	tables["loca"] = be(uint16(0), uint16(0), uint16(0))
+	tables["glyf"] = []byte{}
+	// cmap format 4 with only the terminating segment; CIDs are mapped through
+	// CIDToGIDMap instead.
+	tables["cmap"] = be(
+		uint16(0), uint16(1), // version, numTables
+		uint16(3), uint16(1), uint32(12), // Windows Unicode BMP
+		uint16(4), uint16(24), uint16(0), // format, length, language
+		uint16(2), uint16(2), uint16(0), uint16(0), // segCountX2, searchRange, entrySelector, rangeShift
+		uint16(0xFFFF), uint16(0), uint16(0xFFFF), int16(1), uint16(0),
+	)
+	tables["post"] = be(
+		uint32(0x00030000), uint32(0), // version 3, italicAngle
+		int16(-100), int16(50), // underline position/thickness
+		uint32(0), uint32(0), uint32(0), uint32(0), uint32(0),
+	)
+	tables["name"] = buildNameTable(map[uint16]string{
+		1: glyphlessFontName,
+		2: "Regular",
+		4: glyphlessFontName,
+		6: glyphlessFontName,
+	})
+
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	numTables := uint16(len(tags))
+	entrySelector := uint16(0)
+	for 1<<(entrySelector+1) <= numTables {
+		entrySelector++
+	}
+	searchRange := uint16(16 << entrySelector)
+
+	var font bytes.Buffer
+	font.Write(be(uint32(0x00010000), numTables, searchRange, entrySelector, numTables*16-searchRange))
+	offset := uint32(12 + 16*len(tags))
+	var body bytes.Buffer
+	headOffset := uint32(0)
+	for _, tag := range tags {
+		data := tables[tag]
+		if tag == "head" {
+			headOffset = offset
+		}
+		font.WriteString(tag)
+		font.Write(be(ttfChecksum(data), offset, uint32(len(data))))
+		body.Write(data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+		offset = uint32(12+16*len(tags)) + uint32(body.Len())
+	}
+	font.Write(body.Bytes())
+
+	out := font.Bytes()
+	binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-ttfChecksum(out))
+	return out
+}
+
+// buildNameTable encodes Windows/Unicode name records.
+func buildNameTable(names map[uint16]string) []byte {
+	ids := make([]int, 0, len(names))
+	for id := range names {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	var records, storage bytes.Buffer
+	for _, id := range ids {
+		var encoded []byte
+		for _, u := range utf16.Encode([]rune(names[uint16(id)])) {
+			encoded = binary.BigEndian.AppendUint16(encoded, u)
+		}
+		binary.Write(&records, binary.BigEndian, []uint16{3, 1, 0x0409, uint16(id), uint16(len(encoded)), uint16(storage.Len())})
+		storage.Write(encoded)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint16{0, uint16(len(ids)), uint16(6 + records.Len())})
+	b.Write(records.Bytes())
+	b.Write(storage.Bytes())
+	return b.Bytes()
+}
+
+// ttfChecksum is the TrueType table checksum (sum of big-endian uint32s, zero padded).
+func ttfChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
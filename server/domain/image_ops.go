@@ -0,0 +1,475 @@
+package domain
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// Pure-Go pixel operations used by the preprocessing pipeline. Geometric
+// operations return the inverse mapping (output -> input coordinates) so OCR
+// boxes can be mapped back onto the original image.
+
+// affine is x' = A*x + B*y + C, y' = D*x + E*y + F.
+type affine struct {
+	A, B, C, D, E, F float64
+}
+
+var identityAffine = affine{A: 1, E: 1}
+
+func (m affine) apply(x, y float64) (float64, float64) {
+	return m.A*x + m.B*y + m.C, m.D*x + m.E*y + m.F
+}
+
+// then returns the transform that applies m first and n second.
+func (m affine) then(n affine) affine {
+	return affine{
+		A: n.A*m.A + n.B*m.D,
+		B: n.A*m.B + n.B*m.E,
+		C: n.A*m.C + n.B*m.F + n.C,
+		D: n.D*m.A + n.E*m.D,
+		E: n.D*m.B + n.E*m.E,
+		F: n.D*m.C + n.E*m.F + n.F,
+	}
+}
+
+// mapBox transforms the corners of a box and returns their bounding box.
+func (m affine) mapBox(b BoundingBox) BoundingBox {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]int{{b.X0, b.Y0}, {b.X1, b.Y0}, {b.X0, b.Y1}, {b.X1, b.Y1}} {
+		x, y := m.apply(float64(p[0]), float64(p[1]))
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return BoundingBox{
+		X0: int(math.Floor(minX)),
+		Y0: int(math.Floor(minY)),
+		X1: int(math.Ceil(maxX)),
+		Y1: int(math.Ceil(maxY)),
+	}
+}
+
+// toGray converts an image to 8-bit luminance, flattening transparency onto white.
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok && g.Rect.Min == (image.Point{}) {
+		return g
+	}
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			// Composite over white, then ITU-R BT.601 luma
+			white := 0xffff - a
+			lum := (19595*(r+white) + 38470*(g+white) + 7471*(bl+white) + 1<<15) >> 24
+			gray.Pix[y*gray.Stride+x] = uint8(lum)
+		}
+	}
+	return gray
+}
+
+// toRGBA converts an image to RGBA with a zero origin.
+func toRGBA(img image.Image) *image.RGBA {
+	if r, ok := img.(*image.RGBA); ok && r.Rect.Min == (image.Point{}) {
+		return r
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Over)
+	return rgba
+}
+
+// resample builds a w x h image whose pixel centers are mapped into src by inv
+// and sampled bilinearly; points outside src become white. Gray sources stay gray.
+func resample(src image.Image, w, h int, inv affine) image.Image {
+	if g, ok := src.(*image.Gray); ok {
+		g = toGray(g)
+		dst := image.NewGray(image.Rect(0, 0, w, h))
+		sw, sh := g.Rect.Dx(), g.Rect.Dy()
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				sx, sy := inv.apply(float64(x)+0.5, float64(y)+0.5)
+				dst.Pix[y*dst.Stride+x] = bilinear(g.Pix, g.Stride, 1, 0, sw, sh, sx-0.5, sy-0.5)
+			}
+		}
+		return dst
+	}
+	rgba := toRGBA(src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := rgba.Rect.Dx(), rgba.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := inv.apply(float64(x)+0.5, float64(y)+0.5)
+			i := y*dst.Stride + x*4
+			for c := 0; c < 3; c++ {
+				dst.Pix[i+c] = bilinear(rgba.Pix, rgba.Stride, 4, c, sw, sh, sx-0.5, sy-0.5)
+			}
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// bilinear samples channel c of an interleaved buffer at (x, y) in pixel
+// coordinates, treating everything outside the image as white.
+func bilinear(pix []uint8, stride, channels, c, w, h int, x, y float64) uint8 {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	at := func(px, py int) float64 {
+		if px < 0 || py < 0 || px >= w || py >= h {
+			return 255
+		}
+		return float64(pix[py*stride+px*channels+c])
+	}
+	top := at(x0, y0)*(1-fx) + at(x0+1, y0)*fx
+	bottom := at(x0, y0+1)*(1-fx) + at(x0+1, y0+1)*fx
+	v := top*(1-fy) + bottom*fy
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// rotateImage rotates by angle radians (positive = clockwise on screen) about
+// the center, growing the canvas to fit.
+func rotateImage(img image.Image, angle float64) (image.Image, affine) {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	nw := int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin)))
+	nh := int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos)))
+	cx, cy := w/2, h/2
+	ncx, ncy := float64(nw)/2, float64(nh)/2
+	inv := affine{
+		A: cos, B: sin, C: cx - cos*ncx - sin*ncy,
+		D: -sin, E: cos, F: cy + sin*ncx - cos*ncy,
+	}
+	return resample(img, nw, nh, inv), inv
+}
+
+// rotateQuarterTurns rotates by k * 90 degrees clockwise without interpolation.
+func rotateQuarterTurns(img image.Image, k int) (image.Image, affine) {
+	k = ((k % 4) + 4) % 4
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	var inv affine
+	nw, nh := b.Dx(), b.Dy()
+	switch k {
+	case 0:
+		return img, identityAffine
+	case 1:
+		nw, nh = b.Dy(), b.Dx()
+		inv = affine{A: 0, B: 1, C: 0, D: -1, E: 0, F: h}
+	case 2:
+		inv = affine{A: -1, B: 0, C: w, D: 0, E: -1, F: h}
+	case 3:
+		nw, nh = b.Dy(), b.Dx()
+		inv = affine{A: 0, B: -1, C: w, D: 1, E: 0, F: 0}
+	}
+	// Pixel centers map exactly onto pixel centers, so bilinear sampling is a copy.
+	return resample(img, nw, nh, inv), inv
+}
+
+// scaleImage resizes by factor s.
+func scaleImage(img image.Image, s float64) (image.Image, affine) {
+	b := img.Bounds()
+	nw := int(math.Round(float64(b.Dx()) * s))
+	nh := int(math.Round(float64(b.Dy()) * s))
+	inv := affine{A: 1 / s, E: 1 / s}
+	return resample(img, nw, nh, inv), inv
+}
+
+// medianFilter3 applies a 3x3 median filter (salt-and-pepper noise removal).
+func medianFilter3(g *image.Gray) *image.Gray {
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	var window [9]uint8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					px := min(max(x+dx, 0), w-1)
+					py := min(max(y+dy, 0), h-1)
+					window[n] = g.Pix[py*g.Stride+px]
+					n++
+				}
+			}
+			s := window[:]
+			sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+			dst.Pix[y*dst.Stride+x] = s[4]
+		}
+	}
+	return dst
+}
+
+// sauvolaBinarize thresholds each pixel against T = m * (1 + k*(s/R - 1)) over a
+// square window, using integral images so the cost is independent of window size.
+func sauvolaBinarize(g *image.Gray, window int, k float64) *image.Gray {
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	stride := w + 1
+	sum := make([]float64, stride*(h+1))
+	sq := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSq float64
+		for x := 0; x < w; x++ {
+			v := float64(g.Pix[y*g.Stride+x])
+			rowSum += v
+			rowSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sq[(y+1)*stride+x+1] = sq[y*stride+x+1] + rowSq
+		}
+	}
+	const r = 128.0
+	half := window / 2
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, w)
+			n := float64((x1 - x0) * (y1 - y0))
+			s := sum[y1*stride+x1] - sum[y0*stride+x1] - sum[y1*stride+x0] + sum[y0*stride+x0]
+			s2 := sq[y1*stride+x1] - sq[y0*stride+x1] - sq[y1*stride+x0] + sq[y0*stride+x0]
+			mean := s / n
+			std := math.Sqrt(math.Max(0, s2/n-mean*mean))
+			threshold := mean * (1 + k*(std/r-1))
+			if float64(g.Pix[y*g.Stride+x]) > threshold {
+				dst.Pix[y*dst.Stride+x] = 255
+			}
+		}
+	}
+	return dst
+}
+
+// otsuThreshold returns the global threshold maximizing between-class variance.
+func otsuThreshold(g *image.Gray) uint8 {
+	var hist [256]float64
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for _, v := range g.Pix[y*g.Stride : y*g.Stride+w] {
+			hist[v]++
+		}
+	}
+	total := float64(w * h)
+	var sumAll float64
+	for i, c := range hist {
+		sumAll += float64(i) * c
+	}
+	var sumB, wB, best float64
+	threshold := uint8(128)
+	for i, c := range hist {
+		wB += c
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(i) * c
+		mB := sumB / wB
+		mF := (sumAll - sumB) / wF
+		between := wB * wF * (mB - mF) * (mB - mF)
+		if between > best {
+			best = between
+			threshold = uint8(i)
+		}
+	}
+	return threshold
+}
+
+// inkMask is a downscaled binary view of a page (true = dark) used for
+// orientation and skew analysis.
+type inkMask struct {
+	w, h int
+	ink  []bool
+}
+
+// newInkMask downscales so the longer side is at most maxSide and thresholds with Otsu.
+func newInkMask(img image.Image, maxSide int) *inkMask {
+	g := toGray(img)
+	if long := max(g.Rect.Dx(), g.Rect.Dy()); long > maxSide {
+		scaled, _ := scaleImage(g, float64(maxSide)/float64(long))
+		g = scaled.(*image.Gray)
+	}
+	t := otsuThreshold(g)
+	m := &inkMask{w: g.Rect.Dx(), h: g.Rect.Dy()}
+	m.ink = make([]bool, m.w*m.h)
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			m.ink[y*m.w+x] = g.Pix[y*g.Stride+x] <= t
+		}
+	}
+	return m
+}
+
+// points returns the coordinates of dark pixels; nil when the page is nearly
+// blank or nearly solid (nothing meaningful to measure).
+func (m *inkMask) points() [][2]float64 {
+	var pts [][2]float64
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			if m.ink[y*m.w+x] {
+				pts = append(pts, [2]float64{float64(x), float64(y)})
+			}
+		}
+	}
+	ratio := float64(len(pts)) / float64(max(1, m.w*m.h))
+	if ratio < 0.001 || ratio > 0.5 {
+		return nil
+	}
+	return pts
+}
+
+// projectionScore is the sum of squared bin counts of the dark pixels projected
+// onto the axis perpendicular to text lines tilted by angle; it peaks when the
+// lines are aligned with the bins.
+func projectionScore(pts [][2]float64, angle float64) float64 {
+	sin, cos := math.Sin(angle), math.Cos(angle)
+	bins := map[int]float64{}
+	for _, p := range pts {
+		bins[int(math.Floor(p[1]*cos-p[0]*sin))]++
+	}
+	var score float64
+	for _, c := range bins {
+		score += c * c
+	}
+	return score
+}
+
+// bestProjectionAngle searches +/- maxDegrees for the line angle in radians
+// (positive = lines slope down to the right) and returns it with its score.
+func bestProjectionAngle(pts [][2]float64, maxDegrees float64) (float64, float64) {
+	search := func(from, to, step float64) (float64, float64) {
+		best, bestScore := 0.0, -1.0
+		for deg := from; deg <= to+1e-9; deg += step {
+			if s := projectionScore(pts, deg*math.Pi/180); s > bestScore {
+				best, bestScore = deg, s
+			}
+		}
+		return best, bestScore
+	}
+	coarse, _ := search(-maxDegrees, maxDegrees, 1)
+	fine, score := search(coarse-1, coarse+1, 0.1)
+	return fine * math.Pi / 180, score
+}
+
+// detectSkew estimates the text line angle in radians within +/- maxDegrees.
+func detectSkew(m *inkMask, maxDegrees float64) (float64, bool) {
+	pts := m.points()
+	if pts == nil {
+		return 0, false
+	}
+	angle, _ := bestProjectionAngle(pts, maxDegrees)
+	return angle, true
+}
+
+// projectionProfile returns the dark pixel count per bin across lines tilted by angle.
+func projectionProfile(pts [][2]float64, angle float64) []float64 {
+	sin, cos := math.Sin(angle), math.Cos(angle)
+	lo, hi := math.MaxInt, math.MinInt
+	bins := make([]int, len(pts))
+	for i, p := range pts {
+		bins[i] = int(math.Floor(p[1]*cos - p[0]*sin))
+		lo, hi = min(lo, bins[i]), max(hi, bins[i])
+	}
+	if len(pts) == 0 {
+		return nil
+	}
+	profile := make([]float64, hi-lo+1)
+	for _, b := range bins {
+		profile[b-lo]++
+	}
+	return profile
+}
+
+// rotated returns the mask rotated by k quarter turns clockwise.
+func (m *inkMask) rotated(k int) *inkMask {
+	k = ((k % 4) + 4) % 4
+	if k == 0 {
+		return m
+	}
+	r := &inkMask{w: m.w, h: m.h}
+	if k%2 == 1 {
+		r.w, r.h = m.h, m.w
+	}
+	r.ink = make([]bool, len(m.ink))
+	for y := 0; y < m.h; y++ {
+		for x := 0; x < m.w; x++ {
+			var nx, ny int
+			switch k {
+			case 1:
+				nx, ny = m.h-1-y, x
+			case 2:
+				nx, ny = m.w-1-x, m.h-1-y
+			case 3:
+				nx, ny = y, m.w-1-x
+			}
+			r.ink[ny*r.w+nx] = m.ink[y*m.w+x]
+		}
+	}
+	return r
+}
+
+// looksUpsideDown compares ink above and below the x-height core of each text
+// line. Latin text has more ascenders/capitals than descenders, so much more ink
+// below the core suggests the page is upside down.
+func looksUpsideDown(profile []float64, width int) bool {
+	var above, below float64
+	for y := 0; y < len(profile); {
+		if profile[y] == 0 {
+			y++
+			continue
+		}
+		start := y
+		peak := 0.0
+		for y < len(profile) && profile[y] > 0 {
+			peak = math.Max(peak, profile[y])
+			y++
+		}
+		end := y
+		if end-start < 5 {
+			continue
+		}
+		coreStart, coreEnd := -1, -1
+		for i := start; i < end; i++ {
+			if profile[i] >= peak/2 {
+				if coreStart < 0 {
+					coreStart = i
+				}
+				coreEnd = i
+			}
+		}
+		for i := start; i < coreStart; i++ {
+			above += profile[i]
+		}
+		for i := coreEnd + 1; i < end; i++ {
+			below += profile[i]
+		}
+	}
+	return below > above*1.5 && below-above > float64(width)/10
+}
+
+// detectOrientation returns the clockwise quarter turns needed to make text
+// upright, and whether the page had enough text to decide. Both checks tolerate
+// skew because they project along the best line angle rather than pixel rows.
+func detectOrientation(m *inkMask) (int, bool) {
+	pts := m.points()
+	if pts == nil {
+		return 0, false
+	}
+	k := 0
+	angle, score := bestProjectionAngle(pts, maxDeskewDegrees)
+	// Text lines give a sharply peaked projection; if the page turned a quarter
+	// turn projects much more sharply, its lines run vertically.
+	rotated := m.rotated(1)
+	rotatedPts := rotated.points()
+	if rotatedAngle, rotatedScore := bestProjectionAngle(rotatedPts, maxDeskewDegrees); rotatedScore > 1.2*score {
+		k, m, pts, angle = 1, rotated, rotatedPts, rotatedAngle
+	}
+	if looksUpsideDown(projectionProfile(pts, angle), m.w) {
+		k += 2
+	}
+	return k % 4, true
+}
@@ -0,0 +1,367 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Preprocessing step names usable in a profile spec.
+const (
+	PreprocessOrientation = "orientation"
+	PreprocessDeskew      = "deskew"
+	PreprocessGrayscale   = "grayscale"
+	PreprocessDenoise     = "denoise"
+	PreprocessUpscale     = "upscale"
+	PreprocessBinarize    = "binarize"
+)
+
+// Default per-engine profiles. Tesseract's LSTM models expect clean black text on
+// white at ~300 DPI; EasyOCR runs its own detector on natural images and loses
+// accuracy on binarized input, so it only gets geometric correction.
+var defaultPreprocessProfiles = map[string]string{
+	"tesseract": "grayscale,orientation,deskew,denoise,upscale=300,binarize",
+	"easyocr":   "orientation,deskew,upscale=200",
+}
+
+const (
+	// Pages narrower than this are assumed to be A4/Letter when the source DPI is unknown.
+	assumedPageWidthInches = 8.27
+	maxUpscaleFactor       = 4.0
+	// Skew below this is left alone; resampling costs more sharpness than it gains.
+	minDeskewDegrees = 0.2
+	maxDeskewDegrees = 15.0
+	analysisMaxSide  = 1000
+	sauvolaWindow    = 31
+	sauvolaK         = 0.2
+)
+
+// preprocessStep is one configured step of a profile.
+type preprocessStep struct {
+	name  string
+	param float64
+}
+
+// ImagePreprocessor runs a configurable chain of image corrections before OCR.
+type ImagePreprocessor struct {
+	spec  string
+	steps []preprocessStep
+}
+
+// PreparedImage is the preprocessed image, the steps that actually changed it and
+// the mapping from its coordinates back to the original image.
+type PreparedImage struct {
+	Image          image.Image
+	Steps          []string
+	originalWidth  int
+	originalHeight int
+	toOriginal     affine
+}
+
+// ParsePreprocessProfile parses a comma-separated step list such as
+// "grayscale,deskew,upscale=300,binarize". "" and "none" disable preprocessing.
+func ParsePreprocessProfile(spec string) (*ImagePreprocessor, error) {
+	spec = strings.TrimSpace(spec)
+	p := &ImagePreprocessor{}
+	if spec == "" || strings.EqualFold(spec, "none") {
+		return p, nil
+	}
+	var normalized []string
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(item, "=")
+		step := preprocessStep{name: name}
+		switch name {
+		case PreprocessOrientation, PreprocessDeskew, PreprocessGrayscale, PreprocessDenoise, PreprocessBinarize:
+			if hasValue {
+				return nil, fmt.Errorf("preprocess step %s takes no parameter", name)
+			}
+		case PreprocessUpscale:
+			step.param = 300
+			if hasValue {
+				dpi, err := strconv.ParseFloat(value, 64)
+				if err != nil || dpi <= 0 {
+					return nil, fmt.Errorf("invalid upscale DPI: %s", value)
+				}
+				step.param = dpi
+			}
+		default:
+			return nil, fmt.Errorf("unknown preprocess step: %s", name)
+		}
+		p.steps = append(p.steps, step)
+		normalized = append(normalized, item)
+	}
+	p.spec = strings.Join(normalized, ",")
+	return p, nil
+}
+
+// Spec returns the normalized profile, "" when preprocessing is disabled.
+func (p *ImagePreprocessor) Spec() string {
+	if p == nil {
+		return ""
+	}
+	return p.spec
+}
+
+var (
+	preprocessorsMu sync.Mutex
+	preprocessors   = map[string]*ImagePreprocessor{}
+)
+
+// PreprocessorForEngine returns the profile for an engine. OCR_PREPROCESS_<ENGINE>
+// (e.g. OCR_PREPROCESS_TESSERACT=deskew,binarize or =none) overrides the default.
+// An invalid override is logged and preprocessing is disabled for that engine.
+func PreprocessorForEngine(engineName string) *ImagePreprocessor {
+	preprocessorsMu.Lock()
+	defer preprocessorsMu.Unlock()
+	if p, ok := preprocessors[engineName]; ok {
+		return p
+	}
+	key := "OCR_PREPROCESS_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(engineName))
+	spec, ok := os.LookupEnv(key)
+	if !ok {
+		spec = defaultPreprocessProfiles[engineName]
+	}
+	p, err := ParsePreprocessProfile(spec)
+	if err != nil {
+		log.Printf("Ignoring %s: %v", key, err)
+		p = &ImagePreprocessor{}
+	}
+	preprocessors[engineName] = p
+	return p
+}
+
+// SetDefaultPreprocessProfile sets the profile used for an engine when no
+// OCR_PREPROCESS_<ENGINE> override is present. It is meant for engines declared
+// in configuration rather than code.
+func SetDefaultPreprocessProfile(engineName, spec string) error {
+	if _, err := ParsePreprocessProfile(spec); err != nil {
+		return err
+	}
+	preprocessorsMu.Lock()
+	defer preprocessorsMu.Unlock()
+	defaultPreprocessProfiles[engineName] = spec
+	delete(preprocessors, engineName)
+	return nil
+}
+
+// Run applies the chain to img. sourceDPI is the known resolution of the image
+// (e.g. the PDF render DPI) or 0 when unknown.
+func (p *ImagePreprocessor) Run(ctx context.Context, img image.Image, sourceDPI int) (*PreparedImage, error) {
+	b := img.Bounds()
+	prepared := &PreparedImage{
+		Image:          img,
+		originalWidth:  b.Dx(),
+		originalHeight: b.Dy(),
+		toOriginal:     affine{A: 1, E: 1, C: float64(b.Min.X), F: float64(b.Min.Y)},
+	}
+	if p == nil || len(p.steps) == 0 {
+		return prepared, nil
+	}
+	dpi := float64(sourceDPI)
+	for _, step := range p.steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current := prepared.Image
+		switch step.name {
+		case PreprocessGrayscale:
+			if _, ok := current.(*image.Gray); !ok {
+				prepared.Image = toGray(current)
+				prepared.Steps = append(prepared.Steps, PreprocessGrayscale)
+			}
+		case PreprocessOrientation:
+			if k, ok := detectOrientation(newInkMask(current, analysisMaxSide)); ok && k != 0 {
+				rotated, inv := rotateQuarterTurns(current, k)
+				prepared.transform(rotated, inv)
+				prepared.Steps = append(prepared.Steps, fmt.Sprintf("%s=%d", PreprocessOrientation, k*90))
+			}
+		case PreprocessDeskew:
+			angle, ok := detectSkew(newInkMask(current, analysisMaxSide), maxDeskewDegrees)
+			if degrees := angle * 180 / math.Pi; ok && math.Abs(degrees) >= minDeskewDegrees {
+				rotated, inv := rotateImage(current, -angle)
+				prepared.transform(rotated, inv)
+				prepared.Steps = append(prepared.Steps, fmt.Sprintf("%s=%.1f", PreprocessDeskew, degrees))
+			}
+		case PreprocessDenoise:
+			prepared.Image = medianFilter3(toGray(current))
+			prepared.Steps = append(prepared.Steps, PreprocessDenoise)
+		case PreprocessUpscale:
+			scale := upscaleFactor(current.Bounds().Dx(), dpi, step.param)
+			if scale > 1.05 {
+				scaled, inv := scaleImage(current, scale)
+				prepared.transform(scaled, inv)
+				prepared.Steps = append(prepared.Steps, fmt.Sprintf("%s=%.2fx", PreprocessUpscale, scale))
+				if dpi > 0 {
+					dpi *= scale
+				}
+			}
+		case PreprocessBinarize:
+			prepared.Image = sauvolaBinarize(toGray(current), sauvolaWindow, sauvolaK)
+			prepared.Steps = append(prepared.Steps, PreprocessBinarize)
+		}
+	}
+	return prepared, nil
+}
+
+// upscaleFactor returns the scale needed to reach targetDPI. Without a known
+// source DPI the image is assumed to span a full page width.
+func upscaleFactor(width int, sourceDPI, targetDPI float64) float64 {
+	if width <= 0 {
+		return 1
+	}
+	if sourceDPI <= 0 {
+		sourceDPI = float64(width) / assumedPageWidthInches
+	}
+	return math.Min(targetDPI/sourceDPI, maxUpscaleFactor)
+}
+
+// transform replaces the image with the output of a geometric step whose inverse
+// mapping is inv.
+func (p *PreparedImage) transform(img image.Image, inv affine) {
+	p.Image = img
+	p.toOriginal = inv.then(p.toOriginal)
+}
+
+// MapLayout converts a layout computed on the prepared image back to the original
+// image's coordinates, so stored boxes line up with the uploaded file.
+func (p *PreparedImage) MapLayout(layout *OCRPageLayout) *OCRPageLayout {
+	if layout == nil {
+		return nil
+	}
+	mapped := &OCRPageLayout{Width: p.originalWidth, Height: p.originalHeight}
+	for _, block := range layout.Blocks {
+		var nb OCRBlock
+		for _, line := range block.Lines {
+			var nl OCRLine
+			for _, w := range line.Words {
+				box := p.toOriginal.mapBox(w.BBox)
+				box.X0 = min(max(box.X0, 0), p.originalWidth)
+				box.X1 = min(max(box.X1, 0), p.originalWidth)
+				box.Y0 = min(max(box.Y0, 0), p.originalHeight)
+				box.Y1 = min(max(box.Y1, 0), p.originalHeight)
+				nl.Words = append(nl.Words, OCRWord{Text: w.Text, BBox: box, Confidence: w.Confidence})
+			}
+			nb.Lines = append(nb.Lines, nl)
+		}
+		mapped.Blocks = append(mapped.Blocks, nb)
+	}
+	mapped.recomputeBoxes()
+	return mapped
+}
+
+// pageRecognition is the OCR output for one page image.
+type pageRecognition struct {
+	Text            string
+	Confidence      float64
+	Layout          *OCRPageLayout
+	PreprocessSteps []string
+}
+
+// recognizeImage runs the engine's preprocessing profile on img and OCRs the
+// result. Layout boxes are mapped back to img's coordinates.
+func recognizeImage(ctx context.Context, engine OCREngine, img image.Image, sourceDPI int) (*pageRecognition, error) {
+	prepared, err := PreprocessorForEngine(engine.Name()).Run(ctx, img, sourceDPI)
+	if err != nil {
+		return nil, fmt.Errorf("preprocessing failed: %w", err)
+	}
+	rec := &pageRecognition{PreprocessSteps: prepared.Steps}
+	if layoutEngine, ok := engine.(LayoutOCREngine); ok {
+		text, confidence, layout, err := layoutEngine.ProcessImageWithLayout(ctx, prepared.Image)
+		if err != nil {
+			return nil, err
+		}
+		rec.Text, rec.Confidence, rec.Layout = text, confidence, prepared.MapLayout(layout)
+		return rec, nil
+	}
+	text, confidence, err := engine.ProcessImage(ctx, prepared.Image)
+	if err != nil {
+		return nil, err
+	}
+	rec.Text, rec.Confidence = text, confidence
+	return rec, nil
+}
+
+// BatchOCREngine is implemented by engines that amortize per-call overhead by
+// recognizing several page images in one request.
+type BatchOCREngine interface {
+	LayoutOCREngine
+
+	// BatchSize is the preferred number of images per call.
+	BatchSize() int
+
+	// ProcessImagesWithLayout OCRs the images as given. The error applies to the
+	// whole batch; per-image failures are reported in the outputs.
+	ProcessImagesWithLayout(ctx context.Context, imgs []image.Image) ([]ImageOCROutput, error)
+}
+
+// ImageOCROutput is the recognition of one image in a batch.
+type ImageOCROutput struct {
+	Text       string
+	Confidence float64
+	Layout     *OCRPageLayout
+	Err        error
+}
+
+// recognizeImages is recognizeImage for a sequence of pages. Batch-capable
+// engines get one call per BatchSize pages, so only one batch of preprocessed
+// images is held in memory at a time. The i-th error belongs to the i-th page.
+func recognizeImages(ctx context.Context, engine OCREngine, imgs []image.Image, sourceDPI int) ([]*pageRecognition, []error) {
+	recs := make([]*pageRecognition, len(imgs))
+	errs := make([]error, len(imgs))
+	batchEngine, ok := engine.(BatchOCREngine)
+	if !ok {
+		for i, img := range imgs {
+			recs[i], errs[i] = recognizeImage(ctx, engine, img, sourceDPI)
+		}
+		return recs, errs
+	}
+
+	pre := PreprocessorForEngine(engine.Name())
+	size := max(batchEngine.BatchSize(), 1)
+	for start := 0; start < len(imgs); start += size {
+		end := min(start+size, len(imgs))
+		var prepared []*PreparedImage
+		var indexes []int
+		var batch []image.Image
+		for i := start; i < end; i++ {
+			p, err := pre.Run(ctx, imgs[i], sourceDPI)
+			if err != nil {
+				errs[i] = fmt.Errorf("preprocessing failed: %w", err)
+				continue
+			}
+			prepared = append(prepared, p)
+			indexes = append(indexes, i)
+			batch = append(batch, p.Image)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		outputs, err := batchEngine.ProcessImagesWithLayout(ctx, batch)
+		for j, i := range indexes {
+			switch {
+			case err != nil:
+				errs[i] = err
+			case outputs[j].Err != nil:
+				errs[i] = outputs[j].Err
+			default:
+				recs[i] = &pageRecognition{
+					Text:            outputs[j].Text,
+					Confidence:      outputs[j].Confidence,
+					Layout:          prepared[j].MapLayout(outputs[j].Layout),
+					PreprocessSteps: prepared[j].Steps,
+				}
+			}
+		}
+	}
+	return recs, errs
+}
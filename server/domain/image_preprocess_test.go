@@ -0,0 +1,176 @@
+package domain
+
+import (
+	"context"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePreprocessProfile(t *testing.T) {
+	for _, tt := range []struct {
+		spec, want, wantErr string
+	}{
+		{"grayscale,deskew,upscale=300,binarize", "grayscale,deskew,upscale=300,binarize", ""},
+		{" Grayscale , , DESKEW ", "grayscale,deskew", ""},
+		{"upscale", "upscale", ""},
+		{"none", "", ""},
+		{"", "", ""},
+		{"deskew=5", "", "takes no parameter"},
+		{"upscale=0", "", "invalid upscale DPI"},
+		{"upscale=high", "", "invalid upscale DPI"},
+		{"sharpen", "", "unknown preprocess step"},
+	} {
+		p, err := ParsePreprocessProfile(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePreprocessProfile(%q) err = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || p.Spec() != tt.want {
+			t.Errorf("ParsePreprocessProfile(%q) = %q, %v; want %q", tt.spec, p.Spec(), err, tt.want)
+		}
+	}
+}
+
+// skewedScan is a printed page at 150 DPI, in color and rotated by degrees.
+func skewedScan(degrees float64) image.Image {
+	g := newPage(800, 1000)
+	printLines(g, "Hold the bolt", "THE doll hotel", "both lotto holds", "LIFT the told hut", "bold hot dolt")
+	rotated, _ := rotateImage(toRGBA(g), degrees*math.Pi/180)
+	return rotated
+}
+
+func TestPreprocessProfileSteps(t *testing.T) {
+	ctx := context.Background()
+	scan := skewedScan(3)
+	for _, tt := range []struct {
+		name  string
+		spec  string
+		steps []string // "deskew" stands for any deskew=<angle> step
+		gray  bool
+		scale float64
+	}{
+		{"tesseract", defaultPreprocessProfiles["tesseract"], []string{"grayscale", "deskew", "denoise", "upscale=2.00x", "binarize"}, true, 2},
+		{"easyocr", defaultPreprocessProfiles["easyocr"], []string{"deskew", "upscale=1.33x"}, false, 4.0 / 3},
+		// Steps that would not change the image are left out
+		{"already at the target DPI", "grayscale,grayscale,upscale=150", []string{"grayscale"}, true, 1},
+		{"none", "none", nil, false, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePreprocessProfile(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prepared, err := p.Run(ctx, scan, 150)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prepared.Steps) != len(tt.steps) {
+				t.Fatalf("steps = %v, want %v", prepared.Steps, tt.steps)
+			}
+			for i, step := range prepared.Steps {
+				if tt.steps[i] != "deskew" {
+					if step != tt.steps[i] {
+						t.Fatalf("steps = %v, want %v", prepared.Steps, tt.steps)
+					}
+					continue
+				}
+				angle, err := strconv.ParseFloat(strings.TrimPrefix(step, "deskew="), 64)
+				if err != nil || math.Abs(angle-3) > 0.5 {
+					t.Fatalf("step %q, want a deskew of about 3 degrees", step)
+				}
+			}
+			if _, gray := prepared.Image.(*image.Gray); gray != tt.gray {
+				t.Fatalf("prepared image is %T", prepared.Image)
+			}
+			// Deskewing grows the canvas a little more
+			b, want := prepared.Image.Bounds(), float64(scan.Bounds().Dx())*tt.scale
+			if float64(b.Dx()) < want-2 || float64(b.Dx()) > want*1.1 {
+				t.Fatalf("prepared width %d, want about %.0f", b.Dx(), want)
+			}
+			if strings.Contains(tt.spec, "binarize") {
+				for _, v := range prepared.Image.(*image.Gray).Pix {
+					if v != 0 && v != 255 {
+						t.Fatalf("binarized image has gray level %d", v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPreprocessOrientation(t *testing.T) {
+	// Ascenders tell which way is up
+	upright := skewedScan(0)
+	for _, tt := range []struct {
+		turns int
+		step  string
+	}{
+		{0, ""},
+		{1, "orientation=270"},
+		{2, "orientation=180"},
+		{3, "orientation=90"},
+	} {
+		turned, _ := rotateQuarterTurns(upright, tt.turns)
+		p, _ := ParsePreprocessProfile("orientation")
+		prepared, err := p.Run(context.Background(), turned, 150)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(prepared.Steps, ","); got != tt.step {
+			t.Errorf("page turned %d times: steps %q, want %q", tt.turns, got, tt.step)
+		}
+		if b := prepared.Image.Bounds(); b.Dx() != upright.Bounds().Dx() {
+			t.Errorf("page turned %d times: prepared %dx%d", tt.turns, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestPreparedImageMapLayout(t *testing.T) {
+	p, err := ParsePreprocessProfile("upscale=300")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := p.Run(context.Background(), newPage(400, 500), 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := prepared.MapLayout(&OCRPageLayout{Width: 800, Height: 1000, Blocks: []OCRBlock{{Lines: []OCRLine{{Words: []OCRWord{
+		{Text: "Total", BBox: BoundingBox{X0: 200, Y0: 100, X1: 400, Y1: 140}},
+		{Text: "edge", BBox: BoundingBox{X0: 780, Y0: 980, X1: 820, Y1: 1020}},
+	}}}}}})
+	words := layout.Words()
+	if layout.Width != 400 || layout.Height != 500 || len(words) != 2 {
+		t.Fatalf("layout %dx%d with %d words", layout.Width, layout.Height, len(words))
+	}
+	// Boxes are scaled back and clipped to the original image
+	if words[0].BBox != (BoundingBox{X0: 100, Y0: 50, X1: 200, Y1: 70}) || words[1].BBox != (BoundingBox{X0: 390, Y0: 490, X1: 400, Y1: 500}) {
+		t.Fatalf("boxes = %v and %v", words[0].BBox, words[1].BBox)
+	}
+}
+
+func TestPreprocessorForEngine(t *testing.T) {
+	t.Setenv("OCR_PREPROCESS_TEST_ENGINE_A", "deskew,binarize")
+	t.Setenv("OCR_PREPROCESS_TEST_ENGINE_B", "sharpen")
+	if got := PreprocessorForEngine("test-engine.a").Spec(); got != "deskew,binarize" {
+		t.Errorf("override = %q", got)
+	}
+	// An invalid override disables preprocessing
+	if got := PreprocessorForEngine("test-engine.b").Spec(); got != "" {
+		t.Errorf("invalid override = %q, want none", got)
+	}
+	if err := SetDefaultPreprocessProfile("test-engine-c", "upscale=200"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(defaultPreprocessProfiles, "test-engine-c") })
+	if got := PreprocessorForEngine("test-engine-c").Spec(); got != "upscale=200" {
+		t.Errorf("configured default = %q", got)
+	}
+	if err := SetDefaultPreprocessProfile("test-engine-c", "upscale=-1"); err == nil {
+		t.Error("SetDefaultPreprocessProfile accepted an invalid profile")
+	}
+}
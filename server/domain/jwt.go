@@ -0,0 +1,439 @@
+package domain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRSAKeyBits is the smallest RSA modulus a JWKS may hold.
+const minRSAKeyBits = 2048
+
+// JWT verification errors. Callers map them to Unauthenticated; the message
+// tells the client what to fix.
+var (
+	ErrTokenMalformed   = errors.New("malformed token")
+	ErrTokenSignature   = errors.New("invalid token signature")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrTokenClaims      = errors.New("invalid token claims")
+)
+
+// jwtHeader is the JOSE header of a signed JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtKey is a verification key. HMAC keys hold secret, the others public.
+type jwtKey struct {
+	kid    string
+	alg    string // restricts the key to one algorithm; "" allows any of its family
+	secret []byte
+	public crypto.PublicKey
+}
+
+// jwtAlgorithms maps the supported "alg" values to their hash.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// accepts reports whether the key can verify a signature made with alg.
+func (k *jwtKey) accepts(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch alg[:2] {
+	case "HS":
+		return k.secret != nil
+	case "RS", "PS":
+		_, ok := k.public.(*rsa.PublicKey)
+		return ok
+	case "ES":
+		pub, ok := k.public.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		// ES256 is defined for P-256 only, and so on.
+		size := map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}[alg]
+		return pub.Curve.Params().BitSize == size
+	}
+	return false
+}
+
+// verify checks the signature over signingInput.
+func (k *jwtKey) verify(alg string, signingInput string, signature []byte) bool {
+	h := jwtAlgorithms[alg]
+	switch alg[:2] {
+	case "HS":
+		var newHash func() hash.Hash
+		switch h {
+		case crypto.SHA384:
+			newHash = sha512.New384
+		case crypto.SHA512:
+			newHash = sha512.New
+		default:
+			newHash = sha256.New
+		}
+		mac := hmac.New(newHash, k.secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	hasher := h.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+	switch alg[:2] {
+	case "RS":
+		return rsa.VerifyPKCS1v15(k.public.(*rsa.PublicKey), h, digest, signature) == nil
+	case "PS":
+		return rsa.VerifyPSS(k.public.(*rsa.PublicKey), h, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	case "ES":
+		// JWS ECDSA signatures are r||s, each padded to the curve size.
+		pub := k.public.(*ecdsa.PublicKey)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+// jwk is one key of a JWKS document.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the signature keys of a JWKS document ({"keys": [...]});
+// encryption keys are skipped.
+func parseJWKS(data []byte) ([]*jwtKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	var keys []*jwtKey
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key := &jwtKey{kid: k.Kid, alg: k.Alg}
+		var err error
+		switch k.Kty {
+		case "oct":
+			key.secret, err = base64.RawURLEncoding.DecodeString(k.K)
+		case "RSA":
+			key.public, err = parseRSAJWK(k)
+		case "EC":
+			key.public, err = parseECJWK(k)
+		default:
+			err = fmt.Errorf("unsupported key type %q", k.Kty)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d (%s): %w", i, k.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	exponent := 0
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	if bits := pub.N.BitLen(); bits < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key of %d bits is too short (want at least %d)", bits, minRSAKeyBits)
+	}
+	return pub, nil
+}
+
+func parseECJWK(k jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+	}
+	return pub, nil
+}
+
+// JWTVerifier verifies signed JWTs against an HMAC secret and/or the keys of
+// a local JWKS file. The file is read again when a token names a key ID it
+// does not know and the file has changed, so keys can be rotated in place.
+type JWTVerifier struct {
+	Issuer    string        // required "iss" when set
+	Audience  string        // must be in "aud" when set
+	ClockSkew time.Duration // tolerance for exp, nbf and iat
+
+	hmacKey  *jwtKey
+	jwksPath string
+
+	mu       sync.Mutex
+	jwks     []*jwtKey
+	jwksTime time.Time
+}
+
+// NewJWTVerifier creates a verifier. At least one of hmacSecret and jwksPath
+// must be set.
+func NewJWTVerifier(hmacSecret []byte, jwksPath string) (*JWTVerifier, error) {
+	if len(hmacSecret) == 0 && jwksPath == "" {
+		return nil, fmt.Errorf("an HMAC secret or a JWKS file is required")
+	}
+	v := &JWTVerifier{jwksPath: jwksPath, ClockSkew: time.Minute}
+	if len(hmacSecret) > 0 {
+		if len(hmacSecret) < 32 {
+			return nil, fmt.Errorf("HMAC secret must be at least 32 bytes")
+		}
+		v.hmacKey = &jwtKey{secret: hmacSecret}
+	}
+	if jwksPath != "" {
+		if _, err := v.reloadJWKS(true); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// reloadJWKS reads the JWKS file when it changed since the last read (or
+// always when force is set). It reports whether the keys were replaced.
+func (v *JWTVerifier) reloadJWKS(force bool) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	info, err := os.Stat(v.jwksPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	if !force && !info.ModTime().After(v.jwksTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(v.jwksPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return false, err
+	}
+	v.jwks, v.jwksTime = keys, info.ModTime()
+	return true, nil
+}
+
+// candidateKeys returns the keys that may have signed a token.
+func (v *JWTVerifier) candidateKeys(header jwtHeader) []*jwtKey {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var keys []*jwtKey
+	if v.hmacKey != nil && header.Kid == "" && v.hmacKey.accepts(header.Alg) {
+		keys = append(keys, v.hmacKey)
+	}
+	for _, k := range v.jwks {
+		if (header.Kid == "" || k.kid == header.Kid) && k.accepts(header.Alg) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Verify checks a compact JWS token and returns its claims.
+func (v *JWTVerifier) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if _, ok := jwtAlgorithms[header.Alg]; !ok {
+		// Also rejects "none"
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrTokenSignature, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	keys := v.candidateKeys(header)
+	if len(keys) == 0 && header.Kid != "" && v.jwksPath != "" {
+		if reloaded, err := v.reloadJWKS(false); err != nil {
+			return nil, err
+		} else if reloaded {
+			keys = v.candidateKeys(header)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key for kid %q and alg %s", ErrTokenSignature, header.Kid, header.Alg)
+	}
+	signingInput := parts[0] + "." + parts[1]
+	verified := false
+	for _, k := range keys {
+		if k.verify(header.Alg, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenSignature
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := v.validateClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks the registered claims; exp is required.
+func (v *JWTVerifier) validateClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericDateClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("%w: exp is required", ErrTokenClaims)
+	}
+	if !now.Before(exp.Add(v.ClockSkew)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := numericDateClaim(claims, "nbf"); ok && now.Add(v.ClockSkew).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+	if iat, ok := numericDateClaim(claims, "iat"); ok && now.Add(v.ClockSkew).Before(iat) {
+		return fmt.Errorf("%w: issued in the future", ErrTokenNotYetValid)
+	}
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return fmt.Errorf("%w: unexpected issuer %q", ErrTokenClaims, iss)
+		}
+	}
+	if v.Audience != "" && !audienceMatches(claims["aud"], v.Audience) {
+		return fmt.Errorf("%w: audience %q not accepted", ErrTokenClaims, v.Audience)
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// numericDateClaim reads a NumericDate claim (seconds, possibly fractional).
+func numericDateClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec := int64(value)
+	return time.Unix(sec, int64((value-float64(sec))*1e9)), true
+}
+
+// audienceMatches reports whether an "aud" claim, a string or an array of
+// strings, names audience exactly.
+func audienceMatches(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringListClaim reads a claim that is a string or an array of strings. A
+// string holding several values separated by spaces (as "scope") is split.
+func stringListClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var out []string
+		for _, item := range value {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// SignHS256 creates an HS256 token with the given claims. It is used to mint
+// development tokens.
+func SignHS256(secret []byte, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signTestToken signs claims under header; key is an HMAC secret, an
+// *rsa.PrivateKey, an *ecdsa.PrivateKey (P-256) or nil for no signature.
+func signTestToken(t *testing.T, header jwtHeader, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(signature)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := writeJWKS(t,
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		map[string]string{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	)
+	verifier, err := NewJWTVerifier(testHMACSecret, jwks)
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	verifier.Issuer = "https://issuer.example"
+	verifier.Audience = "api"
+
+	now := time.Unix(1_800_000_000, 0)
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "https://issuer.example", "aud": "api", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	// The RSA public key, as an attacker would use it as an HMAC secret
+	rsaPublicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	for _, tt := range []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"HS256", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(nil), testHMACSecret), nil},
+		{"RS256 by kid", signTestToken(t, jwtHeader{Alg: "RS256", Kid: "rsa-1"}, claims(nil), rsaKey), nil},
+		{"ES256 by kid", signTestToken(t, jwtHeader{Alg: "ES256", Kid: "ec-1"}, claims(nil), ecKey), nil},
+		{"RS256 without kid", signTestToken(t, jwtHeader{Alg: "RS256"}, claims(nil), rsaKey), nil},
+		{"alg none", signTestToken(t, jwtHeader{Alg: "none"}, claims(nil), nil), ErrTokenSignature},
+		{"HS256 with the RSA public key", signTestToken(t, jwtHeader{Alg: "HS256", Kid: "rsa-1"}, claims(nil), rsaPublicDER), ErrTokenSignature},
+		{"RS256 under the EC kid", signTestToken(t, jwtHeader{Alg: "RS256", Kid: "ec-1"}, claims(nil), rsaKey), ErrTokenSignature},
+		{"ES384 with a P-256 key", signTestToken(t, jwtHeader{Alg: "ES384", Kid: "ec-1"}, claims(nil), ecKey), ErrTokenSignature},
+		{"wrong HMAC secret", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(nil), []byte("another secret of at least 32 bytes")), ErrTokenSignature},
+		{"unknown kid", signTestToken(t, jwtHeader{Alg: "RS256", Kid: "rsa-2"}, claims(nil), rsaKey), ErrTokenSignature},
+		{"malformed", "not.a-token", ErrTokenMalformed},
+		{"expired", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), testHMACSecret), ErrTokenExpired},
+		{"expired within the clock skew", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), testHMACSecret), nil},
+		{"without exp", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"exp": nil}), testHMACSecret), ErrTokenClaims},
+		{"not yet valid", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()}), testHMACSecret), ErrTokenNotYetValid},
+		{"issued in the future", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"iat": now.Add(2 * time.Minute).Unix()}), testHMACSecret), ErrTokenNotYetValid},
+		{"wrong issuer", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"iss": "https://other.example"}), testHMACSecret), ErrTokenClaims},
+		{"audience in an array", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"aud": []string{"web", "api"}}), testHMACSecret), nil},
+		{"wrong audience", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"aud": "web"}), testHMACSecret), ErrTokenClaims},
+		{"audience in a space-separated string", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"aud": "web api"}), testHMACSecret), ErrTokenClaims},
+		{"without audience", signTestToken(t, jwtHeader{Alg: "HS256"}, claims(map[string]interface{}{"aud": nil}), testHMACSecret), ErrTokenClaims},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(tt.token, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got["sub"] != "alice" {
+				t.Fatalf("claims = %v, want sub alice", got)
+			}
+		})
+	}
+}
+
+func TestParseJWKSRejectsShortRSAKeys(t *testing.T) {
+	for _, tt := range []struct {
+		bits int
+		ok   bool
+	}{
+		{1024, false},
+		{2048, true},
+	} {
+		key, err := rsa.GenerateKey(rand.Reader, tt.bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{rsaJWK("k", &key.PublicKey)}})
+		if _, err := parseJWKS(data); (err == nil) != tt.ok {
+			t.Errorf("%d-bit key: err = %v, want accepted %v", tt.bits, err, tt.ok)
+		}
+	}
+}
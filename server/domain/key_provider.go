@@ -0,0 +1,262 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyfileCheckInterval is how often the keyfile is checked for changes, so
+// every service picks up a rotation without a restart.
+const keyfileCheckInterval = 2 * time.Second
+
+// keyfile is the JSON form of a local keyfile:
+//
+//	{"active": "k-20261018-1a2b3c4d", "keys": {"k-20261018-1a2b3c4d": "<base64 32 bytes>"}}
+//
+// Older keys stay in the file so objects wrapped with them can be read.
+type keyfile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// localKeyProvider wraps data keys with AES-256-GCM master keys read from a
+// keyfile.
+type localKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	active  string
+	keys    map[string][]byte
+	modTime time.Time
+	checked time.Time
+}
+
+// KeyProviderFromEnv configures storage encryption:
+//
+//   - STORAGE_ENCRYPTION: true enables it (default off; returns nil)
+//   - STORAGE_KEY_PROVIDER: local (default), the only provider so far
+//   - STORAGE_KEYFILE: keyfile of the local provider (default
+//     /app/keys/storage-keys.json); a missing file is created with a new key
+func KeyProviderFromEnv() (KeyProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_ENCRYPTION"))) {
+	case "true", "1", "on", "yes":
+	default:
+		return nil, nil
+	}
+	switch provider := os.Getenv("STORAGE_KEY_PROVIDER"); provider {
+	case "", "local":
+		return NewLocalKeyProvider(StorageKeyfile(), true)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_KEY_PROVIDER %q (want local)", provider)
+	}
+}
+
+// StorageKeyfile returns the path of the local keyfile (STORAGE_KEYFILE).
+func StorageKeyfile() string {
+	if path := os.Getenv("STORAGE_KEYFILE"); path != "" {
+		return path
+	}
+	return "/app/keys/storage-keys.json"
+}
+
+// NewLocalKeyProvider loads the keyfile at path. With create, a missing
+// keyfile is created with a new key; concurrent creators agree on one file.
+func NewLocalKeyProvider(path string, create bool) (KeyProvider, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && create {
+		if err := createKeyfile(path); err != nil {
+			return nil, err
+		}
+	}
+	p := &localKeyProvider{path: path}
+	if err := p.reload(time.Now(), true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newMasterKey returns a new key and its ID.
+func newMasterKey() (string, string, error) {
+	key := make([]byte, dataKeySize)
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", err
+	}
+	id := "k-" + time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix)
+	return id, base64.StdEncoding.EncodeToString(key), nil
+}
+
+func createKeyfile(path string) error {
+	id, key, err := newMasterKey()
+	if err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(keyfile{Active: id, Keys: map[string]string{id: key}}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create keyfile directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyfile-*")
+	if err != nil {
+		return fmt.Errorf("failed to create keyfile: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Link fails if another service created the keyfile first; use theirs
+	if err := os.Link(tmp.Name(), path); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create keyfile: %w", err)
+	} else if err == nil {
+		log.Printf("Created storage keyfile %s (key %s)", path, id)
+	}
+	return nil
+}
+
+// RotateLocalKey adds a new key to the keyfile at path and makes it the
+// active key. It returns the new key ID.
+func RotateLocalKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	var kf keyfile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return "", fmt.Errorf("failed to parse keyfile %s: %w", path, err)
+	}
+	id, key, err := newMasterKey()
+	if err != nil {
+		return "", err
+	}
+	if kf.Keys == nil {
+		kf.Keys = map[string]string{}
+	}
+	kf.Keys[id], kf.Active = key, id
+	data, _ = json.MarshalIndent(kf, "", "  ")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to replace keyfile: %w", err)
+	}
+	return id, nil
+}
+
+// reload re-reads the keyfile when it changed. A broken file keeps the
+// previous keys.
+func (p *localKeyProvider) reload(now time.Time, force bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !force && now.Sub(p.checked) < keyfileCheckInterval {
+		return nil
+	}
+	p.checked = now
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if !force && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+	p.modTime = info.ModTime()
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	var kf keyfile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return fmt.Errorf("failed to parse keyfile %s: %w", p.path, err)
+	}
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != dataKeySize {
+			return fmt.Errorf("keyfile %s: key %s is not 32 base64-encoded bytes", p.path, id)
+		}
+		if len(id) > 255 {
+			return fmt.Errorf("keyfile %s: key ID %.20s... is too long", p.path, id)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[kf.Active]; !ok {
+		return fmt.Errorf("keyfile %s: active key %q is not in keys", p.path, kf.Active)
+	}
+	if !force && kf.Active != p.active {
+		log.Printf("Storage encryption: active key is now %s", kf.Active)
+	}
+	p.active, p.keys = kf.Active, keys
+	return nil
+}
+
+// current returns the keys, reloading the keyfile if it changed.
+func (p *localKeyProvider) current() (string, map[string][]byte) {
+	if err := p.reload(time.Now(), false); err != nil {
+		log.Printf("Storage encryption: keeping the previous keys: %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active, p.keys
+}
+
+func (p *localKeyProvider) ActiveKeyID() string {
+	active, _ := p.current()
+	return active
+}
+
+func (p *localKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	active, keys := p.current()
+	gcm, err := newGCM(keys[active])
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	// The key ID is authenticated, so a wrapped key cannot be relabeled
+	return active, gcm.Seal(nonce, nonce, dataKey, []byte(active)), nil
+}
+
+func (p *localKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	_, keys := p.current()
+	key, ok := keys[keyID]
+	if !ok {
+		// Another service may have rotated the key moments ago
+		if err := p.reload(time.Now(), true); err != nil {
+			return nil, err
+		}
+		_, keys = p.current()
+		if key, ok = keys[keyID]; !ok {
+			return nil, fmt.Errorf("master key %q is not in the keyfile", keyID)
+		}
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("data key does not unwrap with master key %q", keyID)
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,294 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CascadeEngineName is the virtual engine that runs a CascadeStrategy. Add it
+// to OCR_ENGINES to store the cascaded result next to the engine results.
+const CascadeEngineName = "cascade"
+
+// CascadeStage is one engine of a cascade. Pages read with a confidence below
+// MinConfidence are sent to the next stage; the last stage has no threshold.
+type CascadeStage struct {
+	EngineName    string
+	MinConfidence float64
+}
+
+// CascadeStrategy runs a cheap engine on every page and slower engines only on
+// the pages the previous stage was unsure about. Each page keeps the most
+// confident reading.
+type CascadeStrategy struct {
+	Stages []CascadeStage
+}
+
+// ParseCascadeStrategy parses a comma-separated list of engine:threshold
+// stages, the last without a threshold, e.g. "tesseract:0.8,easyocr".
+func ParseCascadeStrategy(spec string) (*CascadeStrategy, error) {
+	strategy := &CascadeStrategy{}
+	var thresholds []bool
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		stage := CascadeStage{EngineName: part}
+		name, threshold, hasThreshold := strings.Cut(part, ":")
+		if hasThreshold {
+			value, err := strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+			if err != nil || value < 0 || value > 1 {
+				return nil, fmt.Errorf("cascade stage %q: threshold must be a number between 0 and 1", part)
+			}
+			stage = CascadeStage{EngineName: strings.TrimSpace(name), MinConfidence: value}
+		}
+		if stage.EngineName == "" || stage.EngineName == CascadeEngineName || stage.EngineName == EnsembleEngineName {
+			return nil, fmt.Errorf("cascade stage %q: invalid engine name", part)
+		}
+		strategy.Stages = append(strategy.Stages, stage)
+		thresholds = append(thresholds, hasThreshold)
+	}
+	if len(strategy.Stages) < 2 {
+		return nil, fmt.Errorf("cascade needs at least two stages, got %q", spec)
+	}
+	last := len(strategy.Stages) - 1
+	for i, stage := range strategy.Stages {
+		if i < last && !thresholds[i] {
+			return nil, fmt.Errorf("cascade stage %q needs a confidence threshold", stage.EngineName)
+		}
+		if i == last && thresholds[i] {
+			return nil, fmt.Errorf("last cascade stage %q has no engine to fall back to", stage.EngineName)
+		}
+	}
+	return strategy, nil
+}
+
+// CascadeStrategyFromEnv reads the strategy from OCR_CASCADE; nil when unset.
+func CascadeStrategyFromEnv() (*CascadeStrategy, error) {
+	spec := strings.TrimSpace(os.Getenv("OCR_CASCADE"))
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseCascadeStrategy(spec)
+}
+
+// String formats the strategy as accepted by ParseCascadeStrategy.
+func (c *CascadeStrategy) String() string {
+	parts := make([]string, len(c.Stages))
+	for i, stage := range c.Stages {
+		parts[i] = stage.EngineName
+		if i < len(c.Stages)-1 {
+			parts[i] += ":" + strconv.FormatFloat(stage.MinConfidence, 'f', -1, 64)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// processCascade runs the stages of strategy on a document. Results of engines
+// that already processed the document are reused. A stage that fails on the
+// whole document hands it to the next stage.
+func (s *ocrService) processCascade(ctx context.Context, strategy *CascadeStrategy, pages *documentPages, done map[string]*OCRResult) *OCRResult {
+	filename := pages.filename
+	result := &OCRResult{
+		Filename:    filename,
+		EngineName:  CascadeEngineName,
+		Status:      "failed",
+		ProcessedAt: time.Now(),
+	}
+	used := map[string]bool{}
+	var lastErr error
+	var base *OCRResult
+
+	for i, stage := range strategy.Stages {
+		engine := s.GetEngine(stage.EngineName)
+		if engine == nil {
+			log.Printf("Cascade: engine %s is not registered, skipping stage", stage.EngineName)
+			continue
+		}
+		stageResult := done[stage.EngineName]
+		if stageResult == nil {
+			stageResult = s.runEngine(ctx, engine, pages)
+		}
+		if stageResult.Status == "failed" || stageResult.Error != nil {
+			lastErr = stageResult.Error
+			log.Printf("Cascade: %s failed on %s, trying the next stage: %v", stage.EngineName, filename, stageResult.Error)
+			continue
+		}
+
+		base = stageResult
+		result.Pages = append([]OCRPage(nil), base.Pages...)
+		result.Languages = base.Languages
+		result.DetectedLanguage = base.DetectedLanguage
+		result.PreprocessProfile = base.PreprocessProfile
+		used[stage.EngineName] = true
+		s.cascadeFallback(ctx, strategy, i, result, pages, used)
+		break
+	}
+	if base == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no cascade stage engine is registered")
+		}
+		result.Error = lastErr
+		return result
+	}
+
+	result.Status = "completed"
+	result.ExtractedText = base.ExtractedText
+	if len(used) > 1 {
+		result.ExtractedText = joinPageTexts(result.Pages, pageMarkerPattern.MatchString(base.ExtractedText))
+	}
+	var total float64
+	for _, page := range result.Pages {
+		total += page.Confidence
+	}
+	if len(result.Pages) > 0 {
+		result.Confidence = total / float64(len(result.Pages))
+	} else {
+		result.Confidence = base.Confidence
+	}
+	var versions []string
+	for _, stage := range strategy.Stages {
+		if !used[stage.EngineName] {
+			continue
+		}
+		version := stage.EngineName
+		if versioned, ok := s.GetEngine(stage.EngineName).(VersionedOCREngine); ok && versioned.Version() != "" {
+			version += "@" + versioned.Version()
+		}
+		versions = append(versions, version)
+	}
+	result.EngineVersion = strings.Join(versions, "+")
+	return result
+}
+
+// cascadeFallback sends the pages below each stage's threshold to the next
+// stage, starting after stage first, and keeps the more confident reading.
+func (s *ocrService) cascadeFallback(ctx context.Context, strategy *CascadeStrategy, first int, result *OCRResult, pages *documentPages, used map[string]bool) {
+	for i := first; i < len(strategy.Stages)-1; i++ {
+		threshold := strategy.Stages[i].MinConfidence
+		next := strategy.Stages[i+1]
+		var low []int
+		for _, page := range result.Pages {
+			if page.Confidence < threshold && !pages.plan.skips(page.PageNumber) {
+				low = append(low, page.PageNumber)
+			}
+		}
+		if len(low) == 0 {
+			return
+		}
+		engine := s.GetEngine(next.EngineName)
+		if engine == nil {
+			log.Printf("Cascade: engine %s is not registered, skipping stage", next.EngineName)
+			continue
+		}
+
+		log.Printf("Cascade: %d/%d pages of %s below %.2f, sending to %s", len(low), len(result.Pages), result.Filename, threshold, next.EngineName)
+		readings, err := pages.recognize(ctx, engine, low, result.Languages)
+		if err != nil {
+			log.Printf("Cascade: %s failed on %s: %v", next.EngineName, result.Filename, err)
+			continue
+		}
+		for j, page := range result.Pages {
+			reading, ok := readings[page.PageNumber]
+			if ok && reading.Confidence > page.Confidence {
+				reading.EngineName = next.EngineName
+				reading.Classification = page.Classification
+				result.Pages[j] = reading
+				used[next.EngineName] = true
+			}
+		}
+	}
+}
+
+// recognize reads the given pages with engine. PDFs and images are recognized
+// page by page; other documents (Office files) are processed whole and the
+// requested pages picked from the result. languages, the codes the first stage
+// used, are kept when engine supports them.
+func (p *documentPages) recognize(ctx context.Context, engine OCREngine, pageNumbers []int, languages []string) (map[int]OCRPage, error) {
+	if err := p.render(ctx); err != nil {
+		return nil, err
+	}
+	readings := map[int]OCRPage{}
+	if p.images == nil {
+		full := runOCREngine(ctx, engine, p.filename, p.data)
+		if full.Error != nil {
+			return nil, full.Error
+		}
+		for _, page := range full.Pages {
+			for _, n := range pageNumbers {
+				if page.PageNumber == n {
+					readings[n] = page
+				}
+			}
+		}
+		return readings, nil
+	}
+
+	var selected []image.Image
+	var numbers []int
+	for _, n := range pageNumbers {
+		if n >= 1 && n <= len(p.images) {
+			selected = append(selected, p.images[n-1])
+			numbers = append(numbers, n)
+		}
+	}
+	ctx = cascadeLanguages(ctx, engine, languages, selected)
+	recs, errs := recognizeImages(ctx, engine, selected, p.dpi)
+	for i, rec := range recs {
+		if errs[i] != nil {
+			log.Printf("Cascade: %s failed on page %d: %v", engine.Name(), numbers[i], errs[i])
+			continue
+		}
+		readings[numbers[i]] = OCRPage{
+			PageNumber:      numbers[i],
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
+		}
+	}
+	return readings, nil
+}
+
+// cascadeLanguages carries the first stage's languages over to engine, or
+// detects them on the selected pages when engine supports none of them.
+func cascadeLanguages(ctx context.Context, engine OCREngine, languages []string, samples []image.Image) context.Context {
+	if len(OCRLanguagesFromContext(ctx)) > 0 {
+		return ctx
+	}
+	me, ok := engine.(MultilingualOCREngine)
+	if !ok {
+		return ctx
+	}
+	var supported []string
+	for _, code := range languages {
+		if me.SupportsLanguage(code) {
+			supported = append(supported, code)
+		}
+	}
+	if len(supported) > 0 {
+		return WithOCRLanguages(ctx, supported)
+	}
+	return resolveDocumentLanguages(ctx, engine, &OCRResult{}, "", samples)
+}
+
+// joinPageTexts builds ExtractedText from pages the way the engines do, with
+// page separators when the first stage used them.
+func joinPageTexts(pages []OCRPage, separators bool) string {
+	var b strings.Builder
+	for i, page := range pages {
+		if separators {
+			fmt.Fprintf(&b, "\n--- Page %d ---\n", page.PageNumber)
+		} else if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(page.Text)
+	}
+	return strings.TrimSpace(b.String())
+}
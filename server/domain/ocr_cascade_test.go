@@ -0,0 +1,220 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeOCREngine returns fixed pages for every document.
+type fakeOCREngine struct {
+	name    string
+	version string
+	pages   []OCRPage
+	err     error
+	calls   int
+}
+
+func (e *fakeOCREngine) Name() string    { return e.name }
+func (e *fakeOCREngine) Version() string { return e.version }
+
+func (e *fakeOCREngine) ProcessDocument(ctx context.Context, filename string, content io.Reader) (*OCRResult, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	pages := append([]OCRPage(nil), e.pages...)
+	var texts []string
+	var total float64
+	for _, page := range pages {
+		texts = append(texts, page.Text)
+		total += page.Confidence
+	}
+	return &OCRResult{
+		Filename:      filename,
+		EngineName:    e.name,
+		ExtractedText: strings.Join(texts, "\n"),
+		Pages:         pages,
+		Status:        "completed",
+		Confidence:    total / float64(max(len(pages), 1)),
+	}, nil
+}
+
+func (e *fakeOCREngine) ProcessImage(ctx context.Context, img image.Image) (string, float64, error) {
+	return "", 0, fmt.Errorf("%s reads documents only", e.name)
+}
+
+// fakePages builds readings of pages 1..n from their texts and confidences.
+func fakePages(texts []string, confidences ...float64) []OCRPage {
+	pages := make([]OCRPage, len(confidences))
+	for i, c := range confidences {
+		pages[i] = OCRPage{PageNumber: i + 1, Text: texts[i], Confidence: c}
+	}
+	return pages
+}
+
+func TestParseCascadeStrategy(t *testing.T) {
+	for _, tt := range []struct {
+		spec    string
+		want    []CascadeStage
+		wantErr string
+	}{
+		{"tesseract:0.8,easyocr", []CascadeStage{{"tesseract", 0.8}, {"easyocr", 0}}, ""},
+		{" tesseract : 0.8 , paddleocr:1, easyocr ,", []CascadeStage{{"tesseract", 0.8}, {"paddleocr", 1}, {"easyocr", 0}}, ""},
+		{"tesseract:0,easyocr", []CascadeStage{{"tesseract", 0}, {"easyocr", 0}}, ""},
+		{"tesseract:0.8,easyocr:0.9", nil, "last cascade stage"},
+		{"tesseract,easyocr", nil, "needs a confidence threshold"},
+		{"tesseract:0.8,paddleocr,easyocr", nil, "needs a confidence threshold"},
+		{"tesseract:0.8", nil, "at least two stages"},
+		{"tesseract", nil, "at least two stages"},
+		{"", nil, "at least two stages"},
+		{"tesseract:1.5,easyocr", nil, "between 0 and 1"},
+		{"tesseract:-0.1,easyocr", nil, "between 0 and 1"},
+		{"tesseract:high,easyocr", nil, "between 0 and 1"},
+		{"cascade:0.8,easyocr", nil, "invalid engine name"},
+		{"tesseract:0.8,ensemble", nil, "invalid engine name"},
+		{":0.8,easyocr", nil, "invalid engine name"},
+	} {
+		strategy, err := ParseCascadeStrategy(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCascadeStrategy(%q) err = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCascadeStrategy(%q): %v", tt.spec, err)
+			continue
+		}
+		if fmt.Sprint(strategy.Stages) != fmt.Sprint(tt.want) {
+			t.Errorf("ParseCascadeStrategy(%q) = %v, want %v", tt.spec, strategy.Stages, tt.want)
+		}
+		// String is accepted back
+		if again, err := ParseCascadeStrategy(strategy.String()); err != nil || fmt.Sprint(again.Stages) != fmt.Sprint(tt.want) {
+			t.Errorf("ParseCascadeStrategy(%q) = %v, %v", strategy.String(), again, err)
+		}
+	}
+}
+
+func TestCascadeKeepsTheMoreConfidentReading(t *testing.T) {
+	texts := []string{"clear", "smudged", "torn"}
+	strategy, err := ParseCascadeStrategy("fast:0.8,slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		fast      *fakeOCREngine
+		slow      *fakeOCREngine
+		engines   []string // engine of each page
+		conf      []float64
+		version   string
+		slowCalls int
+	}{
+		{
+			name: "low pages go to the next stage",
+			fast: &fakeOCREngine{name: "fast", version: "1", pages: fakePages(texts, 0.9, 0.5, 0.4)},
+			// The slow engine reads page 1 better too, but page 1 was not sent;
+			// page 3 it reads worse, so the first reading stays
+			slow:      &fakeOCREngine{name: "slow", version: "2", pages: fakePages([]string{"CLEAR", "SMUDGED", "TORN"}, 0.95, 0.7, 0.3)},
+			engines:   []string{"fast", "slow", "fast"},
+			conf:      []float64{0.9, 0.7, 0.4},
+			version:   "fast@1+slow@2",
+			slowCalls: 1,
+		},
+		{
+			name:      "confident pages stay with the first stage",
+			fast:      &fakeOCREngine{name: "fast", pages: fakePages(texts, 0.9, 0.85, 0.8)},
+			slow:      &fakeOCREngine{name: "slow", pages: fakePages(texts, 1, 1, 1)},
+			engines:   []string{"fast", "fast", "fast"},
+			conf:      []float64{0.9, 0.85, 0.8},
+			version:   "fast",
+			slowCalls: 0,
+		},
+		{
+			name:      "a failed first stage hands the document on",
+			fast:      &fakeOCREngine{name: "fast", err: errors.New("crashed")},
+			slow:      &fakeOCREngine{name: "slow", pages: fakePages(texts, 0.6, 0.6, 0.6)},
+			engines:   []string{"slow", "slow", "slow"},
+			conf:      []float64{0.6, 0.6, 0.6},
+			version:   "slow",
+			slowCalls: 1,
+		},
+		{
+			name:      "a failed fallback keeps the first readings",
+			fast:      &fakeOCREngine{name: "fast", pages: fakePages(texts, 0.9, 0.5, 0.4)},
+			slow:      &fakeOCREngine{name: "slow", err: errors.New("crashed")},
+			engines:   []string{"fast", "fast", "fast"},
+			conf:      []float64{0.9, 0.5, 0.4},
+			version:   "fast",
+			slowCalls: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewOCRService().(*ocrService)
+			s.RegisterEngine(tt.fast)
+			s.RegisterEngine(tt.slow)
+			// Office documents have no page images, so the fallback reruns
+			// the engine on the whole document and picks the pages
+			pages := &documentPages{filename: "report.docx", data: []byte("document"), rendered: true}
+			result := s.processCascade(context.Background(), strategy, pages, map[string]*OCRResult{})
+			if result.Status != "completed" || result.EngineName != CascadeEngineName {
+				t.Fatalf("result = %s %s, %v", result.EngineName, result.Status, result.Error)
+			}
+			if len(result.Pages) != 3 {
+				t.Fatalf("got %d pages, want 3", len(result.Pages))
+			}
+			var total float64
+			for i, page := range result.Pages {
+				if page.EngineName != tt.engines[i] || page.Confidence != tt.conf[i] {
+					t.Errorf("page %d read by %s at %.2f, want %s at %.2f", page.PageNumber, page.EngineName, page.Confidence, tt.engines[i], tt.conf[i])
+				}
+				total += page.Confidence
+			}
+			if diff := result.Confidence - total/3; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("Confidence = %v, want the page mean %v", result.Confidence, total/3)
+			}
+			if result.EngineVersion != tt.version {
+				t.Errorf("EngineVersion = %q, want %q", result.EngineVersion, tt.version)
+			}
+			if tt.slow.calls != tt.slowCalls {
+				t.Errorf("slow engine ran %d times, want %d", tt.slow.calls, tt.slowCalls)
+			}
+			for i, page := range result.Pages {
+				if !strings.Contains(result.ExtractedText, page.Text) {
+					t.Errorf("ExtractedText %q lacks page %d %q", result.ExtractedText, i+1, page.Text)
+				}
+			}
+		})
+	}
+
+	t.Run("results of engines that already ran are reused", func(t *testing.T) {
+		fast := &fakeOCREngine{name: "fast", pages: fakePages(texts, 0.9, 0.5, 0.4)}
+		slow := &fakeOCREngine{name: "slow", pages: fakePages(texts, 0.95, 0.7, 0.3)}
+		s := NewOCRService().(*ocrService)
+		s.RegisterEngine(fast)
+		s.RegisterEngine(slow)
+		pages := &documentPages{filename: "report.docx", data: []byte("document"), rendered: true}
+		done := map[string]*OCRResult{"fast": runOCREngine(context.Background(), fast, pages.filename, pages.data)}
+		result := s.processCascade(context.Background(), strategy, pages, done)
+		if fast.calls != 1 || result.Pages[1].EngineName != "slow" {
+			t.Fatalf("fast ran %d times, page 2 read by %s", fast.calls, result.Pages[1].EngineName)
+		}
+		// The stored engine result is not changed by the cascade
+		if done["fast"].Pages[1].EngineName != "fast" {
+			t.Fatalf("cascade modified the fast result: %+v", done["fast"].Pages[1])
+		}
+	})
+
+	t.Run("no registered stage", func(t *testing.T) {
+		pages := &documentPages{filename: "report.docx", data: []byte("document"), rendered: true}
+		result := NewOCRService().(*ocrService).processCascade(context.Background(), strategy, pages, map[string]*OCRResult{})
+		if result.Status != "failed" || result.Error == nil {
+			t.Fatalf("result = %s, %v; want failed", result.Status, result.Error)
+		}
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	pb "grpc-sample-minimal/proto"
+	"grpc-sample-minimal/tlsconfig"
 )
 
 // OCRClient ?OCR?????????????????????????????????
@@ -21,6 +22,10 @@ type OCRClient interface {
 	// GetOCRResult ?OCR???????
 	GetOCRResult(ctx context.Context, filename string, storageProvider string, engineName string) (*OCRResult, error)
 	
+	// ExportSearchablePDF has the OCR service build (or reuse) the searchable PDF.
+	// It returns nil when there is no OCR result; cached reports a reused PDF.
+	ExportSearchablePDF(ctx context.Context, filename string, storageProvider string, engineName string, force bool) (file *DerivedFile, cached bool, err error)
+	
 	// Close ???????
 	Close() error
 }
@@ -37,8 +42,13 @@ type ocrClientAdapter struct {
 // endpoint: OCR?????gRPC?????????: "ocr-service:50052"?
 // authToken: ??????
 func NewOCRClient(endpoint string, authToken string) (OCRClient, error) {
+	// TLS/mTLS per TLS_MODE; the gateway presents its own certificate
+	creds, err := tlsconfig.DialOption("server")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
 	// gRPC?????
-	conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+	conn, err := grpc.Dial(endpoint, creds)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to OCR service: %w", err)
 	}
@@ -58,6 +68,7 @@ func (c *ocrClientAdapter) ProcessDocument(ctx context.Context, filename string,
 	req := &pb.OCRRequest{
 		Filename:       filename,
 		StorageProvider: storageProvider,
+		Languages:      OCRLanguagesFromContext(ctx),
 	}
 	
 	// ???????????????
@@ -109,6 +120,9 @@ func (c *ocrClientAdapter) GetOCRResult(ctx context.Context, filename string, st
 			PageNumber: int(page.PageNumber),
 			Text:       page.Text,
 			Confidence: page.Confidence,
+			PreprocessSteps: page.PreprocessSteps,
+			EngineAgreement: EngineAgreementFromProto(page.EngineAgreement),
+			EngineName: page.EngineName,
 		}
 	}
 	
@@ -127,9 +141,46 @@ func (c *ocrClientAdapter) GetOCRResult(ctx context.Context, filename string, st
 		Error:           errObj,
 		Confidence:      resp.Confidence,
 		ProcessedAt:     timeFromUnix(resp.ProcessedAt),
+		PreprocessProfile: resp.PreprocessProfile,
+		DetectedLanguage: resp.DetectedLanguage,
+		Languages: resp.Languages,
+		EngineAgreement: EngineAgreementFromProto(resp.EngineAgreement),
+		EngineVersion: resp.EngineVersion,
 	}, nil
 }
 
+// ExportSearchablePDF asks the OCR service over gRPC to build the searchable PDF
+func (c *ocrClientAdapter) ExportSearchablePDF(ctx context.Context, filename string, storageProvider string, engineName string, force bool) (*DerivedFile, bool, error) {
+	md := metadata.New(map[string]string{
+		"authorization": c.getAuthToken(ctx),
+	})
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	
+	resp, err := c.grpcClient.ExportSearchablePDF(ctx, &pb.SearchablePDFRequest{
+		Filename:        filename,
+		StorageProvider: storageProvider,
+		EngineName:      engineName,
+		Force:           force,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to export searchable PDF: %w", err)
+	}
+	if resp.Status == "not_found" {
+		return nil, false, nil
+	}
+	
+	return &DerivedFile{
+		Filename:        resp.Filename,
+		StorageProvider: resp.StorageProvider,
+		EngineName:      resp.EngineName,
+		Kind:            DerivedKindSearchablePDF,
+		StoragePath:     resp.StoragePath,
+		PageCount:       int(resp.PageCount),
+		Size:            resp.Size,
+		CreatedAt:       timeFromUnix(resp.GeneratedAt),
+	}, resp.Cached, nil
+}
+
 // Close ?gRPC??????
 func (c *ocrClientAdapter) Close() error {
 	if c.conn != nil {
@@ -138,16 +189,14 @@ func (c *ocrClientAdapter) Close() error {
 	return nil
 }
 
-// getAuthToken ????????????
+// getAuthToken returns the caller's token, forwarded from the incoming RPC,
+// or the service token (authToken, then AUTH_TOKEN) when there is no caller.
 func (c *ocrClientAdapter) getAuthToken(ctx context.Context) string {
-	if c.authToken != "" {
-		return c.authToken
-	}
-	// ????????
-	if token := os.Getenv("AUTH_TOKEN"); token != "" {
-		return token
+	serviceToken := c.authToken
+	if serviceToken == "" {
+		serviceToken = os.Getenv("AUTH_TOKEN")
 	}
-	return ""
+	return BearerToken(ctx, serviceToken)
 }
 
 // timeFromUnix ?Unix?????????time.Time???
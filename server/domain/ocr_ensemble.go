@@ -0,0 +1,717 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// EnsembleEngineName is the virtual engine whose result is voted from the
+// results of the other engines. Adding it to OCR_ENGINES makes the OCR service
+// rebuild it whenever one of the engines stores a result.
+const EnsembleEngineName = "ensemble"
+
+const (
+	// minEnsembleSources is the number of engine results needed for a vote.
+	minEnsembleSources = 2
+	// ensembleGapScore is the alignment score of a token matched to nothing.
+	// It is above half the score of two unrelated tokens (-1), so a different
+	// reading at the same position is aligned as a substitution and voted on.
+	ensembleGapScore = -0.6
+	// minEnsembleWeight keeps engines that report no confidence in the vote.
+	minEnsembleWeight = 0.01
+)
+
+// EngineAgreement tells how often an engine's words matched the ensemble
+// consensus. Compared words are consensus words plus words only this engine read.
+type EngineAgreement struct {
+	EngineName    string  `json:"engine_name"`
+	Agreement     float64 `json:"agreement"`
+	ComparedWords int     `json:"compared_words"`
+}
+
+// ensembleToken is a word, or a single character of CJK text, as read by one engine.
+type ensembleToken struct {
+	Text       string
+	Confidence float64
+	BBox       BoundingBox
+	HasBox     bool
+	Glued      bool // no space before this token (continuation of a CJK run)
+}
+
+// ensembleSource is one engine's reading of a page.
+type ensembleSource struct {
+	engine         string
+	lines          [][]ensembleToken
+	meanConfidence float64
+}
+
+// agreementCount accumulates EngineAgreement.
+type agreementCount struct {
+	agreed, compared int
+}
+
+// LoadEnsembleSources returns the completed results of the other engines for a
+// file, with their page layouts attached.
+func LoadEnsembleSources(ctx context.Context, repo OCRResultRepository, filename string, provider string) ([]*OCRResult, error) {
+	results, err := repo.GetOCRComparison(ctx, filename, provider)
+	if err != nil {
+		return nil, err
+	}
+	var sources []*OCRResult
+	for _, result := range results {
+		if result.EngineName == EnsembleEngineName || result.EngineName == RedactedEngineName || result.Status != "completed" {
+			continue
+		}
+		if err := attachStoredLayout(ctx, repo, result); err != nil {
+			return nil, err
+		}
+		sources = append(sources, result)
+	}
+	return sources, nil
+}
+
+// attachStoredLayout sets the page layouts of a stored result.
+func attachStoredLayout(ctx context.Context, repo OCRResultRepository, result *OCRResult) error {
+	layout, err := repo.GetOCRLayout(ctx, result.Filename, result.StorageProvider, result.EngineName)
+	if err != nil || layout == nil {
+		return err
+	}
+	for _, lp := range layout.Pages {
+		for i := range result.Pages {
+			if result.Pages[i].PageNumber == lp.PageNumber {
+				pageLayout := lp.OCRPageLayout
+				result.Pages[i].Layout = &pageLayout
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateEnsembleResult rebuilds and stores the ensemble result of a file. It
+// returns nil when fewer than two engines have completed results, or when a
+// stored ensemble already covers newer engine results (another OCR service
+// finished in between).
+func UpdateEnsembleResult(ctx context.Context, repo OCRResultRepository, filename string, provider string) (*OCRResult, error) {
+	sources, err := LoadEnsembleSources(ctx, repo, filename, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load engine results: %w", err)
+	}
+	if len(sources) < minEnsembleSources {
+		return nil, nil
+	}
+	result, err := BuildEnsembleResult(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := repo.GetOCRResult(ctx, filename, provider, EnsembleEngineName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Status == "completed" &&
+		(existing.ProcessedAt.After(result.ProcessedAt) ||
+			(existing.ProcessedAt.Equal(result.ProcessedAt) && len(existing.EngineAgreement) > len(result.EngineAgreement))) {
+		return nil, nil
+	}
+	if err := repo.SaveOCRResult(ctx, result); err != nil {
+		return nil, err
+	}
+	logEnsembleAgreement(result)
+	return result, nil
+}
+
+// BuildEnsembleResult merges the results of several engines for one file.
+//
+// Per page, each engine's lines are aligned to those of the most confident
+// engine, then the words of each aligned line group are aligned the same way
+// (CJK text character by character). Every engine votes for its reading at each
+// position with its word confidence; an engine that read nothing there votes for
+// the gap with its mean confidence on the page. The winning reading's
+// confidence is the summed confidence of its voters divided by the number of
+// engines, so words the engines disagree on come out with low confidence.
+func BuildEnsembleResult(sources []*OCRResult) (*OCRResult, error) {
+	var completed []*OCRResult
+	for _, s := range sources {
+		if s != nil && s.Status == "completed" && s.EngineName != EnsembleEngineName && s.EngineName != CascadeEngineName && s.EngineName != RedactedEngineName {
+			completed = append(completed, s)
+		}
+	}
+	if len(completed) < minEnsembleSources {
+		return nil, fmt.Errorf("ensemble needs at least %d completed engine results, got %d", minEnsembleSources, len(completed))
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].EngineName < completed[j].EngineName })
+
+	result := &OCRResult{
+		Filename:        completed[0].Filename,
+		StorageProvider: completed[0].StorageProvider,
+		EngineName:      EnsembleEngineName,
+		Status:          "completed",
+	}
+	pageNumbers := map[int]bool{}
+	var versions []string
+	for _, s := range completed {
+		if s.EngineVersion != "" {
+			versions = append(versions, s.EngineName+"@"+s.EngineVersion)
+		}
+		if s.ProcessedAt.After(result.ProcessedAt) {
+			result.ProcessedAt = s.ProcessedAt
+		}
+		if result.DetectedLanguage == "" {
+			result.DetectedLanguage = s.DetectedLanguage
+		}
+		for _, code := range s.Languages {
+			if !containsLanguage(result.Languages, code) {
+				result.Languages = append(result.Languages, code)
+			}
+		}
+		for _, p := range s.Pages {
+			pageNumbers[p.PageNumber] = true
+		}
+		// Barcodes come from the page images, not the engine.
+		if len(result.Barcodes) == 0 {
+			result.Barcodes = s.Barcodes
+		}
+	}
+	// The vote changes whenever one of the engines does.
+	result.EngineVersion = strings.Join(versions, "+")
+	numbers := make([]int, 0, len(pageNumbers))
+	for n := range pageNumbers {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	totals := make([]agreementCount, len(completed))
+	var text strings.Builder
+	var confidenceSum float64
+	var textPages int
+	for _, n := range numbers {
+		page, counts := buildEnsemblePage(n, completed)
+		for i, c := range counts {
+			totals[i].agreed += c.agreed
+			totals[i].compared += c.compared
+		}
+		result.Pages = append(result.Pages, page)
+		if len(numbers) > 1 {
+			text.WriteString(fmt.Sprintf("\n--- Page %d ---\n", n))
+		}
+		text.WriteString(page.Text)
+		if page.Text != "" {
+			confidenceSum += page.Confidence
+			textPages++
+		}
+	}
+	result.ExtractedText = strings.TrimSpace(text.String())
+	if textPages > 0 {
+		result.Confidence = confidenceSum / float64(textPages)
+	}
+	result.EngineAgreement = engineAgreements(completed, totals)
+	return result, nil
+}
+
+// buildEnsemblePage votes one page. Engines without text on the page (not
+// reached, or the page failed) do not take part.
+func buildEnsemblePage(pageNumber int, results []*OCRResult) (OCRPage, []agreementCount) {
+	counts := make([]agreementCount, len(results))
+	page := OCRPage{PageNumber: pageNumber}
+
+	var sources []ensembleSource
+	var sourceIndex []int // index into results
+	width, height := 0, 0
+	for i, r := range results {
+		for _, p := range r.Pages {
+			if p.PageNumber != pageNumber {
+				continue
+			}
+			if page.Classification == "" {
+				page.Classification = p.Classification
+			}
+			src := ensembleSourceFromPage(r.EngineName, p)
+			if len(src.lines) == 0 {
+				continue
+			}
+			if p.Layout != nil && width == 0 {
+				width, height = p.Layout.Width, p.Layout.Height
+			}
+			sources = append(sources, src)
+			sourceIndex = append(sourceIndex, i)
+		}
+	}
+	if len(sources) == 0 {
+		return page, counts
+	}
+
+	ref := 0
+	for i, s := range sources {
+		if s.meanConfidence > sources[ref].meanConfidence {
+			ref = i
+		}
+	}
+	groups := alignEnsembleLines(sources, ref)
+
+	pageCounts := make([]agreementCount, len(sources))
+	var lines [][]ensembleToken
+	for _, group := range groups {
+		var line []ensembleToken
+		for _, column := range alignEnsembleWords(sources, group) {
+			if tok, ok := voteEnsembleColumn(sources, column, pageCounts); ok {
+				line = append(line, tok)
+			}
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	lineTexts := make([]string, 0, len(lines))
+	var confidenceSum float64
+	var tokens int
+	hasBoxes := width > 0 && height > 0
+	for _, line := range lines {
+		lineTexts = append(lineTexts, joinEnsembleTokens(line))
+		for _, tok := range line {
+			confidenceSum += tok.Confidence
+			tokens++
+			hasBoxes = hasBoxes && tok.HasBox
+		}
+	}
+	page.Text = strings.Join(lineTexts, "\n")
+	if tokens > 0 {
+		page.Confidence = confidenceSum / float64(tokens)
+	}
+	if hasBoxes && tokens > 0 {
+		page.Layout = ensembleLayout(width, height, lines)
+		if TableExtractionEnabled() {
+			// No page image here, so only tables of aligned columns are found.
+			page.Tables = ExtractTables(page.Layout, nil)
+			for i := range page.Tables {
+				page.Tables[i].PageNumber = page.PageNumber
+			}
+		}
+	}
+
+	pageResults := make([]*OCRResult, len(sources))
+	for i, idx := range sourceIndex {
+		counts[idx] = pageCounts[i]
+		pageResults[i] = results[idx]
+	}
+	page.EngineAgreement = engineAgreements(pageResults, pageCounts)
+	return page, counts
+}
+
+// ensembleSourceFromPage tokenizes a page, from its layout when there is one.
+func ensembleSourceFromPage(engine string, page OCRPage) ensembleSource {
+	src := ensembleSource{engine: engine}
+	var confidenceSum float64
+	var tokens int
+	add := func(line []ensembleToken) {
+		if len(line) == 0 {
+			return
+		}
+		src.lines = append(src.lines, line)
+		for _, tok := range line {
+			confidenceSum += tok.Confidence
+			tokens++
+		}
+	}
+	if len(page.Layout.Words()) > 0 {
+		for _, block := range page.Layout.Blocks {
+			for _, l := range block.Lines {
+				var line []ensembleToken
+				for _, w := range l.Words {
+					line = append(line, splitEnsembleWord(w.Text, w.Confidence, w.BBox, true)...)
+				}
+				add(line)
+			}
+		}
+	} else {
+		for _, l := range strings.Split(page.Text, "\n") {
+			var line []ensembleToken
+			for _, w := range strings.Fields(l) {
+				line = append(line, splitEnsembleWord(w, page.Confidence, BoundingBox{}, false)...)
+			}
+			add(line)
+		}
+	}
+	if tokens > 0 {
+		src.meanConfidence = confidenceSum / float64(tokens)
+	}
+	return src
+}
+
+// splitEnsembleWord splits words containing CJK characters into characters so
+// that engines segmenting CJK text differently can still be aligned. Boxes are
+// divided evenly, assuming horizontal text.
+func splitEnsembleWord(text string, confidence float64, box BoundingBox, hasBox bool) []ensembleToken {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	runes := []rune(text)
+	cjk := false
+	for _, r := range runes {
+		if isCJKRune(r) {
+			cjk = true
+			break
+		}
+	}
+	if !cjk || len(runes) == 1 {
+		return []ensembleToken{{Text: text, Confidence: confidence, BBox: box, HasBox: hasBox}}
+	}
+	tokens := make([]ensembleToken, len(runes))
+	for i, r := range runes {
+		tok := ensembleToken{Text: string(r), Confidence: confidence, HasBox: hasBox, Glued: i > 0}
+		if hasBox {
+			tok.BBox = BoundingBox{
+				X0: box.X0 + box.Width()*i/len(runes),
+				Y0: box.Y0,
+				X1: box.X0 + box.Width()*(i+1)/len(runes),
+				Y1: box.Y1,
+			}
+		}
+		tokens[i] = tok
+	}
+	return tokens
+}
+
+// isCJKRune reports whether r is written without spaces between words.
+func isCJKRune(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// joinEnsembleTokens joins tokens with spaces, except inside CJK runs.
+func joinEnsembleTokens(tokens []ensembleToken) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && !tok.Glued && !(endsWithCJK(tokens[i-1].Text) && startsWithCJK(tok.Text)) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.Text)
+	}
+	return b.String()
+}
+
+func startsWithCJK(s string) bool {
+	for _, r := range s {
+		return isCJKRune(r)
+	}
+	return false
+}
+
+func endsWithCJK(s string) bool {
+	runes := []rune(s)
+	return len(runes) > 0 && isCJKRune(runes[len(runes)-1])
+}
+
+// ensembleLineGroup holds, per source, the index of its line in the group or -1.
+type ensembleLineGroup []int
+
+// alignEnsembleLines aligns every source's lines to the reference source.
+// Lines the reference does not have become groups of their own, placed after
+// the group of the preceding aligned line.
+func alignEnsembleLines(sources []ensembleSource, ref int) []ensembleLineGroup {
+	newGroup := func() ensembleLineGroup {
+		g := make(ensembleLineGroup, len(sources))
+		for i := range g {
+			g[i] = -1
+		}
+		return g
+	}
+	refLines := sources[ref].lines
+	groups := make([]ensembleLineGroup, len(refLines))
+	for i := range refLines {
+		groups[i] = newGroup()
+		groups[i][ref] = i
+	}
+	// inserted[k] are the groups placed before reference line k (k == len: at the end).
+	inserted := make([][]ensembleLineGroup, len(refLines)+1)
+
+	refTexts := make([][]rune, len(refLines))
+	for i, l := range refLines {
+		refTexts[i] = []rune(joinEnsembleTokens(l))
+	}
+	for s, src := range sources {
+		if s == ref {
+			continue
+		}
+		texts := make([][]rune, len(src.lines))
+		for i, l := range src.lines {
+			texts[i] = []rune(joinEnsembleTokens(l))
+		}
+		pairs := alignSequences(len(refTexts), len(texts), func(i, j int) float64 {
+			return 2*textSimilarity(refTexts[i], texts[j]) - 1
+		})
+		next := 0 // reference line the next insertion precedes
+		for _, p := range pairs {
+			switch {
+			case p[0] >= 0 && p[1] >= 0:
+				groups[p[0]][s] = p[1]
+				next = p[0] + 1
+			case p[0] >= 0:
+				next = p[0] + 1
+			default:
+				g := newGroup()
+				g[s] = p[1]
+				inserted[next] = append(inserted[next], g)
+			}
+		}
+	}
+
+	var ordered []ensembleLineGroup
+	for k := range refLines {
+		ordered = append(ordered, inserted[k]...)
+		ordered = append(ordered, groups[k])
+	}
+	return append(ordered, inserted[len(refLines)]...)
+}
+
+// ensembleColumn holds, per source, the token read at one position (nil for none).
+type ensembleColumn []*ensembleToken
+
+// alignEnsembleWords aligns the tokens of a line group to its most confident
+// line. Tokens missing from that line are inserted as extra columns; the n-th
+// insertion of each source at the same place shares a column.
+func alignEnsembleWords(sources []ensembleSource, group ensembleLineGroup) []ensembleColumn {
+	ref := -1
+	for s, li := range group {
+		if li >= 0 && (ref < 0 || sources[s].meanConfidence > sources[ref].meanConfidence) {
+			ref = s
+		}
+	}
+	if ref < 0 {
+		return nil
+	}
+	refTokens := sources[ref].lines[group[ref]]
+	columns := make([]ensembleColumn, len(refTokens))
+	for i := range refTokens {
+		columns[i] = make(ensembleColumn, len(sources))
+		columns[i][ref] = &refTokens[i]
+	}
+	inserted := make([][]ensembleColumn, len(refTokens)+1)
+
+	for s, li := range group {
+		if s == ref || li < 0 {
+			continue
+		}
+		tokens := sources[s].lines[li]
+		pairs := alignSequences(len(refTokens), len(tokens), func(i, j int) float64 {
+			return 2*textSimilarity([]rune(refTokens[i].Text), []rune(tokens[j].Text)) - 1
+		})
+		next, n := 0, 0
+		for _, p := range pairs {
+			switch {
+			case p[0] >= 0 && p[1] >= 0:
+				columns[p[0]][s] = &tokens[p[1]]
+				next, n = p[0]+1, 0
+			case p[0] >= 0:
+				next, n = p[0]+1, 0
+			default:
+				if len(inserted[next]) <= n {
+					inserted[next] = append(inserted[next], make(ensembleColumn, len(sources)))
+				}
+				inserted[next][n][s] = &tokens[p[1]]
+				n++
+			}
+		}
+	}
+
+	var ordered []ensembleColumn
+	for k := range refTokens {
+		ordered = append(ordered, inserted[k]...)
+		ordered = append(ordered, columns[k])
+	}
+	return append(ordered, inserted[len(refTokens)]...)
+}
+
+// voteEnsembleColumn picks the reading with the highest summed confidence.
+// Ties go to a word over the gap. It returns false when the gap wins.
+func voteEnsembleColumn(sources []ensembleSource, column ensembleColumn, counts []agreementCount) (ensembleToken, bool) {
+	weights := map[string]float64{}
+	best := map[string]*ensembleToken{}
+	gapWeight := 0.0
+	for s, tok := range column {
+		if tok == nil {
+			gapWeight += max(sources[s].meanConfidence, minEnsembleWeight)
+			continue
+		}
+		weights[tok.Text] += max(tok.Confidence, minEnsembleWeight)
+		if b, ok := best[tok.Text]; !ok || tok.Confidence > b.Confidence || (!b.HasBox && tok.HasBox) {
+			best[tok.Text] = tok
+		}
+	}
+
+	winner, winnerWeight := "", -1.0
+	for text, w := range weights {
+		if w > winnerWeight || (w == winnerWeight && text < winner) {
+			winner, winnerWeight = text, w
+		}
+	}
+	gapWins := winnerWeight < gapWeight
+
+	for s, tok := range column {
+		switch {
+		case gapWins && tok == nil:
+			// agreeing on nothing is not counted
+		case gapWins:
+			counts[s].compared++
+		case tok != nil && tok.Text == winner:
+			counts[s].compared++
+			counts[s].agreed++
+		default:
+			counts[s].compared++
+		}
+	}
+	if gapWins {
+		return ensembleToken{}, false
+	}
+
+	tok := *best[winner]
+	tok.Confidence = winnerWeight / float64(len(column))
+	return tok, true
+}
+
+// ensembleLayout builds a single-block layout from voted lines.
+func ensembleLayout(width, height int, lines [][]ensembleToken) *OCRPageLayout {
+	block := OCRBlock{}
+	for _, line := range lines {
+		var l OCRLine
+		for i := 0; i < len(line); i++ {
+			// Re-join CJK characters that came from one word back into one box.
+			w := OCRWord{Text: line[i].Text, BBox: line[i].BBox, Confidence: line[i].Confidence}
+			n := 1
+			for i+1 < len(line) && line[i+1].Glued {
+				i++
+				w.Text += line[i].Text
+				w.BBox = w.BBox.Union(line[i].BBox)
+				w.Confidence += line[i].Confidence
+				n++
+			}
+			w.Confidence /= float64(n)
+			l.Words = append(l.Words, w)
+		}
+		block.Lines = append(block.Lines, l)
+	}
+	layout := &OCRPageLayout{Width: width, Height: height, Blocks: []OCRBlock{block}}
+	layout.recomputeBoxes()
+	return layout
+}
+
+// engineAgreements converts counts into EngineAgreement entries.
+func engineAgreements(results []*OCRResult, counts []agreementCount) []EngineAgreement {
+	agreements := make([]EngineAgreement, 0, len(results))
+	for i, r := range results {
+		a := EngineAgreement{EngineName: r.EngineName, ComparedWords: counts[i].compared}
+		if a.ComparedWords > 0 {
+			a.Agreement = float64(counts[i].agreed) / float64(a.ComparedWords)
+		}
+		agreements = append(agreements, a)
+	}
+	return agreements
+}
+
+// alignSequences computes a global (Needleman-Wunsch) alignment of two
+// sequences of lengths n and m. score(i, j) rates pairing element i with j;
+// leaving an element unpaired scores ensembleGapScore. The pairs are in order,
+// with -1 marking the missing side.
+func alignSequences(n, m int, score func(i, j int) float64) [][2]int {
+	dp := make([][]float64, n+1)
+	for i := range dp {
+		dp[i] = make([]float64, m+1)
+		dp[i][0] = float64(i) * ensembleGapScore
+	}
+	for j := 0; j <= m; j++ {
+		dp[0][j] = float64(j) * ensembleGapScore
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			dp[i][j] = max(dp[i-1][j-1]+score(i-1, j-1), dp[i-1][j]+ensembleGapScore, dp[i][j-1]+ensembleGapScore)
+		}
+	}
+
+	var pairs [][2]int
+	i, j := n, m
+	for i > 0 || j > 0 {
+		switch {
+		case i > 0 && j > 0 && dp[i][j] == dp[i-1][j-1]+score(i-1, j-1):
+			pairs = append(pairs, [2]int{i - 1, j - 1})
+			i, j = i-1, j-1
+		case i > 0 && dp[i][j] == dp[i-1][j]+ensembleGapScore:
+			pairs = append(pairs, [2]int{i - 1, -1})
+			i--
+		default:
+			pairs = append(pairs, [2]int{-1, j - 1})
+			j--
+		}
+	}
+	for l, r := 0, len(pairs)-1; l < r; l, r = l+1, r-1 {
+		pairs[l], pairs[r] = pairs[r], pairs[l]
+	}
+	return pairs
+}
+
+// textSimilarity is 1 minus the edit distance normalized by the longer text.
+func textSimilarity(a, b []rune) float64 {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// levenshtein returns the edit distance between two sequences.
+func levenshtein[T comparable](a, b []T) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// EngineAgreementToProto converts agreement scores for gRPC responses.
+func EngineAgreementToProto(agreement []EngineAgreement) []*pb.EngineAgreement {
+	if len(agreement) == 0 {
+		return nil
+	}
+	out := make([]*pb.EngineAgreement, len(agreement))
+	for i, a := range agreement {
+		out[i] = &pb.EngineAgreement{EngineName: a.EngineName, Agreement: a.Agreement, ComparedWords: int32(a.ComparedWords)}
+	}
+	return out
+}
+
+// EngineAgreementFromProto is the inverse of EngineAgreementToProto.
+func EngineAgreementFromProto(agreement []*pb.EngineAgreement) []EngineAgreement {
+	if len(agreement) == 0 {
+		return nil
+	}
+	out := make([]EngineAgreement, len(agreement))
+	for i, a := range agreement {
+		out[i] = EngineAgreement{EngineName: a.EngineName, Agreement: a.Agreement, ComparedWords: int(a.ComparedWords)}
+	}
+	return out
+}
+
+// logEnsembleAgreement logs per-engine agreement of an ensemble result.
+func logEnsembleAgreement(result *OCRResult) {
+	for _, a := range result.EngineAgreement {
+		log.Printf("Ensemble %s: %s agreed on %.1f%% of %d words", result.Filename, a.EngineName, a.Agreement*100, a.ComparedWords)
+	}
+}
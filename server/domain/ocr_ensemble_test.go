@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// engineReading runs a fake engine that reads one page of text with the given
+// confidence.
+func engineReading(name string, text string, confidence float64) *OCRResult {
+	engine := &fakeOCREngine{name: name, pages: []OCRPage{{PageNumber: 1, Text: text, Confidence: confidence}}}
+	return runOCREngine(context.Background(), engine, "scan.png", nil)
+}
+
+func TestAlignSequences(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want string
+	}{
+		{"abc", "abc", "[[0 0] [1 1] [2 2]]"},
+		{"abc", "ac", "[[0 0] [1 -1] [2 1]]"},
+		{"ac", "abc", "[[0 0] [-1 1] [1 2]]"},
+		// A different element at the same position is a substitution, not two gaps
+		{"abc", "axc", "[[0 0] [1 1] [2 2]]"},
+		{"ab", "", "[[0 -1] [1 -1]]"},
+		{"", "xy", "[[-1 0] [-1 1]]"},
+	} {
+		a, b := []rune(tt.a), []rune(tt.b)
+		pairs := alignSequences(len(a), len(b), func(i, j int) float64 {
+			if a[i] == b[j] {
+				return 1
+			}
+			return -1
+		})
+		if got := fmt.Sprint(pairs); got != tt.want {
+			t.Errorf("alignSequences(%q, %q) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		a, b string
+		want float64
+	}{
+		{"120", "120", 1},
+		{"120", "128", 2.0 / 3},
+		{"colour", "color", 5.0 / 6},
+		{"", "", 1},
+		{"abc", "", 0},
+	} {
+		if got := textSimilarity([]rune(tt.a), []rune(tt.b)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("textSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBuildEnsembleResult(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		sources    []*OCRResult
+		want       string
+		agreement  []float64 // per engine, in name order
+		confidence float64   // of the page; 0 to skip
+	}{
+		{
+			name:       "agreement",
+			sources:    []*OCRResult{engineReading("a", "total 120 yen", 0.9), engineReading("b", "total 120 yen", 0.8)},
+			want:       "total 120 yen",
+			agreement:  []float64{1, 1},
+			confidence: 0.85,
+		},
+		{
+			name: "disagreement is outvoted",
+			sources: []*OCRResult{
+				engineReading("a", "total 120 yen", 0.9),
+				engineReading("b", "total 128 yen", 0.8),
+				engineReading("c", "total 120 yen", 0.7),
+			},
+			want:      "total 120 yen",
+			agreement: []float64{1, 2.0 / 3, 1},
+			// total and yen have every vote, 120 only 1.6 of 2.4
+			confidence: (0.8 + 1.6/3 + 0.8) / 3,
+		},
+		{
+			name: "a confident engine beats a weak majority",
+			sources: []*OCRResult{
+				engineReading("a", "the cat sat", 0.9),
+				engineReading("b", "the cot sat", 0.3),
+				engineReading("c", "the cot sat", 0.3),
+			},
+			want:      "the cat sat",
+			agreement: []float64{1, 2.0 / 3, 2.0 / 3},
+		},
+		{
+			name:      "a word missing from one engine is kept",
+			sources:   []*OCRResult{engineReading("a", "pay 100 now", 0.9), engineReading("b", "pay now", 0.8)},
+			want:      "pay 100 now",
+			agreement: []float64{1, 2.0 / 3},
+			// 100 has only the vote of a
+			confidence: (0.85 + 0.45 + 0.85) / 3,
+		},
+		{
+			name:      "a word only a less confident engine read is dropped",
+			sources:   []*OCRResult{engineReading("a", "pay now", 0.9), engineReading("b", "pay 100 now", 0.8)},
+			want:      "pay now",
+			agreement: []float64{1, 2.0 / 3},
+		},
+		{
+			name:      "a tie between words goes to the first reading in order",
+			sources:   []*OCRResult{engineReading("a", "colour", 0.8), engineReading("b", "color", 0.8)},
+			want:      "color",
+			agreement: []float64{0, 1},
+		},
+		{
+			name:      "a tie between a word and the gap keeps the word",
+			sources:   []*OCRResult{engineReading("a", "pay now", 0.8), engineReading("b", "pay 100 now", 0.8)},
+			want:      "pay 100 now",
+			agreement: []float64{2.0 / 3, 1},
+		},
+		{
+			name: "a line missing from one engine is kept",
+			sources: []*OCRResult{
+				engineReading("a", "line one\nline two\nline three", 0.9),
+				engineReading("b", "line one\nline three", 0.8),
+			},
+			want:      "line one\nline two\nline three",
+			agreement: []float64{1, 4.0 / 6},
+		},
+		{
+			name:      "CJK text segmented differently",
+			sources:   []*OCRResult{engineReading("a", "東京都 港区", 0.9), engineReading("b", "東京 都港区", 0.8)},
+			want:      "東京都港区",
+			agreement: []float64{1, 1},
+		},
+		{
+			name:       "an engine without text does not vote",
+			sources:    []*OCRResult{engineReading("a", "hello world", 0.9), engineReading("b", "", 0.8)},
+			want:       "hello world",
+			agreement:  []float64{1, 0},
+			confidence: 0.9,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := BuildEnsembleResult(tt.sources)
+			if err != nil {
+				t.Fatalf("BuildEnsembleResult: %v", err)
+			}
+			if result.EngineName != EnsembleEngineName || result.ExtractedText != tt.want {
+				t.Fatalf("%s result = %q, want %q", result.EngineName, result.ExtractedText, tt.want)
+			}
+			if len(result.EngineAgreement) != len(tt.agreement) {
+				t.Fatalf("agreement = %+v, want %v", result.EngineAgreement, tt.agreement)
+			}
+			for i, a := range result.EngineAgreement {
+				if a.EngineName != tt.sources[i].EngineName || math.Abs(a.Agreement-tt.agreement[i]) > 1e-9 {
+					t.Errorf("agreement of %s = %v over %d words, want %v", a.EngineName, a.Agreement, a.ComparedWords, tt.agreement[i])
+				}
+			}
+			// On a single page the page scores are the result scores, for
+			// the engines that read the page
+			page := result.Pages[0]
+			for _, a := range page.EngineAgreement {
+				if !slices.Contains(result.EngineAgreement, a) {
+					t.Errorf("page agreement %+v differs from %+v", a, result.EngineAgreement)
+				}
+			}
+			if tt.confidence > 0 && math.Abs(page.Confidence-tt.confidence) > 1e-9 {
+				t.Errorf("page confidence = %v, want %v", page.Confidence, tt.confidence)
+			}
+		})
+	}
+}
+
+func TestBuildEnsembleResultLayout(t *testing.T) {
+	t.Setenv("OCR_TABLE_EXTRACTION", "false")
+	word := func(text string, x0, x1 int, confidence float64) OCRWord {
+		return OCRWord{Text: text, BBox: BoundingBox{X0: x0, Y0: 10, X1: x1, Y1: 30}, Confidence: confidence}
+	}
+	layout := func(words ...OCRWord) *OCRPageLayout {
+		return &OCRPageLayout{Width: 600, Height: 400, Blocks: []OCRBlock{{Lines: []OCRLine{{Words: words}}}}}
+	}
+	a := &fakeOCREngine{name: "a", pages: []OCRPage{
+		{PageNumber: 1, Text: "Invoice No", Confidence: 0.9, Layout: layout(word("Invoice", 10, 100, 0.9), word("No", 110, 140, 0.9))},
+		{PageNumber: 2, Text: "Total", Confidence: 0.9},
+	}}
+	b := &fakeOCREngine{name: "b", pages: []OCRPage{
+		{PageNumber: 1, Text: "lnvoice No", Confidence: 0.8, Layout: layout(word("lnvoice", 12, 100, 0.6), word("No", 111, 141, 0.95))},
+	}}
+	sources := []*OCRResult{
+		runOCREngine(context.Background(), b, "scan.pdf", nil),
+		runOCREngine(context.Background(), a, "scan.pdf", nil),
+	}
+	result, err := BuildEnsembleResult(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pages) != 2 || !strings.Contains(result.ExtractedText, "--- Page 2 ---\nTotal") {
+		t.Fatalf("result = %d pages, %q", len(result.Pages), result.ExtractedText)
+	}
+	words := result.Pages[0].Layout.Words()
+	if len(words) != 2 || words[0].Text != "Invoice" || words[1].Text != "No" {
+		t.Fatalf("words = %+v", words)
+	}
+	// Each word keeps the box of its most confident reading
+	if words[0].BBox.X0 != 10 || words[1].BBox.X0 != 111 {
+		t.Fatalf("boxes = %v and %v, want those of a and b", words[0].BBox, words[1].BBox)
+	}
+	// Page 2 was read by a alone, which counts for its agreement only
+	if agreement := result.Pages[1].EngineAgreement; len(agreement) != 1 || agreement[0].EngineName != "a" {
+		t.Fatalf("page 2 agreement = %+v", agreement)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		sources []*OCRResult
+	}{
+		{"one engine", []*OCRResult{sources[0]}},
+		{"failed engine", []*OCRResult{sources[0], {EngineName: "c", Status: "failed"}}},
+		{"previous ensemble", []*OCRResult{sources[0], {EngineName: EnsembleEngineName, Status: "completed"}}},
+	} {
+		if _, err := BuildEnsembleResult(tt.sources); err == nil {
+			t.Errorf("%s: BuildEnsembleResult succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// GroundTruth is the reference text of a file. When Pages is set the file is
+// evaluated page by page; otherwise Text is compared with the whole document.
+type GroundTruth struct {
+	Filename        string
+	StorageProvider string
+	Text            string
+	Pages           []GroundTruthPage
+	UpdatedAt       time.Time
+}
+
+// GroundTruthPage is the reference text of one page.
+type GroundTruthPage struct {
+	PageNumber int    `json:"page_number"`
+	Text       string `json:"text"`
+}
+
+// NewGroundTruth builds a ground truth from plain text. Form feeds separate
+// pages, as in pdftotext output; text without them covers the whole document.
+func NewGroundTruth(filename string, provider string, text string) *GroundTruth {
+	gt := &GroundTruth{Filename: filename, StorageProvider: provider, Text: text}
+	if strings.Contains(text, "\f") {
+		for i, page := range strings.Split(strings.TrimRight(text, "\f\n"), "\f") {
+			gt.Pages = append(gt.Pages, GroundTruthPage{PageNumber: i + 1, Text: page})
+		}
+	}
+	return gt
+}
+
+// TextEvaluation counts errors of recognized text against a reference. Counts
+// add up across pages and files, so aggregates are error rates over all
+// characters and words rather than averages of rates.
+type TextEvaluation struct {
+	RefChars   int `json:"ref_chars"`
+	CharErrors int `json:"char_errors"`
+	RefWords   int `json:"ref_words"`
+	WordErrors int `json:"word_errors"`
+}
+
+// CER is the character error rate: edit distance over reference characters.
+func (e TextEvaluation) CER() float64 {
+	return errorRate(e.CharErrors, e.RefChars)
+}
+
+// WER is the word error rate: word-level edit distance over reference words.
+func (e TextEvaluation) WER() float64 {
+	return errorRate(e.WordErrors, e.RefWords)
+}
+
+// errorRate treats any output for an empty reference as 100% wrong.
+func errorRate(errors, length int) float64 {
+	if length == 0 {
+		if errors == 0 {
+			return 0
+		}
+		return 1
+	}
+	return float64(errors) / float64(length)
+}
+
+// add accumulates another evaluation.
+func (e *TextEvaluation) add(o TextEvaluation) {
+	e.RefChars += o.RefChars
+	e.CharErrors += o.CharErrors
+	e.RefWords += o.RefWords
+	e.WordErrors += o.WordErrors
+}
+
+// EvaluateText compares hypothesis with reference after normalization:
+// full-width ASCII is folded, whitespace runs count as one space, and spaces
+// next to CJK characters are ignored (engines disagree on spacing CJK text).
+// Words are whitespace-separated; each CJK character counts as a word.
+func EvaluateText(reference, hypothesis string) TextEvaluation {
+	refChars := []rune(normalizeEvaluationText(reference))
+	hypChars := []rune(normalizeEvaluationText(hypothesis))
+	refWords := evaluationWords(reference)
+	hypWords := evaluationWords(hypothesis)
+	return TextEvaluation{
+		RefChars:   len(refChars),
+		CharErrors: levenshtein(refChars, hypChars),
+		RefWords:   len(refWords),
+		WordErrors: levenshtein(refWords, hypWords),
+	}
+}
+
+// normalizeEvaluationText applies the normalization described at EvaluateText.
+func normalizeEvaluationText(text string) string {
+	runes := []rune(strings.Join(strings.Fields(foldWidth(text)), " "))
+	var b strings.Builder
+	for i, r := range runes {
+		if r == ' ' && i > 0 && i+1 < len(runes) && (isCJKRune(runes[i-1]) || isCJKRune(runes[i+1])) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// evaluationWords splits normalized text into words, CJK characters separately.
+func evaluationWords(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(foldWidth(text)) {
+		var run []rune
+		for _, r := range field {
+			if isCJKRune(r) {
+				if len(run) > 0 {
+					words = append(words, string(run))
+					run = nil
+				}
+				words = append(words, string(r))
+				continue
+			}
+			run = append(run, r)
+		}
+		if len(run) > 0 {
+			words = append(words, string(run))
+		}
+	}
+	return words
+}
+
+// foldWidth maps full-width ASCII and the ideographic space to their ASCII forms.
+func foldWidth(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E:
+			return r - 0xFEE0
+		case r == 0x3000:
+			return ' '
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// pageMarkerPattern matches the page separators engines put into ExtractedText.
+var pageMarkerPattern = regexp.MustCompile(`(?m)^--- Page \d+ ---$`)
+
+// PageEvaluation is the evaluation of one page.
+type PageEvaluation struct {
+	PageNumber int `json:"page_number"`
+	TextEvaluation
+}
+
+// FileEvaluation is the evaluation of one OCR result.
+type FileEvaluation struct {
+	Filename string `json:"filename"`
+	TextEvaluation
+	Pages []PageEvaluation `json:"pages,omitempty"`
+}
+
+// EvaluateOCRResult compares an OCR result with the ground truth of its file.
+// Pages missing from the result count as empty.
+func EvaluateOCRResult(gt *GroundTruth, result *OCRResult) FileEvaluation {
+	fe := FileEvaluation{Filename: gt.Filename}
+	if len(gt.Pages) == 0 {
+		fe.TextEvaluation = EvaluateText(gt.Text, documentText(result))
+		return fe
+	}
+	hypothesis := map[int]string{}
+	for _, p := range result.Pages {
+		hypothesis[p.PageNumber] = p.Text
+	}
+	if len(result.Pages) == 0 && len(gt.Pages) == 1 {
+		hypothesis[1] = documentText(result)
+	}
+	for _, p := range gt.Pages {
+		pe := PageEvaluation{PageNumber: p.PageNumber, TextEvaluation: EvaluateText(p.Text, hypothesis[p.PageNumber])}
+		fe.Pages = append(fe.Pages, pe)
+		fe.add(pe.TextEvaluation)
+	}
+	return fe
+}
+
+// documentText is the text of the whole result without page separators.
+func documentText(result *OCRResult) string {
+	if len(result.Pages) > 0 {
+		texts := make([]string, 0, len(result.Pages))
+		for _, p := range result.Pages {
+			texts = append(texts, p.Text)
+		}
+		return strings.Join(texts, "\n")
+	}
+	return pageMarkerPattern.ReplaceAllString(result.ExtractedText, "")
+}
+
+// OCREvaluation aggregates the evaluations of one engine version on a dataset.
+type OCREvaluation struct {
+	Dataset           string
+	EngineName        string
+	EngineVersion     string
+	PreprocessProfile string
+	Files             int
+	Pages             int
+	TextEvaluation
+	FileResults []FileEvaluation
+	EvaluatedAt time.Time
+}
+
+// OCREvaluationSet groups file evaluations by engine, version and preprocessing profile.
+type OCREvaluationSet struct {
+	dataset string
+	byKey   map[[3]string]*OCREvaluation
+}
+
+// NewOCREvaluationSet starts an evaluation run on a dataset.
+func NewOCREvaluationSet(dataset string) *OCREvaluationSet {
+	return &OCREvaluationSet{dataset: dataset, byKey: map[[3]string]*OCREvaluation{}}
+}
+
+// Add evaluates a completed OCR result against its ground truth.
+func (s *OCREvaluationSet) Add(gt *GroundTruth, result *OCRResult) FileEvaluation {
+	key := [3]string{result.EngineName, result.EngineVersion, result.PreprocessProfile}
+	ev, ok := s.byKey[key]
+	if !ok {
+		ev = &OCREvaluation{
+			Dataset:           s.dataset,
+			EngineName:        result.EngineName,
+			EngineVersion:     result.EngineVersion,
+			PreprocessProfile: result.PreprocessProfile,
+			EvaluatedAt:       time.Now(),
+		}
+		s.byKey[key] = ev
+	}
+	fe := EvaluateOCRResult(gt, result)
+	ev.Files++
+	ev.Pages += max(len(fe.Pages), 1)
+	ev.add(fe.TextEvaluation)
+	ev.FileResults = append(ev.FileResults, fe)
+	return fe
+}
+
+// Evaluations returns the aggregates ordered by engine name and version.
+func (s *OCREvaluationSet) Evaluations() []*OCREvaluation {
+	out := make([]*OCREvaluation, 0, len(s.byKey))
+	for _, ev := range s.byKey {
+		out = append(out, ev)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].EngineName != out[j].EngineName {
+			return out[i].EngineName < out[j].EngineName
+		}
+		if out[i].EngineVersion != out[j].EngineVersion {
+			return out[i].EngineVersion < out[j].EngineVersion
+		}
+		return out[i].PreprocessProfile < out[j].PreprocessProfile
+	})
+	return out
+}
+
+// StoredResultsDataset names the dataset of uploaded files with ground truth.
+func StoredResultsDataset(provider string) string {
+	return "storage:" + provider
+}
+
+// EvaluateStoredOCRResults evaluates the stored OCR results of files with
+// ground truth and stores the aggregates per engine version. filenames and
+// engineNames restrict the evaluation when not empty; dataset defaults to
+// StoredResultsDataset(provider).
+func EvaluateStoredOCRResults(ctx context.Context, repo OCRResultRepository, provider string, filenames []string, engineNames []string, dataset string) ([]*OCREvaluation, error) {
+	if dataset == "" {
+		dataset = StoredResultsDataset(provider)
+	}
+	if len(filenames) == 0 {
+		var err error
+		filenames, err = repo.ListGroundTruth(ctx, provider)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	set := NewOCREvaluationSet(dataset)
+	for _, filename := range filenames {
+		gt, err := repo.GetGroundTruth(ctx, filename, provider)
+		if err != nil {
+			return nil, err
+		}
+		if gt == nil {
+			return nil, fmt.Errorf("no ground truth for %s", filename)
+		}
+		results, err := repo.GetOCRComparison(ctx, filename, provider)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.Status != "completed" || result.EngineName == RedactedEngineName || (len(engineNames) > 0 && !containsLanguage(engineNames, result.EngineName)) {
+				continue
+			}
+			set.Add(gt, result)
+		}
+	}
+
+	evaluations := set.Evaluations()
+	for _, ev := range evaluations {
+		if err := repo.SaveOCREvaluation(ctx, ev); err != nil {
+			return nil, err
+		}
+	}
+	return evaluations, nil
+}
+
+// OCREvaluationToProto converts an evaluation for gRPC responses; file results
+// are included when present.
+func OCREvaluationToProto(ev *OCREvaluation) *pb.OCREvaluation {
+	out := &pb.OCREvaluation{
+		Dataset:           ev.Dataset,
+		EngineName:        ev.EngineName,
+		EngineVersion:     ev.EngineVersion,
+		PreprocessProfile: ev.PreprocessProfile,
+		Files:             int32(ev.Files),
+		Pages:             int32(ev.Pages),
+		Cer:               ev.CER(),
+		Wer:               ev.WER(),
+		RefChars:          int32(ev.RefChars),
+		CharErrors:        int32(ev.CharErrors),
+		RefWords:          int32(ev.RefWords),
+		WordErrors:        int32(ev.WordErrors),
+		EvaluatedAt:       ev.EvaluatedAt.Unix(),
+	}
+	for _, fe := range ev.FileResults {
+		f := &pb.FileEvaluation{
+			Filename: fe.Filename,
+			Cer:      fe.CER(),
+			Wer:      fe.WER(),
+			RefChars: int32(fe.RefChars),
+			RefWords: int32(fe.RefWords),
+		}
+		for _, pe := range fe.Pages {
+			f.Pages = append(f.Pages, &pb.PageEvaluation{
+				PageNumber: int32(pe.PageNumber),
+				Cer:        pe.CER(),
+				Wer:        pe.WER(),
+				RefChars:   int32(pe.RefChars),
+				RefWords:   int32(pe.RefWords),
+			})
+		}
+		out.FileResults = append(out.FileResults, f)
+	}
+	return out
+}
+
+// GroundTruthFromProto converts a SetGroundTruth request.
+func GroundTruthFromProto(req *pb.GroundTruthRequest) *GroundTruth {
+	if len(req.Pages) == 0 {
+		return NewGroundTruth(req.Filename, req.StorageProvider, req.Text)
+	}
+	gt := &GroundTruth{Filename: req.Filename, StorageProvider: req.StorageProvider, Text: req.Text}
+	for _, p := range req.Pages {
+		gt.Pages = append(gt.Pages, GroundTruthPage{PageNumber: int(p.PageNumber), Text: p.Text})
+	}
+	sort.Slice(gt.Pages, func(i, j int) bool { return gt.Pages[i].PageNumber < gt.Pages[j].PageNumber })
+	return gt
+}
+
+// EvaluateOCRFromProto serves an EvaluateOCR request from the shared result
+// database: it evaluates the stored results and returns them with the stored
+// history of the dataset.
+//
+// Tenant callers only evaluate their own files, and their datasets are kept
+// under their tenant's prefix.
+func EvaluateOCRFromProto(ctx context.Context, repo OCRResultRepository, req *pb.EvaluateOCRRequest) (*pb.EvaluateOCRResponse, error) {
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	dataset := req.Dataset
+	if dataset == "" {
+		dataset = StoredResultsDataset(provider)
+	}
+	filenames := make([]string, 0, len(req.Filenames))
+	for _, filename := range req.Filenames {
+		filenames = append(filenames, ScopeFilename(ctx, filename))
+	}
+	if tenant := CallerTenant(ctx); tenant != "" {
+		dataset = TenantFileKey(tenant, dataset)
+		if len(filenames) == 0 {
+			labelled, err := repo.ListGroundTruth(ctx, provider)
+			if err != nil {
+				return nil, err
+			}
+			for _, filename := range labelled {
+				if VisibleToCaller(ctx, filename) {
+					filenames = append(filenames, filename)
+				}
+			}
+			if len(filenames) == 0 {
+				return &pb.EvaluateOCRResponse{}, nil
+			}
+		}
+	}
+	evaluations, err := EvaluateStoredOCRResults(ctx, repo, provider, filenames, req.EngineNames, dataset)
+	if err != nil {
+		return nil, err
+	}
+	history, err := repo.ListOCREvaluations(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.EvaluateOCRResponse{}
+	for _, ev := range evaluations {
+		resp.Evaluations = append(resp.Evaluations, OCREvaluationToProto(ev))
+	}
+	for _, ev := range history {
+		resp.History = append(resp.History, OCREvaluationToProto(ev))
+	}
+	return resp, nil
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEvaluateText(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		reference  string
+		hypothesis string
+		want       TextEvaluation
+	}{
+		{"exact", "hello world", "hello world", TextEvaluation{RefChars: 11, RefWords: 2}},
+		{"one substitution", "hello world", "hellp world", TextEvaluation{RefChars: 11, CharErrors: 1, RefWords: 2, WordErrors: 1}},
+		{"deletion and insertion", "abc def", "ab defg", TextEvaluation{RefChars: 7, CharErrors: 2, RefWords: 2, WordErrors: 2}},
+		{"whitespace runs", "hello  world\n", " hello\tworld", TextEvaluation{RefChars: 11, RefWords: 2}},
+		{"full-width ASCII", "ABC 123", "ＡＢＣ　１２３", TextEvaluation{RefChars: 7, RefWords: 2}},
+		{"spaces around CJK", "東京 タワー", "東京タワー", TextEvaluation{RefChars: 5, RefWords: 5}},
+		{"CJK characters are words", "東京都", "東京府", TextEvaluation{RefChars: 3, CharErrors: 1, RefWords: 3, WordErrors: 1}},
+		{"mixed scripts", "Tokyo東京", "Tokyo 東京", TextEvaluation{RefChars: 7, RefWords: 3}},
+		{"empty hypothesis", "abc", "", TextEvaluation{RefChars: 3, CharErrors: 3, RefWords: 1, WordErrors: 1}},
+		{"empty reference", "", "abc", TextEvaluation{CharErrors: 3, WordErrors: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EvaluateText(tt.reference, tt.hypothesis); got != tt.want {
+				t.Fatalf("EvaluateText(%q, %q) = %+v, want %+v", tt.reference, tt.hypothesis, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextEvaluationRates(t *testing.T) {
+	for _, tt := range []struct {
+		eval     TextEvaluation
+		cer, wer float64
+	}{
+		{TextEvaluation{RefChars: 10, CharErrors: 1, RefWords: 4, WordErrors: 1}, 0.1, 0.25},
+		{TextEvaluation{}, 0, 0},
+		{TextEvaluation{CharErrors: 2, WordErrors: 1}, 1, 1},
+		{TextEvaluation{RefChars: 2, CharErrors: 5, RefWords: 1, WordErrors: 3}, 2.5, 3},
+	} {
+		if cer, wer := tt.eval.CER(), tt.eval.WER(); math.Abs(cer-tt.cer) > 1e-9 || math.Abs(wer-tt.wer) > 1e-9 {
+			t.Errorf("%+v: CER %g, WER %g; want %g, %g", tt.eval, cer, wer, tt.cer, tt.wer)
+		}
+	}
+}
+
+func TestEvaluateOCRResult(t *testing.T) {
+	gt := &GroundTruth{Filename: "scan.pdf", Pages: []GroundTruthPage{{PageNumber: 1, Text: "first"}, {PageNumber: 2, Text: "second"}}}
+	for _, tt := range []struct {
+		name   string
+		result *OCRResult
+		want   TextEvaluation
+	}{
+		{"pages match", &OCRResult{Pages: []OCRPage{{PageNumber: 1, Text: "first"}, {PageNumber: 2, Text: "second"}}},
+			TextEvaluation{RefChars: 11, RefWords: 2}},
+		{"missing page counts as empty", &OCRResult{Pages: []OCRPage{{PageNumber: 1, Text: "first"}}},
+			TextEvaluation{RefChars: 11, CharErrors: 6, RefWords: 2, WordErrors: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EvaluateOCRResult(gt, tt.result)
+			if got.TextEvaluation != tt.want || len(got.Pages) != 2 {
+				t.Fatalf("EvaluateOCRResult = %+v, want %+v over 2 pages", got, tt.want)
+			}
+		})
+	}
+
+	// Without pages, the whole text is compared, without page separators
+	whole := &GroundTruth{Filename: "scan.png", Text: "hello world"}
+	got := EvaluateOCRResult(whole, &OCRResult{ExtractedText: "--- Page 1 ---\nhello world"})
+	if got.TextEvaluation != (TextEvaluation{RefChars: 11, RefWords: 2}) {
+		t.Fatalf("EvaluateOCRResult of the whole text = %+v", got.TextEvaluation)
+	}
+}
@@ -0,0 +1,344 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ocrLanguage maps a language code (ISO 639-1, as used in OCRRequest.languages)
+// to the model names of each engine.
+type ocrLanguage struct {
+	Code      string
+	Tesseract string
+	EasyOCR   string
+}
+
+var ocrLanguages = []ocrLanguage{
+	{Code: "en", Tesseract: "eng", EasyOCR: "en"},
+	{Code: "ja", Tesseract: "jpn", EasyOCR: "ja"},
+	{Code: "ko", Tesseract: "kor", EasyOCR: "ko"},
+	{Code: "zh", Tesseract: "chi_sim", EasyOCR: "ch_sim"},
+	{Code: "zh-tw", Tesseract: "chi_tra", EasyOCR: "ch_tra"},
+	{Code: "de", Tesseract: "deu", EasyOCR: "de"},
+	{Code: "fr", Tesseract: "fra", EasyOCR: "fr"},
+	{Code: "es", Tesseract: "spa", EasyOCR: "es"},
+	{Code: "ru", Tesseract: "rus", EasyOCR: "ru"},
+}
+
+// lookupOCRLanguage accepts a language code or an engine-specific model name.
+func lookupOCRLanguage(name string) (ocrLanguage, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, l := range ocrLanguages {
+		if name == l.Code || name == l.Tesseract || name == l.EasyOCR {
+			return l, true
+		}
+	}
+	return ocrLanguage{}, false
+}
+
+// ParseOCRLanguages normalizes language names to codes. Entries may be codes
+// ("ko"), Tesseract packs ("kor", "jpn+eng") or EasyOCR names ("ch_sim").
+func ParseOCRLanguages(names []string) ([]string, error) {
+	var codes []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '+' || r == ',' || unicode.IsSpace(r) }) {
+			l, ok := lookupOCRLanguage(part)
+			if !ok {
+				return nil, fmt.Errorf("unsupported OCR language: %s", part)
+			}
+			if !seen[l.Code] {
+				seen[l.Code] = true
+				codes = append(codes, l.Code)
+			}
+		}
+	}
+	return codes, nil
+}
+
+// tesseractLanguageSpec joins language codes into a Tesseract spec such as "kor+eng".
+func tesseractLanguageSpec(codes []string) string {
+	packs := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if l, ok := lookupOCRLanguage(code); ok {
+			packs = append(packs, l.Tesseract)
+		}
+	}
+	return strings.Join(packs, "+")
+}
+
+// easyOCRLanguageList converts language codes into EasyOCR language names.
+func easyOCRLanguageList(codes []string) []string {
+	names := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if l, ok := lookupOCRLanguage(code); ok {
+			names = append(names, l.EasyOCR)
+		}
+	}
+	return names
+}
+
+type ocrLanguagesKey struct{}
+
+// WithOCRLanguages returns a context that makes engines recognize the given
+// languages instead of detecting them.
+func WithOCRLanguages(ctx context.Context, codes []string) context.Context {
+	if len(codes) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ocrLanguagesKey{}, codes)
+}
+
+// OCRLanguagesFromContext returns the languages set by WithOCRLanguages, if any.
+func OCRLanguagesFromContext(ctx context.Context) []string {
+	codes, _ := ctx.Value(ocrLanguagesKey{}).([]string)
+	return codes
+}
+
+// MultilingualOCREngine is implemented by engines whose recognition languages
+// can be chosen per document. The languages in effect come from the context.
+type MultilingualOCREngine interface {
+	OCREngine
+
+	// DefaultLanguages is used when detection is inconclusive.
+	DefaultLanguages() []string
+
+	// SupportsLanguage reports whether the engine has a model for the code.
+	SupportsLanguage(code string) bool
+
+	// LanguageProbes lists the language sets run on a sample page during
+	// detection. Engines that can mix all scripts in one pass return one set.
+	LanguageProbes() [][]string
+}
+
+// TextLanguage is the result of script and language detection on text.
+type TextLanguage struct {
+	Code       string  // detected language code, "" when there is no usable text
+	Script     string  // "latin", "japanese", "hangul", "han", "cyrillic"
+	Letters    int     // letters of the detected script
+	Confidence float64 // share of letters belonging to the detected script
+}
+
+// latinStopwords are frequent function words used to tell Latin-script languages apart.
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "for", "with", "this", "are", "on"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "von", "den", "zu", "ein", "eine", "für", "auf", "sich", "im"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "pour", "que", "dans", "du", "au", "pas", "sur"},
+	"es": {"el", "los", "las", "y", "que", "es", "por", "para", "una", "con", "del", "se", "al", "como"},
+}
+
+// latinMarks are letters characteristic of one language.
+var latinMarks = map[string]string{
+	"de": "äöüß",
+	"fr": "éèêçàùœ",
+	"es": "ñ¿¡áíóú",
+}
+
+// DetectTextLanguage identifies the dominant script of text and, for Latin
+// script, the language by stopword frequency.
+func DetectTextLanguage(text string) TextLanguage {
+	var latin, kana, han, hangul, cyrillic int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	total := latin + kana + han + hangul + cyrillic
+	if total == 0 {
+		return TextLanguage{}
+	}
+
+	best := TextLanguage{Code: "zh", Script: "han", Letters: han}
+	// Japanese mixes kana into kanji text; Chinese has none.
+	if kana > 0 && kana*10 >= kana+han {
+		best = TextLanguage{Code: "ja", Script: "japanese", Letters: kana + han}
+	}
+	if hangul > best.Letters {
+		best = TextLanguage{Code: "ko", Script: "hangul", Letters: hangul}
+	}
+	if cyrillic > best.Letters {
+		best = TextLanguage{Code: "ru", Script: "cyrillic", Letters: cyrillic}
+	}
+	// One CJK character carries roughly a word; Latin needs far more letters to dominate.
+	if best.Letters == 0 || (best.Script != "cyrillic" && best.Letters*6 < latin) || (best.Script == "cyrillic" && best.Letters < latin) {
+		best = TextLanguage{Code: detectLatinLanguage(text), Script: "latin", Letters: latin}
+	}
+	best.Confidence = float64(best.Letters) / float64(total)
+	return best
+}
+
+// detectLatinLanguage scores stopwords and characteristic letters; English wins ties.
+func detectLatinLanguage(text string) string {
+	lower := strings.ToLower(text)
+	scores := map[string]int{}
+	for _, word := range strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for code, stopwords := range latinStopwords {
+			for _, sw := range stopwords {
+				if word == sw {
+					scores[code]++
+				}
+			}
+		}
+	}
+	for code, marks := range latinMarks {
+		for _, r := range lower {
+			if strings.ContainsRune(marks, r) {
+				scores[code]++
+			}
+		}
+	}
+	best := "en"
+	for _, code := range []string{"de", "fr", "es"} {
+		if scores[code] > scores[best] {
+			best = code
+		}
+	}
+	return best
+}
+
+// maxLanguageSamples bounds how many leading pages are considered for the probe.
+const maxLanguageSamples = 3
+
+// resolveDocumentLanguages decides the recognition languages for a document and
+// records them on result. An override from the context wins; otherwise the
+// language is detected from native text when there is any, or from a quick OCR
+// pass over the first non-blank sample page. The returned context carries the
+// chosen languages for the engine.
+func resolveDocumentLanguages(ctx context.Context, engine OCREngine, result *OCRResult, nativeText string, samples []image.Image) context.Context {
+	me, ok := engine.(MultilingualOCREngine)
+	if !ok {
+		return ctx
+	}
+	if override := OCRLanguagesFromContext(ctx); len(override) > 0 {
+		result.Languages = override
+		return ctx
+	}
+
+	detected := DetectTextLanguage(nativeText)
+	if detected.Code == "" {
+		detected = probeImageLanguage(ctx, me, samples)
+	}
+	languages := me.DefaultLanguages()
+	if detected.Code != "" {
+		result.DetectedLanguage = detected.Code
+		if me.SupportsLanguage(detected.Code) {
+			languages = []string{detected.Code}
+			if detected.Code != "en" && me.SupportsLanguage("en") {
+				languages = append(languages, "en")
+			}
+		} else {
+			log.Printf("Detected language %s is not installed for %s, using %v", detected.Code, engine.Name(), languages)
+		}
+		log.Printf("Detected language for %s: %s (script=%s, confidence=%.2f)", result.Filename, detected.Code, detected.Script, detected.Confidence)
+	}
+	result.Languages = languages
+	return WithOCRLanguages(ctx, languages)
+}
+
+// probeImageLanguage OCRs a sample page with each of the engine's probe sets and
+// keeps the detection backed by the most confidently recognized letters. A probe
+// can only vouch for its own languages: a Korean page read with a Japanese model
+// yields low-confidence kanji, not a Japanese detection.
+func probeImageLanguage(ctx context.Context, engine MultilingualOCREngine, samples []image.Image) TextLanguage {
+	var sample image.Image
+	for i, img := range samples {
+		if i >= maxLanguageSamples {
+			break
+		}
+		if newInkMask(img, analysisMaxSide).points() != nil {
+			sample = img
+			break
+		}
+	}
+	if sample == nil {
+		return TextLanguage{}
+	}
+
+	var best TextLanguage
+	bestScore := 0.0
+	for _, probe := range engine.LanguageProbes() {
+		rec, err := recognizeImage(WithOCRLanguages(ctx, probe), engine, sample, 0)
+		if err != nil {
+			log.Printf("Language probe %v failed for %s: %v", probe, engine.Name(), err)
+			continue
+		}
+		detected := DetectTextLanguage(rec.Text)
+		if detected.Code == "" || !probeCovers(probe, detected.Code) {
+			continue
+		}
+		if score := float64(detected.Letters) * rec.Confidence; score > bestScore {
+			best, bestScore = detected, score
+		}
+	}
+	return best
+}
+
+// probeCovers reports whether a probe set can produce the detected language.
+// Latin languages are recognized well enough by the English model to tell them apart.
+func probeCovers(probe []string, code string) bool {
+	for _, p := range probe {
+		if p == code {
+			return true
+		}
+		if p == "en" {
+			if _, latin := latinStopwords[code]; latin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tessdataDirs are the usual locations of Tesseract language packs.
+var tessdataDirs = []string{
+	"/usr/share/tessdata",
+	"/usr/share/tesseract-ocr/5/tessdata",
+	"/usr/share/tesseract-ocr/4.00/tessdata",
+	"/usr/local/share/tessdata",
+}
+
+// installedTesseractLanguages lists language codes with an installed pack, or
+// nil when no tessdata directory can be found.
+func installedTesseractLanguages() []string {
+	dirs := tessdataDirs
+	if prefix := os.Getenv("TESSDATA_PREFIX"); prefix != "" {
+		dirs = append([]string{prefix, filepath.Join(prefix, "tessdata")}, dirs...)
+	}
+	for _, dir := range dirs {
+		var codes []string
+		for _, l := range ocrLanguages {
+			if _, err := os.Stat(filepath.Join(dir, l.Tesseract+".traineddata")); err == nil {
+				codes = append(codes, l.Code)
+			}
+		}
+		if len(codes) > 0 {
+			return codes
+		}
+	}
+	return nil
+}
+
+// containsLanguage reports whether codes contains code.
+func containsLanguage(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
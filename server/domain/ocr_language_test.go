@@ -0,0 +1,192 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestParseOCRLanguages(t *testing.T) {
+	for _, tt := range []struct {
+		names   []string
+		want    string
+		wantErr string
+	}{
+		{[]string{"ko"}, "[ko]", ""},
+		{[]string{"jpn+eng"}, "[ja en]", ""},
+		{[]string{"ch_sim", "zh", "chi_sim"}, "[zh]", ""},
+		{[]string{" KOR, eng "}, "[ko en]", ""},
+		{[]string{"zh-tw", "ch_tra"}, "[zh-tw]", ""},
+		{nil, "[]", ""},
+		{[]string{"eng+xx"}, "", "unsupported OCR language: xx"},
+	} {
+		codes, err := ParseOCRLanguages(tt.names)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ParseOCRLanguages(%q) err = %v, want %q", tt.names, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(codes) != tt.want {
+			t.Errorf("ParseOCRLanguages(%q) = %v, %v; want %s", tt.names, codes, err, tt.want)
+		}
+	}
+	if spec := tesseractLanguageSpec([]string{"ko", "en"}); spec != "kor+eng" {
+		t.Errorf("tesseractLanguageSpec = %q", spec)
+	}
+	if names := easyOCRLanguageList([]string{"zh-tw", "en"}); fmt.Sprint(names) != "[ch_tra en]" {
+		t.Errorf("easyOCRLanguageList = %v", names)
+	}
+}
+
+func TestDetectTextLanguage(t *testing.T) {
+	for _, tt := range []struct {
+		text, code, script string
+	}{
+		{"안녕하세요. 서울특별시 강남구", "ko", "hangul"},
+		{"請求書を送付いたします。ご確認ください。", "ja", "japanese"},
+		{"东京都港区的会议记录", "zh", "han"},
+		{"Счёт на оплату услуг", "ru", "cyrillic"},
+		{"This is the invoice for the services of March.", "en", "latin"},
+		{"Die Rechnung ist nicht mit der Lieferung gekommen.", "de", "latin"},
+		{"La facture est dans le dossier pour la comptabilité.", "fr", "latin"},
+		{"El informe de los pagos para la empresa.", "es", "latin"},
+		// A few Latin words do not outweigh Japanese text
+		{"PDF ファイルを Google Drive に保存しました", "ja", "japanese"},
+		// Without stopwords Latin text is read as English
+		{"ACME 2024 INV-001", "en", "latin"},
+		{"12345 !?", "", ""},
+		{"", "", ""},
+	} {
+		got := DetectTextLanguage(tt.text)
+		if got.Code != tt.code || got.Script != tt.script {
+			t.Errorf("DetectTextLanguage(%q) = %s/%s, want %s/%s", tt.text, got.Code, got.Script, tt.code, tt.script)
+		}
+		if tt.code != "" && (got.Confidence <= 0 || got.Confidence > 1) {
+			t.Errorf("DetectTextLanguage(%q) confidence = %v", tt.text, got.Confidence)
+		}
+	}
+}
+
+// fakeMultilingualEngine reads what each language set would make of a page.
+type fakeMultilingualEngine struct {
+	fakeOCREngine
+	supported []string
+	probes    [][]string
+	readings  map[string]string // languages joined by "+" -> text
+	probed    []string
+}
+
+func (e *fakeMultilingualEngine) ProcessImage(ctx context.Context, img image.Image) (string, float64, error) {
+	languages := strings.Join(OCRLanguagesFromContext(ctx), "+")
+	e.probed = append(e.probed, languages)
+	return e.readings[languages], 0.9, nil
+}
+
+func (e *fakeMultilingualEngine) DefaultLanguages() []string {
+	return []string{"en"}
+}
+
+func (e *fakeMultilingualEngine) SupportsLanguage(code string) bool {
+	return containsLanguage(e.supported, code)
+}
+
+func (e *fakeMultilingualEngine) LanguageProbes() [][]string {
+	return e.probes
+}
+
+func TestResolveDocumentLanguages(t *testing.T) {
+	text := newPage(800, 1000)
+	printLines(text, "HELL ELF IT", "FIT THE TILE")
+	blank := newPage(800, 1000)
+	cjk := func() *fakeMultilingualEngine {
+		return &fakeMultilingualEngine{
+			fakeOCREngine: fakeOCREngine{name: "multilingual"},
+			supported:     []string{"en", "ja", "ko", "zh"},
+			probes:        [][]string{{"ja", "en"}, {"ko", "en"}},
+			// A Korean page read with the Japanese model comes out as kanji
+			readings: map[string]string{"ja+en": "口口日日口", "ko+en": "서울특별시 강남구"},
+		}
+	}
+	for _, tt := range []struct {
+		name      string
+		ctx       context.Context
+		engine    OCREngine
+		native    string
+		samples   []image.Image
+		languages string
+		detected  string
+		probes    string
+	}{
+		{
+			name:      "Korean native text",
+			engine:    cjk(),
+			native:    "안녕하세요. 서울특별시 강남구",
+			samples:   []image.Image{text},
+			languages: "[ko en]",
+			detected:  "ko",
+		},
+		{
+			name:      "English native text",
+			engine:    cjk(),
+			native:    "This is the invoice for March.",
+			languages: "[en]",
+			detected:  "en",
+		},
+		{
+			name:      "Korean page probed with each language set",
+			engine:    cjk(),
+			samples:   []image.Image{blank, text},
+			languages: "[ko en]",
+			detected:  "ko",
+			probes:    "ja+en ko+en",
+		},
+		{
+			name:      "detected language without a model",
+			engine:    cjk(),
+			native:    "Счёт на оплату услуг",
+			languages: "[en]",
+			detected:  "ru",
+		},
+		{
+			name:      "blank pages keep the defaults",
+			engine:    cjk(),
+			samples:   []image.Image{blank, blank},
+			languages: "[en]",
+		},
+		{
+			name:      "languages requested by the caller",
+			ctx:       WithOCRLanguages(context.Background(), []string{"ja"}),
+			engine:    cjk(),
+			native:    "안녕하세요",
+			languages: "[ja]",
+		},
+		{
+			name:      "engine without language selection",
+			engine:    &fakeOCREngine{name: "fixed"},
+			native:    "안녕하세요",
+			languages: "[]",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			result := &OCRResult{Filename: "scan.pdf"}
+			ctx = resolveDocumentLanguages(ctx, tt.engine, result, tt.native, tt.samples)
+			if fmt.Sprint(result.Languages) != tt.languages || result.DetectedLanguage != tt.detected {
+				t.Fatalf("languages %v, detected %q; want %s, %q", result.Languages, result.DetectedLanguage, tt.languages, tt.detected)
+			}
+			// The engine runs with the recorded languages
+			if fmt.Sprint(OCRLanguagesFromContext(ctx)) != tt.languages {
+				t.Fatalf("context languages = %v, want %s", OCRLanguagesFromContext(ctx), tt.languages)
+			}
+			if engine, ok := tt.engine.(*fakeMultilingualEngine); ok && strings.Join(engine.probed, " ") != tt.probes {
+				t.Fatalf("probed %q, want %q", engine.probed, tt.probes)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package domain
+
+import (
+	"context"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// BoundingBox is an axis-aligned box in page pixel coordinates (X1/Y1 exclusive).
+type BoundingBox struct {
+	X0 int `json:"x0"`
+	Y0 int `json:"y0"`
+	X1 int `json:"x1"`
+	Y1 int `json:"y1"`
+}
+
+// Width returns the box width.
+func (b BoundingBox) Width() int { return b.X1 - b.X0 }
+
+// Height returns the box height.
+func (b BoundingBox) Height() int { return b.Y1 - b.Y0 }
+
+// Empty reports whether the box has no area.
+func (b BoundingBox) Empty() bool { return b.X1 <= b.X0 || b.Y1 <= b.Y0 }
+
+// Union returns the smallest box containing both boxes.
+func (b BoundingBox) Union(o BoundingBox) BoundingBox {
+	if b.Empty() {
+		return o
+	}
+	if o.Empty() {
+		return b
+	}
+	return BoundingBox{
+		X0: min(b.X0, o.X0),
+		Y0: min(b.Y0, o.Y0),
+		X1: max(b.X1, o.X1),
+		Y1: max(b.Y1, o.Y1),
+	}
+}
+
+// boundingBoxFromRect converts an image.Rectangle.
+func boundingBoxFromRect(r image.Rectangle) BoundingBox {
+	return BoundingBox{X0: r.Min.X, Y0: r.Min.Y, X1: r.Max.X, Y1: r.Max.Y}
+}
+
+// OCRWord is a recognized word with its position and confidence (0.0-1.0).
+type OCRWord struct {
+	Text       string      `json:"text"`
+	BBox       BoundingBox `json:"bbox"`
+	Confidence float64     `json:"confidence"`
+}
+
+// OCRLine is a line of words.
+type OCRLine struct {
+	BBox  BoundingBox `json:"bbox"`
+	Words []OCRWord   `json:"words"`
+}
+
+// OCRBlock is a block (paragraph/column region) of lines.
+type OCRBlock struct {
+	BBox  BoundingBox `json:"bbox"`
+	Lines []OCRLine   `json:"lines"`
+}
+
+// OCRPageLayout is the structured layout of one page.
+type OCRPageLayout struct {
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Blocks []OCRBlock `json:"blocks"`
+}
+
+// OCRLayoutPage pairs a page layout with its page number.
+type OCRLayoutPage struct {
+	PageNumber int `json:"page_number"`
+	OCRPageLayout
+}
+
+// OCRLayout is the layout of a whole OCR result.
+type OCRLayout struct {
+	Filename        string          `json:"filename"`
+	StorageProvider string          `json:"storage_provider"`
+	EngineName      string          `json:"engine_name"`
+	Pages           []OCRLayoutPage `json:"pages"`
+}
+
+// LayoutOCREngine is implemented by engines that report word-level geometry.
+type LayoutOCREngine interface {
+	OCREngine
+
+	// ProcessImageWithLayout is ProcessImage plus the page layout. Like ProcessImage it
+	// OCRs the image as given; preprocessing is applied by the caller.
+	ProcessImageWithLayout(ctx context.Context, img image.Image) (string, float64, *OCRPageLayout, error)
+}
+
+// Words returns all words of the page in reading order.
+func (l *OCRPageLayout) Words() []OCRWord {
+	if l == nil {
+		return nil
+	}
+	var words []OCRWord
+	for _, block := range l.Blocks {
+		for _, line := range block.Lines {
+			words = append(words, line.Words...)
+		}
+	}
+	return words
+}
+
+// MeanConfidence returns the mean word confidence, or 0 when there are no words.
+func (l *OCRPageLayout) MeanConfidence() float64 {
+	words := l.Words()
+	if len(words) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, w := range words {
+		sum += w.Confidence
+	}
+	return sum / float64(len(words))
+}
+
+// Text renders the layout as plain text: lines separated by newlines, blocks by blank lines.
+func (l *OCRPageLayout) Text() string {
+	if l == nil {
+		return ""
+	}
+	blocks := make([]string, 0, len(l.Blocks))
+	for _, block := range l.Blocks {
+		lines := make([]string, 0, len(block.Lines))
+		for _, line := range block.Lines {
+			words := make([]string, 0, len(line.Words))
+			for _, w := range line.Words {
+				words = append(words, w.Text)
+			}
+			lines = append(lines, strings.Join(words, " "))
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// recomputeBoxes derives line and block boxes from their words.
+func (l *OCRPageLayout) recomputeBoxes() {
+	for bi := range l.Blocks {
+		block := &l.Blocks[bi]
+		block.BBox = BoundingBox{}
+		for li := range block.Lines {
+			line := &block.Lines[li]
+			line.BBox = BoundingBox{}
+			for _, w := range line.Words {
+				line.BBox = line.BBox.Union(w.BBox)
+			}
+			block.BBox = block.BBox.Union(line.BBox)
+		}
+	}
+}
+
+// layoutWord is a word tagged with its engine-reported block, paragraph and line numbers.
+type layoutWord struct {
+	OCRWord
+	Block, Par, Line int
+}
+
+// buildLayoutFromNumberedWords groups words by the block/paragraph/line numbers reported
+// by the engine (Tesseract TSV output), keeping the engine's reading order.
+func buildLayoutFromNumberedWords(width, height int, words []layoutWord) *OCRPageLayout {
+	layout := &OCRPageLayout{Width: width, Height: height}
+	blockIndex := map[int]int{}
+	lineIndex := map[[3]int]int{}
+	for _, w := range words {
+		if strings.TrimSpace(w.Text) == "" {
+			continue
+		}
+		bi, ok := blockIndex[w.Block]
+		if !ok {
+			bi = len(layout.Blocks)
+			blockIndex[w.Block] = bi
+			layout.Blocks = append(layout.Blocks, OCRBlock{})
+		}
+		key := [3]int{w.Block, w.Par, w.Line}
+		li, ok := lineIndex[key]
+		if !ok {
+			li = len(layout.Blocks[bi].Lines)
+			lineIndex[key] = li
+			layout.Blocks[bi].Lines = append(layout.Blocks[bi].Lines, OCRLine{})
+		}
+		line := &layout.Blocks[bi].Lines[li]
+		line.Words = append(line.Words, w.OCRWord)
+	}
+	layout.recomputeBoxes()
+	return layout
+}
+
+// textDetection is a line-level detection as reported by detector-style
+// engines (EasyOCR, external engines): one box for a run of text.
+type textDetection struct {
+	Text       string
+	BBox       BoundingBox
+	Confidence float64
+}
+
+// buildLayoutFromDetections turns line-level detections into a layout. Detections
+// whose vertical centers overlap are merged into lines, lines separated by a large
+// gap start a new block, and each detection is split into words with boxes
+// proportional to character counts.
+func buildLayoutFromDetections(width, height int, detections []textDetection) *OCRPageLayout {
+	layout := &OCRPageLayout{Width: width, Height: height}
+	var dets []textDetection
+	for _, d := range detections {
+		if strings.TrimSpace(d.Text) != "" && !d.BBox.Empty() {
+			dets = append(dets, d)
+		}
+	}
+	if len(dets) == 0 {
+		return layout
+	}
+	sort.SliceStable(dets, func(i, j int) bool {
+		return dets[i].BBox.Y0+dets[i].BBox.Y1 < dets[j].BBox.Y0+dets[j].BBox.Y1
+	})
+
+	// Group into lines by vertical center overlap.
+	var lines [][]textDetection
+	var lineBoxes []BoundingBox
+	for _, d := range dets {
+		center := (d.BBox.Y0 + d.BBox.Y1) / 2
+		placed := false
+		for i, lb := range lineBoxes {
+			if center >= lb.Y0 && center < lb.Y1 {
+				lines[i] = append(lines[i], d)
+				lineBoxes[i] = lb.Union(d.BBox)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			lines = append(lines, []textDetection{d})
+			lineBoxes = append(lineBoxes, d.BBox)
+		}
+	}
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return lineBoxes[order[a]].Y0 < lineBoxes[order[b]].Y0 })
+
+	// Median line height decides block breaks.
+	heights := make([]int, len(lineBoxes))
+	for i, lb := range lineBoxes {
+		heights[i] = lb.Height()
+	}
+	sort.Ints(heights)
+	medianHeight := heights[len(heights)/2]
+
+	var block *OCRBlock
+	prevBottom := math.MinInt
+	for _, idx := range order {
+		lineDets := lines[idx]
+		sort.SliceStable(lineDets, func(a, b int) bool { return lineDets[a].BBox.X0 < lineDets[b].BBox.X0 })
+		var line OCRLine
+		for _, d := range lineDets {
+			line.Words = append(line.Words, splitDetectionIntoWords(d)...)
+		}
+		if block == nil || lineBoxes[idx].Y0-prevBottom > medianHeight*3/2 {
+			layout.Blocks = append(layout.Blocks, OCRBlock{})
+			block = &layout.Blocks[len(layout.Blocks)-1]
+		}
+		block.Lines = append(block.Lines, line)
+		prevBottom = lineBoxes[idx].Y1
+	}
+	layout.recomputeBoxes()
+	return layout
+}
+
+// splitDetectionIntoWords splits a detection on whitespace and assigns each word
+// a horizontal slice of the detection box proportional to its length. Text
+// without spaces (e.g. Japanese) stays a single word.
+func splitDetectionIntoWords(d textDetection) []OCRWord {
+	fields := strings.Fields(d.Text)
+	if len(fields) <= 1 {
+		return []OCRWord{{Text: strings.TrimSpace(d.Text), BBox: d.BBox, Confidence: d.Confidence}}
+	}
+	total := 0
+	for _, f := range fields {
+		total += utf8.RuneCountInString(f)
+	}
+	total += len(fields) - 1 // spaces
+	width := float64(d.BBox.Width())
+	words := make([]OCRWord, 0, len(fields))
+	pos := 0
+	for _, f := range fields {
+		n := utf8.RuneCountInString(f)
+		x0 := d.BBox.X0 + int(width*float64(pos)/float64(total))
+		x1 := d.BBox.X0 + int(width*float64(pos+n)/float64(total))
+		words = append(words, OCRWord{
+			Text:       f,
+			BBox:       BoundingBox{X0: x0, Y0: d.BBox.Y0, X1: x1, Y1: d.BBox.Y1},
+			Confidence: d.Confidence,
+		})
+		pos += n + 1
+	}
+	return words
+}
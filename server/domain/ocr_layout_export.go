@@ -0,0 +1,192 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+)
+
+// Layout export formats accepted by ExportOCRLayout.
+const (
+	LayoutFormatJSON = "json"
+	LayoutFormatHOCR = "hocr"
+	LayoutFormatALTO = "alto"
+)
+
+// ExportOCRLayout renders a layout as JSON, hOCR or ALTO XML and returns the
+// content together with its MIME type.
+func ExportOCRLayout(layout *OCRLayout, format string) ([]byte, string, error) {
+	switch strings.ToLower(format) {
+	case "", LayoutFormatJSON:
+		data, err := json.MarshalIndent(layout, "", "  ")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode layout as JSON: %w", err)
+		}
+		return data, "application/json", nil
+	case LayoutFormatHOCR:
+		return exportHOCR(layout), "text/html", nil
+	case LayoutFormatALTO:
+		return exportALTO(layout), "application/xml", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported layout format: %s", format)
+	}
+}
+
+// hocrBBox formats a bbox property.
+func hocrBBox(b BoundingBox) string {
+	return fmt.Sprintf("bbox %d %d %d %d", b.X0, b.Y0, b.X1, b.Y1)
+}
+
+// exportHOCR writes an hOCR 1.2 document with ocr_page / ocr_carea / ocr_line / ocrx_word elements.
+func exportHOCR(layout *OCRLayout) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">` + "\n")
+	b.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">` + "\n<head>\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(layout.Filename))
+	b.WriteString(`<meta http-equiv="Content-Type" content="text/html;charset=utf-8"/>` + "\n")
+	fmt.Fprintf(&b, `<meta name="ocr-system" content="%s"/>`+"\n", html.EscapeString(layout.EngineName))
+	b.WriteString(`<meta name="ocr-capabilities" content="ocr_page ocr_carea ocr_line ocrx_word"/>` + "\n")
+	b.WriteString("</head>\n<body>\n")
+	for _, page := range layout.Pages {
+		p := page.PageNumber
+		fmt.Fprintf(&b, "<div class=\"ocr_page\" id=\"page_%d\" title=\"image &quot;%s&quot;; %s; ppageno %d\">\n",
+			p, html.EscapeString(layout.Filename), hocrBBox(BoundingBox{X1: page.Width, Y1: page.Height}), p-1)
+		for bi, block := range page.Blocks {
+			fmt.Fprintf(&b, " <div class=\"ocr_carea\" id=\"block_%d_%d\" title=\"%s\">\n", p, bi+1, hocrBBox(block.BBox))
+			for li, line := range block.Lines {
+				fmt.Fprintf(&b, "  <span class=\"ocr_line\" id=\"line_%d_%d_%d\" title=\"%s\">", p, bi+1, li+1, hocrBBox(line.BBox))
+				for wi, w := range line.Words {
+					if wi > 0 {
+						b.WriteString(" ")
+					}
+					fmt.Fprintf(&b, "<span class=\"ocrx_word\" id=\"word_%d_%d_%d_%d\" title=\"%s; x_wconf %d\">%s</span>",
+						p, bi+1, li+1, wi+1, hocrBBox(w.BBox), int(math.Round(w.Confidence*100)), html.EscapeString(w.Text))
+				}
+				b.WriteString("</span>\n")
+			}
+			b.WriteString(" </div>\n")
+		}
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// ALTO v4 document structure (subset used for OCR text).
+type altoDocument struct {
+	XMLName     xml.Name        `xml:"alto"`
+	Xmlns       string          `xml:"xmlns,attr"`
+	Description altoDescription `xml:"Description"`
+	Pages       []altoPage      `xml:"Layout>Page"`
+}
+
+type altoDescription struct {
+	MeasurementUnit string `xml:"MeasurementUnit"`
+	FileName        string `xml:"sourceImageInformation>fileName"`
+	Software        string `xml:"OCRProcessing>ocrProcessingStep>processingSoftware>softwareName"`
+}
+
+type altoPage struct {
+	ID            string         `xml:"ID,attr"`
+	Width         int            `xml:"WIDTH,attr"`
+	Height        int            `xml:"HEIGHT,attr"`
+	PhysicalImgNr int            `xml:"PHYSICAL_IMG_NR,attr"`
+	PrintSpace    altoPrintSpace `xml:"PrintSpace"`
+}
+
+type altoPrintSpace struct {
+	altoGeometry
+	Blocks []altoTextBlock `xml:"TextBlock"`
+}
+
+type altoGeometry struct {
+	HPos   int `xml:"HPOS,attr"`
+	VPos   int `xml:"VPOS,attr"`
+	Width  int `xml:"WIDTH,attr"`
+	Height int `xml:"HEIGHT,attr"`
+}
+
+type altoTextBlock struct {
+	ID string `xml:"ID,attr"`
+	altoGeometry
+	Lines []altoTextLine `xml:"TextLine"`
+}
+
+type altoTextLine struct {
+	ID string `xml:"ID,attr"`
+	altoGeometry
+	Items []any
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	ID      string   `xml:"ID,attr"`
+	altoGeometry
+	Content string `xml:"CONTENT,attr"`
+	WC      string `xml:"WC,attr"`
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+func altoGeom(b BoundingBox) altoGeometry {
+	return altoGeometry{HPos: b.X0, VPos: b.Y0, Width: b.Width(), Height: b.Height()}
+}
+
+// exportALTO writes an ALTO v4 XML document.
+func exportALTO(layout *OCRLayout) []byte {
+	doc := altoDocument{
+		Xmlns: "http://www.loc.gov/standards/alto/ns-v4#",
+		Description: altoDescription{
+			MeasurementUnit: "pixel",
+			FileName:        layout.Filename,
+			Software:        layout.EngineName,
+		},
+	}
+	for _, page := range layout.Pages {
+		p := page.PageNumber
+		ap := altoPage{
+			ID:            fmt.Sprintf("page_%d", p),
+			Width:         page.Width,
+			Height:        page.Height,
+			PhysicalImgNr: p,
+		}
+		ap.PrintSpace.altoGeometry = altoGeometry{Width: page.Width, Height: page.Height}
+		for bi, block := range page.Blocks {
+			tb := altoTextBlock{ID: fmt.Sprintf("block_%d_%d", p, bi+1), altoGeometry: altoGeom(block.BBox)}
+			for li, line := range block.Lines {
+				tl := altoTextLine{ID: fmt.Sprintf("line_%d_%d_%d", p, bi+1, li+1), altoGeometry: altoGeom(line.BBox)}
+				for wi, w := range line.Words {
+					if wi > 0 {
+						tl.Items = append(tl.Items, altoSpace{})
+					}
+					tl.Items = append(tl.Items, altoString{
+						ID:           fmt.Sprintf("word_%d_%d_%d_%d", p, bi+1, li+1, wi+1),
+						altoGeometry: altoGeom(w.BBox),
+						Content:      w.Text,
+						WC:           fmt.Sprintf("%.2f", w.Confidence),
+					})
+				}
+				tb.Lines = append(tb.Lines, tl)
+			}
+			ap.PrintSpace.Blocks = append(ap.PrintSpace.Blocks, tb)
+		}
+		doc.Pages = append(doc.Pages, ap)
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		// Only reachable on programmer error in the struct definitions above.
+		return []byte(xml.Header + "<alto/>")
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
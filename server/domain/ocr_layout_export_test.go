@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// twoWordLayout is a page with one line of two words. The names need escaping.
+func twoWordLayout() *OCRLayout {
+	page := OCRPageLayout{Width: 600, Height: 400, Blocks: []OCRBlock{{Lines: []OCRLine{{Words: []OCRWord{
+		{Text: "Hello", BBox: BoundingBox{X0: 10, Y0: 20, X1: 90, Y1: 50}, Confidence: 0.96},
+		{Text: "<World>", BBox: BoundingBox{X0: 100, Y0: 22, X1: 200, Y1: 52}, Confidence: 0.5},
+	}}}}}}
+	page.recomputeBoxes()
+	return &OCRLayout{
+		Filename:   "scans/a&b.png",
+		EngineName: "tesseract",
+		Pages:      []OCRLayoutPage{{PageNumber: 1, OCRPageLayout: page}},
+	}
+}
+
+// hocrElement is an element of an hOCR document with an ocr class.
+type hocrElement struct {
+	class, title, text string
+	depth              int
+}
+
+// parseHOCR decodes an hOCR document strictly and lists its ocr elements.
+func parseHOCR(t *testing.T, data []byte) []hocrElement {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(string(data)))
+	var elements []hocrElement
+	var open []int // index in elements of each open element, -1 for others
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("hOCR is not well-formed XML: %v\n%s", err, data)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e := hocrElement{depth: len(open)}
+			for _, a := range tok.Attr {
+				switch a.Name.Local {
+				case "class":
+					e.class = a.Value
+				case "title":
+					e.title = a.Value
+				}
+			}
+			if strings.HasPrefix(e.class, "ocr") {
+				elements = append(elements, e)
+				open = append(open, len(elements)-1)
+			} else {
+				open = append(open, -1)
+			}
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if len(open) > 0 && open[len(open)-1] >= 0 {
+				elements[open[len(open)-1]].text += string(tok)
+			}
+		}
+	}
+	if len(open) != 0 {
+		t.Fatalf("hOCR leaves %d elements open", len(open))
+	}
+	return elements
+}
+
+func TestExportHOCR(t *testing.T) {
+	data, mime, err := ExportOCRLayout(twoWordLayout(), "HOCR")
+	if err != nil || mime != "text/html" {
+		t.Fatalf("ExportOCRLayout = %q, %v", mime, err)
+	}
+	var got []string
+	for _, e := range parseHOCR(t, data) {
+		line := fmt.Sprintf("%d %s [%s]", e.depth, e.class, e.title)
+		if e.class == "ocrx_word" {
+			line += " " + e.text
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		`2 ocr_page [image "scans/a&b.png"; bbox 0 0 600 400; ppageno 0]`,
+		`3 ocr_carea [bbox 10 20 200 52]`,
+		`4 ocr_line [bbox 10 20 200 52]`,
+		`5 ocrx_word [bbox 10 20 90 50; x_wconf 96] Hello`,
+		`5 ocrx_word [bbox 100 22 200 52; x_wconf 50] <World>`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("hOCR elements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, meta := range []string{`<title>scans/a&amp;b.png</title>`, `<meta name="ocr-system" content="tesseract"/>`, `content="ocr_page ocr_carea ocr_line ocrx_word"`} {
+		if !strings.Contains(string(data), meta) {
+			t.Errorf("hOCR head lacks %s", meta)
+		}
+	}
+}
+
+func TestExportALTO(t *testing.T) {
+	data, mime, err := ExportOCRLayout(twoWordLayout(), LayoutFormatALTO)
+	if err != nil || mime != "application/xml" {
+		t.Fatalf("ExportOCRLayout = %q, %v", mime, err)
+	}
+	type geometry struct {
+		HPos   int `xml:"HPOS,attr"`
+		VPos   int `xml:"VPOS,attr"`
+		Width  int `xml:"WIDTH,attr"`
+		Height int `xml:"HEIGHT,attr"`
+	}
+	var doc struct {
+		XMLName  xml.Name
+		FileName string `xml:"Description>sourceImageInformation>fileName"`
+		Software string `xml:"Description>OCRProcessing>ocrProcessingStep>processingSoftware>softwareName"`
+		Pages    []struct {
+			ID     string `xml:"ID,attr"`
+			Width  int    `xml:"WIDTH,attr"`
+			Height int    `xml:"HEIGHT,attr"`
+			Blocks []struct {
+				geometry
+				Lines []struct {
+					geometry
+					Items []struct {
+						XMLName xml.Name
+						geometry
+						Content string `xml:"CONTENT,attr"`
+						WC      string `xml:"WC,attr"`
+					} `xml:",any"`
+				} `xml:"TextLine"`
+			} `xml:"PrintSpace>TextBlock"`
+		} `xml:"Layout>Page"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("ALTO is not well-formed: %v\n%s", err, data)
+	}
+	if doc.XMLName.Space != "http://www.loc.gov/standards/alto/ns-v4#" || doc.XMLName.Local != "alto" {
+		t.Fatalf("root element = %v", doc.XMLName)
+	}
+	if doc.FileName != "scans/a&b.png" || doc.Software != "tesseract" {
+		t.Fatalf("description = %q, %q", doc.FileName, doc.Software)
+	}
+	if len(doc.Pages) != 1 || doc.Pages[0].ID != "page_1" || doc.Pages[0].Width != 600 || doc.Pages[0].Height != 400 ||
+		len(doc.Pages[0].Blocks) != 1 || len(doc.Pages[0].Blocks[0].Lines) != 1 {
+		t.Fatalf("pages = %+v", doc.Pages)
+	}
+	line := doc.Pages[0].Blocks[0].Lines[0]
+	if line.geometry != (geometry{HPos: 10, VPos: 20, Width: 190, Height: 32}) {
+		t.Fatalf("line geometry = %+v", line.geometry)
+	}
+	var got []string
+	for _, item := range line.Items {
+		s := item.XMLName.Local
+		if s == "String" {
+			s = fmt.Sprintf("String %q %d,%d %dx%d WC=%s", item.Content, item.HPos, item.VPos, item.Width, item.Height, item.WC)
+		}
+		got = append(got, s)
+	}
+	want := `String "Hello" 10,20 80x30 WC=0.96|SP|String "<World>" 100,22 100x30 WC=0.50`
+	if strings.Join(got, "|") != want {
+		t.Fatalf("line items = %s, want %s", strings.Join(got, "|"), want)
+	}
+}
+
+func TestExportOCRLayoutJSON(t *testing.T) {
+	layout := twoWordLayout()
+	data, mime, err := ExportOCRLayout(layout, "")
+	if err != nil || mime != "application/json" {
+		t.Fatalf("ExportOCRLayout = %q, %v", mime, err)
+	}
+	var decoded OCRLayout
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Pages[0].Text(); got != "Hello <World>" || decoded.Pages[0].PageNumber != 1 {
+		t.Fatalf("decoded page %d text %q", decoded.Pages[0].PageNumber, got)
+	}
+	if _, _, err := ExportOCRLayout(layout, "pdf"); err == nil || !strings.Contains(err.Error(), "unsupported layout format") {
+		t.Fatalf("err = %v, want an unsupported format", err)
+	}
+}
+
+func TestBuildLayoutFromDetections(t *testing.T) {
+	layout := buildLayoutFromDetections(600, 400, []textDetection{
+		{Text: "World", BBox: BoundingBox{X0: 300, Y0: 22, X1: 400, Y1: 52}, Confidence: 0.8},
+		{Text: "Hello there", BBox: BoundingBox{X0: 10, Y0: 20, X1: 120, Y1: 50}, Confidence: 0.9},
+		{Text: "  ", BBox: BoundingBox{X0: 10, Y0: 60, X1: 20, Y1: 90}},
+		// Far below: a block of its own
+		{Text: "東京都", BBox: BoundingBox{X0: 10, Y0: 200, X1: 100, Y1: 230}, Confidence: 0.7},
+	})
+	var got []string
+	for _, block := range layout.Blocks {
+		var lines []string
+		for _, line := range block.Lines {
+			var words []string
+			for _, w := range line.Words {
+				words = append(words, fmt.Sprintf("%s@%d-%d", w.Text, w.BBox.X0, w.BBox.X1))
+			}
+			lines = append(lines, strings.Join(words, " "))
+		}
+		got = append(got, strings.Join(lines, " / "))
+	}
+	// Words get a share of the detection box by their length
+	want := "Hello@10-60 there@70-120 World@300-400 | 東京都@10-100"
+	if strings.Join(got, " | ") != want {
+		t.Fatalf("layout = %s, want %s", strings.Join(got, " | "), want)
+	}
+	if layout.Blocks[0].Lines[0].BBox != (BoundingBox{X0: 10, Y0: 20, X1: 400, Y1: 52}) {
+		t.Fatalf("line box = %v", layout.Blocks[0].Lines[0].BBox)
+	}
+}
@@ -1,9 +1,15 @@
 package domain
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"image"
 	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,6 +28,13 @@ type OCREngine interface {
 	// ???: (extracted_text, confidence, error)
 }
 
+// VersionedOCREngine is implemented by engines that can report the version of
+// the OCR software behind them. It is recorded with each result.
+type VersionedOCREngine interface {
+	OCREngine
+	Version() string
+}
+
 // OCRResult ?OCR????????
 type OCRResult struct {
 	StorageProvider string // "azure", "s3", "gcs" - Added for persistence
@@ -33,6 +46,13 @@ type OCRResult struct {
 	Error           error
 	ProcessedAt     time.Time
 	Confidence     float64 // ?????
+	PreprocessProfile string // image preprocessing profile applied before OCR; "" when disabled
+	DetectedLanguage  string   // language code found by detection; "" when overridden or undetermined
+	Languages         []string // language codes the engine recognized with
+	EngineVersion     string   // version of the engine software; "" when unknown
+	EngineAgreement   []EngineAgreement // ensemble results only: how often each engine matched the vote
+	Barcodes          []DetectedBarcode // barcodes and QR codes found on the page images
+	Tenant            string            // tenant of the file key (see TenantFileKey); set when loaded
 }
 
 // OCRPage ?????????1?????OCR??
@@ -40,6 +60,12 @@ type OCRPage struct {
 	PageNumber int
 	Text       string
 	Confidence float64
+	Layout     *OCRPageLayout // word-level geometry; nil when the engine does not report it
+	PreprocessSteps []string  // preprocessing steps that changed the page image, in order
+	EngineAgreement []EngineAgreement // ensemble results only: per-engine agreement on this page
+	EngineName      string            // engine that read this page; differs from the result's in cascades
+	Tables          []ExtractedTable  // tables found in the page layout
+	Classification  string            // page class found before OCR ("printed", "blank", ...); "" when not classified
 }
 
 // OCRService ????OCR????????????????????
@@ -55,11 +81,23 @@ type OCRService interface {
 	
 	// GetEngine ??????????????????
 	GetEngine(name string) OCREngine
+	
+	// SetCascadeStrategy configures the virtual "cascade" engine; nil disables it.
+	SetCascadeStrategy(strategy *CascadeStrategy)
+
+	// SetBarcodeDetector configures the barcode pass run after the engines; nil disables it.
+	SetBarcodeDetector(detector BarcodeDetector)
+
+	// SetHandwritingEngine names the engine that reads pages classified as handwritten; "" lets every engine read them.
+	SetHandwritingEngine(name string)
 }
 
 // ocrService ?OCRService???
 type ocrService struct {
 	engines map[string]OCREngine
+	cascade *CascadeStrategy
+	barcodes BarcodeDetector
+	handwriting string
 	mu      sync.RWMutex
 }
 
@@ -96,7 +134,30 @@ func (s *ocrService) GetEngine(name string) OCREngine {
 	return s.engines[name]
 }
 
+// SetCascadeStrategy configures the virtual "cascade" engine; nil disables it.
+func (s *ocrService) SetCascadeStrategy(strategy *CascadeStrategy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cascade = strategy
+}
+
+// SetBarcodeDetector configures the barcode pass run after the engines; nil disables it.
+func (s *ocrService) SetBarcodeDetector(detector BarcodeDetector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.barcodes = detector
+}
+
+// SetHandwritingEngine names the engine that reads pages classified as handwritten; "" lets every engine read them.
+func (s *ocrService) SetHandwritingEngine(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handwriting = name
+}
+
 // ProcessDocument ????OCR??????????????
+// The name "cascade" runs the configured CascadeStrategy after the other
+// engines, reusing their results where the strategy names them.
 func (s *ocrService) ProcessDocument(ctx context.Context, filename string, content io.Reader, engineNames []string) (map[string]*OCRResult, error) {
 	if len(engineNames) == 0 {
 		// ???????????????????
@@ -107,6 +168,17 @@ func (s *ocrService) ProcessDocument(ctx context.Context, filename string, conte
 		s.mu.RUnlock()
 	}
 	
+	// Every engine (and each cascade stage) reads the document from the start.
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	
+	// Pages classified before OCR: blank ones are skipped and handwritten ones
+	// go to the handwriting engine.
+	pages := &documentPages{filename: filename, data: data}
+	pages.plan = s.newPagePlan(ctx, pages)
+	
 	results := make(map[string]*OCRResult)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -122,20 +194,7 @@ func (s *ocrService) ProcessDocument(ctx context.Context, filename string, conte
 		go func(name string, eng OCREngine) {
 			defer wg.Done()
 			
-			// ?????????????????????
-			// ??????????????????????????
-			// ???????????????????io.Reader???
-			// ???????????Read?????io.TeeReader??????
-			result, err := eng.ProcessDocument(ctx, filename, content)
-			if err != nil {
-				result = &OCRResult{
-					Filename:   filename,
-					EngineName: name,
-					Status:     "failed",
-					Error:      err,
-					ProcessedAt: time.Now(),
-				}
-			}
+			result := s.runEngine(ctx, eng, pages)
 			
 			mu.Lock()
 			results[name] = result
@@ -144,5 +203,95 @@ func (s *ocrService) ProcessDocument(ctx context.Context, filename string, conte
 	}
 	
 	wg.Wait()
+	
+	if slices.Contains(engineNames, CascadeEngineName) && s.GetEngine(CascadeEngineName) == nil {
+		s.mu.RLock()
+		strategy := s.cascade
+		s.mu.RUnlock()
+		if strategy != nil {
+			results[CascadeEngineName] = s.processCascade(ctx, strategy, pages, results)
+		}
+	}
+	if TableExtractionEnabled() {
+		extractDocumentTables(ctx, pages, results)
+	}
+	s.mu.RLock()
+	detector := s.barcodes
+	s.mu.RUnlock()
+	if detector != nil {
+		detectDocumentBarcodes(ctx, detector, pages, results)
+	}
 	return results, nil
 }
+
+// runOCREngine processes a whole document with one engine. Errors come back as
+// a failed result; pages are attributed to the engine.
+func runOCREngine(ctx context.Context, engine OCREngine, filename string, data []byte) *OCRResult {
+	result, err := engine.ProcessDocument(ctx, filename, bytes.NewReader(data))
+	if err != nil {
+		result = &OCRResult{
+			Filename:   filename,
+			EngineName: engine.Name(),
+			Status:     "failed",
+			Error:      err,
+			ProcessedAt: time.Now(),
+		}
+	}
+	if versioned, ok := engine.(VersionedOCREngine); ok && result.EngineVersion == "" {
+		result.EngineVersion = versioned.Version()
+	}
+	for i := range result.Pages {
+		if result.Pages[i].EngineName == "" {
+			result.Pages[i].EngineName = engine.Name()
+		}
+	}
+	return result
+}
+
+// documentPages renders the page images of a document once for the passes
+// that need them after the engines ran (cascade fallback, table rulings).
+type documentPages struct {
+	filename string
+	data     []byte
+	images   []image.Image
+	dpi      int
+	rendered bool
+	rulings  map[int]*pageRulings
+	plan     *pagePlan // nil when the pages were not classified
+}
+
+// render decodes the page images of PDFs and images; other documents have none.
+func (p *documentPages) render(ctx context.Context) error {
+	if p.rendered {
+		return nil
+	}
+	p.rendered = true
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(p.filename)), ".")
+	switch {
+	case ext == "pdf":
+		images, err := NewPDFConverter().ConvertPDFToImages(ctx, bytes.NewReader(p.data))
+		if err != nil {
+			return fmt.Errorf("failed to convert PDF to images: %w", err)
+		}
+		p.images, p.dpi = images, pdfRenderDPI
+	case isImageFile(ext):
+		img, _, err := image.Decode(bytes.NewReader(p.data))
+		if err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+		p.images = []image.Image{img}
+	}
+	return nil
+}
+
+// image returns the image of a page, or nil when the document has none.
+func (p *documentPages) image(ctx context.Context, pageNumber int) image.Image {
+	if err := p.render(ctx); err != nil {
+		log.Printf("Failed to render pages of %s: %v", p.filename, err)
+		return nil
+	}
+	if pageNumber < 1 || pageNumber > len(p.images) {
+		return nil
+	}
+	return p.images[pageNumber-1]
+}
@@ -0,0 +1,842 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"  // embedded GIFs in office documents
+	_ "image/jpeg" // embedded JPEGs in office documents
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxOfficeDocumentSize caps the size of an office archive read into memory.
+	maxOfficeDocumentSize = 200 << 20
+	// maxOfficePartSize caps a single decompressed zip entry (zip bomb guard).
+	maxOfficePartSize = 64 << 20
+	// maxRepeatedCells caps ODS number-columns-repeated expansion.
+	maxRepeatedCells = 64
+)
+
+// OfficeSegment is one logical unit of an office document: a page of a
+// word-processing document, a slide of a presentation or a sheet of a workbook.
+// Segments map 1:1 onto OCRPage entries.
+type OfficeSegment struct {
+	Kind   string // "page", "slide", "sheet"
+	Title  string // sheet name for workbooks, empty otherwise
+	Text   string
+	Images []OfficeImage
+}
+
+// OfficeImage is an image embedded in an office document.
+type OfficeImage struct {
+	Name string // path inside the archive, e.g. "word/media/image1.png"
+	Data []byte
+}
+
+// OfficeExtractor extracts text and embedded images from OOXML
+// (docx, xlsx, pptx) and ODF (odt, ods, odp) documents.
+type OfficeExtractor interface {
+	Extract(ctx context.Context, filename string, content io.Reader) ([]OfficeSegment, error)
+}
+
+type officeExtractor struct{}
+
+// NewOfficeExtractor creates an OfficeExtractor.
+func NewOfficeExtractor() OfficeExtractor {
+	return &officeExtractor{}
+}
+
+// isOfficeFile reports whether ext is an office format handled by OfficeExtractor.
+func isOfficeFile(ext string) bool {
+	switch ext {
+	case "docx", "xlsx", "pptx", "odt", "ods", "odp":
+		return true
+	}
+	return false
+}
+
+// isLegacyOfficeFile reports whether ext is a binary (OLE2) office format.
+func isLegacyOfficeFile(ext string) bool {
+	switch ext {
+	case "doc", "xls", "ppt":
+		return true
+	}
+	return false
+}
+
+// Extract reads the archive and dispatches on the file extension.
+func (x *officeExtractor) Extract(ctx context.Context, filename string, content io.Reader) ([]OfficeSegment, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if isLegacyOfficeFile(ext) {
+		return nil, fmt.Errorf("legacy binary office format is not supported: %s (convert to %sx)", ext, ext)
+	}
+	if !isOfficeFile(ext) {
+		return nil, fmt.Errorf("unsupported office file type: %s", ext)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(content, maxOfficeDocumentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read office document: %w", err)
+	}
+	if len(data) > maxOfficeDocumentSize {
+		return nil, fmt.Errorf("office document exceeds %d bytes", maxOfficeDocumentSize)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open office document as zip: %w", err)
+	}
+	pkg := &officePackage{files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		pkg.files[f.Name] = f
+	}
+
+	var segments []OfficeSegment
+	switch ext {
+	case "docx":
+		segments, err = extractDOCX(ctx, pkg)
+	case "xlsx":
+		segments, err = extractXLSX(ctx, pkg)
+	case "pptx":
+		segments, err = extractPPTX(ctx, pkg)
+	case "odt", "ods", "odp":
+		segments, err = extractODF(ctx, pkg, ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// officePackage gives access to the parts of an office zip archive.
+type officePackage struct {
+	files map[string]*zip.File
+}
+
+// read returns the decompressed content of a part.
+func (p *officePackage) read(name string) ([]byte, error) {
+	f, ok := p.files[name]
+	if !ok {
+		return nil, fmt.Errorf("part not found: %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open part %s: %w", name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxOfficePartSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read part %s: %w", name, err)
+	}
+	if len(data) > maxOfficePartSize {
+		return nil, fmt.Errorf("part %s exceeds %d bytes", name, maxOfficePartSize)
+	}
+	return data, nil
+}
+
+// image loads an embedded image part; missing parts are logged and skipped.
+func (p *officePackage) image(name string) (OfficeImage, bool) {
+	data, err := p.read(name)
+	if err != nil {
+		log.Printf("Skipping embedded image %s: %v", name, err)
+		return OfficeImage{}, false
+	}
+	return OfficeImage{Name: name, Data: data}, true
+}
+
+// relationships parses the OOXML relationships of a part into id -> absolute target.
+func (p *officePackage) relationships(part string) map[string]string {
+	relsPath := path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+	rels := make(map[string]string)
+	data, err := p.read(relsPath)
+	if err != nil {
+		return rels
+	}
+	var doc struct {
+		Relationships []struct {
+			ID         string `xml:"Id,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		log.Printf("Failed to parse relationships %s: %v", relsPath, err)
+		return rels
+	}
+	for _, r := range doc.Relationships {
+		if r.TargetMode == "External" {
+			continue
+		}
+		if strings.HasPrefix(r.Target, "/") {
+			rels[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			rels[r.ID] = path.Join(path.Dir(part), r.Target)
+		}
+	}
+	return rels
+}
+
+// attr returns the value of the attribute with the given local name.
+func attr(se xml.StartElement, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// segmentBuilder accumulates text and images for the segment being built.
+type segmentBuilder struct {
+	kind     string
+	segments []OfficeSegment
+	text     strings.Builder
+	title    string
+	images   []OfficeImage
+}
+
+// flush closes the current segment; empty segments are dropped unless keepEmpty is set.
+func (b *segmentBuilder) flush(keepEmpty bool) {
+	text := strings.TrimSpace(b.text.String())
+	if text != "" || len(b.images) > 0 || keepEmpty {
+		b.segments = append(b.segments, OfficeSegment{
+			Kind:   b.kind,
+			Title:  b.title,
+			Text:   text,
+			Images: b.images,
+		})
+	}
+	b.text.Reset()
+	b.images = nil
+	b.title = ""
+}
+
+// extractDOCX splits word/document.xml into pages at explicit and rendered page breaks.
+func extractDOCX(ctx context.Context, pkg *officePackage) ([]OfficeSegment, error) {
+	const part = "word/document.xml"
+	data, err := pkg.read(part)
+	if err != nil {
+		return nil, err
+	}
+	rels := pkg.relationships(part)
+
+	b := &segmentBuilder{kind: "page"}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	inText := false
+	tableDepth := 0
+	breakAfterParagraph := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.text.WriteString("\t")
+			case "br", "cr":
+				if attr(t, "type") == "page" {
+					b.flush(false)
+				} else {
+					b.text.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				b.flush(false)
+			case "sectPr":
+				// A section break inside paragraph properties starts a new page.
+				breakAfterParagraph = true
+			case "tbl":
+				tableDepth++
+			case "blip":
+				if target, ok := rels[attr(t, "embed")]; ok {
+					if img, ok := pkg.image(target); ok {
+						b.images = append(b.images, img)
+					}
+				}
+			case "imagedata":
+				if target, ok := rels[attr(t, "id")]; ok {
+					if img, ok := pkg.image(target); ok {
+						b.images = append(b.images, img)
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if tableDepth > 0 {
+					b.text.WriteString(" ")
+				} else {
+					b.text.WriteString("\n")
+				}
+				if breakAfterParagraph {
+					breakAfterParagraph = false
+					b.flush(false)
+				}
+			case "tc":
+				b.text.WriteString("\t")
+			case "tr":
+				b.text.WriteString("\n")
+			case "tbl":
+				tableDepth--
+			}
+		case xml.CharData:
+			if inText {
+				b.text.Write(t)
+			}
+		}
+	}
+	b.flush(len(b.segments) == 0)
+	return b.segments, nil
+}
+
+// extractXLSX produces one segment per worksheet, rows as lines and cells separated by tabs.
+func extractXLSX(ctx context.Context, pkg *officePackage) ([]OfficeSegment, error) {
+	const workbookPart = "xl/workbook.xml"
+	data, err := pkg.read(workbookPart)
+	if err != nil {
+		return nil, err
+	}
+	var workbook struct {
+		Sheets []struct {
+			Name string     `xml:"name,attr"`
+			Attr []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(data, &workbook); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", workbookPart, err)
+	}
+	workbookRels := pkg.relationships(workbookPart)
+	sharedStrings, err := readSharedStrings(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]OfficeSegment, 0, len(workbook.Sheets))
+	for _, sheet := range workbook.Sheets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var relID string
+		for _, a := range sheet.Attr {
+			if a.Name.Local == "id" && a.Name.Space != "" {
+				relID = a.Value
+			}
+		}
+		sheetPart, ok := workbookRels[relID]
+		if !ok {
+			log.Printf("Worksheet %q has no relationship target, skipping", sheet.Name)
+			continue
+		}
+		text, err := readWorksheetText(pkg, sheetPart, sharedStrings)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, OfficeSegment{
+			Kind:   "sheet",
+			Title:  sheet.Name,
+			Text:   text,
+			Images: worksheetImages(pkg, sheetPart),
+		})
+	}
+	return segments, nil
+}
+
+// readSharedStrings loads xl/sharedStrings.xml, skipping phonetic (rPh) runs.
+func readSharedStrings(pkg *officePackage) ([]string, error) {
+	const part = "xl/sharedStrings.xml"
+	data, err := pkg.read(part)
+	if err != nil {
+		// Workbooks with only numbers have no shared strings part.
+		return nil, nil
+	}
+	var strs []string
+	var current strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	inText, inPhonetic := false, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "rPh":
+				inPhonetic = true
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				strs = append(strs, current.String())
+			case "rPh":
+				inPhonetic = false
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText && !inPhonetic {
+				current.Write(t)
+			}
+		}
+	}
+	return strs, nil
+}
+
+// readWorksheetText renders the cell values of a worksheet.
+func readWorksheetText(pkg *officePackage, part string, sharedStrings []string) (string, error) {
+	data, err := pkg.read(part)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	var row []string
+	var value strings.Builder
+	cellType := ""
+	inValue := false
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = row[:0]
+			case "c":
+				cellType = attr(t, "t")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				v := value.String()
+				if cellType == "s" {
+					if idx, err := strconv.Atoi(v); err == nil && idx >= 0 && idx < len(sharedStrings) {
+						v = sharedStrings[idx]
+					}
+				} else if cellType == "b" {
+					if v == "1" {
+						v = "TRUE"
+					} else {
+						v = "FALSE"
+					}
+				}
+				if v != "" {
+					row = append(row, v)
+				}
+			case "row":
+				if len(row) > 0 {
+					out.WriteString(strings.Join(row, "\t"))
+					out.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// worksheetImages follows worksheet -> drawing -> media relationships.
+func worksheetImages(pkg *officePackage, sheetPart string) []OfficeImage {
+	var images []OfficeImage
+	for _, drawingPart := range sortedTargets(pkg.relationships(sheetPart)) {
+		if !strings.Contains(drawingPart, "/drawings/") || strings.Contains(drawingPart, "/vmlDrawing") {
+			continue
+		}
+		images = append(images, blipImages(pkg, drawingPart)...)
+	}
+	return images
+}
+
+// blipImages returns images referenced by a:blip elements in a DrawingML part.
+func blipImages(pkg *officePackage, part string) []OfficeImage {
+	data, err := pkg.read(part)
+	if err != nil {
+		return nil
+	}
+	rels := pkg.relationships(part)
+	var images []OfficeImage
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "blip" {
+			if target, ok := rels[attr(se, "embed")]; ok {
+				if img, ok := pkg.image(target); ok {
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	return images
+}
+
+// sortedTargets returns relationship targets in a stable order.
+func sortedTargets(rels map[string]string) []string {
+	targets := make([]string, 0, len(rels))
+	for _, target := range rels {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// extractPPTX produces one segment per slide in presentation order.
+func extractPPTX(ctx context.Context, pkg *officePackage) ([]OfficeSegment, error) {
+	const presentationPart = "ppt/presentation.xml"
+	data, err := pkg.read(presentationPart)
+	if err != nil {
+		return nil, err
+	}
+	var presentation struct {
+		Slides []struct {
+			Attr []xml.Attr `xml:",any,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	if err := xml.Unmarshal(data, &presentation); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", presentationPart, err)
+	}
+	presentationRels := pkg.relationships(presentationPart)
+
+	segments := make([]OfficeSegment, 0, len(presentation.Slides))
+	for _, slide := range presentation.Slides {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var relID string
+		for _, a := range slide.Attr {
+			if a.Name.Local == "id" && a.Name.Space != "" {
+				relID = a.Value
+			}
+		}
+		slidePart, ok := presentationRels[relID]
+		if !ok {
+			continue
+		}
+		text, err := readSlideText(pkg, slidePart)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, OfficeSegment{
+			Kind:   "slide",
+			Text:   text,
+			Images: blipImages(pkg, slidePart),
+		})
+	}
+	return segments, nil
+}
+
+// readSlideText collects DrawingML paragraphs (a:p / a:t) from a slide.
+func readSlideText(pkg *officePackage, part string) (string, error) {
+	data, err := pkg.read(part)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	inText := false
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "br":
+				out.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				out.WriteString("\n")
+			case "tc":
+				out.WriteString("\t")
+			}
+		case xml.CharData:
+			if inText {
+				out.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// extractODF walks content.xml of an OpenDocument file. Text documents are
+// split at soft page breaks, spreadsheets per table and presentations per page.
+func extractODF(ctx context.Context, pkg *officePackage, ext string) ([]OfficeSegment, error) {
+	const part = "content.xml"
+	data, err := pkg.read(part)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "page"
+	switch ext {
+	case "ods":
+		kind = "sheet"
+	case "odp":
+		kind = "slide"
+	}
+	b := &segmentBuilder{kind: kind}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	inBody := false
+	paragraphDepth := 0
+	var cell strings.Builder
+	var row []string
+	cellRepeat := 1
+	inCell := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", part, err)
+		}
+		// Writes go to the current spreadsheet cell or the segment text.
+		w := &b.text
+		if inCell {
+			w = &cell
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "body":
+				inBody = true
+			case "p", "h":
+				paragraphDepth++
+			case "s":
+				n, _ := strconv.Atoi(attr(t, "c"))
+				if n < 1 {
+					n = 1
+				}
+				w.WriteString(strings.Repeat(" ", n))
+			case "tab":
+				w.WriteString("\t")
+			case "line-break":
+				w.WriteString("\n")
+			case "soft-page-break":
+				if kind == "page" {
+					b.flush(false)
+				}
+			case "page":
+				if kind == "slide" {
+					b.flush(false)
+				}
+			case "table":
+				if kind == "sheet" {
+					b.flush(false)
+					b.title = attr(t, "name")
+				}
+			case "table-row":
+				row = row[:0]
+			case "table-cell", "covered-table-cell":
+				inCell = true
+				cell.Reset()
+				cellRepeat, _ = strconv.Atoi(attr(t, "number-columns-repeated"))
+				if cellRepeat < 1 {
+					cellRepeat = 1
+				}
+			case "image":
+				if href := attr(t, "href"); href != "" && !strings.Contains(href, "://") {
+					if img, ok := pkg.image(strings.TrimPrefix(href, "./")); ok {
+						b.images = append(b.images, img)
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "body":
+				inBody = false
+			case "p", "h":
+				paragraphDepth--
+				if inCell {
+					cell.WriteString(" ")
+				} else {
+					b.text.WriteString("\n")
+				}
+			case "table-cell", "covered-table-cell":
+				inCell = false
+				if v := strings.TrimSpace(cell.String()); v != "" {
+					if cellRepeat > maxRepeatedCells {
+						cellRepeat = maxRepeatedCells
+					}
+					for i := 0; i < cellRepeat; i++ {
+						row = append(row, v)
+					}
+				}
+			case "table-row":
+				if len(row) > 0 {
+					b.text.WriteString(strings.Join(row, "\t"))
+					b.text.WriteString("\n")
+				}
+			case "table":
+				if kind == "sheet" {
+					b.flush(true)
+				}
+			}
+		case xml.CharData:
+			if inBody && paragraphDepth > 0 {
+				w.Write(t)
+			}
+		}
+	}
+	b.flush(len(b.segments) == 0)
+	return b.segments, nil
+}
+
+// processOfficeDocument extracts text natively from an office document and runs
+// the given engine over embedded images. Shared by all OCREngine implementations.
+func processOfficeDocument(ctx context.Context, engine OCREngine, filename string, content io.Reader, result *OCRResult) (*OCRResult, error) {
+	segments, err := NewOfficeExtractor().Extract(ctx, filename, content)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("failed to extract office document: %w", err)
+		return result, result.Error
+	}
+	log.Printf("Office document %s extracted into %d segments", filename, len(segments))
+
+	// Native text decides the language; embedded images are only probed when
+	// the document has no text of its own (e.g. a scanned page pasted into Word).
+	var nativeText strings.Builder
+	var samples []image.Image
+	for _, segment := range segments {
+		nativeText.WriteString(segment.Text)
+		nativeText.WriteString("\n")
+	}
+	if strings.TrimSpace(nativeText.String()) == "" {
+		for _, segment := range segments {
+			for _, embedded := range segment.Images {
+				if len(samples) >= maxLanguageSamples {
+					break
+				}
+				if img, _, err := image.Decode(bytes.NewReader(embedded.Data)); err == nil {
+					samples = append(samples, img)
+				}
+			}
+		}
+	}
+	ctx = resolveDocumentLanguages(ctx, engine, result, nativeText.String(), samples)
+
+	var allText strings.Builder
+	var totalConfidence float64
+	pages := make([]OCRPage, 0, len(segments))
+	for i, segment := range segments {
+		pageNum := i + 1
+		var text strings.Builder
+		text.WriteString(segment.Text)
+
+		// Native text is exact; embedded images contribute their OCR confidence.
+		var confidences []float64
+		var steps []string
+		if segment.Text != "" {
+			confidences = append(confidences, 1.0)
+		}
+		for _, embedded := range segment.Images {
+			img, _, err := image.Decode(bytes.NewReader(embedded.Data))
+			if err != nil {
+				log.Printf("Skipping embedded image %s in %s: %v", embedded.Name, filename, err)
+				continue
+			}
+			rec, err := recognizeImage(ctx, engine, img, 0)
+			if err != nil {
+				log.Printf("Failed to OCR embedded image %s in %s: %v", embedded.Name, filename, err)
+				continue
+			}
+			confidences = append(confidences, rec.Confidence)
+			for _, step := range rec.PreprocessSteps {
+				steps = append(steps, path.Base(embedded.Name)+":"+step)
+			}
+			if imgText := strings.TrimSpace(rec.Text); imgText != "" {
+				text.WriteString(fmt.Sprintf("\n[Image: %s]\n%s", path.Base(embedded.Name), imgText))
+			}
+		}
+
+		pageConfidence := 0.0
+		for _, c := range confidences {
+			pageConfidence += c
+		}
+		if len(confidences) > 0 {
+			pageConfidence /= float64(len(confidences))
+		}
+
+		label := strings.ToUpper(segment.Kind[:1]) + segment.Kind[1:]
+		header := fmt.Sprintf("\n--- %s %d ---\n", label, pageNum)
+		if segment.Title != "" {
+			header = fmt.Sprintf("\n--- %s %d: %s ---\n", label, pageNum, segment.Title)
+		}
+		allText.WriteString(header)
+		allText.WriteString(strings.TrimSpace(text.String()))
+		totalConfidence += pageConfidence
+		pages = append(pages, OCRPage{
+			PageNumber:      pageNum,
+			Text:            strings.TrimSpace(text.String()),
+			Confidence:      pageConfidence,
+			PreprocessSteps: steps,
+		})
+	}
+
+	if len(pages) == 0 {
+		result.Status = "failed"
+		result.Error = fmt.Errorf("no content was extracted from office document")
+		return result, result.Error
+	}
+
+	result.ExtractedText = strings.TrimSpace(allText.String())
+	result.Confidence = totalConfidence / float64(len(pages))
+	result.Status = "completed"
+	result.Pages = pages
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package domain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// officeZip builds an office archive from part names and contents.
+func officeZip(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const (
+	wordNS  = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	sheetNS = `xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	slideNS = `xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	odfNS   = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:xlink="http://www.w3.org/1999/xlink"`
+	relsNS  = `xmlns="http://schemas.openxmlformats.org/package/2006/relationships"`
+)
+
+func TestOfficeExtractor(t *testing.T) {
+	type segment struct {
+		kind, title, text string
+		images            int
+	}
+	for _, tt := range []struct {
+		name     string
+		filename string
+		parts    map[string]string
+		want     []segment
+	}{
+		{
+			name:     "docx pages, tables and images",
+			filename: "report.docx",
+			parts: map[string]string{
+				"word/document.xml": `<w:document ` + wordNS + `><w:body>
+					<w:p><w:r><w:t>First page</w:t></w:r></w:p>
+					<w:tbl><w:tr><w:tc><w:p><w:r><w:t>A</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>B</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+					<w:p><w:r><w:br w:type="page"/><w:t>Second page</w:t></w:r></w:p>
+					<w:p><w:r><w:drawing><a:blip r:embed="rId1"/></w:drawing></w:r></w:p>
+				</w:body></w:document>`,
+				"word/_rels/document.xml.rels": `<Relationships ` + relsNS + `><Relationship Id="rId1" Target="media/image1.png"/></Relationships>`,
+				"word/media/image1.png":        "not really a png",
+			},
+			want: []segment{
+				{"page", "", "First page\nA \tB", 0},
+				{"page", "", "Second page", 1},
+			},
+		},
+		{
+			name:     "docx without text keeps one page",
+			filename: "empty.docx",
+			parts:    map[string]string{"word/document.xml": `<w:document ` + wordNS + `><w:body/></w:document>`},
+			want:     []segment{{"page", "", "", 0}},
+		},
+		{
+			name:     "xlsx sheets with shared strings",
+			filename: "book.xlsx",
+			parts: map[string]string{
+				"xl/workbook.xml":            `<workbook ` + sheetNS + `><sheets><sheet name="Sales" r:id="rId1"/><sheet name="Missing" r:id="rId9"/></sheets></workbook>`,
+				"xl/_rels/workbook.xml.rels": `<Relationships ` + relsNS + `><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+				"xl/sharedStrings.xml":       `<sst ` + sheetNS + `><si><t>Item</t></si><si><r><t>Tokyo</t></r><rPh><t>tookyoo</t></rPh></si></sst>`,
+				"xl/worksheets/sheet1.xml": `<worksheet ` + sheetNS + `><sheetData>
+					<row><c t="s"><v>0</v></c><c><v>42</v></c></row>
+					<row><c t="s"><v>1</v></c><c t="b"><v>1</v></c><c t="inlineStr"><is><t>inline</t></is></c></row>
+				</sheetData></worksheet>`,
+			},
+			want: []segment{{"sheet", "Sales", "Item\t42\nTokyo\tTRUE\tinline", 0}},
+		},
+		{
+			name:     "pptx slides in presentation order",
+			filename: "deck.pptx",
+			parts: map[string]string{
+				"ppt/presentation.xml":            `<p:presentation ` + slideNS + `><p:sldIdLst><p:sldId r:id="rId2"/><p:sldId r:id="rId1"/></p:sldIdLst></p:presentation>`,
+				"ppt/_rels/presentation.xml.rels": `<Relationships ` + relsNS + `><Relationship Id="rId1" Target="slides/slide1.xml"/><Relationship Id="rId2" Target="slides/slide2.xml"/></Relationships>`,
+				"ppt/slides/slide1.xml":           `<p:sld ` + slideNS + `><a:p><a:r><a:t>One</a:t></a:r></a:p></p:sld>`,
+				"ppt/slides/slide2.xml":           `<p:sld ` + slideNS + `><a:p><a:r><a:t>Two</a:t></a:r><a:br/><a:r><a:t>lines</a:t></a:r></a:p></p:sld>`,
+			},
+			want: []segment{{"slide", "", "Two\nlines", 0}, {"slide", "", "One", 0}},
+		},
+		{
+			name:     "odt soft page breaks",
+			filename: "letter.odt",
+			parts: map[string]string{
+				"content.xml": `<office:document-content ` + odfNS + `><office:body><office:text>
+					<text:p>Dear<text:s text:c="2"/>reader</text:p>
+					<text:soft-page-break/>
+					<text:p>Regards</text:p>
+				</office:text></office:body></office:document-content>`,
+			},
+			want: []segment{{"page", "", "Dear  reader", 0}, {"page", "", "Regards", 0}},
+		},
+		{
+			name:     "ods repeated cells are capped",
+			filename: "sheet.ods",
+			parts: map[string]string{
+				"content.xml": `<office:document-content ` + odfNS + `><office:body><office:spreadsheet>
+					<table:table table:name="Data"><table:table-row>
+						<table:table-cell><text:p>x</text:p></table:table-cell>
+						<table:table-cell table:number-columns-repeated="1000000"><text:p>y</text:p></table:table-cell>
+					</table:table-row></table:table>
+				</office:spreadsheet></office:body></office:document-content>`,
+			},
+			want: []segment{{"sheet", "Data", "x\t" + strings.TrimSuffix(strings.Repeat("y\t", maxRepeatedCells), "\t"), 0}},
+		},
+		{
+			name:     "odp pages and images",
+			filename: "talk.odp",
+			parts: map[string]string{
+				"content.xml": `<office:document-content ` + odfNS + `><office:body><office:presentation>
+					<draw:page><draw:frame><draw:text-box><text:p>Hello</text:p></draw:text-box></draw:frame></draw:page>
+					<draw:page><draw:frame><draw:image xlink:href="Pictures/a.png"/></draw:frame><draw:frame><draw:image xlink:href="http://example.com/b.png"/></draw:frame></draw:page>
+				</office:presentation></office:body></office:document-content>`,
+				"Pictures/a.png": "png",
+			},
+			want: []segment{{"slide", "", "Hello", 0}, {"slide", "", "", 1}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := NewOfficeExtractor().Extract(context.Background(), tt.filename, bytes.NewReader(officeZip(t, tt.parts)))
+			if err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if len(segments) != len(tt.want) {
+				t.Fatalf("got %d segments %+v, want %d", len(segments), segments, len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := segments[i]
+				if got.Kind != want.kind || got.Title != want.title || got.Text != want.text || len(got.Images) != want.images {
+					t.Errorf("segment %d = {%s %q %q %d images}, want {%s %q %q %d images}",
+						i, got.Kind, got.Title, got.Text, len(got.Images), want.kind, want.title, want.text, want.images)
+				}
+			}
+		})
+	}
+}
+
+func TestOfficeExtractorRejects(t *testing.T) {
+	bomb := func(t *testing.T) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create("word/document.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := make([]byte, 1<<20)
+		for written := 0; written <= maxOfficePartSize; written += len(chunk) {
+			w.Write(chunk)
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+	for _, tt := range []struct {
+		name     string
+		filename string
+		content  func(t *testing.T) io.Reader
+		wantErr  string
+	}{
+		{"legacy format", "old.doc", func(t *testing.T) io.Reader { return strings.NewReader("\xd0\xcf\x11\xe0") }, "legacy binary office format"},
+		{"unsupported extension", "notes.txt", func(t *testing.T) io.Reader { return strings.NewReader("text") }, "unsupported office file type"},
+		{"not a zip", "report.docx", func(t *testing.T) io.Reader { return strings.NewReader("plain text") }, "failed to open office document"},
+		{"missing part", "report.docx", func(t *testing.T) io.Reader {
+			return bytes.NewReader(officeZip(t, map[string]string{"other.xml": "<x/>"}))
+		}, "part not found"},
+		{"part over the size limit", "report.docx", func(t *testing.T) io.Reader { return bytes.NewReader(bomb(t)) }, "exceeds"},
+		{"document over the size limit", "report.docx", func(t *testing.T) io.Reader {
+			if testing.Short() {
+				t.Skip("reads more than 200 MiB")
+			}
+			return io.LimitReader(zeroReader{}, maxOfficeDocumentSize+1)
+		}, "office document exceeds"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewOfficeExtractor().Extract(context.Background(), tt.filename, tt.content(t))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// zeroReader reads zero bytes forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,542 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Page classes assigned before OCR.
+const (
+	PageClassPrinted     = "printed"
+	PageClassHandwritten = "handwritten"
+	PageClassMixed       = "mixed"
+	PageClassBlank       = "blank"
+	PageClassPhoto       = "photo"
+)
+
+// PageClassification describes a page image from cheap pixel statistics.
+type PageClassification struct {
+	PageNumber       int     `json:"page_number"`
+	Class            string  `json:"class"`
+	Confidence       float64 `json:"confidence"`        // 0.0-1.0
+	InkRatio         float64 `json:"ink_ratio"`         // dark pixels inside the margins
+	MidtoneRatio     float64 `json:"midtone_ratio"`     // pixels far from both the paper and the ink tone
+	TextLines        int     `json:"text_lines"`        // lines of glyph-sized components
+	HandwrittenLines int     `json:"handwritten_lines"` // of which look handwritten
+}
+
+// PageClassificationEnabled reports whether the worker classifies pages before
+// OCR (OCR_PAGE_CLASSIFICATION, on by default).
+func PageClassificationEnabled() bool {
+	switch strings.ToLower(os.Getenv("OCR_PAGE_CLASSIFICATION")) {
+	case "false", "0", "off", "no":
+		return false
+	}
+	return true
+}
+
+// HandwritingEngineFromEnv returns the engine that reads handwritten pages
+// (OCR_HANDWRITING_ENGINE); "" lets every engine read them.
+func HandwritingEngineFromEnv() string {
+	return strings.TrimSpace(os.Getenv("OCR_HANDWRITING_ENGINE"))
+}
+
+type pageClassificationsKey struct{}
+
+// WithPageClassifications returns a context that makes ProcessDocument skip
+// blank pages and route handwritten pages.
+func WithPageClassifications(ctx context.Context, classes []PageClassification) context.Context {
+	if len(classes) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, pageClassificationsKey{}, classes)
+}
+
+// PageClassificationsFromContext returns the classifications set by
+// WithPageClassifications, or nil.
+func PageClassificationsFromContext(ctx context.Context) []PageClassification {
+	classes, _ := ctx.Value(pageClassificationsKey{}).([]PageClassification)
+	return classes
+}
+
+// ClassifyDocumentPages classifies the page images of PDFs and images; other
+// documents (Office files) have none and return nil.
+func ClassifyDocumentPages(ctx context.Context, filename string, data []byte) ([]PageClassification, error) {
+	pages := &documentPages{filename: filename, data: data}
+	if err := pages.render(ctx); err != nil {
+		return nil, err
+	}
+	classes := make([]PageClassification, 0, len(pages.images))
+	for i, img := range pages.images {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c := ClassifyPage(img)
+		c.PageNumber = i + 1
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// SummarizePageClassifications counts pages per class, e.g. "printed=3 blank=1".
+func SummarizePageClassifications(classes []PageClassification) string {
+	counts := map[string]int{}
+	for _, c := range classes {
+		counts[c.Class]++
+	}
+	var parts []string
+	for _, class := range []string{PageClassPrinted, PageClassHandwritten, PageClassMixed, PageClassPhoto, PageClassBlank} {
+		if counts[class] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", class, counts[class]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+const (
+	// classifyMaxSide bounds the analysis resolution; glyphs of body text stay
+	// several pixels tall.
+	classifyMaxSide = 1200
+	// blankContrast is the minimum gray-level gap between paper and ink.
+	blankContrast = 40
+	// photoMidtoneRatio is the share of midtones above which a page is a photo.
+	photoMidtoneRatio = 0.2
+	// handwrittenLineRatio and printedLineRatio split pages by the share of
+	// handwritten line width; between them the page is mixed.
+	handwrittenLineRatio = 0.7
+	printedLineRatio     = 0.25
+)
+
+// ClassifyPage classifies a page image as printed, handwritten, mixed, blank
+// or photo. Blank pages lack contrast or ink; photos are dominated by
+// midtones. Text pages are split into lines of glyph-sized connected
+// components, and each line is judged by inkLineHandwritten.
+func ClassifyPage(img image.Image) PageClassification {
+	g := toGray(img)
+	if long := max(g.Rect.Dx(), g.Rect.Dy()); long > classifyMaxSide {
+		scaled, _ := scaleImage(g, float64(classifyMaxSide)/float64(long))
+		g = scaled.(*image.Gray)
+	}
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	// Scanner edges and punch holes sit in the margins.
+	mx, my := w*3/100, h*3/100
+	x0, y0, x1, y1 := mx, my, w-mx, h-my
+	if x1-x0 < 8 || y1-y0 < 8 {
+		return PageClassification{Class: PageClassBlank, Confidence: 1}
+	}
+
+	threshold := int(otsuThreshold(g))
+	var hist [256]int
+	for y := y0; y < y1; y++ {
+		for _, v := range g.Pix[y*g.Stride+x0 : y*g.Stride+x1] {
+			hist[v]++
+		}
+	}
+	total := (x1 - x0) * (y1 - y0)
+	var darkSum, darkCount, lightSum, lightCount int
+	for v, n := range hist {
+		if v <= threshold {
+			darkSum += v * n
+			darkCount += n
+		} else {
+			lightSum += v * n
+			lightCount += n
+		}
+	}
+	c := PageClassification{InkRatio: float64(darkCount) / float64(total)}
+	if darkCount == 0 || lightCount == 0 {
+		c.Class, c.Confidence = PageClassBlank, 1
+		return c
+	}
+	dark, light := float64(darkSum)/float64(darkCount), float64(lightSum)/float64(lightCount)
+	contrast := light - dark
+	if contrast < blankContrast {
+		c.Class, c.Confidence = PageClassBlank, 1-contrast/blankContrast/2
+		return c
+	}
+	midtones := 0
+	for v, n := range hist {
+		if fv := float64(v); fv > dark+contrast/4 && fv < light-contrast/4 {
+			midtones += n
+		}
+	}
+	c.MidtoneRatio = float64(midtones) / float64(total)
+	if c.MidtoneRatio > photoMidtoneRatio {
+		c.Class, c.Confidence = PageClassPhoto, math.Min(1, c.MidtoneRatio/photoMidtoneRatio/2)
+		return c
+	}
+
+	components := inkComponents(g, uint8(threshold), x0, y0, x1, y1)
+	var glyphs []inkComponent
+	for _, comp := range components {
+		cw, ch := comp.box.X1-comp.box.X0, comp.box.Y1-comp.box.Y0
+		if comp.pixels >= 4 && ch >= 3 && ch <= h/8 && cw <= w/3 {
+			glyphs = append(glyphs, comp)
+		}
+	}
+	if len(glyphs) < 3 && c.InkRatio < 0.002 {
+		c.Class, c.Confidence = PageClassBlank, 0.8
+		return c
+	}
+	// Dots, accents and punctuation would form lines of their own.
+	heights := make([]float64, len(glyphs))
+	for i, comp := range glyphs {
+		heights[i] = float64(comp.box.Y1 - comp.box.Y0)
+	}
+	if len(heights) > 0 {
+		minHeight := int(medianFloat(heights) * 0.4)
+		kept := glyphs[:0]
+		for _, comp := range glyphs {
+			if comp.box.Y1-comp.box.Y0 >= minHeight {
+				kept = append(kept, comp)
+			}
+		}
+		glyphs = kept
+	}
+
+	// Lines are weighted by width: a wandering handwritten line may split
+	// into several bands.
+	var width, handwrittenWidth int
+	for _, line := range groupInkLines(glyphs) {
+		if len(line) < 3 {
+			continue
+		}
+		box := line[0].box
+		for _, comp := range line {
+			box = box.Union(comp.box)
+		}
+		c.TextLines++
+		width += box.X1 - box.X0
+		if inkLineHandwritten(g, line) {
+			c.HandwrittenLines++
+			handwrittenWidth += box.X1 - box.X0
+		}
+	}
+	if c.TextLines == 0 {
+		// Sparse marks (a signature, a stamp) with no line structure.
+		c.Class, c.Confidence = PageClassPrinted, 0.3
+		return c
+	}
+	share := float64(handwrittenWidth) / float64(width)
+	switch {
+	case share >= handwrittenLineRatio:
+		c.Class, c.Confidence = PageClassHandwritten, share
+	case share <= printedLineRatio:
+		c.Class, c.Confidence = PageClassPrinted, 1-share
+	default:
+		c.Class, c.Confidence = PageClassMixed, 1-math.Abs(share-0.5)
+	}
+	return c
+}
+
+// inkComponent is an 8-connected group of dark pixels.
+type inkComponent struct {
+	box    BoundingBox
+	pixels int
+}
+
+// inkComponents labels the dark pixels (<= threshold) inside the rectangle.
+func inkComponents(g *image.Gray, threshold uint8, x0, y0, x1, y1 int) []inkComponent {
+	w := x1 - x0
+	seen := make([]bool, w*(y1-y0))
+	var components []inkComponent
+	var stack []int
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			i := (y-y0)*w + (x - x0)
+			if seen[i] || g.Pix[y*g.Stride+x] > threshold {
+				continue
+			}
+			comp := inkComponent{box: BoundingBox{X0: x, Y0: y, X1: x + 1, Y1: y + 1}}
+			seen[i] = true
+			stack = append(stack[:0], i)
+			for len(stack) > 0 {
+				j := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				px, py := x0+j%w, y0+j/w
+				comp.pixels++
+				comp.box.X0, comp.box.Y0 = min(comp.box.X0, px), min(comp.box.Y0, py)
+				comp.box.X1, comp.box.Y1 = max(comp.box.X1, px+1), max(comp.box.Y1, py+1)
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := px+dx, py+dy
+						if nx < x0 || ny < y0 || nx >= x1 || ny >= y1 {
+							continue
+						}
+						k := (ny-y0)*w + (nx - x0)
+						if !seen[k] && g.Pix[ny*g.Stride+nx] <= threshold {
+							seen[k] = true
+							stack = append(stack, k)
+						}
+					}
+				}
+			}
+			components = append(components, comp)
+		}
+	}
+	return components
+}
+
+// groupInkLines groups components into text lines: a component joins the
+// line it overlaps vertically by at least half of the smaller height.
+func groupInkLines(components []inkComponent) [][]inkComponent {
+	sorted := append([]inkComponent(nil), components...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].box.X0 < sorted[j].box.X0 })
+	type band struct {
+		top, bottom int
+		members     []inkComponent
+	}
+	var bands []*band
+	for _, comp := range sorted {
+		var best *band
+		bestOverlap := 0.0
+		for _, b := range bands {
+			overlap := min(b.bottom, comp.box.Y1) - max(b.top, comp.box.Y0)
+			smaller := min(b.bottom-b.top, comp.box.Y1-comp.box.Y0)
+			if ratio := float64(overlap) / float64(smaller); overlap > 0 && ratio >= 0.5 && ratio > bestOverlap {
+				best, bestOverlap = b, ratio
+			}
+		}
+		if best == nil {
+			bands = append(bands, &band{top: comp.box.Y0, bottom: comp.box.Y1, members: []inkComponent{comp}})
+			continue
+		}
+		best.members = append(best.members, comp)
+		// Follow the line as it drifts, without growing over neighbors.
+		best.top = (best.top*3 + comp.box.Y0) / 4
+		best.bottom = (best.bottom*3 + comp.box.Y1) / 4
+	}
+	lines := make([][]inkComponent, len(bands))
+	for i, b := range bands {
+		lines[i] = b.members
+	}
+	return lines
+}
+
+// inkLineHandwritten decides a line of components from its stroke directions
+// and its baseline. Type is built from vertical stems and horizontal bars,
+// so most edge strength of a printed line lies near the axes; handwriting
+// slants and curves. The baseline is fitted by least squares so that skewed
+// print stays straight; the median absolute deviation of the component
+// bottoms from it ignores descenders.
+func inkLineHandwritten(g *image.Gray, line []inkComponent) bool {
+	heights := make([]float64, len(line))
+	box := line[0].box
+	var sx, sy, sxx, sxy float64
+	for i, comp := range line {
+		heights[i] = float64(comp.box.Y1 - comp.box.Y0)
+		box = box.Union(comp.box)
+		x := float64(comp.box.X0+comp.box.X1) / 2
+		y := float64(comp.box.Y1)
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(line))
+	slope := 0.0
+	if d := n*sxx - sx*sx; d != 0 {
+		slope = (n*sxy - sx*sy) / d
+	}
+	intercept := (sy - slope*sx) / n
+	deviations := make([]float64, len(line))
+	for i, comp := range line {
+		x := float64(comp.box.X0+comp.box.X1) / 2
+		deviations[i] = math.Abs(float64(comp.box.Y1) - (intercept + slope*x))
+	}
+	jitter := medianFloat(deviations) / medianFloat(heights)
+	axis := axisEdgeShare(g, box)
+	return axis < 0.42 || (axis < 0.5 && jitter > 0.15)
+}
+
+// axisEdgeShare is the share of Sobel gradient magnitude within 15 degrees
+// of the horizontal or vertical inside box. Uniformly spread directions give
+// one third.
+func axisEdgeShare(g *image.Gray, box BoundingBox) float64 {
+	var axis, total float64
+	for y := max(box.Y0, 1); y < min(box.Y1, g.Rect.Dy()-1); y++ {
+		for x := max(box.X0, 1); x < min(box.X1, g.Rect.Dx()-1); x++ {
+			p := func(dx, dy int) float64 { return float64(g.Pix[(y+dy)*g.Stride+x+dx]) }
+			gx := p(1, -1) + 2*p(1, 0) + p(1, 1) - p(-1, -1) - 2*p(-1, 0) - p(-1, 1)
+			gy := p(-1, 1) + 2*p(0, 1) + p(1, 1) - p(-1, -1) - 2*p(0, -1) - p(1, -1)
+			magnitude := math.Hypot(gx, gy)
+			if magnitude < 200 {
+				continue
+			}
+			angle := math.Mod(math.Abs(math.Atan2(gy, gx))*180/math.Pi, 90)
+			total += magnitude
+			if angle < 15 || angle > 75 {
+				axis += magnitude
+			}
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return axis / total
+}
+
+func medianFloat(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// pagePlan applies page classifications to the engines of one document.
+type pagePlan struct {
+	classes     map[int]string
+	route       bool      // some page is blank or read by the handwriting engine
+	handwriting OCREngine // reads handwritten pages; nil when the engines do
+	handwritten map[int]OCRPage
+}
+
+// skips reports whether the engines leave a page alone: blank pages, and
+// handwritten pages read by the handwriting engine.
+func (p *pagePlan) skips(pageNumber int) bool {
+	if p == nil || !p.route {
+		return false
+	}
+	class := p.classes[pageNumber]
+	return class == PageClassBlank || (class == PageClassHandwritten && p.handwriting != nil)
+}
+
+// newPagePlan builds the plan for a rendered document; nil without
+// classifications.
+func (s *ocrService) newPagePlan(ctx context.Context, pages *documentPages) *pagePlan {
+	classes := PageClassificationsFromContext(ctx)
+	if len(classes) == 0 {
+		return nil
+	}
+	plan := &pagePlan{classes: map[int]string{}}
+	s.mu.RLock()
+	if s.handwriting != "" {
+		plan.handwriting = s.engines[s.handwriting]
+	}
+	s.mu.RUnlock()
+	var handwritten []int
+	for _, c := range classes {
+		plan.classes[c.PageNumber] = c.Class
+		switch c.Class {
+		case PageClassBlank:
+			plan.route = true
+		case PageClassHandwritten:
+			handwritten = append(handwritten, c.PageNumber)
+		}
+	}
+	if len(handwritten) == 0 {
+		plan.handwriting = nil
+	}
+	if plan.handwriting != nil {
+		plan.route = true
+	}
+	if !plan.route {
+		return plan
+	}
+	if err := pages.render(ctx); err != nil || len(pages.images) == 0 {
+		log.Printf("Cannot route pages of %s, reading them all: %v", pages.filename, err)
+		plan.route, plan.handwriting = false, nil
+		return plan
+	}
+	if plan.handwriting != nil {
+		log.Printf("Routing %d handwritten page(s) of %s to %s", len(handwritten), pages.filename, plan.handwriting.Name())
+		readings, err := pages.recognize(ctx, plan.handwriting, handwritten, nil)
+		if err != nil {
+			log.Printf("Handwriting engine %s failed on %s: %v", plan.handwriting.Name(), pages.filename, err)
+		}
+		plan.handwritten = readings
+	}
+	return plan
+}
+
+// runEngine reads a document with one engine. Without routing the engine
+// reads the whole document and its pages are labeled with their class;
+// otherwise it reads only the pages left to it, page by page.
+func (s *ocrService) runEngine(ctx context.Context, engine OCREngine, pages *documentPages) *OCRResult {
+	plan := pages.plan
+	if plan == nil || !plan.route {
+		result := runOCREngine(ctx, engine, pages.filename, pages.data)
+		if plan != nil {
+			for i := range result.Pages {
+				result.Pages[i].Classification = plan.classes[result.Pages[i].PageNumber]
+			}
+		}
+		return result
+	}
+
+	result := &OCRResult{
+		Filename:          pages.filename,
+		EngineName:        engine.Name(),
+		Status:            "completed",
+		ProcessedAt:       time.Now(),
+		PreprocessProfile: PreprocessorForEngine(engine.Name()).Spec(),
+	}
+	if versioned, ok := engine.(VersionedOCREngine); ok {
+		result.EngineVersion = versioned.Version()
+	}
+	var numbers []int
+	var selected []image.Image
+	for i, img := range pages.images {
+		if plan.skips(i + 1) {
+			continue
+		}
+		numbers = append(numbers, i+1)
+		selected = append(selected, img)
+	}
+	ctx = resolveDocumentLanguages(ctx, engine, result, "", selected)
+	recs, errs := recognizeImages(ctx, engine, selected, pages.dpi)
+	readings := map[int]OCRPage{}
+	var lastErr error
+	for i, rec := range recs {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			log.Printf("Failed to process OCR for page %d with %s: %v", numbers[i], engine.Name(), errs[i])
+			continue
+		}
+		readings[numbers[i]] = OCRPage{
+			PageNumber:      numbers[i],
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
+			EngineName:      engine.Name(),
+		}
+	}
+	if len(numbers) > 0 && len(readings) == 0 && len(plan.handwritten) == 0 {
+		result.Status, result.Error = "failed", fmt.Errorf("no pages were successfully processed: %w", lastErr)
+		return result
+	}
+
+	var read []OCRPage
+	var confidence float64
+	for n := 1; n <= len(pages.images); n++ {
+		class := plan.classes[n]
+		page, ok := readings[n]
+		switch {
+		case class == PageClassBlank:
+			page = OCRPage{PageNumber: n}
+		case class == PageClassHandwritten && plan.handwriting != nil:
+			page, ok = plan.handwritten[n]
+			page.PageNumber = n
+			page.EngineName = plan.handwriting.Name()
+		case !ok:
+			page = OCRPage{PageNumber: n, EngineName: engine.Name()}
+		}
+		page.Classification = class
+		result.Pages = append(result.Pages, page)
+		if ok {
+			read = append(read, page)
+			confidence += page.Confidence
+		}
+	}
+	if len(read) > 0 {
+		result.Confidence = confidence / float64(len(read))
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(pages.filename)), ".")
+	result.ExtractedText = joinPageTexts(read, ext == "pdf")
+	return result
+}
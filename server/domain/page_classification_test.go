@@ -0,0 +1,201 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// blockFont draws letters on a 5x7 grid, the way type is built from stems and
+// bars. Lowercase letters sit in the bottom four rows, with ascenders above.
+var blockFont = map[rune][7]string{
+	'b': {"#....", "#....", "#....", "####.", "#...#", "#...#", "####."},
+	'd': {"....#", "....#", "....#", ".####", "#...#", "#...#", ".####"},
+	'h': {"#....", "#....", "#....", "####.", "#...#", "#...#", "#...#"},
+	'l': {"..#..", "..#..", "..#..", "..#..", "..#..", "..#..", "..##."},
+	'o': {".....", ".....", ".....", ".###.", "#...#", "#...#", ".###."},
+	't': {".#...", ".#...", "####.", ".#...", ".#...", ".#...", "..##."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "#####"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", "#####"},
+}
+
+// newPage returns a white page.
+func newPage(width, height int) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range g.Pix {
+		g.Pix[i] = 255
+	}
+	return g
+}
+
+// printLines sets lines of block letters, 3 pixels per grid cell, in black.
+func printLines(g *image.Gray, lines ...string) {
+	const cell = 3
+	for l, line := range lines {
+		y0 := 60 + l*12*cell
+		x := 60
+		for _, r := range line {
+			for row, bits := range blockFont[r] {
+				for col, bit := range bits {
+					if bit != '#' {
+						continue
+					}
+					for dy := range cell {
+						for dx := range cell {
+							g.SetGray(x+col*cell+dx, y0+row*cell+dy, color.Gray{Y: 20})
+						}
+					}
+				}
+			}
+			x += 7 * cell
+		}
+	}
+}
+
+// writeLines draws lines of cursive-like loops that wander off the baseline.
+func writeLines(g *image.Gray, lines int, rng *rand.Rand) {
+	for l := range lines {
+		baseline := 80 + float64(l)*45
+		for x := 60.0; x < float64(g.Rect.Dx())-60; x += 0.25 {
+			// Words of joined loops with gaps between them
+			if math.Mod(x, 120) > 100 {
+				continue
+			}
+			y := baseline + 8*math.Sin(x/5) + 4*math.Sin(x/37+float64(l))
+			px := x + 4*math.Cos(x/5)
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					g.SetGray(int(px)+dx, int(y)+dy, color.Gray{Y: uint8(30 + rng.IntN(20))})
+				}
+			}
+		}
+	}
+}
+
+// addNoise moves every pixel by up to amount gray levels.
+func addNoise(g *image.Gray, amount int, rng *rand.Rand) {
+	for i, v := range g.Pix {
+		g.Pix[i] = uint8(min(255, max(0, int(v)+rng.IntN(2*amount+1)-amount)))
+	}
+}
+
+// addSpecks darkens a share of the pixels, like dust on the scanner glass.
+func addSpecks(g *image.Gray, share float64, rng *rand.Rand) {
+	for i := range g.Pix {
+		if rng.Float64() < share {
+			g.Pix[i] = 0
+		}
+	}
+}
+
+func TestClassifyPage(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	text := []string{"THE FILE", "HELL ELF IT", "FIT THE TILE", "LIFT THE HUT", "FELT HILL"}
+	for _, tt := range []struct {
+		name  string
+		page  func() image.Image
+		class string
+	}{
+		{"white page", func() image.Image { return newPage(800, 1000) }, PageClassBlank},
+		{"blank page with scanner noise", func() image.Image {
+			g := newPage(800, 1000)
+			addNoise(g, 12, rng)
+			return g
+		}, PageClassBlank},
+		{"blank page with dust specks", func() image.Image {
+			g := newPage(800, 1000)
+			addSpecks(g, 0.0005, rng)
+			return g
+		}, PageClassBlank},
+		{"gray page", func() image.Image {
+			g := newPage(800, 1000)
+			for i := range g.Pix {
+				g.Pix[i] = 128
+			}
+			return g
+		}, PageClassBlank},
+		{"too small to hold text", func() image.Image { return newPage(10, 10) }, PageClassBlank},
+		{"noise over the whole page", func() image.Image {
+			g := newPage(800, 1000)
+			for i := range g.Pix {
+				g.Pix[i] = uint8(rng.IntN(256))
+			}
+			return g
+		}, PageClassPhoto},
+		{"printed text", func() image.Image {
+			g := newPage(800, 1000)
+			printLines(g, text...)
+			return g
+		}, PageClassPrinted},
+		{"noisy printed text", func() image.Image {
+			g := newPage(800, 1000)
+			printLines(g, text...)
+			addNoise(g, 15, rng)
+			addSpecks(g, 0.0005, rng)
+			return g
+		}, PageClassPrinted},
+		{"printed text on a large scan", func() image.Image {
+			g := newPage(800, 1000)
+			printLines(g, text...)
+			large, _ := scaleImage(g, 2)
+			return large
+		}, PageClassPrinted},
+		{"handwriting", func() image.Image {
+			g := newPage(800, 1000)
+			writeLines(g, 5, rng)
+			return g
+		}, PageClassHandwritten},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ClassifyPage(tt.page())
+			if c.Class != tt.class || c.Confidence <= 0 || c.Confidence > 1 {
+				t.Fatalf("class %s at %.2f (ink %.4f, midtones %.3f, %d lines, %d handwritten), want %s",
+					c.Class, c.Confidence, c.InkRatio, c.MidtoneRatio, c.TextLines, c.HandwrittenLines, tt.class)
+			}
+			if tt.class == PageClassPrinted && (c.TextLines < len(text) || c.HandwrittenLines != 0) {
+				t.Fatalf("%d lines, %d handwritten; want %d printed", c.TextLines, c.HandwrittenLines, len(text))
+			}
+		})
+	}
+}
+
+func TestClassifyDocumentPages(t *testing.T) {
+	g := newPage(800, 1000)
+	printLines(g, "THE FILE", "HELL ELF IT", "FIT THE TILE")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	classes, err := ClassifyDocumentPages(context.Background(), "scan.png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 1 || classes[0].PageNumber != 1 || classes[0].Class != PageClassPrinted {
+		t.Fatalf("classes = %+v", classes)
+	}
+
+	summary := SummarizePageClassifications([]PageClassification{
+		{Class: PageClassBlank}, {Class: PageClassPrinted}, {Class: PageClassHandwritten}, {Class: PageClassPrinted},
+	})
+	if summary != "printed=2 handwritten=1 blank=1" {
+		t.Fatalf("summary = %q", summary)
+	}
+
+	ctx := WithPageClassifications(context.Background(), classes)
+	if got := PageClassificationsFromContext(ctx); len(got) != 1 || got[0].Class != PageClassPrinted {
+		t.Fatalf("classifications from context = %+v", got)
+	}
+	if got := PageClassificationsFromContext(WithPageClassifications(context.Background(), nil)); got != nil {
+		t.Fatalf("classifications from a plain context = %+v", got)
+	}
+}
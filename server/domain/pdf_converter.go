@@ -13,6 +13,10 @@ import (
 	"strings"
 )
 
+// pdfRenderDPI is the resolution pdftoppm renders pages at; OCR coordinates of
+// PDF pages are in pixels at this resolution.
+const pdfRenderDPI = 150
+
 // PDFConverter PDF???????????????????
 type PDFConverter interface {
 	// ConvertPDFToImages PDF??????????
@@ -40,13 +44,19 @@ func (c *popplerPDFConverter) ConvertPDFToImages(ctx context.Context, pdfContent
 	}()
 
 	// 2. ??????????????????
-	tempDir := filepath.Dir(tempPDF.Name())
+	// Each conversion gets its own directory so concurrent conversions never
+	// pick up each other's pages.
+	tempDir, err := os.MkdirTemp("", "pdf_pages_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
 	outputPrefix := filepath.Join(tempDir, "pdf_page")
 	
 	// 3. pdftoppm?PDF?PNG???
 	// pdftoppm -png -r 150 input.pdf output_prefix
 	// ??: output_prefix-01.png, output_prefix-02.png, ...
-	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-r", "150", tempPDF.Name(), outputPrefix)
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-r", strconv.Itoa(pdfRenderDPI), tempPDF.Name(), outputPrefix)
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("failed to convert PDF to images: %w", err)
 	}
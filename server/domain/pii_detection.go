@@ -0,0 +1,678 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// PII types of the built-in rules.
+const (
+	PIITypeEmail      = "email"
+	PIITypePhone      = "phone"
+	PIITypeMyNumber   = "my_number"
+	PIITypeCreditCard = "credit_card"
+)
+
+// RedactedEngineName is the engine name the redacted copy of a file's OCR
+// text is stored under.
+const RedactedEngineName = "redacted"
+
+// PIIRule finds one kind of personal information. Pattern is matched against
+// the page text and the text of each layout line; its first capture group (or
+// the whole match) is the value. Validator, when set, must accept the value:
+//
+//   - "luhn": Luhn checksum of the digits (credit cards)
+//   - "my_number": check digit of a 12-digit Japanese Individual Number
+//   - "phone": digit count of domestic (leading 0) or international (+) numbers
+//   - "email": domain with a top-level domain and no empty labels
+//
+// A rule in the rules file replaces the built-in rule of the same name;
+// Disabled turns it off.
+type PIIRule struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Pattern   string `json:"pattern"`
+	Validator string `json:"validator,omitempty"`
+	Disabled  bool   `json:"disabled,omitempty"`
+
+	re       *regexp.Regexp
+	validate func(string) bool
+}
+
+// piiValidators are the validators rules can name.
+var piiValidators = map[string]func(string) bool{
+	"luhn":      validLuhn,
+	"my_number": validMyNumber,
+	"phone":     validPhoneNumber,
+	"email":     validEmail,
+}
+
+// DefaultPIIRules returns the built-in rules. Digits may be separated by
+// spaces or hyphens; full-width characters are folded before matching.
+func DefaultPIIRules() []*PIIRule {
+	return []*PIIRule{
+		{Name: "email", Type: PIITypeEmail, Pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)+`, Validator: "email"},
+		{Name: "credit_card", Type: PIITypeCreditCard, Pattern: `[3-6]\d{3}(?:[ \-]?\d){9,15}`, Validator: "luhn"},
+		{Name: "my_number", Type: PIITypeMyNumber, Pattern: `\d{4}[ \-]?\d{4}[ \-]?\d{4}`, Validator: "my_number"},
+		{Name: "phone", Type: PIITypePhone, Pattern: `(?:\+\d{1,3}[ \-]?)?\(?\d{1,5}\)?(?:[ \-]?\d{1,4}){1,3}`, Validator: "phone"},
+	}
+}
+
+// PIIDetector finds personal information in OCR results.
+type PIIDetector struct {
+	rules []*PIIRule
+}
+
+// NewPIIDetector compiles a rule set.
+func NewPIIDetector(rules []*PIIRule) (*PIIDetector, error) {
+	d := &PIIDetector{}
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		if rule.Name == "" || rule.Type == "" || rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: name, type and pattern are required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", rule.Name, err)
+		}
+		rule.re = re
+		if rule.Validator != "" {
+			rule.validate = piiValidators[rule.Validator]
+			if rule.validate == nil {
+				return nil, fmt.Errorf("rule %s: unknown validator %q", rule.Name, rule.Validator)
+			}
+		}
+		d.rules = append(d.rules, rule)
+	}
+	return d, nil
+}
+
+// Rules returns the names of the active rules.
+func (d *PIIDetector) Rules() []string {
+	names := make([]string, len(d.rules))
+	for i, rule := range d.rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// LoadPIIRules reads a rules file ({"rules": [...]}) and merges it into the
+// built-in rules.
+func LoadPIIRules(path string) ([]*PIIRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PII rules: %w", err)
+	}
+	var file struct {
+		Rules []*PIIRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse PII rules %s: %w", path, err)
+	}
+	rules := DefaultPIIRules()
+	for _, custom := range file.Rules {
+		replaced := false
+		for i, rule := range rules {
+			if rule.Name == custom.Name {
+				if custom.Disabled {
+					rule.Disabled = true
+				} else {
+					rules[i] = custom
+				}
+				replaced = true
+			}
+		}
+		if !replaced {
+			rules = append(rules, custom)
+		}
+	}
+	return rules, nil
+}
+
+// PIIDetectorFromEnv returns the detector configured by OCR_PII_DETECTION
+// (default on; false/0/off/no disables) and OCR_PII_RULES (rules file merged
+// into the built-in rules). It returns nil when detection is disabled.
+func PIIDetectorFromEnv() (*PIIDetector, error) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("OCR_PII_DETECTION"))) {
+	case "false", "0", "off", "no":
+		return nil, nil
+	}
+	rules := DefaultPIIRules()
+	if path := os.Getenv("OCR_PII_RULES"); path != "" {
+		var err error
+		if rules, err = LoadPIIRules(path); err != nil {
+			return nil, err
+		}
+	}
+	return NewPIIDetector(rules)
+}
+
+// PIIFinding is one piece of personal information found in an OCR result.
+// The value itself is not kept; Masked shows its shape.
+type PIIFinding struct {
+	PageNumber int         `json:"page_number"`
+	Type       string      `json:"type"`
+	Rule       string      `json:"rule"`
+	Masked     string      `json:"masked"`
+	Start      int         `json:"start"` // byte offsets in the page text; -1 when found in the layout only
+	End        int         `json:"end"`
+	BBox       BoundingBox `json:"bbox"` // in page image pixels; empty when the page has no layout
+	Confidence float64     `json:"confidence"`
+	Validated  bool        `json:"validated"` // a checksum or format validator accepted the value
+}
+
+// Located reports whether the finding has a position on the page image.
+func (f PIIFinding) Located() bool { return !f.BBox.Empty() }
+
+// PIIReport holds the findings of a file's OCR result.
+type PIIReport struct {
+	Filename          string
+	StorageProvider   string
+	EngineName        string // OCR result that was scanned
+	Findings          []PIIFinding
+	SourceProcessedAt time.Time
+	ScannedAt         time.Time
+}
+
+// piiMatch is a validated rule match in a text.
+type piiMatch struct {
+	rule       *PIIRule
+	start, end int // byte offsets in the original text
+	value      string
+}
+
+// findInText returns the non-overlapping rule matches in text; of
+// overlapping matches the longest wins.
+func (d *PIIDetector) findInText(text string) []piiMatch {
+	folded, offsets := foldWidthWithOffsets(text)
+	var matches []piiMatch
+	for _, rule := range d.rules {
+		// A rejected match is retried one character later, so a longer run
+		// that fails validation does not hide a valid value inside it.
+		for pos := 0; pos < len(folded); {
+			loc := rule.re.FindStringSubmatchIndex(folded[pos:])
+			if loc == nil {
+				break
+			}
+			start, end := pos+loc[0], pos+loc[1]
+			if len(loc) >= 4 && loc[2] >= 0 {
+				start, end = pos+loc[2], pos+loc[3]
+			}
+			value := folded[start:end]
+			if start == end || !piiBoundary(folded, start, end) || (rule.validate != nil && !rule.validate(value)) {
+				_, size := utf8.DecodeRuneInString(folded[pos+loc[0]:])
+				pos += loc[0] + max(size, 1)
+				continue
+			}
+			matches = append(matches, piiMatch{rule: rule, start: offsets[start], end: offsets[end], value: value})
+			pos = max(end, pos+loc[1])
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].end-matches[i].start > matches[j].end-matches[j].start
+	})
+	var kept []piiMatch
+	for _, m := range matches {
+		overlaps := false
+		for _, k := range kept {
+			if m.start < k.end && k.start < m.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept
+}
+
+// piiBoundary rejects matches that are part of a longer word or number,
+// including numbers continued after a space or hyphen.
+func piiBoundary(text string, start, end int) bool {
+	first, _ := utf8.DecodeRuneInString(text[start:])
+	last, _ := utf8.DecodeLastRuneInString(text[:end])
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordRune(first) && isWordRune(before) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(last) && isWordRune(after) {
+		return false
+	}
+	if unicode.IsDigit(last) && end+1 < len(text) && (text[end] == ' ' || text[end] == '-') && isDigitByte(text[end+1]) {
+		return false
+	}
+	if unicode.IsDigit(first) && start >= 2 && (text[start-1] == ' ' || text[start-1] == '-') && isDigitByte(text[start-2]) {
+		return false
+	}
+	return true
+}
+
+// isWordRune reports whether r continues a word. CJK text runs words and
+// numbers together without spaces, so its characters do not.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsDigit(r) || (unicode.IsLetter(r) && !isCJKRune(r))
+}
+
+func isDigitByte(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// foldWidthWithOffsets folds full-width ASCII and ideographic spaces like
+// foldWidth and maps each byte offset of the result (plus the end) back to
+// the original text.
+func foldWidthWithOffsets(text string) (string, []int) {
+	var b strings.Builder
+	offsets := make([]int, 0, len(text)+1)
+	for i, r := range text {
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		case r == 0x3000:
+			r = ' '
+		}
+		n, _ := b.WriteRune(r)
+		for k := 0; k < n; k++ {
+			offsets = append(offsets, i)
+		}
+	}
+	offsets = append(offsets, len(text))
+	return b.String(), offsets
+}
+
+// piiDigits returns the ASCII digits of a value.
+func piiDigits(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// validLuhn checks the Luhn checksum of a 13 to 19 digit number.
+func validLuhn(value string) bool {
+	digits := piiDigits(value)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validMyNumber checks the check digit of a Japanese Individual Number: the
+// 11 digits before it are weighted 2-7, 2-6 from the right, and the check
+// digit is 11 minus the sum mod 11 (0 when the remainder is 0 or 1).
+func validMyNumber(value string) bool {
+	digits := piiDigits(value)
+	if len(digits) != 12 {
+		return false
+	}
+	sum := 0
+	for n := 1; n <= 11; n++ {
+		weight := n + 1
+		if n > 6 {
+			weight = n - 5
+		}
+		sum += int(digits[11-n]-'0') * weight
+	}
+	check := 0
+	if r := sum % 11; r > 1 {
+		check = 11 - r
+	}
+	return int(digits[11]-'0') == check
+}
+
+// validPhoneNumber accepts domestic numbers with a leading 0 and 10 or 11
+// digits, and international numbers with 8 to 15 digits.
+func validPhoneNumber(value string) bool {
+	digits := piiDigits(value)
+	if strings.HasPrefix(value, "+") {
+		return len(digits) >= 8 && len(digits) <= 15
+	}
+	return strings.HasPrefix(strings.TrimPrefix(value, "("), "0") && len(digits) >= 10 && len(digits) <= 11
+}
+
+// validEmail requires a dotted domain without empty labels and an alphabetic
+// top-level domain.
+func validEmail(value string) bool {
+	at := strings.LastIndex(value, "@")
+	if at <= 0 || strings.Contains(value[:at], "..") {
+		return false
+	}
+	labels := strings.Split(value[at+1:], ".")
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	for _, r := range tld {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// maskPIIValue keeps the shape of a value: the last four digits of numbers,
+// and the first character and domain of email addresses.
+func maskPIIValue(piiType string, value string) string {
+	if piiType == PIITypeEmail {
+		if at := strings.LastIndex(value, "@"); at > 0 {
+			first, _ := utf8.DecodeRuneInString(value)
+			return string(first) + strings.Repeat("*", utf8.RuneCountInString(value[:at])-1) + value[at:]
+		}
+	}
+	total := len(piiDigits(value))
+	seen := 0
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			if unicode.IsLetter(r) {
+				return '*'
+			}
+			return r
+		}
+		seen++
+		if seen > total-4 {
+			return r
+		}
+		return '*'
+	}, value)
+}
+
+// piiMatchKey identifies the same value found in the page text and in the layout.
+func piiMatchKey(m piiMatch) string {
+	if m.rule.Type == PIITypeEmail {
+		return m.rule.Name + ":" + strings.ToLower(m.value)
+	}
+	return m.rule.Name + ":" + piiDigits(m.value)
+}
+
+// Detect returns the findings of an OCR result. Each page text is scanned
+// for the offsets used in redacted text, and each layout line for the boxes
+// used in redacted images; a value found in both is reported once.
+func (d *PIIDetector) Detect(result *OCRResult) []PIIFinding {
+	var findings []PIIFinding
+	for _, page := range piiPages(result) {
+		type located struct {
+			match      piiMatch
+			box        BoundingBox
+			confidence float64
+		}
+		var inLayout []located
+		if page.Layout != nil {
+			for _, block := range page.Layout.Blocks {
+				for _, line := range block.Lines {
+					for _, lm := range d.findInLine(line) {
+						inLayout = append(inLayout, located{lm.piiMatch, lm.box, lm.confidence})
+					}
+				}
+			}
+		}
+		used := make([]bool, len(inLayout))
+		for _, m := range d.findInText(page.Text) {
+			finding := newPIIFinding(page.PageNumber, m)
+			finding.Confidence = page.Confidence
+			for i, l := range inLayout {
+				if !used[i] && piiMatchKey(l.match) == piiMatchKey(m) {
+					used[i] = true
+					finding.BBox, finding.Confidence = l.box, l.confidence
+					break
+				}
+			}
+			findings = append(findings, finding)
+		}
+		for i, l := range inLayout {
+			if used[i] {
+				continue
+			}
+			finding := newPIIFinding(page.PageNumber, l.match)
+			finding.Start, finding.End = -1, -1
+			finding.BBox, finding.Confidence = l.box, l.confidence
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+func newPIIFinding(pageNumber int, m piiMatch) PIIFinding {
+	return PIIFinding{
+		PageNumber: pageNumber,
+		Type:       m.rule.Type,
+		Rule:       m.rule.Name,
+		Masked:     maskPIIValue(m.rule.Type, m.value),
+		Start:      m.start,
+		End:        m.end,
+		Validated:  m.rule.validate != nil,
+	}
+}
+
+// piiPages returns the pages of a result; a result without pages is scanned
+// as one page 0 of its extracted text.
+func piiPages(result *OCRResult) []OCRPage {
+	if len(result.Pages) > 0 {
+		return result.Pages
+	}
+	return []OCRPage{{Text: result.ExtractedText, Confidence: result.Confidence}}
+}
+
+// lineMatch is a match in a layout line with the box of the words it covers.
+type lineMatch struct {
+	piiMatch
+	box        BoundingBox
+	confidence float64
+}
+
+// findInLine matches the words of a line joined by spaces. Words covered in
+// part (e.g. "Mail:a@b.jp") are cut in proportion to the characters covered.
+func (d *PIIDetector) findInLine(line OCRLine) []lineMatch {
+	var b strings.Builder
+	spans := make([][2]int, len(line.Words))
+	for i, w := range line.Words {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		spans[i][0] = b.Len()
+		b.WriteString(w.Text)
+		spans[i][1] = b.Len()
+	}
+	text := b.String()
+
+	var out []lineMatch
+	for _, m := range d.findInText(text) {
+		lm := lineMatch{piiMatch: m}
+		var confidence float64
+		var words int
+		for i, w := range line.Words {
+			start, end := max(m.start, spans[i][0]), min(m.end, spans[i][1])
+			if start >= end {
+				continue
+			}
+			box := w.BBox
+			runes := utf8.RuneCountInString(w.Text)
+			if runes > 0 && (start > spans[i][0] || end < spans[i][1]) {
+				from := utf8.RuneCountInString(text[spans[i][0]:start])
+				to := utf8.RuneCountInString(text[spans[i][0]:end])
+				width := float64(w.BBox.Width())
+				box.X0 = w.BBox.X0 + int(width*float64(from)/float64(runes))
+				box.X1 = w.BBox.X0 + int(width*float64(to)/float64(runes)+0.999)
+			}
+			lm.box = lm.box.Union(box)
+			confidence += w.Confidence
+			words++
+		}
+		if words > 0 {
+			lm.confidence = confidence / float64(words)
+		}
+		out = append(out, lm)
+	}
+	return out
+}
+
+// RedactText replaces the findings located in a page text with their type in
+// brackets, e.g. "[EMAIL]".
+func RedactText(text string, findings []PIIFinding) string {
+	spans := make([]PIIFinding, 0, len(findings))
+	for _, f := range findings {
+		if f.Start >= 0 && f.End <= len(text) && f.Start < f.End {
+			spans = append(spans, f)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start > spans[j].Start })
+	end := len(text)
+	for _, f := range spans {
+		if f.End > end {
+			continue
+		}
+		text = text[:f.Start] + "[" + strings.ToUpper(f.Type) + "]" + text[f.End:]
+		end = f.Start
+	}
+	return text
+}
+
+// BuildRedactedResult copies the text of an OCR result with the findings
+// replaced. Layouts, tables and barcodes are left out, since they carry the
+// values too.
+func BuildRedactedResult(source *OCRResult, findings []PIIFinding) *OCRResult {
+	byPage := map[int][]PIIFinding{}
+	for _, f := range findings {
+		byPage[f.PageNumber] = append(byPage[f.PageNumber], f)
+	}
+	version := source.EngineName
+	if source.EngineVersion != "" {
+		version += "@" + source.EngineVersion
+	}
+	result := &OCRResult{
+		StorageProvider:   source.StorageProvider,
+		Filename:          source.Filename,
+		EngineName:        RedactedEngineName,
+		Status:            "completed",
+		ProcessedAt:       time.Now(),
+		Confidence:        source.Confidence,
+		PreprocessProfile: source.PreprocessProfile,
+		DetectedLanguage:  source.DetectedLanguage,
+		Languages:         source.Languages,
+		EngineVersion:     version,
+	}
+	if len(source.Pages) == 0 {
+		result.ExtractedText = RedactText(source.ExtractedText, byPage[0])
+		return result
+	}
+	for _, page := range source.Pages {
+		result.Pages = append(result.Pages, OCRPage{
+			PageNumber:     page.PageNumber,
+			Text:           RedactText(page.Text, byPage[page.PageNumber]),
+			Confidence:     page.Confidence,
+			EngineName:     page.EngineName,
+			Classification: page.Classification,
+		})
+	}
+	result.ExtractedText = joinPageTexts(result.Pages, pageMarkerPattern.MatchString(source.ExtractedText))
+	return result
+}
+
+// RedactDocumentPII scans the best stored OCR result of a file (ensemble,
+// then cascade, then the most confident engine), stores the findings and
+// the redacted text as the "redacted" result. It returns nil when the file
+// has no completed result; the source result comes back with its layouts
+// for redacting the page images.
+func RedactDocumentPII(ctx context.Context, repo OCRResultRepository, detector *PIIDetector, filename string, provider string) (*PIIReport, *OCRResult, error) {
+	results, err := repo.GetOCRComparison(ctx, filename, provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	source := preferredFieldSource(results)
+	if source == nil {
+		return nil, nil, nil
+	}
+	if err := attachStoredLayout(ctx, repo, source); err != nil {
+		return nil, nil, err
+	}
+	report := &PIIReport{
+		Filename:          filename,
+		StorageProvider:   provider,
+		EngineName:        source.EngineName,
+		Findings:          detector.Detect(source),
+		SourceProcessedAt: source.ProcessedAt,
+		ScannedAt:         time.Now(),
+	}
+	if err := repo.SaveOCRResult(ctx, BuildRedactedResult(source, report.Findings)); err != nil {
+		return nil, nil, fmt.Errorf("failed to save redacted text: %w", err)
+	}
+	if err := repo.SavePIIReport(ctx, report); err != nil {
+		return nil, nil, err
+	}
+	return report, source, nil
+}
+
+// PIIFindingsToProto converts findings for gRPC responses.
+func PIIFindingsToProto(findings []PIIFinding) []*pb.PIIFinding {
+	out := make([]*pb.PIIFinding, 0, len(findings))
+	for _, f := range findings {
+		finding := &pb.PIIFinding{
+			PageNumber: int32(f.PageNumber),
+			Type:       f.Type,
+			Rule:       f.Rule,
+			Masked:     f.Masked,
+			Start:      int32(f.Start),
+			End:        int32(f.End),
+			Confidence: f.Confidence,
+			Validated:  f.Validated,
+		}
+		if f.Located() {
+			finding.Bbox = boundingBoxToProto(f.BBox)
+		}
+		out = append(out, finding)
+	}
+	return out
+}
+
+// PIIReportToProto builds the GetPIIFindings response; redacted is the
+// recorded redacted copy, or nil.
+func PIIReportToProto(report *PIIReport, redacted *DerivedFile) *pb.PIIFindingsResponse {
+	resp := &pb.PIIFindingsResponse{
+		Filename:           report.Filename,
+		StorageProvider:    report.StorageProvider,
+		EngineName:         report.EngineName,
+		Findings:           PIIFindingsToProto(report.Findings),
+		RedactedEngineName: RedactedEngineName,
+		Status:             "completed",
+		ScannedAt:          report.ScannedAt.Unix(),
+	}
+	if redacted != nil {
+		resp.RedactedStoragePath = redacted.StoragePath
+	}
+	return resp
+}
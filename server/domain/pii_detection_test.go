@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPIIDetectorFindInText(t *testing.T) {
+	detector, err := NewPIIDetector(DefaultPIIRules())
+	if err != nil {
+		t.Fatalf("NewPIIDetector: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		text string
+		want []string // rule:value as found in the original text
+	}{
+		{"email", "Contact taro.yamada@example.co.jp today", []string{"email:taro.yamada@example.co.jp"}},
+		{"email without a top-level domain", "mail a@b.c or x@localhost", nil},
+		{"email with an empty label", "send to x@example..com", nil},
+		{"credit card", "Card 4111 1111 1111 1111 exp 12/28", []string{"credit_card:4111 1111 1111 1111"}},
+		{"credit card failing luhn", "Card 4111 1111 1111 1112", nil},
+		{"my number", "Individual Number: 1234-5678-9018", []string{"my_number:1234-5678-9018"}},
+		{"my number with a wrong check digit", "Individual Number: 1234-5678-9019", nil},
+		{"full-width my number", "番号１２３４５６７８９０１８です", []string{"my_number:１２３４５６７８９０１８"}},
+		{"domestic phone", "TEL 03-1234-5678 / 090 1234 5678", []string{"phone:03-1234-5678", "phone:090 1234 5678"}},
+		{"international phone", "Call +81 3-1234-5678.", []string{"phone:+81 3-1234-5678"}},
+		{"phone in parentheses", "(03) 1234 5678", []string{"phone:(03) 1234 5678"}},
+		{"part of a longer number", "Order 0312345678901234 and ID12345678", nil},
+		{"number continued after a space", "0312 3456 78 90", nil},
+		{"dates are not phones", "Issued 2026-10-18", nil},
+		{"several findings in order", "a@example.com, 03-1234-5678, 4111111111111111",
+			[]string{"email:a@example.com", "phone:03-1234-5678", "credit_card:4111111111111111"}},
+		{"nothing", "", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range detector.findInText(tt.text) {
+				got = append(got, m.rule.Name+":"+tt.text[m.start:m.end])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("findInText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPIIDetectorCustomRules(t *testing.T) {
+	rules := append(DefaultPIIRules(), &PIIRule{Name: "employee", Type: "employee_id", Pattern: `EMP-(\d{6})`})
+	rules[0].Disabled = true
+	detector, err := NewPIIDetector(rules)
+	if err != nil {
+		t.Fatalf("NewPIIDetector: %v", err)
+	}
+	text := "EMP-004211 wrote to a@example.com"
+	matches := detector.findInText(text)
+	if len(matches) != 1 || matches[0].rule.Name != "employee" || text[matches[0].start:matches[0].end] != "004211" {
+		t.Fatalf("findInText(%q) = %+v, want the employee number only", text, matches)
+	}
+
+	for _, bad := range []*PIIRule{
+		{Name: "x", Type: "x", Pattern: `(`},
+		{Name: "x", Type: "x", Pattern: `x`, Validator: "crc"},
+		{Name: "", Type: "x", Pattern: `x`},
+	} {
+		if _, err := NewPIIDetector([]*PIIRule{bad}); err == nil {
+			t.Errorf("NewPIIDetector(%+v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestMaskPIIValue(t *testing.T) {
+	for _, tt := range []struct {
+		piiType, value, want string
+	}{
+		{PIITypeEmail, "taro@example.com", "t***@example.com"},
+		{PIITypeCreditCard, "4111 1111 1111 1111", "**** **** **** 1111"},
+		{PIITypePhone, "+81 3-1234-5678", "+** *-****-5678"},
+		{PIITypeMyNumber, "123456789018", "********9018"},
+	} {
+		if got := maskPIIValue(tt.piiType, tt.value); got != tt.want {
+			t.Errorf("maskPIIValue(%s, %q) = %q, want %q", tt.piiType, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// RedactedNamespace is the storage namespace of redacted copies of uploads.
+// Objects are stored as redacted/<filename>, PDFs and JPEG/PNG images keeping
+// their format and other images converted to PNG. Tenant files are stored
+// under tenants/<tenant>/.
+const RedactedNamespace = "redacted/"
+
+// DownloadVariantRedacted selects the redacted copy in FileDownloadRequest.variant.
+const DownloadVariantRedacted = "redacted"
+
+// RedactedFilename returns the download name of the redacted copy of a file.
+func RedactedFilename(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf", ".png", ".jpg", ".jpeg":
+		return filename
+	}
+	return filename + ".png"
+}
+
+// RedactedPath returns the storage path of the redacted copy of a file.
+func RedactedPath(filename string) string {
+	tenant, name := SplitTenantFileKey(filename)
+	return tenantStoragePrefix(tenant) + RedactedNamespace + RedactedFilename(trimStorageNamespace(name))
+}
+
+// CanRedactImages reports whether a redacted copy can be drawn for a file,
+// i.e. whether it is a PDF or an image.
+func CanRedactImages(filename string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	return ext == "pdf" || (isImageFile(ext) && ext != "svg" && ext != "ico")
+}
+
+// redactionPadding is added around each box, in page image pixels, so glyph
+// edges outside the OCR box are covered too.
+const redactionPadding = 3
+
+// GenerateRedactedCopy paints the located findings black on the page images
+// of a PDF or image file. PDFs become image-only PDFs without text, images
+// keep their format (PNG for formats Go cannot write). A page with a finding
+// that has no box is covered entirely, so no value can show through. It
+// returns the file and its page count.
+func GenerateRedactedCopy(ctx context.Context, filename string, content io.Reader, source *OCRResult, findings []PIIFinding) ([]byte, int, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if !CanRedactImages(filename) {
+		return nil, 0, fmt.Errorf("redacted copies are only supported for PDF and image files: %s", filename)
+	}
+
+	var images []image.Image
+	if ext == "pdf" {
+		var err error
+		images, err = NewPDFConverter().ConvertPDFToImages(ctx, content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to convert PDF to images: %w", err)
+		}
+	} else {
+		img, _, err := image.Decode(content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode image: %w", err)
+		}
+		images = []image.Image{img}
+	}
+
+	layouts := map[int]*OCRPageLayout{}
+	for _, page := range source.Pages {
+		layouts[page.PageNumber] = page.Layout
+	}
+	redacted := make([]image.Image, len(images))
+	for i, img := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		pageNumber := i + 1
+		var pageFindings []PIIFinding
+		for _, f := range findings {
+			// Findings of a result without pages (page 0) apply to single images
+			if f.PageNumber == pageNumber || (f.PageNumber == 0 && len(images) == 1) {
+				pageFindings = append(pageFindings, f)
+			}
+		}
+		redacted[i] = redactPageImage(img, layouts[pageNumber], pageFindings)
+	}
+
+	var out bytes.Buffer
+	switch ext {
+	case "pdf":
+		w := newSearchablePDFWriter()
+		for _, img := range redacted {
+			if err := w.addPage(img, nil, pdfRenderDPI); err != nil {
+				return nil, 0, err
+			}
+		}
+		return w.finish(), len(redacted), nil
+	case "jpg", "jpeg":
+		if err := jpeg.Encode(&out, redacted[0], &jpeg.Options{Quality: 90}); err != nil {
+			return nil, 0, fmt.Errorf("failed to encode redacted image: %w", err)
+		}
+	default:
+		if err := png.Encode(&out, redacted[0]); err != nil {
+			return nil, 0, fmt.Errorf("failed to encode redacted image: %w", err)
+		}
+	}
+	return out.Bytes(), 1, nil
+}
+
+// redactPageImage returns a copy of img with the findings' boxes filled
+// black. Boxes are in layout pixels and are rescaled when the image was
+// rendered at another size.
+func redactPageImage(img image.Image, layout *OCRPageLayout, findings []PIIFinding) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+	if len(findings) == 0 {
+		return out
+	}
+	sx, sy := 1.0, 1.0
+	if layout != nil && layout.Width > 0 && layout.Height > 0 {
+		sx = float64(bounds.Dx()) / float64(layout.Width)
+		sy = float64(bounds.Dy()) / float64(layout.Height)
+	}
+	black := image.NewUniform(color.Black)
+	for _, f := range findings {
+		if !f.Located() {
+			draw.Draw(out, out.Bounds(), black, image.Point{}, draw.Src)
+			return out
+		}
+		r := image.Rect(
+			int(float64(f.BBox.X0)*sx)-redactionPadding,
+			int(float64(f.BBox.Y0)*sy)-redactionPadding,
+			int(float64(f.BBox.X1)*sx+0.999)+redactionPadding,
+			int(float64(f.BBox.Y1)*sy+0.999)+redactionPadding,
+		).Intersect(out.Bounds())
+		draw.Draw(out, r, black, image.Point{}, draw.Src)
+	}
+	return out
+}
@@ -206,10 +206,7 @@ func (q *pubsubQueueService) EnqueueOCRTask(ctx context.Context, filename string
 	}
 
 	// OCRTask?JSON???????
-	task := &OCRTask{
-		Filename:        filename,
-		StorageProvider: storageProvider,
-	}
+	task := NewOCRTask(filename, storageProvider)
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
 		return fmt.Errorf("failed to marshal OCR task: %w", err)
@@ -21,6 +21,26 @@ type QueueService interface {
 type OCRTask struct {
 	Filename        string
 	StorageProvider string
+	// Tenant is the tenant of the file key, checked again by the worker so
+	// a task can never make it read another tenant's file.
+	Tenant string
+}
+
+// NewOCRTask creates the task of a file key.
+func NewOCRTask(filename string, storageProvider string) *OCRTask {
+	return &OCRTask{
+		Filename:        filename,
+		StorageProvider: storageProvider,
+		Tenant:          TenantOfFilename(filename),
+	}
+}
+
+// Validate checks that the task's tenant matches its file key.
+func (t *OCRTask) Validate() error {
+	if tenant := TenantOfFilename(t.Filename); tenant != t.Tenant {
+		return fmt.Errorf("OCR task for %s carries tenant %q, but the file belongs to %q", t.Filename, t.Tenant, tenant)
+	}
+	return nil
 }
 
 // queueServiceInstances ???????????????????????????????
@@ -77,10 +97,7 @@ func NewCommonQueueService() QueueService {
 }
 
 func (q *commonQueueService) EnqueueOCRTask(ctx context.Context, filename string, storageProvider string) error {
-	task := &OCRTask{
-		Filename:        filename,
-		StorageProvider: storageProvider,
-	}
+	task := NewOCRTask(filename, storageProvider)
 	
 	select {
 	case q.tasks <- task:
@@ -68,7 +68,8 @@ func NewQueueTaskStore(ctx context.Context) (QueueTaskStore, error) {
 			enqueued_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			dequeued_at DATETIME,
 			processed_at DATETIME,
-			error_message TEXT
+			error_message TEXT,
+			tenant TEXT NOT NULL DEFAULT ''
 		);
 		CREATE INDEX IF NOT EXISTS idx_queue_filename_provider ON queue_tasks(filename, storage_provider);
 		CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_tasks(status);
@@ -78,6 +79,11 @@ func NewQueueTaskStore(ctx context.Context) (QueueTaskStore, error) {
 		return nil, fmt.Errorf("failed to create queue_tasks table: %w", err)
 	}
 
+	// tenant was added after the first release
+	if err := ensureColumn(ctx, db, "queue_tasks", "tenant", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
 	store := &sqliteQueueTaskStore{db: db}
 	log.Printf("QueueTaskStore initialized successfully (db: %s)", dbPath)
 	return store, nil
@@ -103,10 +109,10 @@ func GetOrCreateQueueTaskStore(ctx context.Context) (QueueTaskStore, error) {
 
 func (s *sqliteQueueTaskStore) LogEnqueue(ctx context.Context, filename string, storageProvider string) error {
 	query := `
-		INSERT INTO queue_tasks (filename, storage_provider, status, enqueued_at)
-		VALUES (?, ?, 'enqueued', CURRENT_TIMESTAMP)
+		INSERT INTO queue_tasks (filename, storage_provider, status, enqueued_at, tenant)
+		VALUES (?, ?, 'enqueued', CURRENT_TIMESTAMP, ?)
 	`
-	_, err := s.db.ExecContext(ctx, query, filename, storageProvider)
+	_, err := s.db.ExecContext(ctx, query, filename, storageProvider, TenantOfFilename(filename))
 	if err != nil {
 		log.Printf("Warning: Failed to log enqueue: %v", err)
 		return err
@@ -132,10 +138,10 @@ func (s *sqliteQueueTaskStore) LogDequeue(ctx context.Context, filename string,
 	if rowsAffected == 0 {
 		// ??????????????????
 		query = `
-			INSERT INTO queue_tasks (filename, storage_provider, status, enqueued_at, dequeued_at)
-			VALUES (?, ?, 'dequeued', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			INSERT INTO queue_tasks (filename, storage_provider, status, enqueued_at, dequeued_at, tenant)
+			VALUES (?, ?, 'dequeued', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
 		`
-		_, err = s.db.ExecContext(ctx, query, filename, storageProvider)
+		_, err = s.db.ExecContext(ctx, query, filename, storageProvider, TenantOfFilename(filename))
 		return err
 	}
 	return nil
@@ -232,16 +238,16 @@ func (s *sqliteQueueTaskStore) GetNextTask(ctx context.Context, storageProvider
 
 	// ????enqueued?????????
 	query := `
-		SELECT filename, storage_provider, id
+		SELECT filename, storage_provider, id, tenant
 		FROM queue_tasks
 		WHERE storage_provider = ? AND status = 'enqueued'
 		ORDER BY enqueued_at ASC
 		LIMIT 1
 	`
 	
-	var filename, provider string
+	var filename, provider, tenant string
 	var taskID int64
-	err = tx.QueryRowContext(ctx, query, storageProvider).Scan(&filename, &provider, &taskID)
+	err = tx.QueryRowContext(ctx, query, storageProvider).Scan(&filename, &provider, &taskID, &tenant)
 	if err == sql.ErrNoRows {
 		return nil, nil // ?????
 	}
@@ -267,5 +273,6 @@ func (s *sqliteQueueTaskStore) GetNextTask(ctx context.Context, storageProvider
 	return &OCRTask{
 		Filename:        filename,
 		StorageProvider: provider,
+		Tenant:          tenant,
 	}, nil
 }
@@ -0,0 +1,256 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when an upload would exceed a storage quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// TenantQuota limits the storage of a tenant; 0 means unlimited.
+type TenantQuota struct {
+	MaxBytes    int64 `json:"max_bytes"`     // total size of all files
+	MaxFiles    int64 `json:"max_files"`     // number of files
+	MaxFileSize int64 `json:"max_file_size"` // size of one file
+}
+
+// TenantUsage is the storage a tenant uses, from file_metadata.
+type TenantUsage struct {
+	Bytes int64
+	Files int64
+}
+
+// QuotaPolicy holds the quotas of all tenants. Callers without a tenant use
+// the "" tenant and its quota like any other.
+type QuotaPolicy struct {
+	Default TenantQuota            `json:"default"`
+	Tenants map[string]TenantQuota `json:"tenants"`
+
+	mu      sync.Mutex
+	ledgers map[string]*quotaLedger
+}
+
+// quotaLedger is what file_metadata does not show yet of a tenant: the
+// bytes and files reserved by uploads in progress, and those stored since
+// the process started. Uploads read file_metadata once, so the ledger keeps
+// concurrent uploads from each passing the quota on their own.
+type quotaLedger struct {
+	reserved TenantUsage
+	stored   TenantUsage
+}
+
+func (p *QuotaPolicy) ledger(tenant string) *quotaLedger {
+	if p.ledgers == nil {
+		p.ledgers = make(map[string]*quotaLedger)
+	}
+	l, ok := p.ledgers[tenant]
+	if !ok {
+		l = &quotaLedger{}
+		p.ledgers[tenant] = l
+	}
+	return l
+}
+
+// For returns the quota of tenant.
+func (p *QuotaPolicy) For(tenant string) TenantQuota {
+	if p == nil {
+		return TenantQuota{}
+	}
+	if q, ok := p.Tenants[tenant]; ok {
+		return q
+	}
+	return p.Default
+}
+
+// QuotaPolicyFromEnv builds the quota policy. The default quota comes from
+// QUOTA_MAX_BYTES, QUOTA_MAX_FILES and QUOTA_MAX_FILE_SIZE (sizes accept
+// K/M/G suffixes); QUOTA_FILE names an optional JSON file of the form
+// {"default": {...}, "tenants": {"acme": {"max_bytes": 1073741824}}} whose
+// entries override them. It returns nil when no quota is configured.
+func QuotaPolicyFromEnv() (*QuotaPolicy, error) {
+	policy := &QuotaPolicy{}
+	for _, v := range []struct {
+		name  string
+		value *int64
+		size  bool
+	}{
+		{"QUOTA_MAX_BYTES", &policy.Default.MaxBytes, true},
+		{"QUOTA_MAX_FILES", &policy.Default.MaxFiles, false},
+		{"QUOTA_MAX_FILE_SIZE", &policy.Default.MaxFileSize, true},
+	} {
+		value := strings.TrimSpace(os.Getenv(v.name))
+		if value == "" {
+			continue
+		}
+		var n int64
+		var err error
+		if v.size {
+			n, err = ParseByteSize(value)
+		} else {
+			n, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", v.name, value)
+		}
+		*v.value = n
+	}
+
+	if path := os.Getenv("QUOTA_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quota file: %w", err)
+		}
+		var file struct {
+			Default *TenantQuota           `json:"default"`
+			Tenants map[string]TenantQuota `json:"tenants"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse quota file %s: %w", path, err)
+		}
+		if file.Default != nil {
+			policy.Default = *file.Default
+		}
+		policy.Tenants = file.Tenants
+	}
+
+	if policy.Default == (TenantQuota{}) && len(policy.Tenants) == 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// ParseByteSize parses a size such as "500", "64K", "10M" or "2G" (binary
+// units; a trailing "B" or "iB" is accepted).
+func ParseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// UploadQuota tracks one upload against the quota of its tenant, so it can
+// be rejected while chunks are still arriving. The bytes it has been
+// checked for, and the file it adds, stay reserved until Release.
+type UploadQuota struct {
+	policy   *QuotaPolicy
+	tenant   string
+	quota    TenantQuota
+	usage    TenantUsage // from file_metadata when the upload started
+	stored   TenantUsage // ledger.stored when the upload started
+	reserved TenantUsage
+	replaced int64 // size of the file the upload overwrites
+}
+
+// StartUpload checks that the tenant of key may store one more file,
+// reserves it and returns the tracker for its chunks. Overwriting a file of
+// provider does not count as a new file, and its old size is released. A
+// nil policy allows everything.
+func (p *QuotaPolicy) StartUpload(ctx context.Context, repo FileMetadataRepository, key string, provider string) (*UploadQuota, error) {
+	tenant := TenantOfFilename(key)
+	quota := p.For(tenant)
+	if quota == (TenantQuota{}) {
+		return nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage, err := repo.TenantUsage(ctx, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage usage: %w", err)
+	}
+	ledger := p.ledger(tenant)
+	u := &UploadQuota{policy: p, tenant: tenant, quota: quota, usage: usage, stored: ledger.stored}
+	existing, err := repo.FindByFilename(ctx, key, provider)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		u.replaced = existing.Size
+		return u, nil
+	}
+	if files := u.used(ledger).Files; quota.MaxFiles > 0 && files >= quota.MaxFiles {
+		return nil, fmt.Errorf("%w: tenant %q already stores %d of %d files", ErrQuotaExceeded, tenant, files, quota.MaxFiles)
+	}
+	u.reserved.Files = 1
+	ledger.reserved.Files++
+	return u, nil
+}
+
+// used is the usage of the tenant other than the upload's own reservation.
+func (u *UploadQuota) used(ledger *quotaLedger) TenantUsage {
+	return TenantUsage{
+		Bytes: u.usage.Bytes + ledger.stored.Bytes - u.stored.Bytes + ledger.reserved.Bytes - u.reserved.Bytes,
+		Files: u.usage.Files + ledger.stored.Files - u.stored.Files + ledger.reserved.Files - u.reserved.Files,
+	}
+}
+
+// Check returns ErrQuotaExceeded once an upload of size bytes is over the
+// file size limit or would take the tenant over its total, counting the
+// reservations of other uploads; otherwise it reserves size bytes. It is a
+// no-op on nil.
+func (u *UploadQuota) Check(size int64) error {
+	if u == nil {
+		return nil
+	}
+	if u.quota.MaxFileSize > 0 && size > u.quota.MaxFileSize {
+		return fmt.Errorf("%w: files of tenant %q are limited to %d bytes", ErrQuotaExceeded, u.tenant, u.quota.MaxFileSize)
+	}
+	u.policy.mu.Lock()
+	defer u.policy.mu.Unlock()
+	ledger := u.policy.ledger(u.tenant)
+	used := u.used(ledger).Bytes
+	if u.quota.MaxBytes > 0 && used-u.replaced+size > u.quota.MaxBytes {
+		return fmt.Errorf("%w: tenant %q uses %d of %d bytes", ErrQuotaExceeded, u.tenant, used, u.quota.MaxBytes)
+	}
+	if size > u.reserved.Bytes {
+		ledger.reserved.Bytes += size - u.reserved.Bytes
+		u.reserved.Bytes = size
+	}
+	return nil
+}
+
+// Release gives up the reservation of the upload. With stored, the upload
+// was recorded in file_metadata, so its size counts as used until the next
+// upload reads it from there. Release is a no-op on nil and after the first
+// call.
+func (u *UploadQuota) Release(stored bool) {
+	if u == nil {
+		return
+	}
+	u.policy.mu.Lock()
+	defer u.policy.mu.Unlock()
+	ledger := u.policy.ledger(u.tenant)
+	ledger.reserved.Bytes -= u.reserved.Bytes
+	ledger.reserved.Files -= u.reserved.Files
+	if stored {
+		ledger.stored.Bytes += u.reserved.Bytes - u.replaced
+		ledger.stored.Files += u.reserved.Files
+	}
+	u.reserved = TenantUsage{}
+	u.replaced = 0
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// usageRepository serves the usage of file_metadata to StartUpload.
+type usageRepository struct {
+	FileMetadataRepository
+	usage    TenantUsage
+	existing map[string]*FileMetadata
+}
+
+func (r *usageRepository) TenantUsage(ctx context.Context, tenant string) (TenantUsage, error) {
+	return r.usage, nil
+}
+
+func (r *usageRepository) FindByFilename(ctx context.Context, filename string, provider string) (*FileMetadata, error) {
+	return r.existing[filename], nil
+}
+
+func TestUploadQuotaCheck(t *testing.T) {
+	repo := &usageRepository{
+		usage:    TenantUsage{Bytes: 600, Files: 2},
+		existing: map[string]*FileMetadata{"tenants/acme/old.txt": {Size: 300}},
+	}
+	policy := &QuotaPolicy{Tenants: map[string]TenantQuota{"acme": {MaxBytes: 1000, MaxFiles: 3, MaxFileSize: 500}}}
+	for _, tt := range []struct {
+		name     string
+		key      string
+		size     int64
+		startErr error
+		checkErr error
+	}{
+		{"within the quota", "tenants/acme/a.txt", 400, nil, nil},
+		{"over the file size", "tenants/acme/a.txt", 501, nil, ErrQuotaExceeded},
+		{"over the total", "tenants/acme/a.txt", 401, nil, ErrQuotaExceeded},
+		{"overwrite releases the old size", "tenants/acme/old.txt", 500, nil, nil},
+		{"tenant without a quota", "tenants/other/a.txt", 1 << 40, nil, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := policy.StartUpload(context.Background(), repo, tt.key, "s3")
+			if !errors.Is(err, tt.startErr) {
+				t.Fatalf("StartUpload: err = %v, want %v", err, tt.startErr)
+			}
+			defer u.Release(false)
+			if err := u.Check(tt.size); !errors.Is(err, tt.checkErr) {
+				t.Fatalf("Check(%d): err = %v, want %v", tt.size, err, tt.checkErr)
+			}
+		})
+	}
+}
+
+func TestUploadQuotaReservations(t *testing.T) {
+	ctx := context.Background()
+	repo := &usageRepository{}
+	policy := &QuotaPolicy{Default: TenantQuota{MaxBytes: 1000, MaxFiles: 2}}
+
+	first, err := policy.StartUpload(ctx, repo, "a.txt", "s3")
+	if err != nil {
+		t.Fatalf("StartUpload: %v", err)
+	}
+	if err := first.Check(600); err != nil {
+		t.Fatalf("first Check: %v", err)
+	}
+	second, err := policy.StartUpload(ctx, repo, "b.txt", "s3")
+	if err != nil {
+		t.Fatalf("StartUpload: %v", err)
+	}
+	if err := second.Check(600); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("concurrent Check: err = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := policy.StartUpload(ctx, repo, "c.txt", "s3"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third StartUpload: err = %v, want ErrQuotaExceeded", err)
+	}
+
+	// A failed upload frees its reservation
+	first.Release(false)
+	if err := second.Check(600); err != nil {
+		t.Fatalf("Check after release: %v", err)
+	}
+
+	// A stored upload counts for uploads that read file_metadata before it
+	third, err := policy.StartUpload(ctx, repo, "c.txt", "s3")
+	if err != nil {
+		t.Fatalf("StartUpload: %v", err)
+	}
+	second.Release(true)
+	second.Release(true)
+	if err := third.Check(401); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Check after store: err = %v, want ErrQuotaExceeded", err)
+	}
+	third.Release(false)
+
+	// and for later uploads through file_metadata
+	repo.usage = TenantUsage{Bytes: 600, Files: 1}
+	fourth, err := policy.StartUpload(ctx, repo, "d.txt", "s3")
+	if err != nil {
+		t.Fatalf("StartUpload after store: %v", err)
+	}
+	if err := fourth.Check(401); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Check after store: err = %v, want ErrQuotaExceeded", err)
+	}
+	if err := fourth.Check(400); err != nil {
+		t.Fatalf("Check after store: %v", err)
+	}
+	fourth.Release(false)
+}
+
+func TestParseByteSize(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{"500", 500, false},
+		{"64K", 64 << 10, false},
+		{"10MB", 10 << 20, false},
+		{"2GiB", 2 << 30, false},
+		{" 1t ", 1 << 40, false},
+		{"", 0, true},
+		{"ten", 0, true},
+	} {
+		got, err := ParseByteSize(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d (error %v)", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a caller exceeds a rate limit.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit is a token bucket: Rate tokens per second, up to Burst.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// ParseRateLimit parses "rate:burst", e.g. "2:10"; the burst defaults to the
+// rate (at least 1).
+func ParseRateLimit(value string) (RateLimit, error) {
+	ratePart, burstPart, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+	rate, err := strconv.ParseFloat(ratePart, 64)
+	if err != nil || rate <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q (want rate:burst, e.g. 2:10)", value)
+	}
+	limit := RateLimit{Rate: rate, Burst: math.Max(1, rate)}
+	if hasBurst {
+		burst, err := strconv.ParseFloat(burstPart, 64)
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: burst must be at least 1", value)
+		}
+		limit.Burst = burst
+	}
+	return limit, nil
+}
+
+// tokenBucket is the state of one bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = limit.Burst
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(limit.Burst, b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+}
+
+// wait is how long until the bucket has a token again.
+func (b *tokenBucket) wait(limit RateLimit) time.Duration {
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// rateLimitIdle is how long an unused bucket is kept; it is full by then.
+const rateLimitIdle = 10 * time.Minute
+
+// RateLimiter applies token-bucket limits per principal: one bucket for all
+// RPCs of a caller, and one per RPC for RPCs with their own limit (e.g.
+// UploadFile). A call needs a token from both.
+type RateLimiter struct {
+	Default     RateLimit
+	RPCs        map[string]RateLimit // by method name, e.g. "UploadFile"
+	ExemptRoles []string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter creates a limiter with a default limit per caller and
+// per-RPC limits.
+func NewRateLimiter(defaultLimit RateLimit, rpcs map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{Default: defaultLimit, RPCs: rpcs, buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// RateLimiterFromEnv configures rate limiting:
+//
+//   - RATE_LIMIT: true enables it (default off; returns nil)
+//   - RATE_LIMIT_DEFAULT: limit of each caller over all RPCs (default 20:40)
+//   - RATE_LIMIT_RPCS: per-RPC limits of each caller
+//     (default "UploadFile=2:10,FinalizeUpload=2:10,ProcessOCR=2:10,EvaluateOCR=0.2:2")
+//   - RATE_LIMIT_EXEMPT_ROLES: roles without limits (default "service")
+func RateLimiterFromEnv() (*RateLimiter, error) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("RATE_LIMIT"))) {
+	case "true", "1", "on", "yes":
+	default:
+		return nil, nil
+	}
+	defaultValue := os.Getenv("RATE_LIMIT_DEFAULT")
+	if defaultValue == "" {
+		defaultValue = "20:40"
+	}
+	defaultLimit, err := ParseRateLimit(defaultValue)
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_DEFAULT: %w", err)
+	}
+	rpcsValue, ok := os.LookupEnv("RATE_LIMIT_RPCS")
+	if !ok {
+		rpcsValue = "UploadFile=2:10,FinalizeUpload=2:10,ProcessOCR=2:10,EvaluateOCR=0.2:2"
+	}
+	rpcs := make(map[string]RateLimit)
+	for _, entry := range strings.Split(rpcsValue, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		rpc, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("RATE_LIMIT_RPCS: invalid entry %q (want RPC=rate:burst)", entry)
+		}
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_RPCS: %w", err)
+		}
+		rpcs[strings.TrimSpace(rpc)] = limit
+	}
+	limiter := NewRateLimiter(defaultLimit, rpcs)
+	exempt, ok := os.LookupEnv("RATE_LIMIT_EXEMPT_ROLES")
+	if !ok {
+		exempt = "service"
+	}
+	for _, role := range strings.Split(exempt, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			limiter.ExemptRoles = append(limiter.ExemptRoles, role)
+		}
+	}
+	return limiter, nil
+}
+
+// rateLimitKey identifies the caller: each API key has its own buckets.
+func rateLimitKey(principal *Principal) string {
+	if principal.APIKeyID != "" {
+		return "key:" + principal.APIKeyID
+	}
+	return "sub:" + principal.Tenant + "/" + principal.Subject
+}
+
+func (l *RateLimiter) exempt(principal *Principal) bool {
+	for _, role := range l.ExemptRoles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow takes a token for a call of method by principal. It returns
+// ErrRateLimited, and how long to wait, when a bucket is empty.
+func (l *RateLimiter) Allow(principal *Principal, method string) (time.Duration, error) {
+	if principal == nil || l.exempt(principal) {
+		return 0, nil
+	}
+	rpc := method[strings.LastIndex(method, "/")+1:]
+	key := rateLimitKey(principal)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	type check struct {
+		name   string
+		limit  RateLimit
+		bucket *tokenBucket
+	}
+	checks := []check{{"calls", l.Default, l.bucket(key+"|*", l.Default, now)}}
+	if limit, ok := l.RPCs[rpc]; ok {
+		checks = append(checks, check{rpc + " calls", limit, l.bucket(key+"|"+rpc, limit, now)})
+	}
+	// Take tokens only when every bucket has one
+	for _, c := range checks {
+		if c.bucket.tokens < 1 {
+			wait := c.bucket.wait(c.limit)
+			return wait, fmt.Errorf("%w: at most %g %s per second (burst %g); retry in %s",
+				ErrRateLimited, c.limit.Rate, c.name, c.limit.Burst, wait.Round(time.Millisecond))
+		}
+	}
+	for _, c := range checks {
+		c.bucket.tokens--
+	}
+	return 0, nil
+}
+
+// Remaining returns the default limit of principal and the tokens left in
+// its bucket.
+func (l *RateLimiter) Remaining(principal *Principal) (RateLimit, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(rateLimitKey(principal)+"|*", l.Default, l.now())
+	return l.Default, b.tokens
+}
+
+func (l *RateLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+	b.refill(limit, now)
+	return b
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
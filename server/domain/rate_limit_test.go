@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  RateLimit
+		err   bool
+	}{
+		{"2:10", RateLimit{Rate: 2, Burst: 10}, false},
+		{"5", RateLimit{Rate: 5, Burst: 5}, false},
+		{"0.2", RateLimit{Rate: 0.2, Burst: 1}, false},
+		{"0:10", RateLimit{}, true},
+		{"2:0.5", RateLimit{}, true},
+		{"fast", RateLimit{}, true},
+	} {
+		got, err := ParseRateLimit(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v; want %+v (error %v)", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice := &Principal{Subject: "alice", Tenant: "acme"}
+	bob := &Principal{Subject: "bob", Tenant: "acme"}
+	service := &Principal{Subject: "ocr", Roles: []string{"service"}}
+
+	type call struct {
+		principal *Principal
+		method    string
+		after     time.Duration // since start
+		limited   bool
+	}
+	for _, tt := range []struct {
+		name  string
+		calls []call
+	}{
+		{"burst then limited", []call{
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, true},
+		}},
+		{"refills over time", []call{
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", time.Second, false},
+			{alice, "/greeter.Greeter/ListFiles", time.Second, true},
+		}},
+		{"per-RPC limit", []call{
+			{alice, "/greeter.Greeter/UploadFile", 0, false},
+			{alice, "/greeter.Greeter/UploadFile", 0, true},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+		}},
+		{"rejected calls take no tokens", []call{
+			{alice, "/greeter.Greeter/UploadFile", 0, false},
+			{alice, "/greeter.Greeter/UploadFile", 0, true},
+			{alice, "/greeter.Greeter/UploadFile", 0, true},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, false},
+			{alice, "/greeter.Greeter/ListFiles", 0, true},
+		}},
+		{"callers have their own buckets", []call{
+			{alice, "/greeter.Greeter/UploadFile", 0, false},
+			{alice, "/greeter.Greeter/UploadFile", 0, true},
+			{bob, "/greeter.Greeter/UploadFile", 0, false},
+		}},
+		{"exempt roles and anonymous calls", []call{
+			{service, "/greeter.Greeter/UploadFile", 0, false},
+			{service, "/greeter.Greeter/UploadFile", 0, false},
+			{nil, "/greeter.Greeter/UploadFile", 0, false},
+			{nil, "/greeter.Greeter/UploadFile", 0, false},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 3}, map[string]RateLimit{"UploadFile": {Rate: 0.5, Burst: 1}})
+			limiter.ExemptRoles = []string{"service"}
+			limiter.now = func() time.Time { return now }
+			for i, c := range tt.calls {
+				now = start.Add(c.after)
+				wait, err := limiter.Allow(c.principal, c.method)
+				if limited := errors.Is(err, ErrRateLimited); limited != c.limited {
+					t.Fatalf("call %d: err = %v, want limited %v", i, err, c.limited)
+				}
+				if c.limited && wait <= 0 {
+					t.Fatalf("call %d: wait = %v, want a positive wait", i, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterFromEnvIsOptIn(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		on    bool
+	}{
+		{"", false},
+		{"false", false},
+		{"true", true},
+		{"on", true},
+	} {
+		t.Setenv("RATE_LIMIT", tt.value)
+		limiter, err := RateLimiterFromEnv()
+		if err != nil {
+			t.Fatalf("RATE_LIMIT=%q: %v", tt.value, err)
+		}
+		if (limiter != nil) != tt.on {
+			t.Errorf("RATE_LIMIT=%q: limiter = %v, want enabled %v", tt.value, limiter != nil, tt.on)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package domain
+
+import "fmt"
+
+// Arithmetic in GF(256) with the QR code polynomial x^8+x^4+x^3+x^2+1.
+var gfExp, gfLog = func() ([512]byte, [256]int) {
+	var exp [512]byte
+	var log [256]int
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+// gfPow returns alpha^e.
+func gfPow(e int) byte {
+	e %= 255
+	if e < 0 {
+		e += 255
+	}
+	return gfExp[e]
+}
+
+// gfPolyEval evaluates a polynomial with coefficients in ascending powers.
+func gfPolyEval(poly []byte, x byte) byte {
+	var y byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ poly[i]
+	}
+	return y
+}
+
+// rsCorrect corrects a Reed-Solomon codeword in place: data followed by
+// ecLen check bytes, first byte the highest power, generator roots alpha^0
+// to alpha^(ecLen-1). It returns the number of corrected bytes.
+func rsCorrect(codeword []byte, ecLen int) (int, error) {
+	n := len(codeword)
+	syndromes := make([]byte, ecLen)
+	clean := true
+	for i := range syndromes {
+		x := gfPow(i)
+		var s byte
+		for _, c := range codeword {
+			s = gfMul(s, x) ^ c
+		}
+		syndromes[i] = s
+		if s != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp-Massey: error locator with coefficients in ascending powers.
+	locator := []byte{1}
+	prev := []byte{1}
+	errors, shift := 0, 1
+	var prevDiscrepancy byte = 1
+	for k := 0; k < ecLen; k++ {
+		d := syndromes[k]
+		for i := 1; i <= errors && i < len(locator); i++ {
+			d ^= gfMul(locator[i], syndromes[k-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		coef := gfDiv(d, prevDiscrepancy)
+		next := append([]byte(nil), locator...)
+		for len(next) < len(prev)+shift {
+			next = append(next, 0)
+		}
+		for i, p := range prev {
+			next[i+shift] ^= gfMul(coef, p)
+		}
+		if 2*errors <= k {
+			prev, prevDiscrepancy = locator, d
+			errors = k + 1 - errors
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = next
+	}
+	if errors*2 > ecLen {
+		return 0, fmt.Errorf("too many errors")
+	}
+
+	// Chien search for the error positions.
+	var positions []int
+	for k := 0; k < n; k++ {
+		if gfPolyEval(locator, gfPow(-(n-1-k))) == 0 {
+			positions = append(positions, k)
+		}
+	}
+	if len(positions) != errors {
+		return 0, fmt.Errorf("error locator has %d roots for %d errors", len(positions), errors)
+	}
+
+	// Forney: error evaluator S(x)*locator(x) mod x^ecLen.
+	evaluator := make([]byte, ecLen)
+	for i := 0; i < ecLen; i++ {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= gfMul(syndromes[i-j], locator[j])
+		}
+	}
+	derivative := make([]byte, len(locator))
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+	for _, k := range positions {
+		x := gfPow(n - 1 - k)
+		xInv := gfPow(-(n - 1 - k))
+		denominator := gfPolyEval(derivative, xInv)
+		if denominator == 0 {
+			return 0, fmt.Errorf("cannot compute error value")
+		}
+		codeword[k] ^= gfMul(x, gfDiv(gfPolyEval(evaluator, xInv), denominator))
+	}
+	return errors, nil
+}
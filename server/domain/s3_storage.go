@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -57,7 +58,7 @@ func NewS3StorageService() (StorageService, error) {
 		}
 	}
 
-	return &s3StorageService{s3Client: s3Client}, nil
+	return withEncryption(&s3StorageService{s3Client: s3Client})
 }
 
 func isBucketAlreadyOwnedByYouError(err error) bool {
@@ -72,24 +73,11 @@ func isBucketAlreadyOwnedByYouError(err error) bool {
 }
 
 func (s *s3StorageService) UploadFile(ctx context.Context, filename string, content io.Reader) (*pb.FileUploadStatus, error) {
-	// Convert io.Reader to bytes.Reader to make it seekable for S3 PutObject
-	contentBytes, err := io.ReadAll(content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read content for S3 upload: %w", err)
-	}
-	reader := bytes.NewReader(contentBytes)
-
 	// Build storage path with namespace prefix (documents/, media/, or others/)
 	storagePath := BuildStoragePath(filename)
 
-	_, err = s.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s3BucketName),
-		Key:    aws.String(storagePath),
-		Body:   reader,
-		ContentLength: aws.Int64(int64(len(contentBytes))),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to upload file to S3: %w", err)
+	if err := s.putObject(ctx, storagePath, content); err != nil {
+		return nil, err
 	}
 
 	return &pb.FileUploadStatus{
@@ -143,10 +131,108 @@ func (s *s3StorageService) DownloadFileByPath(ctx context.Context, storagePath s
 	return buf, nil
 }
 
+func (s *s3StorageService) UploadFileByPath(ctx context.Context, storagePath string, content io.Reader) error {
+	return s.putObject(ctx, storagePath, content)
+}
+
+const (
+	// s3UploadPartSize is the part size of multipart uploads; S3 requires at
+	// least 5 MiB for every part but the last.
+	s3UploadPartSize = 8 << 20
+	// s3MaxUploadParts is the S3 limit on parts, which bounds objects to
+	// about 78 GiB at s3UploadPartSize.
+	s3MaxUploadParts = 10000
+)
+
+// putObject streams content to key. Content of up to one part is stored with
+// a single PutObject; longer content is sent as a multipart upload, one part
+// at a time, so at most one part is held in memory.
+func (s *s3StorageService) putObject(ctx context.Context, key string, content io.Reader) error {
+	var part bytes.Buffer
+	if _, err := part.ReadFrom(io.LimitReader(content, s3UploadPartSize)); err != nil {
+		return fmt.Errorf("failed to read content for S3 upload: %w", err)
+	}
+	if part.Len() < s3UploadPartSize {
+		_, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(s3BucketName),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(part.Bytes()),
+			ContentLength: aws.Int64(int64(part.Len())),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload file to S3: %w", err)
+		}
+		return nil
+	}
+
+	created, err := s.s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s3BucketName),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start S3 multipart upload: %w", err)
+	}
+	// abort discards the uploaded parts, which S3 would otherwise keep (and bill)
+	abort := func(cause error) error {
+		_, err := s.s3Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s3BucketName),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		if err != nil {
+			log.Printf("Warning: failed to abort S3 multipart upload of %s: %v", key, err)
+		}
+		return cause
+	}
+
+	var parts []types.CompletedPart
+	for number := int32(1); part.Len() > 0; number++ {
+		if number > s3MaxUploadParts {
+			return abort(fmt.Errorf("failed to upload file to S3: content exceeds %d parts of %d bytes", s3MaxUploadParts, s3UploadPartSize))
+		}
+		uploaded, err := s.s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(s3BucketName),
+			Key:               aws.String(key),
+			UploadId:          created.UploadId,
+			PartNumber:        aws.Int32(number),
+			Body:              bytes.NewReader(part.Bytes()),
+			ContentLength:     aws.Int64(int64(part.Len())),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d to S3: %w", number, err))
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:          uploaded.ETag,
+			PartNumber:    aws.Int32(number),
+			ChecksumCRC32: uploaded.ChecksumCRC32,
+		})
+
+		part.Reset()
+		if _, err := part.ReadFrom(io.LimitReader(content, s3UploadPartSize)); err != nil {
+			return abort(fmt.Errorf("failed to read content for S3 upload: %w", err))
+		}
+	}
+
+	_, err = s.s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s3BucketName),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("failed to complete S3 multipart upload: %w", err))
+	}
+	return nil
+}
+
 func (s *s3StorageService) DeleteFile(ctx context.Context, filename string) error {
 	// Build storage path with namespace prefix
-	storagePath := BuildStoragePath(filename)
+	return s.DeleteFileByPath(ctx, BuildStoragePath(filename))
+}
 
+func (s *s3StorageService) DeleteFileByPath(ctx context.Context, storagePath string) error {
 	_, err := s.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s3BucketName),
 		Key:    aws.String(storagePath),
@@ -157,6 +243,39 @@ func (s *s3StorageService) DeleteFile(ctx context.Context, filename string) erro
 	return nil
 }
 
+// SignedUploadURL presigns a PutObject request. A known size is signed, so
+// S3 refuses bodies of another size.
+func (s *s3StorageService) SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s3BucketName),
+		Key:    aws.String(storagePath),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if size > 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	req, err := s3.NewPresignClient(s.s3Client).PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign S3 upload: %w", err)
+	}
+	return &SignedURL{URL: req.URL, Method: req.Method, Headers: signedHeaders(req.SignedHeader), ExpiresAt: time.Now().Add(expires), Direct: true}, nil
+}
+
+// SignedDownloadURL presigns a GetObject request.
+func (s *s3StorageService) SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error) {
+	req, err := s3.NewPresignClient(s.s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s3BucketName),
+		Key:                        aws.String(storagePath),
+		ResponseContentDisposition: aws.String(attachmentDisposition(downloadName)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign S3 download: %w", err)
+	}
+	return &SignedURL{URL: req.URL, Method: req.Method, Headers: signedHeaders(req.SignedHeader), ExpiresAt: time.Now().Add(expires), Direct: true}, nil
+}
+
 func (s *s3StorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, error) {
 	var files []*pb.FileInfo
 	
@@ -173,7 +292,7 @@ func (s *s3StorageService) ListFiles(ctx context.Context) ([]*pb.FileInfo, error
 		for _, obj := range page.Contents {
 			// Skip directory prefixes (keys ending with "/")
 			key := aws.ToString(obj.Key)
-			if strings.HasSuffix(key, "/") {
+			if strings.HasSuffix(key, "/") || IsDerivedStoragePath(key) {
 				continue
 			}
 			
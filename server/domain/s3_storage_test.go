@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 implements the object and multipart upload calls of the S3 API.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte // upload ID -> part number -> data
+	puts     int                       // single PutObject calls
+	parts    int                       // parts of completed multipart uploads
+	aborted  int
+	failPart int // part number to reject; 0 for none
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n := r.Header.Get("X-Amz-Decoded-Content-Length"); n != "" {
+		// aws-chunked: the payload is framed in signed chunks
+		body = decodeAWSChunked(body)
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok || number == f.failPart {
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := f.uploads[query.Get("uploadId")]
+		var object []byte
+		for i, p := range complete.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				http.Error(w, "<Error><Code>InvalidPartOrder</Code></Error>", http.StatusBadRequest)
+				return
+			}
+			object = append(object, parts[p.PartNumber]...)
+		}
+		f.objects[key] = object
+		f.parts += len(complete.Parts)
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.puts++
+		w.Header().Set("ETag", `"put"`)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+// decodeAWSChunked strips the chunk framing of an aws-chunked payload.
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			break
+		}
+		size, err := strconv.ParseInt(string(bytes.SplitN(header, []byte(";"), 2)[0]), 16, 64)
+		if err != nil || size == 0 {
+			break
+		}
+		out = append(out, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func newFakeS3Storage(t *testing.T) (*s3StorageService, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	previous := s3BucketName
+	s3BucketName = "bucket"
+	t.Cleanup(func() { s3BucketName = previous })
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return &s3StorageService{s3Client: client}, fake
+}
+
+// patternReader produces n bytes without holding them.
+type patternReader struct{ n, off int }
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off >= r.n {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.n-r.off)
+	for i := range n {
+		p[i] = byte((r.off + i) % 251)
+	}
+	r.off += n
+	return n, nil
+}
+
+func TestS3UploadStreamsInParts(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name  string
+		size  int
+		parts int // 0 for a single PutObject
+	}{
+		{"empty", 0, 0},
+		{"small", 1000, 0},
+		{"just below a part", s3UploadPartSize - 1, 0},
+		{"exactly one part", s3UploadPartSize, 1},
+		{"several parts", 2*s3UploadPartSize + 12345, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, fake := newFakeS3Storage(t)
+			if err := storage.UploadFileByPath(ctx, "documents/scan.pdf", &patternReader{n: tt.size}); err != nil {
+				t.Fatalf("UploadFileByPath: %v", err)
+			}
+			want, _ := io.ReadAll(&patternReader{n: tt.size})
+			if got := fake.objects["documents/scan.pdf"]; !bytes.Equal(got, want) {
+				t.Fatalf("stored %d bytes, want the %d bytes uploaded", len(got), len(want))
+			}
+			wantPuts := 0
+			if tt.parts == 0 {
+				wantPuts = 1
+			}
+			if fake.puts != wantPuts || fake.parts != tt.parts || len(fake.uploads) != 0 {
+				t.Fatalf("%d PutObject calls, %d parts, %d open uploads; want %d calls and %d parts", fake.puts, fake.parts, len(fake.uploads), wantPuts, tt.parts)
+			}
+		})
+	}
+}
+
+func TestS3UploadAbortsFailedMultipartUploads(t *testing.T) {
+	ctx := context.Background()
+	storage, fake := newFakeS3Storage(t)
+	fake.failPart = 2
+	err := storage.UploadFileByPath(ctx, "documents/scan.pdf", &patternReader{n: 3 * s3UploadPartSize})
+	if err == nil || !strings.Contains(err.Error(), "part 2") {
+		t.Fatalf("err = %v, want part 2 to fail", err)
+	}
+	if fake.aborted != 1 || len(fake.uploads) != 0 || len(fake.objects) != 0 {
+		t.Fatalf("%d aborted, %d open uploads, %d objects; want the upload aborted", fake.aborted, len(fake.uploads), len(fake.objects))
+	}
+
+	// A read error of the content aborts as well
+	fake.failPart = 0
+	content := io.MultiReader(&patternReader{n: s3UploadPartSize + 10}, errReader{})
+	if err := storage.UploadFileByPath(ctx, "documents/scan.pdf", content); err == nil || !strings.Contains(err.Error(), "failed to read content") {
+		t.Fatalf("err = %v, want the read error", err)
+	}
+	if fake.aborted != 2 || len(fake.uploads) != 0 || len(fake.objects) != 0 {
+		t.Fatalf("%d aborted, %d open uploads, %d objects after a read error", fake.aborted, len(fake.uploads), len(fake.objects))
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, fmt.Errorf("connection reset") }
@@ -0,0 +1,302 @@
+package domain
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SearchablePDFNamespace is the storage namespace of generated searchable PDFs.
+// Objects are stored as searchable/<engine>/<filename>.pdf, under
+// tenants/<tenant>/ for tenant files.
+const SearchablePDFNamespace = "searchable/"
+
+// DownloadVariantSearchable selects the searchable PDF in FileDownloadRequest.variant.
+const DownloadVariantSearchable = "searchable"
+
+// searchablePDFImageDPI is the resolution assumed for uploaded images when
+// sizing their PDF pages.
+const searchablePDFImageDPI = 150
+
+// SearchablePDFFilename returns the download name of the searchable PDF of a file.
+func SearchablePDFFilename(filename string) string {
+	if strings.EqualFold(filepath.Ext(filename), ".pdf") {
+		return filename
+	}
+	return filename + ".pdf"
+}
+
+// SearchablePDFPath returns the storage path of the searchable PDF generated
+// from the given file and OCR engine.
+func SearchablePDFPath(filename string, engineName string) string {
+	tenant, name := SplitTenantFileKey(filename)
+	return tenantStoragePrefix(tenant) + SearchablePDFNamespace + engineName + "/" + SearchablePDFFilename(trimStorageNamespace(name))
+}
+
+// GenerateSearchablePDF renders the original page images of a PDF or image file
+// into a new PDF with an invisible text layer positioned from the OCR layout.
+// It returns the PDF and its page count.
+func GenerateSearchablePDF(ctx context.Context, filename string, content io.Reader, layout *OCRLayout) ([]byte, int, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+
+	var images []image.Image
+	dpi := float64(searchablePDFImageDPI)
+	switch {
+	case ext == "pdf":
+		var err error
+		images, err = NewPDFConverter().ConvertPDFToImages(ctx, content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to convert PDF to images: %w", err)
+		}
+		dpi = pdfRenderDPI
+	case isImageFile(ext):
+		img, _, err := image.Decode(content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode image: %w", err)
+		}
+		images = []image.Image{img}
+	default:
+		return nil, 0, fmt.Errorf("searchable PDF is only supported for PDF and image files: %s", filename)
+	}
+
+	layouts := map[int]*OCRPageLayout{}
+	if layout != nil {
+		for i := range layout.Pages {
+			layouts[layout.Pages[i].PageNumber] = &layout.Pages[i].OCRPageLayout
+		}
+	}
+
+	w := newSearchablePDFWriter()
+	for i, img := range images {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		if err := w.addPage(img, layouts[i+1], dpi); err != nil {
+			return nil, 0, fmt.Errorf("failed to write page %d: %w", i+1, err)
+		}
+	}
+	return w.finish(), len(images), nil
+}
+
+// searchablePDFWriter writes a PDF whose pages are a JPEG image plus invisible
+// text drawn with the embedded glyphless font (Identity-H, CID = UTF-16 code unit).
+type searchablePDFWriter struct {
+	buf        bytes.Buffer
+	offsets    []int // byte offset of object i+1
+	pageIDs    []int
+	usedBlocks map[rune]bool // high bytes of the CIDs in use, for ToUnicode
+}
+
+// Objects 1-8 are fixed: catalog, page tree and the font objects.
+const (
+	pdfCatalogID = iota + 1
+	pdfPagesID
+	pdfType0FontID
+	pdfCIDFontID
+	pdfFontDescriptorID
+	pdfFontFileID
+	pdfCIDToGIDMapID
+	pdfToUnicodeID
+)
+
+func newSearchablePDFWriter() *searchablePDFWriter {
+	w := &searchablePDFWriter{usedBlocks: map[rune]bool{}}
+	w.buf.WriteString("%PDF-1.5\n%\xE2\xE3\xCF\xD3\n")
+	w.offsets = make([]int, pdfToUnicodeID)
+
+	w.object(pdfType0FontID, fmt.Sprintf(
+		"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		glyphlessFontName, pdfCIDFontID, pdfToUnicodeID))
+	w.object(pdfCIDFontID, fmt.Sprintf(
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW %d /CIDToGIDMap %d 0 R >>",
+		glyphlessFontName, pdfFontDescriptorID, glyphlessAdvance, pdfCIDToGIDMapID))
+	w.object(pdfFontDescriptorID, fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 5 /FontBBox [0 0 %d %d] /ItalicAngle 0 /Ascent %d /Descent 0 /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		glyphlessFontName, glyphlessAdvance, glyphlessUnitsPerEm, glyphlessUnitsPerEm, glyphlessUnitsPerEm, pdfFontFileID))
+	font := glyphlessFont()
+	w.stream(pdfFontFileID, fmt.Sprintf("/Length1 %d", len(font)), font, true)
+
+	// Every CID maps to glyph 1.
+	cidToGID := make([]byte, 2*65536)
+	for i := 1; i < len(cidToGID); i += 2 {
+		cidToGID[i] = 1
+	}
+	w.stream(pdfCIDToGIDMapID, "", cidToGID, true)
+	return w
+}
+
+func (w *searchablePDFWriter) newObjectID() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *searchablePDFWriter) object(id int, body string) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *searchablePDFWriter) stream(id int, entries string, data []byte, compress bool) {
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		data = z.Bytes()
+		entries += " /Filter /FlateDecode"
+	}
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", id, strings.TrimSpace(entries), len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// addPage writes one page: the image scaled to the page and, when a layout is
+// given, one invisible text run per word stretched to the word's box.
+func (w *searchablePDFWriter) addPage(img image.Image, layout *OCRPageLayout, dpi float64) error {
+	bounds := img.Bounds()
+	scale := 72.0 / dpi
+	pageW := float64(bounds.Dx()) * scale
+	pageH := float64(bounds.Dy()) * scale
+
+	imageData, colorSpace, err := encodePageImage(img)
+	if err != nil {
+		return err
+	}
+	imageID := w.newObjectID()
+	w.stream(imageID, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /DCTDecode",
+		bounds.Dx(), bounds.Dy(), colorSpace), imageData, false)
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q\n%.2f 0 0 %.2f 0 0 cm\n/Im0 Do\nQ\n", pageW, pageH)
+	if layout != nil {
+		// Layout coordinates are in OCR image pixels; rescale if the page was
+		// rendered at a different size.
+		sx, sy := scale, scale
+		if layout.Width > 0 && layout.Height > 0 {
+			sx = pageW / float64(layout.Width)
+			sy = pageH / float64(layout.Height)
+		}
+		content.WriteString("BT\n3 Tr\n")
+		for _, block := range layout.Blocks {
+			for _, line := range block.Lines {
+				for wi, word := range line.Words {
+					width := float64(word.BBox.Width()) * sx
+					height := float64(word.BBox.Height()) * sy
+					n := utf8.RuneCountInString(word.Text)
+					if width <= 0 || height <= 0 || n == 0 {
+						continue
+					}
+					text := word.Text
+					if wi < len(line.Words)-1 {
+						// Trailing space keeps words apart when text is copied
+						text += " "
+					}
+					hz := 100 * width / (float64(n) * height * glyphlessAdvance / glyphlessUnitsPerEm)
+					fmt.Fprintf(&content, "/F1 %.2f Tf %.2f Tz 1 0 0 1 %.2f %.2f Tm <%s> Tj\n",
+						height, hz, float64(word.BBox.X0)*sx, pageH-float64(word.BBox.Y1)*sy, w.encodeText(text))
+				}
+			}
+		}
+		content.WriteString("ET\n")
+	}
+	contentID := w.newObjectID()
+	w.stream(contentID, "", content.Bytes(), true)
+
+	pageID := w.newObjectID()
+	w.object(pageID, fmt.Sprintf(
+		"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+		pdfPagesID, pageW, pageH, pdfType0FontID, imageID, contentID))
+	w.pageIDs = append(w.pageIDs, pageID)
+	return nil
+}
+
+// encodeText hex-encodes text as 2-byte CIDs. Characters outside the BMP are
+// replaced by U+FFFD since CIDs are limited to 16 bits.
+func (w *searchablePDFWriter) encodeText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if r > 0xFFFF {
+			r = utf8.RuneError
+		}
+		w.usedBlocks[r>>8] = true
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// finish writes the ToUnicode CMap, page tree, catalog and cross-reference table.
+func (w *searchablePDFWriter) finish() []byte {
+	w.stream(pdfToUnicodeID, "", w.toUnicodeCMap(), true)
+
+	kids := make([]string, len(w.pageIDs))
+	for i, id := range w.pageIDs {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	w.object(pdfPagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pageIDs)))
+	w.object(pdfCatalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesID))
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, off := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, pdfCatalogID, xref)
+	return w.buf.Bytes()
+}
+
+// toUnicodeCMap maps each CID back to the same code point, one bfrange per
+// 256-code block in use (a bfrange may only vary the last byte).
+func (w *searchablePDFWriter) toUnicodeCMap() []byte {
+	blocks := make([]int, 0, len(w.usedBlocks))
+	for hi := range w.usedBlocks {
+		blocks = append(blocks, int(hi))
+	}
+	sort.Ints(blocks)
+
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(blocks); start += 100 {
+		end := min(start+100, len(blocks))
+		fmt.Fprintf(&b, "%d beginbfrange\n", end-start)
+		for _, hi := range blocks[start:end] {
+			fmt.Fprintf(&b, "<%02X00> <%02XFF> <%02X00>\n", hi, hi, hi)
+		}
+		b.WriteString("endbfrange\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// encodePageImage encodes a page image as JPEG, flattening transparency onto white.
+func encodePageImage(img image.Image) ([]byte, string, error) {
+	colorSpace := "DeviceRGB"
+	switch src := img.(type) {
+	case *image.Gray:
+		colorSpace = "DeviceGray"
+	default:
+		if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			flat := image.NewRGBA(src.Bounds())
+			draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.Draw(flat, flat.Bounds(), src, src.Bounds().Min, draw.Over)
+			img = flat
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode page image: %w", err)
+	}
+	return b.Bytes(), colorSpace, nil
+}
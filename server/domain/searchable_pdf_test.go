@@ -0,0 +1,240 @@
+package domain
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pdfReader resolves the objects of a PDF through its cross-reference table,
+// the way a viewer does.
+type pdfReader struct {
+	t       *testing.T
+	objects map[int][]byte
+	trailer string
+}
+
+func newPDFReader(t *testing.T, data []byte) *pdfReader {
+	t.Helper()
+	r := &pdfReader{t: t, objects: map[int][]byte{}}
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("PDF has no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	section := string(data[xref:])
+	header := regexp.MustCompile(`^xref\n0 (\d+)\n`).FindStringSubmatch(section)
+	if header == nil {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	size, _ := strconv.Atoi(header[1])
+	entries := section[len(header[0]):]
+	for i := 1; i < size; i++ {
+		entry := entries[i*20 : (i+1)*20]
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil || !strings.HasSuffix(entry, " n \n") {
+			t.Fatalf("xref entry %d = %q", i, entry)
+		}
+		prefix := fmt.Sprintf("%d 0 obj\n", i)
+		if !bytes.HasPrefix(data[offset:], []byte(prefix)) {
+			t.Fatalf("xref offset %d of object %d points at %q", offset, i, data[offset:min(offset+20, len(data))])
+		}
+		body := data[offset+len(prefix):]
+		r.objects[i] = body[:bytes.Index(body, []byte("\nendobj\n"))]
+	}
+	r.trailer = entries[size*20:]
+	return r
+}
+
+// ref returns the object referenced by key (e.g. "/Root") in dict.
+func (r *pdfReader) ref(dict string, key string) string {
+	r.t.Helper()
+	m := regexp.MustCompile(regexp.QuoteMeta(key) + ` (\d+) 0 R`).FindStringSubmatch(dict)
+	if m == nil {
+		r.t.Fatalf("%s not found in %.200s", key, dict)
+	}
+	id, _ := strconv.Atoi(m[1])
+	obj, ok := r.objects[id]
+	if !ok {
+		r.t.Fatalf("%s refers to missing object %d", key, id)
+	}
+	return string(obj)
+}
+
+// stream returns the decoded data of a stream object.
+func (r *pdfReader) stream(obj string) []byte {
+	r.t.Helper()
+	dict, rest, ok := strings.Cut(obj, "\nstream\n")
+	if !ok {
+		r.t.Fatalf("not a stream: %.100s", obj)
+	}
+	length, _ := strconv.Atoi(regexp.MustCompile(`/Length (\d+)`).FindStringSubmatch(dict)[1])
+	data := []byte(rest[:length])
+	if !strings.Contains(dict, "/FlateDecode") {
+		return data
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return decoded
+}
+
+// pages returns the page objects in the order of the page tree.
+func (r *pdfReader) pages() []string {
+	r.t.Helper()
+	tree := r.ref(r.ref(r.trailer, "/Root"), "/Pages")
+	kids := regexp.MustCompile(`/Kids \[([^\]]*)\]`).FindStringSubmatch(tree)
+	if kids == nil {
+		r.t.Fatalf("page tree has no kids: %s", tree)
+	}
+	var pages []string
+	for _, ref := range regexp.MustCompile(`(\d+) 0 R`).FindAllStringSubmatch(kids[1], -1) {
+		id, _ := strconv.Atoi(ref[1])
+		pages = append(pages, string(r.objects[id]))
+	}
+	return pages
+}
+
+// pdfTextRun is a string shown on a page, decoded through the font's ToUnicode map.
+type pdfTextRun struct {
+	X, Y float64
+	Text string
+}
+
+// pageText returns the text runs of each page.
+func (r *pdfReader) pageText() [][]pdfTextRun {
+	r.t.Helper()
+	var out [][]pdfTextRun
+	for _, page := range r.pages() {
+		cmap := string(r.stream(r.ref(r.ref(page, "/F1"), "/ToUnicode")))
+		type bfrange struct{ lo, hi, dst int }
+		var ranges []bfrange
+		for _, m := range regexp.MustCompile(`<([0-9A-F]{4})> <([0-9A-F]{4})> <([0-9A-F]{4})>`).FindAllStringSubmatch(cmap, -1) {
+			lo, _ := strconv.ParseInt(m[1], 16, 32)
+			hi, _ := strconv.ParseInt(m[2], 16, 32)
+			dst, _ := strconv.ParseInt(m[3], 16, 32)
+			ranges = append(ranges, bfrange{int(lo), int(hi), int(dst)})
+		}
+		content := string(r.stream(r.ref(page, "/Contents")))
+		var runs []pdfTextRun
+		for _, m := range regexp.MustCompile(`1 0 0 1 ([\d.]+) ([\d.]+) Tm <([0-9A-F]*)> Tj`).FindAllStringSubmatch(content, -1) {
+			run := pdfTextRun{}
+			run.X, _ = strconv.ParseFloat(m[1], 64)
+			run.Y, _ = strconv.ParseFloat(m[2], 64)
+			for i := 0; i < len(m[3]); i += 4 {
+				cid, _ := strconv.ParseInt(m[3][i:i+4], 16, 32)
+				mapped := false
+				for _, br := range ranges {
+					if int(cid) >= br.lo && int(cid) <= br.hi {
+						run.Text += string(rune(br.dst + int(cid) - br.lo))
+						mapped = true
+					}
+				}
+				if !mapped {
+					r.t.Fatalf("CID %04X has no ToUnicode mapping", cid)
+				}
+			}
+			runs = append(runs, run)
+		}
+		out = append(out, runs)
+	}
+	return out
+}
+
+func TestGenerateSearchablePDF(t *testing.T) {
+	var scan bytes.Buffer
+	if err := png.Encode(&scan, newPage(600, 400)); err != nil {
+		t.Fatal(err)
+	}
+	layout := &OCRLayout{Pages: []OCRLayoutPage{{PageNumber: 1, OCRPageLayout: OCRPageLayout{Width: 600, Height: 400, Blocks: []OCRBlock{
+		{Lines: []OCRLine{{Words: []OCRWord{
+			{Text: "Hello", BBox: BoundingBox{X0: 10, Y0: 20, X1: 90, Y1: 50}, Confidence: 0.9},
+			{Text: "World", BBox: BoundingBox{X0: 100, Y0: 20, X1: 200, Y1: 50}, Confidence: 0.9},
+		}}}},
+		{Lines: []OCRLine{{Words: []OCRWord{
+			{Text: "東京都", BBox: BoundingBox{X0: 10, Y0: 100, X1: 100, Y1: 130}, Confidence: 0.8},
+		}}}},
+	}}}}}
+	data, pages, err := GenerateSearchablePDF(context.Background(), "scan.png", bytes.NewReader(scan.Bytes()), layout)
+	if err != nil || pages != 1 {
+		t.Fatalf("GenerateSearchablePDF = %d pages, %v", pages, err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-1.5\n")) {
+		t.Fatalf("header = %q", data[:10])
+	}
+
+	r := newPDFReader(t, data)
+	text := r.pageText()
+	if len(text) != 1 {
+		t.Fatalf("%d pages with text, want 1", len(text))
+	}
+	// At 150 DPI a pixel is 0.48 pt; PDF y runs up from the bottom of the 192 pt page
+	want := []pdfTextRun{{4.8, 168, "Hello "}, {48, 168, "World"}, {4.8, 129.6, "東京都"}}
+	if fmt.Sprint(text[0]) != fmt.Sprint(want) {
+		t.Fatalf("text runs = %v, want %v", text[0], want)
+	}
+	if tree := r.ref(r.ref(r.trailer, "/Root"), "/Pages"); !strings.Contains(tree, "/Count 1") {
+		t.Fatalf("page tree = %s", tree)
+	}
+	page := r.pages()[0]
+	if !strings.Contains(page, "/MediaBox [0 0 288.00 192.00]") || !strings.Contains(r.ref(page, "/Im0"), "/Width 600 /Height 400 /ColorSpace /DeviceGray") {
+		t.Fatalf("page = %s", page)
+	}
+	// The text is drawn invisibly over the image
+	if content := string(r.stream(r.ref(page, "/Contents"))); !strings.Contains(content, "/Im0 Do") || !strings.Contains(content, "BT\n3 Tr\n") {
+		t.Fatalf("content = %s", content)
+	}
+
+	// A PDF viewer's text extraction finds the words too
+	if pdftotext, err := exec.LookPath("pdftotext"); err == nil {
+		path := filepath.Join(t.TempDir(), "scan.pdf")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(pdftotext, path, "-").Output()
+		if err != nil || !strings.Contains(string(out), "Hello World") || !strings.Contains(string(out), "東京都") {
+			t.Fatalf("pdftotext = %q, %v", out, err)
+		}
+	}
+}
+
+func TestGenerateSearchablePDFWithoutLayout(t *testing.T) {
+	var scan bytes.Buffer
+	if err := png.Encode(&scan, newPage(300, 300)); err != nil {
+		t.Fatal(err)
+	}
+	data, pages, err := GenerateSearchablePDF(context.Background(), "scan.png", bytes.NewReader(scan.Bytes()), nil)
+	if err != nil || pages != 1 {
+		t.Fatalf("GenerateSearchablePDF = %d pages, %v", pages, err)
+	}
+	if text := newPDFReader(t, data).pageText(); len(text) != 1 || len(text[0]) != 0 {
+		t.Fatalf("text = %v, want an image-only page", text)
+	}
+
+	if _, _, err := GenerateSearchablePDF(context.Background(), "report.docx", strings.NewReader("x"), nil); err == nil {
+		t.Fatal("GenerateSearchablePDF accepted an Office document")
+	}
+	for _, tt := range []struct{ filename, engine, path string }{
+		{"documents/scan.png", "tesseract", "searchable/tesseract/scan.png.pdf"},
+		{"documents/report.PDF", "easyocr", "searchable/easyocr/report.PDF"},
+	} {
+		if got := SearchablePDFPath(tt.filename, tt.engine); got != tt.path {
+			t.Errorf("SearchablePDFPath(%q, %q) = %q, want %q", tt.filename, tt.engine, got, tt.path)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package domain
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSignedURLUnavailable is returned when no signed URL can be created
+	// for a storage provider.
+	ErrSignedURLUnavailable = errors.New("signed URLs are not available")
+	// ErrInvalidSignedURL is returned for signed URLs and upload IDs that are
+	// malformed, altered or expired.
+	ErrInvalidSignedURL = errors.New("invalid or expired signed URL")
+)
+
+// Signed URL modes (SIGNED_URL_MODE)
+const (
+	SignedURLModeAuto    = "auto"    // the storage's own signed URLs, else the gateway's
+	SignedURLModeNative  = "native"  // only the storage's own signed URLs
+	SignedURLModeGateway = "gateway" // only URLs of the gateway's transfer handler
+)
+
+// StagingNamespace holds direct uploads until FinalizeUpload validates them
+// and moves them into place, as uploads/<upload ID>/<filename> (tenant files
+// under tenants/<tenant>/).
+const StagingNamespace = "uploads/"
+
+// StagingPath returns the storage path a direct upload is staged at.
+func StagingPath(filename string, uploadID string) string {
+	return TenantStoragePath(filename, StagingNamespace+uploadID+"/")
+}
+
+// TransferPathPrefix is the path of the gateway's transfer handler; signed
+// URLs address /transfer/<provider>/<storage path>.
+const TransferPathPrefix = "/transfer/"
+
+// uploadFinalizeGrace is how long after its URL expires an upload can still
+// be finalized.
+const uploadFinalizeGrace = time.Hour
+
+// SignedURL is a time-limited URL a client transfers a file with.
+type SignedURL struct {
+	URL       string
+	Method    string            // "PUT" for uploads, "GET" for downloads
+	Headers   map[string]string // headers the request must carry
+	ExpiresAt time.Time
+	Direct    bool // the URL points at the storage, not at the gateway
+}
+
+// URLSigner creates signed URLs for objects of a storage.
+type URLSigner interface {
+	// SignedUploadURL returns a URL that writes the object at storagePath.
+	// size is the expected size in bytes, 0 if unknown.
+	SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error)
+	// SignedDownloadURL returns a URL that reads the object at storagePath
+	// as an attachment named downloadName.
+	SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error)
+}
+
+// SignedURLPolicy configures signed URLs and signs the URLs of the gateway's
+// transfer handler and the IDs of direct uploads.
+type SignedURLPolicy struct {
+	Mode           string
+	DefaultTTL     time.Duration
+	MaxTTL         time.Duration
+	Listen         string   // address of the transfer handler
+	BaseURL        string   // URL clients reach the transfer handler at
+	AllowedOrigins []string // CORS origins of the transfer handler
+
+	secret []byte
+}
+
+// SignedURLPolicyFromEnv configures signed URLs:
+//
+//   - SIGNED_URLS: true enables the RPCs and the transfer handler; they are
+//     off (nil is returned) by default
+//   - SIGNED_URL_MODE: auto (default), native or gateway
+//   - SIGNED_URL_TTL: default lifetime of URLs (default 15m)
+//   - SIGNED_URL_MAX_TTL: longest lifetime a client may ask for (default 1h)
+//   - SIGNED_URL_LISTEN: address of the transfer handler (default :8090)
+//   - SIGNED_URL_BASE: URL clients reach it at (default http://localhost:8090)
+//   - SIGNED_URL_ALLOWED_ORIGINS: CORS origins of the web apps that upload
+//     and download, comma-separated (required)
+//   - SIGNED_URL_SECRET: HMAC key of gateway URLs and upload IDs, at least
+//     32 bytes (required). Every replica must share it, and URLs stay valid
+//     across restarts.
+func SignedURLPolicyFromEnv() (*SignedURLPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SIGNED_URLS"))) {
+	case "true", "1", "on", "yes":
+	default:
+		return nil, nil
+	}
+	p := &SignedURLPolicy{
+		Mode:       SignedURLModeAuto,
+		DefaultTTL: 15 * time.Minute,
+		MaxTTL:     time.Hour,
+		Listen:     ":8090",
+		BaseURL:    "http://localhost:8090",
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("SIGNED_URL_MODE"))); mode {
+	case "":
+	case SignedURLModeAuto, SignedURLModeNative, SignedURLModeGateway:
+		p.Mode = mode
+	default:
+		return nil, fmt.Errorf("unknown SIGNED_URL_MODE %q (want auto, native or gateway)", mode)
+	}
+	for name, ttl := range map[string]*time.Duration{"SIGNED_URL_TTL": &p.DefaultTTL, "SIGNED_URL_MAX_TTL": &p.MaxTTL} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*ttl = d
+		}
+	}
+	if p.DefaultTTL > p.MaxTTL {
+		p.DefaultTTL = p.MaxTTL
+	}
+	if v := os.Getenv("SIGNED_URL_LISTEN"); v != "" {
+		p.Listen = v
+	}
+	if v := os.Getenv("SIGNED_URL_BASE"); v != "" {
+		p.BaseURL = strings.TrimSuffix(v, "/")
+	}
+	for _, origin := range strings.Split(os.Getenv("SIGNED_URL_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			return nil, fmt.Errorf("SIGNED_URL_ALLOWED_ORIGINS must list origins, not *")
+		case !strings.HasPrefix(origin, "https://") && !strings.HasPrefix(origin, "http://"):
+			return nil, fmt.Errorf("invalid origin %q in SIGNED_URL_ALLOWED_ORIGINS", origin)
+		}
+		p.AllowedOrigins = append(p.AllowedOrigins, origin)
+	}
+	if len(p.AllowedOrigins) == 0 {
+		return nil, fmt.Errorf("signed URLs require SIGNED_URL_ALLOWED_ORIGINS")
+	}
+	secret := os.Getenv("SIGNED_URL_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("signed URLs require SIGNED_URL_SECRET")
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("SIGNED_URL_SECRET must be at least 32 bytes")
+	}
+	p.secret = []byte(secret)
+	return p, nil
+}
+
+// TTL returns the lifetime of a URL a client asked to expire in seconds: the
+// default for 0, at most MaxTTL.
+func (p *SignedURLPolicy) TTL(seconds int64) time.Duration {
+	if seconds <= 0 {
+		return p.DefaultTTL
+	}
+	if ttl := time.Duration(seconds) * time.Second; ttl < p.MaxTTL {
+		return ttl
+	}
+	return p.MaxTTL
+}
+
+// Signer returns the signer for objects of storage, which belongs to
+// provider: the storage's own URLSigner or the transfer handler, by Mode.
+// Storages that encrypt files (see KeyProvider) are not URLSigners, so their
+// files always pass through the transfer handler.
+func (p *SignedURLPolicy) Signer(storage StorageService, provider string) (URLSigner, error) {
+	gateway := &gatewaySigner{policy: p, provider: provider}
+	native, ok := storage.(URLSigner)
+	switch {
+	case p.Mode == SignedURLModeGateway:
+		return gateway, nil
+	case p.Mode == SignedURLModeNative && !ok:
+		return nil, fmt.Errorf("%w: %s storage cannot sign URLs", ErrSignedURLUnavailable, provider)
+	case p.Mode == SignedURLModeNative:
+		return native, nil
+	case ok:
+		return &fallbackSigner{native: native, gateway: gateway, provider: provider}, nil
+	}
+	return gateway, nil
+}
+
+// AllowOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when the origin is not allowed.
+func (p *SignedURLPolicy) AllowOrigin(origin string) string {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// mac returns the HMAC of fields; each is length-prefixed, so no field can
+// spill into the next.
+func (p *SignedURLPolicy) mac(fields ...string) []byte {
+	h := hmac.New(sha256.New, p.secret)
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return h.Sum(nil)
+}
+
+// TransferGrant is what a verified URL of the transfer handler permits.
+type TransferGrant struct {
+	Upload       bool
+	Provider     string
+	StoragePath  string
+	DownloadName string // downloads only
+	MaxSize      int64  // uploads only
+	ExpiresAt    time.Time
+}
+
+func (g *TransferGrant) fields(p *SignedURLPolicy) []string {
+	op := "download"
+	if g.Upload {
+		op = "upload"
+	}
+	return []string{op, g.Provider, g.StoragePath, g.DownloadName,
+		strconv.FormatInt(g.MaxSize, 10), strconv.FormatInt(g.ExpiresAt.Unix(), 10)}
+}
+
+// signTransfer returns the transfer handler URL of a grant.
+func (p *SignedURLPolicy) signTransfer(g *TransferGrant) string {
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(g.ExpiresAt.Unix(), 10))
+	if g.Upload {
+		query.Set("max", strconv.FormatInt(g.MaxSize, 10))
+	} else {
+		query.Set("name", g.DownloadName)
+	}
+	query.Set("sig", hex.EncodeToString(p.mac(g.fields(p)...)))
+	u := url.URL{Path: TransferPathPrefix + g.Provider + "/" + g.StoragePath, RawQuery: query.Encode()}
+	return p.BaseURL + u.String()
+}
+
+// VerifyTransfer checks the signature and expiry of a transfer handler
+// request: PUT for uploads, GET or HEAD for downloads.
+func (p *SignedURLPolicy) VerifyTransfer(method string, u *url.URL) (*TransferGrant, error) {
+	provider, storagePath, ok := strings.Cut(strings.TrimPrefix(u.Path, TransferPathPrefix), "/")
+	if !strings.HasPrefix(u.Path, TransferPathPrefix) || !ok || provider == "" || storagePath == "" {
+		return nil, fmt.Errorf("%w: malformed path", ErrInvalidSignedURL)
+	}
+	query := u.Query()
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed expiry", ErrInvalidSignedURL)
+	}
+	g := &TransferGrant{
+		Upload:       method == http.MethodPut,
+		Provider:     provider,
+		StoragePath:  storagePath,
+		DownloadName: query.Get("name"),
+		ExpiresAt:    time.Unix(exp, 0),
+	}
+	if g.Upload {
+		if g.MaxSize, err = strconv.ParseInt(query.Get("max"), 10, 64); err != nil || g.MaxSize <= 0 {
+			return nil, fmt.Errorf("%w: malformed size", ErrInvalidSignedURL)
+		}
+	}
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || !hmac.Equal(sig, p.mac(g.fields(p)...)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidSignedURL)
+	}
+	if time.Now().After(g.ExpiresAt) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrInvalidSignedURL, g.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return g, nil
+}
+
+// gatewaySigner signs URLs of the gateway's transfer handler, which streams
+// the object through the gateway's StorageService.
+type gatewaySigner struct {
+	policy   *SignedURLPolicy
+	provider string
+}
+
+func (s *gatewaySigner) SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error) {
+	g := &TransferGrant{Upload: true, Provider: s.provider, StoragePath: storagePath, MaxSize: size, ExpiresAt: time.Now().Add(expires)}
+	return &SignedURL{URL: s.policy.signTransfer(g), Method: http.MethodPut, ExpiresAt: g.ExpiresAt}, nil
+}
+
+func (s *gatewaySigner) SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error) {
+	g := &TransferGrant{Provider: s.provider, StoragePath: storagePath, DownloadName: downloadName, ExpiresAt: time.Now().Add(expires)}
+	return &SignedURL{URL: s.policy.signTransfer(g), Method: http.MethodGet, ExpiresAt: g.ExpiresAt}, nil
+}
+
+// fallbackSigner uses the storage's own URLs and the transfer handler when
+// the storage cannot sign, e.g. GCS without a service account key.
+type fallbackSigner struct {
+	native   URLSigner
+	gateway  URLSigner
+	provider string
+}
+
+func (s *fallbackSigner) SignedUploadURL(ctx context.Context, storagePath string, contentType string, size int64, expires time.Duration) (*SignedURL, error) {
+	signed, err := s.native.SignedUploadURL(ctx, storagePath, contentType, size, expires)
+	if err != nil {
+		log.Printf("Signed URLs: %s cannot sign, using the transfer handler: %v", s.provider, err)
+		return s.gateway.SignedUploadURL(ctx, storagePath, contentType, size, expires)
+	}
+	return signed, nil
+}
+
+func (s *fallbackSigner) SignedDownloadURL(ctx context.Context, storagePath string, downloadName string, expires time.Duration) (*SignedURL, error) {
+	signed, err := s.native.SignedDownloadURL(ctx, storagePath, downloadName, expires)
+	if err != nil {
+		log.Printf("Signed URLs: %s cannot sign, using the transfer handler: %v", s.provider, err)
+		return s.gateway.SignedDownloadURL(ctx, storagePath, downloadName, expires)
+	}
+	return signed, nil
+}
+
+// signedHeaders returns the headers a presigned request must carry, less
+// those the client sets itself.
+func signedHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Host", "Content-Length":
+			continue
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+	}
+	return headers
+}
+
+// attachmentDisposition is the Content-Disposition of a download.
+func attachmentDisposition(downloadName string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": downloadName})
+}
+
+// UploadGrant describes a direct upload. Its signed form is the upload ID
+// FinalizeUpload takes; the gateway only records the staged object, so it
+// can delete it once the grant expires (see StagedUpload).
+type UploadGrant struct {
+	ID          string `json:"id"`
+	Filename    string `json:"f"` // file key (see TenantFileKey)
+	Provider    string `json:"p"`
+	StagingPath string `json:"s"`
+	Owner       string `json:"o,omitempty"`
+	Size        int64  `json:"n"` // declared size
+	ExpiresAt   int64  `json:"e"` // Unix time FinalizeUpload accepts it until
+}
+
+// NewUploadGrant creates the grant of a direct upload of size bytes whose
+// URL expires at urlExpiry. The size must be declared up front, as it
+// bounds what the URL accepts.
+func NewUploadGrant(filename string, provider string, owner string, size int64, urlExpiry time.Time) (*UploadGrant, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: direct uploads must declare their size", ErrContentRejected)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	g := &UploadGrant{
+		ID:        hex.EncodeToString(id),
+		Filename:  filename,
+		Provider:  provider,
+		Owner:     owner,
+		Size:      size,
+		ExpiresAt: urlExpiry.Add(uploadFinalizeGrace).Unix(),
+	}
+	g.StagingPath = StagingPath(filename, g.ID)
+	return g, nil
+}
+
+// SignUpload returns the upload ID of a grant.
+func (p *SignedURLPolicy) SignUpload(g *UploadGrant) string {
+	payload, _ := json.Marshal(g)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(p.mac("upload", string(payload)))
+}
+
+// VerifyUpload returns the grant of an upload ID.
+func (p *SignedURLPolicy) VerifyUpload(uploadID string) (*UploadGrant, error) {
+	encoded, encodedSig, ok := strings.Cut(uploadID, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("%w: malformed upload ID", ErrInvalidSignedURL)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, p.mac("upload", string(payload))) {
+		return nil, fmt.Errorf("%w: bad upload ID signature", ErrInvalidSignedURL)
+	}
+	var g UploadGrant
+	if err := json.Unmarshal(payload, &g); err != nil {
+		return nil, fmt.Errorf("%w: malformed upload ID", ErrInvalidSignedURL)
+	}
+	if time.Now().Unix() > g.ExpiresAt {
+		return nil, fmt.Errorf("%w: upload %s can no longer be finalized", ErrInvalidSignedURL, g.ID)
+	}
+	return &g, nil
+}
+
+// StagedUpload is the staged object of a direct upload, recorded until it is
+// finalized or deleted after its grant expires.
+type StagedUpload struct {
+	ID          string
+	Provider    string
+	StoragePath string
+	ExpiresAt   time.Time
+}
+
+// NewDeclaredSizeReader returns a reader of r that fails with
+// ErrContentRejected when r holds more or fewer than size bytes.
+func NewDeclaredSizeReader(r io.Reader, size int64) io.Reader {
+	return &declaredSizeReader{r: r, size: size, remaining: size}
+}
+
+type declaredSizeReader struct {
+	r         io.Reader
+	size      int64
+	remaining int64
+}
+
+func (d *declaredSizeReader) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		// Anything past the declared size rejects the upload
+		var extra [1]byte
+		n, err := d.r.Read(extra[:])
+		if n > 0 {
+			return 0, fmt.Errorf("%w: more than the declared %d bytes were uploaded", ErrContentRejected, d.size)
+		}
+		return 0, err
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	d.remaining -= int64(n)
+	if err == io.EOF && d.remaining > 0 {
+		return n, fmt.Errorf("%w: %d bytes were uploaded, %d were declared", ErrContentRejected, d.size-d.remaining, d.size)
+	}
+	return n, err
+}
@@ -0,0 +1,235 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSignedURLPolicy(secret string) *SignedURLPolicy {
+	return &SignedURLPolicy{
+		Mode:       SignedURLModeGateway,
+		DefaultTTL: 15 * time.Minute,
+		MaxTTL:     time.Hour,
+		BaseURL:    "https://files.example",
+		secret:     []byte(secret),
+	}
+}
+
+func TestVerifyTransfer(t *testing.T) {
+	policy := testSignedURLPolicy("0123456789abcdef0123456789abcdef")
+	other := testSignedURLPolicy("another secret of at least 32 bytes")
+	ctx := context.Background()
+	signer := &gatewaySigner{policy: policy, provider: "s3"}
+	upload, _ := signer.SignedUploadURL(ctx, "tenants/acme/documents/q3 report.pdf", "application/pdf", 1024, time.Minute)
+	download, _ := signer.SignedDownloadURL(ctx, "documents/report.pdf", "report.pdf", time.Minute)
+	expired, _ := signer.SignedDownloadURL(ctx, "documents/report.pdf", "report.pdf", -time.Minute)
+
+	edit := func(raw string, f func(u *url.URL, q url.Values)) string {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		f(u, q)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	for _, tt := range []struct {
+		name    string
+		policy  *SignedURLPolicy
+		method  string
+		url     string
+		wantErr bool
+	}{
+		{"upload", policy, "PUT", upload.URL, false},
+		{"download", policy, "GET", download.URL, false},
+		{"download by HEAD", policy, "HEAD", download.URL, false},
+		{"upload URL used to download", policy, "GET", upload.URL, true},
+		{"download URL used to upload", policy, "PUT", download.URL, true},
+		{"raised size limit", policy, "PUT", edit(upload.URL, func(u *url.URL, q url.Values) { q.Set("max", "1048576") }), true},
+		{"extended expiry", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { q.Set("exp", "99999999999") }), true},
+		{"renamed download", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { q.Set("name", "other.exe") }), true},
+		{"other object", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { u.Path = "/transfer/s3/documents/other.pdf" }), true},
+		{"other provider", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { u.Path = "/transfer/gcs/documents/report.pdf" }), true},
+		{"no signature", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { q.Del("sig") }), true},
+		{"path outside the handler", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { u.Path = "/other/s3/documents/report.pdf" }), true},
+		{"path without an object", policy, "GET", edit(download.URL, func(u *url.URL, q url.Values) { u.Path = "/transfer/s3/" }), true},
+		{"expired", policy, "GET", expired.URL, true},
+		{"signed with another secret", other, "GET", download.URL, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			grant, err := tt.policy.VerifyTransfer(tt.method, u)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSignedURL) {
+					t.Fatalf("err = %v, want ErrInvalidSignedURL", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyTransfer: %v", err)
+			}
+			if grant.Provider != "s3" || grant.Upload != (tt.method == "PUT") {
+				t.Fatalf("grant = %+v", grant)
+			}
+		})
+	}
+
+	grant, err := policy.VerifyTransfer("PUT", mustParseURL(t, upload.URL))
+	if err != nil || grant.StoragePath != "tenants/acme/documents/q3 report.pdf" || grant.MaxSize != 1024 {
+		t.Fatalf("upload grant = %+v, %v", grant, err)
+	}
+	grant, err = policy.VerifyTransfer("GET", mustParseURL(t, download.URL))
+	if err != nil || grant.StoragePath != "documents/report.pdf" || grant.DownloadName != "report.pdf" {
+		t.Fatalf("download grant = %+v, %v", grant, err)
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestVerifyUpload(t *testing.T) {
+	policy := testSignedURLPolicy("0123456789abcdef0123456789abcdef")
+	grant, err := NewUploadGrant("tenants/acme/report.pdf", "s3", "alice", 2048, time.Now().Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("NewUploadGrant: %v", err)
+	}
+	if want := "tenants/acme/uploads/" + grant.ID + "/report.pdf"; grant.StagingPath != want {
+		t.Fatalf("StagingPath = %q, want %q", grant.StagingPath, want)
+	}
+	id := policy.SignUpload(grant)
+
+	expiredGrant := *grant
+	expiredGrant.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	payload, sig, _ := strings.Cut(id, ".")
+	decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+	enlarged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(decoded), `"n":2048`, `"n":99999999`, 1)))
+
+	for _, tt := range []struct {
+		name    string
+		policy  *SignedURLPolicy
+		id      string
+		wantErr bool
+	}{
+		{"valid", policy, id, false},
+		{"altered size", policy, enlarged + "." + sig, true},
+		{"signed with another secret", testSignedURLPolicy("another secret of at least 32 bytes"), id, true},
+		{"expired", policy, policy.SignUpload(&expiredGrant), true},
+		{"without a signature", policy, payload, true},
+		{"malformed", policy, "!!.??", true},
+		{"empty", policy, "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.VerifyUpload(tt.id)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSignedURL) {
+					t.Fatalf("err = %v, want ErrInvalidSignedURL", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyUpload: %v", err)
+			}
+			if *got != *grant {
+				t.Fatalf("grant = %+v, want %+v", got, grant)
+			}
+		})
+	}
+
+	if _, err := NewUploadGrant("report.pdf", "s3", "alice", 0, time.Now()); !errors.Is(err, ErrContentRejected) {
+		t.Fatalf("NewUploadGrant without a size: err = %v, want ErrContentRejected", err)
+	}
+}
+
+func TestDeclaredSizeReader(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		size    int64
+		wantErr bool
+	}{
+		{"exact", "hello", 5, false},
+		{"shorter", "hell", 5, true},
+		{"longer", "hello!", 5, true},
+		{"empty", "", 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := io.ReadAll(NewDeclaredSizeReader(strings.NewReader(tt.content), tt.size))
+			if tt.wantErr {
+				if !errors.Is(err, ErrContentRejected) {
+					t.Fatalf("err = %v, want ErrContentRejected", err)
+				}
+				return
+			}
+			if err != nil || string(data) != tt.content {
+				t.Fatalf("ReadAll = %q, %v; want %q", data, err, tt.content)
+			}
+		})
+	}
+}
+
+func TestSignedURLPolicyFromEnv(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		enabled bool
+		wantErr bool
+	}{
+		{"off by default", nil, false, false},
+		{"enabled", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "https://app.example", "SIGNED_URL_SECRET": secret}, true, false},
+		{"without origins", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_SECRET": secret}, false, true},
+		{"any origin", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "*", "SIGNED_URL_SECRET": secret}, false, true},
+		{"origin without a scheme", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "app.example", "SIGNED_URL_SECRET": secret}, false, true},
+		{"without a secret", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "https://app.example"}, false, true},
+		{"short secret", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "https://app.example", "SIGNED_URL_SECRET": "secret"}, false, true},
+		{"unknown mode", map[string]string{"SIGNED_URLS": "true", "SIGNED_URL_ALLOWED_ORIGINS": "https://app.example", "SIGNED_URL_SECRET": secret, "SIGNED_URL_MODE": "direct"}, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"SIGNED_URLS", "SIGNED_URL_ALLOWED_ORIGINS", "SIGNED_URL_SECRET", "SIGNED_URL_MODE", "SIGNED_URL_TTL", "SIGNED_URL_MAX_TTL"} {
+				t.Setenv(name, tt.env[name])
+			}
+			policy, err := SignedURLPolicyFromEnv()
+			if (err != nil) != tt.wantErr || (policy != nil) != tt.enabled {
+				t.Fatalf("SignedURLPolicyFromEnv = %+v, %v; want enabled %v, error %v", policy, err, tt.enabled, tt.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("SIGNED_URLS", "on")
+	t.Setenv("SIGNED_URL_ALLOWED_ORIGINS", " https://app.example/ , http://localhost:3000")
+	t.Setenv("SIGNED_URL_SECRET", secret)
+	t.Setenv("SIGNED_URL_MODE", "")
+	t.Setenv("SIGNED_URL_TTL", "2h")
+	t.Setenv("SIGNED_URL_MAX_TTL", "1h")
+	policy, err := SignedURLPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("SignedURLPolicyFromEnv: %v", err)
+	}
+	if policy.Mode != SignedURLModeAuto || policy.DefaultTTL != time.Hour {
+		t.Fatalf("policy = %+v, want auto mode and the default TTL capped at the maximum", policy)
+	}
+	if policy.AllowOrigin("https://app.example") == "" || policy.AllowOrigin("https://evil.example") != "" {
+		t.Fatalf("AllowedOrigins = %q", policy.AllowedOrigins)
+	}
+	for seconds, want := range map[int64]time.Duration{0: time.Hour, 60: time.Minute, 7200: time.Hour} {
+		if got := policy.TTL(seconds); got != want {
+			t.Errorf("TTL(%d) = %v, want %v", seconds, got, want)
+		}
+	}
+}
@@ -155,10 +155,7 @@ func (q *sqsQueueService) EnqueueOCRTask(ctx context.Context, filename string, s
 	}
 
 	// OCRTask?JSON???????
-	task := &OCRTask{
-		Filename:        filename,
-		StorageProvider: storageProvider,
-	}
+	task := NewOCRTask(filename, storageProvider)
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
 		return fmt.Errorf("failed to marshal OCR task: %w", err)
@@ -13,6 +13,8 @@ type StorageService interface {
 	UploadFile(ctx context.Context, filename string, content io.Reader) (*pb.FileUploadStatus, error)
 	DownloadFile(ctx context.Context, filename string) (io.Reader, error)
 	DownloadFileByPath(ctx context.Context, storagePath string) (io.Reader, error) // ???storage_path???????
+	UploadFileByPath(ctx context.Context, storagePath string, content io.Reader) error // writes to an exact storage path (derived files)
+	DeleteFileByPath(ctx context.Context, storagePath string) error // removes an object at an exact storage path
 	ListFiles(ctx context.Context) ([]*pb.FileInfo, error)
 	DeleteFile(ctx context.Context, filename string) error
 }
@@ -57,13 +59,41 @@ func GetFileNamespace(filename string) string {
 	return "others/"
 }
 
-// BuildStoragePath constructs the full storage path with namespace prefix
+// BuildStoragePath constructs the full storage path with namespace prefix.
+// Tenant file keys (see TenantFileKey) are stored under tenants/<tenant>/.
 func BuildStoragePath(filename string) string {
-	namespace := GetFileNamespace(filename)
-	// Remove any existing namespace prefixes to avoid duplication
+	_, name := SplitTenantFileKey(filename)
+	return TenantStoragePath(filename, GetFileNamespace(name))
+}
+
+// TenantStoragePath is the storage path of a file in the given namespace
+// (e.g. "others/") instead of the namespace of its extension.
+func TenantStoragePath(filename string, namespace string) string {
+	tenant, name := SplitTenantFileKey(filename)
+	return tenantStoragePrefix(tenant) + namespace + trimStorageNamespace(name)
+}
+
+// trimStorageNamespace removes any existing namespace prefixes to avoid duplication
+func trimStorageNamespace(filename string) string {
 	filename = strings.TrimPrefix(filename, "documents/")
 	filename = strings.TrimPrefix(filename, "images/")
 	filename = strings.TrimPrefix(filename, "media/")
 	filename = strings.TrimPrefix(filename, "others/")
-	return namespace + filename
+	return filename
+}
+
+// derivedNamespaces hold files generated from uploads (not uploads themselves),
+// and direct uploads not finalized yet
+var derivedNamespaces = []string{SearchablePDFNamespace, RedactedNamespace, StagingNamespace}
+
+// IsDerivedStoragePath reports whether a storage key belongs to a derived or
+// staging namespace; such objects are not listed as uploaded files.
+func IsDerivedStoragePath(storagePath string) bool {
+	_, storagePath = SplitTenantFileKey(storagePath)
+	for _, ns := range derivedNamespaces {
+		if strings.HasPrefix(storagePath, ns) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,737 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	pb "grpc-sample-minimal/proto"
+)
+
+// Export formats of GetExtractedTables.
+const (
+	TableFormatJSON = "json"
+	TableFormatCSV  = "csv"
+)
+
+// TableCell is a cell of an extracted table. A cell spanning several grid
+// positions is reported once at its top-left position.
+type TableCell struct {
+	Row        int         `json:"row"`
+	Column     int         `json:"column"`
+	RowSpan    int         `json:"row_span"`
+	ColSpan    int         `json:"col_span"`
+	Text       string      `json:"text"`
+	BBox       BoundingBox `json:"bbox"`
+	Confidence float64     `json:"confidence"`
+}
+
+// ExtractedTable is a table found on a page. Ruled tables come from ruling
+// lines in the page image; the others from words aligned in columns.
+type ExtractedTable struct {
+	PageNumber int         `json:"page_number"`
+	Index      int         `json:"index"` // 1-based position on the page, top to bottom
+	BBox       BoundingBox `json:"bbox"`
+	Rows       int         `json:"rows"`
+	Columns    int         `json:"columns"`
+	Ruled      bool        `json:"ruled"`
+	Confidence float64     `json:"confidence"` // mean word confidence
+	Cells      []TableCell `json:"cells"`
+}
+
+// Grid returns the cell texts by row and column; positions covered by a
+// spanning cell other than its top-left one are empty.
+func (t *ExtractedTable) Grid() [][]string {
+	grid := make([][]string, t.Rows)
+	for r := range grid {
+		grid[r] = make([]string, t.Columns)
+	}
+	for _, cell := range t.Cells {
+		if cell.Row < t.Rows && cell.Column < t.Columns {
+			grid[cell.Row][cell.Column] = cell.Text
+		}
+	}
+	return grid
+}
+
+// CSV renders the table as CSV.
+func (t *ExtractedTable) CSV() string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(t.Grid())
+	return buf.String()
+}
+
+// ExportExtractedTables renders tables as JSON (all tables with their cells) or
+// CSV (tables in page order, separated by a blank line).
+func ExportExtractedTables(tables []ExtractedTable, format string) ([]byte, string, error) {
+	switch strings.ToLower(format) {
+	case "", TableFormatJSON:
+		if tables == nil {
+			tables = []ExtractedTable{}
+		}
+		content, err := json.MarshalIndent(tables, "", "  ")
+		return content, "application/json", err
+	case TableFormatCSV:
+		parts := make([]string, len(tables))
+		for i := range tables {
+			parts[i] = tables[i].CSV()
+		}
+		return []byte(strings.Join(parts, "\n")), "text/csv", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported table format: %s (expected json or csv)", format)
+	}
+}
+
+// TableExtractionEnabled reports whether OCR results get a table pass; set
+// OCR_TABLE_EXTRACTION=false to disable it.
+func TableExtractionEnabled() bool {
+	switch strings.ToLower(os.Getenv("OCR_TABLE_EXTRACTION")) {
+	case "false", "0", "off", "no":
+		return false
+	}
+	return true
+}
+
+// extractDocumentTables attaches the tables of each page with a layout to the
+// completed results. Ruling lines are detected once per page image.
+func extractDocumentTables(ctx context.Context, pages *documentPages, results map[string]*OCRResult) {
+	for _, result := range results {
+		if result == nil || result.Status == "failed" || result.Error != nil {
+			continue
+		}
+		count := 0
+		for i := range result.Pages {
+			page := &result.Pages[i]
+			if page.Layout == nil || len(page.Layout.Blocks) == 0 {
+				continue
+			}
+			page.Tables = ExtractTables(page.Layout, pages.pageRulings(ctx, page.PageNumber))
+			for j := range page.Tables {
+				page.Tables[j].PageNumber = page.PageNumber
+			}
+			count += len(page.Tables)
+		}
+		if count > 0 {
+			log.Printf("Extracted %d table(s) from %s (%s)", count, result.Filename, result.EngineName)
+		}
+	}
+}
+
+// pageRulings detects the ruling lines of a page once; nil without an image.
+func (p *documentPages) pageRulings(ctx context.Context, pageNumber int) *pageRulings {
+	if r, ok := p.rulings[pageNumber]; ok {
+		return r
+	}
+	var r *pageRulings
+	if img := p.image(ctx, pageNumber); img != nil {
+		r = detectRulings(img)
+	}
+	if p.rulings == nil {
+		p.rulings = map[int]*pageRulings{}
+	}
+	p.rulings[pageNumber] = r
+	return r
+}
+
+// ExtractTables finds the tables of a page: grids of ruling lines first, then
+// words aligned in columns among the remaining words. rulings may be nil.
+func ExtractTables(layout *OCRPageLayout, rulings *pageRulings) []ExtractedTable {
+	words := layout.Words()
+	if len(words) == 0 {
+		return nil
+	}
+	var tables []ExtractedTable
+	used := make([]bool, len(words))
+	if rulings != nil {
+		tables = append(tables, ruledTables(rulings, words, used)...)
+	}
+	var rest []OCRWord
+	for i, w := range words {
+		if !used[i] {
+			rest = append(rest, w)
+		}
+	}
+	tables = append(tables, alignedTables(rest)...)
+
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].BBox.Y0 < tables[j].BBox.Y0 })
+	for i := range tables {
+		tables[i].Index = i + 1
+	}
+	return tables
+}
+
+// ruling is a horizontal (pos = y, span in x) or vertical (pos = x, span in y) line.
+type ruling struct {
+	pos, from, to int
+}
+
+// covers reports how much of [from, to) the ruling spans.
+func (r ruling) covers(from, to int) float64 {
+	if to <= from {
+		return 0
+	}
+	return float64(max(0, min(r.to, to)-max(r.from, from))) / float64(to-from)
+}
+
+// pageRulings are the ruling lines of a page image.
+type pageRulings struct {
+	horizontal []ruling
+	vertical   []ruling
+	tolerance  int
+}
+
+// detectRulings finds long thin runs of dark pixels. Runs much longer than
+// any character and at most a few pixels thick are table rules or form lines.
+func detectRulings(img image.Image) *pageRulings {
+	g := toGray(img)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	threshold := otsuThreshold(g)
+	dark := func(x, y int) bool { return g.Pix[y*g.Stride+x] <= threshold }
+	minLen := max(min(w, h)/15, 30)
+	maxThick := max(min(w, h)/200, 4)
+
+	horizontal := mergeRuns(scanRuns(h, w, func(i, j int) bool { return dark(j, i) }, minLen), maxThick)
+	vertical := mergeRuns(scanRuns(w, h, func(i, j int) bool { return dark(i, j) }, minLen), maxThick)
+	return &pageRulings{horizontal: horizontal, vertical: vertical, tolerance: max(maxThick, 4)}
+}
+
+// scanRuns returns the runs of at least minLen dark pixels along each of n
+// lines of length m, bridging gaps of up to two pixels.
+func scanRuns(n, m int, dark func(i, j int) bool, minLen int) []ruling {
+	var runs []ruling
+	for i := 0; i < n; i++ {
+		start, gap := -1, 0
+		for j := 0; j <= m; j++ {
+			if j < m && dark(i, j) {
+				if start < 0 {
+					start = j
+				}
+				gap = 0
+				continue
+			}
+			if start < 0 {
+				continue
+			}
+			if gap++; gap <= 2 && j < m {
+				continue
+			}
+			end := j - gap + 1
+			if end-start >= minLen {
+				runs = append(runs, ruling{pos: i, from: start, to: end})
+			}
+			start, gap = -1, 0
+		}
+	}
+	return runs
+}
+
+// mergeRuns merges runs on neighbouring lines into one ruling and drops
+// thick ones (filled areas, pictures).
+func mergeRuns(runs []ruling, maxThick int) []ruling {
+	type band struct {
+		ruling
+		first, last int
+	}
+	var bands []band
+	for _, r := range runs {
+		merged := false
+		for i := range bands {
+			b := &bands[i]
+			if r.pos-b.last <= 1 && r.pos >= b.first && r.from < b.to && b.from < r.to {
+				b.from, b.to, b.last = min(b.from, r.from), max(b.to, r.to), r.pos
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			bands = append(bands, band{ruling: r, first: r.pos, last: r.pos})
+		}
+	}
+	var out []ruling
+	for _, b := range bands {
+		if b.last-b.first+1 <= maxThick {
+			out = append(out, ruling{pos: (b.first + b.last) / 2, from: b.from, to: b.to})
+		}
+	}
+	return out
+}
+
+// ruledTables builds tables from connected groups of horizontal and vertical
+// rulings. Cell boundaries missing inside the grid make spanning cells. Words
+// placed in a table are marked used.
+func ruledTables(r *pageRulings, words []OCRWord, used []bool) []ExtractedTable {
+	nh := len(r.horizontal)
+	parent := make([]int, nh+len(r.vertical))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	tol := r.tolerance
+	for i, hr := range r.horizontal {
+		for j, vr := range r.vertical {
+			if vr.pos >= hr.from-tol && vr.pos <= hr.to+tol && hr.pos >= vr.from-tol && hr.pos <= vr.to+tol {
+				parent[find(i)] = find(nh + j)
+			}
+		}
+	}
+	groups := map[int][]int{}
+	for i := range parent {
+		groups[find(i)] = append(groups[find(i)], i)
+	}
+
+	var tables []ExtractedTable
+	for _, members := range groups {
+		var hs, vs []ruling
+		for _, m := range members {
+			if m < nh {
+				hs = append(hs, r.horizontal[m])
+			} else {
+				vs = append(vs, r.vertical[m-nh])
+			}
+		}
+		ys := distinctPositions(hs, tol)
+		xs := distinctPositions(vs, tol)
+		if len(ys) < 2 || len(xs) < 2 || (len(ys)-1)*(len(xs)-1) < 2 {
+			continue
+		}
+		tables = append(tables, buildRuledTable(hs, vs, xs, ys, tol, words, used))
+	}
+	return tables
+}
+
+// distinctPositions returns the sorted positions of rulings, merging close ones.
+func distinctPositions(rs []ruling, tol int) []int {
+	pos := make([]int, len(rs))
+	for i, r := range rs {
+		pos[i] = r.pos
+	}
+	sort.Ints(pos)
+	var out []int
+	for _, p := range pos {
+		if len(out) > 0 && p-out[len(out)-1] <= tol {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// buildRuledTable fills the grid given by xs and ys. Two neighbouring grid
+// positions belong to the same cell when less than half of the boundary
+// between them is ruled.
+func buildRuledTable(hs, vs []ruling, xs, ys []int, tol int, words []OCRWord, used []bool) ExtractedTable {
+	rows, cols := len(ys)-1, len(xs)-1
+	ruled := func(lines []ruling, pos, from, to int) bool {
+		covered := 0.0
+		for _, l := range lines {
+			if abs(l.pos-pos) <= tol {
+				covered += l.covers(from, to)
+			}
+		}
+		return covered >= 0.5
+	}
+
+	parent := make([]int, rows*cols)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			i = parent[i]
+		}
+		return i
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			if col+1 < cols && !ruled(vs, xs[col+1], ys[row], ys[row+1]) {
+				parent[find(row*cols+col+1)] = find(row*cols + col)
+			}
+			if row+1 < rows && !ruled(hs, ys[row+1], xs[col], xs[col+1]) {
+				parent[find((row+1)*cols+col)] = find(row*cols + col)
+			}
+		}
+	}
+
+	cells := map[int]*TableCell{}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			root := find(row*cols + col)
+			box := BoundingBox{X0: xs[col], Y0: ys[row], X1: xs[col+1], Y1: ys[row+1]}
+			cell, ok := cells[root]
+			if !ok {
+				cells[root] = &TableCell{Row: row, Column: col, RowSpan: 1, ColSpan: 1, BBox: box}
+				continue
+			}
+			cell.BBox = cell.BBox.Union(box)
+			cell.RowSpan = max(cell.RowSpan, row-cell.Row+1)
+			cell.ColSpan = max(cell.ColSpan, col-cell.Column+1)
+		}
+	}
+
+	table := ExtractedTable{
+		BBox:    BoundingBox{X0: xs[0], Y0: ys[0], X1: xs[cols], Y1: ys[rows]},
+		Rows:    rows,
+		Columns: cols,
+		Ruled:   true,
+	}
+	var tableWords []OCRWord
+	for _, cell := range cells {
+		var cellWords []OCRWord
+		for i, w := range words {
+			cx, cy := (w.BBox.X0+w.BBox.X1)/2, (w.BBox.Y0+w.BBox.Y1)/2
+			if !used[i] && cx >= cell.BBox.X0 && cx < cell.BBox.X1 && cy >= cell.BBox.Y0 && cy < cell.BBox.Y1 {
+				used[i] = true
+				cellWords = append(cellWords, w)
+			}
+		}
+		cell.Text, cell.Confidence = cellText(cellWords)
+		tableWords = append(tableWords, cellWords...)
+		table.Cells = append(table.Cells, *cell)
+	}
+	table.Confidence = meanWordConfidence(tableWords)
+	sortCells(table.Cells)
+	return table
+}
+
+// tableRow is a visual row of words split into segments at wide gaps.
+type tableRow struct {
+	box      BoundingBox
+	segments [][]OCRWord
+}
+
+// alignedTables finds tables without rulings: consecutive rows that split
+// into several segments at wide gaps, with segments lining up in columns.
+func alignedTables(words []OCRWord) []ExtractedTable {
+	if len(words) == 0 {
+		return nil
+	}
+	lineHeight := medianWordHeight(words)
+
+	rows := visualRows(words, lineHeight)
+	var tables []ExtractedTable
+	for start := 0; start < len(rows); {
+		end := start
+		for end < len(rows) && tableRowCandidate(rows, start, end, lineHeight) {
+			end++
+		}
+		if end-start >= 2 {
+			if table, ok := buildAlignedTable(rows[start:end]); ok {
+				tables = append(tables, table)
+			}
+		}
+		start = max(end, start+1)
+	}
+	return tables
+}
+
+// tableRowCandidate reports whether rows[i] continues a table starting at
+// rows[start]: it is close to the previous row and has several segments, or
+// has one segment between two rows that do (a row with a single filled cell).
+func tableRowCandidate(rows []tableRow, start, i int, lineHeight int) bool {
+	if i > start && rows[i].box.Y0-rows[i-1].box.Y1 > lineHeight*5/2 {
+		return false
+	}
+	if len(rows[i].segments) >= 2 {
+		return true
+	}
+	return i > start && i+1 < len(rows) && len(rows[i-1].segments) >= 2 && len(rows[i+1].segments) >= 2 &&
+		rows[i+1].box.Y0-rows[i].box.Y1 <= lineHeight*5/2
+}
+
+// visualRows groups words whose vertical centers overlap into rows, top to
+// bottom, and splits each row at gaps wider than 1.5 line heights.
+func visualRows(words []OCRWord, lineHeight int) []tableRow {
+	sorted := append([]OCRWord(nil), words...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].BBox.Y0+sorted[i].BBox.Y1 < sorted[j].BBox.Y0+sorted[j].BBox.Y1
+	})
+	var rows []tableRow
+	var rowWords [][]OCRWord
+	for _, w := range sorted {
+		center := (w.BBox.Y0 + w.BBox.Y1) / 2
+		placed := false
+		for i := range rows {
+			if center >= rows[i].box.Y0 && center < rows[i].box.Y1 {
+				rows[i].box = rows[i].box.Union(w.BBox)
+				rowWords[i] = append(rowWords[i], w)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			rows = append(rows, tableRow{box: w.BBox})
+			rowWords = append(rowWords, []OCRWord{w})
+		}
+	}
+	for i := range rows {
+		ws := rowWords[i]
+		sort.SliceStable(ws, func(a, b int) bool { return ws[a].BBox.X0 < ws[b].BBox.X0 })
+		segment := []OCRWord{ws[0]}
+		for _, w := range ws[1:] {
+			if w.BBox.X0-segment[len(segment)-1].BBox.X1 > lineHeight*3/2 {
+				rows[i].segments = append(rows[i].segments, segment)
+				segment = nil
+			}
+			segment = append(segment, w)
+		}
+		rows[i].segments = append(rows[i].segments, segment)
+	}
+	sort.SliceStable(rows, func(a, b int) bool { return rows[a].box.Y0 < rows[b].box.Y0 })
+	return rows
+}
+
+// buildAlignedTable clusters the segments of rows into columns. Columns start
+// from the row with the most segments; a segment overlapping one column widens
+// it, one overlapping none adds a column, one overlapping several spans them.
+// Runs of prose (two text columns of a page) are rejected by their length.
+func buildAlignedTable(rows []tableRow) (ExtractedTable, bool) {
+	widest := 0
+	for i, row := range rows {
+		if len(row.segments) > len(rows[widest].segments) {
+			widest = i
+		}
+	}
+	var columns []BoundingBox
+	for _, seg := range rows[widest].segments {
+		columns = append(columns, segmentBox(seg))
+	}
+	for _, row := range rows {
+		for _, seg := range row.segments {
+			box := segmentBox(seg)
+			overlapping := overlappingColumns(columns, box)
+			switch len(overlapping) {
+			case 0:
+				columns = append(columns, box)
+			case 1:
+				columns[overlapping[0]] = columns[overlapping[0]].Union(box)
+			}
+		}
+		sort.Slice(columns, func(i, j int) bool { return columns[i].X0 < columns[j].X0 })
+		columns = mergeOverlappingColumns(columns)
+	}
+	if len(columns) < 2 {
+		return ExtractedTable{}, false
+	}
+
+	table := ExtractedTable{Rows: len(rows), Columns: len(columns)}
+	var tableWords []OCRWord
+	multiColumnRows := 0
+	for r, row := range rows {
+		if len(row.segments) >= 2 {
+			multiColumnRows++
+		}
+		for _, seg := range row.segments {
+			box := segmentBox(seg)
+			cols := overlappingColumns(columns, box)
+			if len(cols) == 0 {
+				cols = []int{nearestColumn(columns, box)}
+			}
+			text, confidence := cellText(seg)
+			table.Cells = append(table.Cells, TableCell{
+				Row:        r,
+				Column:     cols[0],
+				RowSpan:    1,
+				ColSpan:    cols[len(cols)-1] - cols[0] + 1,
+				Text:       text,
+				BBox:       box,
+				Confidence: confidence,
+			})
+			tableWords = append(tableWords, seg...)
+			table.BBox = table.BBox.Union(box)
+		}
+	}
+	if multiColumnRows < 2 || float64(len(tableWords))/float64(len(table.Cells)) > 8 {
+		return ExtractedTable{}, false
+	}
+	table.Cells = mergeCellsAtSamePosition(table.Cells)
+	table.Confidence = meanWordConfidence(tableWords)
+	sortCells(table.Cells)
+	return table, true
+}
+
+// medianWordHeight returns the median word height, at least 1.
+func medianWordHeight(words []OCRWord) int {
+	heights := make([]int, len(words))
+	for i, w := range words {
+		heights[i] = w.BBox.Height()
+	}
+	sort.Ints(heights)
+	return max(heights[len(heights)/2], 1)
+}
+
+// segmentBox returns the box of a run of words.
+func segmentBox(words []OCRWord) BoundingBox {
+	var box BoundingBox
+	for _, w := range words {
+		box = box.Union(w.BBox)
+	}
+	return box
+}
+
+// overlappingColumns returns the indexes of the columns box overlaps horizontally.
+func overlappingColumns(columns []BoundingBox, box BoundingBox) []int {
+	var out []int
+	for i, c := range columns {
+		if box.X0 < c.X1 && c.X0 < box.X1 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// mergeOverlappingColumns joins sorted columns that grew into each other.
+func mergeOverlappingColumns(columns []BoundingBox) []BoundingBox {
+	var out []BoundingBox
+	for _, c := range columns {
+		if n := len(out); n > 0 && c.X0 < out[n-1].X1 {
+			out[n-1] = out[n-1].Union(c)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// nearestColumn returns the column whose center is closest to box's.
+func nearestColumn(columns []BoundingBox, box BoundingBox) int {
+	best, bestDist := 0, -1
+	center := box.X0 + box.X1
+	for i, c := range columns {
+		if d := abs(c.X0 + c.X1 - center); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// mergeCellsAtSamePosition joins segments that landed in the same cell.
+func mergeCellsAtSamePosition(cells []TableCell) []TableCell {
+	index := map[[2]int]int{}
+	var out []TableCell
+	for _, cell := range cells {
+		key := [2]int{cell.Row, cell.Column}
+		if i, ok := index[key]; ok {
+			out[i].Text += " " + cell.Text
+			out[i].BBox = out[i].BBox.Union(cell.BBox)
+			out[i].ColSpan = max(out[i].ColSpan, cell.ColSpan)
+			out[i].Confidence = (out[i].Confidence + cell.Confidence) / 2
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, cell)
+	}
+	return out
+}
+
+// cellText joins the words of a cell in reading order and averages their confidence.
+func cellText(words []OCRWord) (string, float64) {
+	if len(words) == 0 {
+		return "", 0
+	}
+	rows := visualRows(words, 1<<30)
+	var lines []string
+	for _, row := range rows {
+		var parts []string
+		for _, seg := range row.segments {
+			for _, w := range seg {
+				parts = append(parts, w.Text)
+			}
+		}
+		lines = append(lines, strings.Join(parts, " "))
+	}
+	return strings.Join(lines, " "), meanWordConfidence(words)
+}
+
+// meanWordConfidence returns the mean confidence of words, 0 for none.
+func meanWordConfidence(words []OCRWord) float64 {
+	if len(words) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, w := range words {
+		sum += w.Confidence
+	}
+	return sum / float64(len(words))
+}
+
+// sortCells orders cells by row, then column.
+func sortCells(cells []TableCell) {
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Row != cells[j].Row {
+			return cells[i].Row < cells[j].Row
+		}
+		return cells[i].Column < cells[j].Column
+	})
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// ExtractedTablesToProto converts tables for gRPC responses.
+func ExtractedTablesToProto(tables []ExtractedTable) []*pb.ExtractedTable {
+	out := make([]*pb.ExtractedTable, 0, len(tables))
+	for i := range tables {
+		t := &tables[i]
+		pt := &pb.ExtractedTable{
+			PageNumber: int32(t.PageNumber),
+			Index:      int32(t.Index),
+			Rows:       int32(t.Rows),
+			Columns:    int32(t.Columns),
+			Ruled:      t.Ruled,
+			Confidence: t.Confidence,
+			Bbox:       boundingBoxToProto(t.BBox),
+			Csv:        t.CSV(),
+		}
+		for _, cell := range t.Cells {
+			pt.Cells = append(pt.Cells, &pb.TableCell{
+				Row:        int32(cell.Row),
+				Column:     int32(cell.Column),
+				RowSpan:    int32(cell.RowSpan),
+				ColSpan:    int32(cell.ColSpan),
+				Text:       cell.Text,
+				Confidence: cell.Confidence,
+				Bbox:       boundingBoxToProto(cell.BBox),
+			})
+		}
+		out = append(out, pt)
+	}
+	return out
+}
+
+// FilterTablesByPage keeps the tables of one page; page 0 keeps all.
+func FilterTablesByPage(tables []ExtractedTable, pageNumber int) []ExtractedTable {
+	if pageNumber == 0 {
+		return tables
+	}
+	var out []ExtractedTable
+	for _, t := range tables {
+		if t.PageNumber == pageNumber {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func boundingBoxToProto(b BoundingBox) *pb.BoundingBox {
+	return &pb.BoundingBox{X0: int32(b.X0), Y0: int32(b.Y0), X1: int32(b.X1), Y1: int32(b.Y1)}
+}
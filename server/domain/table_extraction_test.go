@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+// testWord places a word at the given box.
+func testWord(text string, x0, y0, x1, y1 int) OCRWord {
+	return OCRWord{Text: text, BBox: BoundingBox{X0: x0, Y0: y0, X1: x1, Y1: y1}, Confidence: 0.9}
+}
+
+// testLayout puts each word on a line of its own.
+func testLayout(words ...OCRWord) *OCRPageLayout {
+	block := OCRBlock{}
+	for _, w := range words {
+		block.Lines = append(block.Lines, OCRLine{BBox: w.BBox, Words: []OCRWord{w}})
+	}
+	return &OCRPageLayout{Width: 600, Height: 400, Blocks: []OCRBlock{block}}
+}
+
+func TestExtractTables(t *testing.T) {
+	type table struct {
+		ruled bool
+		bbox  BoundingBox
+		grid  [][]string
+	}
+	grid := &pageRulings{
+		horizontal: []ruling{{pos: 100, from: 50, to: 450}, {pos: 150, from: 50, to: 450}, {pos: 200, from: 50, to: 450}},
+		vertical:   []ruling{{pos: 50, from: 100, to: 200}, {pos: 250, from: 100, to: 200}, {pos: 450, from: 100, to: 200}},
+		tolerance:  4,
+	}
+	// The same grid without the top half of the middle rule: the header spans both columns
+	spanning := &pageRulings{
+		horizontal: grid.horizontal,
+		vertical:   []ruling{{pos: 50, from: 100, to: 200}, {pos: 250, from: 150, to: 200}, {pos: 450, from: 100, to: 200}},
+		tolerance:  4,
+	}
+	cells := []OCRWord{
+		testWord("Title", 60, 115, 120, 135),
+		testWord("a", 60, 165, 70, 185),
+		testWord("b", 260, 165, 270, 185),
+	}
+	aligned := []OCRWord{
+		testWord("Item", 50, 250, 110, 270), testWord("Qty", 300, 250, 350, 270), testWord("Price", 500, 250, 570, 270),
+		testWord("Apple", 50, 290, 120, 310), testWord("3", 300, 290, 315, 310), testWord("1.20", 500, 290, 550, 310),
+		testWord("Green", 50, 330, 120, 350), testWord("pear", 130, 330, 180, 350), testWord("12", 300, 330, 330, 350), testWord("0.80", 500, 330, 550, 350),
+	}
+	prose := []OCRWord{
+		testWord("Some", 50, 20, 110, 40), testWord("words", 120, 20, 190, 40), testWord("before", 200, 20, 280, 40),
+		testWord("the", 50, 50, 90, 70), testWord("tables", 100, 50, 180, 70),
+	}
+
+	for _, tt := range []struct {
+		name    string
+		words   []OCRWord
+		rulings *pageRulings
+		want    []table
+	}{
+		{"empty page", nil, grid, nil},
+		{"prose", prose, nil, nil},
+		{"aligned columns", append(append([]OCRWord{}, prose...), aligned...), nil, []table{
+			{false, BoundingBox{X0: 50, Y0: 250, X1: 570, Y1: 350}, [][]string{{"Item", "Qty", "Price"}, {"Apple", "3", "1.20"}, {"Green pear", "12", "0.80"}}},
+		}},
+		{"ruled grid", cells, grid, []table{
+			{true, BoundingBox{X0: 50, Y0: 100, X1: 450, Y1: 200}, [][]string{{"Title", ""}, {"a", "b"}}},
+		}},
+		{"ruled grid with a spanning cell", cells, spanning, []table{
+			{true, BoundingBox{X0: 50, Y0: 100, X1: 450, Y1: 200}, [][]string{{"Title", ""}, {"a", "b"}}},
+		}},
+		{"ruled and aligned tables in page order", append(append([]OCRWord{}, aligned...), cells...), grid, []table{
+			{true, BoundingBox{X0: 50, Y0: 100, X1: 450, Y1: 200}, [][]string{{"Title", ""}, {"a", "b"}}},
+			{false, BoundingBox{X0: 50, Y0: 250, X1: 570, Y1: 350}, [][]string{{"Item", "Qty", "Price"}, {"Apple", "3", "1.20"}, {"Green pear", "12", "0.80"}}},
+		}},
+		{"lone rule is not a table", cells, &pageRulings{horizontal: grid.horizontal[:1], tolerance: 4}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractTables(testLayout(tt.words...), tt.rulings)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d tables %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Index != i+1 || got[i].Ruled != want.ruled || got[i].BBox != want.bbox {
+					t.Errorf("table %d = index %d, ruled %v, bbox %+v; want index %d, ruled %v, bbox %+v",
+						i, got[i].Index, got[i].Ruled, got[i].BBox, i+1, want.ruled, want.bbox)
+				}
+				if g := got[i].Grid(); !reflect.DeepEqual(g, want.grid) {
+					t.Errorf("table %d grid = %q, want %q", i, g, want.grid)
+				}
+			}
+		})
+	}
+
+	t.Run("spanning cell", func(t *testing.T) {
+		tables := ExtractTables(testLayout(cells...), spanning)
+		if len(tables) != 1 || len(tables[0].Cells) != 3 {
+			t.Fatalf("tables = %+v, want one table of 3 cells", tables)
+		}
+		if header := tables[0].Cells[0]; header.Text != "Title" || header.ColSpan != 2 || header.RowSpan != 1 {
+			t.Fatalf("header cell = %+v, want Title spanning 2 columns", header)
+		}
+	})
+}
+
+func TestDetectRulings(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 600, 400))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	black := image.NewUniform(color.Black)
+	for _, y := range []int{100, 150, 200} {
+		draw.Draw(img, image.Rect(50, y, 452, y+2), black, image.Point{}, draw.Src)
+	}
+	for _, x := range []int{50, 250, 450} {
+		draw.Draw(img, image.Rect(x, 100, x+2, 202), black, image.Point{}, draw.Src)
+	}
+	// A filled area and short strokes are not rules
+	draw.Draw(img, image.Rect(50, 300, 450, 340), black, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(500, 50, 520, 52), black, image.Point{}, draw.Src)
+
+	r := detectRulings(img)
+	if len(r.horizontal) != 3 || len(r.vertical) != 3 {
+		t.Fatalf("detectRulings = %d horizontal, %d vertical rules, want 3 and 3: %+v", len(r.horizontal), len(r.vertical), r)
+	}
+	tables := ExtractTables(testLayout(
+		testWord("A", 60, 115, 70, 135), testWord("B", 260, 115, 270, 135),
+		testWord("C", 60, 165, 70, 185), testWord("D", 260, 165, 270, 185),
+	), r)
+	if len(tables) != 1 || !reflect.DeepEqual(tables[0].Grid(), [][]string{{"A", "B"}, {"C", "D"}}) {
+		t.Fatalf("ExtractTables = %+v, want the 2x2 grid", tables)
+	}
+}
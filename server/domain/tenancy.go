@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TenantNamespace prefixes the file keys and storage paths of tenant files:
+// invoice.pdf of tenant acme is the file key tenants/acme/invoice.pdf and is
+// stored at tenants/acme/documents/invoice.pdf. Every table keyed by filename
+// (file metadata, OCR results, derived files, fields, PII reports) therefore
+// keeps tenants apart without a tenant in its unique constraints.
+//
+// Files of callers without a tenant claim (services, operators) keep plain
+// keys. Such callers are not scoped and address tenant files by their key,
+// so they need one of UnscopedRoles (see CheckTenantScope).
+const TenantNamespace = "tenants/"
+
+// UnscopedRoles are the roles that let a caller without a tenant act on the
+// files of every tenant.
+var UnscopedRoles = []string{"service", "admin"}
+
+// validTenant restricts tenant IDs to one path segment.
+var validTenant = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// ValidTenant reports whether a tenant ID can be used in file keys.
+func ValidTenant(tenant string) bool {
+	return validTenant.MatchString(tenant)
+}
+
+// TenantFileKey returns the key of a file of a tenant. Any tenant prefix of
+// filename is replaced, so a key of another tenant cannot be smuggled in.
+func TenantFileKey(tenant string, filename string) string {
+	_, name := SplitTenantFileKey(filename)
+	if tenant == "" {
+		return filename
+	}
+	return TenantNamespace + tenant + "/" + name
+}
+
+// SplitTenantFileKey returns the tenant and the plain filename of a file key;
+// the tenant is "" for files without one.
+func SplitTenantFileKey(key string) (string, string) {
+	if !strings.HasPrefix(key, TenantNamespace) {
+		return "", key
+	}
+	rest := key[len(TenantNamespace):]
+	i := strings.Index(rest, "/")
+	if i <= 0 {
+		return "", key
+	}
+	return rest[:i], rest[i+1:]
+}
+
+// TenantOfFilename returns the tenant a file key belongs to.
+func TenantOfFilename(key string) string {
+	tenant, _ := SplitTenantFileKey(key)
+	return tenant
+}
+
+// tenantStoragePrefix is the storage path prefix of a tenant's objects.
+func tenantStoragePrefix(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return TenantNamespace + tenant + "/"
+}
+
+// CheckTenantScope returns ErrPermissionDenied for a principal without a
+// tenant and without one of UnscopedRoles, e.g. a user token that omits the
+// tenant claim. The servers call it for every RPC, so "" is never taken as
+// all tenants for such callers.
+func CheckTenantScope(principal *Principal) error {
+	if principal == nil || principal.Tenant != "" {
+		return nil
+	}
+	for _, role := range UnscopedRoles {
+		if principal.HasRole(role) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s has no tenant and none of the roles %s", ErrPermissionDenied, principal.Subject, strings.Join(UnscopedRoles, ", "))
+}
+
+// CallerTenant returns the tenant of the caller, "" when it is not scoped.
+func CallerTenant(ctx context.Context) string {
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		return principal.Tenant
+	}
+	return ""
+}
+
+// CallerUnscoped reports whether the caller may act on the files of every
+// tenant: a principal without a tenant that passes CheckTenantScope, or work
+// without a caller, such as queue workers.
+func CallerUnscoped(ctx context.Context) bool {
+	principal := PrincipalFromContext(ctx)
+	return principal == nil || (principal.Tenant == "" && CheckTenantScope(principal) == nil)
+}
+
+// ScopeFilename turns a filename of a request into the file key of the
+// caller's tenant. Callers without a tenant get the name unchanged.
+func ScopeFilename(ctx context.Context, filename string) string {
+	if filename == "" {
+		return ""
+	}
+	return TenantFileKey(CallerTenant(ctx), filename)
+}
+
+// DisplayFilename turns a file key into the name the caller knows it by:
+// without the tenant prefix for tenant callers, the key for everyone else.
+func DisplayFilename(ctx context.Context, key string) string {
+	if CallerTenant(ctx) == "" {
+		return key
+	}
+	_, name := SplitTenantFileKey(key)
+	return name
+}
+
+// VisibleToCaller reports whether a file key belongs to the caller's tenant;
+// unscoped callers (see CallerUnscoped) see every file.
+func VisibleToCaller(ctx context.Context, key string) bool {
+	if tenant := CallerTenant(ctx); tenant != "" {
+		return TenantOfFilename(key) == tenant
+	}
+	return CallerUnscoped(ctx)
+}
@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTenantFileKey(t *testing.T) {
+	for _, tt := range []struct {
+		tenant, filename, want string
+	}{
+		{"acme", "invoice.pdf", "tenants/acme/invoice.pdf"},
+		{"acme", "scans/invoice.pdf", "tenants/acme/scans/invoice.pdf"},
+		{"acme", "tenants/other/invoice.pdf", "tenants/acme/invoice.pdf"},
+		{"acme", "tenants/acme/invoice.pdf", "tenants/acme/invoice.pdf"},
+		{"acme", "tenants/invoice.pdf", "tenants/acme/tenants/invoice.pdf"},
+		{"", "invoice.pdf", "invoice.pdf"},
+		{"", "tenants/other/invoice.pdf", "tenants/other/invoice.pdf"},
+	} {
+		if got := TenantFileKey(tt.tenant, tt.filename); got != tt.want {
+			t.Errorf("TenantFileKey(%q, %q) = %q, want %q", tt.tenant, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestValidTenant(t *testing.T) {
+	for _, tt := range []struct {
+		tenant string
+		want   bool
+	}{
+		{"acme", true},
+		{"acme-2.eu_west", true},
+		{"", false},
+		{"-acme", false},
+		{"acme/other", false},
+		{"..", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+	} {
+		if got := ValidTenant(tt.tenant); got != tt.want {
+			t.Errorf("ValidTenant(%q) = %v, want %v", tt.tenant, got, tt.want)
+		}
+	}
+}
+
+func TestCallerScope(t *testing.T) {
+	tenantUser := &Principal{Subject: "alice", Tenant: "acme", Roles: []string{"editor"}}
+	otherUser := &Principal{Subject: "bob", Tenant: "globex"}
+	service := &Principal{Subject: "ocr", Roles: []string{"service"}}
+	admin := &Principal{Subject: "root", Roles: []string{"admin"}}
+	untenanted := &Principal{Subject: "carol", Roles: []string{"editor"}}
+
+	for _, tt := range []struct {
+		name      string
+		principal *Principal
+		scopeErr  bool
+		scoped    string // ScopeFilename("invoice.pdf")
+		display   string // DisplayFilename("tenants/acme/invoice.pdf")
+		visible   []string
+		invisible []string
+	}{
+		{"tenant user", tenantUser, false, "tenants/acme/invoice.pdf", "invoice.pdf",
+			[]string{"tenants/acme/invoice.pdf"}, []string{"tenants/globex/invoice.pdf", "invoice.pdf"}},
+		{"user of another tenant", otherUser, false, "tenants/globex/invoice.pdf", "invoice.pdf",
+			[]string{"tenants/globex/invoice.pdf"}, []string{"tenants/acme/invoice.pdf", "invoice.pdf"}},
+		{"service", service, false, "invoice.pdf", "tenants/acme/invoice.pdf",
+			[]string{"invoice.pdf", "tenants/acme/invoice.pdf", "tenants/globex/invoice.pdf"}, nil},
+		{"admin", admin, false, "invoice.pdf", "tenants/acme/invoice.pdf",
+			[]string{"invoice.pdf", "tenants/acme/invoice.pdf"}, nil},
+		{"user without a tenant", untenanted, true, "invoice.pdf", "tenants/acme/invoice.pdf",
+			nil, []string{"invoice.pdf", "tenants/acme/invoice.pdf"}},
+		{"no caller", nil, false, "invoice.pdf", "tenants/acme/invoice.pdf",
+			[]string{"invoice.pdf", "tenants/acme/invoice.pdf"}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = WithPrincipal(ctx, tt.principal)
+			}
+			if err := CheckTenantScope(tt.principal); (err != nil) != tt.scopeErr || (err != nil && !errors.Is(err, ErrPermissionDenied)) {
+				t.Errorf("CheckTenantScope = %v, want error %v", err, tt.scopeErr)
+			}
+			if got := ScopeFilename(ctx, "invoice.pdf"); got != tt.scoped {
+				t.Errorf("ScopeFilename = %q, want %q", got, tt.scoped)
+			}
+			if got := ScopeFilename(ctx, ""); got != "" {
+				t.Errorf("ScopeFilename of no file = %q, want empty", got)
+			}
+			if got := DisplayFilename(ctx, "tenants/acme/invoice.pdf"); got != tt.display {
+				t.Errorf("DisplayFilename = %q, want %q", got, tt.display)
+			}
+			for _, key := range tt.visible {
+				if !VisibleToCaller(ctx, key) {
+					t.Errorf("VisibleToCaller(%q) = false, want true", key)
+				}
+			}
+			for _, key := range tt.invisible {
+				if VisibleToCaller(ctx, key) {
+					t.Errorf("VisibleToCaller(%q) = true, want false", key)
+				}
+			}
+		})
+	}
+}
@@ -18,6 +18,8 @@ import (
 // tesseractEngine ?Tesseract OCR???????
 type tesseractEngine struct {
 	language string // OCR??: "jpn+eng"??
+	defaults  []string // language codes of language, used when detection is inconclusive
+	installed []string // codes with an installed language pack; nil when unknown
 }
 
 // NewTesseractEngine ????Tesseract OCR?????????
@@ -25,23 +27,72 @@ func NewTesseractEngine(language string) OCREngine {
 	if language == "" {
 		language = "jpn+eng" // ?????: ???+??
 	}
+	defaults, err := ParseOCRLanguages([]string{language})
+	if err != nil {
+		log.Printf("Tesseract language %q is not in the language table, detection will not override it: %v", language, err)
+	}
 	return &tesseractEngine{
-		language: language,
+		language:  language,
+		defaults:  defaults,
+		installed: installedTesseractLanguages(),
 	}
 }
 
+// DefaultLanguages returns the languages configured at startup.
+func (e *tesseractEngine) DefaultLanguages() []string {
+	return e.defaults
+}
+
+// SupportsLanguage reports whether the language pack is installed. When the
+// tessdata directory cannot be found only the configured languages are assumed.
+func (e *tesseractEngine) SupportsLanguage(code string) bool {
+	if len(e.defaults) == 0 {
+		return false
+	}
+	if e.installed == nil {
+		return containsLanguage(e.defaults, code)
+	}
+	return containsLanguage(e.installed, code)
+}
+
+// LanguageProbes runs a single pass with every installed script; Tesseract can
+// combine any packs in one recognition.
+func (e *tesseractEngine) LanguageProbes() [][]string {
+	var probe []string
+	for _, code := range []string{"ja", "ko", "zh", "ru", "en"} {
+		if e.SupportsLanguage(code) {
+			probe = append(probe, code)
+		}
+	}
+	return [][]string{probe}
+}
+
+// languageSpec returns the Tesseract language spec for this call.
+func (e *tesseractEngine) languageSpec(ctx context.Context) string {
+	if codes := OCRLanguagesFromContext(ctx); len(codes) > 0 {
+		return tesseractLanguageSpec(codes)
+	}
+	return e.language
+}
+
 // Name ?????????
 func (e *tesseractEngine) Name() string {
 	return "tesseract"
 }
 
+// Version returns the version of the linked Tesseract library.
+func (e *tesseractEngine) Version() string {
+	return gosseract.Version()
+}
+
 // ProcessDocument ???????????????OCR?????
 func (e *tesseractEngine) ProcessDocument(ctx context.Context, filename string, content io.Reader) (*OCRResult, error) {
 	result := &OCRResult{
-		Filename:    filename,
-		EngineName:  e.Name(),
-		Status:      "processing",
-		ProcessedAt: time.Now(),
+		Filename:          filename,
+		EngineName:        e.Name(),
+		Status:            "processing",
+		ProcessedAt:       time.Now(),
+		PreprocessProfile: PreprocessorForEngine(e.Name()).Spec(),
 	}
 
 	// ??????
@@ -58,6 +109,11 @@ func (e *tesseractEngine) ProcessDocument(ctx context.Context, filename string,
 		return e.processImageFile(ctx, filename, content, result)
 	}
 
+	// Office documents: native text extraction, embedded images go through OCR
+	if isOfficeFile(ext) || isLegacyOfficeFile(ext) {
+		return processOfficeDocument(ctx, e, filename, content, result)
+	}
+
 	// ???????????????????
 	// TODO: ????????????????????PDF???????
 	result.Status = "failed"
@@ -67,19 +123,26 @@ func (e *tesseractEngine) ProcessDocument(ctx context.Context, filename string,
 
 // ProcessImage ????OCR?????
 func (e *tesseractEngine) ProcessImage(ctx context.Context, img image.Image) (string, float64, error) {
+	text, confidence, _, err := e.ProcessImageWithLayout(ctx, img)
+	return text, confidence, err
+}
+
+// ProcessImageWithLayout runs OCR and also returns word boxes from Tesseract's
+// TSV output. The confidence is the mean word confidence (0.0-1.0).
+func (e *tesseractEngine) ProcessImageWithLayout(ctx context.Context, img image.Image) (string, float64, *OCRPageLayout, error) {
 	// gosseract?OCR??
 	client := gosseract.NewClient()
 	defer client.Close()
 	
 	// ????
-	if err := client.SetLanguage(e.language); err != nil {
-		return "", 0.0, fmt.Errorf("failed to set language: %w", err)
+	if err := client.SetLanguage(e.languageSpec(ctx)); err != nil {
+		return "", 0.0, nil, fmt.Errorf("failed to set language: %w", err)
 	}
 	
 	// ?????????????gosseract?????????????
 	tempFile, err := saveImageToTempFile(img)
 	if err != nil {
-		return "", 0.0, fmt.Errorf("failed to save image to temp file: %w", err)
+		return "", 0.0, nil, fmt.Errorf("failed to save image to temp file: %w", err)
 	}
 	defer func() {
 		tempFile.Close()
@@ -90,14 +153,31 @@ func (e *tesseractEngine) ProcessImage(ctx context.Context, img image.Image) (st
 	client.SetImage(tempFile.Name())
 	text, err := client.Text()
 	if err != nil {
-		return "", 0.0, fmt.Errorf("failed to extract text: %w", err)
+		return "", 0.0, nil, fmt.Errorf("failed to extract text: %w", err)
 	}
 	
-	// ?????????gosseract v2??MeanConfidence?????????????0.0????
-	// TODO: HOCR?????????????????
-	confidence := 0.0
+	// Word boxes (block/paragraph/line numbers as in Tesseract's TSV output)
+	boxes, err := client.GetBoundingBoxesVerbose()
+	if err != nil {
+		return "", 0.0, nil, fmt.Errorf("failed to get word boxes: %w", err)
+	}
+	words := make([]layoutWord, 0, len(boxes))
+	for _, box := range boxes {
+		words = append(words, layoutWord{
+			OCRWord: OCRWord{
+				Text:       box.Word,
+				BBox:       boundingBoxFromRect(box.Box),
+				Confidence: box.Confidence / 100.0,
+			},
+			Block: box.BlockNum,
+			Par:   box.ParNum,
+			Line:  box.LineNum,
+		})
+	}
+	bounds := img.Bounds()
+	layout := buildLayoutFromNumberedWords(bounds.Dx(), bounds.Dy(), words)
 	
-	return text, confidence, nil
+	return text, layout.MeanConfidence(), layout, nil
 }
 
 // saveImageToTempFile ???????????????
@@ -139,6 +219,7 @@ func (e *tesseractEngine) processPDF(ctx context.Context, filename string, conte
 	}
 
 	log.Printf("PDF converted to %d images", len(images))
+	ctx = resolveDocumentLanguages(ctx, e, result, "", images)
 
 	// ????OCR??
 	var allText strings.Builder
@@ -147,7 +228,7 @@ func (e *tesseractEngine) processPDF(ctx context.Context, filename string, conte
 
 	for pageNum, img := range images {
 		// OCR??
-		text, confidence, err := e.ProcessImage(ctx, img)
+		rec, err := recognizeImage(ctx, e, img, pdfRenderDPI)
 		if err != nil {
 			log.Printf("Failed to process OCR for page %d: %v", pageNum+1, err)
 			// ??????????????
@@ -161,15 +242,17 @@ func (e *tesseractEngine) processPDF(ctx context.Context, filename string, conte
 
 		// ?????
 		allText.WriteString(fmt.Sprintf("\n--- Page %d ---\n", pageNum+1))
-		allText.WriteString(text)
-		totalConfidence += confidence
+		allText.WriteString(rec.Text)
+		totalConfidence += rec.Confidence
 		pages = append(pages, OCRPage{
-			PageNumber: pageNum + 1,
-			Text:       text,
-			Confidence: confidence,
+			PageNumber:      pageNum + 1,
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
 		})
 
-		log.Printf("Processed page %d/%d: confidence=%.2f", pageNum+1, len(images), confidence)
+		log.Printf("Processed page %d/%d: confidence=%.2f", pageNum+1, len(images), rec.Confidence)
 	}
 
 	// ?????
@@ -201,9 +284,10 @@ func (e *tesseractEngine) processImageFile(ctx context.Context, filename string,
 	}
 	
 	log.Printf("Decoded image format: %s", format)
+	ctx = resolveDocumentLanguages(ctx, e, result, "", []image.Image{img})
 	
 	// OCR??
-	text, confidence, err := e.ProcessImage(ctx, img)
+	rec, err := recognizeImage(ctx, e, img, 0)
 	if err != nil {
 		result.Status = "failed"
 		result.Error = fmt.Errorf("OCR processing failed: %w", err)
@@ -211,14 +295,16 @@ func (e *tesseractEngine) processImageFile(ctx context.Context, filename string,
 	}
 	
 	// ?????
-	result.ExtractedText = text
-	result.Confidence = confidence
+	result.ExtractedText = rec.Text
+	result.Confidence = rec.Confidence
 	result.Status = "completed"
 	result.Pages = []OCRPage{
 		{
-			PageNumber: 1,
-			Text:       text,
-			Confidence: confidence,
+			PageNumber:      1,
+			Text:            rec.Text,
+			Confidence:      rec.Confidence,
+			Layout:          rec.Layout,
+			PreprocessSteps: rec.PreprocessSteps,
 		},
 	}
 	
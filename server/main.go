@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -13,6 +16,7 @@ import (
 	pb "grpc-sample-minimal/proto"
 	"grpc-sample-minimal/server/application"
 	"grpc-sample-minimal/server/domain"
+	"grpc-sample-minimal/tlsconfig"
 )
 
 const (
@@ -20,7 +24,21 @@ const (
 )
 
 var (
+	// authToken is the service token sent to the OCR services for calls
+	// without a caller (AUTH_TOKEN, a JWT)
 	authToken = os.Getenv("AUTH_TOKEN")
+
+	// authenticator verifies the JWTs of incoming RPCs (see domain.AuthenticatorFromEnv)
+	authenticator *domain.Authenticator
+
+	// authorizer enforces the access policy (AUTH_POLICY_FILE); nil disables it
+	authorizer *domain.Authorizer
+
+	// auditLog records every RPC; nil when the database is unavailable
+	auditLog domain.AuditLog
+
+	// rateLimiter limits the calls of each caller (RATE_LIMIT*); nil disables it
+	rateLimiter *domain.RateLimiter
 )
 
 // server is used to implement proto.GreeterServer.
@@ -29,44 +47,151 @@ type server struct{
 	appService *application.ApplicationService
 }
 
-// authInterceptor is a unary interceptor that checks for a valid auth token.
+// authInterceptor is a unary interceptor that verifies the caller's JWT and
+// puts the principal into the context.
 func authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	ctx, principal, err := authenticator.Authenticate(ctx)
+	if err != nil {
+		log.Printf("Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
 	}
-
-	values := md["authorization"]
-	if len(values) == 0 || values[0] != authToken {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token")
+	domain.AuditEventFromContext(ctx).SetPrincipal(principal)
+	if err := domain.CheckTenantScope(principal); err != nil {
+		log.Printf("Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := domain.CheckAPIKeyScope(principal, info.FullMethod); err != nil {
+		log.Printf("Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
 	}
 
-	log.Printf("Auth successful for method: %s", info.FullMethod)
+	log.Printf("Auth successful for method: %s (sub=%s, tenant=%s)", info.FullMethod, principal.Subject, principal.Tenant)
 	return handler(ctx, req)
 }
 
-// loggingInterceptor is a unary interceptor that logs RPC calls.
+// authzInterceptor is a unary interceptor that checks the caller's roles
+// against the access policy and drops files outside the caller's namespaces
+// from listings.
+func authzInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if authorizer == nil {
+		return handler(ctx, req)
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	accessReq := authorizer.AccessRequestFor(ctx, info.FullMethod, req, "")
+	if err := authorizer.Authorize(principal, accessReq); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if r, ok := req.(interface{ GetFilenames() []string }); ok {
+		if err := authorizer.AuthorizeFilenames(ctx, principal, accessReq, r.GetFilenames()); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	resp, err := handler(authorizer.ScopeNamespaces(ctx, principal, accessReq), req)
+	if err == nil {
+		authorizer.FilterResponse(ctx, principal, accessReq, resp)
+	}
+	return resp, err
+}
+
+// authzStreamInterceptor is a stream interceptor that checks the caller's
+// roles for the method, then the file and provider of each received message.
+func authzStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if authorizer == nil {
+		return handler(srv, ss)
+	}
+	principal := domain.PrincipalFromContext(ss.Context())
+	// UploadFile selects the provider by metadata (default: s3)
+	provider := ""
+	if md, ok := metadata.FromIncomingContext(ss.Context()); ok {
+		if vals := md.Get("storage-provider"); len(vals) > 0 {
+			provider = vals[0]
+		}
+	}
+	if err := authorizer.Authorize(principal, domain.AccessRequest{Method: info.FullMethod, Provider: provider}); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if provider == "" {
+		provider = "s3"
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, principal: principal, method: info.FullMethod, provider: provider})
+}
+
+// authorizedStream checks every received message that names a file or a
+// storage provider.
+type authorizedStream struct {
+	grpc.ServerStream
+	principal *domain.Principal
+	method    string
+	provider  string
+	allowed   map[[2]string]bool // filename and provider
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	filename, provider := domain.RequestFile(m, s.provider)
+	if _, hasProvider := m.(interface{ GetStorageProvider() string }); filename == "" && !hasProvider {
+		return nil
+	}
+	// Upload chunks repeat the filename; decide once per file and provider
+	key := [2]string{filename, provider}
+	if s.allowed[key] {
+		return nil
+	}
+	accessReq := authorizer.AccessRequestFor(s.Context(), s.method, m, s.provider)
+	if err := authorizer.Authorize(s.principal, accessReq); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if s.allowed == nil {
+		s.allowed = map[[2]string]bool{}
+	}
+	s.allowed[key] = true
+	return nil
+}
+
+// loggingInterceptor is a unary interceptor that logs RPC calls. Requests
+// and responses are not printed, as they carry file contents, OCR text and
+// new API keys; the audit log records who did what.
 func loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	log.Printf("Incoming RPC: %s, Request: %v", info.FullMethod, req)
+	log.Printf("Incoming RPC: %s", info.FullMethod)
+	start := time.Now()
 	resp, err := handler(ctx, req)
-	log.Printf("Outgoing RPC: %s, Response: %v, Error: %v", info.FullMethod, resp, err)
+	log.Printf("Outgoing RPC: %s, Code: %s, Duration: %s, Error: %v", info.FullMethod, status.Code(err), time.Since(start), err)
 	return resp, err
 }
 
-// authStreamInterceptor is a stream interceptor that checks for a valid auth token.
+// authStreamInterceptor is a stream interceptor that verifies the caller's
+// JWT and puts the principal into the stream's context.
 func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	md, ok := metadata.FromIncomingContext(ss.Context())
-	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	ctx, principal, err := authenticator.Authenticate(ss.Context())
+	if err != nil {
+		log.Printf("Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.Unauthenticated, err.Error())
 	}
-
-	values := md["authorization"]
-	if len(values) == 0 || values[0] != authToken {
-		return status.Errorf(codes.Unauthenticated, "invalid auth token")
+	domain.AuditEventFromContext(ctx).SetPrincipal(principal)
+	if err := domain.CheckTenantScope(principal); err != nil {
+		log.Printf("Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := domain.CheckAPIKeyScope(principal, info.FullMethod); err != nil {
+		log.Printf("Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.PermissionDenied, err.Error())
 	}
 
-	log.Printf("Auth successful for stream method: %s", info.FullMethod)
-	return handler(srv, ss)
+	log.Printf("Auth successful for stream method: %s (sub=%s, tenant=%s)", info.FullMethod, principal.Subject, principal.Tenant)
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream is a server stream whose context carries the principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
 }
 
 // loggingStreamInterceptor is a stream interceptor that logs RPC calls.
@@ -80,52 +205,216 @@ func loggingStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.
 func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
 	msg, err := s.appService.SayHello(ctx, in.GetName())
 	if err != nil {
-		return nil, err
+		return nil, domainError(err)
 	}
 	return &pb.HelloReply{Message: msg}, nil
 }
 
 func (s *server) StreamCounter(in *pb.CounterRequest, stream pb.Greeter_StreamCounterServer) error {
-	return s.appService.StreamCounter(stream.Context(), in.GetLimit(), stream)
+	return domainError(s.appService.StreamCounter(stream.Context(), in.GetLimit(), stream))
 }
 
 func (s *server) Chat(stream pb.Greeter_ChatServer) error {
-	return s.appService.Chat(stream)
+	return domainError(s.appService.Chat(stream))
 }
 
 func (s *server) UploadFile(stream pb.Greeter_UploadFileServer) error {
-	return s.appService.UploadFile(stream)
+	return domainError(s.appService.UploadFile(stream))
 }
 
 func (s *server) DownloadFile(req *pb.FileDownloadRequest, stream pb.Greeter_DownloadFileServer) error {
-	return s.appService.DownloadFile(req, stream)
+	return domainError(s.appService.DownloadFile(req, stream))
 }
 
 func (s *server) ListFiles(ctx context.Context, req *pb.FileListRequest) (*pb.FileListResponse, error) {
-	return s.appService.ListFiles(ctx, req)
+	resp, err := s.appService.ListFiles(ctx, req)
+	return resp, domainError(err)
 }
 
 func (s *server) DeleteFile(ctx context.Context, req *pb.DeleteFileRequest) (*pb.DeleteFileResponse, error) {
-	return s.appService.DeleteFile(ctx, req)
+	resp, err := s.appService.DeleteFile(ctx, req)
+	return resp, domainError(err)
 }
 
 func (s *server) ProcessOCR(ctx context.Context, req *pb.OCRRequest) (*pb.OCRResponse, error) {
-	return s.appService.ProcessOCR(ctx, req)
+	resp, err := s.appService.ProcessOCR(ctx, req)
+	return resp, domainError(err)
 }
 
 func (s *server) GetOCRResult(ctx context.Context, req *pb.OCRResultRequest) (*pb.OCRResultResponse, error) {
-	return s.appService.GetOCRResult(ctx, req)
+	resp, err := s.appService.GetOCRResult(ctx, req)
+	return resp, domainError(err)
 }
 
 func (s *server) ListOCRResults(ctx context.Context, req *pb.OCRListRequest) (*pb.OCRListResponse, error) {
-	return s.appService.ListOCRResults(ctx, req)
+	resp, err := s.appService.ListOCRResults(ctx, req)
+	return resp, domainError(err)
 }
 
 func (s *server) CompareOCRResults(ctx context.Context, req *pb.OCRComparisonRequest) (*pb.OCRComparisonResponse, error) {
-	return s.appService.CompareOCRResults(ctx, req)
+	resp, err := s.appService.CompareOCRResults(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) GetOCRLayout(ctx context.Context, req *pb.OCRLayoutRequest) (*pb.OCRLayoutResponse, error) {
+	resp, err := s.appService.GetOCRLayout(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) GetExtractedTables(ctx context.Context, req *pb.ExtractedTablesRequest) (*pb.ExtractedTablesResponse, error) {
+	resp, err := s.appService.GetExtractedTables(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) ExportSearchablePDF(ctx context.Context, req *pb.SearchablePDFRequest) (*pb.SearchablePDFResponse, error) {
+	resp, err := s.appService.ExportSearchablePDF(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) SetGroundTruth(ctx context.Context, req *pb.GroundTruthRequest) (*pb.GroundTruthResponse, error) {
+	resp, err := s.appService.SetGroundTruth(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) EvaluateOCR(ctx context.Context, req *pb.EvaluateOCRRequest) (*pb.EvaluateOCRResponse, error) {
+	resp, err := s.appService.EvaluateOCR(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) GetExtractedFields(ctx context.Context, req *pb.ExtractedFieldsRequest) (*pb.ExtractedFieldsResponse, error) {
+	resp, err := s.appService.GetExtractedFields(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) SearchDocuments(ctx context.Context, req *pb.SearchDocumentsRequest) (*pb.SearchDocumentsResponse, error) {
+	resp, err := s.appService.SearchDocuments(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) GetPIIFindings(ctx context.Context, req *pb.PIIFindingsRequest) (*pb.PIIFindingsResponse, error) {
+	resp, err := s.appService.GetPIIFindings(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	resp, err := s.appService.CreateAPIKey(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	resp, err := s.appService.ListAPIKeys(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	resp, err := s.appService.RevokeAPIKey(ctx, req)
+	return resp, domainError(err)
+}
+
+// domainError maps the errors of the domain to gRPC codes.
+func domainError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, domain.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, domain.ErrAPIKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, domain.ErrInvalidAPIKeyScope):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, domain.ErrQuotaExceeded), errors.Is(err, domain.ErrRateLimited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, domain.ErrContentRejected):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, domain.ErrFileQuarantined):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, domain.ErrScanFailed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, domain.ErrSignedURLUnavailable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, domain.ErrInvalidSignedURL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
+func (s *server) QueryAuditLog(ctx context.Context, req *pb.AuditLogQuery) (*pb.AuditLogResponse, error) {
+	resp, err := s.appService.QueryAuditLog(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) GetQuota(ctx context.Context, req *pb.GetQuotaRequest) (*pb.GetQuotaResponse, error) {
+	resp, err := s.appService.GetQuota(ctx, req)
+	if err != nil {
+		return nil, domainError(err)
+	}
+	// The rate limit is enforced here, not by the application service
+	if principal := domain.PrincipalFromContext(ctx); rateLimiter != nil && principal != nil {
+		limit, remaining := rateLimiter.Remaining(principal)
+		resp.RateLimitPerSecond, resp.RateLimitBurst, resp.RateLimitRemaining = limit.Rate, limit.Burst, remaining
+	}
+	return resp, nil
+}
+
+func (s *server) CreateUploadURL(ctx context.Context, req *pb.CreateUploadURLRequest) (*pb.SignedURLResponse, error) {
+	resp, err := s.appService.CreateUploadURL(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) FinalizeUpload(ctx context.Context, req *pb.FinalizeUploadRequest) (*pb.FileUploadStatus, error) {
+	resp, err := s.appService.FinalizeUpload(ctx, req)
+	return resp, domainError(err)
+}
+
+func (s *server) CreateDownloadURL(ctx context.Context, req *pb.CreateDownloadURLRequest) (*pb.SignedURLResponse, error) {
+	resp, err := s.appService.CreateDownloadURL(ctx, req)
+	return resp, domainError(err)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(runToken(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		os.Exit(runAuditVerify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-storage-key" {
+		os.Exit(runRotateStorageKey(os.Args[2:]))
+	}
+
+	var err error
+	authenticator, err = domain.AuthenticatorFromEnv()
+	if err != nil {
+		log.Fatalf("authentication is not configured: %v", err)
+	}
+	authorizer, err = domain.AuthorizerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid AUTH_POLICY_FILE: %v", err)
+	}
+	if authorizer == nil {
+		log.Printf("AUTH_POLICY_FILE is not set: every authenticated caller may use every RPC")
+	}
+	rateLimiter, err = domain.RateLimiterFromEnv()
+	if err != nil {
+		log.Fatalf("invalid rate limit configuration: %v", err)
+	}
+	quotaPolicy, err := domain.QuotaPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid quota configuration: %v", err)
+	}
+	contentPolicy, err := domain.ContentPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid content validation configuration: %v", err)
+	}
+	contentScanner, err := domain.ContentScannerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid content scanner configuration: %v", err)
+	}
+	signedURLs, err := domain.SignedURLPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid signed URL configuration: %v", err)
+	}
+
 	domainService := domain.NewGreeterService()
 	storageService, err := domain.NewS3StorageService()
 	if err != nil {
@@ -144,6 +433,10 @@ func main() {
 			}
 		}
 	}()
+	// Policies decide on the namespace files are stored under
+	if authorizer != nil {
+		authorizer.SetFileMetadata(fileRepo)
+	}
 	
 	// OCR?????????????????gRPC????????
 	// ??OCR?????????: MultiOCRClient???
@@ -205,14 +498,68 @@ func main() {
 		}
 	}()
 	
+	// API keys for automation clients, accepted next to JWTs
+	apiKeyStore, err := domain.NewAPIKeyStore(context.Background())
+	if err != nil {
+		log.Printf("Warning: Failed to create API key store: %v (API keys will be unavailable)", err)
+	} else {
+		authenticator.SetAPIKeys(apiKeyStore)
+		defer func() {
+			if closer, ok := apiKeyStore.(interface{ Close() error }); ok {
+				if err := closer.Close(); err != nil {
+					log.Printf("Error closing API key store: %v", err)
+				}
+			}
+		}()
+	}
+	
+	// Audit log of every RPC
+	auditLog, err = domain.NewAuditLog(context.Background())
+	if err != nil {
+		log.Printf("Warning: Failed to create audit log: %v (RPCs will not be audited)", err)
+	} else {
+		defer func() {
+			if closer, ok := auditLog.(interface{ Close() error }); ok {
+				if err := closer.Close(); err != nil {
+					log.Printf("Error closing audit log: %v", err)
+				}
+			}
+		}()
+	}
+	
 	appService := application.NewApplicationService(
 		domainService, 
 		storageService, 
 		fileRepo,
 		ocrClient,
 		ocrResultRepo,
+		apiKeyStore,
+		auditLog,
+		quotaPolicy,
+		contentPolicy,
+		contentScanner,
+		signedURLs,
 	)
 
+	// Signed URLs of storages that cannot sign their own are served here
+	if signedURLs != nil {
+		err := serveTransfers(signedURLs, func(ctx context.Context, provider string) (domain.StorageService, error) {
+			switch provider {
+			case "s3":
+				return storageService, nil
+			case "gcs":
+				return domain.NewGCSStorageService(ctx)
+			case "azure":
+				return domain.NewAzureStorageService(ctx)
+			}
+			return nil, fmt.Errorf("unknown storage provider %q", provider)
+		})
+		if err != nil {
+			log.Fatalf("failed to start the transfer handler: %v", err)
+		}
+		go sweepStagedUploads(appService)
+	}
+
 	port := os.Getenv("GRPC_SERVER_PORT")
 	if port == "" {
 		port = defaultPort
@@ -223,9 +570,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
+	creds, err := tlsconfig.ServerOption("server")
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
 	s := grpc.NewServer(
-		grpc.ChainUnaryInterceptor(loggingInterceptor, authInterceptor),
-		grpc.ChainStreamInterceptor(loggingStreamInterceptor, authStreamInterceptor),
+		creds,
+		grpc.ChainUnaryInterceptor(loggingInterceptor, auditInterceptor, authInterceptor, rateLimitInterceptor, authzInterceptor),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, auditStreamInterceptor, authStreamInterceptor, rateLimitStreamInterceptor, authzStreamInterceptor),
 	)
 	pb.RegisterGreeterServer(s, &server{appService: appService})
 	log.Printf("server listening at %v", lis.Addr())
@@ -0,0 +1,140 @@
+// Command easyocr_stub is a Python-free stand-in for easyocr_worker.py. It speaks
+// the same framed JSON protocol and returns one fixed detection per image, so
+// the EasyOCR worker pool can be exercised without PyTorch. The pool tests in
+// server/domain/easyocr_pool_test.go build and run it; by hand:
+//
+//	EASYOCR_WORKER_COMMAND=/app/easyocr-stub OCR_ENGINES=easyocr ./ocr-service
+//
+// Fault injection for pool testing:
+//
+//	EASYOCR_STUB_TEXT         text returned for every image (default "stub text")
+//	EASYOCR_STUB_DELAY        sleep per image, e.g. "2s", to trigger request timeouts
+//	EASYOCR_STUB_START_DELAY  sleep before reporting ready
+//	EASYOCR_STUB_CRASH_AFTER  exit with status 1 on the Nth OCR request
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type request struct {
+	ID        uint64   `json:"id"`
+	Op        string   `json:"op"`
+	Languages []string `json:"languages"`
+	Images    []string `json:"images"`
+}
+
+type box struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+	Box        [4]int  `json:"box"`
+}
+
+type imageResult struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"`
+	Boxes      []box   `json:"boxes"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type response struct {
+	ID      uint64        `json:"id"`
+	Ready   bool          `json:"ready,omitempty"`
+	Version string        `json:"version,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Results []imageResult `json:"results,omitempty"`
+}
+
+func readFrame(r io.Reader, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeFrame(w *bufio.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	w.Write(header[:])
+	w.Write(data)
+	return w.Flush()
+}
+
+func recognize(path string, text string) imageResult {
+	f, err := os.Open(path)
+	if err != nil {
+		return imageResult{Error: err.Error()}
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return imageResult{Error: fmt.Sprintf("failed to decode %s: %v", path, err)}
+	}
+	b := box{Text: text, Confidence: 0.9, Box: [4]int{0, 0, cfg.Width, cfg.Height}}
+	return imageResult{Text: text, Confidence: b.Confidence, Boxes: []box{b}}
+}
+
+func main() {
+	text := os.Getenv("EASYOCR_STUB_TEXT")
+	if text == "" {
+		text = "stub text"
+	}
+	delay, _ := time.ParseDuration(os.Getenv("EASYOCR_STUB_DELAY"))
+	startDelay, _ := time.ParseDuration(os.Getenv("EASYOCR_STUB_START_DELAY"))
+	crashAfter, _ := strconv.Atoi(os.Getenv("EASYOCR_STUB_CRASH_AFTER"))
+
+	in := bufio.NewReader(os.Stdin)
+	out := bufio.NewWriter(os.Stdout)
+	time.Sleep(startDelay)
+	if err := writeFrame(out, response{Ready: true, Version: "stub"}); err != nil {
+		os.Exit(1)
+	}
+
+	ocrRequests := 0
+	for {
+		var req request
+		if err := readFrame(in, &req); err != nil {
+			return
+		}
+		resp := response{ID: req.ID}
+		switch req.Op {
+		case "ping":
+		case "ocr":
+			ocrRequests++
+			if crashAfter > 0 && ocrRequests >= crashAfter {
+				fmt.Fprintf(os.Stderr, "easyocr_stub: crashing on request %d\n", ocrRequests)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "easyocr_stub: %d image(s), languages %s\n", len(req.Images), strings.Join(req.Languages, ","))
+			for _, path := range req.Images {
+				time.Sleep(delay)
+				resp.Results = append(resp.Results, recognize(path, text))
+			}
+		default:
+			resp.Error = "unknown op: " + req.Op
+		}
+		if err := writeFrame(out, resp); err != nil {
+			return
+		}
+	}
+}
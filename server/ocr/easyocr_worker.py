@@ -0,0 +1,115 @@
+#!/usr/bin/env python3
+"""
+Long-lived EasyOCR worker for the Go OCR service.
+
+Speaks length-prefixed JSON frames (4-byte big-endian length + UTF-8 JSON) on
+stdin/stdout. After start-up it sends {"ready": true, "version": "1.7.1"}; then it answers each
+request with a frame carrying the same "id":
+
+  {"id": 1, "op": "ping"}                                   -> {"id": 1}
+  {"id": 2, "op": "ocr", "languages": ["ja", "en"],
+   "images": ["/tmp/a.png", "/tmp/b.png"]}                  -> {"id": 2, "results": [...]}
+
+Readers are cached per language set, so models load once per worker.
+"""
+
+import json
+import os
+import struct
+import sys
+from collections import OrderedDict
+
+# Frames own the real stdout; anything else printed (model download progress,
+# library warnings) is redirected to stderr so it cannot corrupt the protocol.
+_frames = os.fdopen(os.dup(1), "wb")
+os.dup2(2, 1)
+sys.stdout = sys.stderr
+
+import easyocr  # noqa: E402  (imported after the redirect on purpose)
+
+MAX_READERS = int(os.environ.get("EASYOCR_MAX_READERS", "2"))
+_readers = OrderedDict()
+
+
+def read_frame():
+    header = sys.stdin.buffer.read(4)
+    if len(header) < 4:
+        return None
+    (length,) = struct.unpack(">I", header)
+    data = sys.stdin.buffer.read(length)
+    if len(data) < length:
+        return None
+    return json.loads(data.decode("utf-8"))
+
+
+def write_frame(obj):
+    data = json.dumps(obj).encode("utf-8")
+    _frames.write(struct.pack(">I", len(data)) + data)
+    _frames.flush()
+
+
+def get_reader(languages):
+    key = tuple(languages)
+    reader = _readers.get(key)
+    if reader is None:
+        reader = easyocr.Reader(list(languages), gpu=False)
+        _readers[key] = reader
+        while len(_readers) > MAX_READERS:
+            _readers.popitem(last=False)
+    else:
+        _readers.move_to_end(key)
+    return reader
+
+
+def recognize(reader, image_path):
+    results = reader.readtext(image_path)
+    boxes = []
+    for points, text, confidence in results:
+        xs = [float(p[0]) for p in points]
+        ys = [float(p[1]) for p in points]
+        boxes.append({
+            "text": text,
+            "confidence": float(confidence),
+            "box": [int(min(xs)), int(min(ys)), int(round(max(xs))), int(round(max(ys)))],
+        })
+    confidence = sum(b["confidence"] for b in boxes) / len(boxes) if boxes else 0.0
+    return {
+        "text": "\n".join(b["text"] for b in boxes),
+        "confidence": confidence,
+        "boxes": boxes,
+    }
+
+
+def handle(request):
+    op = request.get("op")
+    if op == "ping":
+        return {}
+    if op != "ocr":
+        return {"error": "unknown op: %s" % op}
+    languages = request.get("languages") or ["ja", "en"]
+    try:
+        reader = get_reader(languages)
+    except Exception as e:  # bad language combination, missing model
+        return {"error": str(e)}
+    results = []
+    for path in request.get("images") or []:
+        try:
+            results.append(recognize(reader, path))
+        except Exception as e:
+            results.append({"text": "", "confidence": 0.0, "boxes": [], "error": str(e)})
+    return {"results": results}
+
+
+def main():
+    write_frame({"ready": True, "version": getattr(easyocr, "__version__", "")})
+    while True:
+        request = read_frame()
+        if request is None:
+            return
+        response = handle(request)
+        response["id"] = request.get("id", 0)
+        write_frame(response)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"grpc-sample-minimal/server/domain"
+)
+
+// groundTruthSuffix marks the reference text of a sample: invoice.pdf is
+// labelled by invoice.pdf.gt.txt or invoice.gt.txt.
+const groundTruthSuffix = ".gt.txt"
+
+// evaluationSample is a labelled file of an evaluation directory.
+type evaluationSample struct {
+	path        string
+	groundTruth *domain.GroundTruth
+}
+
+// runEvaluate implements the evaluate subcommand: it runs every labelled
+// sample of a directory through the OCR engines, prints CER/WER per engine
+// version and stores the aggregates in the result database.
+func runEvaluate(args []string) int {
+	fs := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	dir := fs.String("dir", "", "directory of samples, each with a <file>.gt.txt or <name>.gt.txt ground truth")
+	engines := fs.String("engines", "", "comma-separated engines to evaluate (default: OCR_ENGINES)")
+	dataset := fs.String("dataset", "", "name the results are stored under (default: directory name)")
+	languages := fs.String("languages", "", "comma-separated OCR languages (default: detected per document)")
+	store := fs.Bool("store", true, "store the aggregates in the OCR result database (DB_PATH)")
+	verbose := fs.Bool("v", false, "print per file and page results")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s evaluate -dir <samples> [flags]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		fs.Usage()
+		return 2
+	}
+
+	engineNames := getEngineNames()
+	if *engines != "" {
+		engineNames = splitList(*engines)
+	}
+	if *dataset == "" {
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			log.Printf("evaluate: %v", err)
+			return 1
+		}
+		*dataset = filepath.Base(abs)
+	}
+	ctx := context.Background()
+	if *languages != "" {
+		codes, err := domain.ParseOCRLanguages(splitList(*languages))
+		if err != nil {
+			log.Printf("evaluate: %v", err)
+			return 2
+		}
+		ctx = domain.WithOCRLanguages(ctx, codes)
+	}
+
+	samples, err := loadEvaluationSamples(*dir)
+	if err != nil {
+		log.Printf("evaluate: %v", err)
+		return 1
+	}
+	if len(samples) == 0 {
+		log.Printf("evaluate: no labelled samples in %s", *dir)
+		return 1
+	}
+
+	ocrService, closeEngines, err := newOCRService()
+	if err != nil {
+		log.Printf("evaluate: %v", err)
+		return 1
+	}
+	defer closeEngines()
+
+	set := domain.NewOCREvaluationSet(*dataset)
+	for _, sample := range samples {
+		if err := evaluateSample(ctx, ocrService, engineNames, sample, set); err != nil {
+			log.Printf("evaluate: %s: %v", sample.path, err)
+			return 1
+		}
+	}
+	evaluations := set.Evaluations()
+	printEvaluations(os.Stdout, evaluations, *verbose)
+
+	if *store {
+		if err := storeEvaluations(evaluations); err != nil {
+			log.Printf("evaluate: %v", err)
+			return 1
+		}
+		log.Printf("Stored %d evaluation(s) as dataset %q", len(evaluations), *dataset)
+	}
+	return 0
+}
+
+// loadEvaluationSamples finds the files of dir that have a ground truth file.
+func loadEvaluationSamples(dir string) ([]evaluationSample, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var samples []evaluationSample
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, groundTruthSuffix) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		candidates := []string{path + groundTruthSuffix, strings.TrimSuffix(path, filepath.Ext(name)) + groundTruthSuffix}
+		var text []byte
+		for _, candidate := range candidates {
+			if text, err = os.ReadFile(candidate); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Skipping %s: no ground truth", name)
+			continue
+		}
+		samples = append(samples, evaluationSample{
+			path:        path,
+			groundTruth: domain.NewGroundTruth(name, "local", string(text)),
+		})
+	}
+	return samples, nil
+}
+
+// evaluateSample runs a sample through each engine separately, so every
+// engine reads the file from the start, and adds the results to set. A failed
+// engine is evaluated as having read nothing.
+func evaluateSample(ctx context.Context, ocrService domain.OCRService, engineNames []string, sample evaluationSample, set *domain.OCREvaluationSet) error {
+	content, err := os.ReadFile(sample.path)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Base(sample.path)
+
+	var completed []*domain.OCRResult
+	for _, engineName := range engineNames {
+		if engineName == domain.EnsembleEngineName {
+			continue
+		}
+		if ocrService.GetEngine(engineName) == nil {
+			return fmt.Errorf("OCR engine %s is not registered", engineName)
+		}
+		start := time.Now()
+		results, err := ocrService.ProcessDocument(ctx, filename, bytes.NewReader(content), []string{engineName})
+		if err != nil {
+			return err
+		}
+		result := results[engineName]
+		if result == nil {
+			continue
+		}
+		if result.Error != nil {
+			log.Printf("%s failed on %s: %v", engineName, filename, result.Error)
+			result.ExtractedText, result.Pages = "", nil
+		} else {
+			result.Status = "completed"
+			completed = append(completed, result)
+		}
+		fe := set.Add(sample.groundTruth, result)
+		log.Printf("%s %s: CER %.2f%% WER %.2f%% (%s)", filename, engineName, fe.CER()*100, fe.WER()*100, time.Since(start).Round(time.Millisecond))
+	}
+
+	if containsString(engineNames, domain.EnsembleEngineName) {
+		result, err := domain.BuildEnsembleResult(completed)
+		if err != nil {
+			log.Printf("Ensemble skipped for %s: %v", filename, err)
+			return nil
+		}
+		fe := set.Add(sample.groundTruth, result)
+		log.Printf("%s %s: CER %.2f%% WER %.2f%%", filename, domain.EnsembleEngineName, fe.CER()*100, fe.WER()*100)
+	}
+	return nil
+}
+
+// printEvaluations writes the aggregates as a table.
+func printEvaluations(w io.Writer, evaluations []*domain.OCREvaluation, verbose bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENGINE\tVERSION\tPREPROCESS\tFILES\tPAGES\tCER\tWER")
+	for _, ev := range evaluations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.2f%%\t%.2f%%\n",
+			ev.EngineName, orDash(ev.EngineVersion), orDash(ev.PreprocessProfile), ev.Files, ev.Pages, ev.CER()*100, ev.WER()*100)
+		if !verbose {
+			continue
+		}
+		files := append([]domain.FileEvaluation(nil), ev.FileResults...)
+		sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+		for _, fe := range files {
+			fmt.Fprintf(tw, "  %s\t\t\t\t%d\t%.2f%%\t%.2f%%\n", fe.Filename, max(len(fe.Pages), 1), fe.CER()*100, fe.WER()*100)
+			for _, pe := range fe.Pages {
+				fmt.Fprintf(tw, "    page %d\t\t\t\t\t%.2f%%\t%.2f%%\n", pe.PageNumber, pe.CER()*100, pe.WER()*100)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+// storeEvaluations saves the aggregates to the database at DB_PATH.
+func storeEvaluations(evaluations []*domain.OCREvaluation) error {
+	ctx := context.Background()
+	// The metadata repository creates the schema when the database is new.
+	fileMetadataRepo, err := domain.NewFileMetadataRepository(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if closer, ok := fileMetadataRepo.(io.Closer); ok {
+		defer closer.Close()
+	}
+	ocrResultRepo, err := domain.NewOCRResultRepository(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create OCR result repository: %w", err)
+	}
+	if closer, ok := ocrResultRepo.(io.Closer); ok {
+		defer closer.Close()
+	}
+	for _, ev := range evaluations {
+		if err := ocrResultRepo.SaveOCREvaluation(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
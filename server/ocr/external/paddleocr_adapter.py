@@ -0,0 +1,68 @@
+#!/usr/bin/env python3
+"""
+Example command adapter wrapping PaddleOCR for the external OCR engine.
+
+Reads one request from stdin and writes one response to stdout, following the
+contract in doc/EXTERNAL_OCR_ENGINES.md:
+
+  {"image": "<base64 PNG>", "format": "png", "width": 1654, "height": 2339,
+   "languages": ["ja", "en"]}
+  -> {"text": "...", "confidence": 0.93,
+      "boxes": [{"text": "...", "confidence": 0.97, "box": [x0, y0, x1, y1]}]}
+
+Errors are reported as {"error": "..."} with exit status 0, so the service can
+tell adapter failures from crashes.
+"""
+
+import base64
+import io
+import json
+import os
+import sys
+
+# PaddleOCR prints progress to stdout; keep the real stdout for the response.
+_out = os.fdopen(os.dup(1), "w")
+os.dup2(2, 1)
+sys.stdout = sys.stderr
+
+# Language codes from the service mapped to PaddleOCR model names.
+PADDLE_LANGUAGES = {"en": "en", "ja": "japan", "ko": "korean", "zh": "ch",
+                    "zh-tw": "chinese_cht", "de": "german", "fr": "french",
+                    "es": "es", "ru": "ru"}
+
+
+def main():
+    request = json.load(sys.stdin)
+    languages = request.get("languages") or ["en"]
+    # PaddleOCR loads one recognition model; the first non-English language wins.
+    lang = next((l for l in languages if l != "en"), languages[0])
+
+    import numpy as np
+    from PIL import Image
+    from paddleocr import PaddleOCR
+
+    image = Image.open(io.BytesIO(base64.b64decode(request["image"]))).convert("RGB")
+    ocr = PaddleOCR(use_angle_cls=False, lang=PADDLE_LANGUAGES.get(lang, "en"), show_log=False)
+    result = ocr.ocr(np.array(image), cls=False)
+
+    lines = result[0] if result and result[0] else []
+    boxes = []
+    for points, (text, confidence) in lines:
+        xs = [int(p[0]) for p in points]
+        ys = [int(p[1]) for p in points]
+        boxes.append({"text": text, "confidence": float(confidence),
+                      "box": [min(xs), min(ys), max(xs), max(ys)]})
+    return {
+        "text": "\n".join(b["text"] for b in boxes),
+        "confidence": sum(b["confidence"] for b in boxes) / len(boxes) if boxes else 0.0,
+        "boxes": boxes,
+    }
+
+
+if __name__ == "__main__":
+    try:
+        response = main()
+    except Exception as e:  # reported to the service instead of a bare exit code
+        response = {"error": str(e)}
+    json.dump(response, _out)
+    _out.flush()
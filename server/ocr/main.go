@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -12,10 +13,10 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	pb "grpc-sample-minimal/proto"
 	"grpc-sample-minimal/server/domain"
+	"grpc-sample-minimal/tlsconfig"
 )
 
 const (
@@ -23,7 +24,10 @@ const (
 )
 
 var (
-	authToken = os.Getenv("AUTH_TOKEN")
+	// authenticator verifies the JWTs of incoming RPCs (see domain.AuthenticatorFromEnv)
+	authenticator *domain.Authenticator
+	// authorizer enforces the access policy (AUTH_POLICY_FILE) like the gateway; nil disables it
+	authorizer *domain.Authorizer
 )
 
 // ocrServer ?OCR?????????gRPC????
@@ -33,6 +37,8 @@ type ocrServer struct {
 	ocrResultRepo    domain.OCRResultRepository
 	fileMetadataRepo domain.FileMetadataRepository
 	getStorageService func(ctx context.Context, provider string) (domain.StorageService, error)
+	fieldTemplates   *domain.FieldTemplateSet
+	piiDetector      *domain.PIIDetector
 }
 
 // ProcessOCR ?OCR???????????
@@ -40,8 +46,13 @@ func (s *ocrServer) ProcessOCR(ctx context.Context, req *pb.OCRRequest) (*pb.OCR
 	// ???ID???
 	taskID := req.Filename + "_" + req.StorageProvider + "_" + fmt.Sprintf("%d", time.Now().Unix())
 	
+	languages, err := domain.ParseOCRLanguages(req.Languages)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	
 	// ????OCR?????
-	go s.processOCRAsync(context.Background(), req.Filename, req.StorageProvider)
+	go s.processOCRAsync(domain.WithOCRLanguages(context.Background(), languages), req.Filename, req.StorageProvider)
 	
 	return &pb.OCRResponse{
 		TaskId:  taskID,
@@ -72,43 +83,7 @@ func (s *ocrServer) processOCRAsync(ctx context.Context, filename string, storag
 	}
 	
 	// ???????????storage_path???
-	var contentReader io.Reader
-	if s.fileMetadataRepo != nil {
-		log.Printf("Looking up file metadata: filename=%s, provider=%s", filename, storageProvider)
-		metadata, err := s.fileMetadataRepo.FindByFilename(ctx, filename, storageProvider)
-		if err != nil {
-			log.Printf("Error finding file metadata: %v, using filename to build path", err)
-			contentReader, err = storageService.DownloadFile(ctx, filename)
-		} else if metadata != nil && metadata.StoragePath != "" {
-			// ???????????????storage_path???
-			log.Printf("Using storage_path from DB: %s", metadata.StoragePath)
-			contentReader, err = storageService.DownloadFileByPath(ctx, metadata.StoragePath)
-		if err != nil {
-			log.Printf("Failed to download file by path: %v, trying with filename", err)
-			// ???????: filename???
-			var fallbackErr error
-			contentReader, fallbackErr = storageService.DownloadFile(ctx, filename)
-			if fallbackErr != nil {
-				log.Printf("Failed to download file with filename fallback: %v", fallbackErr)
-				err = fallbackErr // ????????????
-			} else {
-				log.Printf("Successfully downloaded file with filename fallback")
-				err = nil // ??????????????????
-			}
-		} else {
-			log.Printf("Successfully downloaded file using storage_path from DB")
-		}
-		} else {
-			// ???????????????filename???
-			log.Printf("File metadata not found in DB (metadata=%v), using filename to build path", metadata)
-			contentReader, err = storageService.DownloadFile(ctx, filename)
-		}
-	} else {
-		// fileMetadataRepo??????filename???
-		log.Printf("fileMetadataRepo is nil, using filename to build path")
-		contentReader, err = storageService.DownloadFile(ctx, filename)
-	}
-	
+	contentReader, err := downloadSourceFile(ctx, s.fileMetadataRepo, storageService, filename, storageProvider)
 	if err != nil {
 		log.Printf("Failed to download file: %v", err)
 		s.saveFailedResult(ctx, filename, storageProvider, err)
@@ -121,6 +96,13 @@ func (s *ocrServer) processOCRAsync(ctx context.Context, filename string, storag
 		return
 	}
 	
+	ctx, contentReader, err = classifyDocumentPages(ctx, filename, contentReader)
+	if err != nil {
+		log.Printf("Failed to read file: %v", err)
+		s.saveFailedResult(ctx, filename, storageProvider, err)
+		return
+	}
+	
 	// 2. OCR?????????????????
 	engineNames := getEngineNames()
 	results, err := s.ocrService.ProcessDocument(ctx, filename, contentReader, engineNames)
@@ -155,9 +137,75 @@ func (s *ocrServer) processOCRAsync(ctx context.Context, filename string, storag
 		}
 	}
 	
+	updateEnsembleResult(ctx, s.ocrResultRepo, filename, storageProvider, engineNames)
+	extractDocumentFields(ctx, s.ocrResultRepo, s.fieldTemplates, filename, storageProvider)
+	redactDocumentPII(ctx, s.ocrResultRepo, s.fileMetadataRepo, storageService, s.piiDetector, filename, storageProvider)
+	
 	log.Printf("OCR processing completed for file: %s with %d engine(s)", filename, len(results))
 }
 
+// downloadSourceFile downloads an uploaded file, preferring the storage_path
+// recorded in file metadata and falling back to the path built from the filename.
+func downloadSourceFile(
+	ctx context.Context,
+	fileMetadataRepo domain.FileMetadataRepository,
+	storageService domain.StorageService,
+	filename string,
+	storageProvider string,
+) (io.Reader, error) {
+	if fileMetadataRepo == nil {
+		log.Printf("fileMetadataRepo is nil, using filename to build path")
+		return storageService.DownloadFile(ctx, filename)
+	}
+	
+	log.Printf("Looking up file metadata: filename=%s, provider=%s", filename, storageProvider)
+	metadata, err := fileMetadataRepo.FindByFilename(ctx, filename, storageProvider)
+	if err != nil {
+		log.Printf("Error finding file metadata: %v, using filename to build path", err)
+		return storageService.DownloadFile(ctx, filename)
+	}
+	if metadata == nil || metadata.StoragePath == "" {
+		log.Printf("File metadata not found in DB (metadata=%v), using filename to build path", metadata)
+		return storageService.DownloadFile(ctx, filename)
+	}
+	
+	log.Printf("Using storage_path from DB: %s", metadata.StoragePath)
+	contentReader, err := storageService.DownloadFileByPath(ctx, metadata.StoragePath)
+	if err == nil {
+		log.Printf("Successfully downloaded file using storage_path from DB")
+		return contentReader, nil
+	}
+	log.Printf("Failed to download file by path: %v, trying with filename", err)
+	contentReader, fallbackErr := storageService.DownloadFile(ctx, filename)
+	if fallbackErr != nil {
+		log.Printf("Failed to download file with filename fallback: %v", fallbackErr)
+		return nil, fallbackErr
+	}
+	log.Printf("Successfully downloaded file with filename fallback")
+	return contentReader, nil
+}
+
+// classifyDocumentPages classifies the pages of a document before OCR so that
+// ProcessDocument skips blank pages and routes handwritten ones. The returned
+// reader replaces content, which has been read.
+func classifyDocumentPages(ctx context.Context, filename string, content io.Reader) (context.Context, io.Reader, error) {
+	if !domain.PageClassificationEnabled() {
+		return ctx, content, nil
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	classes, err := domain.ClassifyDocumentPages(ctx, filename, data)
+	if err != nil {
+		log.Printf("Page classification failed for %s, reading all pages: %v", filename, err)
+	} else if len(classes) > 0 {
+		log.Printf("Page classes of %s: %s", filename, domain.SummarizePageClassifications(classes))
+		ctx = domain.WithPageClassifications(ctx, classes)
+	}
+	return ctx, bytes.NewReader(data), nil
+}
+
 // saveFailedResult ?????OCR???????
 func (s *ocrServer) saveFailedResult(ctx context.Context, filename string, storageProvider string, err error) {
 	result := &domain.OCRResult{
@@ -201,6 +249,10 @@ func (s *ocrServer) GetOCRResult(ctx context.Context, req *pb.OCRResultRequest)
 			PageNumber: int32(page.PageNumber),
 			Text:       page.Text,
 			Confidence: page.Confidence,
+			PreprocessSteps: page.PreprocessSteps,
+			EngineAgreement: domain.EngineAgreementToProto(page.EngineAgreement),
+			EngineName: page.EngineName,
+			Classification: page.Classification,
 		}
 	}
 	
@@ -218,9 +270,199 @@ func (s *ocrServer) GetOCRResult(ctx context.Context, req *pb.OCRResultRequest)
 		ErrorMessage: errorMsg,
 		Confidence:   result.Confidence,
 		ProcessedAt: result.ProcessedAt.Unix(),
+		PreprocessProfile: result.PreprocessProfile,
+		DetectedLanguage: result.DetectedLanguage,
+		Languages: result.Languages,
+		EngineAgreement: domain.EngineAgreementToProto(result.EngineAgreement),
+		EngineVersion: result.EngineVersion,
+		Barcodes: domain.BarcodesToProto(result.Barcodes),
 	}, nil
 }
 
+// GetOCRLayout returns the word-level layout of an OCR result as JSON, hOCR or ALTO.
+func (s *ocrServer) GetOCRLayout(ctx context.Context, req *pb.OCRLayoutRequest) (*pb.OCRLayoutResponse, error) {
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract" // ?????
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = domain.LayoutFormatJSON
+	}
+	
+	layout, err := s.ocrResultRepo.GetOCRLayout(ctx, req.Filename, req.StorageProvider, engineName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get OCR layout: %v", err)
+	}
+	if layout == nil {
+		return &pb.OCRLayoutResponse{
+			Filename:   req.Filename,
+			EngineName: engineName,
+			Format:     format,
+			Status:     "not_found",
+		}, nil
+	}
+	
+	content, contentType, err := domain.ExportOCRLayout(layout, format)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	
+	return &pb.OCRLayoutResponse{
+		Filename:    layout.Filename,
+		EngineName:  layout.EngineName,
+		Format:      format,
+		ContentType: contentType,
+		Content:     content,
+		PageCount:   int32(len(layout.Pages)),
+		Status:      "completed",
+	}, nil
+}
+
+// GetExtractedTables returns the tables found in an OCR result's pages as
+// structured cells plus JSON or CSV.
+func (s *ocrServer) GetExtractedTables(ctx context.Context, req *pb.ExtractedTablesRequest) (*pb.ExtractedTablesResponse, error) {
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract"
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = domain.TableFormatJSON
+	}
+	
+	tables, err := s.ocrResultRepo.GetExtractedTables(ctx, req.Filename, req.StorageProvider, engineName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get extracted tables: %v", err)
+	}
+	if tables == nil {
+		return &pb.ExtractedTablesResponse{
+			Filename:   req.Filename,
+			EngineName: engineName,
+			Format:     format,
+			Status:     "not_found",
+		}, nil
+	}
+	
+	tables = domain.FilterTablesByPage(tables, int(req.PageNumber))
+	content, contentType, err := domain.ExportExtractedTables(tables, format)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	
+	return &pb.ExtractedTablesResponse{
+		Filename:    req.Filename,
+		EngineName:  engineName,
+		Tables:      domain.ExtractedTablesToProto(tables),
+		Format:      format,
+		ContentType: contentType,
+		Content:     content,
+		Status:      "completed",
+	}, nil
+}
+
+// ExportSearchablePDF builds a searchable PDF from the original file and the
+// stored OCR layout, caches it under the searchable/ namespace and records it.
+// A cached PDF is reused while the OCR result it was built from is unchanged.
+func (s *ocrServer) ExportSearchablePDF(ctx context.Context, req *pb.SearchablePDFRequest) (*pb.SearchablePDFResponse, error) {
+	if req.Filename == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "filename is required")
+	}
+	engineName := req.EngineName
+	if engineName == "" {
+		engineName = "tesseract"
+	}
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	
+	result, err := s.ocrResultRepo.GetOCRResult(ctx, req.Filename, provider, engineName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get OCR result: %v", err)
+	}
+	if result == nil {
+		return &pb.SearchablePDFResponse{
+			Filename:        req.Filename,
+			StorageProvider: provider,
+			EngineName:      engineName,
+			Status:          "not_found",
+		}, nil
+	}
+	if result.Status != "completed" {
+		return nil, status.Errorf(codes.FailedPrecondition, "OCR result is %s", result.Status)
+	}
+	
+	if !req.Force {
+		cached, err := s.ocrResultRepo.GetDerivedFile(ctx, req.Filename, provider, engineName, domain.DerivedKindSearchablePDF)
+		if err != nil {
+			log.Printf("Failed to look up cached searchable PDF: %v", err)
+		} else if cached != nil && cached.SourceProcessedAt.Equal(result.ProcessedAt) {
+			log.Printf("Using cached searchable PDF: %s", cached.StoragePath)
+			return searchablePDFResponse(cached, true), nil
+		}
+	}
+	
+	layout, err := s.ocrResultRepo.GetOCRLayout(ctx, req.Filename, provider, engineName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get OCR layout: %v", err)
+	}
+	
+	storageService, err := s.getStorageService(ctx, provider)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	content, err := downloadSourceFile(ctx, s.fileMetadataRepo, storageService, req.Filename, provider)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "failed to download source file: %v", err)
+	}
+	
+	pdf, pageCount, err := domain.GenerateSearchablePDF(ctx, req.Filename, content, layout)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to generate searchable PDF: %v", err)
+	}
+	
+	storagePath := domain.SearchablePDFPath(req.Filename, engineName)
+	if err := storageService.UploadFileByPath(ctx, storagePath, bytes.NewReader(pdf)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store searchable PDF: %v", err)
+	}
+	
+	file := &domain.DerivedFile{
+		Filename:          req.Filename,
+		StorageProvider:   provider,
+		EngineName:        engineName,
+		Kind:              domain.DerivedKindSearchablePDF,
+		StoragePath:       storagePath,
+		PageCount:         pageCount,
+		Size:              int64(len(pdf)),
+		SourceProcessedAt: result.ProcessedAt,
+		CreatedAt:         time.Now(),
+	}
+	if err := s.ocrResultRepo.SaveDerivedFile(ctx, file); err != nil {
+		// The PDF is stored; it is just regenerated next time.
+		log.Printf("Failed to record searchable PDF: %v", err)
+	}
+	log.Printf("Searchable PDF generated: %s (%d pages, %d bytes)", storagePath, pageCount, len(pdf))
+	
+	return searchablePDFResponse(file, false), nil
+}
+
+// searchablePDFResponse converts a derived file record to the RPC response.
+func searchablePDFResponse(file *domain.DerivedFile, cached bool) *pb.SearchablePDFResponse {
+	return &pb.SearchablePDFResponse{
+		Filename:         file.Filename,
+		StorageProvider:  file.StorageProvider,
+		EngineName:       file.EngineName,
+		StoragePath:      file.StoragePath,
+		DownloadFilename: domain.SearchablePDFFilename(file.Filename),
+		PageCount:        int32(file.PageCount),
+		Size:             file.Size,
+		Cached:           cached,
+		Status:           "completed",
+		GeneratedAt:      file.CreatedAt.Unix(),
+	}
+}
+
 // ListOCRResults ?OCR?????????
 func (s *ocrServer) ListOCRResults(ctx context.Context, req *pb.OCRListRequest) (*pb.OCRListResponse, error) {
 	results, err := s.ocrResultRepo.ListOCRResults(ctx, req.StorageProvider)
@@ -228,14 +470,17 @@ func (s *ocrServer) ListOCRResults(ctx context.Context, req *pb.OCRListRequest)
 		return nil, status.Errorf(codes.Internal, "failed to list OCR results: %v", err)
 	}
 	
-	summaries := make([]*pb.OCRResultSummary, len(results))
-	for i, result := range results {
-		summaries[i] = &pb.OCRResultSummary{
+	summaries := make([]*pb.OCRResultSummary, 0, len(results))
+	for _, result := range results {
+		if !domain.VisibleToCaller(ctx, result.Filename) {
+			continue
+		}
+		summaries = append(summaries, &pb.OCRResultSummary{
 			Filename:    result.Filename,
 			EngineName:  result.EngineName,
 			Status:      result.Status,
 			ProcessedAt: result.ProcessedAt.Unix(),
-		}
+		})
 	}
 	
 	return &pb.OCRListResponse{
@@ -258,6 +503,10 @@ func (s *ocrServer) CompareOCRResults(ctx context.Context, req *pb.OCRComparison
 				PageNumber: int32(page.PageNumber),
 				Text:       page.Text,
 				Confidence: page.Confidence,
+				PreprocessSteps: page.PreprocessSteps,
+				EngineAgreement: domain.EngineAgreementToProto(page.EngineAgreement),
+				EngineName: page.EngineName,
+				Classification: page.Classification,
 			}
 		}
 		
@@ -275,6 +524,12 @@ func (s *ocrServer) CompareOCRResults(ctx context.Context, req *pb.OCRComparison
 			ErrorMessage: errorMsg,
 			Confidence:   result.Confidence,
 			ProcessedAt:  result.ProcessedAt.Unix(),
+			PreprocessProfile: result.PreprocessProfile,
+			DetectedLanguage: result.DetectedLanguage,
+			Languages: result.Languages,
+			EngineAgreement: domain.EngineAgreementToProto(result.EngineAgreement),
+			EngineVersion: result.EngineVersion,
+			Barcodes: domain.BarcodesToProto(result.Barcodes),
 		}
 	}
 	
@@ -285,6 +540,99 @@ func (s *ocrServer) CompareOCRResults(ctx context.Context, req *pb.OCRComparison
 	}, nil
 }
 
+// SetGroundTruth registers the reference text of a file for EvaluateOCR.
+func (s *ocrServer) SetGroundTruth(ctx context.Context, req *pb.GroundTruthRequest) (*pb.GroundTruthResponse, error) {
+	if req.Filename == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "filename is required")
+	}
+	if req.StorageProvider == "" {
+		req.StorageProvider = "s3"
+	}
+	
+	gt := domain.GroundTruthFromProto(req)
+	if err := s.ocrResultRepo.SaveGroundTruth(ctx, gt); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save ground truth: %v", err)
+	}
+	message := "ground truth saved for the whole document"
+	if len(gt.Pages) > 0 {
+		message = fmt.Sprintf("ground truth saved for %d pages", len(gt.Pages))
+	}
+	return &pb.GroundTruthResponse{Success: true, Message: message}, nil
+}
+
+// EvaluateOCR computes CER/WER of the stored OCR results against ground truth
+// and stores the aggregates per engine version.
+func (s *ocrServer) EvaluateOCR(ctx context.Context, req *pb.EvaluateOCRRequest) (*pb.EvaluateOCRResponse, error) {
+	resp, err := domain.EvaluateOCRFromProto(ctx, s.ocrResultRepo, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to evaluate OCR results: %v", err)
+	}
+	return resp, nil
+}
+
+// GetExtractedFields returns the key-value fields read from a file by its
+// document type's template.
+func (s *ocrServer) GetExtractedFields(ctx context.Context, req *pb.ExtractedFieldsRequest) (*pb.ExtractedFieldsResponse, error) {
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	fields, err := s.ocrResultRepo.GetExtractedFields(ctx, req.Filename, provider)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get extracted fields: %v", err)
+	}
+	if fields == nil {
+		return &pb.ExtractedFieldsResponse{Status: "not_found"}, nil
+	}
+	return &pb.ExtractedFieldsResponse{
+		Fields: domain.ExtractedFieldsToProto(fields),
+		Status: "completed",
+	}, nil
+}
+
+// SearchDocuments returns the files whose extracted fields match the filters,
+// with value counts of the requested facet fields.
+func (s *ocrServer) SearchDocuments(ctx context.Context, req *pb.SearchDocumentsRequest) (*pb.SearchDocumentsResponse, error) {
+	query := domain.DocumentSearchQueryFromProto(req)
+	query.Tenant = domain.CallerTenant(ctx)
+	// Totals and facets only count files in the caller's namespaces
+	if namespaces, scoped := domain.NamespaceScope(ctx); scoped {
+		if len(namespaces) == 0 {
+			return &pb.SearchDocumentsResponse{}, nil
+		}
+		query.Namespaces = namespaces
+	}
+	result, err := s.ocrResultRepo.SearchExtractedFields(ctx, query)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to search documents: %v", err)
+	}
+	return domain.DocumentSearchResultToProto(result), nil
+}
+
+// GetPIIFindings returns the personal information found in a file's OCR
+// text and where its redacted copy is.
+func (s *ocrServer) GetPIIFindings(ctx context.Context, req *pb.PIIFindingsRequest) (*pb.PIIFindingsResponse, error) {
+	if req.Filename == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "filename is required")
+	}
+	provider := req.StorageProvider
+	if provider == "" {
+		provider = "s3"
+	}
+	report, err := s.ocrResultRepo.GetPIIReport(ctx, req.Filename, provider)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get PII findings: %v", err)
+	}
+	if report == nil {
+		return &pb.PIIFindingsResponse{Filename: req.Filename, StorageProvider: provider, Status: "not_found"}, nil
+	}
+	redacted, err := s.ocrResultRepo.GetDerivedFile(ctx, req.Filename, provider, domain.RedactedEngineName, domain.DerivedKindRedacted)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get redacted copy: %v", err)
+	}
+	return domain.PIIReportToProto(report, redacted), nil
+}
+
 // getEngineNames ??????OCR????????????????: tesseract?
 func getEngineNames() []string {
 	enginesEnv := os.Getenv("OCR_ENGINES")
@@ -319,28 +667,208 @@ func containsString(slice []string, str string) bool {
 
 // authInterceptor ???????????
 func authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	ctx, principal, err := authenticator.Authenticate(ctx)
+	if err != nil {
+		log.Printf("OCR Service: Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := domain.CheckTenantScope(principal); err != nil {
+		log.Printf("OCR Service: Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := domain.CheckAPIKeyScope(principal, info.FullMethod); err != nil {
+		log.Printf("OCR Service: Auth failed for method %s: %v", info.FullMethod, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
 	}
 
-	values := md["authorization"]
-	if len(values) == 0 || values[0] != authToken {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token")
+	log.Printf("OCR Service: Auth successful for method: %s (sub=%s, tenant=%s)", info.FullMethod, principal.Subject, principal.Tenant)
+	return handler(ctx, req)
+}
+
+// authStreamInterceptor verifies the caller's JWT for streaming RPCs.
+func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, principal, err := authenticator.Authenticate(ss.Context())
+	if err != nil {
+		log.Printf("OCR Service: Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := domain.CheckTenantScope(principal); err != nil {
+		log.Printf("OCR Service: Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := domain.CheckAPIKeyScope(principal, info.FullMethod); err != nil {
+		log.Printf("OCR Service: Auth failed for stream method %s: %v", info.FullMethod, err)
+		return status.Error(codes.PermissionDenied, err.Error())
 	}
 
-	log.Printf("OCR Service: Auth successful for method: %s", info.FullMethod)
+	log.Printf("OCR Service: Auth successful for stream method: %s (sub=%s, tenant=%s)", info.FullMethod, principal.Subject, principal.Tenant)
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// tenantScopeInterceptor keeps tenant callers to their own files. The gateway
+// sends file keys (see domain.TenantFileKey), which must belong to the
+// caller's tenant.
+func tenantScopeInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := checkTenantScope(ctx, info.FullMethod, req); err != nil {
+		return nil, err
+	}
 	return handler(ctx, req)
 }
 
+// tenantScopeStreamInterceptor applies the checks of tenantScopeInterceptor
+// to every message a stream receives.
+func tenantScopeStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &tenantScopedStream{ServerStream: ss, method: info.FullMethod})
+}
+
+// tenantScopedStream checks the files named by each received message.
+type tenantScopedStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *tenantScopedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return checkTenantScope(s.Context(), s.method, m)
+}
+
+// checkTenantScope returns PermissionDenied when msg names a file outside
+// the caller's tenant.
+func checkTenantScope(ctx context.Context, method string, msg interface{}) error {
+	var filenames []string
+	if r, ok := msg.(interface{ GetFilename() string }); ok && r.GetFilename() != "" {
+		filenames = append(filenames, r.GetFilename())
+	}
+	if r, ok := msg.(interface{ GetFilenames() []string }); ok {
+		filenames = append(filenames, r.GetFilenames()...)
+	}
+	for _, filename := range filenames {
+		if !domain.VisibleToCaller(ctx, filename) {
+			log.Printf("OCR Service: %s denied: %s is not a file of tenant %s", method, filename, domain.CallerTenant(ctx))
+			return status.Errorf(codes.PermissionDenied, "%s is not a file of tenant %s", filename, domain.CallerTenant(ctx))
+		}
+	}
+	return nil
+}
+
+// authzInterceptor checks the caller's roles against the access policy and
+// drops files outside the caller's namespaces from listings, as the gateway
+// does for its RPCs.
+func authzInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if authorizer == nil {
+		return handler(ctx, req)
+	}
+	principal := domain.PrincipalFromContext(ctx)
+	accessReq := authorizer.AccessRequestFor(ctx, info.FullMethod, req, "")
+	if err := authorizer.Authorize(principal, accessReq); err != nil {
+		log.Printf("OCR Service: %s denied: %v", info.FullMethod, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if r, ok := req.(interface{ GetFilenames() []string }); ok {
+		if err := authorizer.AuthorizeFilenames(ctx, principal, accessReq, r.GetFilenames()); err != nil {
+			log.Printf("OCR Service: %s denied: %v", info.FullMethod, err)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	resp, err := handler(authorizer.ScopeNamespaces(ctx, principal, accessReq), req)
+	if err == nil {
+		authorizer.FilterResponse(ctx, principal, accessReq, resp)
+	}
+	return resp, err
+}
+
+// authzStreamInterceptor checks the caller's roles for the method, then the
+// file and provider of each received message.
+func authzStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if authorizer == nil {
+		return handler(srv, ss)
+	}
+	principal := domain.PrincipalFromContext(ss.Context())
+	if err := authorizer.Authorize(principal, domain.AccessRequest{Method: info.FullMethod}); err != nil {
+		log.Printf("OCR Service: %s denied: %v", info.FullMethod, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, principal: principal, method: info.FullMethod})
+}
+
+// authorizedStream checks every received message that names a file or a
+// storage provider.
+type authorizedStream struct {
+	grpc.ServerStream
+	principal *domain.Principal
+	method    string
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	filename, _ := domain.RequestFile(m, "")
+	if _, hasProvider := m.(interface{ GetStorageProvider() string }); filename == "" && !hasProvider {
+		return nil
+	}
+	if err := authorizer.Authorize(s.principal, authorizer.AccessRequestFor(s.Context(), s.method, m, "")); err != nil {
+		log.Printf("OCR Service: %s denied: %v", s.method, err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// authenticatedStream is a server stream whose context carries the principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
 func main() {
-	// OCR????????
-	ocrService := domain.NewOCRService()
-	
-	// Tesseract???????
-	tesseractEngine := domain.NewTesseractEngine("jpn+eng")
-	ocrService.RegisterEngine(tesseractEngine)
-	
+	if len(os.Args) > 1 && os.Args[1] == "evaluate" {
+		os.Exit(runEvaluate(os.Args[2:]))
+	}
+
+	var err error
+	authenticator, err = domain.AuthenticatorFromEnv()
+	if err != nil {
+		log.Fatalf("authentication is not configured: %v", err)
+	}
+	authorizer, err = domain.AuthorizerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid AUTH_POLICY_FILE: %v", err)
+	}
+	if authorizer == nil {
+		log.Printf("AUTH_POLICY_FILE is not set: every authenticated caller may use every RPC")
+	}
+
+	ocrService, closeEngines, err := newOCRService()
+	if err != nil {
+		log.Fatalf("failed to set up OCR engines: %v", err)
+	}
+	defer closeEngines()
+
+	// OCR_FIELD_TEMPLATES: key-value extraction per document type
+	fieldTemplates, err := domain.FieldTemplatesFromEnv()
+	if err != nil {
+		log.Fatalf("invalid OCR_FIELD_TEMPLATES: %v", err)
+	}
+	if fieldTemplates != nil {
+		log.Printf("Loaded %d field extraction template(s)", len(fieldTemplates.Templates))
+	}
+
+	// OCR_PII_DETECTION / OCR_PII_RULES: personal information in OCR text
+	piiDetector, err := domain.PIIDetectorFromEnv()
+	if err != nil {
+		log.Fatalf("invalid OCR_PII_RULES: %v", err)
+	}
+	if piiDetector != nil {
+		log.Printf("PII detection enabled (rules: %s)", strings.Join(piiDetector.Rules(), ", "))
+	}
+
 	// OCR???????????
 	ocrResultRepo, err := domain.NewOCRResultRepository(context.Background())
 	if err != nil {
@@ -365,8 +893,14 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 	
+	creds, err := tlsconfig.ServerOption("ocr-service")
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
 	s := grpc.NewServer(
-		grpc.UnaryInterceptor(authInterceptor),
+		creds,
+		grpc.ChainUnaryInterceptor(authInterceptor, tenantScopeInterceptor, authzInterceptor),
+		grpc.ChainStreamInterceptor(authStreamInterceptor, tenantScopeStreamInterceptor, authzStreamInterceptor),
 	)
 	
 	// ????????????????
@@ -389,6 +923,10 @@ func main() {
 		log.Printf("Warning: Failed to create file metadata repository: %v", err)
 		fileMetadataRepo = nil // nil?????????????
 	}
+	if authorizer != nil {
+		// Files are authorized by the namespace they were stored under
+		authorizer.SetFileMetadata(fileMetadataRepo)
+	}
 	defer func() {
 		if closer, ok := fileMetadataRepo.(interface{ Close() error }); ok && closer != nil {
 			if err := closer.Close(); err != nil {
@@ -402,13 +940,15 @@ func main() {
 		ocrResultRepo:    ocrResultRepo,
 		fileMetadataRepo: fileMetadataRepo,
 		getStorageService: getStorageService,
+		fieldTemplates:   fieldTemplates,
+		piiDetector:      piiDetector,
 	}
 	
 	// ????????????????????????????????????????????
 	ctx := context.Background()
-	go startOCRWorker(ctx, "azure", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService)
-	go startOCRWorker(ctx, "s3", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService)
-	go startOCRWorker(ctx, "gcs", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService)
+	go startOCRWorker(ctx, "azure", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService, fieldTemplates, piiDetector)
+	go startOCRWorker(ctx, "s3", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService, fieldTemplates, piiDetector)
+	go startOCRWorker(ctx, "gcs", ocrService, ocrResultRepo, fileMetadataRepo, getStorageService, fieldTemplates, piiDetector)
 	
 	log.Printf("OCR workers started for all storage providers")
 	
@@ -422,6 +962,89 @@ func main() {
 	}
 }
 
+// newOCRService registers the built-in engines and those declared in
+// OCR_EXTERNAL_ENGINES_CONFIG. The returned function stops engine workers.
+func newOCRService() (domain.OCRService, func(), error) {
+	// OCR_CASCADE: cheap engine first, low-confidence pages to slower engines
+	cascade, err := domain.CascadeStrategyFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid OCR_CASCADE: %w", err)
+	}
+	
+	// OCR????????
+	ocrService := domain.NewOCRService()
+	var closers []io.Closer
+	closeEngines := func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}
+	
+	// Tesseract???????
+	tesseractEngine := domain.NewTesseractEngine("jpn+eng")
+	ocrService.RegisterEngine(tesseractEngine)
+	
+	// EasyOCR runs in a pool of long-lived Python workers started on first use
+	if containsString(getEngineNames(), "easyocr") || cascadeUses(cascade, "easyocr") || os.Getenv("EASYOCR_ENABLED") == "true" {
+		easyOCREngine := domain.NewEasyOCREngine(nil)
+		ocrService.RegisterEngine(easyOCREngine)
+		if closer, ok := easyOCREngine.(io.Closer); ok {
+			closers = append(closers, closer)
+		}
+	}
+
+	// Engines declared in OCR_EXTERNAL_ENGINES_CONFIG (command or HTTP adapters)
+	externalEngines, err := domain.ExternalEnginesFromEnv()
+	if err != nil {
+		closeEngines()
+		return nil, nil, fmt.Errorf("failed to load external OCR engines: %w", err)
+	}
+	for _, engine := range externalEngines {
+		if ocrService.GetEngine(engine.Name()) != nil {
+			log.Printf("External OCR engine %s replaces the built-in engine of the same name", engine.Name())
+		}
+		ocrService.RegisterEngine(engine)
+		log.Printf("Registered external OCR engine: %s", engine.Name())
+	}
+
+	if cascade != nil {
+		for _, stage := range cascade.Stages {
+			if ocrService.GetEngine(stage.EngineName) == nil {
+				log.Printf("Warning: cascade stage %s is not a registered OCR engine", stage.EngineName)
+			}
+		}
+		ocrService.SetCascadeStrategy(cascade)
+		log.Printf("OCR cascade strategy: %s", cascade)
+	}
+	if name := domain.HandwritingEngineFromEnv(); name != "" {
+		if ocrService.GetEngine(name) == nil {
+			log.Printf("Warning: handwriting engine %s is not registered here, handwritten pages are read by every engine", name)
+		} else {
+			ocrService.SetHandwritingEngine(name)
+			log.Printf("Handwritten pages are routed to %s", name)
+		}
+	}
+	if domain.BarcodeDetectionEnabled() {
+		detector := domain.NewBarcodeDetector()
+		ocrService.SetBarcodeDetector(detector)
+		log.Printf("Barcode detection enabled (%s detector)", detector.Name())
+	}
+	return ocrService, closeEngines, nil
+}
+
+// cascadeUses reports whether a cascade strategy has a stage for engineName.
+func cascadeUses(cascade *domain.CascadeStrategy, engineName string) bool {
+	if cascade == nil {
+		return false
+	}
+	for _, stage := range cascade.Stages {
+		if stage.EngineName == engineName {
+			return true
+		}
+	}
+	return false
+}
+
 // startOCRWorker ??????OCR???????????????????
 func startOCRWorker(
 	ctx context.Context,
@@ -430,6 +1053,8 @@ func startOCRWorker(
 	ocrResultRepo domain.OCRResultRepository,
 	fileMetadataRepo domain.FileMetadataRepository,
 	getStorageService func(ctx context.Context, provider string) (domain.StorageService, error),
+	fieldTemplates *domain.FieldTemplateSet,
+	piiDetector *domain.PIIDetector,
 ) {
 	queueManager := domain.GetQueueManager()
 	if !queueManager.IsEnabled() {
@@ -476,7 +1101,16 @@ func startOCRWorker(
 			}
 		}
 
-		log.Printf("Processing OCR task: file=%s, provider=%s", task.Filename, task.StorageProvider)
+		log.Printf("Processing OCR task: file=%s, provider=%s, tenant=%s", task.Filename, task.StorageProvider, task.Tenant)
+
+		// A task whose tenant does not match its file key is never processed
+		if err := task.Validate(); err != nil {
+			log.Printf("Rejecting OCR task: %v", err)
+			if store, storeErr := domain.GetOrCreateQueueTaskStore(ctx); storeErr == nil {
+				store.LogFailed(ctx, task.Filename, task.StorageProvider, err)
+			}
+			continue
+		}
 
 		// OCR??????defer + recover?panic????
 		func() {
@@ -487,7 +1121,7 @@ func startOCRWorker(
 					saveFailedResult(ctx, task.Filename, task.StorageProvider, ocrResultRepo, err)
 				}
 			}()
-			processOCRTask(ctx, task.Filename, task.StorageProvider, ocrService, ocrResultRepo, fileMetadataRepo, getStorageService)
+			processOCRTask(ctx, task.Filename, task.StorageProvider, ocrService, ocrResultRepo, fileMetadataRepo, getStorageService, fieldTemplates, piiDetector)
 		}()
 	}
 }
@@ -501,6 +1135,8 @@ func processOCRTask(
 	ocrResultRepo domain.OCRResultRepository,
 	fileMetadataRepo domain.FileMetadataRepository,
 	getStorageService func(ctx context.Context, provider string) (domain.StorageService, error),
+	fieldTemplates *domain.FieldTemplateSet,
+	piiDetector *domain.PIIDetector,
 ) {
 	log.Printf("Starting OCR processing for file: %s (provider: %s)", filename, storageProvider)
 	
@@ -513,45 +1149,16 @@ func processOCRTask(
 	}
 	
 	// ???????????storage_path???
-	var contentReader io.Reader
-	if fileMetadataRepo != nil {
-		log.Printf("Looking up file metadata: filename=%s, provider=%s", filename, storageProvider)
-		metadata, err := fileMetadataRepo.FindByFilename(ctx, filename, storageProvider)
-		if err != nil {
-			log.Printf("Error finding file metadata: %v, using filename to build path", err)
-			contentReader, err = storageService.DownloadFile(ctx, filename)
-		} else if metadata != nil && metadata.StoragePath != "" {
-			// ???????????????storage_path???
-			log.Printf("Using storage_path from DB: %s", metadata.StoragePath)
-			contentReader, err = storageService.DownloadFileByPath(ctx, metadata.StoragePath)
-		if err != nil {
-			log.Printf("Failed to download file by path: %v, trying with filename", err)
-			// ???????: filename???
-			var fallbackErr error
-			contentReader, fallbackErr = storageService.DownloadFile(ctx, filename)
-			if fallbackErr != nil {
-				log.Printf("Failed to download file with filename fallback: %v", fallbackErr)
-				err = fallbackErr // ????????????
-			} else {
-				log.Printf("Successfully downloaded file with filename fallback")
-				err = nil // ??????????????????
-			}
-		} else {
-			log.Printf("Successfully downloaded file using storage_path from DB")
-		}
-		} else {
-			// ???????????????filename???
-			log.Printf("File metadata not found in DB (metadata=%v), using filename to build path", metadata)
-			contentReader, err = storageService.DownloadFile(ctx, filename)
-		}
-	} else {
-		// fileMetadataRepo??????filename???
-		log.Printf("fileMetadataRepo is nil, using filename to build path")
-		contentReader, err = storageService.DownloadFile(ctx, filename)
+	contentReader, err := downloadSourceFile(ctx, fileMetadataRepo, storageService, filename, storageProvider)
+	if err != nil {
+		log.Printf("Failed to download file: %v", err)
+		saveFailedResult(ctx, filename, storageProvider, ocrResultRepo, err)
+		return
 	}
 	
+	ctx, contentReader, err = classifyDocumentPages(ctx, filename, contentReader)
 	if err != nil {
-		log.Printf("Failed to download file: %v", err)
+		log.Printf("Failed to read file: %v", err)
 		saveFailedResult(ctx, filename, storageProvider, ocrResultRepo, err)
 		return
 	}
@@ -595,9 +1202,107 @@ func processOCRTask(
 		}
 	}
 	
+	updateEnsembleResult(ctx, ocrResultRepo, filename, storageProvider, engineNames)
+	extractDocumentFields(ctx, ocrResultRepo, fieldTemplates, filename, storageProvider)
+	redactDocumentPII(ctx, ocrResultRepo, fileMetadataRepo, storageService, piiDetector, filename, storageProvider)
+	
 	log.Printf("OCR processing completed for file: %s - %d/%d engines succeeded", filename, successCount, len(results))
 }
 
+// updateEnsembleResult re-votes the "ensemble" result when it is among the
+// configured engines. The stored results of every OCR service are combined, so
+// the ensemble is complete once the last engine has saved its result.
+func updateEnsembleResult(ctx context.Context, ocrResultRepo domain.OCRResultRepository, filename string, storageProvider string, engineNames []string) {
+	if !containsString(engineNames, domain.EnsembleEngineName) {
+		return
+	}
+	result, err := domain.UpdateEnsembleResult(ctx, ocrResultRepo, filename, storageProvider)
+	if err != nil {
+		log.Printf("Failed to update ensemble result for %s: %v", filename, err)
+		return
+	}
+	if result != nil {
+		log.Printf("Ensemble result saved for %s: confidence=%.2f", filename, result.Confidence)
+	}
+}
+
+// extractDocumentFields reads the key-value fields of a file with the
+// template of its document type, once the OCR results are stored.
+func extractDocumentFields(ctx context.Context, ocrResultRepo domain.OCRResultRepository, templates *domain.FieldTemplateSet, filename string, storageProvider string) {
+	if templates == nil {
+		return
+	}
+	fields, err := domain.ExtractDocumentFields(ctx, ocrResultRepo, templates, filename, storageProvider)
+	if err != nil {
+		log.Printf("Failed to extract fields from %s: %v", filename, err)
+		return
+	}
+	if fields != nil {
+		log.Printf("Extracted %d field(s) from %s as %s (valid: %t)", len(fields.Fields), filename, fields.DocumentType, fields.Valid())
+	}
+}
+
+// redactDocumentPII scans the stored OCR text of a file for personal
+// information, stores the findings and the redacted text, and for PDFs and
+// images stores a redacted copy under the redacted/ namespace.
+func redactDocumentPII(
+	ctx context.Context,
+	ocrResultRepo domain.OCRResultRepository,
+	fileMetadataRepo domain.FileMetadataRepository,
+	storageService domain.StorageService,
+	detector *domain.PIIDetector,
+	filename string,
+	storageProvider string,
+) {
+	if detector == nil {
+		return
+	}
+	report, source, err := domain.RedactDocumentPII(ctx, ocrResultRepo, detector, filename, storageProvider)
+	if err != nil {
+		log.Printf("Failed to detect PII in %s: %v", filename, err)
+		return
+	}
+	if report == nil {
+		return
+	}
+	log.Printf("PII scan of %s (%s): %d finding(s)", filename, report.EngineName, len(report.Findings))
+	if !domain.CanRedactImages(filename) {
+		return
+	}
+	
+	content, err := downloadSourceFile(ctx, fileMetadataRepo, storageService, filename, storageProvider)
+	if err != nil {
+		log.Printf("Failed to download %s for redaction: %v", filename, err)
+		return
+	}
+	data, pageCount, err := domain.GenerateRedactedCopy(ctx, filename, content, source, report.Findings)
+	if err != nil {
+		log.Printf("Failed to redact %s: %v", filename, err)
+		return
+	}
+	storagePath := domain.RedactedPath(filename)
+	if err := storageService.UploadFileByPath(ctx, storagePath, bytes.NewReader(data)); err != nil {
+		log.Printf("Failed to store redacted copy of %s: %v", filename, err)
+		return
+	}
+	file := &domain.DerivedFile{
+		Filename:          filename,
+		StorageProvider:   storageProvider,
+		EngineName:        domain.RedactedEngineName,
+		Kind:              domain.DerivedKindRedacted,
+		StoragePath:       storagePath,
+		PageCount:         pageCount,
+		Size:              int64(len(data)),
+		SourceProcessedAt: source.ProcessedAt,
+		CreatedAt:         time.Now(),
+	}
+	if err := ocrResultRepo.SaveDerivedFile(ctx, file); err != nil {
+		log.Printf("Failed to record redacted copy of %s: %v", filename, err)
+		return
+	}
+	log.Printf("Redacted copy stored: %s (%d pages, %d bytes)", storagePath, pageCount, len(data))
+}
+
 // saveFailedResult ?????OCR???????
 // ?????????????????????????????????????
 func saveFailedResult(ctx context.Context, filename string, storageProvider string, ocrResultRepo domain.OCRResultRepository, err error) {
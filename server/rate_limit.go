@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-sample-minimal/server/domain"
+)
+
+// rateLimitInterceptor is a unary interceptor that rejects calls over the
+// caller's rate limit with ResourceExhausted. It runs after authentication,
+// so buckets belong to principals rather than connections.
+func rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := checkRateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// rateLimitStreamInterceptor is a stream interceptor that rejects streams
+// over the caller's rate limit. A stream takes one token, however many
+// messages it carries.
+func rateLimitStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := checkRateLimit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// checkRateLimit takes a token for the call, telling rejected callers when to
+// retry in the retry-after header (in seconds).
+func checkRateLimit(ctx context.Context, method string) error {
+	if rateLimiter == nil {
+		return nil
+	}
+	wait, err := rateLimiter.Allow(domain.PrincipalFromContext(ctx), method)
+	if err == nil {
+		return nil
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+	return status.Error(codes.ResourceExhausted, err.Error())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"grpc-sample-minimal/server/domain"
+)
+
+// runRotateStorageKey implements the rotate-storage-key subcommand: it adds a
+// new master key to the local keyfile and makes it active. Running services
+// pick it up within seconds; files keep their data keys, wrapped with the
+// previous master key, until -rewrap re-encrypts them under the new one.
+func runRotateStorageKey(args []string) int {
+	fs := flag.NewFlagSet("rotate-storage-key", flag.ContinueOnError)
+	rewrap := fs.Bool("rewrap", false, "re-encrypt all stored files under the new key")
+	rewrapOnly := fs.Bool("rewrap-only", false, "re-wrap with the active key without adding a new one")
+	providers := fs.String("providers", "s3,gcs,azure", "comma-separated storage providers to re-wrap")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rotate-storage-key [flags]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return 2
+	}
+	if p := os.Getenv("STORAGE_KEY_PROVIDER"); p != "" && p != "local" {
+		log.Printf("rotate-storage-key: only the local key provider can be rotated here (STORAGE_KEY_PROVIDER=%s)", p)
+		return 1
+	}
+
+	if !*rewrapOnly {
+		id, err := domain.RotateLocalKey(domain.StorageKeyfile())
+		if err != nil {
+			log.Printf("rotate-storage-key: %v", err)
+			return 1
+		}
+		fmt.Printf("active storage key is now %s\n", id)
+	}
+	if !*rewrap && !*rewrapOnly {
+		return 0
+	}
+
+	ctx := context.Background()
+	if keys, err := domain.StorageKeyProvider(); err != nil || keys == nil {
+		log.Printf("rotate-storage-key: storage encryption is not enabled (STORAGE_ENCRYPTION): %v", err)
+		return 1
+	}
+	fileRepo, err := domain.NewFileMetadataRepository(ctx)
+	if err != nil {
+		log.Printf("rotate-storage-key: %v", err)
+		return 1
+	}
+	failed := false
+	for _, provider := range strings.Split(*providers, ",") {
+		provider = strings.TrimSpace(provider)
+		var storage domain.StorageService
+		switch provider {
+		case "s3":
+			storage, err = domain.NewS3StorageService()
+		case "gcs":
+			storage, err = domain.NewGCSStorageService(ctx)
+		case "azure":
+			storage, err = domain.NewAzureStorageService(ctx)
+		default:
+			log.Printf("rotate-storage-key: unknown provider %q", provider)
+			return 2
+		}
+		if err != nil {
+			log.Printf("rotate-storage-key: skipping %s: %v", provider, err)
+			failed = true
+			continue
+		}
+		rewrapper, ok := storage.(domain.KeyRewrapper)
+		if !ok {
+			continue
+		}
+		paths, err := fileRepo.ListStoragePaths(ctx, provider)
+		if err != nil {
+			log.Printf("rotate-storage-key: %v", err)
+			return 1
+		}
+		rewrapped := 0
+		for _, path := range paths {
+			changed, err := rewrapper.RewrapFile(ctx, path)
+			if err != nil {
+				log.Printf("rotate-storage-key: %s %s: %v", provider, path, err)
+				failed = true
+				continue
+			}
+			if changed {
+				rewrapped++
+			}
+		}
+		fmt.Printf("%s: re-wrapped %d of %d files\n", provider, rewrapped, len(paths))
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
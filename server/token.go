@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"grpc-sample-minimal/server/domain"
+)
+
+// runToken implements the token subcommand: it prints an HS256 JWT signed
+// with AUTH_JWT_HMAC_SECRET, for development clients and the service token
+// (AUTH_TOKEN) of the gateway and the web app.
+func runToken(args []string) int {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	subject := fs.String("sub", "", "subject (user or service name)")
+	tenant := fs.String("tenant", "", "tenant of the subject")
+	roles := fs.String("roles", "", "comma-separated roles")
+	ttl := fs.Duration("ttl", time.Hour, "lifetime of the token")
+	issuer := fs.String("iss", os.Getenv("AUTH_JWT_ISSUER"), "issuer (default: AUTH_JWT_ISSUER)")
+	audience := fs.String("aud", os.Getenv("AUTH_JWT_AUDIENCE"), "audience (default: AUTH_JWT_AUDIENCE)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s token -sub <subject> [flags]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *subject == "" || *ttl <= 0 {
+		fs.Usage()
+		return 2
+	}
+	secret := os.Getenv("AUTH_JWT_HMAC_SECRET")
+	if path := os.Getenv("AUTH_JWT_HMAC_SECRET_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("token: %v", err)
+			return 1
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		log.Printf("token: AUTH_JWT_HMAC_SECRET is not set")
+		return 1
+	}
+
+	// Claim names follow the servers' AUTH_JWT_TENANT_CLAIM and AUTH_JWT_ROLES_CLAIM
+	tenantClaim, rolesClaim := domain.ClaimNamesFromEnv()
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub": *subject,
+		"iat": now.Unix(),
+		"exp": now.Add(*ttl).Unix(),
+	}
+	if *tenant != "" {
+		claims[tenantClaim] = *tenant
+	}
+	if *roles != "" {
+		var list []string
+		for _, r := range strings.Split(*roles, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				list = append(list, r)
+			}
+		}
+		claims[rolesClaim] = list
+	}
+	if *issuer != "" {
+		claims["iss"] = *issuer
+	}
+	if *audience != "" {
+		claims["aud"] = *audience
+	}
+	token, err := domain.SignHS256([]byte(secret), claims)
+	if err != nil {
+		log.Printf("token: %v", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
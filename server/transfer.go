@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"grpc-sample-minimal/server/application"
+	"grpc-sample-minimal/server/domain"
+	"grpc-sample-minimal/tlsconfig"
+)
+
+// transferHandler serves the URLs domain.SignedURLPolicy signs for storages
+// that cannot sign their own: PUT stores the request body at the signed
+// path and GET streams the object back. Transfers go through the gateway's
+// StorageService, so files are encrypted at rest like any other upload.
+type transferHandler struct {
+	policy  *domain.SignedURLPolicy
+	storage func(ctx context.Context, provider string) (domain.StorageService, error)
+}
+
+func (h *transferHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Browsers upload from the web app's origin
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if allowed := h.policy.AllowOrigin(origin); allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+			w.Header().Add("Vary", "Origin")
+		}
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut, http.MethodGet, http.MethodHead:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	grant, err := h.policy.VerifyTransfer(r.Method, r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	storage, err := h.storage(r.Context(), grant.Provider)
+	if err != nil {
+		log.Printf("Transfer of %s: %v", grant.StoragePath, err)
+		http.Error(w, "storage is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if grant.Upload {
+		if r.ContentLength > grant.MaxSize {
+			http.Error(w, fmt.Sprintf("upload is larger than the declared %d bytes", grant.MaxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, grant.MaxSize)
+		if err := storage.UploadFileByPath(r.Context(), grant.StoragePath, body); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("upload is larger than the declared %d bytes", grant.MaxSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Printf("Transfer upload to %s failed: %v", grant.StoragePath, err)
+			http.Error(w, "upload failed", http.StatusBadGateway)
+			return
+		}
+		log.Printf("Transfer upload to %s (%s) completed", grant.StoragePath, grant.Provider)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	reader, err := storage.DownloadFileByPath(r.Context(), grant.StoragePath)
+	if err != nil {
+		log.Printf("Transfer download of %s failed: %v", grant.StoragePath, err)
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(grant.DownloadName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": grant.DownloadName}))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Transfer download of %s failed: %v", grant.StoragePath, err)
+	}
+}
+
+// serveTransfers starts the transfer handler at the policy's listen address,
+// with the TLS certificates of the gRPC server (see tlsconfig).
+func serveTransfers(policy *domain.SignedURLPolicy, storage func(ctx context.Context, provider string) (domain.StorageService, error)) error {
+	cfg, err := tlsconfig.FromEnv("server")
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := cfg.HTTPServerConfig()
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", policy.Listen)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+		if strings.HasPrefix(policy.BaseURL, "http://") {
+			log.Printf("SIGNED_URL_BASE %s is not https but the transfer handler serves TLS", policy.BaseURL)
+		}
+	} else {
+		log.Printf("TLS is off: the transfer handler serves plain HTTP")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(domain.TransferPathPrefix, &transferHandler{policy: policy, storage: storage})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 30 * time.Second, TLSConfig: tlsConfig}
+	log.Printf("transfer handler listening at %v (signed URLs: %s, mode %s, TLS %s)", lis.Addr(), policy.BaseURL, policy.Mode, cfg.Mode)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("transfer handler stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stagedUploadSweepInterval is how often expired staged uploads are deleted.
+const stagedUploadSweepInterval = 10 * time.Minute
+
+// sweepStagedUploads deletes the staged objects of direct uploads that
+// expired without being finalized.
+func sweepStagedUploads(appService *application.ApplicationService) {
+	ticker := time.NewTicker(stagedUploadSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := appService.SweepStagedUploads(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Sweep of staged uploads failed: %v", err)
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d expired staged uploads", deleted)
+		}
+	}
+}
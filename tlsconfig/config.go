@@ -0,0 +1,211 @@
+// Package tlsconfig configures TLS and mutual TLS for the gRPC servers and
+// clients (webapp, API server, OCR services). Certificates are read from
+// files and reloaded when they are rotated; a dev mode creates a local CA.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Mode selects the transport security of a connection.
+type Mode string
+
+const (
+	// ModeOff uses plaintext connections.
+	ModeOff Mode = "off"
+	// ModeTLS encrypts connections and authenticates the server. Servers with
+	// a CA file also verify client certificates that are presented.
+	ModeTLS Mode = "tls"
+	// ModeMTLS requires and verifies a client certificate as well.
+	ModeMTLS Mode = "mtls"
+)
+
+// Config is the transport security of a server or client.
+type Config struct {
+	Mode     Mode
+	CertFile string // own certificate (PEM); may also hold the key
+	KeyFile  string // own private key (PEM)
+	CAFile   string // CA bundle verifying the peer; system roots for clients when empty
+	// ServerName overrides the name a client verifies the server certificate
+	// against; by default it is the host of the dialed address.
+	ServerName string
+}
+
+// FromEnv reads the configuration of a service:
+//
+//   - TLS_MODE: off (default), tls or mtls
+//   - TLS_CERT_FILE, TLS_KEY_FILE: certificate and key of the service
+//   - TLS_CA_FILE: CA bundle for verifying peers
+//   - TLS_SERVER_NAME: name clients verify server certificates against
+//   - TLS_DEV: create a local CA and a certificate for the service in
+//     TLS_DEV_DIR (default /app/tls) on first start. The certificate is
+//     issued to TLS_DEV_NAME (default service) and TLS_DEV_SANS
+//     (comma-separated). TLS_MODE defaults to mtls in dev mode.
+func FromEnv(service string) (Config, error) {
+	cfg := Config{
+		Mode:       Mode(strings.ToLower(strings.TrimSpace(os.Getenv("TLS_MODE")))),
+		CertFile:   os.Getenv("TLS_CERT_FILE"),
+		KeyFile:    os.Getenv("TLS_KEY_FILE"),
+		CAFile:     os.Getenv("TLS_CA_FILE"),
+		ServerName: os.Getenv("TLS_SERVER_NAME"),
+	}
+	if envEnabled("TLS_DEV") {
+		if cfg.Mode == "" {
+			cfg.Mode = ModeMTLS
+		}
+		dir := os.Getenv("TLS_DEV_DIR")
+		if dir == "" {
+			dir = "/app/tls"
+		}
+		name := os.Getenv("TLS_DEV_NAME")
+		if name == "" {
+			name = service
+		}
+		var sans []string
+		for _, san := range strings.Split(os.Getenv("TLS_DEV_SANS"), ",") {
+			if san = strings.TrimSpace(san); san != "" {
+				sans = append(sans, san)
+			}
+		}
+		dev, err := EnsureDevCertificates(dir, name, sans)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.CertFile, cfg.KeyFile, cfg.CAFile = dev.CertFile, dev.KeyFile, dev.CAFile
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeOff
+	}
+	return cfg, cfg.validate()
+}
+
+// envEnabled reports whether a boolean variable is set to a true value.
+func envEnabled(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+func (c Config) validate() error {
+	switch c.Mode {
+	case ModeOff, ModeTLS, ModeMTLS:
+	default:
+		return fmt.Errorf("invalid TLS_MODE %q (want off, tls or mtls)", c.Mode)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.Mode == ModeMTLS && c.CAFile == "" {
+		return fmt.Errorf("mtls requires TLS_CA_FILE")
+	}
+	return nil
+}
+
+// Enabled reports whether connections are encrypted.
+func (c Config) Enabled() bool {
+	return c.Mode == ModeTLS || c.Mode == ModeMTLS
+}
+
+// ServerCredentials returns the transport credentials of a gRPC server.
+func (c Config) ServerCredentials() (credentials.TransportCredentials, error) {
+	if !c.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.CertFile == "" {
+		return nil, fmt.Errorf("a TLS server requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	source, err := newSource(c)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingCredentials{source: source}, nil
+}
+
+// HTTPServerConfig returns the TLS config of an HTTP server of the service,
+// or nil when TLS is off. Certificates are reloaded like those of the gRPC
+// server. Browsers have no client certificate, so mtls only verifies the
+// certificates that are presented.
+func (c Config) HTTPServerConfig() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.CertFile == "" {
+		return nil, fmt.Errorf("a TLS server requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	source, err := newSource(c)
+	if err != nil {
+		return nil, err
+	}
+	creds := &reloadingCredentials{source: source}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := creds.serverConfig()
+			if cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}, nil
+}
+
+// ClientCredentials returns the transport credentials of a gRPC client.
+func (c Config) ClientCredentials() (credentials.TransportCredentials, error) {
+	if !c.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.Mode == ModeMTLS && c.CertFile == "" {
+		return nil, fmt.Errorf("an mtls client requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	source, err := newSource(c)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingCredentials{source: source, serverName: c.ServerName}, nil
+}
+
+// ServerOption returns the grpc.Creds option of a service configured from
+// the environment.
+func ServerOption(service string) (grpc.ServerOption, error) {
+	cfg, err := FromEnv(service)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := cfg.ServerCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Creds(creds), nil
+}
+
+// DialOption returns the transport credentials option of a client
+// configured from the environment.
+func DialOption(service string) (grpc.DialOption, error) {
+	cfg, err := FromEnv(service)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := cfg.ClientCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
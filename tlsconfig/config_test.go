@@ -0,0 +1,89 @@
+package tlsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	devDir := t.TempDir()
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		mode    Mode
+		wantErr bool
+	}{
+		{"off by default", nil, ModeOff, false},
+		{"tls without certificates", map[string]string{"TLS_MODE": "tls"}, ModeTLS, false},
+		{"tls with a certificate", map[string]string{"TLS_MODE": "TLS", "TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem"}, ModeTLS, false},
+		{"mtls with a CA", map[string]string{"TLS_MODE": "mtls", "TLS_CA_FILE": "ca.crt", "TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "c.pem"}, ModeMTLS, false},
+		{"unknown mode", map[string]string{"TLS_MODE": "ssl"}, "", true},
+		{"mtls without a CA", map[string]string{"TLS_MODE": "mtls", "TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem"}, "", true},
+		{"certificate without a key", map[string]string{"TLS_MODE": "tls", "TLS_CERT_FILE": "c.pem"}, "", true},
+		{"key without a certificate", map[string]string{"TLS_MODE": "tls", "TLS_KEY_FILE": "k.pem"}, "", true},
+		{"dev mode defaults to mtls", map[string]string{"TLS_DEV": "true", "TLS_DEV_DIR": devDir}, ModeMTLS, false},
+		{"dev mode with tls", map[string]string{"TLS_DEV": "1", "TLS_DEV_DIR": devDir, "TLS_MODE": "tls"}, ModeTLS, false},
+		{"dev mode with an invalid name", map[string]string{"TLS_DEV": "on", "TLS_DEV_DIR": devDir, "TLS_DEV_NAME": "../ca"}, "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"TLS_MODE", "TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_CA_FILE", "TLS_SERVER_NAME", "TLS_DEV", "TLS_DEV_DIR", "TLS_DEV_NAME", "TLS_DEV_SANS"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, err := FromEnv("ocr-service")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv = %+v, %v; want error %v", cfg, err, tt.wantErr)
+			}
+			if err == nil && cfg.Mode != tt.mode {
+				t.Fatalf("Mode = %q, want %q", cfg.Mode, tt.mode)
+			}
+		})
+	}
+
+	t.Run("dev mode issues the service certificate", func(t *testing.T) {
+		t.Setenv("TLS_MODE", "")
+		t.Setenv("TLS_DEV", "true")
+		t.Setenv("TLS_DEV_DIR", devDir)
+		t.Setenv("TLS_DEV_NAME", "")
+		t.Setenv("TLS_DEV_SANS", " ocr-easyocr , ,10.0.0.5")
+		cfg, err := FromEnv("ocr-service")
+		if err != nil {
+			t.Fatalf("FromEnv: %v", err)
+		}
+		if cfg.CertFile != filepath.Join(devDir, "ocr-service.pem") || cfg.KeyFile != cfg.CertFile || cfg.CAFile != filepath.Join(devDir, "ca.crt") {
+			t.Fatalf("dev config = %+v", cfg)
+		}
+		cert := readCertificate(t, cfg.CertFile)
+		if !hasDNSName(cert, "ocr-easyocr") || !hasIP(cert, "10.0.0.5") {
+			t.Fatalf("certificate names = %q %v, want the TLS_DEV_SANS", cert.DNSNames, cert.IPAddresses)
+		}
+	})
+}
+
+func TestCredentialsRequireCertificates(t *testing.T) {
+	dev, err := EnsureDevCertificates(t.TempDir(), "svc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Config{Mode: ModeTLS}).ServerCredentials(); err == nil {
+		t.Error("ServerCredentials without a certificate succeeded")
+	}
+	if _, err := (Config{Mode: ModeMTLS, CAFile: dev.CAFile}).ClientCredentials(); err == nil {
+		t.Error("mtls ClientCredentials without a certificate succeeded")
+	}
+	if _, err := (Config{Mode: ModeTLS, CertFile: filepath.Join(t.TempDir(), "missing.pem"), KeyFile: "missing.pem"}).ServerCredentials(); err == nil {
+		t.Error("ServerCredentials with a missing certificate succeeded")
+	}
+	if _, err := (Config{Mode: ModeOff}).ServerCredentials(); err != nil {
+		t.Errorf("plaintext ServerCredentials: %v", err)
+	}
+	if cfg, err := (Config{Mode: ModeOff}).HTTPServerConfig(); cfg != nil || err != nil {
+		t.Errorf("plaintext HTTPServerConfig = %v, %v; want nil", cfg, err)
+	}
+	if _, err := os.Stat(dev.CertFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Config{Mode: ModeTLS, CertFile: dev.CertFile, KeyFile: dev.KeyFile, CAFile: dev.CAFile}).ClientCredentials(); err != nil {
+		t.Errorf("ClientCredentials: %v", err)
+	}
+}
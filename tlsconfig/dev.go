@@ -0,0 +1,297 @@
+package tlsconfig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	devCALifetime   = 10 * 365 * 24 * time.Hour
+	devCertLifetime = 90 * 24 * time.Hour
+	// devCertRenewal is how long before expiry a dev certificate is reissued.
+	devCertRenewal = 30 * 24 * time.Hour
+)
+
+// EnsureDevCertificates sets up development certificates in dir: a CA
+// (ca.pem with its key, ca.crt without) created by the first service to
+// start, and <name>.pem holding a certificate and key for name signed by it.
+// The certificate is valid for client and server use and names name,
+// localhost, the loopback addresses, the host name and sans (DNS names, IPs
+// or URIs). It is reissued when it is missing, due for renewal or does not
+// match the CA or the names.
+func EnsureDevCertificates(dir string, name string, sans []string) (Config, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return Config{}, fmt.Errorf("invalid dev certificate name %q", name)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Config{}, fmt.Errorf("failed to create TLS_DEV_DIR: %w", err)
+	}
+	caCert, caKey, err := loadOrCreateDevCA(dir)
+	if err != nil {
+		return Config{}, err
+	}
+
+	names := []string{name, "localhost", "127.0.0.1", "::1"}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		names = append(names, host)
+	}
+	names = append(names, sans...)
+
+	certPath := filepath.Join(dir, name+".pem")
+	cfg := Config{CertFile: certPath, KeyFile: certPath, CAFile: filepath.Join(dir, "ca.crt")}
+	if devCertUsable(certPath, caCert, names) {
+		return cfg, nil
+	}
+	leaf, err := issueDevCertificate(caCert, caKey, name, names)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := writeFileAtomic(certPath, leaf, 0o600); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadOrCreateDevCA returns the CA of dir, creating it if needed. Services
+// starting together race for it: the CA is linked into place, so exactly one
+// of them creates it and the others load it.
+func loadOrCreateDevCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	path := filepath.Join(dir, "ca.pem")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = createDevCA(dir, path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up the dev CA: %w", err)
+	}
+	cert, key, err := parseCertAndKey(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dev CA %s: %w", path, err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := writeFileAtomic(filepath.Join(dir, "ca.crt"), certPEM, 0o644); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func createDevCA(dir string, path string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "grpc-sample-minimal dev CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodeCertAndKey(der, key)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".ca-*.pem")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return os.ReadFile(path)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// devCertUsable reports whether the certificate at path can be kept.
+func devCertUsable(path string, caCert *x509.Certificate, names []string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	cert, _, err := parseCertAndKey(data)
+	if err != nil || time.Until(cert.NotAfter) < devCertRenewal || cert.CheckSignatureFrom(caCert) != nil {
+		return false
+	}
+	dns, ips, uris := splitSANs(names)
+	have := make(map[string]bool)
+	for _, n := range cert.DNSNames {
+		have[n] = true
+	}
+	for _, ip := range cert.IPAddresses {
+		have[ip.String()] = true
+	}
+	for _, u := range cert.URIs {
+		have[u.String()] = true
+	}
+	for _, n := range dns {
+		if !have[n] {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if !have[ip.String()] {
+			return false
+		}
+	}
+	for _, u := range uris {
+		if !have[u.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+func issueDevCertificate(caCert *x509.Certificate, caKey crypto.Signer, name string, names []string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	template.DNSNames, template.IPAddresses, template.URIs = splitSANs(names)
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue dev certificate for %s: %w", name, err)
+	}
+	return encodeCertAndKey(der, key)
+}
+
+// splitSANs sorts names into DNS names, IP addresses and URIs.
+func splitSANs(names []string) ([]string, []net.IP, []*url.URL) {
+	var dns []string
+	var ips []net.IP
+	var uris []*url.URL
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		if ip := net.ParseIP(n); ip != nil {
+			ips = append(ips, ip)
+		} else if u, err := url.Parse(n); err == nil && strings.Contains(n, "://") {
+			uris = append(uris, u)
+		} else {
+			dns = append(dns, n)
+		}
+	}
+	return dns, ips, uris
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeCertAndKey(der []byte, key *ecdsa.PrivateKey) ([]byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+func parseCertAndKey(data []byte) (*x509.Certificate, crypto.Signer, error) {
+	var cert *x509.Certificate
+	var key crypto.Signer
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert == nil {
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, err
+				}
+				cert = c
+			}
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			signer, ok := k.(crypto.Signer)
+			if !ok {
+				return nil, nil, fmt.Errorf("unsupported private key type %T", k)
+			}
+			key = signer
+		}
+	}
+	if cert == nil || key == nil {
+		return nil, nil, fmt.Errorf("certificate and private key required")
+	}
+	return cert, key, nil
+}
+
+// writeFileAtomic replaces path, so a reader never sees a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
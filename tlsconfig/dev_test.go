@@ -0,0 +1,149 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readCertificate(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := parseCertAndKey(data)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return cert
+}
+
+func hasDNSName(cert *x509.Certificate, name string) bool {
+	for _, n := range cert.DNSNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIP(cert *x509.Certificate, ip string) bool {
+	for _, a := range cert.IPAddresses {
+		if a.Equal(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDevCertificate checks a certificate against the CA bundle of dir.
+func verifyDevCertificate(t *testing.T, cfg Config) *x509.Certificate {
+	t.Helper()
+	caPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("no certificates in %s", cfg.CAFile)
+	}
+	cert := readCertificate(t, cfg.CertFile)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Fatalf("certificate does not verify for %v: %v", usage, err)
+		}
+	}
+	return cert
+}
+
+func TestEnsureDevCertificates(t *testing.T) {
+	dir := t.TempDir()
+	sans := []string{"ocr-service.internal", "10.0.0.5", "spiffe://acme/ocr"}
+	cfg, err := EnsureDevCertificates(dir, "ocr-service", sans)
+	if err != nil {
+		t.Fatalf("EnsureDevCertificates: %v", err)
+	}
+	cert := verifyDevCertificate(t, cfg)
+	if cert.Subject.CommonName != "ocr-service" {
+		t.Errorf("CommonName = %q, want ocr-service", cert.Subject.CommonName)
+	}
+	for _, name := range []string{"ocr-service", "localhost", "ocr-service.internal"} {
+		if !hasDNSName(cert, name) {
+			t.Errorf("DNS names %q lack %s", cert.DNSNames, name)
+		}
+	}
+	for _, ip := range []string{"127.0.0.1", "::1", "10.0.0.5"} {
+		if !hasIP(cert, ip) {
+			t.Errorf("IP addresses %v lack %s", cert.IPAddresses, ip)
+		}
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://acme/ocr" {
+		t.Errorf("URIs = %v, want spiffe://acme/ocr", cert.URIs)
+	}
+	if info, err := os.Stat(cfg.CertFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("certificate file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	ca, leaf := read("ca.pem"), read("ocr-service.pem")
+
+	t.Run("idempotent", func(t *testing.T) {
+		again, err := EnsureDevCertificates(dir, "ocr-service", sans)
+		if err != nil || again != cfg {
+			t.Fatalf("EnsureDevCertificates = %+v, %v; want %+v", again, err, cfg)
+		}
+		if !bytes.Equal(read("ca.pem"), ca) || !bytes.Equal(read("ocr-service.pem"), leaf) {
+			t.Fatal("a second call replaced the CA or the certificate")
+		}
+	})
+
+	t.Run("other services share the CA", func(t *testing.T) {
+		other, err := EnsureDevCertificates(dir, "server", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyDevCertificate(t, other)
+		if !bytes.Equal(read("ca.pem"), ca) {
+			t.Fatal("the CA was replaced")
+		}
+	})
+
+	t.Run("new names reissue the certificate", func(t *testing.T) {
+		if _, err := EnsureDevCertificates(dir, "ocr-service", append(sans, "ocr.example")); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(read("ocr-service.pem"), leaf) {
+			t.Fatal("the certificate was not reissued")
+		}
+		if cert := verifyDevCertificate(t, cfg); !hasDNSName(cert, "ocr.example") || !hasDNSName(cert, "ocr-service.internal") {
+			t.Fatalf("DNS names = %q", cert.DNSNames)
+		}
+	})
+
+	t.Run("a new CA reissues the certificate", func(t *testing.T) {
+		os.Remove(filepath.Join(dir, "ca.pem"))
+		if _, err := EnsureDevCertificates(dir, "ocr-service", sans); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(read("ca.pem"), ca) {
+			t.Fatal("the CA was not recreated")
+		}
+		verifyDevCertificate(t, cfg)
+	})
+
+	for _, name := range []string{"", "a/b", `a\b`} {
+		if _, err := EnsureDevCertificates(dir, name, nil); err == nil {
+			t.Errorf("EnsureDevCertificates(%q) succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// checkInterval limits how often the certificate files are checked for changes.
+const checkInterval = 2 * time.Second
+
+// source holds the certificate and CA pool of a Config, reloading them when
+// their files change. A broken rotation keeps the previous files in force.
+type source struct {
+	cfg Config
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	stamps  []time.Time
+	checked time.Time
+}
+
+func newSource(cfg Config) (*source, error) {
+	s := &source{cfg: cfg}
+	if _, err := s.reload(time.Now(), true); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *source) files() []string {
+	var files []string
+	for _, f := range []string{s.cfg.CertFile, s.cfg.KeyFile, s.cfg.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// reload re-reads the files when one of them changed. Failed loads are
+// retried on the next check, as cert and key are often replaced one by one.
+func (s *source) reload(now time.Time, force bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !force && now.Sub(s.checked) < checkInterval {
+		return false, nil
+	}
+	s.checked = now
+	var stamps []time.Time
+	changed := force
+	for i, f := range s.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		stamps = append(stamps, info.ModTime())
+		if i >= len(s.stamps) || !info.ModTime().Equal(s.stamps[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if s.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load certificate %s: %w", s.cfg.CertFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if s.cfg.CAFile != "" {
+		data, err := os.ReadFile(s.cfg.CAFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("no certificates in CA file %s", s.cfg.CAFile)
+		}
+	}
+	s.cert, s.pool, s.stamps = cert, pool, stamps
+	return true, nil
+}
+
+// current returns the certificate and CA pool, reloading them if needed.
+func (s *source) current() (*tls.Certificate, *x509.CertPool) {
+	reloaded, err := s.reload(time.Now(), false)
+	if err != nil {
+		log.Printf("TLS: keeping the previous certificates: %v", err)
+	} else if reloaded {
+		log.Printf("TLS: reloaded certificates (cert=%s ca=%s)", s.cfg.CertFile, s.cfg.CAFile)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cert, s.pool
+}
+
+// reloadingCredentials builds the TLS config of every handshake from the
+// current files, so rotated certificates apply to new connections without
+// a restart.
+type reloadingCredentials struct {
+	source     *source
+	serverName string
+}
+
+func (c *reloadingCredentials) serverConfig() *tls.Config {
+	cert, pool := c.source.current()
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+		ClientCAs:    pool,
+	}
+	switch {
+	case c.source.cfg.Mode == ModeMTLS:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case pool != nil:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+func (c *reloadingCredentials) clientConfig() *tls.Config {
+	cert, pool := c.source.current()
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		ServerName: c.serverName,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if c.serverName != "" {
+		authority = c.serverName
+	}
+	return credentials.NewTLS(c.clientConfig()).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.serverConfig()).ServerHandshake(conn)
+}
+
+func (c *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2", ServerName: c.serverName}
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *reloadingCredentials) OverrideServerName(name string) error {
+	c.serverName = name
+	return nil
+}
@@ -0,0 +1,206 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshake connects client to server over loopback and returns the
+// certificates each side saw, or the error of each side.
+func handshake(t *testing.T, server, client credentials.TransportCredentials) (serverSaw, clientSaw *x509.Certificate, serverErr, clientErr error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type result struct {
+		info credentials.AuthInfo
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, info, err := server.ServerHandshake(conn)
+		if err == nil {
+			// Wait for the client to finish, so its errors are not ours
+			conn.Read(make([]byte, 1))
+		}
+		accepted <- result{info, err}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, info, clientErr := client.ClientHandshake(ctx, "localhost", conn)
+	if clientErr == nil {
+		if state := info.(credentials.TLSInfo).State; len(state.PeerCertificates) > 0 {
+			clientSaw = state.PeerCertificates[0]
+		}
+	}
+	conn.Close()
+
+	r := <-accepted
+	if r.err == nil {
+		if state := r.info.(credentials.TLSInfo).State; len(state.PeerCertificates) > 0 {
+			serverSaw = state.PeerCertificates[0]
+		}
+	}
+	return serverSaw, clientSaw, r.err, clientErr
+}
+
+func devConfig(t *testing.T, dir string, name string, mode Mode) Config {
+	t.Helper()
+	cfg, err := EnsureDevCertificates(dir, name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Mode = mode
+	return cfg
+}
+
+func TestMutualTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	serverCreds, err := devConfig(t, dir, "server", ModeMTLS).ServerCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert, err := devConfig(t, dir, "webapp", ModeMTLS).ClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutCert, err := Config{Mode: ModeTLS, CAFile: filepath.Join(dir, "ca.crt")}.ClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverSaw, clientSaw, serverErr, clientErr := handshake(t, serverCreds, withCert)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake with a client certificate: server %v, client %v", serverErr, clientErr)
+	}
+	if serverSaw == nil || serverSaw.Subject.CommonName != "webapp" || clientSaw.Subject.CommonName != "server" {
+		t.Fatalf("peers = %v and %v, want webapp and server", serverSaw, clientSaw)
+	}
+
+	if _, _, serverErr, _ := handshake(t, serverCreds, withoutCert); serverErr == nil {
+		t.Fatal("mtls server accepted a client without a certificate")
+	}
+
+	// A tls server with a CA verifies only certificates that are presented
+	tlsServer, err := devConfig(t, dir, "server", ModeTLS).ServerCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, serverErr, clientErr := handshake(t, tlsServer, withoutCert); serverErr != nil || clientErr != nil {
+		t.Fatalf("tls handshake without a client certificate: server %v, client %v", serverErr, clientErr)
+	}
+
+	// A client certificate of another CA is rejected
+	foreign, err := devConfig(t, t.TempDir(), "webapp", ModeMTLS).ClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, serverErr, _ := handshake(t, serverCreds, foreign); serverErr == nil {
+		t.Fatal("mtls server accepted a certificate of another CA")
+	}
+}
+
+func TestRotatedCertificatesAreReloaded(t *testing.T) {
+	dir := t.TempDir()
+	cfg := devConfig(t, dir, "server", ModeTLS)
+	serverCreds, err := cfg.ServerCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Config{Mode: ModeTLS, CAFile: cfg.CAFile}.ClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := serverCreds.(*reloadingCredentials).source
+	// recheck lets the next handshake look at the files again
+	recheck := func() {
+		source.mu.Lock()
+		source.checked = time.Time{}
+		source.mu.Unlock()
+	}
+
+	_, before, _, err := handshake(t, serverCreds, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate the certificate in place, as a certificate manager would
+	caData, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, caKey, err := parseCertAndKey(caData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := issueDevCertificate(caCert, caKey, "server", []string{"server", "localhost", "rotated.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(cfg.CertFile, rotated, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.CertFile, future, future)
+
+	// Within the check interval the previous certificate is still served
+	if _, same, _, err := handshake(t, serverCreds, client); err != nil || !same.Equal(before) {
+		t.Fatalf("certificate changed before the check interval (err %v)", err)
+	}
+	recheck()
+	_, after, _, err := handshake(t, serverCreds, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Equal(before) || !hasDNSName(after, "rotated.example") {
+		t.Fatalf("server still presents the certificate from before the rotation: %q", after.DNSNames)
+	}
+
+	// A broken rotation keeps the certificate in force
+	if err := os.WriteFile(cfg.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	os.Chtimes(cfg.CertFile, later, later)
+	recheck()
+	if _, kept, _, err := handshake(t, serverCreds, client); err != nil || !kept.Equal(after) {
+		t.Fatalf("broken rotation: err %v, want the rotated certificate kept", err)
+	}
+}
+
+func TestHTTPServerConfigRelaxesMutualTLS(t *testing.T) {
+	cfg, err := devConfig(t, t.TempDir(), "server", ModeMTLS).HTTPServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ClientAuth != tls.VerifyClientCertIfGiven || len(got.Certificates) != 1 {
+		t.Fatalf("ClientAuth = %v with %d certificates, want VerifyClientCertIfGiven", got.ClientAuth, len(got.Certificates))
+	}
+}
@@ -27,11 +27,42 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	// variant=searchable serves the searchable PDF of the file, generating it
+	// first if there is no up-to-date cached copy.
+	variant := r.URL.Query().Get("variant")
+	engineName := r.URL.Query().Get("engineName")
+	downloadName := filename
+	if variant == "searchable" {
+		exportCtx, exportCancel := context.WithTimeout(r.Context(), 5*time.Minute)
+		exportCtx = metadata.AppendToOutgoingContext(exportCtx, "authorization", GetAuthToken())
+		resp, err := c.ExportSearchablePDF(exportCtx, &pb.SearchablePDFRequest{
+			Filename:        filename,
+			StorageProvider: provider,
+			EngineName:      engineName,
+		})
+		exportCancel()
+		if err != nil {
+			WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if resp.Status == "not_found" {
+			WriteJSONError(w, "OCR result not found", http.StatusNotFound)
+			return
+		}
+		engineName = resp.EngineName
+		downloadName = resp.DownloadFilename
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
 	defer cancel()
 	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
 
-	stream, err := c.DownloadFile(ctx, &pb.FileDownloadRequest{Filename: filename, StorageProvider: provider})
+	stream, err := c.DownloadFile(ctx, &pb.FileDownloadRequest{
+		Filename:        filename,
+		StorageProvider: provider,
+		Variant:         variant,
+		EngineName:      engineName,
+	})
 	if err != nil {
 		WriteJSONError(w, "Failed to open download stream", http.StatusInternalServerError)
 		return
@@ -39,7 +70,9 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Set appropriate Content-Type based on file extension
 	contentType := "application/octet-stream"
-	if preview {
+	if variant == "searchable" {
+		contentType = "application/pdf"
+	} else if preview {
 		// Determine content type for preview
 		if len(filename) > 4 {
 			ext := filename[len(filename)-4:]
@@ -68,9 +101,9 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Set Content-Disposition: inline for preview, attachment for download
 	if preview {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", downloadName))
 	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", downloadName))
 	}
 
 	for {
@@ -87,3 +120,4 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	pb "grpc-sample-minimal/proto"
+)
+
+// ExportSearchablePDFHandler generates (or reuses) the searchable PDF of a file.
+// The PDF is then served by /api/download-file?variant=searchable.
+func ExportSearchablePDFHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Filename        string `json:"filename"`
+		StorageProvider string `json:"storage_provider"`
+		EngineName      string `json:"engine_name"`
+		Force           bool   `json:"force"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Filename == "" {
+		WriteJSONError(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.StorageProvider == "" {
+		req.StorageProvider = "azure" // Default for Phase 1
+	}
+
+	// Rendering and re-encoding every page can take a while for long PDFs
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, conn, err := GetGrpcClient(ctx)
+	if err != nil {
+		WriteJSONError(w, "Failed to connect to gRPC server", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// Add auth token to metadata
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
+
+	resp, err := client.ExportSearchablePDF(ctx, &pb.SearchablePDFRequest{
+		Filename:        req.Filename,
+		StorageProvider: req.StorageProvider,
+		EngineName:      req.EngineName,
+		Force:           req.Force,
+	})
+	if err != nil {
+		WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, resp)
+}
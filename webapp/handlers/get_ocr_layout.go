@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	pb "grpc-sample-minimal/proto"
+)
+
+// GetOCRLayoutHandler returns the word-level layout of an OCR result as the raw
+// JSON, hOCR or ALTO document.
+func GetOCRLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	storageProvider := r.URL.Query().Get("storageProvider")
+	engineName := r.URL.Query().Get("engineName")
+	format := r.URL.Query().Get("format")
+
+	if filename == "" {
+		WriteJSONError(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	if storageProvider == "" {
+		storageProvider = "azure" // Default for Phase 1
+	}
+
+	if engineName == "" {
+		engineName = "tesseract" // Default
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, conn, err := GetGrpcClient(ctx)
+	if err != nil {
+		WriteJSONError(w, "Failed to connect to gRPC server", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// Add auth token to metadata
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
+
+	resp, err := client.GetOCRLayout(ctx, &pb.OCRLayoutRequest{
+		Filename:        filename,
+		StorageProvider: storageProvider,
+		EngineName:      engineName,
+		Format:          format,
+	})
+	if err != nil {
+		WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if resp.Status == "not_found" {
+		WriteJSONError(w, "OCR layout not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", resp.ContentType+"; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Content)))
+	w.Write(resp.Content)
+}
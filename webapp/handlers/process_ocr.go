@@ -17,8 +17,9 @@ func ProcessOCRHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Filename        string `json:"filename"`
-		StorageProvider string `json:"storage_provider"`
+		Filename        string   `json:"filename"`
+		StorageProvider string   `json:"storage_provider"`
+		Languages       []string `json:"languages"` // optional, e.g. ["ko", "en"]; detected when empty
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -51,6 +52,7 @@ func ProcessOCRHandler(w http.ResponseWriter, r *http.Request) {
 	ocrReq := &pb.OCRRequest{
 		Filename:        req.Filename,
 		StorageProvider: req.StorageProvider,
+		Languages:       req.Languages,
 	}
 
 	resp, err := client.ProcessOCR(ctx, ocrReq)
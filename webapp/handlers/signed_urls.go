@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	pb "grpc-sample-minimal/proto"
+)
+
+// CreateUploadURLHandler returns a signed URL the browser uploads a file to
+// directly, and the upload ID to finalize it with.
+func CreateUploadURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Filename         string `json:"filename"`
+		StorageProvider  string `json:"storage_provider"`
+		ContentType      string `json:"content_type"`
+		Size             int64  `json:"size"`
+		ExpiresInSeconds int64  `json:"expires_in_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Filename == "" {
+		WriteJSONError(w, "filename is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	client, conn, err := GetGrpcClient(ctx)
+	if err != nil {
+		WriteJSONError(w, "Failed to connect to gRPC server", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
+	resp, err := client.CreateUploadURL(ctx, &pb.CreateUploadURLRequest{
+		Filename:         req.Filename,
+		StorageProvider:  req.StorageProvider,
+		ContentType:      req.ContentType,
+		Size:             req.Size,
+		ExpiresInSeconds: req.ExpiresInSeconds,
+	})
+	if err != nil {
+		WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, resp)
+}
+
+// FinalizeUploadHandler registers a file the browser uploaded to a signed URL.
+func FinalizeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UploadID        string `json:"upload_id"`
+		Filename        string `json:"filename"`
+		StorageProvider string `json:"storage_provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UploadID == "" || req.Filename == "" {
+		WriteJSONError(w, "upload_id and filename are required", http.StatusBadRequest)
+		return
+	}
+
+	// Finalizing reads the uploaded object and may scan it
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	client, conn, err := GetGrpcClient(ctx)
+	if err != nil {
+		WriteJSONError(w, "Failed to connect to gRPC server", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
+	resp, err := client.FinalizeUpload(ctx, &pb.FinalizeUploadRequest{
+		UploadId:        req.UploadID,
+		Filename:        req.Filename,
+		StorageProvider: req.StorageProvider,
+	})
+	if err != nil {
+		WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, resp)
+}
+
+// CreateDownloadURLHandler returns a signed URL the browser downloads a file
+// from directly.
+func CreateDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		WriteJSONError(w, "Filename is required", http.StatusBadRequest)
+		return
+	}
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expiresInSeconds"), 10, 64)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	client, conn, err := GetGrpcClient(ctx)
+	if err != nil {
+		WriteJSONError(w, "Failed to connect to gRPC server", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", GetAuthToken())
+	resp, err := client.CreateDownloadURL(ctx, &pb.CreateDownloadURLRequest{
+		Filename:         filename,
+		StorageProvider:  r.URL.Query().Get("storageProvider"),
+		Variant:          r.URL.Query().Get("variant"),
+		EngineName:       r.URL.Query().Get("engineName"),
+		ExpiresInSeconds: expires,
+	})
+	if err != nil {
+		WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, resp)
+}
@@ -9,8 +9,8 @@ import (
 	"os"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	pb "grpc-sample-minimal/proto"
+	"grpc-sample-minimal/tlsconfig"
 )
 
 const (
@@ -23,8 +23,21 @@ var (
 
 func GetAuthToken() string { return authToken }
 
+// transportCredentials is set up once, as dev mode may issue a certificate.
+var (
+	transportOnce  sync.Once
+	transportCreds grpc.DialOption
+	transportErr   error
+)
+
 func GetGrpcClient(ctx context.Context) (pb.GreeterClient, *grpc.ClientConn, error) {
-	conn, err := grpc.Dial(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	transportOnce.Do(func() {
+		transportCreds, transportErr = tlsconfig.DialOption("webapp")
+	})
+	if transportErr != nil {
+		return nil, nil, fmt.Errorf("invalid TLS configuration: %w", transportErr)
+	}
+	conn, err := grpc.Dial(grpcAddress, transportCreds)
 	if err != nil {
 		return nil, nil, fmt.Errorf("did not connect to gRPC server: %w", err)
 	}
@@ -48,6 +48,12 @@ func main() {
 	http.HandleFunc("/api/get-ocr-result", handlers.GetOCRResultHandler)
 	http.HandleFunc("/api/list-ocr-results", handlers.ListOCRResultsHandler)
 	http.HandleFunc("/api/compare-ocr-results", handlers.CompareOCRResultsHandler)
+	http.HandleFunc("/api/get-ocr-layout", handlers.GetOCRLayoutHandler)
+	http.HandleFunc("/api/export-searchable-pdf", handlers.ExportSearchablePDFHandler)
+	// Direct transfers with signed URLs
+	http.HandleFunc("/api/create-upload-url", handlers.CreateUploadURLHandler)
+	http.HandleFunc("/api/finalize-upload", handlers.FinalizeUploadHandler)
+	http.HandleFunc("/api/create-download-url", handlers.CreateDownloadURLHandler)
 
     log.Printf("Web server listening on port %s", webPort)
     log.Fatal(http.ListenAndServe(webPort, nil))
@@ -229,4 +229,72 @@ export const compareOCRResultsService = async (filename: string, storageProvider
     throw new Error(`Failed to compare OCR results: ${response.statusText}`);
   }
   return response.json();
-};
\ No newline at end of file
+};
+export interface SignedURLResponse {
+  url: string;
+  method: string;
+  headers?: Record<string, string>;
+  expires_at: number;
+  upload_id?: string;
+  direct?: boolean;
+}
+
+const postJSON = async <T>(path: string, body: unknown): Promise<T> => {
+  const response = await fetch(`${API_BASE_URL}${path}`, {
+    method: 'POST',
+    headers,
+    body: JSON.stringify(body),
+  });
+  if (!response.ok) {
+    const errorJson = await response.json().catch(() => ({}));
+    throw new Error(errorJson.error || `HTTP ${response.status}: ${response.statusText}`);
+  }
+  return response.json();
+};
+
+// Uploads a file straight to storage with a signed URL, then finalizes it so
+// it is registered and queued for OCR.
+export const directUploadService = async (file: File, storageProvider: string): Promise<FileUploadStatus> => {
+  const signed = await postJSON<SignedURLResponse>('/api/create-upload-url', {
+    filename: file.name,
+    storage_provider: storageProvider,
+    content_type: file.type,
+    size: file.size,
+  });
+
+  const uploadHeaders: Record<string, string> = { ...(signed.headers || {}) };
+  if (file.type && !uploadHeaders['Content-Type']) {
+    uploadHeaders['Content-Type'] = file.type;
+  }
+  const upload = await fetch(signed.url, { method: signed.method, headers: uploadHeaders, body: file });
+  if (!upload.ok) {
+    throw new Error(`Upload to storage failed: HTTP ${upload.status} ${await upload.text()}`);
+  }
+
+  const data = await postJSON<any>('/api/finalize-upload', {
+    upload_id: signed.upload_id,
+    filename: file.name,
+    storage_provider: storageProvider,
+  });
+  return {
+    filename: data.filename || file.name,
+    bytesWritten: String(data.bytes_written || file.size),
+    success: Boolean(data.success),
+    message: data.message || 'File uploaded successfully',
+    storageProvider: data.storage_provider || storageProvider,
+  };
+};
+
+// Returns a signed URL the browser downloads the file from directly.
+export const createDownloadURLService = async (filename: string, storageProvider: string): Promise<SignedURLResponse> => {
+  const response = await fetch(`${API_BASE_URL}/api/create-download-url?filename=${encodeURIComponent(filename)}&storageProvider=${encodeURIComponent(storageProvider)}`, {
+    headers: {
+      'Authorization': AUTH_TOKEN,
+    },
+  });
+  if (!response.ok) {
+    const errorJson = await response.json().catch(() => ({}));
+    throw new Error(errorJson.error || `HTTP ${response.status}: ${response.statusText}`);
+  }
+  return response.json();
+};